@@ -9,15 +9,47 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
 
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/matrix"
 	"github.com/ecirlabs/matrix-core/internal/node"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
 	// Parse command line flags
 	initMode := flag.Bool("init", false, "Initialize a new node")
 	configPath := flag.String("config", "config.yaml", "Path to config file")
+	exportID := flag.String("export", "", "Export the journal of a matrix and exit")
+	exportFormat := flag.String("format", "csv", "Export format: csv, parquet, gexf, or graphml")
+	exportOut := flag.String("out", "", "Export file (default stdout)")
+	inspectDir := flag.String("inspect-data", "", "Summarize a data directory read-only and exit")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "List the storage migrations the node would run at startup and exit")
 	flag.Parse()
+	// "matrixd dev" runs the node with in-memory storage
+	dev := flag.Arg(0) == "dev"
+
+	if *exportID != "" {
+		if err := export(*configPath, *exportID, matrix.ExportFormat(*exportFormat), *exportOut); err != nil {
+			log.Fatalf("Failed to export matrix: %v", err)
+		}
+		return
+	}
+
+	if *inspectDir != "" {
+		if err := inspectData(*inspectDir); err != nil {
+			log.Fatalf("Failed to inspect data: %v", err)
+		}
+		return
+	}
+
+	if *migrateDryRun {
+		if err := dryRunMigrations(*configPath); err != nil {
+			log.Fatalf("Failed to dry-run migrations: %v", err)
+		}
+		return
+	}
 
 	if *initMode {
 		if err := node.Initialize(*configPath); err != nil {
@@ -36,6 +68,9 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create node: %v", err)
 	}
+	if dev {
+		n.UseMemoryStorage()
+	}
 
 	// Start the node
 	if err := n.Start(); err != nil {
@@ -55,3 +90,100 @@ func main() {
 		log.Printf("Error during shutdown: %v", err)
 	}
 }
+
+// export writes a matrix's journal from the node's store
+func export(configPath, matrixID string, format matrix.ExportFormat, outPath string) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Read-only, so a running node's store is read through a copy
+	store, err := kv.New(kv.Config{Engine: config.Storage.Engine, Path: config.Storage.Path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	journal, err := matrix.NewJournal(store, matrixID)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer out.Close()
+	}
+	return matrix.ExportJournal(out, format, journal, matrix.ExportOptions{})
+}
+
+// inspectData prints the buckets and engine state of a data directory
+// without changing it, such as one left by a crashed node
+func inspectData(dir string) error {
+	store, err := kv.New(kv.Config{Path: dir, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if stats, ok := store.EngineStats(); ok {
+		fmt.Printf("Disk usage: %d bytes, WAL %d bytes, read amplification %d\n", stats.DiskUsage, stats.WALSize, stats.ReadAmp)
+	}
+	names, err := store.Buckets()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tKEYS\tBYTES\tDISK")
+	for _, name := range names {
+		b := store.Bucket(name)
+		stats, err := b.Stats()
+		if err != nil {
+			return err
+		}
+		disk, err := b.DiskUsage()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", name, stats.Keys, stats.Bytes, disk)
+	}
+	return w.Flush()
+}
+
+// dryRunMigrations runs the node store's pending migrations without
+// committing them and prints what they would write
+func dryRunMigrations(configPath string) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	store, err := kv.New(kv.Config{Engine: config.Storage.Engine, Path: config.Storage.Path, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	results, err := store.Migrate(kv.MigrateOptions{DryRun: true})
+	for _, m := range results {
+		fmt.Printf("%s: version %d, %d writes: %s\n", m.Bucket, m.Version, m.Writes, m.Description)
+	}
+	if err == nil && len(results) == 0 {
+		fmt.Println("No migrations pending")
+	}
+	return err
+}
+
+// loadConfig reads the node config at path
+func loadConfig(path string) (node.Config, error) {
+	var config node.Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return config, nil
+}
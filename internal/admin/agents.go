@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+)
+
+// AgentSource reports on the node's running agents
+type AgentSource interface {
+	AgentStats() []agent.Stats
+	AgentOutput(id string) (agent.Output, bool)
+	AgentDebugger(id string) (*agent.Debugger, bool)
+	AgentUsage(from, to time.Time, id string) agent.UsageReport
+}
+
+// AgentsService exposes information about running agents
+type AgentsService struct {
+	source AgentSource
+	mu     sync.RWMutex
+	auth   *Authenticator
+}
+
+// NewAgentsService creates a new agents service
+func NewAgentsService(auth *Authenticator) *AgentsService {
+	return &AgentsService{
+		auth: auth,
+	}
+}
+
+// SetSource sets where agent information is read from
+func (s *AgentsService) SetSource(source AgentSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source = source
+}
+
+// GetAgentStats returns resource usage for one agent, or for all agents
+// sorted by execution time when id is empty
+func (s *AgentsService) GetAgentStats(ctx context.Context, id string) ([]agent.Stats, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionReadAgents); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+	if source == nil {
+		return nil, nil
+	}
+
+	stats := source.AgentStats()
+	if id == "" {
+		sort.Slice(stats, func(i, j int) bool {
+			return stats[i].ExecTime > stats[j].ExecTime
+		})
+		return stats, nil
+	}
+
+	for _, st := range stats {
+		if st.ID == id {
+			return []agent.Stats{st}, nil
+		}
+	}
+	return nil, fmt.Errorf("agent %s not found", id)
+}
+
+// GetAgentOutput returns the most recent stdout and stderr of an agent
+func (s *AgentsService) GetAgentOutput(ctx context.Context, id string) (agent.Output, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionReadAgents); err != nil {
+			return agent.Output{}, err
+		}
+	}
+
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+	if source == nil {
+		return agent.Output{}, fmt.Errorf("agent %s not found", id)
+	}
+
+	output, ok := source.AgentOutput(id)
+	if !ok {
+		return agent.Output{}, fmt.Errorf("agent %s not found", id)
+	}
+	return output, nil
+}
+
+// GetAgentUsage returns fuel and invocation totals per agent and window
+// for windows starting in [from, to); an empty id reports every agent
+func (s *AgentsService) GetAgentUsage(ctx context.Context, id string, from, to time.Time) (agent.UsageReport, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionReadAgents); err != nil {
+			return agent.UsageReport{}, err
+		}
+	}
+	if !from.Before(to) {
+		return agent.UsageReport{}, fmt.Errorf("usage period must end after it starts")
+	}
+
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+	if source == nil {
+		return agent.UsageReport{From: from, To: to}, nil
+	}
+	return source.AgentUsage(from, to, id), nil
+}
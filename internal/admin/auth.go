@@ -42,6 +42,10 @@ const (
 	PermissionRemoveDeploy Permission = "deploy:remove"
 	PermissionReadLogs     Permission = "logs:read"
 	PermissionReadSensitive Permission = "logs:sensitive"
+	PermissionReadAgents   Permission = "agents:read"
+	PermissionDebugAgents  Permission = "agents:debug"
+	PermissionReadMatrices Permission = "matrices:read"
+	PermissionManageStorage Permission = "storage:manage"
 )
 
 // rolePermissions maps roles to their permissions
@@ -53,6 +57,10 @@ var rolePermissions = map[Role][]Permission{
 		PermissionRemoveDeploy,
 		PermissionReadLogs,
 		PermissionReadSensitive,
+		PermissionReadAgents,
+		PermissionDebugAgents,
+		PermissionReadMatrices,
+		PermissionManageStorage,
 	},
 	RoleOperator: {
 		PermissionDeployAgent,
@@ -60,9 +68,13 @@ var rolePermissions = map[Role][]Permission{
 		PermissionStopDeploy,
 		PermissionRemoveDeploy,
 		PermissionReadLogs,
+		PermissionReadAgents,
+		PermissionReadMatrices,
 	},
 	RoleViewer: {
 		PermissionReadLogs,
+		PermissionReadAgents,
+		PermissionReadMatrices,
 	},
 }
 
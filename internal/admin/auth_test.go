@@ -1,9 +1,12 @@
 package admin
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"testing"
 
+	"github.com/ecirlabs/matrix-core/internal/kv"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -417,3 +420,105 @@ func TestLogsService_Authorization(t *testing.T) {
 		})
 	}
 }
+
+// fakeStorage is a StorageBackend whose backups hold "backup"
+type fakeStorage struct {
+	compacted chan struct{}
+}
+
+func (f *fakeStorage) Backup(w io.Writer) error {
+	_, err := io.WriteString(w, "backup")
+	return err
+}
+
+func (f *fakeStorage) Compact(start, end []byte) error {
+	f.compacted <- struct{}{}
+	return nil
+}
+
+func (f *fakeStorage) EngineStats() (kv.EngineStats, bool) {
+	return kv.EngineStats{CompactionDebt: 42}, true
+}
+
+func (f *fakeStorage) BucketDiskUsage() (map[string]uint64, error) {
+	return map[string]uint64{"souls": 1024}, nil
+}
+
+func TestStorageService_Authorization(t *testing.T) {
+	auth := NewAuthenticator()
+	if err := auth.AddKey(&APIKey{Key: "admin-key", Role: RoleAdmin}); err != nil {
+		t.Fatalf("Failed to add admin key: %v", err)
+	}
+	if err := auth.AddKey(&APIKey{Key: "operator-key", Role: RoleOperator}); err != nil {
+		t.Fatalf("Failed to add operator key: %v", err)
+	}
+
+	service := NewStorageService(auth)
+	storage := &fakeStorage{compacted: make(chan struct{}, 1)}
+	service.SetStore(storage)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{
+			name:    "admin can back up",
+			ctx:     metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{"authorization": "admin-key"})),
+			wantErr: nil,
+		},
+		{
+			name:    "operator cannot back up",
+			ctx:     metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{"authorization": "operator-key"})),
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "no auth cannot back up",
+			ctx:     context.Background(),
+			wantErr: ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := service.Backup(tt.ctx, &buf)
+			if err != tt.wantErr {
+				t.Errorf("Backup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && buf.String() != "backup" {
+				t.Errorf("Backup() wrote %q", buf.String())
+			}
+			// The fake backup is not a valid archive, so only a denied
+			// restore has a known error
+			err = service.Restore(tt.ctx, &buf, t.TempDir())
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("Restore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (err == ErrForbidden || err == ErrUnauthorized) {
+				t.Errorf("Restore() error = %v, want authorized", err)
+			}
+
+			if err := service.StartCompaction(tt.ctx, nil, nil); err != tt.wantErr {
+				t.Errorf("StartCompaction() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err == nil {
+				<-storage.compacted
+			}
+			status, err := service.Compaction(tt.ctx)
+			if err != tt.wantErr {
+				t.Errorf("Compaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (status.StartedAt.IsZero() || status.Debt != 42) {
+				t.Errorf("Compaction() = %+v", status)
+			}
+			usage, err := service.DiskUsage(tt.ctx)
+			if err != tt.wantErr {
+				t.Errorf("DiskUsage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && usage["souls"] != 1024 {
+				t.Errorf("DiskUsage() = %v", usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+)
+
+// AgentWatch reports an agent's watched globals and their recent changes
+type AgentWatch struct {
+	Globals map[string]uint64
+	Events  []agent.WatchEvent
+}
+
+// debugger returns an agent's debugger after checking the debug permission.
+// Debug mode is enabled on first use.
+func (s *AgentsService) debugger(ctx context.Context, id string) (*agent.Debugger, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionDebugAgents); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+	if source == nil {
+		return nil, fmt.Errorf("agent %s not found", id)
+	}
+
+	d, ok := source.AgentDebugger(id)
+	if !ok {
+		return nil, fmt.Errorf("agent %s not found", id)
+	}
+	return d, nil
+}
+
+// PauseAgent holds an agent's invocations until it is resumed or stepped
+func (s *AgentsService) PauseAgent(ctx context.Context, id string) error {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return err
+	}
+	d.Pause()
+	return nil
+}
+
+// ResumeAgent lets a paused agent run freely again
+func (s *AgentsService) ResumeAgent(ctx context.Context, id string) error {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return err
+	}
+	d.Resume()
+	return nil
+}
+
+// StepAgent runs a single invocation of a paused agent
+func (s *AgentsService) StepAgent(ctx context.Context, id string) (agent.StepResult, error) {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return agent.StepResult{}, err
+	}
+	return d.Step(ctx)
+}
+
+// ReadAgentMemory returns a region of a paused agent's linear memory
+func (s *AgentsService) ReadAgentMemory(ctx context.Context, id string, offset, length uint32) ([]byte, error) {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.ReadMemory(offset, length)
+}
+
+// WatchAgentGlobals starts recording changes to exported globals
+func (s *AgentsService) WatchAgentGlobals(ctx context.Context, id string, names []string) error {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return err
+	}
+	return d.Watch(names...)
+}
+
+// GetAgentWatch returns the watched globals of an agent and their changes
+func (s *AgentsService) GetAgentWatch(ctx context.Context, id string) (AgentWatch, error) {
+	d, err := s.debugger(ctx, id)
+	if err != nil {
+		return AgentWatch{}, err
+	}
+	return AgentWatch{Globals: d.Globals(), Events: d.WatchEvents()}, nil
+}
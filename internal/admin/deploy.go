@@ -2,13 +2,38 @@ package admin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+	"github.com/ecirlabs/matrix-core/internal/matrix"
 )
 
+// ScenarioConfigKey is the DeployMatrix config key holding a YAML or JSON
+// scenario document
+const ScenarioConfigKey = "scenario"
+
+// MatrixDeployer creates and removes the matrices behind matrix deployments
+type MatrixDeployer interface {
+	Create(id string, scenario *matrix.Scenario) (*matrix.Matrix, error)
+	Stop(id string) error
+	Delete(id string) error
+}
+
+// AgentRunner starts and stops the agents behind agent deployments
+type AgentRunner interface {
+	RunAgent(ctx context.Context, deployment *Deployment, code, signature []byte) error
+	StopAgent(ctx context.Context, id string) error
+}
+
 // DeployService handles agent and matrix deployment requests
 type DeployService struct {
 	deployments map[string]*Deployment
+	matrices    MatrixDeployer
+	agents      AgentRunner
+	verifier    *agent.ArtifactVerifier
 	mu          sync.RWMutex
 	auth        *Authenticator
 }
@@ -17,9 +42,28 @@ type DeployService struct {
 type Deployment struct {
 	ID        string
 	Type      string // "agent" or "matrix"
-	Status    string // "running", "stopped", "error"
+	Status    string // "running", "stopped", "error", "oom"
 	Config    map[string]interface{}
 	CreatedAt int64
+
+	// Set for agents deployed from an artifact
+	Manifest *agent.Manifest
+	Limits   agent.ResourceLimits
+	Digest   string
+
+	// Set for matrices deployed from a scenario
+	Scenario *matrix.Scenario
+
+	// Failure describes the most recent guest trap, if any
+	Failure *agent.Trap
+}
+
+// AgentArtifact is a packaged agent submitted for deployment
+type AgentArtifact struct {
+	Code      []byte
+	Signature []byte // Detached ed25519 signature over Code
+	Manifest  []byte // agent.yaml contents; empty uses the manifest embedded in Code
+	Config    map[string]interface{}
 }
 
 // NewDeployService creates a new deploy service
@@ -30,6 +74,30 @@ func NewDeployService(auth *Authenticator) *DeployService {
 	}
 }
 
+// SetMatrixDeployer sets what creates the matrices of matrix deployments.
+// Without one, matrix deployments are recorded only.
+func (s *DeployService) SetMatrixDeployer(deployer MatrixDeployer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.matrices = deployer
+}
+
+// SetAgentRunner sets what runs the agents of artifact deployments.
+// Without one, agent deployments are recorded only.
+func (s *DeployService) SetAgentRunner(runner AgentRunner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agents = runner
+}
+
+// SetArtifactVerifier sets the verifier artifact signatures are checked
+// against. Without one, no signer is trusted and unsigned code is rejected.
+func (s *DeployService) SetArtifactVerifier(verifier *agent.ArtifactVerifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = verifier
+}
+
 // DeployAgent deploys a new agent
 func (s *DeployService) DeployAgent(ctx context.Context, id string, config map[string]interface{}) error {
 	// Check authorization
@@ -57,7 +125,100 @@ func (s *DeployService) DeployAgent(ctx context.Context, id string, config map[s
 	return nil
 }
 
-// DeployMatrix deploys a new matrix
+// DeployAgentArtifact deploys an agent described by its manifest. The
+// signature is verified before anything else is done with the artifact;
+// the manifest then determines the resource limits and validates the
+// config.
+func (s *DeployService) DeployAgentArtifact(ctx context.Context, id string, artifact AgentArtifact) (*Deployment, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionDeployAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	verifier := s.verifier
+	s.mu.RUnlock()
+	if verifier == nil {
+		verifier = agent.NewArtifactVerifier(false, nil)
+	}
+	if _, err := verifier.Verify(artifact.Code, artifact.Signature); err != nil {
+		return nil, fmt.Errorf("failed to verify agent %s: %w", id, err)
+	}
+
+	var manifest *agent.Manifest
+	var err error
+	if len(artifact.Manifest) > 0 {
+		manifest, err = agent.ParseManifest(artifact.Manifest)
+	} else {
+		manifest, err = agent.ManifestFromCode(artifact.Code)
+	}
+	if errors.Is(err, agent.ErrNoManifest) {
+		return nil, fmt.Errorf("agent %s has no agent.yaml or embedded manifest", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := manifest.ValidateConfig(artifact.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config for agent %s: %w", id, err)
+	}
+
+	limits := manifest.Limits(agent.DefaultMemoryLimits)
+	if err := limits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid resource requests for agent %s: %w", id, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.deployments[id]; exists {
+		return nil, fmt.Errorf("deployment with ID %s already exists", id)
+	}
+
+	deployment := &Deployment{
+		ID:        id,
+		Type:      "agent",
+		Status:    "running",
+		Config:    config,
+		CreatedAt: time.Now().Unix(),
+		Manifest:  manifest,
+		Limits:    limits,
+		Digest:    agent.Digest(artifact.Code),
+	}
+	if s.agents != nil {
+		if err := s.agents.RunAgent(ctx, deployment, artifact.Code, artifact.Signature); err != nil {
+			return nil, fmt.Errorf("failed to run agent %s: %w", id, err)
+		}
+	}
+	s.deployments[id] = deployment
+
+	return deployment, nil
+}
+
+// ReportFailure marks an agent deployment as errored with the trap that
+// caused it. Traps from exhausted memory set the status to "oom".
+func (s *DeployService) ReportFailure(id string, trap *agent.Trap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deployment, exists := s.deployments[id]
+	if !exists {
+		return fmt.Errorf("deployment with ID %s not found", id)
+	}
+
+	deployment.Status = "error"
+	if trap != nil && trap.Kind == agent.TrapOutOfMemory {
+		deployment.Status = "oom"
+	}
+	deployment.Failure = trap
+	return nil
+}
+
+// DeployMatrix deploys a new matrix. A scenario under ScenarioConfigKey is
+// parsed and validated and kept on the deployment.
 func (s *DeployService) DeployMatrix(ctx context.Context, id string, config map[string]interface{}) error {
 	// Check authorization
 	if s.auth != nil {
@@ -66,19 +227,42 @@ func (s *DeployService) DeployMatrix(ctx context.Context, id string, config map[
 		}
 	}
 
+	var scenario *matrix.Scenario
+	if raw, ok := config[ScenarioConfigKey]; ok {
+		var data []byte
+		switch v := raw.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			return fmt.Errorf("scenario for matrix %s must be a YAML or JSON document", id)
+		}
+		var err error
+		if scenario, err = matrix.ParseScenario(data); err != nil {
+			return fmt.Errorf("invalid scenario for matrix %s: %w", id, err)
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.deployments[id]; exists {
 		return fmt.Errorf("deployment with ID %s already exists", id)
 	}
+	if s.matrices != nil {
+		if _, err := s.matrices.Create(id, scenario); err != nil {
+			return fmt.Errorf("failed to create matrix %s: %w", id, err)
+		}
+	}
 
 	s.deployments[id] = &Deployment{
 		ID:        id,
 		Type:      "matrix",
 		Status:    "running",
 		Config:    config,
-		CreatedAt: 0, // TODO: Use actual timestamp
+		CreatedAt: time.Now().Unix(),
+		Scenario:  scenario,
 	}
 
 	return nil
@@ -126,6 +310,14 @@ func (s *DeployService) StopDeployment(ctx context.Context, id string) error {
 	if !exists {
 		return fmt.Errorf("deployment with ID %s not found", id)
 	}
+	if deployment.Type == "matrix" && s.matrices != nil {
+		if err := s.matrices.Stop(id); err != nil {
+			return fmt.Errorf("failed to stop matrix %s: %w", id, err)
+		}
+	}
+	if err := s.stopAgent(ctx, deployment); err != nil {
+		return err
+	}
 
 	deployment.Status = "stopped"
 	return nil
@@ -143,10 +335,31 @@ func (s *DeployService) RemoveDeployment(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.deployments[id]; !exists {
+	deployment, exists := s.deployments[id]
+	if !exists {
 		return fmt.Errorf("deployment with ID %s not found", id)
 	}
+	if deployment.Type == "matrix" && s.matrices != nil {
+		if err := s.matrices.Delete(id); err != nil {
+			return fmt.Errorf("failed to delete matrix %s: %w", id, err)
+		}
+	}
+	if err := s.stopAgent(ctx, deployment); err != nil {
+		return err
+	}
 
 	delete(s.deployments, id)
 	return nil
 }
+
+// stopAgent stops the running agent of an artifact deployment. Callers
+// must hold mu.
+func (s *DeployService) stopAgent(ctx context.Context, deployment *Deployment) error {
+	if deployment.Manifest == nil || deployment.Status == "stopped" || s.agents == nil {
+		return nil
+	}
+	if err := s.agents.StopAgent(ctx, deployment.ID); err != nil {
+		return fmt.Errorf("failed to stop agent %s: %w", deployment.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+)
+
+// fakeRunner records the agents a DeployService runs and stops
+type fakeRunner struct {
+	running map[string][]byte
+}
+
+func (r *fakeRunner) RunAgent(ctx context.Context, deployment *Deployment, code, signature []byte) error {
+	r.running[deployment.ID] = signature
+	return nil
+}
+
+func (r *fakeRunner) StopAgent(ctx context.Context, id string) error {
+	delete(r.running, id)
+	return nil
+}
+
+func TestDeployService_DeployAgentArtifact(t *testing.T) {
+	ctx := context.Background()
+	signerPub, signer, _ := ed25519.GenerateKey(nil)
+	_, stranger, _ := ed25519.GenerateKey(nil)
+	code := []byte("\x00asm\x01\x00\x00\x00")
+	manifest := []byte("name: greeter\nversion: 1.0.0\n")
+
+	tests := []struct {
+		name     string
+		verifier *agent.ArtifactVerifier
+		artifact AgentArtifact
+		wantErr  error
+	}{
+		{"unsigned without verifier", nil, AgentArtifact{Code: code, Manifest: manifest}, agent.ErrUnsignedArtifact},
+		{"unsigned disallowed", agent.NewArtifactVerifier(false, []ed25519.PublicKey{signerPub}), AgentArtifact{Code: code, Manifest: manifest}, agent.ErrUnsignedArtifact},
+		// Verification comes before the missing manifest is noticed
+		{"untrusted signer", agent.NewArtifactVerifier(true, []ed25519.PublicKey{signerPub}), AgentArtifact{Code: code, Signature: agent.SignArtifact(stranger, code)}, agent.ErrUntrustedSigner},
+		{"trusted signer", agent.NewArtifactVerifier(false, []ed25519.PublicKey{signerPub}), AgentArtifact{Code: code, Signature: agent.SignArtifact(signer, code), Manifest: manifest}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{running: make(map[string][]byte)}
+			service := NewDeployService(nil)
+			service.SetAgentRunner(runner)
+			if tt.verifier != nil {
+				service.SetArtifactVerifier(tt.verifier)
+			}
+
+			deployment, err := service.DeployAgentArtifact(ctx, "greeter", tt.artifact)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeployAgentArtifact() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(service.ListDeployments()) != 0 || len(runner.running) != 0 {
+					t.Errorf("rejected artifact was recorded or run")
+				}
+				return
+			}
+
+			if deployment.Digest != agent.Digest(code) {
+				t.Errorf("Digest = %s, want %s", deployment.Digest, agent.Digest(code))
+			}
+			if _, ok := runner.running["greeter"]; !ok {
+				t.Fatalf("agent was not run")
+			}
+			if err := service.StopDeployment(ctx, "greeter"); err != nil {
+				t.Fatalf("StopDeployment() error = %v", err)
+			}
+			if _, ok := runner.running["greeter"]; ok {
+				t.Errorf("agent still running after StopDeployment()")
+			}
+		})
+	}
+}
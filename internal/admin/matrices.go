@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/matrix"
+)
+
+// MatrixSource looks up the node's matrices
+type MatrixSource interface {
+	Matrix(id string) (*matrix.Matrix, bool)
+}
+
+// MatricesService exposes running matrices
+type MatricesService struct {
+	source MatrixSource
+	mu     sync.RWMutex
+	auth   *Authenticator
+}
+
+// NewMatricesService creates a new matrices service
+func NewMatricesService(auth *Authenticator) *MatricesService {
+	return &MatricesService{
+		auth: auth,
+	}
+}
+
+// SetSource sets where matrices are looked up
+func (s *MatricesService) SetSource(source MatrixSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source = source
+}
+
+// StreamMatrixEvents streams a matrix's events matching the given filters
+// until ctx ends
+func (s *MatricesService) StreamMatrixEvents(ctx context.Context, id string, filters matrix.WatchFilters, ch chan<- matrix.JournalEntry) error {
+	defer close(ch)
+
+	m, err := s.matrix(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	events := m.Watch(ctx, filters)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-events:
+			if !ok {
+				return ctx.Err()
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- entry:
+			}
+		}
+	}
+}
+
+// GetRunResult returns the result of a matrix's most recent finished run
+func (s *MatricesService) GetRunResult(ctx context.Context, id string) (*matrix.RunResult, error) {
+	m, err := s.matrix(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := m.LastRunResult()
+	if !ok {
+		return nil, fmt.Errorf("matrix %s has not finished a run", id)
+	}
+	return result, nil
+}
+
+// ExportMatrix writes a journaled matrix's run so far in an analysis
+// format: its event stream as CSV or Parquet, or its interaction graph as
+// GEXF or GraphML
+func (s *MatricesService) ExportMatrix(ctx context.Context, id string, format matrix.ExportFormat, opts matrix.ExportOptions, w io.Writer) error {
+	m, err := s.matrix(ctx, id)
+	if err != nil {
+		return err
+	}
+	j, ok := m.Journal()
+	if !ok {
+		return fmt.Errorf("matrix %s: %w", id, matrix.ErrNoJournal)
+	}
+	return matrix.ExportJournal(w, format, j, opts)
+}
+
+// matrix checks authorization and looks up a matrix
+func (s *MatricesService) matrix(ctx context.Context, id string) (*matrix.Matrix, error) {
+	// Check authorization
+	if s.auth != nil {
+		if _, err := s.auth.CheckPermission(ctx, PermissionReadMatrices); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	source := s.source
+	s.mu.RUnlock()
+	if source == nil {
+		return nil, fmt.Errorf("matrix %s not found", id)
+	}
+
+	m, ok := source.Matrix(id)
+	if !ok {
+		return nil, fmt.Errorf("matrix %s not found", id)
+	}
+	return m, nil
+}
@@ -17,6 +17,9 @@ type Server struct {
 	addr        string
 	deploySvc   *DeployService
 	logsSvc     *LogsService
+	agentsSvc   *AgentsService
+	matricesSvc *MatricesService
+	storageSvc  *StorageService
 	auth        *Authenticator
 	requireAuth bool
 }
@@ -59,6 +62,9 @@ func NewServer(cfg Config) (*Server, error) {
 	// Create and register custom services
 	deploySvc := NewDeployService(auth)
 	logsSvc := NewLogsService(auth)
+	agentsSvc := NewAgentsService(auth)
+	matricesSvc := NewMatricesService(auth)
+	storageSvc := NewStorageService(auth)
 
 	return &Server{
 		grpcServer:  grpcServer,
@@ -66,6 +72,9 @@ func NewServer(cfg Config) (*Server, error) {
 		addr:        cfg.Addr,
 		deploySvc:   deploySvc,
 		logsSvc:     logsSvc,
+		agentsSvc:   agentsSvc,
+		matricesSvc: matricesSvc,
+		storageSvc:  storageSvc,
 		auth:        auth,
 		requireAuth: cfg.RequireAuth,
 	}, nil
@@ -110,6 +119,21 @@ func (s *Server) GetLogsService() *LogsService {
 	return s.logsSvc
 }
 
+// GetAgentsService returns the agents service instance
+func (s *Server) GetAgentsService() *AgentsService {
+	return s.agentsSvc
+}
+
+// GetMatricesService returns the matrices service instance
+func (s *Server) GetMatricesService() *MatricesService {
+	return s.matricesSvc
+}
+
+// GetStorageService returns the storage service instance
+func (s *Server) GetStorageService() *StorageService {
+	return s.storageSvc
+}
+
 // GetAuthenticator returns the authenticator instance
 func (s *Server) GetAuthenticator() *Authenticator {
 	return s.auth
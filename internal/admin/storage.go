@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// ErrCompactionRunning is returned when a compaction is started while
+// another is running
+var ErrCompactionRunning = errors.New("compaction already running")
+
+// StorageBackend is the node storage the storage service manages
+type StorageBackend interface {
+	Backup(w io.Writer) error
+	Compact(start, end []byte) error
+	EngineStats() (kv.EngineStats, bool)
+	BucketDiskUsage() (map[string]uint64, error)
+}
+
+// CompactionStatus describes the last compaction started through the
+// service and the engine's own background compactions
+type CompactionStatus struct {
+	Running    bool
+	Start, End []byte // Range of the compaction; nil sides are open
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Error      string // Why the last compaction failed, if it did
+	Active     int64  // Background compactions running
+	Debt       uint64 // Bytes the engine estimates it must compact
+}
+
+// StorageService backs up, restores, and compacts the node's storage
+type StorageService struct {
+	store      StorageBackend
+	compaction CompactionStatus
+	mu         sync.RWMutex
+	auth       *Authenticator
+}
+
+// NewStorageService creates a new storage service
+func NewStorageService(auth *Authenticator) *StorageService {
+	return &StorageService{
+		auth: auth,
+	}
+}
+
+// SetStore sets the storage to back up
+func (s *StorageService) SetStore(store StorageBackend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+}
+
+// Backup writes a tar archive of the node's storage to w without stopping
+// the node
+func (s *StorageService) Backup(ctx context.Context, w io.Writer) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+
+	store, err := s.backend()
+	if err != nil {
+		return err
+	}
+	return store.Backup(w)
+}
+
+// Restore unpacks a backup into dir, which must not exist or be empty. The
+// node's own storage is untouched; restart the node on dir to use it.
+func (s *StorageService) Restore(ctx context.Context, r io.Reader, dir string) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	return kv.Restore(r, dir)
+}
+
+// StartCompaction compacts the keys from start up to but excluding end in
+// the background; nil sides leave the range open. Follow its progress with
+// Compaction.
+func (s *StorageService) StartCompaction(ctx context.Context, start, end []byte) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return fmt.Errorf("no storage configured")
+	}
+	if s.compaction.Running {
+		return ErrCompactionRunning
+	}
+	s.compaction = CompactionStatus{Running: true, Start: start, End: end, StartedAt: time.Now()}
+
+	go func(store StorageBackend) {
+		err := store.Compact(start, end)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.compaction.Running = false
+		s.compaction.FinishedAt = time.Now()
+		if err != nil {
+			s.compaction.Error = err.Error()
+		}
+	}(s.store)
+	return nil
+}
+
+// Compaction returns the status of compactions
+func (s *StorageService) Compaction(ctx context.Context) (CompactionStatus, error) {
+	if err := s.authorize(ctx); err != nil {
+		return CompactionStatus{}, err
+	}
+	store, err := s.backend()
+	if err != nil {
+		return CompactionStatus{}, err
+	}
+
+	s.mu.RLock()
+	status := s.compaction
+	s.mu.RUnlock()
+	if stats, ok := store.EngineStats(); ok {
+		status.Active = stats.CompactionsActive
+		status.Debt = stats.CompactionDebt
+	}
+	return status, nil
+}
+
+// DiskUsage returns the estimated bytes on disk of each storage bucket
+func (s *StorageService) DiskUsage(ctx context.Context) (map[string]uint64, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	store, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	return store.BucketDiskUsage()
+}
+
+// backend returns the storage, failing when none is set
+func (s *StorageService) backend() (StorageBackend, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return nil, fmt.Errorf("no storage configured")
+	}
+	return s.store, nil
+}
+
+// authorize checks that the caller may manage storage
+func (s *StorageService) authorize(ctx context.Context) error {
+	if s.auth == nil {
+		return nil
+	}
+	_, err := s.auth.CheckPermission(ctx, PermissionManageStorage)
+	return err
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ExportAlloc is the guest export the host calls to obtain a buffer in
+// guest memory: alloc(size i32) -> ptr i32. Guests that receive data from
+// the host (state, messages, config) must export it.
+const ExportAlloc = "alloc"
+
+// ExportRealloc is the canonical ABI allocator component guests export in
+// place of alloc: cabi_realloc(old_ptr, old_size, align, new_size i32) -> ptr i32
+const ExportRealloc = "cabi_realloc"
+
+// exportPostReturnPrefix names the canonical ABI functions a component
+// guest may export to free a hook's result once the host has read it
+const exportPostReturnPrefix = "cabi_post_"
+
+// packPtrLen packs a guest pointer and length into a single i64 result,
+// pointer in the high 32 bits
+func packPtrLen(ptr, length uint32) uint64 {
+	return uint64(ptr)<<32 | uint64(length)
+}
+
+// unpackPtrLen splits an i64 result into a guest pointer and length
+func unpackPtrLen(v uint64) (ptr, length uint32) {
+	return uint32(v >> 32), uint32(v)
+}
+
+// writeToGuest copies data into a buffer allocated by the module's alloc
+// export, or cabi_realloc for components, and returns its pointer. Callers
+// must hold callMu.
+func (a *Agent) writeToGuest(ctx context.Context, module api.Module, data []byte) (uint32, error) {
+	name, params := ExportAlloc, []uint64{uint64(len(data))}
+	if a.component {
+		name, params = ExportRealloc, []uint64{0, 0, 1, uint64(len(data))}
+	}
+	alloc := module.ExportedFunction(name)
+	if alloc == nil {
+		return 0, fmt.Errorf("module does not export %s", name)
+	}
+
+	results, err := a.invoke(ctx, module, alloc, params...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	ptr := uint32(results[0])
+
+	if len(data) > 0 && !module.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("%s returned out of bounds pointer %d", name, ptr)
+	}
+	return ptr, nil
+}
+
+// resultRegion returns the packed pointer and length of a hook's byte
+// result. Core modules return it packed; components return a pointer to a
+// canonical ABI return area holding the pointer and length. Callers must
+// hold callMu.
+func (a *Agent) resultRegion(module api.Module, name string, result uint64) (uint64, error) {
+	if !a.component {
+		return result, nil
+	}
+	area, ok := readGuestBytes(module, uint32(result), 8)
+	if !ok {
+		return 0, fmt.Errorf("%s returned out of bounds return area %d", name, uint32(result))
+	}
+	return packPtrLen(binary.LittleEndian.Uint32(area), binary.LittleEndian.Uint32(area[4:])), nil
+}
+
+// postReturn lets a component free a hook's result once the host has
+// copied it, through the hook's cabi_post_ export if it has one. Callers
+// must hold callMu.
+func (a *Agent) postReturn(ctx context.Context, module api.Module, name string, result uint64) {
+	if !a.component {
+		return
+	}
+	if fn := module.ExportedFunction(exportPostReturnPrefix + name); fn != nil {
+		// A failing callback is reported like any other trap by invoke
+		_, _ = a.invoke(ctx, module, fn, result)
+	}
+}
+
+// readFromGuest copies a packed pointer/length region out of guest memory
+func readFromGuest(module api.Module, packed uint64) ([]byte, error) {
+	ptr, length := unpackPtrLen(packed)
+	buf, ok := readGuestBytes(module, ptr, length)
+	if !ok {
+		return nil, fmt.Errorf("guest region %d+%d out of bounds", ptr, length)
+	}
+	return buf, nil
+}
@@ -2,40 +2,229 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/soul"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
 )
 
 // DefaultMemoryLimits defines default resource constraints
 var DefaultMemoryLimits = ResourceLimits{
-	MaxMemoryPages: 256, // 16MB (256 * 64KB)
-	MaxFuel:        1000000,
+	MaxMemoryPages: 256,       // 16MB (256 * 64KB)
+	MaxFuel:        100000000, // Instructions per invocation
+	CallTimeout:    5 * time.Second,
 }
 
+// ErrCallTimeout is returned when an invocation exceeds its deadline. The
+// guest instance is closed and must be recreated.
+var ErrCallTimeout = errors.New("call deadline exceeded")
+
+// DefaultInboxSize is the number of undelivered messages an agent may hold
+const DefaultInboxSize = 256
+
 // Agent represents a WebAssembly agent
 type Agent struct {
-	ID      string
-	module  api.Module
-	runtime wazero.Runtime
-	memory  []byte
+	ID        string
+	module    api.Module
+	runtime   wazero.Runtime
+	logger    Logger
+	messenger Messenger
+	pubsub    PubSub
+	topics    TopicACL
+	mailbox   *Mailbox
+	instance  int    // Index within an InstancePool
+	name      string // Module instance name, unique within the runtime
+	limits    ResourceLimits
+	caps      capabilitySet
+	kv        *Namespace
+	http      *httpFetcher
+	keys      *KeyStore
+	clock     Clock
+	digest    atomic.Pointer[string]
+	pool      *RuntimePool
+	poolKey   poolKey
+	initData  []byte
+	verifier  *ArtifactVerifier
+	entry     Entrypoints
+	metrics   AgentMetrics
+	stdout    *RingBuffer
+	stderr    *RingBuffer
+
+	// soul is the agent's soul binding, if any
+	soul atomic.Pointer[soulBinding]
+
+	// initConfig holds the resolved deployment config for config envelopes
+	initConfig map[string][]byte
+
+	// envelope is the negotiated envelope version; 0 uses the raw ABI
+	envelope atomic.Uint32
+
+	// usage aggregates consumption for chargeback reports
+	usage *UsageLedger
+
+	// debugger is set once debug mode is enabled
+	debugger atomic.Pointer[Debugger]
+
+	// deterministic agents are driven by Step instead of the dispatch loop
+	deterministic bool
+
+	// mem enforces the memory policy on every instance of the agent
+	mem *memoryGuard
+
+	// sharesMailbox is set for InstancePool members, whose mailbox belongs
+	// to the pool
+	sharesMailbox bool
+
+	// component is set while the instance was unwrapped from a component
+	// and speaks the canonical ABI. Guarded by callMu.
+	component bool
+
+	// callMu serializes invocations and guards module swaps on reload
+	callMu       sync.Mutex
+	compiled     wazero.CompiledModule
+	moduleConfig wazero.ModuleConfig
+	generation   int
+	fuelUsed     atomic.Uint64
+	invocations  atomic.Uint64
+	failures     atomic.Uint64
+	execNanos    atomic.Int64
+	memoryPages  atomic.Uint32
+	stopped      bool
+
+	// done is closed once the instance crashes or is stopped
+	done     chan struct{}
+	doneOnce sync.Once
+	crashErr error
 }
 
 // Config represents agent configuration
 type Config struct {
-	ID      string
-	Code    []byte
-	Stdout  io.Writer
-	Stderr  io.Writer
-	MemSize uint32
+	ID        string
+	Code      []byte
+	Signature []byte            // Detached ed25519 signature over Code
+	Verifier  *ArtifactVerifier // Checks Signature before compilation; nil trusts no signer and rejects unsigned code
+	Stdout    io.Writer
+	Stderr    io.Writer
+	Logger    Logger        // Receives entries from the guest log() host function
+	Messenger Messenger     // Delivers messages from the guest send() host function
+	Mailbox   MailboxConfig // Inbound queue depth and overflow policy
+	Metrics   AgentMetrics  // Receives resource usage and mailbox depth; may be nil
+	WASI      WASIConfig
+	Store     *kv.Store // Backs the kv_* host functions; nil disables them
+	KVQuota   KVQuota   // Limits for the agent's namespace; zero fields use DefaultKVQuota's
+	HTTP      HTTPPolicy
+	Keys      *KeyStore // Signing keys for the crypto_* host functions
+	Clock     Clock     // Time and entropy source; nil uses the host clock
+	Cache     *ModuleCache
+	Pool      *RuntimePool // Shares runtimes for agents whose Trust level allows it
+	Trust     TrustLevel
+	InitData  []byte // Passed to the guest's on_init export when it starts
+
+	// Entrypoints overrides the lifecycle export names; empty fields use
+	// DefaultEntrypoints
+	Entrypoints Entrypoints
+
+	// InitConfig is serialized to JSON and passed to on_init when InitData
+	// is empty; string values may be secret references
+	InitConfig map[string]interface{}
+
+	// Secrets resolves secret references in InitConfig and WASI.Env
+	Secrets SecretResolver
+
+	// PubSub backs the publish and subscribe host functions; Topics limits
+	// which transport topics they and send() may reach
+	PubSub PubSub
+	Topics TopicACL
+
+	// Soul binds the agent to a soul for the soul_* host functions, which
+	// also require CapabilitySoul; SoulAccess limits what they may do
+	Soul       *soul.Soul
+	SoulAccess SoulAccess
+
+	// Usage receives per-invocation fuel and execution time for usage
+	// reports; may be nil
+	Usage *UsageLedger
+
+	// Deterministic puts the agent under a scheduler: a seeded VirtualClock
+	// replaces Clock and messages are delivered only by Step
+	Deterministic *DeterministicConfig
+
+	// Set by InstancePool for its members
+	instance        int
+	sharedMailbox   *Mailbox
+	sharedNamespace *Namespace
+
+	// OutputBufferSize is the number of bytes of stdout and stderr retained
+	// for inspection; 0 uses DefaultOutputBufferSize
+	OutputBufferSize int
+
+	// Capabilities selects which host functions are exported to the
+	// instance; functions outside the granted set are not linkable, or in
+	// pooled runtimes return StatusUnavailable
+	Capabilities []Capability
+}
+
+// WASIConfig scopes the WASI preview1 capabilities granted to an agent. The
+// zero value grants no filesystem or network access; clocks and randomness
+// come from the agent's Clock.
+type WASIConfig struct {
+	Args     []string          // Command line arguments visible to the guest, after the agent ID
+	Env      map[string]string // Environment variables; values may be secret references
+	Preopens []Preopen         // Host directories visible to the guest; none by default
+}
+
+// Preopen mounts a host directory into an agent's WASI filesystem. The
+// guest cannot reach anything outside its mounts.
+type Preopen struct {
+	HostPath  string // Directory on the host
+	GuestPath string // Absolute mount point seen by the guest
+	ReadOnly  bool   // Reject writes through the mount
+}
+
+// fsConfig validates the preopens and mounts them, returning nil when
+// there are none
+func (c WASIConfig) fsConfig() (wazero.FSConfig, error) {
+	if len(c.Preopens) == 0 {
+		return nil, nil
+	}
+	fsConfig := wazero.NewFSConfig()
+	for _, p := range c.Preopens {
+		if !strings.HasPrefix(p.GuestPath, "/") {
+			return nil, fmt.Errorf("preopen guest path %q must be absolute", p.GuestPath)
+		}
+		info, err := os.Stat(p.HostPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preopen %s: %w", p.GuestPath, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("preopen host path %s is not a directory", p.HostPath)
+		}
+		if p.ReadOnly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(p.HostPath, p.GuestPath)
+		} else {
+			fsConfig = fsConfig.WithDirMount(p.HostPath, p.GuestPath)
+		}
+	}
+	return fsConfig, nil
 }
 
 // ResourceLimits defines resource constraints for an agent
 type ResourceLimits struct {
-	MaxMemoryPages uint32 // Number of 64KB pages
-	MaxFuel        uint64
+	MaxMemoryPages uint32        // Number of 64KB pages
+	MaxFuel        uint64        // Instructions per invocation; 0 disables metering
+	CallTimeout    time.Duration // Wall-clock limit per invocation; 0 disables the deadline
+	MemoryPolicy   MemoryPolicy  // How memory.grow is handled; empty uses MemoryGrowToLimit
+	MemoryPressure float64       // Fraction of MaxMemoryPages that triggers on_memory_pressure; 0 uses DefaultMemoryPressure
 }
 
 // Validate checks if the resource limits are within acceptable ranges
@@ -46,7 +235,13 @@ func (l ResourceLimits) Validate() error {
 	if l.MaxMemoryPages > 65536 {
 		return fmt.Errorf("MaxMemoryPages exceeds maximum allowed (65536)")
 	}
-	return nil
+	if l.CallTimeout < 0 {
+		return fmt.Errorf("CallTimeout must not be negative")
+	}
+	if l.MemoryPressure < 0 || l.MemoryPressure > 1 {
+		return fmt.Errorf("MemoryPressure must be between 0 and 1")
+	}
+	return l.MemoryPolicy.validate()
 }
 
 // New creates a new Agent instance
@@ -56,106 +251,442 @@ func New(ctx context.Context, cfg Config, limits ResourceLimits) (*Agent, error)
 		return nil, fmt.Errorf("invalid resource limits: %w", err)
 	}
 
-	// Create WebAssembly runtime with memory tuning
-	rConfig := wazero.NewRuntimeConfig().
-		WithMemoryLimitPages(limits.MaxMemoryPages)
-
-	r := wazero.NewRuntimeWithConfig(ctx, rConfig)
-
-	// Configure module
-	builder := r.NewHostModuleBuilder("env")
+	caps, err := newCapabilitySet(cfg.Capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capabilities: %w", err)
+	}
 
-	// Add host functions
-	builder.NewFunctionBuilder().
-		WithFunc(hostLog).
-		Export("log")
+	verifier := cfg.Verifier
+	if verifier == nil {
+		verifier = NewArtifactVerifier(false, nil)
+	}
+	if _, err := verifier.Verify(cfg.Code, cfg.Signature); err != nil {
+		return nil, fmt.Errorf("failed to verify agent %s: %w", cfg.ID, err)
+	}
+	code, component, err := coreModule(cfg.Code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent %s: %w", cfg.ID, err)
+	}
 
-	builder.NewFunctionBuilder().
-		WithFunc(hostSend).
-		Export("send")
+	// Resolve secret references before anything is allocated
+	env, err := resolveEnv(ctx, cfg.Secrets, cfg.WASI.Env)
+	if err != nil {
+		return nil, err
+	}
+	fsConfig, err := cfg.WASI.fsConfig()
+	if err != nil {
+		return nil, err
+	}
+	ns := cfg.sharedNamespace
+	if ns == nil && cfg.Store != nil {
+		if ns, err = NewNamespace(cfg.Store, cfg.ID, cfg.KVQuota.withDefaults()); err != nil {
+			return nil, err
+		}
+	}
+	initData := cfg.InitData
+	var initConfig map[string][]byte
+	if cfg.InitConfig != nil {
+		if initData == nil {
+			if initData, err = EncodeInitConfig(ctx, cfg.Secrets, cfg.InitConfig); err != nil {
+				return nil, err
+			}
+		}
+		if initConfig, err = encodeConfigEntries(ctx, cfg.Secrets, cfg.InitConfig); err != nil {
+			return nil, err
+		}
+	}
 
-	builder.NewFunctionBuilder().
-		WithFunc(hostGetMemory).
-		Export("get_memory")
+	// Obtain a runtime with the module compiled in it, either from the
+	// shared pool or dedicated to this agent
+	var r wazero.Runtime
+	var compiled wazero.CompiledModule
+	var pool *RuntimePool
+	key := poolKey{trust: cfg.Trust, memoryPages: limits.MaxMemoryPages}
+	metered := limits.MaxFuel > 0
+	digest := Digest(cfg.Code)
 
-	builder.NewFunctionBuilder().
-		WithFunc(hostSetMemory).
-		Export("set_memory")
+	if cfg.Pool != nil && cfg.Pool.Shares(cfg.Trust) {
+		r, compiled, err = cfg.Pool.acquire(ctx, key, code, metered)
+		if err != nil {
+			return nil, err
+		}
+		pool = cfg.Pool
+	} else {
+		r, err = newRuntime(ctx, limits.MaxMemoryPages, cfg.Cache, caps)
+		if err != nil {
+			return nil, err
+		}
+		compiled, err = compileModule(ctx, r, code, metered)
+		if err != nil {
+			r.Close(ctx)
+			return nil, err
+		}
+		if cfg.Cache != nil {
+			cfg.Cache.record(digest)
+		}
+	}
 
-	// Instantiate host module
-	if _, err := builder.Instantiate(ctx); err != nil {
-		return nil, fmt.Errorf("failed to instantiate host module: %w", err)
+	clock := cfg.Clock
+	if cfg.Deterministic != nil {
+		start := cfg.Deterministic.Start
+		if start.IsZero() {
+			start = time.Unix(0, 0)
+		}
+		clock = NewVirtualClock(start, cfg.Deterministic.Seed)
+	} else if clock == nil {
+		clock = NewRealClock()
 	}
 
-	// Compile WebAssembly module
-	compiled, err := r.CompileModule(ctx, cfg.Code)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compile module: %w", err)
+	// Keep the tail of guest output for inspection
+	outputSize := cfg.OutputBufferSize
+	if outputSize <= 0 {
+		outputSize = DefaultOutputBufferSize
 	}
+	stdout, stderr := NewRingBuffer(outputSize), NewRingBuffer(outputSize)
 
-	// Configure module
+	// Configure module; _start is deferred to Start so host functions
+	// called during startup can resolve the agent
+	name := cfg.ID
+	if cfg.sharedMailbox != nil {
+		name = fmt.Sprintf("%s/%d", cfg.ID, cfg.instance)
+	}
 	moduleConfig := wazero.NewModuleConfig().
-		WithName(cfg.ID).
-		WithStdout(cfg.Stdout).
-		WithStderr(cfg.Stderr)
+		WithName(name).
+		WithStdout(teeOutput(stdout, cfg.Stdout)).
+		WithStderr(teeOutput(stderr, cfg.Stderr)).
+		WithArgs(append([]string{cfg.ID}, cfg.WASI.Args...)...).
+		WithWalltime(func() (int64, int32) {
+			now := clock.Now()
+			return now.Unix(), int32(now.Nanosecond())
+		}, sys.ClockResolution(time.Microsecond)).
+		WithNanotime(clock.Nanotime, sys.ClockResolution(1)).
+		WithRandSource(clock).
+		WithStartFunctions()
+	for _, key := range sortedKeys(env) {
+		moduleConfig = moduleConfig.WithEnv(key, env[key])
+	}
+	if fsConfig != nil {
+		moduleConfig = moduleConfig.WithFSConfig(fsConfig)
+	}
 
-	// Instantiate module
-	module, err := r.InstantiateModule(ctx, compiled, moduleConfig)
+	// Instantiate module; linear memory is allocated as the guest grows it,
+	// subject to the memory policy
+	mem := newMemoryGuard(limits)
+	module, err := r.InstantiateModule(mem.withAllocator(ctx), compiled, moduleConfig)
 	if err != nil {
+		if pool != nil {
+			pool.release(ctx, key)
+		} else {
+			r.Close(ctx)
+		}
 		return nil, fmt.Errorf("failed to instantiate module: %w", err)
 	}
 
-	// Initialize agent memory buffer
-	memSize := cfg.MemSize
-	if memSize == 0 {
-		memSize = uint32(limits.MaxMemoryPages) * 65536 // Default to max WebAssembly memory
+	httpPolicy := cfg.HTTP
+	if httpPolicy.MaxResponseBytes <= 0 {
+		httpPolicy.MaxResponseBytes = DefaultHTTPPolicy.MaxResponseBytes
+	}
+	if httpPolicy.Timeout <= 0 {
+		httpPolicy.Timeout = DefaultHTTPPolicy.Timeout
 	}
+	if httpPolicy.RequestsPerMinute <= 0 {
+		httpPolicy.RequestsPerMinute = DefaultHTTPPolicy.RequestsPerMinute
+	}
+
+	a := &Agent{
+		ID:        cfg.ID,
+		module:    module,
+		runtime:   r,
+		logger:    cfg.Logger,
+		messenger: cfg.Messenger,
+		pubsub:    cfg.PubSub,
+		topics:    cfg.Topics,
+		mailbox:   cfg.sharedMailbox,
+		instance:  cfg.instance,
+		name:      name,
+		limits:    limits,
+		caps:      caps,
+		kv:        ns,
+		http:      newHTTPFetcher(httpPolicy),
+		keys:      cfg.Keys,
+		clock:     clock,
+		pool:      pool,
+		poolKey:   key,
+		initData:  initData,
+		verifier:  verifier,
+		entry:     cfg.Entrypoints.withDefaults(),
+		metrics:   cfg.Metrics,
+		stdout:    stdout,
+		stderr:    stderr,
 
-	return &Agent{
-		ID:      cfg.ID,
-		module:  module,
-		runtime: r,
-		memory:  make([]byte, memSize),
-	}, nil
+		initConfig:    initConfig,
+		usage:         cfg.Usage,
+		deterministic: cfg.Deterministic != nil,
+		mem:           mem,
+		sharesMailbox: cfg.sharedMailbox != nil,
+		component:     component,
+		compiled:      compiled,
+		moduleConfig:  moduleConfig,
+		done:          make(chan struct{}),
+	}
+	if a.mailbox == nil {
+		a.mailbox = NewMailbox(cfg.ID, cfg.Mailbox, mailboxMetrics(cfg.Metrics))
+	}
+	a.digest.Store(&digest)
+	if cfg.Soul != nil {
+		a.soul.Store(&soulBinding{soul: cfg.Soul, access: cfg.SoulAccess})
+	}
+	a.recordMemory(module)
+	return a, nil
 }
 
 // Start initializes and starts the agent
 func (a *Agent) Start(ctx context.Context) error {
-	// Call _start function if it exists
-	start := a.module.ExportedFunction("_start")
-	if start != nil {
-		if _, err := start.Call(ctx); err != nil {
-			return fmt.Errorf("failed to call _start: %w", err)
-		}
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+	if err := a.runStartFunctions(ctx, a.module); err != nil {
+		a.finish(err)
+		return err
+	}
+	if !a.deterministic {
+		go a.dispatch()
 	}
 	return nil
 }
 
+// runStartFunctions calls _initialize first if the module is a WASI
+// reactor, then _start, then the on_init lifecycle hook. Callers must hold
+// callMu.
+func (a *Agent) runStartFunctions(ctx context.Context, module api.Module) error {
+	for _, name := range []string{"_initialize", "_start"} {
+		fn := module.ExportedFunction(name)
+		if fn == nil {
+			continue
+		}
+		if _, err := a.invoke(ctx, module, fn); err != nil && !isCleanExit(err) {
+			return fmt.Errorf("failed to call %s: %w", name, err)
+		}
+	}
+	return a.runInit(ctx, module)
+}
+
+// isCleanExit reports whether err is a WASI proc_exit with status zero,
+// which command-style modules raise when _start returns
+func isCleanExit(err error) bool {
+	var exitErr *sys.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 0
+}
+
+// Digest returns the SHA-256 digest of the agent's current code
+func (a *Agent) Digest() string {
+	return *a.digest.Load()
+}
+
+// HasCapability reports whether the agent was granted a capability
+func (a *Agent) HasCapability(c Capability) bool {
+	return a.caps.has(c)
+}
+
+// KV returns the agent's key-value namespace, or nil if none is configured
+func (a *Agent) KV() *Namespace {
+	return a.kv
+}
+
+// FuelUsed returns the total fuel consumed across all invocations
+func (a *Agent) FuelUsed() uint64 {
+	return a.fuelUsed.Load()
+}
+
+// call invokes an exported guest function under the agent's resource limits
+func (a *Agent) call(ctx context.Context, fn api.Function, params ...uint64) ([]uint64, error) {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+	results, err := a.invoke(ctx, a.module, fn, params...)
+	if err != nil && !isCleanExit(err) {
+		a.finish(err)
+	}
+	return results, err
+}
+
+// invoke performs a call of one of module's functions without
+// serialization. Callers must hold callMu.
+func (a *Agent) invoke(ctx context.Context, module api.Module, fn api.Function, params ...uint64) ([]uint64, error) {
+	// Hold the invocation while a debugger has the agent paused
+	debugger := a.debugger.Load()
+	var stepped bool
+	if debugger != nil {
+		var err error
+		if stepped, err = debugger.gate(ctx); err != nil {
+			return nil, fmt.Errorf("failed to call %s: %w", exportName(fn), err)
+		}
+	}
+
+	ctx = withAgent(ctx, a)
+
+	if a.limits.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.limits.CallTimeout)
+		defer cancel()
+	}
+
+	var meter *fuelMeter
+	if a.limits.MaxFuel > 0 {
+		meter = startFuelMeter(module, a.limits.MaxFuel)
+	}
+
+	a.mem.denied.Store(false)
+	start := time.Now()
+	results, err := fn.Call(ctx, params...)
+	elapsed := time.Since(start)
+	a.recordInvocation(elapsed, err)
+
+	var consumed uint64
+	if meter != nil {
+		var exhausted bool
+		consumed, exhausted = meter.stop()
+		if exhausted && err != nil {
+			err = fuelTrap(err)
+		}
+		a.fuelUsed.Add(consumed)
+		if a.metrics != nil {
+			a.metrics.RecordAgentFuel(a.ID, consumed)
+		}
+	}
+	a.recordUsage(consumed, elapsed, err)
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == sys.ExitCodeDeadlineExceeded {
+		err = fmt.Errorf("%w: %s after %s", ErrCallTimeout, exportName(fn), a.limits.CallTimeout)
+	}
+	if err != nil && !isCleanExit(err) {
+		trap := newTrap(exportName(fn), err)
+		a.classifyOOM(trap)
+		a.logTrap(trap)
+		err = trap
+	}
+	if debugger != nil {
+		debugger.observe(exportName(fn), elapsed, err, stepped)
+	}
+	if err == nil {
+		a.notifyMemoryPressure(ctx)
+	}
+
+	return results, err
+}
+
+// exportName returns the name a function is exported under, falling back
+// to its debug name
+func exportName(fn api.Function) string {
+	def := fn.Definition()
+	if names := def.ExportNames(); len(names) > 0 {
+		return names[0]
+	}
+	return def.Name()
+}
+
+// logTrap records a failed invocation with its location in the node log
+func (a *Agent) logTrap(trap *Trap) {
+	if a.logger == nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"agent_id": a.ID,
+		"function": trap.Function,
+		"kind":     string(trap.Kind),
+		"error":    trap.Message,
+	}
+	if loc := trap.Location(); loc != "" {
+		fields["location"] = loc
+	}
+	switch trap.Kind {
+	case TrapTimeout:
+		fields["timeout"] = a.limits.CallTimeout.String()
+	case TrapFuelExhausted:
+		fields["max_fuel"] = a.limits.MaxFuel
+	case TrapExit:
+		fields["exit_code"] = trap.ExitCode
+	case TrapOutOfMemory:
+		fields["max_memory_pages"] = a.limits.MaxMemoryPages
+		fields["memory_policy"] = string(a.limits.MemoryPolicy)
+	}
+	if len(trap.Frames) > 0 {
+		fields["stack_trace"] = trap.StackTrace()
+	}
+	a.logger.AddLog("error", "agent", "agent trapped", fields)
+}
+
 // Stop gracefully shuts down the agent
 func (a *Agent) Stop(ctx context.Context) error {
+	// Release invocations held by a paused debugger so callMu frees up
+	if debugger := a.debugger.Load(); debugger != nil {
+		debugger.detach()
+	}
+
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	if a.stopped {
+		return nil
+	}
+	a.stopped = true
+	a.finish(nil)
+
 	if err := a.module.Close(ctx); err != nil {
 		return fmt.Errorf("failed to close module: %w", err)
 	}
+	if a.pool != nil {
+		return a.pool.release(ctx, a.poolKey)
+	}
 	if err := a.runtime.Close(ctx); err != nil {
 		return fmt.Errorf("failed to close runtime: %w", err)
 	}
 	return nil
 }
 
-// Host functions exposed to WebAssembly modules
+// Done returns a channel that is closed when the instance traps, exits, or
+// is stopped
+func (a *Agent) Done() <-chan struct{} {
+	return a.done
+}
+
+// Err returns the error that crashed the instance, or nil if it is still
+// running or was stopped cleanly
+func (a *Agent) Err() error {
+	select {
+	case <-a.done:
+		return a.crashErr
+	default:
+		return nil
+	}
+}
 
-func hostLog(ctx context.Context, m api.Module, offset, length uint32) {
-	// Implementation for logging from WebAssembly
+// finish records how the instance ended; only the first call has effect
+func (a *Agent) finish(err error) {
+	a.doneOnce.Do(func() {
+		a.crashErr = err
+		close(a.done)
+		// A pool's mailbox outlives any one of its instances
+		if !a.sharesMailbox {
+			a.mailbox.Close()
+		}
+	})
 }
 
-func hostSend(ctx context.Context, m api.Module, targetOffset, targetLength, msgOffset, msgLength uint32) {
-	// Implementation for sending messages between agents
+// Mailbox returns the agent's inbound message queue
+func (a *Agent) Mailbox() *Mailbox {
+	return a.mailbox
 }
 
-func hostGetMemory(ctx context.Context, m api.Module, offset, length uint32) {
-	// Implementation for reading from agent memory
+// audit records a security-relevant guest action in the node log
+func (a *Agent) audit(action string, fields map[string]interface{}) {
+	if a.logger == nil {
+		return
+	}
+	fields["agent_id"] = a.ID
+	fields["action"] = action
+	a.logger.AddLog("info", "audit", "agent "+action, fields)
 }
 
-func hostSetMemory(ctx context.Context, m api.Module, offset, length uint32) {
-	// Implementation for writing to agent memory
+// deliver queues a message according to the mailbox overflow policy
+func (a *Agent) deliver(ctx context.Context, msg Message) error {
+	return a.mailbox.Push(ctx, msg)
 }
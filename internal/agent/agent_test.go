@@ -0,0 +1,2001 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+	"github.com/tetratelabs/wazero"
+)
+
+// allowUnsigned lets tests run the unsigned modules below
+var allowUnsigned = NewArtifactVerifier(true, nil)
+
+// recursiveStartWasm is a module whose exported _start calls itself forever:
+//
+//	(module (func $f (export "_start") call $f))
+var recursiveStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+	0x0a, 0x06, 0x01, 0x04, 0x00, 0x10, 0x00, 0x0b, // code: call 0; end
+}
+
+// noopStartWasm is a module whose exported _start returns immediately:
+//
+//	(module (func (export "_start")))
+var noopStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+	0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b, // code: end
+}
+
+// loopStartWasm is a module whose exported _start spins without calling:
+//
+//	(module (func (export "_start") (loop br 0)))
+var loopStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b, // code: loop br 0 end; end
+}
+
+// tickStatusWasm is a module whose on_tick returns the step as its status:
+//
+//	(module (func (export "on_tick") (param i64) (result i32) local.get 0 i32.wrap_i64))
+var tickStatusWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x06, 0x01, 0x60, 0x01, 0x7e, 0x01, 0x7f, // type section: (i64) -> i32
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x07, 0x0b, 0x01, 0x07, 'o', 'n', '_', 't', 'i', 'c', 'k', 0x00, 0x00, // export "on_tick"
+	0x0a, 0x07, 0x01, 0x05, 0x00, 0x20, 0x00, 0xa7, 0x0b, // code: local.get 0; i32.wrap_i64; end
+}
+
+// growStartWasm is a module with one page of memory whose _start grows it
+// by a page and traps if that fails:
+//
+//	(module (memory 1)
+//	  (func (export "_start")
+//	    (if (i32.eq (memory.grow (i32.const 1)) (i32.const -1)) (then unreachable))))
+var growStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: min 1
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+	0x0a, 0x0f, 0x01, 0x0d, 0x00, // code: one body, no locals
+	0x41, 0x01, 0x40, 0x00, 0x41, 0x7f, 0x46, // i32.const 1; memory.grow; i32.const -1; i32.eq
+	0x04, 0x40, 0x00, 0x0b, 0x0b, // if unreachable end; end
+}
+
+func TestAgent_MemoryPolicy(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		limits  ResourceLimits
+		wantOOM bool
+		pages   uint32
+	}{
+		{"grow within limit", ResourceLimits{MaxMemoryPages: 4}, false, 2},
+		{"grow past limit", ResourceLimits{MaxMemoryPages: 1}, true, 1},
+		{"deny", ResourceLimits{MaxMemoryPages: 4, MemoryPolicy: MemoryDeny}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(ctx, Config{ID: "grower", Code: growStartWasm, Verifier: allowUnsigned}, tt.limits)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer a.Stop(ctx)
+
+			err = a.Start(ctx)
+			if tt.wantOOM {
+				var trap *Trap
+				if !errors.As(err, &trap) || trap.Kind != TrapOutOfMemory {
+					t.Fatalf("Start() error = %v, want out of memory trap", err)
+				}
+				if !errors.Is(err, ErrOutOfMemory) {
+					t.Errorf("Start() error does not match ErrOutOfMemory")
+				}
+			} else if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if got := a.Stats().MemoryPages; got != tt.pages {
+				t.Errorf("MemoryPages = %d, want %d", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestAgent_FuelExhausted(t *testing.T) {
+	ctx := context.Background()
+	// No call deadline: the loop must be stopped by fuel alone
+	limits := ResourceLimits{MaxMemoryPages: 1, MaxFuel: 1000}
+
+	for name, code := range map[string][]byte{"recursion": recursiveStartWasm, "loop": loopStartWasm} {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(ctx, Config{ID: "looper", Code: code, Verifier: allowUnsigned}, limits)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer a.Stop(ctx)
+
+			err = a.Start(ctx)
+			if !errors.Is(err, ErrFuelExhausted) {
+				t.Fatalf("Start() error = %v, want ErrFuelExhausted", err)
+			}
+			var trap *Trap
+			if !errors.As(err, &trap) || trap.Kind != TrapFuelExhausted || len(trap.Frames) == 0 {
+				t.Errorf("Start() error = %#v, want a fuel trap with a stack trace", err)
+			}
+			if used := a.FuelUsed(); used <= limits.MaxFuel || used > limits.MaxFuel+10 {
+				t.Errorf("FuelUsed() = %d, want just over %d", used, limits.MaxFuel)
+			}
+		})
+	}
+}
+
+func TestAgent_FuelPerInstruction(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, Config{ID: "ticker", Code: tickStatusWasm, Verifier: allowUnsigned}, ResourceLimits{MaxMemoryPages: 1, MaxFuel: 100})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// on_tick is local.get, i32.wrap_i64 and end; every call is metered
+	// afresh against the budget
+	for step := uint64(1); step <= 3; step++ {
+		if err := a.Tick(ctx, 0); err != nil {
+			t.Fatalf("Tick() error = %v", err)
+		}
+		if used := a.FuelUsed(); used != 3*step {
+			t.Errorf("FuelUsed() after %d ticks = %d, want %d", step, used, 3*step)
+		}
+	}
+}
+
+func TestInstrumentFuel(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+
+	for name, code := range map[string][]byte{"loop": loopStartWasm, "memory": growStartWasm, "imports": logStartWasm, "wasi": wasiProxyWasm} {
+		t.Run(name, func(t *testing.T) {
+			metered, err := instrumentFuel(code)
+			if err != nil {
+				t.Fatalf("instrumentFuel() error = %v", err)
+			}
+			original, err := r.CompileModule(ctx, code)
+			if err != nil {
+				t.Fatalf("CompileModule() error = %v", err)
+			}
+			compiled, err := r.CompileModule(ctx, metered)
+			if err != nil {
+				t.Fatalf("CompileModule() of the instrumented module error = %v", err)
+			}
+			if got, want := len(compiled.ExportedFunctions()), len(original.ExportedFunctions()); got != want {
+				t.Errorf("instrumented module exports %d functions, want %d", got, want)
+			}
+		})
+	}
+
+	// (module (func (export "_start") i64.const 0 global.set 0))
+	forged := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+		0x03, 0x02, 0x01, 0x00, // function section
+		0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+		0x0a, 0x08, 0x01, 0x06, 0x00, 0x42, 0x00, 0x24, 0x00, 0x0b, // code: i64.const 0; global.set 0; end
+	}
+	if _, err := instrumentFuel(forged); err == nil {
+		t.Errorf("instrumentFuel() accepted code naming the fuel global")
+	}
+}
+
+func TestAgent_CallTimeout(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1, CallTimeout: 50 * time.Millisecond}
+
+	a, err := New(ctx, Config{ID: "spinner", Code: loopStartWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	err = a.Start(ctx)
+	if !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("Start() error = %v, want ErrCallTimeout", err)
+	}
+}
+
+func TestNamespace_Quota(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	ns, err := NewNamespace(store, "agent-1", KVQuota{MaxKeySize: 8, MaxValueSize: 8, MaxKeys: 2, MaxTotalBytes: 64})
+	if err != nil {
+		t.Fatalf("NewNamespace() error = %v", err)
+	}
+	other, err := NewNamespace(store, "agent-10", DefaultKVQuota)
+	if err != nil {
+		t.Fatalf("NewNamespace() error = %v", err)
+	}
+	// A NUL in the ID would let it reach into agent-1's keys
+	if _, err := NewNamespace(store, "agent-1\x00a", DefaultKVQuota); !errors.Is(err, ErrInvalidAgentID) {
+		t.Errorf("NewNamespace() of an ID containing NUL error = %v, want ErrInvalidAgentID", err)
+	}
+
+	if err := ns.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put(a) error = %v", err)
+	}
+	if err := ns.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("Put(b) error = %v", err)
+	}
+	if err := ns.Put([]byte("c"), []byte("3")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put(c) error = %v, want ErrQuotaExceeded", err)
+	}
+	if err := ns.Put([]byte("a"), []byte("too-long-value")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put(a, long) error = %v, want ErrQuotaExceeded", err)
+	}
+
+	// Overwriting and deleting must not count against the key limit
+	if err := ns.Put([]byte("a"), []byte("x")); err != nil {
+		t.Errorf("overwrite Put(a) error = %v", err)
+	}
+	if err := ns.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete(b) error = %v", err)
+	}
+	if err := ns.Put([]byte("c"), []byte("3")); err != nil {
+		t.Errorf("Put(c) after delete error = %v", err)
+	}
+
+	if err := other.Put([]byte("z"), []byte("9")); err != nil {
+		t.Fatalf("other.Put(z) error = %v", err)
+	}
+	keys, err := ns.Keys(nil)
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if len(keys) != 2 || string(keys[0]) != "a" || string(keys[1]) != "c" {
+		t.Errorf("Keys() = %q, want [a c]", keys)
+	}
+}
+
+func TestKVQuota_Defaults(t *testing.T) {
+	store, err := kv.New(kv.Config{Engine: kv.EngineMemory})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// Only the limit that is set overrides the default
+	cfg := Config{ID: "quota", Code: noopStartWasm, Verifier: allowUnsigned, Store: store, KVQuota: KVQuota{MaxKeys: 1}}
+	a, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+	want := DefaultKVQuota
+	want.MaxKeys = 1
+	if got := a.KV().quota; got != want {
+		t.Errorf("quota = %+v, want %+v", got, want)
+	}
+
+	pool, err := NewInstancePool(ctx, cfg, ResourceLimits{MaxMemoryPages: 1}, 1)
+	if err != nil {
+		t.Fatalf("NewInstancePool() error = %v", err)
+	}
+	defer pool.Stop(ctx)
+	if got := pool.Next().KV().quota; got != want {
+		t.Errorf("pool quota = %+v, want %+v", got, want)
+	}
+}
+
+func TestModuleCache_SharedAcrossAgents(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewModuleCache("")
+	if err != nil {
+		t.Fatalf("NewModuleCache() error = %v", err)
+	}
+	defer cache.Close(ctx)
+
+	limits := ResourceLimits{MaxMemoryPages: 1}
+	for _, id := range []string{"copy-1", "copy-2", "copy-3"} {
+		a, err := New(ctx, Config{ID: id, Code: loopStartWasm, Verifier: allowUnsigned, Cache: cache}, limits)
+		if err != nil {
+			t.Fatalf("New(%s) error = %v", id, err)
+		}
+		defer a.Stop(ctx)
+	}
+
+	stats := cache.Stats()
+	if stats.Modules != 1 || stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("Stats() = %+v, want 1 module, 1 miss, 2 hits", stats)
+	}
+}
+
+func TestRuntimePool_SharesByTrustLevel(t *testing.T) {
+	ctx := context.Background()
+	pool := NewRuntimePool(PoolConfig{SharedLevels: []TrustLevel{TrustTrusted}})
+	limits := ResourceLimits{MaxMemoryPages: 1}
+
+	newAgent := func(id string, trust TrustLevel) *Agent {
+		a, err := New(ctx, Config{ID: id, Code: loopStartWasm, Verifier: allowUnsigned, Pool: pool, Trust: trust}, limits)
+		if err != nil {
+			t.Fatalf("New(%s) error = %v", id, err)
+		}
+		return a
+	}
+
+	a1 := newAgent("trusted-1", TrustTrusted)
+	a2 := newAgent("trusted-2", TrustTrusted)
+	u := newAgent("untrusted", TrustUntrusted)
+
+	if a1.runtime != a2.runtime {
+		t.Error("trusted agents should share a runtime")
+	}
+	if u.runtime == a1.runtime {
+		t.Error("untrusted agent should have a dedicated runtime")
+	}
+
+	for _, a := range []*Agent{a1, a2, u} {
+		if err := a.Stop(ctx); err != nil {
+			t.Errorf("Stop(%s) error = %v", a.ID, err)
+		}
+	}
+	if len(pool.runtimes) != 0 {
+		t.Errorf("pool holds %d runtimes after all agents stopped, want 0", len(pool.runtimes))
+	}
+}
+
+func TestAgent_Reload(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1, CallTimeout: 50 * time.Millisecond}
+
+	a, err := New(ctx, Config{ID: "reloader", Code: noopStartWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// A failing reload must leave the running instance in place
+	before := a.module
+	if err := a.Reload(ctx, loopStartWasm, nil); !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("Reload(loop) error = %v, want ErrCallTimeout", err)
+	}
+	if a.module != before || a.Digest() != Digest(noopStartWasm) {
+		t.Error("failed reload replaced the running instance")
+	}
+
+	if err := a.Reload(ctx, noopStartWasm, nil); err != nil {
+		t.Fatalf("Reload(noop) error = %v", err)
+	}
+	if a.module == before {
+		t.Error("successful reload kept the old instance")
+	}
+}
+
+func TestAgent_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1, CallTimeout: 50 * time.Millisecond}
+
+	a, err := New(ctx, Config{ID: "snap", Code: noopStartWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	blob, err := a.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := a.Restore(blob); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	other, err := New(ctx, Config{ID: "other", Code: recursiveStartWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer other.Stop(ctx)
+
+	if err := other.Restore(blob); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("Restore(foreign) error = %v, want ErrSnapshotMismatch", err)
+	}
+	if err := a.Restore(blob[:len(blob)-1]); err == nil {
+		t.Error("Restore(truncated) succeeded")
+	}
+}
+
+func TestAgentSupervisor_RestartsCrashedAgent(t *testing.T) {
+	ctx := context.Background()
+	bus := transport.NewEventBus()
+	defer bus.Close()
+	events := bus.Subscribe(ctx, transport.EventTypeAgent)
+
+	policy := RestartPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxRestarts: 1}
+	s := NewAgentSupervisor(policy, nil, bus, nil)
+	defer s.Close(ctx)
+
+	limits := ResourceLimits{MaxMemoryPages: 1}
+	a, err := s.Supervise(ctx, Config{ID: "worker", Code: noopStartWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("Supervise() error = %v", err)
+	}
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Data["event"] != want {
+				t.Fatalf("event = %v, want %s", ev.Data["event"], want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s event", want)
+		}
+	}
+
+	a.finish(errors.New("trap"))
+	expect("crashed")
+	expect("restarted")
+
+	next, ok := s.Agent("worker")
+	if !ok || next == a {
+		t.Fatal("Agent() did not return a fresh instance")
+	}
+
+	next.finish(errors.New("trap"))
+	expect("crashed")
+	expect("failed")
+
+	if status, _ := s.Status("worker"); status.State != StateFailed || status.Restarts != 1 {
+		t.Errorf("Status() = %+v, want failed after 1 restart", status)
+	}
+}
+
+func TestAgent_Tick(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1}
+
+	a, err := New(ctx, Config{ID: "ticker", Code: tickStatusWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := a.Tick(ctx, 0); err != nil {
+		t.Errorf("Tick(0) error = %v", err)
+	}
+	if err := a.Tick(ctx, 3); !errors.Is(err, ErrHandlerFailed) {
+		t.Errorf("Tick(3) error = %v, want ErrHandlerFailed", err)
+	}
+	if a.Err() != nil {
+		t.Errorf("handler status crashed the agent: %v", a.Err())
+	}
+}
+
+func TestMailbox_Overflow(t *testing.T) {
+	ctx := context.Background()
+	msg := func(payload string) Message { return Message{From: "a", Payload: []byte(payload)} }
+
+	tests := []struct {
+		name    string
+		cfg     MailboxConfig
+		wantErr error
+		want    string // first message left in the queue
+	}{
+		{"reject", MailboxConfig{Size: 1, Overflow: OverflowReject}, ErrInboxFull, "1"},
+		{"drop oldest", MailboxConfig{Size: 1, Overflow: OverflowDropOldest}, nil, "2"},
+		{"block timeout", MailboxConfig{Size: 1, Overflow: OverflowBlock, BlockTimeout: 10 * time.Millisecond}, ErrInboxFull, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMailbox("agent", tt.cfg, nil)
+			if err := m.Push(ctx, msg("1")); err != nil {
+				t.Fatalf("Push(1) error = %v", err)
+			}
+			if err := m.Push(ctx, msg("2")); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Push(2) error = %v, want %v", err, tt.wantErr)
+			}
+			if m.Dropped() != 1 {
+				t.Errorf("Dropped() = %d, want 1", m.Dropped())
+			}
+			got, err := m.Next(ctx)
+			if err != nil || string(got.Payload) != tt.want {
+				t.Errorf("Next() = %q, %v, want %q", got.Payload, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMailbox_CloseWakesBlockedSenders(t *testing.T) {
+	ctx := context.Background()
+	m := NewMailbox("agent", MailboxConfig{Size: 1, Overflow: OverflowBlock, BlockTimeout: time.Minute}, nil)
+	if err := m.Push(ctx, Message{Payload: []byte("0")}); err != nil {
+		t.Fatalf("Push(0) error = %v", err)
+	}
+
+	const senders = 3
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() { errs <- m.Push(ctx, Message{Payload: []byte("blocked")}) }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	m.Close()
+
+	for i := 0; i < senders; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrMailboxClosed) {
+				t.Errorf("blocked Push() error = %v, want ErrMailboxClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d blocked senders still waiting after Close", senders-i, senders)
+		}
+	}
+}
+
+func TestArtifactVerifier_Verify(t *testing.T) {
+	trustedPub, trusted, _ := ed25519.GenerateKey(nil)
+	_, stranger, _ := ed25519.GenerateKey(nil)
+	code := noopStartWasm
+
+	tests := []struct {
+		name          string
+		allowUnsigned bool
+		signature     []byte
+		wantErr       error
+	}{
+		{"trusted signer", false, SignArtifact(trusted, code), nil},
+		{"untrusted signer", false, SignArtifact(stranger, code), ErrUntrustedSigner},
+		{"unsigned rejected", false, nil, ErrUnsignedArtifact},
+		{"unsigned allowed", true, nil, nil},
+		{"bad signature with unsigned allowed", true, SignArtifact(stranger, code), ErrUntrustedSigner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewArtifactVerifier(tt.allowUnsigned, []ed25519.PublicKey{trustedPub})
+			if _, err := v.Verify(code, tt.signature); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_VerifiesArtifact(t *testing.T) {
+	ctx := context.Background()
+	trustedPub, trusted, _ := ed25519.GenerateKey(nil)
+	limits := ResourceLimits{MaxMemoryPages: 1}
+
+	tests := []struct {
+		name      string
+		verifier  *ArtifactVerifier
+		signature []byte
+		wantErr   error
+	}{
+		{"nil verifier rejects unsigned", nil, nil, ErrUnsignedArtifact},
+		{"nil verifier trusts no signer", nil, SignArtifact(trusted, noopStartWasm), ErrUntrustedSigner},
+		{"unsigned disallowed", NewArtifactVerifier(false, []ed25519.PublicKey{trustedPub}), nil, ErrUnsignedArtifact},
+		{"trusted signer", NewArtifactVerifier(false, []ed25519.PublicKey{trustedPub}), SignArtifact(trusted, noopStartWasm), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(ctx, Config{ID: "signed", Code: noopStartWasm, Signature: tt.signature, Verifier: tt.verifier}, limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("New() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer a.Stop(ctx)
+
+			// Reloads are held to the same verifier
+			if err := a.Reload(ctx, noopStartWasm, nil); !errors.Is(err, ErrUnsignedArtifact) {
+				t.Errorf("Reload(unsigned) error = %v, want ErrUnsignedArtifact", err)
+			}
+		})
+	}
+}
+
+func TestManifest(t *testing.T) {
+	doc := []byte(`
+name: greeter
+version: 1.0.0
+capabilities: [log, kv]
+resources:
+  memory_pages: 4
+  call_timeout: 2s
+config_schema:
+  greeting: {type: string, default: hello}
+  retries: {type: number, required: true}
+`)
+
+	// Embed the manifest as a custom section after the module's own sections
+	name := []byte(ManifestSection)
+	section := append([]byte{byte(len(name))}, name...)
+	section = append(section, doc...)
+	code := append(append([]byte{}, noopStartWasm...), 0x00, byte(len(section))|0x80, byte(len(section)>>7))
+	code = append(code, section...)
+
+	m, err := ManifestFromCode(code)
+	if err != nil {
+		t.Fatalf("ManifestFromCode() error = %v", err)
+	}
+	if _, err := ManifestFromCode(noopStartWasm); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("ManifestFromCode(bare) error = %v, want ErrNoManifest", err)
+	}
+
+	limits := m.Limits(DefaultMemoryLimits)
+	if limits.MaxMemoryPages != 4 || limits.CallTimeout != 2*time.Second || limits.MaxFuel != DefaultMemoryLimits.MaxFuel {
+		t.Errorf("Limits() = %+v", limits)
+	}
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{"defaults applied", map[string]interface{}{"retries": 3}, false},
+		{"missing required", map[string]interface{}{}, true},
+		{"wrong type", map[string]interface{}{"retries": "three"}, true},
+		{"unknown key", map[string]interface{}{"retries": 3, "extra": true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.ValidateConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got["greeting"] != "hello" {
+				t.Errorf("ValidateConfig() greeting = %v, want default", got["greeting"])
+			}
+		})
+	}
+}
+
+func TestAgent_Stats(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1}
+
+	a, err := New(ctx, Config{ID: "counted", Code: tickStatusWasm, Verifier: allowUnsigned}, limits)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	a.Tick(ctx, 0)
+	a.Tick(ctx, 1)
+
+	stats := a.Stats()
+	if stats.Invocations != 2 || stats.Failures != 0 {
+		t.Errorf("Stats() invocations = %d, failures = %d, want 2, 0", stats.Invocations, stats.Failures)
+	}
+	if stats.Digest != Digest(tickStatusWasm) {
+		t.Errorf("Stats() digest = %s", stats.Digest)
+	}
+}
+
+func TestOpenModuleCache_PrunesStaleVersions(t *testing.T) {
+	dataDir := t.TempDir()
+	stale := filepath.Join(dataDir, "compilation-cache", "v0.0.1")
+	if err := os.MkdirAll(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := OpenModuleCache(dataDir)
+	if err != nil {
+		t.Fatalf("OpenModuleCache() error = %v", err)
+	}
+	defer cache.Close(context.Background())
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale cache dir still present: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "compilation-cache", RuntimeVersion())); err != nil {
+		t.Errorf("versioned cache dir missing: %v", err)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"under capacity", []string{"ab", "c"}, "abc"},
+		{"exactly full", []string{"abcd"}, "abcd"},
+		{"wraps", []string{"abc", "def"}, "cdef"},
+		{"oversized write", []string{"a", "bcdefg"}, "defg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRingBuffer(4)
+			for _, w := range tt.writes {
+				r.Write([]byte(w))
+			}
+			if got := string(r.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTrap(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantKind TrapKind
+		wantLoc  string
+	}{
+		{
+			name:     "unreachable with debug info",
+			err:      errors.New("wasm error: unreachable\nwasm stack trace:\n\tguest.handle(i32) i32\n\t\t/src/main.rs:12:5\n\tguest.on_message(i32,i32,i32,i32) i32"),
+			wantKind: TrapUnreachable,
+			wantLoc:  "/src/main.rs:12:5",
+		},
+		{
+			name:     "out of bounds without debug info",
+			err:      errors.New("wasm error: out of bounds memory access\nwasm stack trace:\n\tguest.$3()"),
+			wantKind: TrapOutOfBounds,
+			wantLoc:  "guest.$3()",
+		},
+		{
+			name:     "fuel exhausted",
+			err:      fmt.Errorf("%w (recovered by wazero)\nwasm stack trace:\n\tguest.loop()", ErrFuelExhausted),
+			wantKind: TrapFuelExhausted,
+			wantLoc:  "guest.loop()",
+		},
+		{
+			name:     "timeout",
+			err:      fmt.Errorf("%w: _start after 5s", ErrCallTimeout),
+			wantKind: TrapTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trap := newTrap("on_message", tt.err)
+			if trap.Kind != tt.wantKind {
+				t.Errorf("Kind = %s, want %s", trap.Kind, tt.wantKind)
+			}
+			if got := trap.Location(); got != tt.wantLoc {
+				t.Errorf("Location() = %q, want %q", got, tt.wantLoc)
+			}
+			if !errors.Is(trap, tt.err) {
+				t.Error("trap does not wrap the runtime error")
+			}
+		})
+	}
+}
+
+func TestEncodeInitConfig(t *testing.T) {
+	ctx := context.Background()
+	secrets := SecretMap{"api-key": "s3cret"}
+
+	data, err := EncodeInitConfig(ctx, secrets, map[string]interface{}{
+		"endpoint": "https://example.com",
+		"key":      "secret:api-key",
+		"retries":  3,
+	})
+	if err != nil {
+		t.Fatalf("EncodeInitConfig() error = %v", err)
+	}
+	want := `{"endpoint":"https://example.com","key":"s3cret","retries":3}`
+	if string(data) != want {
+		t.Errorf("EncodeInitConfig() = %s, want %s", data, want)
+	}
+
+	_, err = EncodeInitConfig(ctx, secrets, map[string]interface{}{"key": "secret:missing"})
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("EncodeInitConfig(missing) error = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestTopicACL(t *testing.T) {
+	acl := TopicACL{
+		Publish:   []string{"matrix/*", "chat"},
+		Subscribe: []string{"*", "matrix/*"},
+	}
+
+	tests := []struct {
+		topic         string
+		wantPublish   bool
+		wantSubscribe bool
+	}{
+		{"chat", true, true},
+		{"matrix/world", true, true},
+		{"matrix/world/deep", false, false},
+		{"admin", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			if got := acl.CanPublish(tt.topic); got != tt.wantPublish {
+				t.Errorf("CanPublish() = %v, want %v", got, tt.wantPublish)
+			}
+			if got := acl.CanSubscribe(tt.topic); got != tt.wantSubscribe {
+				t.Errorf("CanSubscribe() = %v, want %v", got, tt.wantSubscribe)
+			}
+		})
+	}
+
+	if (TopicACL{}).CanPublish("chat") {
+		t.Error("empty ACL allowed publishing")
+	}
+}
+
+func TestInstancePool_RoundRobin(t *testing.T) {
+	ctx := context.Background()
+	rtPool := NewRuntimePool(PoolConfig{SharedLevels: []TrustLevel{TrustTrusted}})
+	cfg := Config{ID: "worker", Code: tickStatusWasm, Verifier: allowUnsigned, Pool: rtPool, Trust: TrustTrusted}
+
+	p, err := NewInstancePool(ctx, cfg, ResourceLimits{MaxMemoryPages: 1}, 3)
+	if err != nil {
+		t.Fatalf("NewInstancePool() error = %v", err)
+	}
+	defer p.Stop(ctx)
+
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for step := 0; step < 6; step++ {
+		if err := p.Tick(ctx, 0); err != nil {
+			t.Fatalf("Tick() error = %v", err)
+		}
+	}
+
+	for _, st := range p.Stats() {
+		if st.Invocations != 2 {
+			t.Errorf("instance %d invocations = %d, want 2", st.Instance, st.Invocations)
+		}
+	}
+	if p.Instances()[0].Mailbox() != p.Instances()[2].Mailbox() {
+		t.Error("instances do not share a mailbox")
+	}
+}
+
+// testModule assembles a core module from its sections, each given as
+// its ID and vector entries
+func testModule(sections ...[]byte) []byte {
+	return append([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, bytes.Join(sections, nil)...)
+}
+
+// testSection encodes a section holding a vector of entries
+func testSection(id byte, entries ...[]byte) []byte {
+	payload := appendULEB128(nil, uint64(len(entries)))
+	for _, e := range entries {
+		payload = append(payload, e...)
+	}
+	return append(appendULEB128([]byte{id}, uint64(len(payload))), payload...)
+}
+
+// testBody encodes a function body without locals
+func testBody(code ...byte) []byte {
+	body := append(append([]byte{0x00}, code...), 0x0b)
+	return append(appendULEB128(nil, uint64(len(body))), body...)
+}
+
+// testComponent wraps core modules in a component binary
+func testComponent(modules ...[]byte) []byte {
+	component := []byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}
+	for _, m := range modules {
+		component = append(appendULEB128(append(component, 0x01), uint64(len(m))), m...)
+	}
+	return component
+}
+
+// componentCoreWasm is the core module of a component targeting the
+// stateful-agent world, laid out as wit-bindgen would:
+//
+//	(module
+//	  (import "matrix:agent/host@0.1.0" "log" (func $log (param i32 i32)))
+//	  (import "matrix:agent/host@0.1.0" "kv-get" (func $kv-get (param i32 i32 i32)))
+//	  (memory (export "memory") 1)
+//	  (global $heap (mut i32) (i32.const 1024))
+//	  ;; A bump allocator
+//	  (func (export "cabi_realloc") (param i32 i32 i32 i32) (result i32) ...)
+//	  ;; Logs its data
+//	  (func (export "on-init") (param i32 i32) (result i32) ...)
+//	  ;; Looks up the payload as a key, logging the value or returning the status
+//	  (func (export "on-message") (param i32 i32 i32 i32) (result i32) ...)
+//	  ;; Returns "saved" through a return area at 32, then logs "freed"
+//	  (func (export "state-save") (result i32) ...)
+//	  (func (export "cabi_post_state-save") (param i32) ...)
+//	  ;; Logs the state
+//	  (func (export "state-load") (param i32 i32) ...)
+//	  (data (i32.const 32) "\28\00\00\00\05\00\00\00")
+//	  (data (i32.const 40) "saved") (data (i32.const 48) "freed"))
+var componentCoreWasm = testModule(
+	testSection(1, // types
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x00},                   // 0: (i32, i32) -> ()
+		[]byte{0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x00},             // 1: (i32, i32, i32) -> ()
+		[]byte{0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f}, // 2: (i32, i32, i32, i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f},             // 3: (i32, i32) -> i32
+		[]byte{0x60, 0x00, 0x01, 0x7f},                         // 4: () -> i32
+		[]byte{0x60, 0x01, 0x7f, 0x00},                         // 5: (i32) -> ()
+	),
+	testSection(2, // imports
+		append(append(wasmName(HostInterface), wasmName("log")...), 0x00, 0x00),
+		append(append(wasmName(HostInterface), wasmName("kv-get")...), 0x00, 0x01),
+	),
+	testSection(3, []byte{0x02}, []byte{0x03}, []byte{0x02}, []byte{0x04}, []byte{0x05}, []byte{0x00}),
+	testSection(5, []byte{0x00, 0x01}),                         // memory: min 1
+	testSection(6, []byte{0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b}), // global: mut i32 = 1024
+	testSection(7, // exports
+		append(wasmName("memory"), 0x02, 0x00),
+		append(wasmName("cabi_realloc"), 0x00, 0x02),
+		append(wasmName("on-init"), 0x00, 0x03),
+		append(wasmName("on-message"), 0x00, 0x04),
+		append(wasmName("state-save"), 0x00, 0x05),
+		append(wasmName("cabi_post_state-save"), 0x00, 0x06),
+		append(wasmName("state-load"), 0x00, 0x07),
+	),
+	testSection(10, // code
+		// global.get 0; global.get 0; local.get 3; i32.add; global.set 0
+		testBody(0x23, 0x00, 0x23, 0x00, 0x20, 0x03, 0x6a, 0x24, 0x00),
+		// local.get 0; local.get 1; call $log; i32.const 0
+		testBody(0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x41, 0x00),
+		// kv-get into a return area at 16; if its discriminant is set,
+		// return the status at 20, else log the list at 20
+		testBody(
+			0x20, 0x02, 0x20, 0x03, 0x41, 0x10, 0x10, 0x01,
+			0x41, 0x10, 0x2d, 0x00, 0x00, 0x04, 0x7f,
+			0x41, 0x14, 0x2d, 0x00, 0x00,
+			0x05,
+			0x41, 0x14, 0x28, 0x02, 0x00, 0x41, 0x18, 0x28, 0x02, 0x00, 0x10, 0x00, 0x41, 0x00,
+			0x0b,
+		),
+		testBody(0x41, 0x20),                         // i32.const 32
+		testBody(0x41, 0x30, 0x41, 0x05, 0x10, 0x00), // log "freed"
+		testBody(0x20, 0x00, 0x20, 0x01, 0x10, 0x00), // log the state
+	),
+	testSection(11, // data
+		[]byte{0x00, 0x41, 0x20, 0x0b, 0x08, 0x28, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00},
+		append([]byte{0x00, 0x41, 0x28, 0x0b}, wasmName("saved")...),
+		append([]byte{0x00, 0x41, 0x30, 0x0b}, wasmName("freed")...),
+	),
+)
+
+func TestAgent_Component(t *testing.T) {
+	ctx := context.Background()
+	store, err := kv.New(kv.Config{Engine: kv.EngineMemory})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	// A shim module without memory precedes the main module, as component
+	// tooling emits them
+	component := testComponent(noopStartWasm, componentCoreWasm)
+	if !IsComponent(component) || IsComponent(componentCoreWasm) {
+		t.Fatal("IsComponent() misclassified the fixtures")
+	}
+
+	logger := &recordingLogger{}
+	a, err := New(ctx, Config{
+		ID:           "component",
+		Code:         component,
+		Verifier:     allowUnsigned,
+		Logger:       logger,
+		Store:        store,
+		InitData:     []byte("hello"),
+		Capabilities: []Capability{CapabilityLog, CapabilityKV},
+	}, ResourceLimits{MaxMemoryPages: 1, MaxFuel: 1 << 20})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+	if a.Digest() != Digest(component) {
+		t.Errorf("Digest() = %s, want the component's", a.Digest())
+	}
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if err := a.KV().Put([]byte("greeting"), []byte("hi there")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := a.HandleMessage(ctx, Message{From: "peer", Payload: []byte("greeting")}); err != nil {
+		t.Errorf("HandleMessage() error = %v", err)
+	}
+	err = a.HandleMessage(ctx, Message{From: "peer", Payload: []byte("missing")})
+	if !errors.Is(err, ErrHandlerFailed) || !strings.Contains(err.Error(), fmt.Sprintf("status %d", StatusNotFound)) {
+		t.Errorf("HandleMessage(missing key) error = %v, want not-found status", err)
+	}
+
+	// State crosses the reload through state-save's return area, which
+	// its post-return function then frees
+	if err := a.Reload(ctx, component, nil); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	var got []string
+	for _, entry := range logger.entries {
+		if entry["level"] == "info" && entry["message"] != "agent reloaded" {
+			got = append(got, entry["message"].(string))
+		}
+	}
+	want := []string{"hello", "hi there", "freed", "hello", "saved"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("guest logged %q, want %q", got, want)
+	}
+}
+
+func TestUnwrapComponent(t *testing.T) {
+	withMemory := func(imports ...[]byte) []byte {
+		sections := [][]byte{testSection(1, []byte{0x60, 0x00, 0x00})}
+		if len(imports) > 0 {
+			sections = append(sections, testSection(2, imports...))
+		}
+		sections = append(sections,
+			testSection(5, []byte{0x00, 0x01}),
+			testSection(7, append(wasmName("memory"), 0x02, 0x00)))
+		return testModule(sections...)
+	}
+	importFunc := func(module, name string) []byte {
+		return append(append(wasmName(module), wasmName(name)...), 0x00, 0x00)
+	}
+
+	tests := []struct {
+		name    string
+		code    []byte
+		wantErr string
+	}{
+		{"no core module", testComponent(), "no core module"},
+		{"no memory", testComponent(noopStartWasm), "no core module"},
+		{"two main modules", testComponent(withMemory(), withMemory()), "more than one"},
+		{"wasi", testComponent(withMemory(importFunc("wasi_snapshot_preview1", "fd_write"))), ""},
+		{"undeclared host function", testComponent(withMemory(importFunc(HostInterface, "teleport"))), "does not declare"},
+		{"other interface", testComponent(withMemory(importFunc("acme:widgets/api", "spin"))), "acme:widgets/api#spin"},
+		{"truncated", testComponent(withMemory())[:20], "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, component, err := coreModule(tt.code)
+			if tt.wantErr == "" {
+				if err != nil || !component || IsComponent(core) {
+					t.Errorf("coreModule() = component %v, error %v; want the core module", component, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrComponentUnsupported) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("coreModule() error = %v, want ErrComponentUnsupported mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Core modules pass through untouched
+	if core, component, err := coreModule(noopStartWasm); err != nil || component || !bytes.Equal(core, noopStartWasm) {
+		t.Errorf("coreModule(core module) = %v, %v; want it unchanged", component, err)
+	}
+}
+
+func TestComponentHostFunctions_MatchWIT(t *testing.T) {
+	ctx := context.Background()
+	if hostInterface.module != HostInterface {
+		t.Errorf("HostWIT declares %s, want %s", hostInterface.module, HostInterface)
+	}
+
+	all := make(capabilitySet, len(knownCapabilities))
+	for c := range knownCapabilities {
+		all[c] = true
+	}
+	r, err := newRuntime(ctx, 1, nil, all)
+	if err != nil {
+		t.Fatalf("newRuntime() error = %v", err)
+	}
+	defer r.Close(ctx)
+
+	defs := r.Module(HostInterface).ExportedFunctionDefinitions()
+	if len(defs) != len(hostInterface.funcs) {
+		t.Errorf("bound %d functions, HostWIT declares %d", len(defs), len(hostInterface.funcs))
+	}
+	for name, fn := range hostInterface.funcs {
+		def, ok := defs[name]
+		if !ok {
+			t.Errorf("%s has no binding", name)
+			continue
+		}
+		params, results := fn.coreSignature()
+		if fmt.Sprint(def.ParamTypes()) != fmt.Sprint(params) || fmt.Sprint(def.ResultTypes()) != fmt.Sprint(results) {
+			t.Errorf("%s bound as %v -> %v, canonical ABI lowers it to %v -> %v",
+				name, def.ParamTypes(), def.ResultTypes(), params, results)
+		}
+	}
+}
+
+// logStartWasm is a module whose _start logs "hello":
+//
+//	(module (import "env" "log" (func $log (param i32 i32))) (memory 1)
+//	  (data (i32.const 0) "hello")
+//	  (func (export "_start") (call $log (i32.const 0) (i32.const 5))))
+var logStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x09, 0x02, 0x60, 0x02, 0x7f, 0x7f, 0x00, 0x60, 0x00, 0x00, // type section: (i32, i32) -> (), () -> ()
+	0x02, 0x0b, 0x01, 0x03, 'e', 'n', 'v', 0x03, 'l', 'o', 'g', 0x00, 0x00, // import env.log
+	0x03, 0x02, 0x01, 0x01, // function section
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: min 1
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x01, // export "_start"
+	0x0a, 0x0a, 0x01, 0x08, 0x00, 0x41, 0x00, 0x41, 0x05, 0x10, 0x00, 0x0b, // code: i32.const 0; i32.const 5; call 0; end
+	0x0b, 0x0b, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x05, 'h', 'e', 'l', 'l', 'o', // data: "hello" at 0
+}
+
+// wasiProxyWasm exports forwarders for a few WASI functions so tests can
+// drive WASI as the guest sees it:
+//
+//	(module
+//	  (import "wasi_snapshot_preview1" "path_open" (func $path_open ...))
+//	  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read ...))
+//	  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get ...))
+//	  (import "wasi_snapshot_preview1" "environ_get" (func $environ_get ...))
+//	  (memory (export "memory") 1)
+//	  (func (export "path_open") ... (call $path_open (local.get 0) ... (local.get 8)))
+//	  ...)
+var wasiProxyWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x1c, 0x03, // type section: 3 types
+	0x60, 0x09, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7e, 0x7e, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32, i32, i32, i64, i64, i32, i32) -> i32
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32, i32) -> i32
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32) -> i32
+	0x02, 0x95, 0x01, 0x04, // import section: 4 imports
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1', 0x09, 'p', 'a', 't', 'h', '_', 'o', 'p', 'e', 'n', 0x00, 0x00,
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1', 0x07, 'f', 'd', '_', 'r', 'e', 'a', 'd', 0x00, 0x01,
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1', 0x11, 'e', 'n', 'v', 'i', 'r', 'o', 'n', '_', 's', 'i', 'z', 'e', 's', '_', 'g', 'e', 't', 0x00, 0x02,
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1', 0x0b, 'e', 'n', 'v', 'i', 'r', 'o', 'n', '_', 'g', 'e', 't', 0x00, 0x02,
+	0x03, 0x05, 0x04, 0x00, 0x01, 0x02, 0x02, // function section
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: min 1
+	0x07, 0x42, 0x05, // export section: memory and a forwarder per import
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x09, 'p', 'a', 't', 'h', '_', 'o', 'p', 'e', 'n', 0x00, 0x04,
+	0x07, 'f', 'd', '_', 'r', 'e', 'a', 'd', 0x00, 0x05,
+	0x11, 'e', 'n', 'v', 'i', 'r', 'o', 'n', '_', 's', 'i', 'z', 'e', 's', '_', 'g', 'e', 't', 0x00, 0x06,
+	0x0b, 'e', 'n', 'v', 'i', 'r', 'o', 'n', '_', 'g', 'e', 't', 0x00, 0x07,
+	0x0a, 0x37, 0x04, // code section: local.get each param; call the import; end
+	0x16, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x20, 0x03, 0x20, 0x04, 0x20, 0x05, 0x20, 0x06, 0x20, 0x07, 0x20, 0x08, 0x10, 0x00, 0x0b,
+	0x0c, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x20, 0x03, 0x10, 0x01, 0x0b,
+	0x08, 0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x02, 0x0b,
+	0x08, 0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x03, 0x0b,
+}
+
+func TestHostSoulGetValue(t *testing.T) {
+	ctx := context.Background()
+	s := soul.New("soul-1")
+	s.SetValue("curiosity", 0.75)
+
+	tests := []struct {
+		name   string
+		caps   []Capability
+		access SoulAccess
+		key    string
+		want   uint32
+	}{
+		{"granted", []Capability{CapabilitySoul}, SoulAccess{Values: true}, "curiosity", StatusOK},
+		{"missing value", []Capability{CapabilitySoul}, SoulAccess{Values: true}, "fear", StatusNotFound},
+		{"denied by policy", []Capability{CapabilitySoul}, SoulAccess{Recall: true}, "curiosity", StatusRejected},
+		{"no capability", nil, SoulAccess{Values: true}, "curiosity", StatusUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{ID: "souled", Code: growStartWasm, Verifier: allowUnsigned, Capabilities: tt.caps, Soul: s, SoulAccess: tt.access}
+			a, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer a.Stop(ctx)
+
+			mem := a.module.Memory()
+			mem.Write(0, []byte(tt.key))
+			got := hostSoulGetValue(withAgent(ctx, a), a.module, 0, uint32(len(tt.key)), 64)
+			if got != tt.want {
+				t.Fatalf("hostSoulGetValue() = %d, want %d", got, tt.want)
+			}
+			if got == StatusOK {
+				if v, _ := mem.ReadFloat64Le(64); v != 0.75 {
+					t.Errorf("value = %v, want 0.75", v)
+				}
+			}
+		})
+	}
+}
+
+func TestAgent_BindSoul(t *testing.T) {
+	ctx := context.Background()
+	s := soul.New("shared")
+	newAgent := func(id string, access SoulAccess) *Agent {
+		cfg := Config{ID: id, Code: growStartWasm, Verifier: allowUnsigned, Capabilities: []Capability{CapabilitySoul}}
+		a, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		a.BindSoul(s, access)
+		a.module.Memory().Write(0, []byte("mood"))
+		return a
+	}
+	writer := newAgent("writer", SoulAccess{Values: true, Write: true})
+	defer writer.Stop(ctx)
+	reader := newAgent("reader", SoulAccess{Values: true})
+	defer reader.Stop(ctx)
+
+	if got := hostSoulSetValue(withAgent(ctx, writer), writer.module, 0, 4, 0.5); got != StatusOK {
+		t.Fatalf("writer hostSoulSetValue() = %d, want StatusOK", got)
+	}
+	if got := hostSoulSetValue(withAgent(ctx, reader), reader.module, 0, 4, 1); got != StatusRejected {
+		t.Errorf("reader hostSoulSetValue() = %d, want StatusRejected", got)
+	}
+	if got := hostSoulSetValue(withAgent(ctx, writer), writer.module, 0, 4, math.NaN()); got != StatusInvalidArgument {
+		t.Errorf("hostSoulSetValue(NaN) = %d, want StatusInvalidArgument", got)
+	}
+
+	// The reader sees the writer's value through the shared soul
+	if got := hostSoulGetValue(withAgent(ctx, reader), reader.module, 0, 4, 64); got != StatusOK {
+		t.Fatalf("reader hostSoulGetValue() = %d, want StatusOK", got)
+	}
+	if v, _ := reader.module.Memory().ReadFloat64Le(64); v != 0.5 {
+		t.Errorf("value = %v, want 0.5", v)
+	}
+	history := s.GetHistory()
+	if len(history) != 1 || history[0].Actor != "writer" {
+		t.Errorf("history = %+v, want one change by writer", history)
+	}
+
+	reader.UnbindSoul()
+	if _, _, bound := reader.Soul(); bound {
+		t.Error("Soul() reports a binding after UnbindSoul")
+	}
+	if got := hostSoulGetValue(withAgent(ctx, reader), reader.module, 0, 4, 64); got != StatusUnavailable {
+		t.Errorf("unbound hostSoulGetValue() = %d, want StatusUnavailable", got)
+	}
+}
+
+func TestAgent_DeterministicStep(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1}
+	newAgent := func(id string) *Agent {
+		a, err := New(ctx, Config{ID: id, Code: tickStatusWasm, Verifier: allowUnsigned, Deterministic: &DeterministicConfig{Seed: 42}}, limits)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if err := a.Start(ctx); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		return a
+	}
+	a, b := newAgent("a"), newAgent("b")
+	defer a.Stop(ctx)
+	defer b.Stop(ctx)
+
+	ra, rb := make([]byte, 16), make([]byte, 16)
+	a.clock.Read(ra)
+	b.clock.Read(rb)
+	if string(ra) != string(rb) {
+		t.Errorf("random streams differ for the same seed")
+	}
+
+	if err := a.deliver(ctx, Message{From: "b", To: "a"}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := a.Mailbox().Len(); n != 1 {
+		t.Fatalf("Mailbox().Len() = %d before Step, want 1", n)
+	}
+
+	now := time.Unix(100, 0)
+	if err := a.Step(ctx, 0, now); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if n := a.Mailbox().Len(); n != 0 {
+		t.Errorf("Mailbox().Len() = %d after Step, want 0", n)
+	}
+	if got := a.clock.Now(); !got.Equal(now) {
+		t.Errorf("clock = %v, want %v", got, now)
+	}
+}
+
+func TestDebugger_PauseStep(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, Config{ID: "debugged", Code: tickStatusWasm, Verifier: allowUnsigned}, ResourceLimits{MaxMemoryPages: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer a.Stop(ctx)
+
+	d := a.Debug()
+	if _, err := d.Step(ctx); !errors.Is(err, ErrNotPaused) {
+		t.Fatalf("Step() on running agent error = %v, want ErrNotPaused", err)
+	}
+	if err := d.Watch("counter"); !errors.Is(err, ErrUnknownGlobal) {
+		t.Errorf("Watch() error = %v, want ErrUnknownGlobal", err)
+	}
+
+	d.Pause()
+	ticked := make(chan error, 1)
+	go func() { ticked <- a.Tick(ctx, 0) }()
+
+	select {
+	case err := <-ticked:
+		t.Fatalf("Tick() returned %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	res, err := d.Step(ctx)
+	if err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if res.Function != ExportOnTick || res.Err != nil {
+		t.Errorf("Step() = %+v, want clean on_tick", res)
+	}
+	if err := <-ticked; err != nil {
+		t.Errorf("Tick() error = %v", err)
+	}
+	if !d.Paused() {
+		t.Errorf("Paused() = false after Step")
+	}
+}
+
+func TestUsageLedger_Report(t *testing.T) {
+	l := NewUsageLedger(time.Hour, 0)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.Record("a", base.Add(10*time.Minute), 100, time.Millisecond, false)
+	l.Record("a", base.Add(20*time.Minute), 50, time.Millisecond, true)
+	l.Record("b", base.Add(30*time.Minute), 7, time.Millisecond, false)
+	l.Record("a", base.Add(90*time.Minute), 25, time.Millisecond, false)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		agentID  string
+		windows  int
+		totals   map[string]uint64
+	}{
+		{"all", base, base.Add(2 * time.Hour), "", 3, map[string]uint64{"a": 175, "b": 7}},
+		{"first window", base, base.Add(time.Hour), "", 2, map[string]uint64{"a": 150, "b": 7}},
+		{"one agent", base, base.Add(2 * time.Hour), "a", 2, map[string]uint64{"a": 175}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := l.Report(tt.from, tt.to, tt.agentID)
+			if len(r.Windows) != tt.windows {
+				t.Errorf("len(Windows) = %d, want %d", len(r.Windows), tt.windows)
+			}
+			if len(r.Totals) != len(tt.totals) {
+				t.Errorf("len(Totals) = %d, want %d", len(r.Totals), len(tt.totals))
+			}
+			for id, fuel := range tt.totals {
+				if got := r.Totals[id].Fuel; got != fuel {
+					t.Errorf("Totals[%s].Fuel = %d, want %d", id, got, fuel)
+				}
+			}
+		})
+	}
+	if got := l.Report(base, base.Add(time.Hour), "a").Totals["a"].Failures; got != 1 {
+		t.Errorf("Failures = %d, want 1", got)
+	}
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		env  Envelope
+	}{
+		{"message", Envelope{Version: 1, Kind: EnvelopeMessage, From: "a", To: "b", Payload: []byte("hi"), Timestamp: 42}},
+		{"log", Envelope{Version: 1, Kind: EnvelopeLog, Level: "warn", Payload: []byte("careful")}},
+		{"config", Envelope{Version: 1, Kind: EnvelopeConfig, Config: map[string][]byte{"rate": []byte("5"), "name": []byte(`"x"`)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := MarshalEnvelope(tt.env)
+			got, err := UnmarshalEnvelope(data)
+			if err != nil {
+				t.Fatalf("UnmarshalEnvelope() error = %v", err)
+			}
+			if !bytes.Equal(MarshalEnvelope(got), data) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.env)
+			}
+		})
+	}
+
+	// Unknown fields from newer guests are skipped
+	data := MarshalEnvelope(Envelope{Version: 1, Kind: EnvelopeLog})
+	data = append(data, 0x48, 0x01) // field 9, varint 1
+	binary.LittleEndian.PutUint32(data, uint32(len(data)-4))
+	if env, err := UnmarshalEnvelope(data); err != nil || env.Kind != EnvelopeLog {
+		t.Errorf("UnmarshalEnvelope() with unknown field = %+v, %v", env, err)
+	}
+
+	if _, err := UnmarshalEnvelope(data[:len(data)-1]); err == nil {
+		t.Errorf("UnmarshalEnvelope() accepted a truncated envelope")
+	}
+}
+
+// recordingLogger keeps the entries agents log
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (l *recordingLogger) AddLog(level, component, message string, fields map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := map[string]interface{}{"level": level, "component": component, "message": message}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func TestHTTPFetcher_CheckURL(t *testing.T) {
+	f := newHTTPFetcher(HTTPPolicy{AllowedDomains: []string{"api.example.com", "*.Example.org"}})
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://api.example.com/v1", true},
+		{"http://API.example.com:8080/", true},
+		{"https://example.com/", false},
+		{"https://evil.api.example.com/", false},
+		{"https://api.example.com.evil.net/", false},
+		{"https://example.org/", true},
+		{"https://a.b.example.org/", true},
+		{"https://badexample.org/", false},
+		{"ftp://api.example.com/", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		err := f.checkURL(u)
+		if (err == nil) != tt.want {
+			t.Errorf("checkURL(%s) error = %v, want allowed %v", tt.url, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, ErrDomainNotAllowed) {
+			t.Errorf("checkURL(%s) error = %v, want ErrDomainNotAllowed", tt.url, err)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	if !l.allow() || !l.allow() {
+		t.Fatal("allow() denied within the burst")
+	}
+	if l.allow() {
+		t.Error("allow() permitted a third request in the same instant")
+	}
+	// Half a minute refills one token at two per minute
+	l.last = l.last.Add(-30 * time.Second)
+	if !l.allow() {
+		t.Error("allow() denied after the bucket refilled")
+	}
+	if l.allow() {
+		t.Error("allow() permitted more than the refill")
+	}
+
+	unlimited := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow() {
+			t.Fatal("allow() denied with limiting disabled")
+		}
+	}
+}
+
+func TestHTTPFetcher_Fetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		fmt.Fprint(w, "hello")
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 65))
+	})
+	mux.HandleFunc("/exact", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 64))
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	// The same server under a host name outside the allowlist
+	outside := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	mux.HandleFunc("/escape", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, outside+"/ok", http.StatusFound)
+	})
+
+	policy := HTTPPolicy{AllowedDomains: []string{"127.0.0.1"}, MaxResponseBytes: 64, Timeout: 10 * time.Second}
+	tests := []struct {
+		path    string
+		wantErr error
+		body    string
+	}{
+		{"/ok", nil, "hello"},
+		{"/exact", nil, strings.Repeat("x", 64)},
+		{"/big", ErrResponseTooLarge, ""},
+		{"/hop", nil, "hello"},
+		{"/escape", ErrDomainNotAllowed, ""},
+		{"/loop", errTooManyRedirects, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f := newHTTPFetcher(policy)
+			resp, err := f.fetch(context.Background(), HTTPRequest{URL: server.URL + tt.path})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("fetch() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if resp.Status != http.StatusOK || string(resp.Body) != tt.body {
+				t.Errorf("fetch() = %d %q, want 200 %q", resp.Status, resp.Body, tt.body)
+			}
+			if f.lastResult() == nil {
+				t.Errorf("lastResult() = nil after a successful fetch")
+			}
+		})
+	}
+
+	t.Run("call deadline", func(t *testing.T) {
+		f := newHTTPFetcher(policy)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if _, err := f.fetch(ctx, HTTPRequest{URL: server.URL + "/slow"}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("fetch() error = %v, want context.DeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("fetch() took %v, past the call deadline", elapsed)
+		}
+	})
+
+	t.Run("policy timeout", func(t *testing.T) {
+		f := newHTTPFetcher(HTTPPolicy{AllowedDomains: policy.AllowedDomains, MaxResponseBytes: 64, Timeout: 50 * time.Millisecond})
+		if _, err := f.fetch(context.Background(), HTTPRequest{URL: server.URL + "/slow"}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("fetch() error = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		logger := &recordingLogger{}
+		cfg := Config{
+			ID:           "fetcher",
+			Code:         growStartWasm,
+			Verifier:     allowUnsigned,
+			Logger:       logger,
+			HTTP:         policy,
+			Capabilities: []Capability{CapabilityHTTP},
+		}
+		ctx := context.Background()
+		a, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		defer a.Stop(ctx)
+
+		req := []byte(`{"url":"` + server.URL + `/ok?token=secret"}`)
+		a.module.Memory().Write(0, req)
+		if n := hostHTTPFetch(withAgent(ctx, a), a.module, 0, uint32(len(req))); n <= 0 {
+			t.Fatalf("hostHTTPFetch() = %d", n)
+		}
+		if len(logger.entries) != 1 {
+			t.Fatalf("logged %d entries, want 1", len(logger.entries))
+		}
+		entry := logger.entries[0]
+		if entry["action"] != "http_fetch" || entry["url"] != server.URL+"/ok?token=REDACTED" {
+			t.Errorf("audit entry = %v, want the fetch with its query redacted", entry)
+		}
+	})
+}
+
+func TestKeyStore_Sealing(t *testing.T) {
+	store, err := kv.New(kv.Config{Engine: kv.EngineMemory})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+	master := bytes.Repeat([]byte{0x42}, MasterKeySize)
+
+	keys, err := NewKeyStore(store, master)
+	if err != nil {
+		t.Fatalf("NewKeyStore() error = %v", err)
+	}
+	priv, err := keys.PrivateKey("signer")
+	if err != nil {
+		t.Fatalf("PrivateKey() error = %v", err)
+	}
+	stored, _ := store.Get([]byte("agentkey/signer"))
+	if len(stored) == 0 || bytes.Contains(stored, priv.Seed()) {
+		t.Fatalf("stored key %x holds the plaintext seed", stored)
+	}
+
+	// A fresh store with the same master key opens the sealed seed
+	reopened, _ := NewKeyStore(store, master)
+	pub, err := reopened.PublicKey("signer")
+	if err != nil || !pub.Equal(priv.Public()) {
+		t.Errorf("PublicKey() after reopening = %x, %v, want %x", pub, err, priv.Public())
+	}
+	unkeyed, _ := NewKeyStore(store, nil)
+	if _, err := unkeyed.PublicKey("signer"); !errors.Is(err, ErrKeySealed) {
+		t.Errorf("PublicKey() without a master key error = %v, want ErrKeySealed", err)
+	}
+	wrong, _ := NewKeyStore(store, bytes.Repeat([]byte{0x24}, MasterKeySize))
+	if _, err := wrong.PublicKey("signer"); err == nil {
+		t.Errorf("PublicKey() with the wrong master key succeeded")
+	}
+	if _, err := NewKeyStore(store, master[:16]); err == nil {
+		t.Errorf("NewKeyStore() accepted a short master key")
+	}
+
+	// Seeds stored in plaintext are sealed when next loaded
+	_, legacy, _ := ed25519.GenerateKey(nil)
+	store.Put([]byte("agentkey/legacy"), legacy.Seed())
+	migrated, _ := NewKeyStore(store, master)
+	if got, err := migrated.PrivateKey("legacy"); err != nil || !got.Equal(legacy) {
+		t.Fatalf("PrivateKey() of a plaintext seed = %v, want the stored key", err)
+	}
+	if stored, _ := store.Get([]byte("agentkey/legacy")); bytes.Contains(stored, legacy.Seed()) {
+		t.Errorf("plaintext seed was not sealed on load")
+	}
+}
+
+func TestHostSignVerify(t *testing.T) {
+	ctx := context.Background()
+	keys, err := NewKeyStore(nil, nil)
+	if err != nil {
+		t.Fatalf("NewKeyStore() error = %v", err)
+	}
+	cfg := Config{ID: "signer", Code: growStartWasm, Verifier: allowUnsigned, Keys: keys, Capabilities: []Capability{CapabilityCrypto}}
+	a, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+	ctx = withAgent(ctx, a)
+
+	const pubOffset, msgOffset, sigOffset = 0, 64, 128
+	msg := []byte("move north")
+	mem := a.module.Memory()
+	mem.Write(msgOffset, msg)
+	if got := hostPublicKey(ctx, a.module, 0, 0, pubOffset); got != StatusOK {
+		t.Fatalf("hostPublicKey() = %d, want StatusOK", got)
+	}
+	if got := hostSign(ctx, a.module, msgOffset, uint32(len(msg)), sigOffset); got != StatusOK {
+		t.Fatalf("hostSign() = %d, want StatusOK", got)
+	}
+	if got := hostVerify(ctx, a.module, pubOffset, msgOffset, uint32(len(msg)), sigOffset); got != StatusOK {
+		t.Errorf("hostVerify() of the signed message = %d, want StatusOK", got)
+	}
+
+	mem.WriteByte(msgOffset, 'M')
+	if got := hostVerify(ctx, a.module, pubOffset, msgOffset, uint32(len(msg)), sigOffset); got != StatusRejected {
+		t.Errorf("hostVerify() of a tampered message = %d, want StatusRejected", got)
+	}
+
+	// A module without memory gets an error rather than a host panic
+	bare, err := New(context.Background(), Config{ID: "bare", Code: noopStartWasm, Verifier: allowUnsigned, Keys: keys, Capabilities: []Capability{CapabilityCrypto}}, ResourceLimits{MaxMemoryPages: 1})
+	if err != nil {
+		t.Fatalf("New(bare) error = %v", err)
+	}
+	defer bare.Stop(ctx)
+	bctx := withAgent(context.Background(), bare)
+	if got := hostSHA256(bctx, bare.module, 0, 0, 0); got != StatusInvalidArgument {
+		t.Errorf("hostSHA256() without memory = %d, want StatusInvalidArgument", got)
+	}
+	if got := hostSign(bctx, bare.module, 0, 0, 0); got != StatusInvalidArgument {
+		t.Errorf("hostSign() without memory = %d, want StatusInvalidArgument", got)
+	}
+	if got := hostPublicKey(bctx, bare.module, 0, 0, 0); got != StatusInvalidArgument {
+		t.Errorf("hostPublicKey() without memory = %d, want StatusInvalidArgument", got)
+	}
+}
+
+func TestHostSend(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		target   string
+		want     []uint32 // status of each of two sends
+		queued   string   // first message left in the recipient's inbox
+	}{
+		{"local delivery", OverflowReject, "recipient", []uint32{StatusOK}, "1"},
+		{"full inbox rejects", OverflowReject, "recipient", []uint32{StatusOK, StatusRejected}, "1"},
+		{"full inbox drops oldest", OverflowDropOldest, "recipient", []uint32{StatusOK, StatusOK}, "2"},
+		{"unknown target", OverflowReject, "nobody", []uint32{StatusNotFound}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(nil, nil)
+			sender, err := New(ctx, Config{ID: "sender", Code: growStartWasm, Verifier: allowUnsigned, Messenger: router, Capabilities: []Capability{CapabilitySend}}, ResourceLimits{MaxMemoryPages: 1})
+			if err != nil {
+				t.Fatalf("New(sender) error = %v", err)
+			}
+			defer sender.Stop(ctx)
+			recipient, err := New(ctx, Config{ID: "recipient", Code: noopStartWasm, Verifier: allowUnsigned, Mailbox: MailboxConfig{Size: 1, Overflow: tt.overflow}}, ResourceLimits{MaxMemoryPages: 1})
+			if err != nil {
+				t.Fatalf("New(recipient) error = %v", err)
+			}
+			defer recipient.Stop(ctx)
+			router.Register(sender)
+			router.Register(recipient)
+
+			mem := sender.module.Memory()
+			mem.Write(0, []byte(tt.target))
+			for i, want := range tt.want {
+				payload := []byte(fmt.Sprint(i + 1))
+				mem.Write(64, payload)
+				if got := hostSend(withAgent(ctx, sender), sender.module, 0, uint32(len(tt.target)), 64, uint32(len(payload))); got != want {
+					t.Fatalf("hostSend(%s) = %d, want %d", payload, got, want)
+				}
+			}
+
+			if tt.queued == "" {
+				return
+			}
+			if n := recipient.mailbox.Len(); n != 1 {
+				t.Fatalf("recipient inbox holds %d messages, want 1", n)
+			}
+			msg, err := recipient.mailbox.Next(ctx)
+			if err != nil || msg.From != "sender" || string(msg.Payload) != tt.queued {
+				t.Errorf("Next() = %+v, %v, want %q from sender", msg, err, tt.queued)
+			}
+		})
+	}
+
+	t.Run("no capability", func(t *testing.T) {
+		a, err := New(ctx, Config{ID: "mute", Code: growStartWasm, Verifier: allowUnsigned, Messenger: NewRouter(nil, nil)}, ResourceLimits{MaxMemoryPages: 1})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		defer a.Stop(ctx)
+		if got := hostSend(withAgent(ctx, a), a.module, 0, 1, 0, 0); got != StatusUnavailable {
+			t.Errorf("hostSend() = %d, want StatusUnavailable", got)
+		}
+	})
+}
+
+func TestNew_CapabilityGrants(t *testing.T) {
+	ctx := context.Background()
+	limits := ResourceLimits{MaxMemoryPages: 1}
+	grant := []Capability{CapabilityLog}
+
+	tests := []struct {
+		name    string
+		caps    []Capability
+		pool    *RuntimePool
+		wantErr bool
+		logged  int
+	}{
+		{"granted", grant, nil, false, 1},
+		// Ungranted functions are not exported, so the import cannot link
+		{"ungranted", nil, nil, true, 0},
+		// Shared runtimes export everything, but the call is a no-op
+		{"granted shared", grant, NewRuntimePool(PoolConfig{SharedLevels: []TrustLevel{TrustTrusted}}), false, 1},
+		{"ungranted shared", nil, NewRuntimePool(PoolConfig{SharedLevels: []TrustLevel{TrustTrusted}}), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			cfg := Config{ID: "logger", Code: logStartWasm, Verifier: allowUnsigned, Logger: logger, Capabilities: tt.caps, Pool: tt.pool, Trust: TrustTrusted}
+			a, err := New(ctx, cfg, limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer a.Stop(ctx)
+			if err := a.Start(ctx); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(logger.entries) != tt.logged {
+				t.Errorf("logged %d entries, want %d", len(logger.entries), tt.logged)
+			}
+		})
+	}
+}
+
+func TestHostClock_Deterministic(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// trace records what a guest sees from time_now and random across a
+	// clock advance
+	trace := func(id string, seed uint64) []byte {
+		clock := NewVirtualClock(start, seed)
+		a, err := New(ctx, Config{ID: id, Code: growStartWasm, Verifier: allowUnsigned, Clock: clock}, ResourceLimits{MaxMemoryPages: 1})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		defer a.Stop(ctx)
+		actx := withAgent(ctx, a)
+
+		var out []byte
+		for step := 0; step < 2; step++ {
+			out = binary.LittleEndian.AppendUint64(out, uint64(hostTimeNow(actx)))
+			if got := hostRandom(actx, a.module, 0, 16); got != StatusOK {
+				t.Fatalf("hostRandom() = %d, want StatusOK", got)
+			}
+			buf, _ := a.module.Memory().Read(0, 16)
+			out = append(out, buf...)
+			clock.Advance(time.Second)
+		}
+		return out
+	}
+
+	a, b := trace("a", 42), trace("b", 42)
+	if !bytes.Equal(a, b) {
+		t.Errorf("same seed and clock gave different outputs:\n%x\n%x", a, b)
+	}
+	if got := int64(binary.LittleEndian.Uint64(a)); got != start.UnixNano() {
+		t.Errorf("time_now() = %d, want the virtual start %d", got, start.UnixNano())
+	}
+	if c := trace("c", 43); bytes.Equal(a[8:24], c[8:24]) {
+		t.Errorf("different seeds gave the same random bytes")
+	}
+}
+
+func TestAgent_WASIScope(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	data := filepath.Join(root, "data")
+	if err := os.Mkdir(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(data, "hello.txt"), []byte("hi there"), 0o644)
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0o644)
+
+	newAgent := func(t *testing.T, wasi WASIConfig) *Agent {
+		t.Helper()
+		a, err := New(ctx, Config{ID: "wasi", Code: wasiProxyWasm, Verifier: allowUnsigned, WASI: wasi}, ResourceLimits{MaxMemoryPages: 1})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		t.Cleanup(func() { a.Stop(ctx) })
+		return a
+	}
+	call := func(t *testing.T, a *Agent, name string, params ...uint64) uint32 {
+		t.Helper()
+		results, err := a.module.ExportedFunction(name).Call(ctx, params...)
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		return uint32(results[0])
+	}
+	// open opens path under the first preopen (fd 3), returning the WASI
+	// errno and the opened fd
+	const pathOffset, fdOffset = 100, 0
+	const oflagCreate, rightFDRead, rightFDWrite = 1, 1 << 1, 1 << 6
+	open := func(t *testing.T, a *Agent, path string, oflags, rights uint64) (uint32, uint32) {
+		t.Helper()
+		a.module.Memory().Write(pathOffset, []byte(path))
+		errno := call(t, a, "path_open", 3, 0, pathOffset, uint64(len(path)), oflags, rights, 0, 0, fdOffset)
+		fd, _ := a.module.Memory().ReadUint32Le(fdOffset)
+		return errno, fd
+	}
+
+	t.Run("env", func(t *testing.T) {
+		a := newAgent(t, WASIConfig{Env: map[string]string{"GREETING": "hi"}})
+		if errno := call(t, a, "environ_sizes_get", 0, 4); errno != 0 {
+			t.Fatalf("environ_sizes_get() errno = %d", errno)
+		}
+		count, _ := a.module.Memory().ReadUint32Le(0)
+		size, _ := a.module.Memory().ReadUint32Le(4)
+		if count != 1 {
+			t.Fatalf("guest sees %d environment variables, want 1", count)
+		}
+		if errno := call(t, a, "environ_get", 16, 64); errno != 0 {
+			t.Fatalf("environ_get() errno = %d", errno)
+		}
+		if env, _ := a.module.Memory().Read(64, size); string(env) != "GREETING=hi\x00" {
+			t.Errorf("guest environment = %q, want only GREETING=hi", env)
+		}
+	})
+
+	t.Run("no preopens", func(t *testing.T) {
+		a := newAgent(t, WASIConfig{})
+		if errno, _ := open(t, a, "hello.txt", 0, rightFDRead); errno == 0 {
+			t.Errorf("path_open() without preopens succeeded")
+		}
+	})
+
+	t.Run("preopen", func(t *testing.T) {
+		a := newAgent(t, WASIConfig{Preopens: []Preopen{{HostPath: data, GuestPath: "/data", ReadOnly: true}}})
+		errno, fd := open(t, a, "hello.txt", 0, rightFDRead)
+		if errno != 0 {
+			t.Fatalf("path_open(hello.txt) errno = %d", errno)
+		}
+		// One iovec at 200 pointing at a 64-byte buffer at 300
+		mem := a.module.Memory()
+		mem.WriteUint32Le(200, 300)
+		mem.WriteUint32Le(204, 64)
+		if errno := call(t, a, "fd_read", uint64(fd), 200, 1, 8); errno != 0 {
+			t.Fatalf("fd_read() errno = %d", errno)
+		}
+		n, _ := mem.ReadUint32Le(8)
+		if got, _ := mem.Read(300, n); string(got) != "hi there" {
+			t.Errorf("read %q, want %q", got, "hi there")
+		}
+
+		if errno, _ := open(t, a, "../secret.txt", 0, rightFDRead); errno == 0 {
+			t.Errorf("path_open() outside the preopen succeeded")
+		}
+		if errno, _ := open(t, a, "new.txt", oflagCreate, rightFDWrite); errno == 0 {
+			t.Errorf("path_open(O_CREAT) on a read-only preopen succeeded")
+		}
+		if _, err := os.Stat(filepath.Join(data, "new.txt")); err == nil {
+			t.Errorf("guest created a file through a read-only preopen")
+		}
+	})
+
+	t.Run("writable preopen", func(t *testing.T) {
+		a := newAgent(t, WASIConfig{Preopens: []Preopen{{HostPath: data, GuestPath: "/data"}}})
+		if errno, _ := open(t, a, "new.txt", oflagCreate, rightFDWrite); errno != 0 {
+			t.Fatalf("path_open(O_CREAT) errno = %d", errno)
+		}
+		if _, err := os.Stat(filepath.Join(data, "new.txt")); err != nil {
+			t.Errorf("file created by the guest is missing: %v", err)
+		}
+	})
+
+	t.Run("invalid preopen", func(t *testing.T) {
+		for _, p := range []Preopen{
+			{HostPath: data, GuestPath: "data"},
+			{HostPath: filepath.Join(root, "missing"), GuestPath: "/data"},
+			{HostPath: filepath.Join(root, "secret.txt"), GuestPath: "/data"},
+		} {
+			cfg := Config{ID: "wasi", Code: wasiProxyWasm, Verifier: allowUnsigned, WASI: WASIConfig{Preopens: []Preopen{p}}}
+			if _, err := New(ctx, cfg, ResourceLimits{MaxMemoryPages: 1}); err == nil {
+				t.Errorf("New() with preopen %+v succeeded", p)
+			}
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// componentHostFunctions binds every function HostWIT's host interface
+// declares, with the core signature the canonical ABI lowers it to.
+// Functions whose lowered signature and semantics match the env module's
+// share its implementation; the rest return results through a return area
+// the guest passes last, allocating lists with the guest's cabi_realloc.
+var componentHostFunctions = []hostFunction{
+	{name: "log", fn: hostLog, capability: CapabilityLog},
+	{name: "send", fn: hostSend, capability: CapabilitySend},
+	{name: "publish", fn: hostPublish, capability: CapabilityPubSub},
+	{name: "subscribe", fn: hostSubscribe, capability: CapabilityPubSub},
+	{name: "unsubscribe", fn: hostUnsubscribe, capability: CapabilityPubSub},
+	{name: "kv-get", fn: witKVGet, capability: CapabilityKV},
+	{name: "kv-put", fn: hostKVPut, capability: CapabilityKV},
+	{name: "kv-delete", fn: hostKVDelete, capability: CapabilityKV},
+	{name: "kv-scan", fn: witKVScan, capability: CapabilityKV},
+	{name: "soul-remember", fn: hostSoulRemember, capability: CapabilitySoul},
+	{name: "soul-recall", fn: witSoulRecall, capability: CapabilitySoul},
+	{name: "soul-get-value", fn: witSoulGetValue, capability: CapabilitySoul},
+	{name: "soul-set-value", fn: hostSoulSetValue, capability: CapabilitySoul},
+	{name: "http-fetch", fn: witHTTPFetch, capability: CapabilityHTTP},
+	{name: "crypto-sha256", fn: witSHA256, capability: CapabilityCrypto},
+	{name: "crypto-sign", fn: witSign, capability: CapabilityCrypto},
+	{name: "crypto-verify", fn: witVerify, capability: CapabilityCrypto},
+	{name: "crypto-public-key", fn: witPublicKey, capability: CapabilityCrypto},
+	{name: "time-now", fn: hostTimeNow},
+	{name: "random", fn: witRandom},
+}
+
+// lowerBytes copies data into a guest buffer allocated through
+// cabi_realloc, as the canonical ABI passes lists to the guest
+func lowerBytes(ctx context.Context, m api.Module, data []byte, align uint32) (uint32, bool) {
+	realloc := m.ExportedFunction(ExportRealloc)
+	if realloc == nil {
+		return 0, false
+	}
+	results, err := realloc.Call(ctx, 0, 0, uint64(align), uint64(len(data)))
+	if err != nil {
+		return 0, false
+	}
+	ptr := uint32(results[0])
+	if len(data) > 0 && !m.Memory().Write(ptr, data) {
+		return 0, false
+	}
+	return ptr, true
+}
+
+// storeList writes the ok case of a result<list<u8>, status> to the return
+// area at retptr: a zero discriminant byte, then at offset 4 the list's
+// pointer and length. Failing to allocate the list stores failed instead.
+func storeList(ctx context.Context, m api.Module, retptr uint32, data []byte) {
+	ptr, ok := lowerBytes(ctx, m, data, 1)
+	if !ok {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+	storeRegion(m, retptr, ptr, uint32(len(data)))
+}
+
+// storeRegion writes the ok case of a result holding a list
+func storeRegion(m api.Module, retptr, ptr, length uint32) {
+	var area [12]byte
+	binary.LittleEndian.PutUint32(area[4:], ptr)
+	binary.LittleEndian.PutUint32(area[8:], length)
+	m.Memory().Write(retptr, area[:])
+}
+
+// storeStatus writes the error case of a result<list<u8>, status>: a
+// discriminant of one, then the status at offset 4
+func storeStatus(m api.Module, retptr, status uint32) {
+	mem := m.Memory()
+	mem.WriteByte(retptr, 1)
+	mem.WriteByte(retptr+4, byte(status))
+}
+
+func witKVGet(ctx context.Context, m api.Module, keyOffset, keyLength, retptr uint32) {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+
+	value, err := a.kv.Get(key)
+	switch {
+	case err != nil:
+		storeStatus(m, retptr, StatusFailed)
+	case value == nil:
+		storeStatus(m, retptr, StatusNotFound)
+	default:
+		storeList(ctx, m, retptr, value)
+	}
+}
+
+// witKVScan returns the matching keys as a list<list<u8>>: an array of
+// pointer and length pairs, each key allocated separately
+func witKVScan(ctx context.Context, m api.Module, prefixOffset, prefixLength, retptr uint32) {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+
+	prefix, ok := readGuestBytes(m, prefixOffset, prefixLength)
+	if !ok {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+	keys, err := a.kv.Keys(prefix)
+	if err != nil {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+
+	elems := make([]byte, 8*len(keys))
+	for i, key := range keys {
+		ptr, ok := lowerBytes(ctx, m, key, 1)
+		if !ok {
+			storeStatus(m, retptr, StatusFailed)
+			return
+		}
+		binary.LittleEndian.PutUint32(elems[8*i:], ptr)
+		binary.LittleEndian.PutUint32(elems[8*i+4:], uint32(len(key)))
+	}
+	ptr, ok := lowerBytes(ctx, m, elems, 4)
+	if !ok {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+	storeRegion(m, retptr, ptr, uint32(len(keys)))
+}
+
+// witSoulRecall returns the bound soul's memories like soul_recall
+func witSoulRecall(ctx context.Context, m api.Module, queryOffset, queryLength, retptr uint32) {
+	a, b := boundSoul(ctx)
+	if b == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+	if !b.access.Recall {
+		a.audit("soul_recall_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		storeStatus(m, retptr, StatusRejected)
+		return
+	}
+
+	var query soul.MemoryQuery
+	if queryLength > 0 {
+		raw, ok := readGuestBytes(m, queryOffset, queryLength)
+		if !ok {
+			storeStatus(m, retptr, StatusInvalidArgument)
+			return
+		}
+		var tags []string
+		if err := json.Unmarshal(raw, &tags); err == nil {
+			if len(tags) > 0 {
+				expr := soul.AnyTag(tags...)
+				query.Tags = &expr
+			}
+		} else if err := json.Unmarshal(raw, &query); err != nil {
+			storeStatus(m, retptr, StatusInvalidArgument)
+			return
+		}
+	}
+
+	memories, err := b.soul.Query(query)
+	if err != nil {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+	data, err := json.Marshal(soulMemories(memories))
+	if err != nil {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+	storeList(ctx, m, retptr, data)
+}
+
+// witSoulGetValue returns a result<f64, status>, whose payload is 8-byte
+// aligned
+func witSoulGetValue(ctx context.Context, m api.Module, keyOffset, keyLength, retptr uint32) {
+	mem := m.Memory()
+	fail := func(status uint32) {
+		mem.WriteByte(retptr, 1)
+		mem.WriteByte(retptr+8, byte(status))
+	}
+
+	a, b := boundSoul(ctx)
+	if b == nil {
+		fail(StatusUnavailable)
+		return
+	}
+	if !b.access.Values {
+		a.audit("soul_get_value_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		fail(StatusRejected)
+		return
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		fail(StatusInvalidArgument)
+		return
+	}
+	value, found := b.soul.GetValue(string(key))
+	if !found {
+		fail(StatusNotFound)
+		return
+	}
+	mem.WriteByte(retptr, 0)
+	mem.WriteFloat64Le(retptr+8, value)
+}
+
+// witHTTPFetch performs a request like http_fetch and returns the JSON
+// response directly
+func witHTTPFetch(ctx context.Context, m api.Module, reqOffset, reqLength, retptr uint32) {
+	if status := hostHTTPFetch(ctx, m, reqOffset, reqLength); status < 0 {
+		storeStatus(m, retptr, uint32(-status))
+		return
+	}
+	a := agentFromContext(ctx)
+	storeList(ctx, m, retptr, a.http.lastResult())
+}
+
+func witSHA256(ctx context.Context, m api.Module, dataOffset, dataLength, retptr uint32) {
+	if grantedAgent(ctx, CapabilityCrypto) == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+
+	data, ok := readGuestBytes(m, dataOffset, dataLength)
+	if !ok {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+	sum := sha256.Sum256(data)
+	storeList(ctx, m, retptr, sum[:])
+}
+
+func witSign(ctx context.Context, m api.Module, msgOffset, msgLength, retptr uint32) {
+	a := grantedAgent(ctx, CapabilityCrypto)
+	if a == nil || a.keys == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+
+	msg, ok := readGuestBytes(m, msgOffset, msgLength)
+	if !ok {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+	priv, err := a.keys.PrivateKey(a.ID)
+	if err != nil {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+	storeList(ctx, m, retptr, ed25519.Sign(priv, msg))
+}
+
+// witVerify checks a signature like crypto_verify, with each argument a
+// sized list so malformed keys and signatures are invalid arguments
+func witVerify(ctx context.Context, m api.Module, pubOffset, pubLength, msgOffset, msgLength, sigOffset, sigLength uint32) uint32 {
+	if grantedAgent(ctx, CapabilityCrypto) == nil {
+		return StatusUnavailable
+	}
+	if pubLength != ed25519.PublicKeySize || sigLength != ed25519.SignatureSize {
+		return StatusInvalidArgument
+	}
+	return hostVerify(ctx, m, pubOffset, msgOffset, msgLength, sigOffset)
+}
+
+func witPublicKey(ctx context.Context, m api.Module, idOffset, idLength, retptr uint32) {
+	a := grantedAgent(ctx, CapabilityCrypto)
+	if a == nil || a.keys == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+
+	id, ok := readGuestBytes(m, idOffset, idLength)
+	if !ok {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+
+	var pub ed25519.PublicKey
+	if len(id) == 0 {
+		priv, err := a.keys.PrivateKey(a.ID)
+		if err != nil {
+			storeStatus(m, retptr, StatusFailed)
+			return
+		}
+		pub = priv.Public().(ed25519.PublicKey)
+	} else {
+		var err error
+		if pub, err = a.keys.PublicKey(string(id)); err != nil {
+			storeStatus(m, retptr, StatusFailed)
+			return
+		}
+		if pub == nil {
+			storeStatus(m, retptr, StatusNotFound)
+			return
+		}
+	}
+	storeList(ctx, m, retptr, pub)
+}
+
+func witRandom(ctx context.Context, m api.Module, length, retptr uint32) {
+	a := agentFromContext(ctx)
+	if a == nil {
+		storeStatus(m, retptr, StatusUnavailable)
+		return
+	}
+	if length > MaxRandomSize {
+		storeStatus(m, retptr, StatusInvalidArgument)
+		return
+	}
+
+	buf := make([]byte, length)
+	if _, err := a.clock.Read(buf); err != nil {
+		storeStatus(m, retptr, StatusFailed)
+		return
+	}
+	storeList(ctx, m, retptr, buf)
+}
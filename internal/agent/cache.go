@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Digest returns the hex-encoded SHA-256 digest identifying agent code
+func Digest(code []byte) string {
+	sum := sha256.Sum256(code)
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheStats reports module cache effectiveness
+type CacheStats struct {
+	Modules int    // Distinct modules compiled
+	Hits    uint64 // Agent instances that reused compiled code
+	Misses  uint64 // Agent instances that required compilation
+}
+
+// ModuleCache shares compiled code between agent runtimes. Every runtime
+// configured with the same cache compiles each distinct module only once.
+type ModuleCache struct {
+	compilation wazero.CompilationCache
+	modules     map[string]int // Digest to number of instances compiled against it
+	hits        uint64
+	misses      uint64
+	mu          sync.Mutex
+}
+
+// NewModuleCache creates a module cache. If dir is non-empty compiled code
+// is also persisted there and reused across process restarts.
+func NewModuleCache(dir string) (*ModuleCache, error) {
+	var compilation wazero.CompilationCache
+	if dir == "" {
+		compilation = wazero.NewCompilationCache()
+	} else {
+		var err error
+		compilation, err = wazero.NewCompilationCacheWithDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create compilation cache in %s: %w", dir, err)
+		}
+	}
+
+	return &ModuleCache{
+		compilation: compilation,
+		modules:     make(map[string]int),
+	}, nil
+}
+
+// OpenModuleCache creates a module cache persisted under the node's data
+// directory. Entries live in a subdirectory named for the wazero version,
+// since compiled code is only valid for the runtime that produced it;
+// directories left behind by other versions are removed.
+func OpenModuleCache(dataDir string) (*ModuleCache, error) {
+	root := filepath.Join(dataDir, "compilation-cache")
+	version := RuntimeVersion()
+
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read compilation cache dir: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != version {
+			if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+				return nil, fmt.Errorf("failed to remove stale compilation cache: %w", err)
+			}
+		}
+	}
+
+	dir := filepath.Join(root, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create compilation cache dir: %w", err)
+	}
+	return NewModuleCache(dir)
+}
+
+// RuntimeVersion returns the version of the wazero runtime compiled into
+// the binary, or "devel" if it cannot be determined
+func RuntimeVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/tetratelabs/wazero" {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			return dep.Version
+		}
+	}
+	return "devel"
+}
+
+// Stats returns cache statistics
+func (c *ModuleCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Modules: len(c.modules),
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+}
+
+// Close releases compiled code held by the cache. Runtimes using the cache
+// must be closed first.
+func (c *ModuleCache) Close(ctx context.Context) error {
+	if err := c.compilation.Close(ctx); err != nil {
+		return fmt.Errorf("failed to close compilation cache: %w", err)
+	}
+	return nil
+}
+
+// record notes a compilation of the module with the given digest
+func (c *ModuleCache) record(digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.modules[digest] > 0 {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.modules[digest]++
+}
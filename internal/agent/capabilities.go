@@ -0,0 +1,55 @@
+package agent
+
+import "fmt"
+
+// Capability names a group of host functions an agent may be granted
+type Capability string
+
+const (
+	// CapabilityLog grants the log host function
+	CapabilityLog Capability = "log"
+	// CapabilitySend grants messaging to other agents and topics
+	CapabilitySend Capability = "send"
+	// CapabilityKV grants access to the agent's key-value namespace
+	CapabilityKV Capability = "kv"
+	// CapabilityHTTP grants outbound HTTP requests
+	CapabilityHTTP Capability = "http"
+	// CapabilityCrypto grants hashing, signing and verification
+	CapabilityCrypto Capability = "crypto"
+	// CapabilitySoul grants access to a bound soul
+	CapabilitySoul Capability = "soul"
+	// CapabilityPubSub grants publishing and subscribing to transport topics
+	CapabilityPubSub Capability = "pubsub"
+)
+
+// knownCapabilities lists every capability that may be granted
+var knownCapabilities = map[Capability]bool{
+	CapabilityLog:    true,
+	CapabilitySend:   true,
+	CapabilityKV:     true,
+	CapabilityHTTP:   true,
+	CapabilityCrypto: true,
+	CapabilitySoul:   true,
+	CapabilityPubSub: true,
+}
+
+// capabilitySet is the set of capabilities granted to an agent
+type capabilitySet map[Capability]bool
+
+// newCapabilitySet validates and collects granted capabilities
+func newCapabilitySet(caps []Capability) (capabilitySet, error) {
+	set := make(capabilitySet, len(caps))
+	for _, c := range caps {
+		if !knownCapabilities[c] {
+			return nil, fmt.Errorf("unknown capability %q", c)
+		}
+		set[c] = true
+	}
+	return set, nil
+}
+
+// has reports whether the capability is granted. The empty capability
+// marks host functions available to every agent.
+func (s capabilitySet) has(c Capability) bool {
+	return c == "" || s[c]
+}
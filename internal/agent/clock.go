@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mrand "math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Clock provides the time and entropy visible to an agent, through both
+// the time_now/random host functions and WASI
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Nanotime returns a monotonic time in nanoseconds
+	Nanotime() int64
+	// Read fills p with random bytes
+	Read(p []byte) (int, error)
+}
+
+// RealClock exposes host wall time and system entropy
+type RealClock struct {
+	start time.Time
+}
+
+// NewRealClock creates a clock backed by the host
+func NewRealClock() *RealClock {
+	return &RealClock{start: time.Now()}
+}
+
+// Now returns the host wall time
+func (c *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// Nanotime returns nanoseconds elapsed since the clock was created
+func (c *RealClock) Nanotime() int64 {
+	return int64(time.Since(c.start))
+}
+
+// Read fills p from the system CSPRNG
+func (c *RealClock) Read(p []byte) (int, error) {
+	return rand.Read(p)
+}
+
+// VirtualClock is a manually advanced clock with seeded entropy, making
+// agent runs reproducible for simulations
+type VirtualClock struct {
+	now time.Time
+	rng *mrand.ChaCha8
+	mu  sync.Mutex
+}
+
+// NewVirtualClock creates a virtual clock starting at start whose random
+// stream is fully determined by seed
+func NewVirtualClock(start time.Time, seed uint64) *VirtualClock {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	return &VirtualClock{
+		now: start,
+		rng: mrand.NewChaCha8(key),
+	}
+}
+
+// Now returns the current virtual time
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Nanotime returns the virtual time in nanoseconds
+func (c *VirtualClock) Nanotime() int64 {
+	return c.Now().UnixNano()
+}
+
+// Advance moves virtual time forward
+func (c *VirtualClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves virtual time to t if it is later than the current time
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// Read fills p from the seeded random stream
+func (c *VirtualClock) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Read(p)
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrComponentUnsupported is returned for component binaries the runtime
+// cannot link. Components must be built around a single core module
+// importing only HostInterface and WASI preview 1, as standard tooling
+// produces for guests targeting HostWIT's worlds.
+var ErrComponentUnsupported = errors.New("unsupported wasm component")
+
+// wasmMagic opens both core modules and components
+var wasmMagic = []byte{0x00, 0x61, 0x73, 0x6d}
+
+// componentSectionCoreModule is the component section embedding a core
+// module
+const componentSectionCoreModule byte = 1
+
+// componentExports maps the exports of HostWIT's worlds to the core exports
+// the host calls
+var componentExports = map[string]string{
+	"on-init":        ExportOnInit,
+	"on-message":     ExportOnMessage,
+	"on-tick":        ExportOnTick,
+	"on-matrix-tick": ExportOnMatrixTick,
+	"state-save":     ExportStateSave,
+	"state-load":     ExportStateLoad,
+}
+
+// IsComponent reports whether code is a component-model binary. The two
+// bytes after the magic are a version and the next two a layer, which is
+// 0 for core modules and 1 for components.
+func IsComponent(code []byte) bool {
+	return len(code) >= 8 && bytes.HasPrefix(code, wasmMagic) && code[6] == 0x01 && code[7] == 0x00
+}
+
+// coreModule returns the core module to compile for agent code and whether
+// it was unwrapped from a component
+func coreModule(code []byte) ([]byte, bool, error) {
+	if !IsComponent(code) {
+		return code, false, nil
+	}
+	core, err := unwrapComponent(code)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrComponentUnsupported, err)
+	}
+	return core, true, nil
+}
+
+// unwrapComponent extracts a component's main core module: the one that
+// defines and exports a memory, as opposed to the shim and adapter modules
+// component tooling adds around it. Rather than instantiating those, the
+// host links the main module directly: its HostInterface imports against
+// the typed host bindings and its WASI imports against the runtime's WASI,
+// which is what the component's own wiring resolves them to. World exports
+// are renamed to the lifecycle exports they implement.
+func unwrapComponent(code []byte) ([]byte, error) {
+	var main []byte
+	r := &wasmReader{b: code, pos: 8}
+	for !r.done() {
+		id := r.byte()
+		payload := r.bytes(int(r.u32()))
+		if r.err != nil {
+			return nil, fmt.Errorf("malformed component section: %w", r.err)
+		}
+		if id != componentSectionCoreModule {
+			continue
+		}
+		exportsMemory, err := coreExportsMemory(payload)
+		if err != nil {
+			return nil, err
+		}
+		if !exportsMemory {
+			continue
+		}
+		if main != nil {
+			return nil, fmt.Errorf("component embeds more than one core module with memory")
+		}
+		main = payload
+	}
+	if main == nil {
+		return nil, fmt.Errorf("component embeds no core module with memory")
+	}
+
+	sections, err := readSections(main)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range sections {
+		switch s.id {
+		case sectionImport:
+			if err := checkComponentImports(s.payload); err != nil {
+				return nil, err
+			}
+		case sectionExport:
+			if sections[i].payload, err = renameComponentExports(s.payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return writeSections(main[:8], sections), nil
+}
+
+// coreExportsMemory reports whether a core module exports a memory
+func coreExportsMemory(code []byte) (bool, error) {
+	sections, err := readSections(code)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range sections {
+		if s.id != sectionExport {
+			continue
+		}
+		r := &wasmReader{b: s.payload}
+		for i, n := uint32(0), r.u32(); i < n && r.err == nil; i++ {
+			r.bytes(int(r.u32()))
+			if kind := r.byte(); kind == externMemory {
+				return true, nil
+			}
+			r.u32()
+		}
+		if r.err != nil {
+			return false, fmt.Errorf("malformed export section: %w", r.err)
+		}
+	}
+	return false, nil
+}
+
+// checkComponentImports rejects imports other than HostInterface's
+// functions and WASI preview 1
+func checkComponentImports(payload []byte) error {
+	r := &wasmReader{b: payload}
+	for i, n := uint32(0), r.u32(); i < n && r.err == nil; i++ {
+		module := string(r.bytes(int(r.u32())))
+		field := string(r.bytes(int(r.u32())))
+		r.importDesc()
+		if r.err != nil {
+			break
+		}
+
+		switch module {
+		case HostInterface:
+			if _, ok := hostInterface.funcs[field]; !ok {
+				return fmt.Errorf("component imports %s, which %s does not declare", field, HostInterface)
+			}
+		case "wasi_snapshot_preview1":
+		default:
+			return fmt.Errorf("component imports %s#%s; only %s and WASI are provided", module, field, HostInterface)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("malformed import section: %w", r.err)
+	}
+	return nil
+}
+
+// renameComponentExports renames world exports, and the cabi_post_
+// functions that free their results, to the lifecycle exports they
+// implement
+func renameComponentExports(payload []byte) ([]byte, error) {
+	r := &wasmReader{b: payload}
+	n := r.u32()
+	out := appendULEB128(nil, uint64(n))
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		name := string(r.bytes(int(r.u32())))
+		start := r.pos
+		r.byte()
+		r.u32()
+
+		if core, ok := componentExports[name]; ok {
+			name = core
+		} else if wit, ok := strings.CutPrefix(name, exportPostReturnPrefix); ok {
+			if core, ok := componentExports[wit]; ok {
+				name = exportPostReturnPrefix + core
+			}
+		}
+		out = append(out, wasmName(name)...)
+		out = append(out, payload[start:r.pos]...)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed export section: %w", r.err)
+	}
+	return out, nil
+}
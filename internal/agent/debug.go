@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxWatchEvents is the number of global changes a debugger retains
+const MaxWatchEvents = 256
+
+var (
+	// ErrNotPaused is returned for debugger operations that need a paused agent
+	ErrNotPaused = errors.New("agent is not paused")
+	// ErrUnknownGlobal is returned when watching a global the module does not export
+	ErrUnknownGlobal = errors.New("exported global not found")
+)
+
+// StepResult describes an invocation run by Debugger.Step
+type StepResult struct {
+	Function string
+	Duration time.Duration
+	Err      error
+}
+
+// WatchEvent records a watched global changing across an invocation
+type WatchEvent struct {
+	Global     string
+	Old        uint64
+	New        uint64
+	Function   string // Export whose invocation changed the value
+	Invocation uint64 // Agent invocation count at the time of the change
+}
+
+// Debugger pauses, steps, and inspects a single agent. Invocations are
+// held before they reach the guest while the agent is paused, so memory and
+// globals can be read without racing the guest.
+type Debugger struct {
+	agent    *Agent
+	paused   bool
+	steps    int           // Invocations allowed to run while paused
+	running  bool          // A stepped invocation is executing
+	wake     chan struct{} // Closed and replaced whenever the state changes
+	stepped  chan StepResult
+	watches  map[string]uint64
+	events   []WatchEvent
+	detached bool
+	mu       sync.Mutex
+}
+
+// Debug returns the agent's debugger, enabling debug mode on first use
+func (a *Agent) Debug() *Debugger {
+	if d := a.debugger.Load(); d != nil {
+		return d
+	}
+	d := &Debugger{
+		agent:   a,
+		wake:    make(chan struct{}),
+		stepped: make(chan StepResult, 1),
+		watches: make(map[string]uint64),
+	}
+	if !a.debugger.CompareAndSwap(nil, d) {
+		return a.debugger.Load()
+	}
+	return d
+}
+
+// Pause holds every later invocation until Resume or Step
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = true
+	d.steps = 0
+}
+
+// Resume lets invocations run freely again
+func (d *Debugger) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = false
+	d.notifyLocked()
+}
+
+// Paused reports whether invocations are being held
+func (d *Debugger) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// Step lets one held or upcoming invocation run and waits for it to
+// finish. The agent stays paused afterwards.
+func (d *Debugger) Step(ctx context.Context) (StepResult, error) {
+	d.mu.Lock()
+	if !d.paused {
+		d.mu.Unlock()
+		return StepResult{}, ErrNotPaused
+	}
+	d.steps++
+	d.notifyLocked()
+	d.mu.Unlock()
+
+	select {
+	case res := <-d.stepped:
+		return res, nil
+	case <-d.agent.done:
+		return StepResult{}, fmt.Errorf("agent %s stopped", d.agent.ID)
+	case <-ctx.Done():
+		return StepResult{}, ctx.Err()
+	}
+}
+
+// ReadMemory copies a region of the paused agent's linear memory. It fails
+// while a stepped invocation is running.
+func (d *Debugger) ReadMemory(offset, length uint32) ([]byte, error) {
+	d.mu.Lock()
+	idle := d.paused && !d.running
+	d.mu.Unlock()
+	if !idle {
+		return nil, ErrNotPaused
+	}
+	mem := linearMemory(d.agent.module)
+	if mem == nil {
+		return nil, fmt.Errorf("agent %s has no linear memory", d.agent.ID)
+	}
+	buf, ok := mem.Read(offset, length)
+	if !ok {
+		return nil, fmt.Errorf("range %d+%d is outside linear memory of %d bytes", offset, length, mem.Size())
+	}
+	return append([]byte(nil), buf...), nil
+}
+
+// Watch records changes to exported globals after every invocation
+func (d *Debugger) Watch(names ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range names {
+		g := d.agent.module.ExportedGlobal(name)
+		if g == nil {
+			return fmt.Errorf("%w: %s", ErrUnknownGlobal, name)
+		}
+		d.watches[name] = g.Get()
+	}
+	return nil
+}
+
+// Unwatch stops recording changes to exported globals
+func (d *Debugger) Unwatch(names ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range names {
+		delete(d.watches, name)
+	}
+}
+
+// Globals returns the last observed value of each watched global
+func (d *Debugger) Globals() map[string]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	values := make(map[string]uint64, len(d.watches))
+	for name, v := range d.watches {
+		values[name] = v
+	}
+	return values
+}
+
+// WatchEvents returns the retained global changes, oldest first
+func (d *Debugger) WatchEvents() []WatchEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]WatchEvent(nil), d.events...)
+}
+
+// detach releases held invocations for good so the agent can shut down
+func (d *Debugger) detach() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.detached = true
+	d.paused = false
+	d.notifyLocked()
+}
+
+// notifyLocked wakes held invocations. Callers must hold mu.
+func (d *Debugger) notifyLocked() {
+	close(d.wake)
+	d.wake = make(chan struct{})
+}
+
+// gate blocks an invocation while the agent is paused, reporting whether
+// it was released by Step
+func (d *Debugger) gate(ctx context.Context) (bool, error) {
+	for {
+		d.mu.Lock()
+		if !d.paused || d.detached {
+			d.mu.Unlock()
+			return false, nil
+		}
+		if d.steps > 0 {
+			d.steps--
+			d.running = true
+			d.mu.Unlock()
+			return true, nil
+		}
+		wake := d.wake
+		d.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// observe records watched global changes after an invocation and hands
+// the result to a waiting Step. Callers must hold callMu.
+func (d *Debugger) observe(function string, elapsed time.Duration, err error, stepped bool) {
+	d.mu.Lock()
+	if stepped {
+		d.running = false
+	}
+	for name, old := range d.watches {
+		g := d.agent.module.ExportedGlobal(name)
+		if g == nil {
+			continue
+		}
+		if v := g.Get(); v != old {
+			d.watches[name] = v
+			d.events = append(d.events, WatchEvent{
+				Global:     name,
+				Old:        old,
+				New:        v,
+				Function:   function,
+				Invocation: d.agent.invocations.Load(),
+			})
+		}
+	}
+	if n := len(d.events); n > MaxWatchEvents {
+		d.events = append([]WatchEvent(nil), d.events[n-MaxWatchEvents:]...)
+	}
+	d.mu.Unlock()
+
+	if stepped {
+		select {
+		case d.stepped <- StepResult{Function: function, Duration: elapsed, Err: err}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DeterministicConfig hands an agent's clock, entropy, and message delivery
+// to a scheduler so simulation runs are reproducible. The agent reads time
+// from a VirtualClock seeded with Seed, and queued messages are only
+// delivered by Step, in a fixed order. Call timeouts still measure wall
+// time, so runs that hit them are not reproducible; rely on fuel instead.
+type DeterministicConfig struct {
+	Seed  uint64    // Seeds the agent's random stream; give each agent its own
+	Start time.Time // Initial virtual time; zero uses the Unix epoch
+}
+
+// Deterministic reports whether the agent runs under a scheduler
+func (a *Agent) Deterministic() bool {
+	return a.deterministic
+}
+
+// Step advances a deterministic agent to a scheduler step: virtual time
+// moves to now, queued messages are delivered ordered by timestamp and
+// sender, and on_tick is called. Messages from one sender keep the order
+// they were sent in. Handler failures are logged; a trap ends the step.
+func (a *Agent) Step(ctx context.Context, step uint64, now time.Time) error {
+	if !a.deterministic {
+		return fmt.Errorf("agent %s is not in deterministic mode", a.ID)
+	}
+	if vc, ok := a.clock.(*VirtualClock); ok {
+		vc.Set(now)
+	}
+
+	msgs := a.mailbox.Drain()
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if msgs[i].Timestamp != msgs[j].Timestamp {
+			return msgs[i].Timestamp < msgs[j].Timestamp
+		}
+		return msgs[i].From < msgs[j].From
+	})
+	for _, msg := range msgs {
+		err := a.HandleMessage(ctx, msg)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrHandlerFailed) {
+			return err
+		}
+		if a.logger != nil {
+			a.logger.AddLog("warn", "agent", "agent failed to handle message", map[string]interface{}{
+				"agent_id": a.ID,
+				"from":     msg.From,
+				"step":     step,
+				"error":    err.Error(),
+			})
+		}
+	}
+
+	if err := a.Tick(ctx, step); err != nil && !errors.Is(err, ErrHandlerFailed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SecretPrefix marks a config or environment value as a reference to a
+// secret, e.g. "secret:openai-key", resolved when the agent is instantiated
+const SecretPrefix = "secret:"
+
+// ErrSecretNotFound is returned when a secret reference cannot be resolved
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretResolver looks up secret values by name
+type SecretResolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// SecretMap resolves secrets from an in-memory map
+type SecretMap map[string]string
+
+// Resolve returns the named secret
+func (m SecretMap) Resolve(ctx context.Context, name string) (string, error) {
+	value, ok := m[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// EnvSecretResolver resolves secrets from the node's process environment.
+// The secret "db-password" with prefix "MATRIX_SECRET_" is read from
+// MATRIX_SECRET_DB_PASSWORD.
+type EnvSecretResolver struct {
+	Prefix string
+}
+
+// Resolve returns the named secret
+func (r EnvSecretResolver) Resolve(ctx context.Context, name string) (string, error) {
+	key := r.Prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// resolveValue replaces a secret reference with its value
+func resolveValue(ctx context.Context, secrets SecretResolver, value string) (string, error) {
+	name, ok := strings.CutPrefix(value, SecretPrefix)
+	if !ok {
+		return value, nil
+	}
+	if secrets == nil {
+		return "", fmt.Errorf("%w: %s (no secret resolver configured)", ErrSecretNotFound, name)
+	}
+	return secrets.Resolve(ctx, name)
+}
+
+// resolveEnv resolves secret references in environment variables
+func resolveEnv(ctx context.Context, secrets SecretResolver, env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(env))
+	for key, value := range env {
+		v, err := resolveValue(ctx, secrets, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve env %s: %w", key, err)
+		}
+		resolved[key] = v
+	}
+	return resolved, nil
+}
+
+// EncodeInitConfig resolves secret references in a deployment config and
+// serializes it as the JSON object passed to on_init
+func EncodeInitConfig(ctx context.Context, secrets SecretResolver, config map[string]interface{}) ([]byte, error) {
+	resolved, err := resolveInitConfig(ctx, secrets, config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return data, nil
+}
+
+// encodeConfigEntries resolves a deployment config into JSON values per
+// key, the form carried by config envelopes
+func encodeConfigEntries(ctx context.Context, secrets SecretResolver, config map[string]interface{}) (map[string][]byte, error) {
+	resolved, err := resolveInitConfig(ctx, secrets, config)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]byte, len(resolved))
+	for key, value := range resolved {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config %s: %w", key, err)
+		}
+		entries[key] = data
+	}
+	return entries, nil
+}
+
+// resolveInitConfig replaces secret references among string config values
+func resolveInitConfig(ctx context.Context, secrets SecretResolver, config map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		if s, ok := value.(string); ok {
+			v, err := resolveValue(ctx, secrets, s)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve config %s: %w", key, err)
+			}
+			value = v
+		}
+		resolved[key] = value
+	}
+	return resolved, nil
+}
+
+// sortedKeys returns map keys in order so instantiation is deterministic
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
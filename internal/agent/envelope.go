@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Envelope schema versions the host speaks. Guests built with an SDK
+// negotiate one of them at start; others keep the raw byte ABI.
+const (
+	MinEnvelopeVersion uint32 = 1
+	MaxEnvelopeVersion uint32 = 1
+)
+
+// ExportEnvelopeVersion is the guest export used for schema negotiation:
+// envelope_version() -> i32, the highest version the guest understands.
+// It is called before on_init; the agreed version is the lower of the
+// guest's and MaxEnvelopeVersion.
+const ExportEnvelopeVersion = "envelope_version"
+
+// MaxEnvelopeSize caps an envelope passed to the emit host function
+const MaxEnvelopeSize = 1 << 20
+
+// ErrEnvelopeVersion is returned when a guest only speaks envelope
+// versions the host does not support
+var ErrEnvelopeVersion = errors.New("unsupported envelope version")
+
+// EnvelopeKind identifies what an envelope carries
+type EnvelopeKind uint32
+
+// Envelope kinds
+const (
+	EnvelopeMessage EnvelopeKind = 1 // An agent message: From, To, Payload, Timestamp
+	EnvelopeLog     EnvelopeKind = 2 // A guest log entry: Level, Payload
+	EnvelopeConfig  EnvelopeKind = 3 // Init data and deployment config passed to on_init
+)
+
+// Envelope is the structured unit exchanged with SDK guests. On the wire
+// it is a little-endian u32 length followed by this protobuf message:
+//
+//	message Envelope {
+//	  uint32 version = 1;
+//	  uint32 kind = 2;
+//	  string from = 3;
+//	  string to = 4;
+//	  bytes payload = 5;
+//	  int64 timestamp = 6;
+//	  string level = 7;
+//	  repeated ConfigEntry config = 8; // message ConfigEntry { string key = 1; bytes value = 2; }
+//	}
+//
+// Config values are JSON encoded. Unknown fields are skipped so newer
+// guests can add fields within a version.
+type Envelope struct {
+	Version   uint32
+	Kind      EnvelopeKind
+	From      string
+	To        string
+	Payload   []byte
+	Timestamp int64
+	Level     string
+	Config    map[string][]byte
+}
+
+// Envelope field numbers
+const (
+	envFieldVersion   protowire.Number = 1
+	envFieldKind      protowire.Number = 2
+	envFieldFrom      protowire.Number = 3
+	envFieldTo        protowire.Number = 4
+	envFieldPayload   protowire.Number = 5
+	envFieldTimestamp protowire.Number = 6
+	envFieldLevel     protowire.Number = 7
+	envFieldConfig    protowire.Number = 8
+
+	configFieldKey   protowire.Number = 1
+	configFieldValue protowire.Number = 2
+)
+
+// MarshalEnvelope encodes a length-prefixed envelope
+func MarshalEnvelope(env Envelope) []byte {
+	b := make([]byte, 4, 64+len(env.Payload))
+	b = protowire.AppendTag(b, envFieldVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(env.Version))
+	b = protowire.AppendTag(b, envFieldKind, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(env.Kind))
+	if env.From != "" {
+		b = protowire.AppendTag(b, envFieldFrom, protowire.BytesType)
+		b = protowire.AppendString(b, env.From)
+	}
+	if env.To != "" {
+		b = protowire.AppendTag(b, envFieldTo, protowire.BytesType)
+		b = protowire.AppendString(b, env.To)
+	}
+	if len(env.Payload) > 0 {
+		b = protowire.AppendTag(b, envFieldPayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, env.Payload)
+	}
+	if env.Timestamp != 0 {
+		b = protowire.AppendTag(b, envFieldTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(env.Timestamp))
+	}
+	if env.Level != "" {
+		b = protowire.AppendTag(b, envFieldLevel, protowire.BytesType)
+		b = protowire.AppendString(b, env.Level)
+	}
+
+	// Entries are sorted so encodings are stable
+	keys := make([]string, 0, len(env.Config))
+	for k := range env.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, configFieldKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, configFieldValue, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, env.Config[k])
+		b = protowire.AppendTag(b, envFieldConfig, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	binary.LittleEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// UnmarshalEnvelope decodes a length-prefixed envelope
+func UnmarshalEnvelope(data []byte) (Envelope, error) {
+	var env Envelope
+	if len(data) < 4 {
+		return env, fmt.Errorf("envelope too short")
+	}
+	size := binary.LittleEndian.Uint32(data)
+	if uint64(size) != uint64(len(data)-4) {
+		return env, fmt.Errorf("envelope length %d does not match %d bytes", size, len(data)-4)
+	}
+
+	b := data[4:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return env, fmt.Errorf("malformed envelope: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if n = consumeEnvelopeField(&env, num, typ, b); n < 0 {
+			return env, fmt.Errorf("malformed envelope field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return env, nil
+}
+
+// consumeEnvelopeField decodes one field value into env, skipping unknown
+// fields, and returns the bytes consumed or a negative protowire error
+func consumeEnvelopeField(env *Envelope, num protowire.Number, typ protowire.Type, b []byte) int {
+	switch typ {
+	case protowire.VarintType:
+		v, n := protowire.ConsumeVarint(b)
+		switch num {
+		case envFieldVersion:
+			env.Version = uint32(v)
+		case envFieldKind:
+			env.Kind = EnvelopeKind(v)
+		case envFieldTimestamp:
+			env.Timestamp = int64(v)
+		}
+		return n
+	case protowire.BytesType:
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		switch num {
+		case envFieldFrom:
+			env.From = string(v)
+		case envFieldTo:
+			env.To = string(v)
+		case envFieldPayload:
+			env.Payload = append([]byte(nil), v...)
+		case envFieldLevel:
+			env.Level = string(v)
+		case envFieldConfig:
+			key, value, ok := consumeConfigEntry(v)
+			if !ok {
+				return -1
+			}
+			if env.Config == nil {
+				env.Config = make(map[string][]byte)
+			}
+			env.Config[key] = value
+		}
+		return n
+	default:
+		return protowire.ConsumeFieldValue(num, typ, b)
+	}
+}
+
+// consumeConfigEntry decodes one ConfigEntry message
+func consumeConfigEntry(b []byte) (string, []byte, bool) {
+	var key string
+	var value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, false
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType && (num == configFieldKey || num == configFieldValue) {
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				if num == configFieldKey {
+					key = string(v)
+				} else {
+					value = append([]byte(nil), v...)
+				}
+			}
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", nil, false
+		}
+		b = b[n:]
+	}
+	return key, value, true
+}
+
+// negotiateEnvelope agrees on an envelope version with a guest, returning
+// 0 for guests that do not export envelope_version. Callers must hold
+// callMu.
+func (a *Agent) negotiateEnvelope(ctx context.Context, module api.Module) (uint32, error) {
+	fn := module.ExportedFunction(ExportEnvelopeVersion)
+	if fn == nil {
+		return 0, nil
+	}
+	results, err := a.invoke(ctx, module, fn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call %s: %w", ExportEnvelopeVersion, err)
+	}
+	if len(results) == 0 {
+		return 0, fmt.Errorf("%s returned no version", ExportEnvelopeVersion)
+	}
+
+	guest := int32(results[0])
+	if guest < int32(MinEnvelopeVersion) {
+		return 0, fmt.Errorf("%w: guest speaks %d, host %d-%d", ErrEnvelopeVersion, guest, MinEnvelopeVersion, MaxEnvelopeVersion)
+	}
+	return min(uint32(guest), MaxEnvelopeVersion), nil
+}
+
+// EnvelopeVersion returns the envelope version agreed with the guest, or 0
+// if it uses the raw byte ABI
+func (a *Agent) EnvelopeVersion() uint32 {
+	return a.envelope.Load()
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ErrFuelExhausted is returned when an invocation exceeds its fuel budget
+var ErrFuelExhausted = errors.New("fuel exhausted")
+
+// fuelMeter tracks fuel consumed by a single invocation through the fuel
+// global that instrumentFuel adds to metered modules, which charge one unit
+// per instruction
+type fuelMeter struct {
+	limit  int64
+	global api.MutableGlobal
+}
+
+// startFuelMeter fills the module's fuel global with the invocation's
+// budget. Modules compiled without metering get a meter that reports no
+// consumption.
+func startFuelMeter(module api.Module, limit uint64) *fuelMeter {
+	m := &fuelMeter{limit: int64(min(limit, math.MaxInt64))}
+	if g, ok := module.ExportedGlobal(FuelGlobal).(api.MutableGlobal); ok {
+		m.global = g
+		g.Set(uint64(m.limit))
+	}
+	return m
+}
+
+// stop returns the fuel consumed since the meter started and whether the
+// budget ran out. The counter is emptied so calls that bypass invoke run
+// no metered code.
+func (m *fuelMeter) stop() (consumed uint64, exhausted bool) {
+	if m.global == nil {
+		return 0, false
+	}
+	left := int64(m.global.Get())
+	m.global.Set(0)
+	return uint64(m.limit - left), left < 0
+}
+
+// fuelTrap replaces the unreachable trap raised by metering code with
+// ErrFuelExhausted, keeping the guest stack trace
+func fuelTrap(err error) error {
+	if _, stack, ok := strings.Cut(err.Error(), "\nwasm stack trace:\n"); ok {
+		return fmt.Errorf("%w\nwasm stack trace:\n%s", ErrFuelExhausted, stack)
+	}
+	return ErrFuelExhausted
+}
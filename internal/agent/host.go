@@ -0,0 +1,721 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// MaxRandomSize caps the number of bytes a single random call may fill
+const MaxRandomSize = 64 << 10
+
+// MaxLogMessageSize caps the number of bytes a single guest log call may emit
+const MaxLogMessageSize = 4096
+
+// Status codes returned to guest code by host functions
+const (
+	StatusOK uint32 = iota
+	StatusInvalidArgument
+	StatusNotFound
+	StatusRejected
+	StatusFailed
+	StatusUnavailable
+	StatusQuotaExceeded
+)
+
+// hostFunction describes a host function and the capability gating it
+type hostFunction struct {
+	name       string
+	fn         interface{}
+	capability Capability // Empty for functions every agent receives
+}
+
+// hostFunctions lists every function the env host module can export
+var hostFunctions = []hostFunction{
+	{name: "log", fn: hostLog, capability: CapabilityLog},
+	{name: "send", fn: hostSend, capability: CapabilitySend},
+	{name: "emit", fn: hostEmit},
+	{name: "publish", fn: hostPublish, capability: CapabilityPubSub},
+	{name: "subscribe", fn: hostSubscribe, capability: CapabilityPubSub},
+	{name: "unsubscribe", fn: hostUnsubscribe, capability: CapabilityPubSub},
+	{name: "kv_get", fn: hostKVGet, capability: CapabilityKV},
+	{name: "kv_put", fn: hostKVPut, capability: CapabilityKV},
+	{name: "kv_delete", fn: hostKVDelete, capability: CapabilityKV},
+	{name: "kv_scan", fn: hostKVScan, capability: CapabilityKV},
+	{name: "soul_remember", fn: hostSoulRemember, capability: CapabilitySoul},
+	{name: "soul_recall", fn: hostSoulRecall, capability: CapabilitySoul},
+	{name: "soul_get_value", fn: hostSoulGetValue, capability: CapabilitySoul},
+	{name: "soul_set_value", fn: hostSoulSetValue, capability: CapabilitySoul},
+	{name: "http_fetch", fn: hostHTTPFetch, capability: CapabilityHTTP},
+	{name: "http_result", fn: hostHTTPResult, capability: CapabilityHTTP},
+	{name: "crypto_sha256", fn: hostSHA256, capability: CapabilityCrypto},
+	{name: "crypto_sign", fn: hostSign, capability: CapabilityCrypto},
+	{name: "crypto_verify", fn: hostVerify, capability: CapabilityCrypto},
+	{name: "crypto_public_key", fn: hostPublicKey, capability: CapabilityCrypto},
+	{name: "time_now", fn: hostTimeNow},
+	{name: "random", fn: hostRandom},
+	{name: "get_memory", fn: hostGetMemory},
+	{name: "set_memory", fn: hostSetMemory},
+}
+
+// Logger receives log entries emitted by agents
+type Logger interface {
+	AddLog(level, component, message string, fields map[string]interface{})
+}
+
+// agentContextKey is the context key carrying the calling agent
+type agentContextKey struct{}
+
+// withAgent returns a context carrying the agent for host function calls
+func withAgent(ctx context.Context, a *Agent) context.Context {
+	return context.WithValue(ctx, agentContextKey{}, a)
+}
+
+// agentFromContext returns the agent a host function is being called for
+func agentFromContext(ctx context.Context) *Agent {
+	a, _ := ctx.Value(agentContextKey{}).(*Agent)
+	return a
+}
+
+// grantedAgent returns the calling agent if it holds the capability. Pooled
+// runtimes export every host function, so gated functions check here too.
+func grantedAgent(ctx context.Context, c Capability) *Agent {
+	a := agentFromContext(ctx)
+	if a == nil || !a.caps.has(c) {
+		return nil
+	}
+	return a
+}
+
+// readGuestBytes copies a region of guest memory
+func readGuestBytes(m api.Module, offset, length uint32) ([]byte, bool) {
+	mem := linearMemory(m)
+	if mem == nil {
+		return nil, false
+	}
+	buf, ok := mem.Read(offset, length)
+	if !ok {
+		return nil, false
+	}
+
+	// Copy since the view is invalidated if the guest grows its memory
+	result := make([]byte, len(buf))
+	copy(result, buf)
+	return result, true
+}
+
+// writeGuestBytes copies data into guest memory, failing if the module has
+// no memory or the region is out of range
+func writeGuestBytes(m api.Module, offset uint32, data []byte) bool {
+	mem := linearMemory(m)
+	return mem != nil && mem.Write(offset, data)
+}
+
+// writeSized writes data into a guest buffer using the sized-result
+// convention: the data length is returned, and nothing is written when the
+// buffer is too small so the guest can retry with a larger one
+func writeSized(m api.Module, data []byte, bufOffset, bufLength uint32) int64 {
+	if uint32(len(data)) > bufLength {
+		return int64(len(data))
+	}
+	if !writeGuestBytes(m, bufOffset, data) {
+		return -int64(StatusInvalidArgument)
+	}
+	return int64(len(data))
+}
+
+// Host functions exposed to WebAssembly modules
+
+func hostLog(ctx context.Context, m api.Module, offset, length uint32) {
+	a := grantedAgent(ctx, CapabilityLog)
+	if a == nil || a.logger == nil {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"agent_id": a.ID,
+	}
+
+	truncated := false
+	if length > MaxLogMessageSize {
+		length = MaxLogMessageSize
+		truncated = true
+	}
+
+	buf, ok := readGuestBytes(m, offset, length)
+	if !ok {
+		fields["offset"] = offset
+		fields["length"] = length
+		a.logger.AddLog("warn", "agent", "log message out of memory bounds", fields)
+		return
+	}
+
+	if truncated {
+		fields["truncated"] = true
+	}
+
+	a.logger.AddLog("info", "agent", string(buf), fields)
+}
+
+func hostSend(ctx context.Context, m api.Module, targetOffset, targetLength, msgOffset, msgLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilitySend)
+	if a == nil || a.messenger == nil {
+		return StatusUnavailable
+	}
+
+	target, ok := readGuestBytes(m, targetOffset, targetLength)
+	if !ok || len(target) == 0 {
+		return StatusInvalidArgument
+	}
+	payload, ok := readGuestBytes(m, msgOffset, msgLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	return a.sendMessage(ctx, string(target), payload)
+}
+
+// sendMessage delivers a guest message through the messenger and maps the
+// outcome to a status
+func (a *Agent) sendMessage(ctx context.Context, target string, payload []byte) uint32 {
+	if topic, ok := strings.CutPrefix(target, TopicPrefix); ok && !a.topics.CanPublish(topic) {
+		a.audit("publish_denied", map[string]interface{}{"topic": topic})
+		return StatusRejected
+	}
+
+	if err := a.messenger.Send(ctx, a.ID, target, payload); err != nil {
+		switch {
+		case errors.Is(err, ErrTargetNotFound):
+			return StatusNotFound
+		case errors.Is(err, ErrInboxFull):
+			return StatusRejected
+		default:
+			return StatusFailed
+		}
+	}
+	return StatusOK
+}
+
+// hostEmit accepts an envelope from a guest that negotiated envelopes:
+// message envelopes are sent like send() and log envelopes are logged like
+// log(), each under the same capability
+func hostEmit(ctx context.Context, m api.Module, offset, length uint32) uint32 {
+	a := agentFromContext(ctx)
+	if a == nil || a.envelope.Load() == 0 {
+		return StatusUnavailable
+	}
+	if length > MaxEnvelopeSize {
+		return StatusInvalidArgument
+	}
+
+	raw, ok := readGuestBytes(m, offset, length)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	env, err := UnmarshalEnvelope(raw)
+	if err != nil || env.Version < MinEnvelopeVersion || env.Version > MaxEnvelopeVersion {
+		return StatusInvalidArgument
+	}
+
+	switch env.Kind {
+	case EnvelopeMessage:
+		if !a.caps.has(CapabilitySend) || a.messenger == nil {
+			return StatusUnavailable
+		}
+		if env.To == "" {
+			return StatusInvalidArgument
+		}
+		return a.sendMessage(ctx, env.To, env.Payload)
+	case EnvelopeLog:
+		if !a.caps.has(CapabilityLog) || a.logger == nil {
+			return StatusUnavailable
+		}
+		level := env.Level
+		switch level {
+		case "debug", "info", "warn", "error":
+		default:
+			level = "info"
+		}
+		fields := map[string]interface{}{"agent_id": a.ID}
+		msg := env.Payload
+		if len(msg) > MaxLogMessageSize {
+			msg = msg[:MaxLogMessageSize]
+			fields["truncated"] = true
+		}
+		a.logger.AddLog(level, "agent", string(msg), fields)
+		return StatusOK
+	default:
+		return StatusInvalidArgument
+	}
+}
+
+func hostPublish(ctx context.Context, m api.Module, topicOffset, topicLength, msgOffset, msgLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityPubSub)
+	if a == nil || a.pubsub == nil {
+		return StatusUnavailable
+	}
+
+	topic, ok := readGuestBytes(m, topicOffset, topicLength)
+	if !ok || len(topic) == 0 {
+		return StatusInvalidArgument
+	}
+	payload, ok := readGuestBytes(m, msgOffset, msgLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	if !a.topics.CanPublish(string(topic)) {
+		a.audit("publish_denied", map[string]interface{}{"topic": string(topic)})
+		return StatusRejected
+	}
+
+	if err := a.pubsub.Publish(ctx, a.ID, string(topic), payload); err != nil {
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+func hostSubscribe(ctx context.Context, m api.Module, topicOffset, topicLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityPubSub)
+	if a == nil || a.pubsub == nil {
+		return StatusUnavailable
+	}
+
+	topic, ok := readGuestBytes(m, topicOffset, topicLength)
+	if !ok || len(topic) == 0 {
+		return StatusInvalidArgument
+	}
+	if !a.topics.CanSubscribe(string(topic)) {
+		a.audit("subscribe_denied", map[string]interface{}{"topic": string(topic)})
+		return StatusRejected
+	}
+
+	if err := a.pubsub.Subscribe(ctx, a.ID, string(topic)); err != nil {
+		return StatusFailed
+	}
+	a.audit("subscribe", map[string]interface{}{"topic": string(topic)})
+	return StatusOK
+}
+
+func hostUnsubscribe(ctx context.Context, m api.Module, topicOffset, topicLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityPubSub)
+	if a == nil || a.pubsub == nil {
+		return StatusUnavailable
+	}
+
+	topic, ok := readGuestBytes(m, topicOffset, topicLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	a.pubsub.Unsubscribe(a.ID, string(topic))
+	return StatusOK
+}
+
+func hostKVGet(ctx context.Context, m api.Module, keyOffset, keyLength, bufOffset, bufLength uint32) int64 {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		return -int64(StatusUnavailable)
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		return -int64(StatusInvalidArgument)
+	}
+
+	value, err := a.kv.Get(key)
+	if err != nil {
+		return -int64(StatusFailed)
+	}
+	if value == nil {
+		return -int64(StatusNotFound)
+	}
+	return writeSized(m, value, bufOffset, bufLength)
+}
+
+func hostKVPut(ctx context.Context, m api.Module, keyOffset, keyLength, valueOffset, valueLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		return StatusUnavailable
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	value, ok := readGuestBytes(m, valueOffset, valueLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	if err := a.kv.Put(key, value); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			return StatusQuotaExceeded
+		}
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+func hostKVDelete(ctx context.Context, m api.Module, keyOffset, keyLength uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		return StatusUnavailable
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	if err := a.kv.Delete(key); err != nil {
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+func hostKVScan(ctx context.Context, m api.Module, prefixOffset, prefixLength, bufOffset, bufLength uint32) int64 {
+	a := grantedAgent(ctx, CapabilityKV)
+	if a == nil || a.kv == nil {
+		return -int64(StatusUnavailable)
+	}
+
+	prefix, ok := readGuestBytes(m, prefixOffset, prefixLength)
+	if !ok {
+		return -int64(StatusInvalidArgument)
+	}
+
+	keys, err := a.kv.Keys(prefix)
+	if err != nil {
+		return -int64(StatusFailed)
+	}
+	return writeSized(m, encodeKeyList(keys), bufOffset, bufLength)
+}
+
+// hostSoulRemember appends a JSON SoulMemory to the bound soul, stamped
+// with the agent clock
+func hostSoulRemember(ctx context.Context, m api.Module, entryOffset, entryLength uint32) uint32 {
+	a, b := boundSoul(ctx)
+	if b == nil {
+		return StatusUnavailable
+	}
+	if !b.access.Remember {
+		a.audit("soul_remember_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		return StatusRejected
+	}
+	if entryLength > MaxSoulMemorySize {
+		return StatusInvalidArgument
+	}
+
+	raw, ok := readGuestBytes(m, entryOffset, entryLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	var entry SoulMemory
+	if err := json.Unmarshal(raw, &entry); err != nil || entry.Content == "" {
+		return StatusInvalidArgument
+	}
+
+	err := b.soul.AddMemory(soul.MemoryEntry{
+		Timestamp: a.clock.Now().UnixNano(),
+		Content:   entry.Content,
+		Type:      entry.Type,
+		Tags:      entry.Tags,
+	})
+	if errors.Is(err, soul.ErrQuotaExceeded) {
+		return StatusQuotaExceeded
+	}
+	if err != nil {
+		return StatusFailed
+	}
+	return StatusOK
+}
+
+// hostSoulRecall writes the bound soul's memories as a JSON array of
+// SoulMemory. The query is a JSON array of tags, any of which a memory must
+// carry, or a JSON soul.MemoryQuery object; an empty query matches all.
+func hostSoulRecall(ctx context.Context, m api.Module, queryOffset, queryLength, bufOffset, bufLength uint32) int64 {
+	a, b := boundSoul(ctx)
+	if b == nil {
+		return -int64(StatusUnavailable)
+	}
+	if !b.access.Recall {
+		a.audit("soul_recall_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		return -int64(StatusRejected)
+	}
+
+	var query soul.MemoryQuery
+	if queryLength > 0 {
+		raw, ok := readGuestBytes(m, queryOffset, queryLength)
+		if !ok {
+			return -int64(StatusInvalidArgument)
+		}
+		var tags []string
+		if err := json.Unmarshal(raw, &tags); err == nil {
+			if len(tags) > 0 {
+				expr := soul.AnyTag(tags...)
+				query.Tags = &expr
+			}
+		} else if err := json.Unmarshal(raw, &query); err != nil {
+			return -int64(StatusInvalidArgument)
+		}
+	}
+
+	memories, err := b.soul.Query(query)
+	if err != nil {
+		return -int64(StatusInvalidArgument)
+	}
+	data, err := json.Marshal(soulMemories(memories))
+	if err != nil {
+		return -int64(StatusFailed)
+	}
+	return writeSized(m, data, bufOffset, bufLength)
+}
+
+// hostSoulGetValue writes a soul value as a little-endian f64 at outOffset
+func hostSoulGetValue(ctx context.Context, m api.Module, keyOffset, keyLength, outOffset uint32) uint32 {
+	a, b := boundSoul(ctx)
+	if b == nil {
+		return StatusUnavailable
+	}
+	if !b.access.Values {
+		a.audit("soul_get_value_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		return StatusRejected
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	value, found := b.soul.GetValue(string(key))
+	if !found {
+		return StatusNotFound
+	}
+	mem := linearMemory(m)
+	if mem == nil || !mem.WriteFloat64Le(outOffset, value) {
+		return StatusInvalidArgument
+	}
+	return StatusOK
+}
+
+// hostSoulSetValue sets a soul value, recording the agent as its author
+func hostSoulSetValue(ctx context.Context, m api.Module, keyOffset, keyLength uint32, value float64) uint32 {
+	a, b := boundSoul(ctx)
+	if b == nil {
+		return StatusUnavailable
+	}
+	if !b.access.Write {
+		a.audit("soul_set_value_denied", map[string]interface{}{"soul_id": b.soul.ID})
+		return StatusRejected
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return StatusInvalidArgument
+	}
+
+	key, ok := readGuestBytes(m, keyOffset, keyLength)
+	if !ok || len(key) == 0 {
+		return StatusInvalidArgument
+	}
+	b.soul.SetValueAs(a.ID, string(key), value)
+	return StatusOK
+}
+
+func hostHTTPFetch(ctx context.Context, m api.Module, reqOffset, reqLength uint32) int64 {
+	a := grantedAgent(ctx, CapabilityHTTP)
+	if a == nil || a.http == nil {
+		return -int64(StatusUnavailable)
+	}
+
+	raw, ok := readGuestBytes(m, reqOffset, reqLength)
+	if !ok {
+		return -int64(StatusInvalidArgument)
+	}
+	var req HTTPRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return -int64(StatusInvalidArgument)
+	}
+
+	started := time.Now()
+	resp, err := a.http.fetch(ctx, req)
+
+	fields := map[string]interface{}{
+		"method":   req.Method,
+		"url":      redactURL(req.URL),
+		"duration": time.Since(started).String(),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	} else {
+		fields["status"] = resp.Status
+		fields["bytes"] = len(resp.Body)
+	}
+	a.audit("http_fetch", fields)
+
+	switch {
+	case err == nil:
+		return int64(len(a.http.lastResult()))
+	case errors.Is(err, ErrDomainNotAllowed):
+		return -int64(StatusRejected)
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrResponseTooLarge):
+		return -int64(StatusQuotaExceeded)
+	default:
+		return -int64(StatusFailed)
+	}
+}
+
+func hostHTTPResult(ctx context.Context, m api.Module, bufOffset, bufLength uint32) int64 {
+	a := grantedAgent(ctx, CapabilityHTTP)
+	if a == nil || a.http == nil {
+		return -int64(StatusUnavailable)
+	}
+
+	result := a.http.lastResult()
+	if result == nil {
+		return -int64(StatusNotFound)
+	}
+	return writeSized(m, result, bufOffset, bufLength)
+}
+
+func hostSHA256(ctx context.Context, m api.Module, dataOffset, dataLength, outOffset uint32) uint32 {
+	if grantedAgent(ctx, CapabilityCrypto) == nil {
+		return StatusUnavailable
+	}
+
+	data, ok := readGuestBytes(m, dataOffset, dataLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	sum := sha256.Sum256(data)
+	if !writeGuestBytes(m, outOffset, sum[:]) {
+		return StatusInvalidArgument
+	}
+	return StatusOK
+}
+
+// hostSign signs a message with the calling agent's key, writing a
+// 64-byte signature
+func hostSign(ctx context.Context, m api.Module, msgOffset, msgLength, sigOffset uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityCrypto)
+	if a == nil || a.keys == nil {
+		return StatusUnavailable
+	}
+
+	msg, ok := readGuestBytes(m, msgOffset, msgLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	priv, err := a.keys.PrivateKey(a.ID)
+	if err != nil {
+		return StatusFailed
+	}
+	if !writeGuestBytes(m, sigOffset, ed25519.Sign(priv, msg)) {
+		return StatusInvalidArgument
+	}
+	return StatusOK
+}
+
+// hostVerify checks a 64-byte signature against a 32-byte public key,
+// returning StatusRejected for invalid signatures
+func hostVerify(ctx context.Context, m api.Module, pubOffset, msgOffset, msgLength, sigOffset uint32) uint32 {
+	if grantedAgent(ctx, CapabilityCrypto) == nil {
+		return StatusUnavailable
+	}
+
+	pub, ok := readGuestBytes(m, pubOffset, ed25519.PublicKeySize)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	msg, ok := readGuestBytes(m, msgOffset, msgLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+	sig, ok := readGuestBytes(m, sigOffset, ed25519.SignatureSize)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	if !ed25519.Verify(pub, msg, sig) {
+		return StatusRejected
+	}
+	return StatusOK
+}
+
+// hostPublicKey writes the 32-byte public key of a local agent. An empty
+// agent ID selects the calling agent.
+func hostPublicKey(ctx context.Context, m api.Module, idOffset, idLength, outOffset uint32) uint32 {
+	a := grantedAgent(ctx, CapabilityCrypto)
+	if a == nil || a.keys == nil {
+		return StatusUnavailable
+	}
+
+	id, ok := readGuestBytes(m, idOffset, idLength)
+	if !ok {
+		return StatusInvalidArgument
+	}
+
+	var pub ed25519.PublicKey
+	if len(id) == 0 {
+		priv, err := a.keys.PrivateKey(a.ID)
+		if err != nil {
+			return StatusFailed
+		}
+		pub = priv.Public().(ed25519.PublicKey)
+	} else {
+		var err error
+		if pub, err = a.keys.PublicKey(string(id)); err != nil {
+			return StatusFailed
+		}
+		if pub == nil {
+			return StatusNotFound
+		}
+	}
+
+	if !writeGuestBytes(m, outOffset, pub) {
+		return StatusInvalidArgument
+	}
+	return StatusOK
+}
+
+// hostTimeNow returns the agent clock's time in Unix nanoseconds
+func hostTimeNow(ctx context.Context) int64 {
+	a := agentFromContext(ctx)
+	if a == nil {
+		return 0
+	}
+	return a.clock.Now().UnixNano()
+}
+
+func hostRandom(ctx context.Context, m api.Module, bufOffset, bufLength uint32) uint32 {
+	a := agentFromContext(ctx)
+	if a == nil {
+		return StatusUnavailable
+	}
+	if bufLength > MaxRandomSize {
+		return StatusInvalidArgument
+	}
+
+	buf := make([]byte, bufLength)
+	if _, err := a.clock.Read(buf); err != nil {
+		return StatusFailed
+	}
+	if !writeGuestBytes(m, bufOffset, buf) {
+		return StatusInvalidArgument
+	}
+	return StatusOK
+}
+
+func hostGetMemory(ctx context.Context, m api.Module, offset, length uint32) {
+	// Implementation for reading from agent memory
+}
+
+func hostSetMemory(ctx context.Context, m api.Module, offset, length uint32) {
+	// Implementation for writing to agent memory
+}
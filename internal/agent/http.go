@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDomainNotAllowed is returned when a request targets a host outside the allowlist
+	ErrDomainNotAllowed = errors.New("domain not allowed")
+	// ErrRateLimited is returned when an agent exceeds its request rate
+	ErrRateLimited = errors.New("rate limited")
+	// ErrResponseTooLarge is returned when a response body exceeds the size cap
+	ErrResponseTooLarge = errors.New("response too large")
+	// errTooManyRedirects ends a redirect chain longer than five hops
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// DefaultHTTPPolicy defines default limits for outbound agent requests.
+// No domains are allowed unless explicitly listed.
+var DefaultHTTPPolicy = HTTPPolicy{
+	MaxResponseBytes:  1 << 20, // 1MB
+	Timeout:           10 * time.Second,
+	RequestsPerMinute: 60,
+}
+
+// HTTPPolicy controls outbound HTTP requests made by an agent
+type HTTPPolicy struct {
+	AllowedDomains    []string // Exact hosts, or "*.example.com" to include subdomains
+	MaxResponseBytes  int64
+	Timeout           time.Duration // Per request, cut short by the calling invocation's deadline
+	RequestsPerMinute int
+}
+
+// HTTPRequest is the JSON request guests pass to http_fetch
+type HTTPRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+}
+
+// HTTPResponse is the JSON response guests read with http_result
+type HTTPResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+}
+
+// httpFetcher performs policy-checked requests for a single agent
+type httpFetcher struct {
+	policy  HTTPPolicy
+	client  *http.Client
+	limiter *rateLimiter
+
+	mu     sync.Mutex
+	result []byte
+}
+
+// newHTTPFetcher creates a fetcher enforcing the policy
+func newHTTPFetcher(policy HTTPPolicy) *httpFetcher {
+	f := &httpFetcher{
+		policy:  policy,
+		limiter: newRateLimiter(policy.RequestsPerMinute, time.Minute),
+	}
+	f.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errTooManyRedirects
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// fetch performs the request and retains the encoded response for
+// http_result. The request ends at the policy timeout or when ctx does,
+// whichever comes first, so it cannot outlive the guest call making it.
+func (f *httpFetcher) fetch(ctx context.Context, req HTTPRequest) (*HTTPResponse, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if err := f.checkURL(target); err != nil {
+		return nil, err
+	}
+	if !f.limiter.allow() {
+		return nil, ErrRateLimited
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.policy.Timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := f.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, f.policy.MaxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > f.policy.MaxResponseBytes {
+		return nil, ErrResponseTooLarge
+	}
+
+	resp := &HTTPResponse{
+		Status:  httpResp.StatusCode,
+		Headers: make(map[string]string, len(httpResp.Header)),
+		Body:    body,
+	}
+	for k := range httpResp.Header {
+		resp.Headers[k] = httpResp.Header.Get(k)
+	}
+
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+	f.mu.Lock()
+	f.result = encoded
+	f.mu.Unlock()
+
+	return resp, nil
+}
+
+// lastResult returns the encoded response of the most recent fetch
+func (f *httpFetcher) lastResult() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.result
+}
+
+// checkURL verifies the scheme and host against the policy
+func (f *httpFetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrDomainNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range f.policy.AllowedDomains {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+		} else if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDomainNotAllowed, host)
+}
+
+// redactURL strips credentials and query values from a URL for logging,
+// keeping the query keys
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.User = nil
+	u.Fragment = ""
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{"REDACTED"}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// rateLimiter is a token bucket refilled continuously over a period
+type rateLimiter struct {
+	capacity float64
+	rate     float64 // tokens per second
+	tokens   float64
+	last     time.Time
+	mu       sync.Mutex
+}
+
+// newRateLimiter allows up to limit events per period; limit <= 0 disables limiting
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		capacity: float64(limit),
+		rate:     float64(limit) / period.Seconds(),
+		tokens:   float64(limit),
+		last:     time.Now(),
+	}
+}
+
+// allow consumes a token if one is available
+func (l *rateLimiter) allow() bool {
+	if l.capacity <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// InstancePool runs several instances of one agent module so CPU-bound
+// agents can use multiple cores without guest-side threading. Instances
+// share the agent's mailbox, KV namespace and identity; each idle instance
+// takes the next queued message, and direct calls such as Tick rotate
+// round robin.
+type InstancePool struct {
+	ID        string
+	instances []*Agent
+	mailbox   *Mailbox
+	next      atomic.Uint64
+}
+
+// NewInstancePool creates size instances of the agent described by cfg
+func NewInstancePool(ctx context.Context, cfg Config, limits ResourceLimits, size int) (*InstancePool, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("pool size must be greater than 0")
+	}
+
+	p := &InstancePool{
+		ID:      cfg.ID,
+		mailbox: NewMailbox(cfg.ID, cfg.Mailbox, mailboxMetrics(cfg.Metrics)),
+	}
+
+	var ns *Namespace
+	if cfg.Store != nil {
+		var err error
+		if ns, err = NewNamespace(cfg.Store, cfg.ID, cfg.KVQuota.withDefaults()); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		instanceCfg := cfg
+		instanceCfg.instance = i
+		instanceCfg.sharedMailbox = p.mailbox
+		instanceCfg.sharedNamespace = ns
+
+		a, err := New(ctx, instanceCfg, limits)
+		if err != nil {
+			p.Stop(ctx)
+			return nil, fmt.Errorf("failed to create instance %d: %w", i, err)
+		}
+		p.instances = append(p.instances, a)
+	}
+	return p, nil
+}
+
+// Start starts every instance
+func (p *InstancePool) Start(ctx context.Context) error {
+	for _, a := range p.instances {
+		if err := a.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start instance %d: %w", a.instance, err)
+		}
+	}
+	return nil
+}
+
+// Next returns the next live instance in round-robin order, or nil if
+// every instance has stopped
+func (p *InstancePool) Next() *Agent {
+	for range p.instances {
+		a := p.instances[p.next.Add(1)%uint64(len(p.instances))]
+		select {
+		case <-a.Done():
+		default:
+			return a
+		}
+	}
+	return nil
+}
+
+// Tick calls on_tick on the next instance
+func (p *InstancePool) Tick(ctx context.Context, step uint64) error {
+	a := p.Next()
+	if a == nil {
+		return fmt.Errorf("agent %s has no live instances", p.ID)
+	}
+	return a.Tick(ctx, step)
+}
+
+// Register makes the pool reachable through a router. Messages are queued
+// in the shared mailbox, so any instance may be registered.
+func (p *InstancePool) Register(r *Router) {
+	r.Register(p.instances[0])
+}
+
+// Mailbox returns the queue shared by all instances
+func (p *InstancePool) Mailbox() *Mailbox {
+	return p.mailbox
+}
+
+// Instances returns the pool members
+func (p *InstancePool) Instances() []*Agent {
+	return p.instances
+}
+
+// Stats returns resource usage for each instance
+func (p *InstancePool) Stats() []Stats {
+	stats := make([]Stats, len(p.instances))
+	for i, a := range p.instances {
+		stats[i] = a.Stats()
+	}
+	return stats
+}
+
+// Stop closes the shared mailbox and stops every instance
+func (p *InstancePool) Stop(ctx context.Context) error {
+	p.mailbox.Close()
+
+	var errs []error
+	for _, a := range p.instances {
+		if err := a.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop instance %d: %w", a.instance, err))
+		}
+	}
+	return errors.Join(errs...)
+}
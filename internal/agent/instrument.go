@@ -0,0 +1,536 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// FuelGlobal is the export under which metered modules expose their fuel
+// counter, a mutable i64 holding the fuel left in the current invocation
+const FuelGlobal = "__matrix_fuel"
+
+// Wasm section IDs the instrumentation reads or writes
+const (
+	sectionCustom byte = 0
+	sectionImport byte = 2
+	sectionGlobal byte = 6
+	sectionExport byte = 7
+	sectionCode   byte = 10
+)
+
+// External kinds of imports and exports
+const (
+	externFunc   byte = 0x00
+	externTable  byte = 0x01
+	externMemory byte = 0x02
+	externGlobal byte = 0x03
+	externTag    byte = 0x04
+)
+
+// sectionOrder ranks non-custom sections in the order the binary format
+// requires them, so injected sections land in a valid position
+var sectionOrder = map[byte]int{
+	1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13,
+}
+
+// wasmSection is one section of a module binary
+type wasmSection struct {
+	id      byte
+	payload []byte
+}
+
+// instrumentFuel rewrites a core module so it meters its own execution.
+// A mutable i64 global is added and exported as FuelGlobal; every basic
+// block first subtracts its instruction count from it and executes
+// unreachable once it goes negative, so each instruction costs one unit
+// of fuel and tight loops are bounded like any other code. DWARF sections
+// are dropped since their code offsets no longer hold.
+func instrumentFuel(code []byte) ([]byte, error) {
+	all, err := readSections(code)
+	if err != nil {
+		return nil, err
+	}
+	var sections []wasmSection
+	for _, s := range all {
+		if s.id == sectionCustom && strings.HasPrefix(customSectionName(s.payload), ".debug_") {
+			continue
+		}
+		sections = append(sections, s)
+	}
+
+	// The counter is appended after every existing global, so its index is
+	// one past the last index the original code could validly reference
+	var fuelGlobal uint32
+	for _, s := range sections {
+		var n uint32
+		var err error
+		switch s.id {
+		case sectionImport:
+			n, err = countImportedGlobals(s.payload)
+		case sectionGlobal:
+			n, err = (&wasmReader{b: s.payload}).vecLen()
+		}
+		if err != nil {
+			return nil, err
+		}
+		fuelGlobal += n
+	}
+
+	global := []byte{0x7e, 0x01, 0x42, 0x00, 0x0b} // mut i64, init i64.const 0
+	export := append(wasmName(FuelGlobal), 0x03)
+	export = appendULEB128(export, uint64(fuelGlobal))
+
+	if sections, err = appendVecEntry(sections, sectionGlobal, global); err != nil {
+		return nil, err
+	}
+	for _, s := range sections {
+		if s.id == sectionExport {
+			if err := checkExports(s.payload, fuelGlobal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if sections, err = appendVecEntry(sections, sectionExport, export); err != nil {
+		return nil, err
+	}
+	for i, s := range sections {
+		if s.id == sectionCode {
+			if sections[i].payload, err = meterCode(s.payload, fuelGlobal); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return writeSections(code[:8], sections), nil
+}
+
+// readSections splits a module binary into its sections
+func readSections(code []byte) ([]wasmSection, error) {
+	if len(code) < 8 || !bytes.HasPrefix(code, wasmMagic) {
+		return nil, fmt.Errorf("not a wasm module")
+	}
+	var sections []wasmSection
+	r := &wasmReader{b: code, pos: 8}
+	for !r.done() {
+		id := r.byte()
+		size := r.u32()
+		payload := r.bytes(int(size))
+		if r.err != nil {
+			return nil, fmt.Errorf("malformed wasm section: %w", r.err)
+		}
+		sections = append(sections, wasmSection{id: id, payload: payload})
+	}
+	return sections, nil
+}
+
+// writeSections encodes sections after a module header
+func writeSections(header []byte, sections []wasmSection) []byte {
+	out := append([]byte(nil), header...)
+	for _, s := range sections {
+		out = append(out, s.id)
+		out = appendULEB128(out, uint64(len(s.payload)))
+		out = append(out, s.payload...)
+	}
+	return out
+}
+
+// customSectionName returns the name of a custom section payload
+func customSectionName(payload []byte) string {
+	r := &wasmReader{b: payload}
+	name := r.bytes(int(r.u32()))
+	if r.err != nil {
+		return ""
+	}
+	return string(name)
+}
+
+// countImportedGlobals counts the global imports of an import section
+func countImportedGlobals(payload []byte) (uint32, error) {
+	r := &wasmReader{b: payload}
+	var globals uint32
+	for i, n := uint32(0), r.u32(); i < n && r.err == nil; i++ {
+		r.bytes(int(r.u32())) // module
+		r.bytes(int(r.u32())) // field
+		if r.importDesc() == externGlobal {
+			globals++
+		}
+	}
+	if r.err != nil {
+		return 0, fmt.Errorf("malformed import section: %w", r.err)
+	}
+	return globals, nil
+}
+
+// checkExports rejects modules that already export the name or index the
+// fuel global is given
+func checkExports(payload []byte, fuelGlobal uint32) error {
+	r := &wasmReader{b: payload}
+	for i, n := uint32(0), r.u32(); i < n && r.err == nil; i++ {
+		name := r.bytes(int(r.u32()))
+		kind, index := r.byte(), r.u32()
+		if string(name) == FuelGlobal || (kind == externGlobal && index >= fuelGlobal) {
+			return fmt.Errorf("module exports reserved global %s", FuelGlobal)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("malformed export section: %w", r.err)
+	}
+	return nil
+}
+
+// appendVecEntry appends an entry to the vector held by the section with
+// the given ID, creating the section in order if the module lacks it
+func appendVecEntry(sections []wasmSection, id byte, entry []byte) ([]wasmSection, error) {
+	for i, s := range sections {
+		if s.id != id {
+			continue
+		}
+		r := &wasmReader{b: s.payload}
+		n := r.u32()
+		if r.err != nil {
+			return nil, fmt.Errorf("malformed section %d: %w", id, r.err)
+		}
+		payload := appendULEB128(nil, uint64(n)+1)
+		payload = append(payload, s.payload[r.pos:]...)
+		sections[i].payload = append(payload, entry...)
+		return sections, nil
+	}
+
+	at := len(sections)
+	for i, s := range sections {
+		if s.id != sectionCustom && sectionOrder[s.id] > sectionOrder[id] {
+			at = i
+			break
+		}
+	}
+	added := wasmSection{id: id, payload: append([]byte{0x01}, entry...)}
+	return append(sections[:at], append([]wasmSection{added}, sections[at:]...)...), nil
+}
+
+// meterCode inserts a fuel charge at the start of every basic block of
+// every function body in a code section
+func meterCode(payload []byte, fuelGlobal uint32) ([]byte, error) {
+	r := &wasmReader{b: payload}
+	n := r.u32()
+	out := appendULEB128(nil, uint64(n))
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		body := r.bytes(int(r.u32()))
+		if r.err != nil {
+			break
+		}
+		metered, err := meterBody(body, fuelGlobal)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", i, err)
+		}
+		out = appendULEB128(out, uint64(len(metered)))
+		out = append(out, metered...)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed code section: %w", r.err)
+	}
+	return out, nil
+}
+
+// meterBody instruments one function body. A basic block ends after any
+// instruction that starts, ends or may leave a structured block; the code
+// after an unconditional branch up to the next end is charged to the
+// block before it.
+func meterBody(body []byte, fuelGlobal uint32) ([]byte, error) {
+	r := &wasmReader{b: body}
+	for i, n := uint32(0), r.u32(); i < n && r.err == nil; i++ {
+		r.u32()  // count
+		r.byte() // type
+	}
+	out := append([]byte(nil), body[:r.pos]...)
+
+	start, cost := r.pos, uint64(0)
+	for !r.done() && r.err == nil {
+		op := r.instruction(fuelGlobal)
+		cost++
+		if r.err != nil || !endsBlock(op) {
+			continue
+		}
+		out = appendFuelCharge(out, fuelGlobal, cost)
+		out = append(out, body[start:r.pos]...)
+		start, cost = r.pos, 0
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if cost > 0 {
+		return nil, fmt.Errorf("function body does not end with end")
+	}
+	return out, nil
+}
+
+// endsBlock reports whether a basic block ends after the opcode: block,
+// loop, if, else, end and br_if
+func endsBlock(op byte) bool {
+	switch op {
+	case 0x02, 0x03, 0x04, 0x05, 0x0b, 0x0d:
+		return true
+	}
+	return false
+}
+
+// appendFuelCharge emits code subtracting cost from the fuel global and
+// trapping once it goes negative:
+//
+//	global.get $fuel  i64.const cost  i64.sub  global.set $fuel
+//	global.get $fuel  i64.const 0  i64.lt_s  if  unreachable  end
+func appendFuelCharge(out []byte, fuelGlobal uint32, cost uint64) []byte {
+	out = append(out, 0x23)
+	out = appendULEB128(out, uint64(fuelGlobal))
+	out = append(out, 0x42)
+	out = appendSLEB128(out, int64(cost))
+	out = append(out, 0x7d, 0x24)
+	out = appendULEB128(out, uint64(fuelGlobal))
+	out = append(out, 0x23)
+	out = appendULEB128(out, uint64(fuelGlobal))
+	return append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0b)
+}
+
+// wasmReader decodes the parts of a wasm binary the instrumentation needs.
+// Reads past a failure return zero values and leave err set.
+type wasmReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *wasmReader) done() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *wasmReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, args...)
+	}
+	r.pos = len(r.b)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.fail("unexpected end of input")
+		return 0
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if n < 0 || len(r.b)-r.pos < n {
+		r.fail("unexpected end of input")
+		return nil
+	}
+	r.pos += n
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *wasmReader) u32() uint32 {
+	v, n := readULEB128(r.b[r.pos:])
+	if n == 0 || v > 1<<32-1 {
+		r.fail("malformed LEB128 at offset %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return uint32(v)
+}
+
+// skipLEB skips a signed or unsigned LEB128 value of any width
+func (r *wasmReader) skipLEB() {
+	for i := 0; i < 10; i++ {
+		if r.byte()&0x80 == 0 {
+			return
+		}
+	}
+	r.fail("malformed LEB128 at offset %d", r.pos)
+}
+
+// importDesc skips an import's description, returning its kind
+func (r *wasmReader) importDesc() byte {
+	kind := r.byte()
+	switch kind {
+	case externFunc:
+		r.u32()
+	case externTable:
+		r.byte()
+		r.limits()
+	case externMemory:
+		r.limits()
+	case externGlobal:
+		r.byte()
+		r.byte()
+	case externTag:
+		r.byte()
+		r.u32()
+	default:
+		r.fail("unknown import kind 0x%02x", kind)
+	}
+	return kind
+}
+
+func (r *wasmReader) vecLen() (uint32, error) {
+	n := r.u32()
+	return n, r.err
+}
+
+func (r *wasmReader) limits() {
+	if flags := r.byte(); flags&0x01 != 0 {
+		r.u32()
+		r.u32()
+	} else {
+		r.u32()
+	}
+}
+
+// memarg skips a load or store's alignment and offset
+func (r *wasmReader) memarg() {
+	r.u32()
+	r.u32()
+}
+
+// blockType skips a block type: empty, a value type or a type index
+func (r *wasmReader) blockType() {
+	if r.pos < len(r.b) {
+		switch r.b[r.pos] {
+		case 0x40, 0x7f, 0x7e, 0x7d, 0x7c, 0x7b, 0x70, 0x6f:
+			r.pos++
+			return
+		}
+	}
+	r.skipLEB()
+}
+
+// instruction skips one instruction and returns its opcode. Instructions
+// the runtime cannot execute are rejected, as is any access to the fuel
+// global, which the original code cannot validly name.
+func (r *wasmReader) instruction(fuelGlobal uint32) byte {
+	op := r.byte()
+	switch {
+	case op == 0x02 || op == 0x03 || op == 0x04: // block, loop, if
+		r.blockType()
+	case op == 0x0c || op == 0x0d || op == 0x10: // br, br_if, call
+		r.u32()
+	case op == 0x0e: // br_table
+		for i, n := uint32(0), r.u32(); i <= n && r.err == nil; i++ {
+			r.u32()
+		}
+	case op == 0x11: // call_indirect
+		r.u32()
+		r.u32()
+	case op == 0x1c: // select t*
+		r.bytes(int(r.u32()))
+	case op >= 0x20 && op <= 0x22: // local.get, local.set, local.tee
+		r.u32()
+	case op == 0x23 || op == 0x24: // global.get, global.set
+		if r.u32() >= fuelGlobal {
+			r.fail("global index out of range")
+		}
+	case op == 0x25 || op == 0x26: // table.get, table.set
+		r.u32()
+	case op >= 0x28 && op <= 0x3e: // loads and stores
+		r.memarg()
+	case op == 0x3f || op == 0x40: // memory.size, memory.grow
+		r.u32()
+	case op == 0x41 || op == 0x42: // i32.const, i64.const
+		r.skipLEB()
+	case op == 0x43: // f32.const
+		r.bytes(4)
+	case op == 0x44: // f64.const
+		r.bytes(8)
+	case op == 0xd0: // ref.null
+		r.byte()
+	case op == 0xd2: // ref.func
+		r.u32()
+	case op == 0xfc:
+		r.miscInstruction()
+	case op == 0xfd:
+		r.vectorInstruction()
+	case op == 0xfe:
+		r.atomicInstruction()
+	case op <= 0x01, op == 0x05, op == 0x0b, op == 0x0f, op == 0x1a, op == 0x1b,
+		op >= 0x45 && op <= 0xc4, op == 0xd1:
+		// No immediates
+	default:
+		r.fail("unsupported opcode 0x%02x", op)
+	}
+	return op
+}
+
+// miscInstruction skips the immediates of a 0xfc-prefixed instruction:
+// saturating truncation, bulk memory and table operations
+func (r *wasmReader) miscInstruction() {
+	switch sub := r.u32(); {
+	case sub <= 7:
+	case sub == 8: // memory.init
+		r.u32()
+		r.u32()
+	case sub == 9 || sub == 11 || sub == 13 || (sub >= 15 && sub <= 17):
+		r.u32()
+	case sub == 10 || sub == 12 || sub == 14:
+		r.u32()
+		r.u32()
+	default:
+		r.fail("unsupported opcode 0xfc %d", sub)
+	}
+}
+
+// vectorInstruction skips the immediates of a 0xfd-prefixed SIMD
+// instruction
+func (r *wasmReader) vectorInstruction() {
+	switch sub := r.u32(); {
+	case sub <= 11 || sub == 92 || sub == 93: // loads and stores
+		r.memarg()
+	case sub == 12 || sub == 13: // v128.const, i8x16.shuffle
+		r.bytes(16)
+	case sub >= 21 && sub <= 34: // extract and replace lane
+		r.byte()
+	case sub >= 84 && sub <= 91: // load and store lane
+		r.memarg()
+		r.byte()
+	case sub <= 255:
+	default:
+		r.fail("unsupported opcode 0xfd %d", sub)
+	}
+}
+
+// atomicInstruction skips the immediates of a 0xfe-prefixed atomic
+// instruction
+func (r *wasmReader) atomicInstruction() {
+	if sub := r.u32(); sub == 0x03 { // atomic.fence
+		r.byte()
+		return
+	}
+	r.memarg()
+}
+
+// wasmName encodes a name as a length-prefixed byte vector
+func wasmName(name string) []byte {
+	return append(appendULEB128(nil, uint64(len(name))), name...)
+}
+
+// appendULEB128 appends an unsigned LEB128 value
+func appendULEB128(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// appendSLEB128 appends a signed LEB128 value
+func appendSLEB128(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
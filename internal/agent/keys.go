@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// ErrKeySealed is returned when a stored agent key is sealed but the key
+// store has no master key to open it
+var ErrKeySealed = errors.New("agent key is sealed but no master key is set")
+
+// MasterKeySize is the size of the AES-256 key that seals stored agent keys
+const MasterKeySize = 32
+
+// sealedKeyPrefix marks sealed seeds. Sealed seeds are never SeedSize
+// long, so seeds written before sealing was enabled remain readable.
+const sealedKeyPrefix byte = 0x01
+
+// KeyStore manages per-agent ed25519 signing keys on behalf of the node so
+// private keys never need to be embedded in guest binaries
+type KeyStore struct {
+	store  *kv.Store
+	master cipher.AEAD
+	keys   map[string]ed25519.PrivateKey
+	mu     sync.Mutex
+}
+
+// NewKeyStore creates a key store. Keys are persisted when store is non-nil
+// and kept in memory only otherwise. A non-empty masterKey, which must be
+// MasterKeySize bytes, seals persisted keys with AES-GCM; plaintext keys
+// already stored are sealed when next loaded.
+func NewKeyStore(store *kv.Store, masterKey []byte) (*KeyStore, error) {
+	k := &KeyStore{
+		store: store,
+		keys:  make(map[string]ed25519.PrivateKey),
+	}
+	if len(masterKey) > 0 {
+		if len(masterKey) != MasterKeySize {
+			return nil, fmt.Errorf("agent key master key must be %d bytes", MasterKeySize)
+		}
+		block, err := aes.NewCipher(masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+		if k.master, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+	}
+	return k, nil
+}
+
+// PrivateKey returns the agent's signing key, generating it on first use
+func (k *KeyStore) PrivateKey(agentID string) (ed25519.PrivateKey, error) {
+	return k.lookup(agentID, true)
+}
+
+// PublicKey returns the agent's public key, or nil if it has no key yet
+func (k *KeyStore) PublicKey(agentID string) (ed25519.PublicKey, error) {
+	priv, err := k.lookup(agentID, false)
+	if err != nil || priv == nil {
+		return nil, err
+	}
+	return priv.Public().(ed25519.PublicKey), nil
+}
+
+// lookup loads an agent key from memory or storage, optionally creating it
+func (k *KeyStore) lookup(agentID string, create bool) (ed25519.PrivateKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if priv, exists := k.keys[agentID]; exists {
+		return priv, nil
+	}
+
+	storeKey := []byte("agentkey/" + agentID)
+	if k.store != nil {
+		value, err := k.store.Get(storeKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key for agent %s: %w", agentID, err)
+		}
+		if value != nil {
+			seed, err := k.openSeed(storeKey, value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load key for agent %s: %w", agentID, err)
+			}
+			priv := ed25519.NewKeyFromSeed(seed)
+			// Seal a seed stored before the master key was set
+			if k.master != nil && len(value) == ed25519.SeedSize {
+				if err := k.store.Put(storeKey, k.sealSeed(storeKey, seed)); err != nil {
+					return nil, fmt.Errorf("failed to seal key for agent %s: %w", agentID, err)
+				}
+			}
+			k.keys[agentID] = priv
+			return priv, nil
+		}
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key for agent %s: %w", agentID, err)
+	}
+	if k.store != nil {
+		if err := k.store.Put(storeKey, k.sealSeed(storeKey, priv.Seed())); err != nil {
+			return nil, fmt.Errorf("failed to persist key for agent %s: %w", agentID, err)
+		}
+	}
+	k.keys[agentID] = priv
+	return priv, nil
+}
+
+// sealSeed encrypts a seed bound to its KV key, or returns it unchanged
+// when the store has no master key
+func (k *KeyStore) sealSeed(storeKey, seed []byte) []byte {
+	if k.master == nil {
+		return seed
+	}
+	nonce := make([]byte, k.master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	out := append([]byte{sealedKeyPrefix}, nonce...)
+	return k.master.Seal(out, nonce, seed, storeKey)
+}
+
+// openSeed decrypts a stored seed, passing plaintext seeds through
+func (k *KeyStore) openSeed(storeKey, value []byte) ([]byte, error) {
+	if len(value) == ed25519.SeedSize {
+		return value, nil
+	}
+	if len(value) == 0 || value[0] != sealedKeyPrefix {
+		return nil, fmt.Errorf("corrupt key")
+	}
+	if k.master == nil {
+		return nil, ErrKeySealed
+	}
+	n := k.master.NonceSize()
+	if len(value) < 1+n {
+		return nil, fmt.Errorf("sealed key is truncated")
+	}
+	seed, err := k.master.Open(nil, value[1:1+n], value[1+n:], storeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("corrupt key")
+	}
+	return seed, nil
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// ErrQuotaExceeded is returned when a write would exceed an agent's KV quota
+var ErrQuotaExceeded = errors.New("kv quota exceeded")
+
+// ErrInvalidAgentID is returned for agent IDs that cannot scope a namespace
+var ErrInvalidAgentID = errors.New("invalid agent id")
+
+// DefaultKVQuota defines default limits for an agent's key-value namespace
+var DefaultKVQuota = KVQuota{
+	MaxKeySize:    256,
+	MaxValueSize:  64 << 10, // 64KB
+	MaxKeys:       10000,
+	MaxTotalBytes: 16 << 20, // 16MB
+}
+
+// KVQuota bounds the keys and bytes an agent may store. Zero fields use
+// DefaultKVQuota's.
+type KVQuota struct {
+	MaxKeySize    int
+	MaxValueSize  int
+	MaxKeys       int
+	MaxTotalBytes int64
+}
+
+// withDefaults fills unset limits from DefaultKVQuota
+func (q KVQuota) withDefaults() KVQuota {
+	if q.MaxKeySize <= 0 {
+		q.MaxKeySize = DefaultKVQuota.MaxKeySize
+	}
+	if q.MaxValueSize <= 0 {
+		q.MaxValueSize = DefaultKVQuota.MaxValueSize
+	}
+	if q.MaxKeys <= 0 {
+		q.MaxKeys = DefaultKVQuota.MaxKeys
+	}
+	if q.MaxTotalBytes <= 0 {
+		q.MaxTotalBytes = DefaultKVQuota.MaxTotalBytes
+	}
+	return q
+}
+
+// Namespace scopes key-value access to a single agent's key prefix
+type Namespace struct {
+	store  *kv.Store
+	prefix []byte
+	quota  KVQuota
+
+	mu     sync.Mutex
+	loaded bool
+	keys   int
+	bytes  int64
+}
+
+// NewNamespace creates a namespace over the store for the given agent. The
+// ID is terminated by a NUL byte in the prefix, so IDs containing one are
+// rejected; otherwise one agent's keys could fall in another's range.
+func NewNamespace(store *kv.Store, agentID string, quota KVQuota) (*Namespace, error) {
+	if agentID == "" || strings.IndexByte(agentID, 0) >= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAgentID, agentID)
+	}
+	return &Namespace{
+		store:  store,
+		prefix: []byte("agent/" + agentID + "\x00"),
+		quota:  quota,
+	}, nil
+}
+
+// Get retrieves a value by key, returning nil if it does not exist
+func (n *Namespace) Get(key []byte) ([]byte, error) {
+	return n.store.Get(n.fullKey(key))
+}
+
+// Put stores a key-value pair subject to the namespace quota
+func (n *Namespace) Put(key, value []byte) error {
+	if len(key) == 0 || len(key) > n.quota.MaxKeySize {
+		return fmt.Errorf("%w: key size %d", ErrQuotaExceeded, len(key))
+	}
+	if len(value) > n.quota.MaxValueSize {
+		return fmt.Errorf("%w: value size %d", ErrQuotaExceeded, len(value))
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.loadUsageLocked(); err != nil {
+		return err
+	}
+
+	fullKey := n.fullKey(key)
+	old, err := n.store.Get(fullKey)
+	if err != nil {
+		return err
+	}
+
+	keys := n.keys
+	bytes := n.bytes + int64(len(key)+len(value))
+	if old == nil {
+		keys++
+	} else {
+		bytes -= int64(len(key) + len(old))
+	}
+	if keys > n.quota.MaxKeys || bytes > n.quota.MaxTotalBytes {
+		return fmt.Errorf("%w: %d keys, %d bytes", ErrQuotaExceeded, keys, bytes)
+	}
+
+	if err := n.store.Put(fullKey, value); err != nil {
+		return err
+	}
+	n.keys, n.bytes = keys, bytes
+	return nil
+}
+
+// Delete removes a key from the namespace
+func (n *Namespace) Delete(key []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.loadUsageLocked(); err != nil {
+		return err
+	}
+
+	fullKey := n.fullKey(key)
+	old, err := n.store.Get(fullKey)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+
+	if err := n.store.Delete(fullKey); err != nil {
+		return err
+	}
+	n.keys--
+	n.bytes -= int64(len(key) + len(old))
+	return nil
+}
+
+// Keys returns all keys in the namespace starting with prefix
+func (n *Namespace) Keys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := n.iterate(prefix, func(key, _ []byte) {
+		keys = append(keys, key)
+	})
+	return keys, err
+}
+
+// iterate calls fn for every key-value pair under prefix. Keys are passed
+// with the namespace prefix stripped and are safe to retain.
+func (n *Namespace) iterate(prefix []byte, fn func(key, value []byte)) error {
+	iter, err := n.store.Scan(n.fullKey(prefix))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for valid := iter.First(); valid; valid = iter.Next() {
+		key := append([]byte(nil), iter.Key()[len(n.prefix):]...)
+		fn(key, iter.Value())
+	}
+	return iter.Err()
+}
+
+// loadUsageLocked computes current usage on first access.
+// Callers must hold mu.
+func (n *Namespace) loadUsageLocked() error {
+	if n.loaded {
+		return nil
+	}
+
+	var keys int
+	var bytes int64
+	err := n.iterate(nil, func(key, value []byte) {
+		keys++
+		bytes += int64(len(key) + len(value))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load kv usage: %w", err)
+	}
+
+	n.keys, n.bytes, n.loaded = keys, bytes, true
+	return nil
+}
+
+// fullKey prepends the namespace prefix to a key
+func (n *Namespace) fullKey(key []byte) []byte {
+	full := make([]byte, 0, len(n.prefix)+len(key))
+	full = append(full, n.prefix...)
+	return append(full, key...)
+}
+
+// encodeKeyList encodes keys as a sequence of little-endian u32 length
+// prefixed byte strings, the layout kv_scan writes into guest memory
+func encodeKeyList(keys [][]byte) []byte {
+	size := 0
+	for _, k := range keys {
+		size += 4 + len(k)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, k := range keys {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(k)))
+		buf = append(buf, k...)
+	}
+	return buf
+}
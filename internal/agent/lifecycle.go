@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Lifecycle exports a guest may provide in addition to _start. Each is
+// optional; the host skips hooks the module does not export. A manifest
+// may rename them through its entrypoints.
+//
+// Guests that negotiate envelopes (see ExportEnvelopeVersion) receive a
+// config envelope in on_init and a message envelope as on_message's
+// payload; the sender argument is passed either way.
+//
+// Byte arguments are passed by copying them into guest memory through the
+// alloc export and handing the guest a pointer and length. The buffer
+// belongs to the guest from then on and the host never reads it back. Each
+// hook returns an i32 status: zero for success, anything else is reported
+// to the caller as ErrHandlerFailed without crashing the instance.
+const (
+	// ExportOnInit receives the agent's init data once after start:
+	// on_init(ptr, len i32) -> i32
+	ExportOnInit = "on_init"
+	// ExportOnMessage receives a delivered message:
+	// on_message(from_ptr, from_len, payload_ptr, payload_len i32) -> i32
+	ExportOnMessage = "on_message"
+	// ExportOnTick is called once per matrix step: on_tick(step i64) -> i32
+	ExportOnTick = "on_tick"
+	// ExportOnMatrixTick is called instead of on_tick when the agent is
+	// bound into a matrix and exports it. It receives the agent's matrix
+	// state as JSON and returns its output (ptr<<32 | len), or 0 for none:
+	// on_matrix_tick(step i64, state_ptr, state_len i32) -> i64
+	ExportOnMatrixTick = "on_matrix_tick"
+)
+
+// MaxMatrixOutputSize caps the output on_matrix_tick may return
+const MaxMatrixOutputSize = 1 << 20
+
+// ErrHandlerFailed is returned when a lifecycle hook reports a non-zero status
+var ErrHandlerFailed = errors.New("guest handler failed")
+
+// runInit negotiates the envelope version, then calls on_init with the
+// agent's init data, wrapped in a config envelope when one was agreed.
+// Callers must hold callMu.
+func (a *Agent) runInit(ctx context.Context, module api.Module) error {
+	version, err := a.negotiateEnvelope(ctx, module)
+	if err != nil {
+		return err
+	}
+	a.envelope.Store(version)
+
+	fn := module.ExportedFunction(a.entry.Init)
+	if fn == nil {
+		return nil
+	}
+
+	data := a.initData
+	if version > 0 {
+		data = MarshalEnvelope(Envelope{
+			Version: version,
+			Kind:    EnvelopeConfig,
+			To:      a.ID,
+			Payload: a.initData,
+			Config:  a.initConfig,
+		})
+	}
+	ptr, err := a.writeToGuest(ctx, module, data)
+	if err != nil {
+		return fmt.Errorf("failed to pass init data: %w", err)
+	}
+	results, err := a.invoke(ctx, module, fn, uint64(ptr), uint64(len(data)))
+	return handlerResult(a.entry.Init, results, err)
+}
+
+// HandleMessage passes a message to the guest's on_message hook
+func (a *Agent) HandleMessage(ctx context.Context, msg Message) error {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	fn := a.module.ExportedFunction(a.entry.Message)
+	if fn == nil {
+		return nil
+	}
+
+	// Guests that negotiated envelopes receive the message wrapped in one
+	payload := msg.Payload
+	if version := a.envelope.Load(); version > 0 {
+		payload = MarshalEnvelope(Envelope{
+			Version:   version,
+			Kind:      EnvelopeMessage,
+			From:      msg.From,
+			To:        msg.To,
+			Payload:   msg.Payload,
+			Timestamp: msg.Timestamp,
+		})
+	}
+
+	// Sender and payload share one allocation, sender first, except for
+	// components, which own each list argument separately
+	var fromPtr, payloadPtr uint32
+	var err error
+	if a.component {
+		if fromPtr, err = a.writeToGuest(ctx, a.module, []byte(msg.From)); err == nil {
+			payloadPtr, err = a.writeToGuest(ctx, a.module, payload)
+		}
+	} else {
+		buf := make([]byte, 0, len(msg.From)+len(payload))
+		buf = append(append(buf, msg.From...), payload...)
+		fromPtr, err = a.writeToGuest(ctx, a.module, buf)
+		payloadPtr = fromPtr + uint32(len(msg.From))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pass message: %w", err)
+	}
+
+	results, err := a.invoke(ctx, a.module, fn, uint64(fromPtr), uint64(len(msg.From)), uint64(payloadPtr), uint64(len(payload)))
+	return a.lifecycleResult(a.entry.Message, results, err)
+}
+
+// dispatch delivers queued messages to on_message until the instance ends
+func (a *Agent) dispatch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		default:
+		}
+
+		msg, err := a.mailbox.Next(ctx)
+		if err != nil {
+			return
+		}
+		if err := a.HandleMessage(ctx, msg); err != nil && a.logger != nil {
+			a.logger.AddLog("warn", "agent", "agent failed to handle message", map[string]interface{}{
+				"agent_id": a.ID,
+				"from":     msg.From,
+				"error":    err.Error(),
+			})
+		}
+	}
+}
+
+// Tick calls the guest's on_tick hook for a matrix step
+func (a *Agent) Tick(ctx context.Context, step uint64) error {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	fn := a.module.ExportedFunction(a.entry.Tick)
+	if fn == nil {
+		return nil
+	}
+	results, err := a.invoke(ctx, a.module, fn, step)
+	return a.lifecycleResult(a.entry.Tick, results, err)
+}
+
+// MatrixTick runs one matrix step for an agent bound into a matrix,
+// passing its serialized state to on_matrix_tick and returning the output
+// the guest produced. Guests without on_matrix_tick get a plain on_tick
+// and produce no output.
+func (a *Agent) MatrixTick(ctx context.Context, step uint64, state []byte) ([]byte, error) {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	fn := a.module.ExportedFunction(ExportOnMatrixTick)
+	if fn == nil {
+		if fn = a.module.ExportedFunction(a.entry.Tick); fn == nil {
+			return nil, nil
+		}
+		results, err := a.invoke(ctx, a.module, fn, step)
+		return nil, a.lifecycleResult(a.entry.Tick, results, err)
+	}
+
+	ptr, err := a.writeToGuest(ctx, a.module, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pass matrix state: %w", err)
+	}
+	results, err := a.invoke(ctx, a.module, fn, step, uint64(ptr), uint64(len(state)))
+	if err != nil {
+		return nil, a.lifecycleResult(ExportOnMatrixTick, results, err)
+	}
+	if len(results) == 0 || (results[0] == 0 && !a.component) {
+		return nil, nil
+	}
+	defer a.postReturn(ctx, a.module, ExportOnMatrixTick, results[0])
+
+	region, err := a.resultRegion(a.module, ExportOnMatrixTick, results[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s output: %w", ExportOnMatrixTick, err)
+	}
+	_, length := unpackPtrLen(region)
+	if length == 0 {
+		return nil, nil
+	}
+	if length > MaxMatrixOutputSize {
+		return nil, fmt.Errorf("%s returned %d bytes, limit is %d", ExportOnMatrixTick, length, MaxMatrixOutputSize)
+	}
+	output, err := readFromGuest(a.module, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s output: %w", ExportOnMatrixTick, err)
+	}
+	return append([]byte(nil), output...), nil
+}
+
+// lifecycleResult interprets a hook result, recording traps as crashes.
+// Callers must hold callMu.
+func (a *Agent) lifecycleResult(name string, results []uint64, err error) error {
+	if err != nil && !isCleanExit(err) {
+		a.finish(err)
+	}
+	return handlerResult(name, results, err)
+}
+
+// handlerResult converts a hook's status result into an error
+func handlerResult(name string, results []uint64, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	if len(results) > 0 && int32(results[0]) != 0 {
+		return fmt.Errorf("%w: %s returned status %d", ErrHandlerFailed, name, int32(results[0]))
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMailboxClosed is returned by a mailbox whose agent has stopped
+var ErrMailboxClosed = errors.New("mailbox closed")
+
+// OverflowPolicy decides what happens when a message arrives at a full mailbox
+type OverflowPolicy string
+
+const (
+	// OverflowReject fails the send with ErrInboxFull
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowDropOldest discards the oldest queued message to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowBlock waits up to the block timeout for room, then rejects
+	OverflowBlock OverflowPolicy = "block"
+)
+
+// DefaultBlockTimeout bounds how long a sender waits under OverflowBlock
+const DefaultBlockTimeout = time.Second
+
+// MailboxConfig configures an agent's inbound message queue
+type MailboxConfig struct {
+	Size         int            // Queue depth; 0 uses DefaultInboxSize
+	Overflow     OverflowPolicy // Behavior when full; empty uses OverflowReject
+	BlockTimeout time.Duration  // Wait limit for OverflowBlock; 0 uses DefaultBlockTimeout
+}
+
+// MailboxMetrics receives queue depth and drop counts
+type MailboxMetrics interface {
+	RecordMailboxDepth(agentID string, depth int)
+	RecordMailboxDropped(agentID, reason string)
+}
+
+// Mailbox is a bounded FIFO of messages awaiting delivery to on_message
+type Mailbox struct {
+	agentID  string
+	cfg      MailboxConfig
+	metrics  MailboxMetrics
+	items    []Message
+	dropped  uint64
+	notEmpty chan struct{}
+	notFull  chan struct{}
+	closed   chan struct{} // Closed by Close, waking every waiter
+	mu       sync.Mutex
+}
+
+// NewMailbox creates a new mailbox. metrics may be nil.
+func NewMailbox(agentID string, cfg MailboxConfig, metrics MailboxMetrics) *Mailbox {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultInboxSize
+	}
+	if cfg.Overflow == "" {
+		cfg.Overflow = OverflowReject
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = DefaultBlockTimeout
+	}
+	return &Mailbox{
+		agentID:  agentID,
+		cfg:      cfg,
+		metrics:  metrics,
+		items:    make([]Message, 0, cfg.Size),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Push enqueues a message, applying the overflow policy when full
+func (m *Mailbox) Push(ctx context.Context, msg Message) error {
+	var timer *time.Timer
+	for {
+		m.mu.Lock()
+		if m.isClosed() {
+			m.mu.Unlock()
+			return ErrMailboxClosed
+		}
+		if len(m.items) < m.cfg.Size {
+			m.enqueueLocked(msg)
+			m.mu.Unlock()
+			return nil
+		}
+
+		switch m.cfg.Overflow {
+		case OverflowDropOldest:
+			m.items = m.items[1:]
+			m.dropLocked("drop-oldest")
+			m.enqueueLocked(msg)
+			m.mu.Unlock()
+			return nil
+		case OverflowBlock:
+			m.mu.Unlock()
+		default:
+			m.dropLocked("rejected")
+			m.mu.Unlock()
+			return ErrInboxFull
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(m.cfg.BlockTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-m.notFull:
+		case <-m.closed:
+		case <-timer.C:
+			m.mu.Lock()
+			m.dropLocked("timeout")
+			m.mu.Unlock()
+			return ErrInboxFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Next blocks until a message is available, the mailbox closes, or ctx ends
+func (m *Mailbox) Next(ctx context.Context) (Message, error) {
+	for {
+		m.mu.Lock()
+		if len(m.items) > 0 {
+			msg := m.items[0]
+			m.items = m.items[1:]
+			signal(m.notFull)
+			if len(m.items) > 0 {
+				signal(m.notEmpty)
+			}
+			m.recordDepthLocked()
+			m.mu.Unlock()
+			return msg, nil
+		}
+		if m.isClosed() {
+			m.mu.Unlock()
+			return Message{}, ErrMailboxClosed
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-m.notEmpty:
+		case <-m.closed:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Drain removes and returns every queued message without blocking
+func (m *Mailbox) Drain() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := m.items
+	m.items = make([]Message, 0, m.cfg.Size)
+	if len(msgs) > 0 {
+		signal(m.notFull)
+		m.recordDepthLocked()
+	}
+	return msgs
+}
+
+// Len returns the number of queued messages
+func (m *Mailbox) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+// Dropped returns the number of messages lost to overflow
+func (m *Mailbox) Dropped() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropped
+}
+
+// Close rejects further sends and wakes every waiter
+func (m *Mailbox) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isClosed() {
+		close(m.closed)
+	}
+}
+
+// isClosed reports whether Close has been called
+func (m *Mailbox) isClosed() bool {
+	select {
+	case <-m.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueLocked appends a message and wakes a receiver
+func (m *Mailbox) enqueueLocked(msg Message) {
+	m.items = append(m.items, msg)
+	signal(m.notEmpty)
+	if len(m.items) < m.cfg.Size {
+		signal(m.notFull)
+	}
+	m.recordDepthLocked()
+}
+
+// dropLocked counts a lost message
+func (m *Mailbox) dropLocked(reason string) {
+	m.dropped++
+	if m.metrics != nil {
+		m.metrics.RecordMailboxDropped(m.agentID, reason)
+	}
+}
+
+// recordDepthLocked reports the current queue depth
+func (m *Mailbox) recordDepthLocked() {
+	if m.metrics != nil {
+		m.metrics.RecordMailboxDepth(m.agentID, len(m.items))
+	}
+}
+
+// signal performs a non-blocking send on a wakeup channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
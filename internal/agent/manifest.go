@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestSection is the name of the wasm custom section that embeds an
+// agent manifest in the module itself
+const ManifestSection = "matrix.manifest"
+
+// ErrNoManifest is returned when an artifact carries no manifest
+var ErrNoManifest = errors.New("agent manifest not found")
+
+// Manifest describes an agent artifact: who it is, what it needs, and how
+// it is driven. It is read from agent.yaml or the ManifestSection custom
+// section.
+type Manifest struct {
+	Name         string                 `yaml:"name"`
+	Version      string                 `yaml:"version"`
+	Capabilities []Capability           `yaml:"capabilities"`
+	Resources    ResourceRequests       `yaml:"resources"`
+	ConfigSchema map[string]ConfigField `yaml:"config_schema"`
+	Entrypoints  Entrypoints            `yaml:"entrypoints"`
+}
+
+// ResourceRequests are the limits an agent asks for; zero fields fall back
+// to the node defaults
+type ResourceRequests struct {
+	MemoryPages  uint32        `yaml:"memory_pages"`
+	Fuel         uint64        `yaml:"fuel"`
+	CallTimeout  time.Duration `yaml:"call_timeout"`
+	MailboxSize  int           `yaml:"mailbox_size"`
+	MemoryPolicy MemoryPolicy  `yaml:"memory_policy"`
+}
+
+// ConfigField declares one key of an agent's configuration
+type ConfigField struct {
+	Type        string      `yaml:"type"` // "string", "number", or "bool"
+	Required    bool        `yaml:"required"`
+	Default     interface{} `yaml:"default"`
+	Description string      `yaml:"description"`
+}
+
+// Entrypoints names the guest exports used for each lifecycle hook
+type Entrypoints struct {
+	Init    string `yaml:"init"`
+	Message string `yaml:"message"`
+	Tick    string `yaml:"tick"`
+}
+
+// DefaultEntrypoints are the lifecycle exports used when a manifest names none
+var DefaultEntrypoints = Entrypoints{
+	Init:    ExportOnInit,
+	Message: ExportOnMessage,
+	Tick:    ExportOnTick,
+}
+
+// withDefaults fills unnamed entrypoints with the standard exports
+func (e Entrypoints) withDefaults() Entrypoints {
+	if e.Init == "" {
+		e.Init = DefaultEntrypoints.Init
+	}
+	if e.Message == "" {
+		e.Message = DefaultEntrypoints.Message
+	}
+	if e.Tick == "" {
+		e.Tick = DefaultEntrypoints.Tick
+	}
+	return e
+}
+
+// ParseManifest decodes and validates an agent.yaml document
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// ManifestFromCode extracts and parses the manifest embedded in a wasm
+// module's ManifestSection, returning ErrNoManifest if there is none
+func ManifestFromCode(code []byte) (*Manifest, error) {
+	data, err := customSection(code, ManifestSection)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNoManifest
+	}
+	return ParseManifest(data)
+}
+
+// Validate checks that the manifest is complete and within bounds
+func (m *Manifest) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if m.Version == "" {
+		return fmt.Errorf("version is required")
+	}
+	if _, err := newCapabilitySet(m.Capabilities); err != nil {
+		return err
+	}
+	if m.Resources.MemoryPages > 65536 {
+		return fmt.Errorf("memory_pages exceeds maximum allowed (65536)")
+	}
+	if m.Resources.CallTimeout < 0 || m.Resources.MailboxSize < 0 {
+		return fmt.Errorf("resource requests must not be negative")
+	}
+	if err := m.Resources.MemoryPolicy.validate(); err != nil {
+		return err
+	}
+	for key, field := range m.ConfigSchema {
+		switch field.Type {
+		case "string", "number", "bool":
+		default:
+			return fmt.Errorf("config field %s has unknown type %q", key, field.Type)
+		}
+		if field.Default != nil && !configTypeMatches(field.Type, field.Default) {
+			return fmt.Errorf("config field %s default does not match type %s", key, field.Type)
+		}
+	}
+	return nil
+}
+
+// Limits returns the resource limits requested by the manifest, using
+// defaults for anything it leaves unset
+func (m *Manifest) Limits(defaults ResourceLimits) ResourceLimits {
+	limits := defaults
+	if m.Resources.MemoryPages > 0 {
+		limits.MaxMemoryPages = m.Resources.MemoryPages
+	}
+	if m.Resources.Fuel > 0 {
+		limits.MaxFuel = m.Resources.Fuel
+	}
+	if m.Resources.CallTimeout > 0 {
+		limits.CallTimeout = m.Resources.CallTimeout
+	}
+	if m.Resources.MemoryPolicy != "" {
+		limits.MemoryPolicy = m.Resources.MemoryPolicy
+	}
+	return limits
+}
+
+// ValidateConfig checks a deployment config against the schema and
+// returns it with defaults applied. Keys not in the schema are rejected.
+func (m *Manifest) ValidateConfig(config map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(m.ConfigSchema))
+	for key, value := range config {
+		field, ok := m.ConfigSchema[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown config key %s", key)
+		}
+		if !configTypeMatches(field.Type, value) {
+			return nil, fmt.Errorf("config key %s must be a %s", key, field.Type)
+		}
+		result[key] = value
+	}
+	for key, field := range m.ConfigSchema {
+		if _, ok := result[key]; ok {
+			continue
+		}
+		if field.Default != nil {
+			result[key] = field.Default
+		} else if field.Required {
+			return nil, fmt.Errorf("missing required config key %s", key)
+		}
+	}
+	return result, nil
+}
+
+// Apply copies the manifest's capabilities, mailbox size, and entrypoints
+// into an agent config
+func (m *Manifest) Apply(cfg *Config) {
+	cfg.Capabilities = m.Capabilities
+	if m.Resources.MailboxSize > 0 {
+		cfg.Mailbox.Size = m.Resources.MailboxSize
+	}
+	cfg.Entrypoints = m.Entrypoints
+}
+
+// configTypeMatches reports whether a decoded value has the schema type
+func configTypeMatches(typ string, value interface{}) bool {
+	switch value.(type) {
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "bool"
+	case int, int32, int64, uint, uint32, uint64, float32, float64:
+		return typ == "number"
+	default:
+		return false
+	}
+}
+
+// customSection returns the payload of the named custom section of a wasm
+// binary, or nil if it has none
+func customSection(code []byte, name string) ([]byte, error) {
+	if len(code) < 8 || string(code[:4]) != "\x00asm" {
+		return nil, fmt.Errorf("not a wasm module")
+	}
+	rest := code[8:]
+	for len(rest) > 0 {
+		id := rest[0]
+		size, n := readULEB128(rest[1:])
+		if n == 0 || uint64(len(rest)-1-n) < size {
+			return nil, fmt.Errorf("malformed wasm section")
+		}
+		body := rest[1+n : 1+n+int(size)]
+		rest = rest[1+n+int(size):]
+		if id != 0 {
+			continue
+		}
+
+		nameLen, m := readULEB128(body)
+		if m == 0 || uint64(len(body)-m) < nameLen {
+			return nil, fmt.Errorf("malformed wasm custom section")
+		}
+		if string(body[m:m+int(nameLen)]) == name {
+			return body[m+int(nameLen):], nil
+		}
+	}
+	return nil, nil
+}
+
+// readULEB128 decodes an unsigned LEB128 value, returning the number of
+// bytes consumed or 0 if the input is truncated
+func readULEB128(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
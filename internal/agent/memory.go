@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// MemoryPolicy controls whether and how an agent's linear memory may grow
+type MemoryPolicy string
+
+const (
+	// MemoryGrowToLimit lets memory.grow succeed up to MaxMemoryPages
+	MemoryGrowToLimit MemoryPolicy = "grow_to_limit"
+	// MemoryDeny fails every memory.grow, keeping the module at its
+	// declared initial size
+	MemoryDeny MemoryPolicy = "deny"
+	// MemoryNotify grows like MemoryGrowToLimit and calls the guest's
+	// on_memory_pressure export once usage crosses the pressure threshold
+	MemoryNotify MemoryPolicy = "notify"
+)
+
+// ExportOnMemoryPressure is called under MemoryNotify after the invocation
+// that crossed the pressure threshold: on_memory_pressure(used, limit i32),
+// both in pages. Its result is ignored.
+const ExportOnMemoryPressure = "on_memory_pressure"
+
+// DefaultMemoryPressure is the fraction of MaxMemoryPages at which
+// MemoryNotify warns the guest
+const DefaultMemoryPressure = 0.8
+
+// ErrOutOfMemory matches traps raised after the guest ran out of memory
+var ErrOutOfMemory = errors.New("agent out of memory")
+
+// validate checks that the policy is known; empty means MemoryGrowToLimit
+func (p MemoryPolicy) validate() error {
+	switch p {
+	case "", MemoryGrowToLimit, MemoryDeny, MemoryNotify:
+		return nil
+	default:
+		return fmt.Errorf("unknown memory policy %q", p)
+	}
+}
+
+// memoryGuard applies an agent's memory policy to every instance it
+// creates, including those swapped in by Reload. It is installed as the
+// wazero memory allocator, so it sees each memory.grow the runtime allows.
+type memoryGuard struct {
+	policy        MemoryPolicy
+	pressurePages uint32
+
+	denied   atomic.Bool // A grow was refused during the current invocation
+	pending  atomic.Bool // The pressure threshold was crossed and not yet reported
+	notified atomic.Bool // on_memory_pressure has been scheduled for this instance
+}
+
+// newMemoryGuard creates a guard for the given limits
+func newMemoryGuard(limits ResourceLimits) *memoryGuard {
+	pressure := limits.MemoryPressure
+	if pressure <= 0 || pressure > 1 {
+		pressure = DefaultMemoryPressure
+	}
+	return &memoryGuard{
+		policy:        limits.MemoryPolicy,
+		pressurePages: uint32(float64(limits.MaxMemoryPages) * pressure),
+	}
+}
+
+// withAllocator makes instances created with ctx allocate through the guard
+func (g *memoryGuard) withAllocator(ctx context.Context) context.Context {
+	return experimental.WithMemoryAllocator(ctx, g)
+}
+
+// Allocate implements experimental.MemoryAllocator. Only the declared
+// initial size is allocated; the rest is added as the guest grows.
+func (g *memoryGuard) Allocate(capacity, _ uint64) experimental.LinearMemory {
+	return &guardedMemory{guard: g, capacity: capacity}
+}
+
+// reset rearms the pressure notification for a new instance
+func (g *memoryGuard) reset() {
+	g.pending.Store(false)
+	g.notified.Store(false)
+}
+
+// guardedMemory is a linear memory whose growth is subject to a guard
+type guardedMemory struct {
+	guard    *memoryGuard
+	capacity uint64
+	buf      []byte
+}
+
+// Reallocate implements experimental.LinearMemory. The first call sizes
+// the memory at instantiation and is never refused.
+func (m *guardedMemory) Reallocate(size uint64) []byte {
+	if m.buf == nil {
+		m.buf = make([]byte, size, max(size, m.capacity))
+		return m.buf
+	}
+
+	g := m.guard
+	if g.policy == MemoryDeny {
+		g.denied.Store(true)
+		return nil
+	}
+	if uint64(cap(m.buf)) >= size {
+		m.buf = m.buf[:size]
+	} else {
+		m.buf = append(m.buf[:cap(m.buf)], make([]byte, size-uint64(cap(m.buf)))...)[:size]
+	}
+	if g.policy == MemoryNotify && size/pageSize >= uint64(g.pressurePages) && !g.notified.Swap(true) {
+		g.pending.Store(true)
+	}
+	return m.buf
+}
+
+// Free implements experimental.LinearMemory
+func (m *guardedMemory) Free() {
+	m.buf = nil
+}
+
+// atMemoryLimit reports whether the instance's memory cannot grow further,
+// either because of the agent's limit or the module's declared maximum
+func (a *Agent) atMemoryLimit(module api.Module) bool {
+	mem := linearMemory(module)
+	if mem == nil {
+		return false
+	}
+	limit := a.limits.MaxMemoryPages
+	if declared, ok := mem.Definition().Max(); ok && declared < limit {
+		limit = declared
+	}
+	return mem.Size()/pageSize >= limit
+}
+
+// classifyOOM marks a trap as out of memory when growth was refused during
+// the invocation, or when the guest trapped with memory at its limit, which
+// is how guest allocators typically abort after memory.grow fails
+func (a *Agent) classifyOOM(trap *Trap) {
+	switch trap.Kind {
+	case TrapUnreachable, TrapOutOfBounds, TrapUnknown:
+	default:
+		return
+	}
+	if a.mem.denied.Load() || a.atMemoryLimit(a.module) {
+		trap.Kind = TrapOutOfMemory
+	}
+}
+
+// notifyMemoryPressure calls on_memory_pressure if the last invocation
+// crossed the threshold. Callers must hold callMu.
+func (a *Agent) notifyMemoryPressure(ctx context.Context) {
+	if !a.mem.pending.Swap(false) {
+		return
+	}
+	pages := a.memoryPages.Load()
+	if a.logger != nil {
+		a.logger.AddLog("warn", "agent", "agent memory pressure", map[string]interface{}{
+			"agent_id":  a.ID,
+			"pages":     pages,
+			"max_pages": a.limits.MaxMemoryPages,
+		})
+	}
+	fn := a.module.ExportedFunction(ExportOnMemoryPressure)
+	if fn == nil {
+		return
+	}
+	// A failing callback is reported like any other trap by invoke
+	_, _ = a.invoke(ctx, a.module, fn, uint64(pages), uint64(a.limits.MaxMemoryPages))
+}
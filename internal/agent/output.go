@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultOutputBufferSize is the number of bytes of stdout and stderr kept
+// per agent for inspection
+const DefaultOutputBufferSize = 64 * 1024
+
+// Output holds the most recent stdout and stderr written by an agent
+type Output struct {
+	Stdout      []byte
+	Stderr      []byte
+	StdoutTotal uint64 // Bytes ever written to stdout, including discarded ones
+	StderrTotal uint64
+}
+
+// RingBuffer is an io.Writer that keeps only the last bytes written
+type RingBuffer struct {
+	buf   []byte
+	next  int  // Position of the next write
+	full  bool // Whether the buffer has wrapped
+	total uint64
+	mu    sync.Mutex
+}
+
+// NewRingBuffer creates a ring buffer holding up to size bytes
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{buf: make([]byte, size)}
+}
+
+// Write appends p, overwriting the oldest bytes when full
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total += uint64(len(p))
+	n := len(p)
+	if n >= len(r.buf) {
+		copy(r.buf, p[n-len(r.buf):])
+		r.next = 0
+		r.full = true
+		return n, nil
+	}
+
+	copied := copy(r.buf[r.next:], p)
+	if copied < n {
+		copy(r.buf, p[copied:])
+		r.full = true
+	}
+	r.next = (r.next + n) % len(r.buf)
+	if r.next == 0 && n > 0 {
+		r.full = true
+	}
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered data, oldest first
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.next]...)
+	}
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.next:]...)
+	return append(out, r.buf[:r.next]...)
+}
+
+// Total returns the number of bytes ever written
+func (r *RingBuffer) Total() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
+
+// Output returns the agent's buffered stdout and stderr
+func (a *Agent) Output() Output {
+	return Output{
+		Stdout:      a.stdout.Bytes(),
+		Stderr:      a.stderr.Bytes(),
+		StdoutTotal: a.stdout.Total(),
+		StderrTotal: a.stderr.Total(),
+	}
+}
+
+// teeOutput returns a writer that feeds the ring buffer and, if set, w
+func teeOutput(ring *RingBuffer, w io.Writer) io.Writer {
+	if w == nil {
+		return ring
+	}
+	return io.MultiWriter(ring, w)
+}
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// ErrTopicDenied is returned when an agent's ACL does not cover a topic
+var ErrTopicDenied = errors.New("topic not permitted")
+
+// TopicACL lists the transport topics an agent may publish and subscribe
+// to. Entries are path.Match patterns, so "matrix/*" covers "matrix/world".
+// An empty list denies everything.
+type TopicACL struct {
+	Publish   []string
+	Subscribe []string
+}
+
+// CanPublish reports whether the ACL allows publishing to topic
+func (acl TopicACL) CanPublish(topic string) bool {
+	return matchTopic(acl.Publish, topic)
+}
+
+// CanSubscribe reports whether the ACL allows subscribing to topic
+func (acl TopicACL) CanSubscribe(topic string) bool {
+	return matchTopic(acl.Subscribe, topic)
+}
+
+// matchTopic reports whether any pattern matches the topic
+func matchTopic(patterns []string, topic string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PubSub bridges guests to transport topics
+type PubSub interface {
+	Publish(ctx context.Context, from, topic string, payload []byte) error
+	Subscribe(ctx context.Context, agentID, topic string) error
+	Unsubscribe(agentID, topic string)
+}
+
+// topicSubscription fans one transport subscription out to local agents
+type topicSubscription struct {
+	agents map[string]bool
+	cancel context.CancelFunc
+}
+
+// Publish sends a guest payload to a transport topic
+func (r *Router) Publish(ctx context.Context, from, topic string, payload []byte) error {
+	if r.transport == nil {
+		return fmt.Errorf("no transport available for topic %s", topic)
+	}
+	if err := r.transport.Publish(ctx, topic, payload); err != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe delivers messages on a transport topic to an agent's mailbox.
+// Each delivered message has From set to TopicPrefix plus the topic.
+func (r *Router) Subscribe(ctx context.Context, agentID, topic string) error {
+	if r.transport == nil {
+		return fmt.Errorf("no transport available for topic %s", topic)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sub, exists := r.topics[topic]; exists {
+		sub.agents[agentID] = true
+		return nil
+	}
+
+	// The subscription outlives the guest call that created it
+	subCtx, cancel := context.WithCancel(context.Background())
+	messages, err := r.transport.Subscribe(subCtx, topic)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
+	}
+	r.topics[topic] = &topicSubscription{
+		agents: map[string]bool{agentID: true},
+		cancel: cancel,
+	}
+
+	go func() {
+		for msg := range messages {
+			r.fanOut(subCtx, topic, msg.Payload)
+		}
+	}()
+	return nil
+}
+
+// Unsubscribe stops delivering a topic to an agent, leaving the transport
+// topic once no local agent listens to it
+func (r *Router) Unsubscribe(agentID, topic string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unsubscribeLocked(agentID, topic)
+}
+
+// unsubscribeLocked removes a topic subscriber. Callers must hold mu.
+func (r *Router) unsubscribeLocked(agentID, topic string) {
+	sub, exists := r.topics[topic]
+	if !exists {
+		return
+	}
+	delete(sub.agents, agentID)
+	if len(sub.agents) == 0 {
+		sub.cancel()
+		delete(r.topics, topic)
+	}
+}
+
+// fanOut delivers a topic message to every subscribed local agent
+func (r *Router) fanOut(ctx context.Context, topic string, payload []byte) {
+	r.mu.RLock()
+	var recipients []*Agent
+	if sub, exists := r.topics[topic]; exists {
+		for id := range sub.agents {
+			if a, ok := r.agents[id]; ok {
+				recipients = append(recipients, a)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	msg := Message{
+		From:      TopicPrefix + topic,
+		Payload:   payload,
+		Timestamp: time.Now().UnixNano(),
+	}
+	for _, a := range recipients {
+		msg.To = a.ID
+		// Topic traffic is best effort; full mailboxes apply their overflow policy
+		_ = a.deliver(ctx, msg)
+	}
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Well-known exports for carrying guest state across a reload. Agents that
+// keep all durable state in their KV namespace need not export them.
+const (
+	// ExportStateSave serializes guest state: state_save() -> i64 (ptr<<32 | len)
+	ExportStateSave = "state_save"
+	// ExportStateLoad restores serialized state: state_load(ptr, len i32)
+	ExportStateLoad = "state_load"
+)
+
+// Reload replaces the agent's code without losing state. The current
+// instance's state is captured via state_save, the new module is
+// instantiated and initialized, and the state is handed to its state_load.
+// If any step fails the old instance keeps running unchanged. The
+// signature is checked against the agent's verifier.
+func (a *Agent) Reload(ctx context.Context, code, signature []byte) error {
+	if _, err := a.verifier.Verify(code, signature); err != nil {
+		return fmt.Errorf("failed to verify agent %s: %w", a.ID, err)
+	}
+
+	digest := Digest(code)
+	code, component, err := coreModule(code)
+	if err != nil {
+		return fmt.Errorf("failed to load agent %s: %w", a.ID, err)
+	}
+
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	// Capture state from the running instance
+	var state []byte
+	if save := a.module.ExportedFunction(ExportStateSave); save != nil {
+		results, err := a.invoke(ctx, a.module, save)
+		if err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+		region, err := a.resultRegion(a.module, ExportStateSave, results[0])
+		if err == nil {
+			state, err = readFromGuest(a.module, region)
+		}
+		a.postReturn(ctx, a.module, ExportStateSave, results[0])
+		if err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+	}
+
+	// Compile the new code in the agent's runtime
+	metered := a.limits.MaxFuel > 0
+	var compiled wazero.CompiledModule
+	if a.pool != nil {
+		if _, compiled, err = a.pool.acquire(ctx, a.poolKey, code, metered); err != nil {
+			return err
+		}
+	} else {
+		if compiled, err = compileModule(ctx, a.runtime, code, metered); err != nil {
+			return err
+		}
+	}
+
+	// Release whichever module loses if we bail out, keeping the envelope
+	// version and ABI of the old instance
+	oldEnvelope, oldComponent := a.envelope.Load(), a.component
+	a.component = component
+	discard := func(module api.Module) {
+		a.envelope.Store(oldEnvelope)
+		a.component = oldComponent
+		if module != nil {
+			module.Close(ctx)
+		}
+		if a.pool != nil {
+			a.pool.release(ctx, a.poolKey)
+		} else {
+			compiled.Close(ctx)
+		}
+	}
+
+	a.generation++
+	name := fmt.Sprintf("%s#%d", a.name, a.generation)
+	a.mem.reset()
+	module, err := a.runtime.InstantiateModule(a.mem.withAllocator(ctx), compiled, a.moduleConfig.WithName(name))
+	if err != nil {
+		discard(nil)
+		return fmt.Errorf("failed to instantiate module: %w", err)
+	}
+
+	if err := a.runStartFunctions(ctx, module); err != nil {
+		discard(module)
+		return err
+	}
+
+	// Hand the captured state to the new instance
+	if state != nil {
+		load := module.ExportedFunction(ExportStateLoad)
+		if load == nil {
+			discard(module)
+			return fmt.Errorf("new module does not export %s", ExportStateLoad)
+		}
+		ptr, err := a.writeToGuest(ctx, module, state)
+		if err != nil {
+			discard(module)
+			return fmt.Errorf("failed to restore state: %w", err)
+		}
+		if _, err := a.invoke(ctx, module, load, uint64(ptr), uint64(len(state))); err != nil {
+			discard(module)
+			return fmt.Errorf("failed to restore state: %w", err)
+		}
+	}
+
+	// Swap in the new instance and retire the old one
+	old, oldCompiled := a.module, a.compiled
+	a.module, a.compiled = module, compiled
+	a.digest.Store(&digest)
+	a.recordMemory(module)
+
+	old.Close(ctx)
+	if a.pool != nil {
+		a.pool.release(ctx, a.poolKey)
+	} else {
+		oldCompiled.Close(ctx)
+	}
+
+	if a.logger != nil {
+		a.logger.AddLog("info", "agent", "agent reloaded", map[string]interface{}{
+			"agent_id":    a.ID,
+			"digest":      digest,
+			"state_bytes": len(state),
+		})
+	}
+	return nil
+}
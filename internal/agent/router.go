@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// TopicPrefix marks a send target as a transport pubsub topic rather than an agent ID
+const TopicPrefix = "topic:"
+
+var (
+	// ErrTargetNotFound is returned when no local agent matches a send target
+	ErrTargetNotFound = errors.New("target not found")
+	// ErrInboxFull is returned when the recipient cannot accept more messages
+	ErrInboxFull = errors.New("inbox full")
+)
+
+// Message represents a message delivered between agents
+type Message struct {
+	From      string
+	To        string
+	Payload   []byte
+	Timestamp int64
+}
+
+// Messenger delivers messages sent by guest code
+type Messenger interface {
+	Send(ctx context.Context, from, target string, payload []byte) error
+}
+
+// Router delivers agent messages to local agents or transport topics
+type Router struct {
+	transport *transport.Transport
+	eventBus  *transport.EventBus
+	agents    map[string]*Agent
+	topics    map[string]*topicSubscription
+	mu        sync.RWMutex
+}
+
+// NewRouter creates a new message router. Either argument may be nil, in
+// which case the corresponding delivery path is disabled.
+func NewRouter(t *transport.Transport, eventBus *transport.EventBus) *Router {
+	return &Router{
+		transport: t,
+		eventBus:  eventBus,
+		agents:    make(map[string]*Agent),
+		topics:    make(map[string]*topicSubscription),
+	}
+}
+
+// Register makes an agent reachable as a local send target
+func (r *Router) Register(a *Agent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agents[a.ID] = a
+}
+
+// Unregister removes a local send target and its topic subscriptions
+func (r *Router) Unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.agents, id)
+	for topic := range r.topics {
+		r.unsubscribeLocked(id, topic)
+	}
+}
+
+// Close ends all topic subscriptions
+func (r *Router) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for topic, sub := range r.topics {
+		sub.cancel()
+		delete(r.topics, topic)
+	}
+}
+
+// Send delivers a payload to a local agent or, for targets prefixed with
+// TopicPrefix, publishes it to the corresponding transport topic
+func (r *Router) Send(ctx context.Context, from, target string, payload []byte) error {
+	if topic, ok := strings.CutPrefix(target, TopicPrefix); ok {
+		if r.transport == nil {
+			return fmt.Errorf("no transport available for topic %s", topic)
+		}
+		if err := r.transport.Publish(ctx, topic, payload); err != nil {
+			return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
+		}
+		return nil
+	}
+
+	r.mu.RLock()
+	recipient, exists := r.agents[target]
+	sender := r.agents[from]
+	r.mu.RUnlock()
+
+	if !exists {
+		return ErrTargetNotFound
+	}
+
+	// Stamp with the sender's clock so deterministic agents order messages
+	// by virtual time
+	now := time.Now()
+	if sender != nil {
+		now = sender.clock.Now()
+	}
+	msg := Message{
+		From:      from,
+		To:        target,
+		Payload:   payload,
+		Timestamp: now.UnixNano(),
+	}
+	if err := recipient.deliver(ctx, msg); err != nil {
+		return err
+	}
+
+	if r.eventBus != nil {
+		r.eventBus.Publish(transport.Event{
+			Type:      transport.EventTypeAgent,
+			Source:    from,
+			Timestamp: msg.Timestamp,
+			Data: map[string]interface{}{
+				"event": "message",
+				"to":    target,
+				"size":  len(payload),
+			},
+		})
+	}
+
+	return nil
+}
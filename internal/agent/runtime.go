@@ -0,0 +1,225 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// TrustLevel classifies how much isolation an agent requires
+type TrustLevel string
+
+const (
+	// TrustUntrusted agents always receive a dedicated runtime
+	TrustUntrusted TrustLevel = "untrusted"
+	// TrustTrusted agents may share a pooled runtime
+	TrustTrusted TrustLevel = "trusted"
+	// TrustSystem agents are operated by the node itself
+	TrustSystem TrustLevel = "system"
+)
+
+// newRuntime creates a runtime with the env host module, its typed
+// counterpart for components and WASI instantiated. Only host functions
+// for the given capabilities are exported.
+func newRuntime(ctx context.Context, memoryPages uint32, cache *ModuleCache, caps capabilitySet) (wazero.Runtime, error) {
+	// Closing on context done lets invocation deadlines interrupt running
+	// guest code
+	rConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(memoryPages).
+		WithCloseOnContextDone(true)
+	if cache != nil {
+		rConfig = rConfig.WithCompilationCache(cache.compilation)
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, rConfig)
+
+	// Configure module
+	builder := r.NewHostModuleBuilder("env")
+
+	// Add host functions granted to this runtime
+	for _, hf := range hostFunctions {
+		if !caps.has(hf.capability) {
+			continue
+		}
+		builder.NewFunctionBuilder().
+			WithFunc(hf.fn).
+			Export(hf.name)
+	}
+
+	// Instantiate host module
+	if _, err := builder.Instantiate(ctx); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate host module: %w", err)
+	}
+
+	// Component guests reach the same functions through the typed bindings
+	components := r.NewHostModuleBuilder(HostInterface)
+	for _, hf := range componentHostFunctions {
+		if !caps.has(hf.capability) {
+			continue
+		}
+		components.NewFunctionBuilder().
+			WithFunc(hf.fn).
+			Export(hf.name)
+	}
+	if _, err := components.Instantiate(ctx); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate %s: %w", HostInterface, err)
+	}
+
+	// Instantiate WASI so modules built with standard toolchains link. No
+	// filesystem is mounted and wazero provides no sockets, so the guest only
+	// sees what its module config grants.
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+
+	return r, nil
+}
+
+// compileModule compiles agent code, instrumenting it for fuel metering if
+// requested
+func compileModule(ctx context.Context, r wazero.Runtime, code []byte, metered bool) (wazero.CompiledModule, error) {
+	if metered {
+		var err error
+		if code, err = instrumentFuel(code); err != nil {
+			return nil, fmt.Errorf("failed to instrument module for fuel metering: %w", err)
+		}
+	}
+	compiled, err := r.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile module: %w", err)
+	}
+	return compiled, nil
+}
+
+// PoolConfig represents runtime pool configuration
+type PoolConfig struct {
+	// SharedLevels lists the trust levels whose agents share runtimes
+	SharedLevels []TrustLevel
+	Cache        *ModuleCache
+}
+
+// RuntimePool shares a wazero runtime among agents of the same trust level
+// and memory limit, so lightweight agents don't each pay for a runtime.
+// Pooled runtimes export every host function and enforce capabilities when
+// a function is called, rather than at link time.
+type RuntimePool struct {
+	shared   map[TrustLevel]bool
+	cache    *ModuleCache
+	runtimes map[poolKey]*sharedRuntime
+	mu       sync.Mutex
+}
+
+// poolKey identifies a shared runtime
+type poolKey struct {
+	trust       TrustLevel
+	memoryPages uint32
+}
+
+// sharedRuntime is a runtime and the modules compiled in it
+type sharedRuntime struct {
+	runtime  wazero.Runtime
+	compiled map[compiledKey]wazero.CompiledModule
+	refs     int
+}
+
+// compiledKey identifies a compiled module within a shared runtime
+type compiledKey struct {
+	digest  string
+	metered bool
+}
+
+// NewRuntimePool creates a new runtime pool
+func NewRuntimePool(cfg PoolConfig) *RuntimePool {
+	shared := make(map[TrustLevel]bool, len(cfg.SharedLevels))
+	for _, level := range cfg.SharedLevels {
+		shared[level] = true
+	}
+
+	return &RuntimePool{
+		shared:   shared,
+		cache:    cfg.Cache,
+		runtimes: make(map[poolKey]*sharedRuntime),
+	}
+}
+
+// Shares reports whether agents of the trust level run in shared runtimes
+func (p *RuntimePool) Shares(level TrustLevel) bool {
+	return p.shared[level]
+}
+
+// acquire returns the shared runtime for the key and the code compiled in
+// it, creating either if needed. Each successful acquire must be paired with
+// a release.
+func (p *RuntimePool) acquire(ctx context.Context, key poolKey, code []byte, metered bool) (wazero.Runtime, wazero.CompiledModule, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sr, exists := p.runtimes[key]
+	if !exists {
+		all := make(capabilitySet, len(knownCapabilities))
+		for c := range knownCapabilities {
+			all[c] = true
+		}
+
+		r, err := newRuntime(ctx, key.memoryPages, p.cache, all)
+		if err != nil {
+			return nil, nil, err
+		}
+		sr = &sharedRuntime{
+			runtime:  r,
+			compiled: make(map[compiledKey]wazero.CompiledModule),
+		}
+		p.runtimes[key] = sr
+	}
+
+	ck := compiledKey{digest: Digest(code), metered: metered}
+	compiled, exists := sr.compiled[ck]
+	if !exists {
+		var err error
+		compiled, err = compileModule(ctx, sr.runtime, code, metered)
+		if err != nil {
+			if sr.refs == 0 {
+				p.closeLocked(ctx, key, sr)
+			}
+			return nil, nil, err
+		}
+		sr.compiled[ck] = compiled
+	}
+	if p.cache != nil {
+		p.cache.record(ck.digest)
+	}
+
+	sr.refs++
+	return sr.runtime, compiled, nil
+}
+
+// release drops a reference to a shared runtime, closing it once unused
+func (p *RuntimePool) release(ctx context.Context, key poolKey) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sr, exists := p.runtimes[key]
+	if !exists {
+		return nil
+	}
+	sr.refs--
+	if sr.refs > 0 {
+		return nil
+	}
+	return p.closeLocked(ctx, key, sr)
+}
+
+// closeLocked closes and forgets a shared runtime. Callers must hold mu.
+func (p *RuntimePool) closeLocked(ctx context.Context, key poolKey, sr *sharedRuntime) error {
+	delete(p.runtimes, key)
+	if err := sr.runtime.Close(ctx); err != nil {
+		return fmt.Errorf("failed to close shared runtime: %w", err)
+	}
+	return nil
+}
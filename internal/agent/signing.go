@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnsignedArtifact is returned when code without a signature is rejected
+	ErrUnsignedArtifact = errors.New("agent artifact is not signed")
+	// ErrUntrustedSigner is returned when no trusted signer produced the signature
+	ErrUntrustedSigner = errors.New("agent artifact signature is not from a trusted signer")
+)
+
+// SignArtifact produces a detached ed25519 signature over agent code
+func SignArtifact(key ed25519.PrivateKey, code []byte) []byte {
+	return ed25519.Sign(key, code)
+}
+
+// ArtifactVerifier checks agent code against a list of trusted signers
+// before it is compiled
+type ArtifactVerifier struct {
+	allowUnsigned bool
+	signers       []ed25519.PublicKey
+}
+
+// NewArtifactVerifier creates a new verifier. When allowUnsigned is true,
+// code without a signature is accepted, but a signature that is present
+// must still verify.
+func NewArtifactVerifier(allowUnsigned bool, signers []ed25519.PublicKey) *ArtifactVerifier {
+	return &ArtifactVerifier{
+		allowUnsigned: allowUnsigned,
+		signers:       signers,
+	}
+}
+
+// ParseSigners decodes hex-encoded ed25519 public keys
+func ParseSigners(encoded []string) ([]ed25519.PublicKey, error) {
+	signers := make([]ed25519.PublicKey, 0, len(encoded))
+	for _, s := range encoded {
+		key, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signer key %q: %w", s, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("signer key %q has length %d, want %d", s, len(key), ed25519.PublicKeySize)
+		}
+		signers = append(signers, ed25519.PublicKey(key))
+	}
+	return signers, nil
+}
+
+// Verify checks a detached signature over code and returns the signer
+// that produced it, or nil for accepted unsigned code
+func (v *ArtifactVerifier) Verify(code, signature []byte) (ed25519.PublicKey, error) {
+	if len(signature) == 0 {
+		if v.allowUnsigned {
+			return nil, nil
+		}
+		return nil, ErrUnsignedArtifact
+	}
+
+	for _, signer := range v.signers {
+		if ed25519.Verify(signer, code, signature) {
+			return signer, nil
+		}
+	}
+	return nil, ErrUntrustedSigner
+}
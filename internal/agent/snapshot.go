@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// snapshotMagic identifies agent snapshot blobs
+var snapshotMagic = [4]byte{'M', 'X', 'S', 'N'}
+
+// snapshotVersion is the current snapshot encoding version
+const snapshotVersion uint16 = 1
+
+// pageSize is the size of a WebAssembly memory page
+const pageSize = 65536
+
+// ErrSnapshotMismatch is returned when a snapshot was taken from different code
+var ErrSnapshotMismatch = errors.New("snapshot does not match agent code")
+
+// snapshotGlobal is a captured global value
+type snapshotGlobal struct {
+	Type  api.ValueType
+	Value uint64
+}
+
+// Snapshot captures the agent's linear memory and globals into a blob that
+// Restore can apply to an instance of the same code
+func (a *Agent) Snapshot() ([]byte, error) {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	binary.Write(&buf, binary.LittleEndian, snapshotVersion)
+	writeBlob(&buf, []byte(a.Digest()))
+
+	var mem []byte
+	if m := linearMemory(a.module); m != nil {
+		view, ok := m.Read(0, m.Size())
+		if !ok {
+			return nil, fmt.Errorf("failed to read agent memory")
+		}
+		mem = view
+	}
+	writeBlob(&buf, mem)
+
+	globals := moduleGlobals(a.module)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(globals)))
+	for _, g := range globals {
+		buf.WriteByte(g.Type())
+		binary.Write(&buf, binary.LittleEndian, g.Get())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Restore applies a snapshot taken from an instance of the same code,
+// growing memory as needed and resetting mutable globals
+func (a *Agent) Restore(data []byte) error {
+	a.callMu.Lock()
+	defer a.callMu.Unlock()
+
+	r := bytes.NewReader(data)
+	var magic [4]byte
+	var version uint16
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != snapshotMagic {
+		return fmt.Errorf("invalid snapshot header")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil || version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	digest, err := readBlob(r)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if string(digest) != a.Digest() {
+		return ErrSnapshotMismatch
+	}
+
+	mem, err := readBlob(r)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	captured := make([]snapshotGlobal, count)
+	for i := range captured {
+		t, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+		captured[i].Type = t
+		if err := binary.Read(r, binary.LittleEndian, &captured[i].Value); err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+	}
+
+	// Validate everything before mutating the instance
+	globals := moduleGlobals(a.module)
+	if len(globals) != len(captured) {
+		return fmt.Errorf("%w: global count %d, want %d", ErrSnapshotMismatch, len(captured), len(globals))
+	}
+	for i, g := range globals {
+		if g.Type() != captured[i].Type {
+			return fmt.Errorf("%w: global %d type changed", ErrSnapshotMismatch, i)
+		}
+	}
+
+	if len(mem) > 0 {
+		m := linearMemory(a.module)
+		if m == nil {
+			return fmt.Errorf("%w: agent has no memory", ErrSnapshotMismatch)
+		}
+		if uint32(len(mem)) > m.Size() {
+			delta := (uint32(len(mem)) - m.Size() + pageSize - 1) / pageSize
+			if _, ok := m.Grow(delta); !ok {
+				return fmt.Errorf("failed to grow memory by %d pages", delta)
+			}
+		}
+		if !m.Write(0, mem) {
+			return fmt.Errorf("failed to write agent memory")
+		}
+	}
+
+	for i, g := range globals {
+		if mg, ok := g.(api.MutableGlobal); ok {
+			mg.Set(captured[i].Value)
+		}
+	}
+	return nil
+}
+
+// SaveSnapshot captures a snapshot and persists it in the store
+func (a *Agent) SaveSnapshot(store *kv.Store) error {
+	data, err := a.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := store.Put(snapshotKey(a.ID), data); err != nil {
+		return fmt.Errorf("failed to persist snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot restores the most recent persisted snapshot, reporting
+// whether one existed
+func (a *Agent) LoadSnapshot(store *kv.Store) (bool, error) {
+	data, err := store.Get(snapshotKey(a.ID))
+	if err != nil {
+		return false, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	if data == nil {
+		return false, nil
+	}
+	return true, a.Restore(data)
+}
+
+// snapshotKey returns the store key holding an agent's snapshot
+func snapshotKey(agentID string) []byte {
+	return []byte("agentsnap/" + agentID)
+}
+
+// linearMemory returns the module's memory, or nil when it defines none;
+// wazero hands back a typed nil in that case
+func linearMemory(m api.Module) api.Memory {
+	mem := m.Memory()
+	if mem == nil || reflect.ValueOf(mem).IsNil() {
+		return nil
+	}
+	return mem
+}
+
+// moduleGlobals returns every global of the module, including unexported
+// ones such as the stack pointer
+func moduleGlobals(m api.Module) []api.Global {
+	im, ok := m.(experimental.InternalModule)
+	if !ok {
+		return nil
+	}
+	globals := make([]api.Global, im.NumGlobal())
+	for i := range globals {
+		globals[i] = im.Global(i)
+	}
+	return globals
+}
+
+// writeBlob writes a u32 length-prefixed byte string
+func writeBlob(w *bytes.Buffer, b []byte) {
+	binary.Write(w, binary.LittleEndian, uint32(len(b)))
+	w.Write(b)
+}
+
+// readBlob reads a u32 length-prefixed byte string
+func readBlob(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
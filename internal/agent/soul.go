@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+)
+
+// MaxSoulMemorySize caps the JSON entry a single soul_remember call may pass
+const MaxSoulMemorySize = 64 << 10
+
+// SoulAccess is the policy for an agent bound to a soul. The zero value
+// grants nothing even when CapabilitySoul is held.
+type SoulAccess struct {
+	Recall   bool // soul_recall may read memories
+	Remember bool // soul_remember may append memories
+	Values   bool // soul_get_value may read values
+	Write    bool // soul_set_value may set values
+}
+
+// soulBinding is a soul an agent is bound to and its access policy
+type soulBinding struct {
+	soul   *soul.Soul
+	access SoulAccess
+}
+
+// BindSoul binds the agent to a soul, replacing any earlier binding. Many
+// agents may share a soul, each limited by its own access policy.
+func (a *Agent) BindSoul(s *soul.Soul, access SoulAccess) {
+	a.soul.Store(&soulBinding{soul: s, access: access})
+	a.audit("soul_bound", map[string]interface{}{"soul_id": s.ID, "access": access})
+}
+
+// UnbindSoul removes the agent's soul binding
+func (a *Agent) UnbindSoul() {
+	if old := a.soul.Swap(nil); old != nil {
+		a.audit("soul_unbound", map[string]interface{}{"soul_id": old.soul.ID})
+	}
+}
+
+// Soul returns the soul the agent is bound to and its access policy
+func (a *Agent) Soul() (*soul.Soul, SoulAccess, bool) {
+	b := a.soul.Load()
+	if b == nil {
+		return nil, SoulAccess{}, false
+	}
+	return b.soul, b.access, true
+}
+
+// boundSoul returns the agent's soul binding when it holds CapabilitySoul
+func boundSoul(ctx context.Context) (*Agent, *soulBinding) {
+	a := grantedAgent(ctx, CapabilitySoul)
+	if a == nil {
+		return nil, nil
+	}
+	b := a.soul.Load()
+	if b == nil {
+		return nil, nil
+	}
+	return a, b
+}
+
+// SoulMemory is the JSON form of a soul memory exchanged with guests
+type SoulMemory struct {
+	Timestamp int64    `json:"timestamp"`
+	Content   string   `json:"content"`
+	Type      string   `json:"type,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// soulMemories converts soul entries to their guest form
+func soulMemories(entries []soul.MemoryEntry) []SoulMemory {
+	result := make([]SoulMemory, len(entries))
+	for i, e := range entries {
+		result[i] = SoulMemory{
+			Timestamp: e.Timestamp,
+			Content:   e.Content,
+			Type:      e.Type,
+			Tags:      e.Tags,
+		}
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// AgentMetrics receives per-agent resource usage
+type AgentMetrics interface {
+	MailboxMetrics
+	RecordAgentMemory(agentID string, usage int64)
+	RecordAgentFuel(agentID string, consumed uint64)
+	RecordAgentInvocation(agentID string, duration time.Duration, failed bool)
+}
+
+// Stats is a point-in-time view of an agent's resource usage
+type Stats struct {
+	ID             string
+	Instance       int // Index within an InstancePool; 0 for standalone agents
+	Digest         string
+	MemoryPages    uint32
+	MemoryBytes    int64
+	FuelUsed       uint64
+	Invocations    uint64
+	Failures       uint64
+	ExecTime       time.Duration
+	MailboxDepth   int
+	MailboxDropped uint64
+}
+
+// Stats returns the agent's current resource usage without waiting for
+// in-flight invocations
+func (a *Agent) Stats() Stats {
+	pages := a.memoryPages.Load()
+	return Stats{
+		ID:             a.ID,
+		Instance:       a.instance,
+		Digest:         a.Digest(),
+		MemoryPages:    pages,
+		MemoryBytes:    int64(pages) * pageSize,
+		FuelUsed:       a.fuelUsed.Load(),
+		Invocations:    a.invocations.Load(),
+		Failures:       a.failures.Load(),
+		ExecTime:       time.Duration(a.execNanos.Load()),
+		MailboxDepth:   a.mailbox.Len(),
+		MailboxDropped: a.mailbox.Dropped(),
+	}
+}
+
+// recordInvocation accounts for one guest call. Callers must hold callMu.
+func (a *Agent) recordInvocation(elapsed time.Duration, err error) {
+	a.invocations.Add(1)
+	a.execNanos.Add(int64(elapsed))
+	failed := err != nil && !isCleanExit(err)
+	if failed {
+		a.failures.Add(1)
+	}
+	a.recordMemory(a.module)
+	if a.metrics != nil {
+		a.metrics.RecordAgentInvocation(a.ID, elapsed, failed)
+	}
+}
+
+// recordMemory samples the linear memory size of the live instance
+func (a *Agent) recordMemory(module api.Module) {
+	if module == nil {
+		return
+	}
+	var pages uint32
+	if mem := linearMemory(module); mem != nil {
+		pages = mem.Size() / pageSize
+	}
+	a.memoryPages.Store(pages)
+	if a.metrics != nil {
+		a.metrics.RecordAgentMemory(a.ID, int64(pages)*pageSize)
+	}
+}
+
+// mailboxMetrics narrows AgentMetrics, keeping a nil value nil
+func mailboxMetrics(m AgentMetrics) MailboxMetrics {
+	if m == nil {
+		return nil
+	}
+	return m
+}
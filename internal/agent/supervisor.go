@@ -0,0 +1,377 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// ErrNotSupervised is returned for agent IDs the supervisor does not manage
+var ErrNotSupervised = errors.New("agent not supervised")
+
+// DefaultRestartPolicy restarts crashed agents up to five times with
+// exponential backoff from one second to one minute
+var DefaultRestartPolicy = RestartPolicy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	MaxRestarts:    5,
+	ResetAfter:     10 * time.Minute,
+}
+
+// RestartPolicy controls how crashed agents are restarted
+type RestartPolicy struct {
+	InitialBackoff time.Duration // Delay before the first restart
+	MaxBackoff     time.Duration // Upper bound for the doubling delay
+	MaxRestarts    int           // Consecutive restarts before giving up; negative is unlimited
+	ResetAfter     time.Duration // Uptime after which the restart count resets; 0 never resets
+}
+
+// backoff returns the delay before the given restart attempt
+func (p RestartPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// SupervisedState describes the lifecycle of a supervised agent
+type SupervisedState string
+
+const (
+	// StateRunning means the current instance is live
+	StateRunning SupervisedState = "running"
+	// StateRestarting means the supervisor is waiting to restart a crashed instance
+	StateRestarting SupervisedState = "restarting"
+	// StateFailed means the restart budget was exhausted
+	StateFailed SupervisedState = "failed"
+)
+
+// SupervisedStatus reports the state of a supervised agent
+type SupervisedStatus struct {
+	ID        string
+	State     SupervisedState
+	Restarts  int
+	LastError string
+	StartedAt time.Time
+}
+
+// AgentSupervisor restarts agents that trap or exit, applying a restart
+// policy and publishing crash events
+type AgentSupervisor struct {
+	policy   RestartPolicy
+	router   *Router
+	eventBus *transport.EventBus
+	logger   Logger
+	agents   map[string]*supervisedAgent
+	mu       sync.Mutex
+}
+
+// supervisedAgent tracks one agent and the configuration used to recreate it
+type supervisedAgent struct {
+	cfg    Config
+	limits ResourceLimits
+	stop   chan struct{}
+	exited chan struct{}
+
+	mu        sync.Mutex
+	agent     *Agent
+	state     SupervisedState
+	restarts  int
+	lastErr   error
+	startedAt time.Time
+}
+
+// NewAgentSupervisor creates a new supervisor. The router, event bus, and
+// logger may be nil.
+func NewAgentSupervisor(policy RestartPolicy, router *Router, eventBus *transport.EventBus, logger Logger) *AgentSupervisor {
+	if policy == (RestartPolicy{}) {
+		policy = DefaultRestartPolicy
+	}
+	return &AgentSupervisor{
+		policy:   policy,
+		router:   router,
+		eventBus: eventBus,
+		logger:   logger,
+		agents:   make(map[string]*supervisedAgent),
+	}
+}
+
+// Supervise creates and starts an agent, restarting it from cfg whenever
+// it crashes
+func (s *AgentSupervisor) Supervise(ctx context.Context, cfg Config, limits ResourceLimits) (*Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.agents[cfg.ID]; exists {
+		return nil, fmt.Errorf("agent %s is already supervised", cfg.ID)
+	}
+
+	e := &supervisedAgent{
+		cfg:    cfg,
+		limits: limits,
+		stop:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	a, err := s.start(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	s.agents[cfg.ID] = e
+
+	go s.watch(e, a)
+	return a, nil
+}
+
+// Agent returns the current instance of a supervised agent
+func (s *AgentSupervisor) Agent(id string) (*Agent, bool) {
+	s.mu.Lock()
+	e, exists := s.agents[id]
+	s.mu.Unlock()
+	if !exists {
+		return nil, false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.agent, e.agent != nil
+}
+
+// Agents returns the live instances of all supervised agents
+func (s *AgentSupervisor) Agents() []*Agent {
+	s.mu.Lock()
+	entries := make([]*supervisedAgent, 0, len(s.agents))
+	for _, e := range s.agents {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	agents := make([]*Agent, 0, len(entries))
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.agent != nil {
+			agents = append(agents, e.agent)
+		}
+		e.mu.Unlock()
+	}
+	return agents
+}
+
+// Status returns the supervision state of an agent
+func (s *AgentSupervisor) Status(id string) (SupervisedStatus, bool) {
+	s.mu.Lock()
+	e, exists := s.agents[id]
+	s.mu.Unlock()
+	if !exists {
+		return SupervisedStatus{}, false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := SupervisedStatus{
+		ID:        id,
+		State:     e.state,
+		Restarts:  e.restarts,
+		StartedAt: e.startedAt,
+	}
+	if e.lastErr != nil {
+		status.LastError = e.lastErr.Error()
+	}
+	return status, true
+}
+
+// Stop stops supervising an agent and shuts down its current instance
+func (s *AgentSupervisor) Stop(ctx context.Context, id string) error {
+	s.mu.Lock()
+	e, exists := s.agents[id]
+	delete(s.agents, id)
+	s.mu.Unlock()
+	if !exists {
+		return ErrNotSupervised
+	}
+	return s.shutdown(ctx, e)
+}
+
+// Close stops every supervised agent
+func (s *AgentSupervisor) Close(ctx context.Context) error {
+	s.mu.Lock()
+	agents := s.agents
+	s.agents = make(map[string]*supervisedAgent)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, e := range agents {
+		if err := s.shutdown(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shutdown ends the watch loop and stops the current instance
+func (s *AgentSupervisor) shutdown(ctx context.Context, e *supervisedAgent) error {
+	close(e.stop)
+	<-e.exited
+
+	if s.router != nil {
+		s.router.Unregister(e.cfg.ID)
+	}
+
+	e.mu.Lock()
+	a := e.agent
+	e.agent = nil
+	e.mu.Unlock()
+	if a == nil {
+		return nil
+	}
+	return a.Stop(ctx)
+}
+
+// start creates and starts a new instance from the supervised config
+func (s *AgentSupervisor) start(ctx context.Context, e *supervisedAgent) (*Agent, error) {
+	a, err := New(ctx, e.cfg, e.limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent: %w", err)
+	}
+	if err := a.Start(ctx); err != nil {
+		a.Stop(ctx)
+		return nil, fmt.Errorf("failed to start agent: %w", err)
+	}
+
+	e.mu.Lock()
+	e.agent = a
+	e.state = StateRunning
+	e.startedAt = time.Now()
+	e.mu.Unlock()
+
+	if s.router != nil {
+		s.router.Register(a)
+	}
+	return a, nil
+}
+
+// watch waits for the instance to end and restarts it per the policy
+func (s *AgentSupervisor) watch(e *supervisedAgent, a *Agent) {
+	defer close(e.exited)
+	ctx := context.Background()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-a.Done():
+		}
+
+		crashErr := a.Err()
+		if crashErr == nil {
+			// Stopped outside the supervisor; nothing to restart
+			return
+		}
+		a.Stop(ctx)
+
+		e.mu.Lock()
+		if s.policy.ResetAfter > 0 && time.Since(e.startedAt) >= s.policy.ResetAfter {
+			e.restarts = 0
+		}
+		e.mu.Unlock()
+		s.crashed(e, crashErr)
+
+		for {
+			e.mu.Lock()
+			attempt := e.restarts
+			e.mu.Unlock()
+			if s.policy.MaxRestarts >= 0 && attempt >= s.policy.MaxRestarts {
+				s.failed(e)
+				return
+			}
+
+			select {
+			case <-e.stop:
+				return
+			case <-time.After(s.policy.backoff(attempt)):
+			}
+
+			e.mu.Lock()
+			e.restarts++
+			e.mu.Unlock()
+
+			next, err := s.start(ctx, e)
+			if err == nil {
+				a = next
+				s.publish(e, "restarted", nil)
+				break
+			}
+			s.crashed(e, err)
+		}
+	}
+}
+
+// crashed records a crash and announces it
+func (s *AgentSupervisor) crashed(e *supervisedAgent, err error) {
+	e.mu.Lock()
+	e.agent = nil
+	e.state = StateRestarting
+	e.lastErr = err
+	e.mu.Unlock()
+
+	if s.router != nil {
+		s.router.Unregister(e.cfg.ID)
+	}
+	if s.logger != nil {
+		s.logger.AddLog("error", "agent", "agent crashed", map[string]interface{}{
+			"agent_id": e.cfg.ID,
+			"error":    err.Error(),
+		})
+	}
+	data := map[string]interface{}{"error": err.Error()}
+	var trap *Trap
+	if errors.As(err, &trap) {
+		data["trap"] = trap
+	}
+	s.publish(e, "crashed", data)
+}
+
+// failed marks an agent as having exhausted its restart budget
+func (s *AgentSupervisor) failed(e *supervisedAgent) {
+	e.mu.Lock()
+	e.state = StateFailed
+	e.mu.Unlock()
+
+	if s.logger != nil {
+		s.logger.AddLog("error", "agent", "agent exceeded its restart limit", map[string]interface{}{
+			"agent_id":     e.cfg.ID,
+			"max_restarts": s.policy.MaxRestarts,
+		})
+	}
+	s.publish(e, "failed", nil)
+}
+
+// publish emits an EventTypeAgent lifecycle event
+func (s *AgentSupervisor) publish(e *supervisedAgent, event string, data map[string]interface{}) {
+	if s.eventBus == nil {
+		return
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	e.mu.Lock()
+	data["event"] = event
+	data["state"] = string(e.state)
+	data["restarts"] = e.restarts
+	e.mu.Unlock()
+
+	s.eventBus.Publish(transport.Event{
+		Type:      transport.EventTypeAgent,
+		Source:    e.cfg.ID,
+		Timestamp: time.Now().UnixNano(),
+		Data:      data,
+	})
+}
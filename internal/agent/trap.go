@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// TrapKind classifies why a guest invocation failed
+type TrapKind string
+
+// Trap kinds reported for failed invocations
+const (
+	TrapUnreachable     TrapKind = "unreachable"
+	TrapOutOfBounds     TrapKind = "out_of_bounds"
+	TrapStackOverflow   TrapKind = "stack_overflow"
+	TrapDivideByZero    TrapKind = "divide_by_zero"
+	TrapIntegerOverflow TrapKind = "integer_overflow"
+	TrapInvalidTable    TrapKind = "invalid_table_access"
+	TrapFuelExhausted   TrapKind = "fuel_exhausted"
+	TrapOutOfMemory     TrapKind = "out_of_memory"
+	TrapTimeout         TrapKind = "timeout"
+	TrapExit            TrapKind = "exit"
+	TrapHostFunction    TrapKind = "host_function"
+	TrapUnknown         TrapKind = "unknown"
+)
+
+// trapMessages maps wazero runtime error messages to trap kinds
+var trapMessages = map[string]TrapKind{
+	"unreachable":                 TrapUnreachable,
+	"out of bounds memory access": TrapOutOfBounds,
+	"stack overflow":              TrapStackOverflow,
+	"integer divide by zero":      TrapDivideByZero,
+	"integer overflow":            TrapIntegerOverflow,
+	"invalid table access":        TrapInvalidTable,
+	"indirect call type mismatch": TrapInvalidTable,
+}
+
+// StackFrame is one guest frame of a trap's stack trace
+type StackFrame struct {
+	Function string   // Function signature, e.g. "agent.handle(i32,i32) i32"
+	Sources  []string // Source locations from DWARF, innermost first; empty without debug info
+}
+
+// Trap describes a failed guest invocation
+type Trap struct {
+	Kind     TrapKind
+	Function string // Export that was invoked
+	Message  string // Error message without the stack trace
+	ExitCode uint32 // Set for TrapExit
+	Frames   []StackFrame
+	err      error
+}
+
+// Error returns the message and innermost source location
+func (t *Trap) Error() string {
+	msg := fmt.Sprintf("%s trapped (%s): %s", t.Function, t.Kind, t.Message)
+	if loc := t.Location(); loc != "" {
+		msg += " at " + loc
+	}
+	return msg
+}
+
+// Unwrap returns the underlying runtime error
+func (t *Trap) Unwrap() error {
+	return t.err
+}
+
+// Is reports whether the trap matches target; out of memory traps match
+// ErrOutOfMemory
+func (t *Trap) Is(target error) bool {
+	return target == ErrOutOfMemory && t.Kind == TrapOutOfMemory
+}
+
+// Location returns the innermost source location, or the innermost
+// function if the module has no debug info
+func (t *Trap) Location() string {
+	if len(t.Frames) == 0 {
+		return ""
+	}
+	if len(t.Frames[0].Sources) > 0 {
+		return t.Frames[0].Sources[0]
+	}
+	return t.Frames[0].Function
+}
+
+// StackTrace formats the frames one per line
+func (t *Trap) StackTrace() string {
+	var b strings.Builder
+	for _, f := range t.Frames {
+		b.WriteString(f.Function)
+		b.WriteByte('\n')
+		for _, src := range f.Sources {
+			b.WriteString("\t" + src + "\n")
+		}
+	}
+	return b.String()
+}
+
+// newTrap builds a Trap from an invocation error
+func newTrap(function string, err error) *Trap {
+	t := &Trap{Function: function, Kind: TrapUnknown, err: err}
+
+	msg, stack, _ := strings.Cut(err.Error(), "\nwasm stack trace:\n")
+	t.Frames = parseStackTrace(stack)
+
+	var exitErr *sys.ExitError
+	switch {
+	case errors.Is(err, ErrCallTimeout):
+		t.Kind = TrapTimeout
+	case errors.Is(err, ErrFuelExhausted):
+		t.Kind = TrapFuelExhausted
+	case errors.As(err, &exitErr):
+		t.Kind = TrapExit
+		t.ExitCode = exitErr.ExitCode()
+	case strings.HasPrefix(msg, "wasm error: "):
+		msg = strings.TrimPrefix(msg, "wasm error: ")
+		if kind, ok := trapMessages[msg]; ok {
+			t.Kind = kind
+		}
+	case strings.HasSuffix(msg, " (recovered by wazero)"):
+		msg = strings.TrimSuffix(msg, " (recovered by wazero)")
+		t.Kind = TrapHostFunction
+	}
+	t.Message = msg
+	return t
+}
+
+// parseStackTrace splits wazero's stack trace text into frames. Frame
+// lines are indented once, their source locations twice.
+func parseStackTrace(stack string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range strings.Split(stack, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			if len(frames) > 0 {
+				f := &frames[len(frames)-1]
+				f.Sources = append(f.Sources, strings.TrimSpace(line))
+			}
+		case strings.HasPrefix(line, "\t"):
+			frames = append(frames, StackFrame{Function: strings.TrimSpace(line)})
+		}
+	}
+	return frames
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultUsageWindow is the accounting period of a UsageLedger
+const DefaultUsageWindow = time.Hour
+
+// DefaultUsageRetention is how long a UsageLedger keeps closed windows
+const DefaultUsageRetention = 31 * 24 * time.Hour
+
+// AgentUsage is one agent's resource consumption within a window
+type AgentUsage struct {
+	AgentID     string
+	WindowStart time.Time
+	Fuel        uint64
+	Invocations uint64
+	Failures    uint64
+	ExecTime    time.Duration
+}
+
+// add accumulates other into u
+func (u *AgentUsage) add(other AgentUsage) {
+	u.Fuel += other.Fuel
+	u.Invocations += other.Invocations
+	u.Failures += other.Failures
+	u.ExecTime += other.ExecTime
+}
+
+// UsageReport summarizes consumption over a period for chargeback
+type UsageReport struct {
+	From    time.Time
+	To      time.Time
+	Window  time.Duration
+	Windows []AgentUsage          // Per agent and window, ordered by window then agent
+	Totals  map[string]AgentUsage // Per agent over the whole period
+}
+
+// UsageLedger aggregates agent fuel and invocations into fixed wall-clock
+// windows. Agents report to it through Config.Usage.
+type UsageLedger struct {
+	window    time.Duration
+	retention time.Duration
+	buckets   map[int64]map[string]*AgentUsage // Window start in Unix nanoseconds
+	mu        sync.Mutex
+}
+
+// NewUsageLedger creates a new ledger. Zero durations use
+// DefaultUsageWindow and DefaultUsageRetention.
+func NewUsageLedger(window, retention time.Duration) *UsageLedger {
+	if window <= 0 {
+		window = DefaultUsageWindow
+	}
+	if retention <= 0 {
+		retention = DefaultUsageRetention
+	}
+	return &UsageLedger{
+		window:    window,
+		retention: retention,
+		buckets:   make(map[int64]map[string]*AgentUsage),
+	}
+}
+
+// Record adds one invocation to the window containing at
+func (l *UsageLedger) Record(agentID string, at time.Time, fuel uint64, elapsed time.Duration, failed bool) {
+	start := at.Truncate(l.window).UnixNano()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, exists := l.buckets[start]
+	if !exists {
+		bucket = make(map[string]*AgentUsage)
+		l.buckets[start] = bucket
+		l.pruneLocked(at)
+	}
+	u, exists := bucket[agentID]
+	if !exists {
+		u = &AgentUsage{AgentID: agentID, WindowStart: time.Unix(0, start)}
+		bucket[agentID] = u
+	}
+	u.Fuel += fuel
+	u.Invocations++
+	u.ExecTime += elapsed
+	if failed {
+		u.Failures++
+	}
+}
+
+// Report returns usage for windows starting in [from, to). An empty
+// agentID reports every agent.
+func (l *UsageLedger) Report(from, to time.Time, agentID string) UsageReport {
+	report := UsageReport{
+		From:   from,
+		To:     to,
+		Window: l.window,
+		Totals: make(map[string]AgentUsage),
+	}
+
+	l.mu.Lock()
+	for start, bucket := range l.buckets {
+		ws := time.Unix(0, start)
+		if ws.Before(from.Truncate(l.window)) || !ws.Before(to) {
+			continue
+		}
+		for id, u := range bucket {
+			if agentID != "" && id != agentID {
+				continue
+			}
+			report.Windows = append(report.Windows, *u)
+			total := report.Totals[id]
+			total.AgentID = id
+			total.WindowStart = from
+			total.add(*u)
+			report.Totals[id] = total
+		}
+	}
+	l.mu.Unlock()
+
+	sort.Slice(report.Windows, func(i, j int) bool {
+		a, b := report.Windows[i], report.Windows[j]
+		if !a.WindowStart.Equal(b.WindowStart) {
+			return a.WindowStart.Before(b.WindowStart)
+		}
+		return a.AgentID < b.AgentID
+	})
+	return report
+}
+
+// pruneLocked drops windows older than the retention period. Callers must
+// hold mu.
+func (l *UsageLedger) pruneLocked(now time.Time) {
+	cutoff := now.Add(-l.retention).UnixNano()
+	for start := range l.buckets {
+		if start < cutoff {
+			delete(l.buckets, start)
+		}
+	}
+}
+
+// recordUsage reports an invocation to the agent's ledger, if any
+func (a *Agent) recordUsage(fuel uint64, elapsed time.Duration, err error) {
+	if a.usage == nil {
+		return
+	}
+	a.usage.Record(a.ID, time.Now(), fuel, elapsed, err != nil && !isCleanExit(err))
+}
@@ -0,0 +1,393 @@
+package agent
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// HostWIT is the WIT package describing the typed host API and the worlds
+// component agents target. Guest bindings are generated from it with
+// standard component tooling (e.g. wit-bindgen).
+//
+//go:embed wit/matrix.wit
+var HostWIT string
+
+// HostInterface is the import module name under which component agents
+// reach the typed host API
+const HostInterface = "matrix:agent/host@0.1.0"
+
+// hostInterface is HostWIT's host interface
+var hostInterface = mustParseWITInterface(HostWIT, "host")
+
+// witFunc is a function declared in a WIT interface
+type witFunc struct {
+	name    string
+	params  []witType
+	results []witType
+}
+
+// witType is a WIT value type. Named types are resolved when parsed.
+type witType struct {
+	kind  string     // Primitive name, or list, option, result, tuple or enum
+	elems []*witType // Element types; nil entries are result's omitted cases
+}
+
+// witInterface is a parsed WIT interface
+type witInterface struct {
+	module string // Import module name: namespace:package/interface@version
+	funcs  map[string]witFunc
+}
+
+// Canonical ABI limits on flattened signatures before values are passed
+// through memory instead
+const (
+	maxFlatParams  = 16
+	maxFlatResults = 1
+)
+
+// coreSignature returns the core function type a component imports the
+// function as under the canonical ABI
+func (f witFunc) coreSignature() (params, results []api.ValueType) {
+	for _, t := range f.params {
+		params = append(params, t.flatten()...)
+	}
+	if len(params) > maxFlatParams {
+		params = []api.ValueType{api.ValueTypeI32}
+	}
+	for _, t := range f.results {
+		results = append(results, t.flatten()...)
+	}
+	if len(results) > maxFlatResults {
+		// The caller passes a pointer to a return area instead
+		params = append(params, api.ValueTypeI32)
+		results = nil
+	}
+	return params, results
+}
+
+// flatten returns the core values a type is passed as
+func (t witType) flatten() []api.ValueType {
+	switch t.kind {
+	case "bool", "u8", "s8", "u16", "s16", "u32", "s32", "char", "enum":
+		return []api.ValueType{api.ValueTypeI32}
+	case "u64", "s64":
+		return []api.ValueType{api.ValueTypeI64}
+	case "f32":
+		return []api.ValueType{api.ValueTypeF32}
+	case "f64":
+		return []api.ValueType{api.ValueTypeF64}
+	case "string", "list":
+		return []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}
+	case "tuple":
+		var flat []api.ValueType
+		for _, e := range t.elems {
+			flat = append(flat, e.flatten()...)
+		}
+		return flat
+	case "option", "result":
+		// A discriminant, then the cases' values overlaid slot by slot
+		var payload []api.ValueType
+		for _, e := range t.elems {
+			if e == nil {
+				continue
+			}
+			for i, v := range e.flatten() {
+				if i < len(payload) {
+					payload[i] = joinFlat(payload[i], v)
+				} else {
+					payload = append(payload, v)
+				}
+			}
+		}
+		return append([]api.ValueType{api.ValueTypeI32}, payload...)
+	}
+	return nil
+}
+
+// joinFlat returns the core type able to hold values of both a and b
+func joinFlat(a, b api.ValueType) api.ValueType {
+	switch {
+	case a == b:
+		return a
+	case (a == api.ValueTypeI32 && b == api.ValueTypeF32) || (a == api.ValueTypeF32 && b == api.ValueTypeI32):
+		return api.ValueTypeI32
+	default:
+		return api.ValueTypeI64
+	}
+}
+
+// mustParseWITInterface parses an interface of a WIT package, panicking on
+// malformed input since the package is compiled in
+func mustParseWITInterface(src, name string) *witInterface {
+	iface, err := parseWITInterface(src, name)
+	if err != nil {
+		panic(fmt.Sprintf("invalid host WIT: %v", err))
+	}
+	return iface
+}
+
+// parseWITInterface parses the functions of one interface of a WIT
+// package. Types may be primitives, list, option, result, tuple or enums
+// declared in the interface; worlds and other items are skipped.
+func parseWITInterface(src, name string) (*witInterface, error) {
+	p := &witParser{tokens: tokenizeWIT(src)}
+
+	var pkg, version string
+	for !p.done() && p.err == nil {
+		switch tok := p.next(); tok {
+		case "package":
+			ns := p.next()
+			p.expect(":")
+			pkg = ns + ":" + p.next()
+			if p.peek() == "@" {
+				p.next()
+				version = p.version()
+			}
+			p.expect(";")
+		case "interface":
+			if p.next() != name {
+				p.skipBlock()
+				continue
+			}
+			if pkg == "" {
+				return nil, fmt.Errorf("interface %s declared before package", name)
+			}
+			module := pkg + "/" + name
+			if version != "" {
+				module += "@" + version
+			}
+			iface := &witInterface{module: module, funcs: make(map[string]witFunc)}
+			p.interfaceBody(iface)
+			if p.err != nil {
+				return nil, p.err
+			}
+			return iface, nil
+		default:
+			p.skipItem()
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+// tokenizeWIT splits WIT source into identifiers and punctuation, dropping
+// comments
+func tokenizeWIT(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(src)
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			if end := strings.Index(src[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(src)
+			}
+		case strings.HasPrefix(src[i:], "->"):
+			tokens = append(tokens, "->")
+			i += 2
+		case c == '%' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '-' || src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, strings.TrimPrefix(src[i:j], "%"))
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// witParser walks WIT tokens. Reads past a failure return "" and leave err
+// set.
+type witParser struct {
+	tokens []string
+	pos    int
+	enums  map[string]bool // Enums declared so far
+	err    error
+}
+
+func (p *witParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *witParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+	p.pos = len(p.tokens)
+}
+
+func (p *witParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *witParser) next() string {
+	if p.done() {
+		p.fail("unexpected end of input")
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *witParser) expect(tok string) {
+	if got := p.next(); got != tok && p.err == nil {
+		p.fail("expected %q, found %q", tok, got)
+	}
+}
+
+// version reads a semantic version, which tokenizes as a run of numbers,
+// dots and prerelease identifiers
+func (p *witParser) version() string {
+	var v strings.Builder
+	for !p.done() && p.peek() != ";" && p.peek() != "/" && p.peek() != "{" {
+		v.WriteString(p.next())
+	}
+	return v.String()
+}
+
+// skipItem skips a top-level item: a statement or a braced block
+func (p *witParser) skipItem() {
+	for !p.done() {
+		switch p.next() {
+		case ";":
+			return
+		case "{":
+			p.pos--
+			p.skipBlock()
+			return
+		}
+	}
+}
+
+// skipBlock skips tokens up to and including the end of the next braced
+// block
+func (p *witParser) skipBlock() {
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return
+			}
+		}
+	}
+	p.fail("unterminated block")
+}
+
+// interfaceBody parses an interface's braced body into iface
+func (p *witParser) interfaceBody(iface *witInterface) {
+	p.enums = make(map[string]bool)
+	p.expect("{")
+	for p.err == nil && p.peek() != "}" {
+		switch tok := p.next(); tok {
+		case "enum":
+			name := p.next()
+			p.expect("{")
+			for p.err == nil && p.peek() != "}" {
+				p.next()
+				if p.peek() == "," {
+					p.next()
+				}
+			}
+			p.expect("}")
+			p.enums[name] = true
+		case "use":
+			p.fail("use statements are not supported")
+		default:
+			p.expect(":")
+			p.expect("func")
+			fn := witFunc{name: tok}
+			p.expect("(")
+			for p.err == nil && p.peek() != ")" {
+				p.next() // Parameter name
+				p.expect(":")
+				fn.params = append(fn.params, p.typ())
+				if p.peek() == "," {
+					p.next()
+				}
+			}
+			p.expect(")")
+			if p.peek() == "->" {
+				p.next()
+				fn.results = append(fn.results, p.typ())
+			}
+			p.expect(";")
+			iface.funcs[fn.name] = fn
+		}
+	}
+	p.expect("}")
+}
+
+// typ parses a type
+func (p *witParser) typ() witType {
+	name := p.next()
+	switch name {
+	case "bool", "u8", "s8", "u16", "s16", "u32", "s32", "u64", "s64", "f32", "f64", "char", "string":
+		return witType{kind: name}
+	case "list", "option":
+		p.expect("<")
+		elem := p.typ()
+		p.expect(">")
+		return witType{kind: name, elems: []*witType{&elem}}
+	case "tuple":
+		t := witType{kind: name}
+		p.expect("<")
+		for p.err == nil && p.peek() != ">" {
+			elem := p.typ()
+			t.elems = append(t.elems, &elem)
+			if p.peek() == "," {
+				p.next()
+			}
+		}
+		p.expect(">")
+		return t
+	case "result":
+		t := witType{kind: name, elems: []*witType{nil, nil}}
+		if p.peek() != "<" {
+			return t
+		}
+		p.next()
+		if p.peek() == "_" {
+			p.next()
+		} else {
+			ok := p.typ()
+			t.elems[0] = &ok
+		}
+		if p.peek() == "," {
+			p.next()
+			errType := p.typ()
+			t.elems[1] = &errType
+		}
+		p.expect(">")
+		return t
+	}
+	if p.enums[name] {
+		return witType{kind: "enum"}
+	}
+	p.fail("unsupported type %q", name)
+	return witType{}
+}
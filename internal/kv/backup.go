@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Backup writes a consistent copy of the store to w as a tar archive while
+// the store stays open for reads and writes. The copy is taken from a
+// checkpoint next to the data directory, which is removed afterwards. The
+// engine must be a Checkpointer.
+func (s *Store) Backup(w io.Writer) error {
+	cp, ok := s.engine.(Checkpointer)
+	if !ok {
+		return fmt.Errorf("storage engine does not support backups")
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(filepath.Clean(s.path)), ".backup-")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "db")
+	if err := cp.Checkpoint(dir); err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// Restore unpacks a backup written by Backup into dir, which must not exist
+// or be empty, and checks that it opens. A pebble store can then be opened
+// on dir. On failure dir is removed.
+func Restore(r io.Reader, dir string) (err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read restore directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("restore directory %s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("invalid backup entry %q", hdr.Name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = restoreFile(path, tr)
+		default:
+			return fmt.Errorf("invalid backup entry %q", hdr.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", hdr.Name, err)
+		}
+	}
+
+	db, err := pebble.Open(dir, &pebble.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open restored database: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("failed to close restored database: %w", err)
+	}
+	return nil
+}
+
+// restoreFile writes a file of a backup
+func restoreFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,226 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// bucketsPrefix prefixes the keys of every bucket. A bucket's keys follow
+// its escaped name: 0x00 bytes in the name become 0x00 0xff and the name
+// ends with 0x00 0x01, so no bucket's prefix is a prefix of another's.
+const bucketsPrefix = "\x00b/"
+
+// Bucket is a namespace of keys in the store. Keys in different buckets
+// never collide, and a bucket can be iterated, measured, and wiped on its
+// own. Keys passed to and returned by a bucket are relative to it.
+type Bucket struct {
+	store  *Store
+	name   string
+	prefix []byte
+	opts   []WriteOption // Applied to writes before their own options
+}
+
+// BucketStats counts a bucket's live keys and their size
+type BucketStats struct {
+	Keys  int
+	Bytes int64 // Total size of keys and values
+}
+
+// Bucket returns the bucket with the given name. Buckets need not be
+// created; a bucket exists while it holds keys.
+func (s *Store) Bucket(name string) *Bucket {
+	return &Bucket{store: s, name: name, prefix: bucketPrefix(name)}
+}
+
+// Buckets returns the names of the buckets holding keys, in order
+func (s *Store) Buckets() ([]string, error) {
+	iter, err := s.Scan([]byte(bucketsPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var names []string
+	for valid := iter.First(); valid; {
+		name, ok := bucketName(iter.Key()[len(bucketsPrefix):])
+		if !ok {
+			return nil, fmt.Errorf("malformed bucket key %q", iter.Key())
+		}
+		names = append(names, name)
+		valid = iter.SeekGE(PrefixEnd(bucketPrefix(name)))
+	}
+	return names, iter.Err()
+}
+
+// WithDurability returns the bucket writing with durability d unless a
+// write says otherwise
+func (b *Bucket) WithDurability(d Durability) *Bucket {
+	c := *b
+	c.opts = append(append([]WriteOption(nil), b.opts...), WithDurability(d))
+	return &c
+}
+
+// Name returns the bucket's name
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Key returns the store key of a key in the bucket, for use with
+// transactions and batches
+func (b *Bucket) Key(key []byte) []byte {
+	full := make([]byte, 0, len(b.prefix)+len(key))
+	full = append(full, b.prefix...)
+	return append(full, key...)
+}
+
+// Get retrieves a value by key
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	return b.store.Get(b.Key(key))
+}
+
+// Put stores a key-value pair
+func (b *Bucket) Put(key, value []byte, opts ...WriteOption) error {
+	return b.store.Put(b.Key(key), value, b.options(opts)...)
+}
+
+// PutWithTTL stores a key-value pair that expires after ttl
+func (b *Bucket) PutWithTTL(key, value []byte, ttl time.Duration, opts ...WriteOption) error {
+	return b.store.PutWithTTL(b.Key(key), value, ttl, b.options(opts)...)
+}
+
+// Delete removes a key-value pair
+func (b *Bucket) Delete(key []byte, opts ...WriteOption) error {
+	return b.store.Delete(b.Key(key), b.options(opts)...)
+}
+
+// Merge combines key's value with operand using the store's merge operator
+func (b *Bucket) Merge(key, operand []byte, opts ...WriteOption) ([]byte, error) {
+	return b.store.Merge(b.Key(key), operand, b.options(opts)...)
+}
+
+// Increment adds delta to the counter at key and returns its new value
+func (b *Bucket) Increment(key []byte, delta int64, opts ...WriteOption) (int64, error) {
+	return b.store.Increment(b.Key(key), delta, b.options(opts)...)
+}
+
+// options returns the options of a write to the bucket
+func (b *Bucket) options(opts []WriteOption) []WriteOption {
+	if len(b.opts) == 0 {
+		return opts
+	}
+	return append(append([]WriteOption(nil), b.opts...), opts...)
+}
+
+// Scan returns an iterator over the bucket's keys with prefix. Its keys
+// are relative to the bucket.
+func (b *Bucket) Scan(prefix []byte) (*Iterator, error) {
+	start := b.Key(prefix)
+	return b.iterate(start, PrefixEnd(start))
+}
+
+// Iterate returns an iterator over the bucket's keys from start up to but
+// excluding end; a nil start or end leaves that side open. Its keys are
+// relative to the bucket.
+func (b *Bucket) Iterate(start, end []byte) (*Iterator, error) {
+	upper := PrefixEnd(b.prefix)
+	if end != nil {
+		upper = b.Key(end)
+	}
+	return b.iterate(b.Key(start), upper)
+}
+
+// iterate returns an iterator over the store keys from lower to upper that
+// trims the bucket's prefix
+func (b *Bucket) iterate(lower, upper []byte) (*Iterator, error) {
+	iter, err := b.store.Iterate(lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	iter.prefix = b.prefix
+	return iter, nil
+}
+
+// Stats counts the bucket's keys and their size
+func (b *Bucket) Stats() (BucketStats, error) {
+	iter, err := b.Scan(nil)
+	if err != nil {
+		return BucketStats{}, err
+	}
+	defer iter.Close()
+
+	var stats BucketStats
+	for valid := iter.First(); valid; valid = iter.Next() {
+		stats.Keys++
+		stats.Bytes += int64(len(iter.Key()) + len(iter.Value()))
+	}
+	return stats, iter.Err()
+}
+
+// Wipe deletes every key in the bucket along with their TTLs and index
+// entries. Watchers are not told of the deletions.
+func (b *Bucket) Wipe() error {
+	s := b.store
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	if err := batch.DeleteRange(b.prefix, PrefixEnd(b.prefix)); err != nil {
+		return fmt.Errorf("failed to wipe bucket %s: %w", b.name, err)
+	}
+	// The sweeper skips expiry entries whose deadline is gone
+	expires := expiresKey(b.prefix)
+	if err := batch.DeleteRange(expires, PrefixEnd(expires)); err != nil {
+		return fmt.Errorf("failed to wipe bucket %s: %w", b.name, err)
+	}
+	indexes := indexesKey(b.prefix)
+	if err := batch.DeleteRange(indexes, PrefixEnd(indexes)); err != nil {
+		return fmt.Errorf("failed to wipe bucket %s: %w", b.name, err)
+	}
+	if err := batch.Commit(true); err != nil {
+		return fmt.Errorf("failed to wipe bucket %s: %w", b.name, err)
+	}
+	return nil
+}
+
+// bucketPrefix returns the key prefix of a bucket
+func bucketPrefix(name string) []byte {
+	prefix := make([]byte, 0, len(bucketsPrefix)+len(name)+2)
+	return appendEscaped(append(prefix, bucketsPrefix...), []byte(name))
+}
+
+// appendEscaped appends p to dst escaped like a bucket name, so that no
+// escaped value is a prefix of another
+func appendEscaped(dst, p []byte) []byte {
+	for _, c := range p {
+		dst = append(dst, c)
+		if c == 0x00 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0x00, 0x01)
+}
+
+// bucketName decodes the escaped bucket name at the start of key
+func bucketName(key []byte) (string, bool) {
+	var name []byte
+	for {
+		i := bytes.IndexByte(key, 0x00)
+		if i < 0 || i+1 >= len(key) {
+			return "", false
+		}
+		name = append(name, key[:i]...)
+		switch key[i+1] {
+		case 0x01:
+			return string(name), true
+		case 0xff:
+			name = append(name, 0x00)
+			key = key[i+2:]
+		default:
+			return "", false
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package kv
+
+import "fmt"
+
+// Compact compacts the keys from start up to but excluding end, reclaiming
+// the space of deleted and overwritten values. A nil start or end leaves
+// that side of the range open. It blocks until the compaction is done; the
+// engine must be a Compactor.
+func (s *Store) Compact(start, end []byte) error {
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+	c, ok := s.engine.(Compactor)
+	if !ok {
+		return fmt.Errorf("storage engine does not support compaction")
+	}
+	if start == nil {
+		start = []byte{}
+	}
+	if err := c.Compact(start, end); err != nil {
+		return fmt.Errorf("failed to compact: %w", err)
+	}
+	return nil
+}
+
+// DiskUsage estimates the bytes on disk holding the keys from start up to
+// but excluding end. The engine must be a DiskUsageEstimator.
+func (s *Store) DiskUsage(start, end []byte) (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+	e, ok := s.engine.(DiskUsageEstimator)
+	if !ok {
+		return 0, fmt.Errorf("storage engine does not report disk usage")
+	}
+	usage, err := e.DiskUsage(start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate disk usage: %w", err)
+	}
+	return usage, nil
+}
+
+// BucketDiskUsage estimates the bytes on disk holding each bucket's keys
+func (s *Store) BucketDiskUsage() (map[string]uint64, error) {
+	names, err := s.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]uint64, len(names))
+	for _, name := range names {
+		if usage[name], err = s.Bucket(name).DiskUsage(); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// Compact compacts the bucket's keys
+func (b *Bucket) Compact() error {
+	return b.store.Compact(b.prefix, PrefixEnd(b.prefix))
+}
+
+// DiskUsage estimates the bytes on disk holding the bucket's keys. Keys
+// still in memory or the log are not counted.
+func (b *Bucket) DiskUsage() (uint64, error) {
+	return b.store.DiskUsage(b.prefix, PrefixEnd(b.prefix))
+}
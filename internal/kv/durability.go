@@ -0,0 +1,132 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultGroupCommitLatency is how long a group commit waits for more
+// writes before syncing
+const DefaultGroupCommitLatency = 2 * time.Millisecond
+
+// Durability is when a write is on stable storage relative to the call
+// making it
+type Durability int
+
+const (
+	// DurabilitySync syncs every write before the call returns
+	DurabilitySync Durability = iota
+	// DurabilityAsync returns once a write is logged; the last writes may
+	// be lost if the machine crashes
+	DurabilityAsync
+	// DurabilityGroup returns once a sync shared by the writes of the last
+	// GroupCommitLatency covers the write
+	DurabilityGroup
+)
+
+// String returns the name of the durability
+func (d Durability) String() string {
+	switch d {
+	case DurabilitySync:
+		return "sync"
+	case DurabilityAsync:
+		return "async"
+	case DurabilityGroup:
+		return "group"
+	default:
+		return fmt.Sprintf("Durability(%d)", int(d))
+	}
+}
+
+// ParseDurability parses a durability name; empty is DurabilitySync
+func ParseDurability(name string) (Durability, error) {
+	switch name {
+	case "", "sync":
+		return DurabilitySync, nil
+	case "async":
+		return DurabilityAsync, nil
+	case "group":
+		return DurabilityGroup, nil
+	default:
+		return 0, fmt.Errorf("unknown durability %q", name)
+	}
+}
+
+// WriteOption changes how a single write is made
+type WriteOption func(*writeOptions)
+
+// writeOptions are the settings of a write
+type writeOptions struct {
+	durability Durability
+}
+
+// WithDurability makes a write with durability d instead of the store's
+// default
+func WithDurability(d Durability) WriteOption {
+	return func(o *writeOptions) {
+		o.durability = d
+	}
+}
+
+// durability returns the durability of a write made with opts
+func (s *Store) durability(opts []WriteOption) Durability {
+	o := writeOptions{durability: s.defaultDurability}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.durability
+}
+
+// commit applies batch, syncing it when d is DurabilitySync. Writes with
+// DurabilityGroup are synced by settle.
+func (s *Store) commit(batch EngineBatch, d Durability) error {
+	return batch.Commit(d == DurabilitySync)
+}
+
+// settle waits until writes committed with d are on stable storage, unless
+// *errp reports that they failed. Defer it before locking the written keys
+// so the wait begins once they are unlocked.
+func (s *Store) settle(d Durability, errp *error) {
+	if d != DurabilityGroup || *errp != nil {
+		return
+	}
+	if err := s.group.wait(); err != nil {
+		*errp = fmt.Errorf("failed to sync writes: %w", err)
+	}
+}
+
+// groupCommitter syncs the engine once for the writes waiting within its
+// latency of each other
+type groupCommitter struct {
+	sync    func() error
+	latency time.Duration
+	waiters []chan error
+	mu      sync.Mutex
+}
+
+// wait blocks until a sync started after the call covers the caller's
+// committed writes
+func (g *groupCommitter) wait() error {
+	ch := make(chan error, 1)
+	g.mu.Lock()
+	g.waiters = append(g.waiters, ch)
+	if len(g.waiters) == 1 {
+		time.AfterFunc(g.latency, g.flush)
+	}
+	g.mu.Unlock()
+	return <-ch
+}
+
+// flush syncs the engine and releases the waiters
+func (g *groupCommitter) flush() {
+	g.mu.Lock()
+	waiters := g.waiters
+	g.waiters = nil
+	g.mu.Unlock()
+
+	err := g.sync()
+	for _, ch := range waiters {
+		ch <- err
+	}
+}
@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Storage engines built in
+const (
+	EnginePebble = "pebble"
+	EngineMemory = "memory"
+)
+
+// Engine is the ordered key-value storage under a Store. The store builds
+// expiry, watches, buckets, and transactions on top of it.
+type Engine interface {
+	Reader
+	// NewBatch returns an empty batch of writes applied atomically on
+	// commit
+	NewBatch() EngineBatch
+	// NewSnapshot returns a consistent view of the current state
+	NewSnapshot() Snapshot
+	// Sync puts the writes committed so far on stable storage
+	Sync() error
+	Close() error
+}
+
+// Reader reads the keys of an engine or snapshot
+type Reader interface {
+	// Get returns a copy of the value of key and whether it exists
+	Get(key []byte) ([]byte, bool, error)
+	NewIter(opts IterOptions) (EngineIterator, error)
+}
+
+// Snapshot is a consistent view of an engine. Close it to release it.
+type Snapshot interface {
+	Reader
+	Close() error
+}
+
+// EngineBatch is a set of writes committed atomically
+type EngineBatch interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// DeleteRange deletes the keys from start up to but excluding end
+	DeleteRange(start, end []byte) error
+	Empty() bool
+	// Commit applies the writes, durably when sync is set
+	Commit(sync bool) error
+	Close() error
+}
+
+// EngineIterator walks the keys of a Reader in order
+type EngineIterator interface {
+	First() bool
+	Last() bool
+	Next() bool
+	Prev() bool
+	SeekGE(key []byte) bool
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Close() error
+}
+
+// IterOptions bounds an iteration. SkipPoint, when set, hides the keys
+// it reports.
+type IterOptions struct {
+	LowerBound []byte
+	UpperBound []byte
+	SkipPoint  func(key []byte) bool
+}
+
+// Checkpointer is an Engine that can copy its state to a directory while
+// open, which Backup requires
+type Checkpointer interface {
+	Checkpoint(dir string) error
+}
+
+// Compactor is an Engine that can compact a key range on demand, which
+// Compact requires
+type Compactor interface {
+	// Compact compacts the keys from start up to but excluding end; a nil
+	// end leaves the range open
+	Compact(start, end []byte) error
+}
+
+// DiskUsageEstimator is an Engine that can estimate the disk space of a
+// key range, which DiskUsage requires
+type DiskUsageEstimator interface {
+	// DiskUsage estimates the bytes on disk holding the keys from start up
+	// to but excluding end
+	DiskUsage(start, end []byte) (uint64, error)
+}
+
+// EngineOpener opens an engine for a store configuration
+type EngineOpener func(cfg Config) (Engine, error)
+
+var (
+	engines   = map[string]EngineOpener{}
+	enginesMu sync.RWMutex
+)
+
+func init() {
+	RegisterEngine(EnginePebble, openPebble)
+	RegisterEngine(EngineMemory, func(Config) (Engine, error) {
+		return NewMemoryEngine(), nil
+	})
+}
+
+// RegisterEngine makes an engine available to Config.Engine under name,
+// replacing any engine of that name
+func RegisterEngine(name string, open EngineOpener) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	engines[name] = open
+}
+
+// Engines returns the names of the registered engines, sorted
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openEngine opens the engine named by cfg, pebble by default
+func openEngine(cfg Config) (Engine, error) {
+	name := cfg.Engine
+	if name == "" {
+		name = EnginePebble
+	}
+	enginesMu.RLock()
+	open, ok := engines[name]
+	enginesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage engine %q (have %v)", name, Engines())
+	}
+	return open(cfg)
+}
@@ -0,0 +1,323 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Snapshot streams hold the keys of one prefix range:
+//
+//	magic, version, uvarint len(prefix), prefix
+//	per key: 1, uvarint len(key), key, uvarint len(value), value,
+//	         uvarint deadline (unix nanoseconds, 0 for none), crc32c(record)
+//	0, uvarint count, crc32c(everything before)
+const (
+	snapshotMagic   = "MXKVSNAP"
+	snapshotVersion = 1
+
+	// maxSnapshotField bounds a key or value read from a stream, so a
+	// corrupt length cannot exhaust memory
+	maxSnapshotField = 256 << 20
+)
+
+// ErrBadSnapshot is returned by ImportSnapshot for a stream that is
+// truncated, corrupt, or not a snapshot
+var ErrBadSnapshot = errors.New("invalid snapshot stream")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ExportSnapshot writes the keys with prefix, as of one consistent
+// snapshot, to w as a checksummed stream that ImportSnapshot reads on
+// another node. A key's TTL travels as its absolute deadline; expired keys
+// are left out. It returns the number of keys written.
+func (s *Store) ExportSnapshot(w io.Writer, prefix []byte) (int, error) {
+	if err := checkSnapshotPrefix(prefix); err != nil {
+		return 0, err
+	}
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	snap := s.engine.NewSnapshot()
+	s.release()
+	defer snap.Close()
+
+	iter, err := snap.NewIter(IterOptions{LowerBound: prefix, UpperBound: PrefixEnd(prefix)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.New(castagnoli)}
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion})
+	sw.writeBytes(prefix)
+
+	now := uint64(time.Now().UnixNano())
+	count := 0
+	for valid := iter.First(); valid && sw.err == nil; valid = iter.Next() {
+		key := iter.Key()
+		at, ok, err := deadline(snap, key)
+		if err != nil {
+			return count, err
+		}
+		if ok && at <= now {
+			continue
+		}
+		if !ok {
+			at = 0
+		}
+		sw.write([]byte{1})
+		sw.writeRecord(key, iter.Value(), at)
+		count++
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("failed to iterate: %w", err)
+	}
+	sw.write([]byte{0})
+	sw.writeUvarint(uint64(count))
+	sw.write(binary.BigEndian.AppendUint32(nil, sw.crc.Sum32()))
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err != nil {
+		return count, fmt.Errorf("failed to write snapshot: %w", sw.err)
+	}
+	return count, nil
+}
+
+// ImportSnapshot replaces the keys under the prefix of a stream written by
+// ExportSnapshot with the stream's keys. The stream is read and verified
+// in full before anything is written, and the keys are applied atomically,
+// so a bad stream leaves the store unchanged. Watchers are not notified,
+// and indexes of buckets under the prefix must be rebuilt. It returns the
+// number of keys imported.
+func (s *Store) ImportSnapshot(r io.Reader) (int, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(castagnoli)}
+	header := make([]byte, len(snapshotMagic)+1)
+	if err := sr.read(header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: bad magic", ErrBadSnapshot)
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, header[len(snapshotMagic)])
+	}
+	prefix, err := sr.readBytes()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkSnapshotPrefix(prefix); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+
+	var entries []snapshotEntry
+	for {
+		tag, err := sr.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if tag == 0 {
+			break
+		}
+		if tag != 1 {
+			return 0, fmt.Errorf("%w: unknown record type %d", ErrBadSnapshot, tag)
+		}
+		e, err := sr.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.HasPrefix(e.key, prefix) {
+			return 0, fmt.Errorf("%w: key %q outside prefix %q", ErrBadSnapshot, e.key, prefix)
+		}
+		entries = append(entries, e)
+	}
+	n, err := sr.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n != uint64(len(entries)) {
+		return 0, fmt.Errorf("%w: holds %d keys, trailer says %d", ErrBadSnapshot, len(entries), n)
+	}
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	if err := s.acquireWrite(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	err = batch.DeleteRange(prefix, PrefixEnd(prefix))
+	if err == nil {
+		err = batch.DeleteRange(expiresKey(prefix), PrefixEnd(expiresKey(prefix)))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear prefix: %w", err)
+	}
+	for _, e := range entries {
+		if err := batch.Set(e.key, e.value); err != nil {
+			return 0, fmt.Errorf("failed to set key: %w", err)
+		}
+		if e.deadline == 0 {
+			continue
+		}
+		// Set before the commit so no reader misses the deadline
+		s.hasTTL.Store(true)
+		err := batch.Set(expiresKey(e.key), binary.BigEndian.AppendUint64(nil, e.deadline))
+		if err == nil {
+			err = batch.Set(expiryKey(e.deadline, e.key), nil)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to set key expiry: %w", err)
+		}
+	}
+	if err := batch.Commit(true); err != nil {
+		return 0, fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	return len(entries), nil
+}
+
+// snapshotEntry is a key read from a snapshot stream
+type snapshotEntry struct {
+	key, value []byte
+	deadline   uint64 // Unix nanoseconds; 0 for none
+}
+
+// checkSnapshotPrefix checks that prefix bounds a range of keys apart from
+// the store's bookkeeping
+func checkSnapshotPrefix(prefix []byte) error {
+	if len(prefix) == 0 || PrefixEnd(prefix) == nil || reserved(prefix) || bytes.HasPrefix([]byte(reservedPrefix), prefix) {
+		return fmt.Errorf("invalid snapshot prefix %q", prefix)
+	}
+	return nil
+}
+
+// snapshotWriter writes a snapshot stream, checksumming it and keeping the
+// first error
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (w *snapshotWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc.Write(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *snapshotWriter) writeUvarint(n uint64) {
+	w.write(binary.AppendUvarint(nil, n))
+}
+
+func (w *snapshotWriter) writeBytes(p []byte) {
+	w.writeUvarint(uint64(len(p)))
+	w.write(p)
+}
+
+// writeRecord writes a key's record followed by its checksum
+func (w *snapshotWriter) writeRecord(key, value []byte, deadline uint64) {
+	record := binary.AppendUvarint(nil, uint64(len(key)))
+	record = append(record, key...)
+	record = binary.AppendUvarint(record, uint64(len(value)))
+	record = append(record, value...)
+	record = binary.AppendUvarint(record, deadline)
+	w.write(record)
+	w.write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(record, castagnoli)))
+}
+
+// snapshotReader reads a snapshot stream, checksumming what it reads
+type snapshotReader struct {
+	r      *bufio.Reader
+	crc    hash.Hash32
+	record []byte // Bytes of the record being read
+}
+
+func (r *snapshotReader) read(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	r.crc.Write(p)
+	r.record = append(r.record, p...)
+	return nil
+}
+
+func (r *snapshotReader) readByte() (byte, error) {
+	var b [1]byte
+	err := r.read(b[:])
+	return b[0], err
+}
+
+func (r *snapshotReader) readUvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		if !errors.Is(err, ErrBadSnapshot) {
+			err = fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *snapshotReader) readBytes() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("%w: field of %d bytes", ErrBadSnapshot, n)
+	}
+	p := make([]byte, n)
+	return p, r.read(p)
+}
+
+// readRecord reads a key's record and verifies its checksum
+func (r *snapshotReader) readRecord() (snapshotEntry, error) {
+	var e snapshotEntry
+	var err error
+	r.record = r.record[:0]
+	if e.key, err = r.readBytes(); err != nil {
+		return e, err
+	}
+	if e.value, err = r.readBytes(); err != nil {
+		return e, err
+	}
+	if e.deadline, err = r.readUvarint(); err != nil {
+		return e, err
+	}
+	want := crc32.Checksum(r.record, castagnoli)
+	var sum [4]byte
+	if err := r.read(sum[:]); err != nil {
+		return e, err
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return e, fmt.Errorf("%w: checksum mismatch at key %q", ErrBadSnapshot, e.key)
+	}
+	return e, nil
+}
+
+// byteReader adapts a snapshotReader to io.ByteReader
+type byteReader struct {
+	r *snapshotReader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	return b.r.readByte()
+}
@@ -0,0 +1,222 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// indexPrefix holds the entries of bucket indexes. An index's keys follow
+// the bucket's prefix and the index's escaped name: the index's own key
+// records that it was built, and each entry adds an escaped term and the
+// bucket-relative key indexed under it.
+const indexPrefix = reservedPrefix + "index/"
+
+// IndexFunc returns the terms a bucket key and its value are indexed
+// under. Returning none leaves the key out of the index.
+type IndexFunc func(key, value []byte) [][]byte
+
+// Index finds a bucket's keys by the terms its IndexFunc extracts from
+// them, such as deployments by label. Writes of the bucket through the
+// store, its transactions, and the sweeper update the index in the same
+// atomic commit; writes through a Batch or ImportSnapshot do not, so
+// Rebuild the index after those.
+type Index struct {
+	bucket *Bucket
+	name   string
+	fn     IndexFunc
+	prefix []byte
+}
+
+// indexes are the indexes defined on a store, by bucket name
+type indexes struct {
+	byBucket map[string][]*Index
+	mu       sync.RWMutex
+}
+
+// DefineIndex declares the bucket's index named name, whose terms fn
+// extracts. Define indexes when opening the store, before writing to the
+// bucket. An index not yet in the store is built from the bucket's keys,
+// which a read-only store cannot do; Rebuild it when fn changes.
+func (b *Bucket) DefineIndex(name string, fn IndexFunc) (*Index, error) {
+	ix := &Index{bucket: b, name: name, fn: fn}
+	ix.prefix = appendEscaped(indexesKey(b.prefix), []byte(name))
+
+	s := b.store
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	// Writers of the bucket wait, so the build misses none of them
+	defer s.keyLocks.lockAll()()
+
+	s.indexes.mu.Lock()
+	if s.indexes.byBucket == nil {
+		s.indexes.byBucket = make(map[string][]*Index)
+	}
+	// Writes may be reading the old slice, so build a new one
+	var defined []*Index
+	for _, d := range s.indexes.byBucket[b.name] {
+		if d.name != name {
+			defined = append(defined, d)
+		}
+	}
+	s.indexes.byBucket[b.name] = append(defined, ix)
+	s.indexes.mu.Unlock()
+
+	_, built, err := s.engine.Get(ix.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index %s: %w", name, err)
+	}
+	if !built && s.readOnly {
+		return nil, fmt.Errorf("failed to build index %s: %w", name, ErrReadOnly)
+	}
+	if !built {
+		if err := ix.build(); err != nil {
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+// Name returns the index's name
+func (ix *Index) Name() string {
+	return ix.name
+}
+
+// Lookup returns the bucket keys indexed under term, in order. Keys whose
+// TTL has passed are left out.
+func (ix *Index) Lookup(term []byte) ([][]byte, error) {
+	s := ix.bucket.store
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	snap := s.engine.NewSnapshot()
+	s.release()
+	defer snap.Close()
+
+	start := appendEscaped(append([]byte(nil), ix.prefix...), term)
+	iter, err := snap.NewIter(IterOptions{LowerBound: start, UpperBound: PrefixEnd(start)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var keys [][]byte
+	for valid := iter.First(); valid; valid = iter.Next() {
+		key := append([]byte(nil), iter.Key()[len(start):]...)
+		expired, err := s.expired(snap, ix.bucket.Key(key))
+		if err != nil {
+			return nil, err
+		}
+		if !expired {
+			keys = append(keys, key)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to look up index %s: %w", ix.name, err)
+	}
+	return keys, nil
+}
+
+// Rebuild recomputes the index from the bucket's keys
+func (ix *Index) Rebuild() error {
+	s := ix.bucket.store
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+	defer s.keyLocks.lockAll()()
+	return ix.build()
+}
+
+// build writes the index's entries for every key of the bucket, replacing
+// any it had. closeMu and every key lock must be held.
+func (ix *Index) build() error {
+	s := ix.bucket.store
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	if err := batch.DeleteRange(ix.prefix, PrefixEnd(ix.prefix)); err != nil {
+		return fmt.Errorf("failed to build index %s: %w", ix.name, err)
+	}
+	if err := batch.Set(ix.prefix, nil); err != nil {
+		return fmt.Errorf("failed to build index %s: %w", ix.name, err)
+	}
+
+	prefix := ix.bucket.prefix
+	iter, err := s.engine.NewIter(IterOptions{LowerBound: prefix, UpperBound: PrefixEnd(prefix)})
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+	for valid := iter.First(); valid; valid = iter.Next() {
+		key := iter.Key()[len(prefix):]
+		for _, term := range ix.fn(key, iter.Value()) {
+			if err := batch.Set(ix.entryKey(term, key), nil); err != nil {
+				return fmt.Errorf("failed to build index %s: %w", ix.name, err)
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("failed to build index %s: %w", ix.name, err)
+	}
+	if err := batch.Commit(true); err != nil {
+		return fmt.Errorf("failed to build index %s: %w", ix.name, err)
+	}
+	return nil
+}
+
+// entryKey returns the key of the entry indexing key under term
+func (ix *Index) entryKey(term, key []byte) []byte {
+	entry := make([]byte, 0, len(ix.prefix)+len(term)+len(key)+4)
+	entry = appendEscaped(append(entry, ix.prefix...), term)
+	return append(entry, key...)
+}
+
+// index adds to batch the index changes of setting key to value, or of
+// deleting it when del is set. The key's lock must be held until batch
+// commits, so its current value is the one being replaced.
+func (s *Store) index(batch EngineBatch, key, value []byte, del bool) error {
+	if !bytes.HasPrefix(key, []byte(bucketsPrefix)) {
+		return nil
+	}
+	name, ok := bucketName(key[len(bucketsPrefix):])
+	if !ok {
+		return nil
+	}
+	s.indexes.mu.RLock()
+	defined := s.indexes.byBucket[name]
+	s.indexes.mu.RUnlock()
+	if len(defined) == 0 {
+		return nil
+	}
+
+	old, exists, err := s.engine.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to get key: %w", err)
+	}
+	rel := key[len(defined[0].bucket.prefix):]
+	for _, ix := range defined {
+		if exists {
+			for _, term := range ix.fn(rel, old) {
+				if err := batch.Delete(ix.entryKey(term, rel)); err != nil {
+					return fmt.Errorf("failed to update index %s: %w", ix.name, err)
+				}
+			}
+		}
+		if del {
+			continue
+		}
+		for _, term := range ix.fn(rel, value) {
+			if err := batch.Set(ix.entryKey(term, rel), nil); err != nil {
+				return fmt.Errorf("failed to update index %s: %w", ix.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// indexesKey returns the prefix of the indexes of the bucket with prefix
+func indexesKey(bucketPrefix []byte) []byte {
+	return append([]byte(indexPrefix), bucketPrefix...)
+}
@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Iterator walks a key range of a consistent snapshot in key order. Writes
+// made after the iterator was created are not seen. Key and Value are only
+// valid until the iterator moves; copy them to keep them.
+//
+//	iter, err := store.Scan(prefix)
+//	...
+//	defer iter.Close()
+//	for valid := iter.First(); valid; valid = iter.Next() {
+//		use(iter.Key(), iter.Value())
+//	}
+//	return iter.Err()
+type Iterator struct {
+	snap   Snapshot
+	iter   EngineIterator
+	prefix []byte // Bucket prefix trimmed from keys, if any
+}
+
+// Scan returns an iterator over the keys with prefix. Keys whose TTL has
+// passed are seen until the sweeper deletes them.
+func (s *Store) Scan(prefix []byte) (*Iterator, error) {
+	return s.Iterate(prefix, PrefixEnd(prefix))
+}
+
+// Iterate returns an iterator over the keys from start up to but excluding
+// end. A nil start or end leaves that side of the range open.
+func (s *Store) Iterate(start, end []byte) (*Iterator, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	opts := IterOptions{LowerBound: start, UpperBound: end}
+	if bytes.Compare(start, PrefixEnd([]byte(reservedPrefix))) < 0 && (end == nil || bytes.Compare(end, []byte(reservedPrefix)) > 0) {
+		opts.SkipPoint = reserved
+	}
+	snap := s.engine.NewSnapshot()
+	iter, err := snap.NewIter(opts)
+	if err != nil {
+		snap.Close()
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	return &Iterator{snap: snap, iter: iter}, nil
+}
+
+// First moves to the first key of the range and reports whether there is
+// one
+func (it *Iterator) First() bool {
+	return it.iter.First()
+}
+
+// Last moves to the last key of the range and reports whether there is one
+func (it *Iterator) Last() bool {
+	return it.iter.Last()
+}
+
+// Next moves to the next key and reports whether there is one
+func (it *Iterator) Next() bool {
+	return it.iter.Next()
+}
+
+// Prev moves to the previous key and reports whether there is one
+func (it *Iterator) Prev() bool {
+	return it.iter.Prev()
+}
+
+// SeekGE moves to the first key at or after key and reports whether there
+// is one
+func (it *Iterator) SeekGE(key []byte) bool {
+	if it.prefix != nil {
+		key = append(append([]byte(nil), it.prefix...), key...)
+	}
+	return it.iter.SeekGE(key)
+}
+
+// Valid reports whether the iterator is at a key
+func (it *Iterator) Valid() bool {
+	return it.iter.Valid()
+}
+
+// Key returns the current key
+func (it *Iterator) Key() []byte {
+	return it.iter.Key()[len(it.prefix):]
+}
+
+// Value returns the current value
+func (it *Iterator) Value() []byte {
+	return it.iter.Value()
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	if err := it.iter.Error(); err != nil {
+		return fmt.Errorf("failed to iterate: %w", err)
+	}
+	return nil
+}
+
+// Close releases the iterator and its snapshot
+func (it *Iterator) Close() error {
+	err := it.iter.Close()
+	if serr := it.snap.Close(); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close iterator: %w", err)
+	}
+	return nil
+}
+
+// reserved reports whether key holds the store's own bookkeeping
+func reserved(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(reservedPrefix))
+}
+
+// PrefixEnd returns the smallest key greater than every key with prefix,
+// or nil when there is none
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
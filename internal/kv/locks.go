@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ErrClosed is returned for operations on a closed store
+var ErrClosed = errors.New("store closed")
+
+// ErrReadOnly is returned for writes to a store opened read-only
+var ErrReadOnly = errors.New("store is read-only")
+
+// keyLockStripes is how many mutexes keyLocks spreads keys over
+const keyLockStripes = 256
+
+// keyLocks serialize writes of the same key with the sweeper, which reads
+// a key's deadline before deleting it. Keys share one of a fixed set of
+// mutexes, so writers of different keys rarely wait on each other.
+type keyLocks [keyLockStripes]sync.Mutex
+
+// lock locks the stripes of keys in a fixed order and returns the function
+// unlocking them
+func (l *keyLocks) lock(keys ...[]byte) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, stripe(key))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, i := range stripes {
+		l[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			l[i].Unlock()
+		}
+	}
+}
+
+// lockAll locks every stripe, holding off all writers, and returns the
+// function unlocking them
+func (l *keyLocks) lockAll() func() {
+	for i := range l {
+		l[i].Lock()
+	}
+	return func() {
+		for i := range l {
+			l[i].Unlock()
+		}
+	}
+}
+
+// stripe returns the stripe of key, by FNV-1a hash
+func stripe(key []byte) int {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return int(h % keyLockStripes)
+}
+
+// acquire holds off Close until release, failing once the store is closed
+func (s *Store) acquire() error {
+	s.closeMu.RLock()
+	if s.closed {
+		s.closeMu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// acquireWrite is acquire for operations that write
+func (s *Store) acquireWrite() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	return s.acquire()
+}
+
+// release lets Close proceed once every operation has released
+func (s *Store) release() {
+	s.closeMu.RUnlock()
+}
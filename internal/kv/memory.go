@@ -0,0 +1,391 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// errEngineClosed is returned for operations on a closed memory engine
+var errEngineClosed = errors.New("engine closed")
+
+// MemoryEngine is an Engine keeping keys in memory, for tests and
+// development nodes. Its state is lost on Close. Each key keeps the
+// versions open snapshots may still read.
+type MemoryEngine struct {
+	keys     []string // Sorted keys with any version
+	versions map[string][]memVersion
+	seq      uint64
+	snaps    map[*memSnapshot]struct{}
+	stale    map[string]struct{} // Keys holding versions that may be pruned
+	closed   bool
+	mu       sync.RWMutex
+}
+
+// memVersion is a key's value as of a commit
+type memVersion struct {
+	seq     uint64
+	value   []byte
+	deleted bool
+}
+
+// NewMemoryEngine creates an empty memory engine
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{
+		versions: make(map[string][]memVersion),
+		snaps:    make(map[*memSnapshot]struct{}),
+		stale:    make(map[string]struct{}),
+	}
+}
+
+// Get returns a copy of the current value of key
+func (e *MemoryEngine) Get(key []byte) ([]byte, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return nil, false, errEngineClosed
+	}
+	return e.get(key, e.seq)
+}
+
+// NewIter returns an iterator over the current keys. It reads a snapshot
+// that it releases on Close.
+func (e *MemoryEngine) NewIter(opts IterOptions) (EngineIterator, error) {
+	snap := e.NewSnapshot().(*memSnapshot)
+	iter, err := snap.NewIter(opts)
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	iter.(*memIterator).snap = snap
+	return iter, nil
+}
+
+// NewBatch returns an empty batch
+func (e *MemoryEngine) NewBatch() EngineBatch {
+	return &memBatch{engine: e}
+}
+
+// NewSnapshot returns a view of the current state
+func (e *MemoryEngine) NewSnapshot() Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := &memSnapshot{engine: e, seq: e.seq}
+	e.snaps[s] = struct{}{}
+	return s
+}
+
+// Sync does nothing; memory has no stable storage
+func (e *MemoryEngine) Sync() error {
+	return nil
+}
+
+// Close discards the engine's keys
+func (e *MemoryEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	e.keys = nil
+	e.versions = nil
+	return nil
+}
+
+// get returns a copy of the value of key as of seq. mu must be held.
+func (e *MemoryEngine) get(key []byte, seq uint64) ([]byte, bool, error) {
+	v, ok := visible(e.versions[string(key)], seq)
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte{}, v.value...), true, nil
+}
+
+// visible returns the version of a key seen at seq, if it holds a value
+func visible(versions []memVersion, seq uint64) (memVersion, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].seq <= seq {
+			return versions[i], !versions[i].deleted
+		}
+	}
+	return memVersion{}, false
+}
+
+// apply commits a batch's writes as one new version
+func (e *MemoryEngine) apply(ops []memOp) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errEngineClosed
+	}
+
+	e.seq++
+	for _, op := range ops {
+		switch {
+		case op.end != nil:
+			lo := sort.SearchStrings(e.keys, string(op.key))
+			for _, key := range e.keys[lo:] {
+				if key >= string(op.end) {
+					break
+				}
+				if _, ok := visible(e.versions[key], e.seq); ok {
+					e.write(key, memVersion{seq: e.seq, deleted: true})
+				}
+			}
+		case op.deleted:
+			if _, ok := visible(e.versions[string(op.key)], e.seq); ok {
+				e.write(string(op.key), memVersion{seq: e.seq, deleted: true})
+			}
+		default:
+			e.write(string(op.key), memVersion{seq: e.seq, value: op.value})
+		}
+	}
+	e.prune()
+	return nil
+}
+
+// write adds a version of key, replacing one of the same commit. mu must
+// be held.
+func (e *MemoryEngine) write(key string, v memVersion) {
+	versions, ok := e.versions[key]
+	if !ok {
+		i := sort.SearchStrings(e.keys, key)
+		e.keys = append(e.keys, "")
+		copy(e.keys[i+1:], e.keys[i:])
+		e.keys[i] = key
+	}
+	if n := len(versions); n > 0 && versions[n-1].seq == v.seq {
+		versions[n-1] = v
+	} else {
+		versions = append(versions, v)
+	}
+	e.versions[key] = versions
+	e.stale[key] = struct{}{}
+}
+
+// prune drops the versions of stale keys that no snapshot can read, and
+// keys left with only a deletion. mu must be held.
+func (e *MemoryEngine) prune() {
+	oldest := e.seq
+	for s := range e.snaps {
+		oldest = min(oldest, s.seq)
+	}
+	for key := range e.stale {
+		versions := e.versions[key]
+		// Keep the newest version seen by the oldest reader and all later
+		keep := 0
+		for i, v := range versions {
+			if v.seq <= oldest {
+				keep = i
+			}
+		}
+		versions = versions[keep:]
+		if len(versions) == 1 && versions[0].seq <= oldest {
+			delete(e.stale, key)
+			if versions[0].deleted {
+				delete(e.versions, key)
+				i := sort.SearchStrings(e.keys, key)
+				e.keys = append(e.keys[:i], e.keys[i+1:]...)
+				continue
+			}
+		}
+		e.versions[key] = versions
+	}
+}
+
+// memSnapshot is a Snapshot of a MemoryEngine
+type memSnapshot struct {
+	engine *MemoryEngine
+	seq    uint64
+}
+
+func (s *memSnapshot) Get(key []byte) ([]byte, bool, error) {
+	s.engine.mu.RLock()
+	defer s.engine.mu.RUnlock()
+	if s.engine.closed {
+		return nil, false, errEngineClosed
+	}
+	return s.engine.get(key, s.seq)
+}
+
+func (s *memSnapshot) NewIter(opts IterOptions) (EngineIterator, error) {
+	return &memIterator{reader: s, opts: opts}, nil
+}
+
+func (s *memSnapshot) Close() error {
+	e := s.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.snaps[s]; ok && !e.closed {
+		delete(e.snaps, s)
+		e.prune()
+	}
+	return nil
+}
+
+// memIterator is an EngineIterator over a memSnapshot. It finds its place
+// by key on every move, so keys added meanwhile do not disturb it.
+type memIterator struct {
+	reader *memSnapshot
+	snap   *memSnapshot // Snapshot owned by the iterator, if any
+	opts   IterOptions
+	key    []byte
+	value  []byte
+	valid  bool
+	err    error
+}
+
+func (it *memIterator) First() bool {
+	return it.seek(it.opts.LowerBound, true, true)
+}
+
+func (it *memIterator) Last() bool {
+	return it.seek(it.opts.UpperBound, false, false)
+}
+
+func (it *memIterator) Next() bool {
+	if !it.valid {
+		return false
+	}
+	return it.seek(it.key, true, false)
+}
+
+func (it *memIterator) Prev() bool {
+	if !it.valid {
+		return false
+	}
+	return it.seek(it.key, false, false)
+}
+
+func (it *memIterator) SeekGE(key []byte) bool {
+	if it.opts.LowerBound != nil && bytes.Compare(key, it.opts.LowerBound) < 0 {
+		key = it.opts.LowerBound
+	}
+	return it.seek(key, true, true)
+}
+
+func (it *memIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *memIterator) Key() []byte {
+	return it.key
+}
+
+func (it *memIterator) Value() []byte {
+	return it.value
+}
+
+func (it *memIterator) Error() error {
+	return it.err
+}
+
+func (it *memIterator) Close() error {
+	it.valid = false
+	if it.snap != nil {
+		return it.snap.Close()
+	}
+	return nil
+}
+
+// seek moves to the first visible key after from, or before it when not
+// forward. inclusive also accepts from itself; a nil from starts at the
+// matching end of the keys.
+func (it *memIterator) seek(from []byte, forward, inclusive bool) bool {
+	e := it.reader.engine
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	it.valid = false
+	if e.closed {
+		it.err = errEngineClosed
+		return false
+	}
+
+	var i int
+	switch {
+	case forward && from == nil:
+		i = 0
+	case forward:
+		i = sort.SearchStrings(e.keys, string(from))
+		if !inclusive && i < len(e.keys) && e.keys[i] == string(from) {
+			i++
+		}
+	case from == nil:
+		i = len(e.keys) - 1
+	default:
+		i = sort.SearchStrings(e.keys, string(from)) - 1
+	}
+
+	for ; i >= 0 && i < len(e.keys); i = step(i, forward) {
+		key := []byte(e.keys[i])
+		if it.opts.LowerBound != nil && bytes.Compare(key, it.opts.LowerBound) < 0 {
+			if forward {
+				continue
+			}
+			return false
+		}
+		if it.opts.UpperBound != nil && bytes.Compare(key, it.opts.UpperBound) >= 0 {
+			if forward {
+				return false
+			}
+			continue
+		}
+		v, ok := visible(e.versions[e.keys[i]], it.reader.seq)
+		if !ok || (it.opts.SkipPoint != nil && it.opts.SkipPoint(key)) {
+			continue
+		}
+		it.key, it.value, it.valid = key, v.value, true
+		return true
+	}
+	return false
+}
+
+// step moves an index one key forward or back
+func step(i int, forward bool) int {
+	if forward {
+		return i + 1
+	}
+	return i - 1
+}
+
+// memOp is a write of a memBatch; a non-nil end makes it a range deletion
+type memOp struct {
+	key     []byte
+	value   []byte
+	end     []byte
+	deleted bool
+}
+
+// memBatch is an EngineBatch of a MemoryEngine
+type memBatch struct {
+	engine *MemoryEngine
+	ops    []memOp
+}
+
+func (b *memBatch) Set(key, value []byte) error {
+	b.ops = append(b.ops, memOp{key: append([]byte(nil), key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (b *memBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, memOp{key: append([]byte(nil), key...), deleted: true})
+	return nil
+}
+
+func (b *memBatch) DeleteRange(start, end []byte) error {
+	b.ops = append(b.ops, memOp{key: append([]byte(nil), start...), end: append([]byte{}, end...), deleted: true})
+	return nil
+}
+
+func (b *memBatch) Empty() bool {
+	return len(b.ops) == 0
+}
+
+// Commit applies the batch; there is nothing to sync in memory
+func (b *memBatch) Commit(bool) error {
+	return b.engine.apply(b.ops)
+}
+
+func (b *memBatch) Close() error {
+	b.ops = nil
+	return nil
+}
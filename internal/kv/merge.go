@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotCounter is returned by Increment and Counter for a key whose value
+// is not a counter
+var ErrNotCounter = errors.New("value is not a counter")
+
+// MergeOperator combines a key's current value, nil if it has none, with an
+// operand into the key's new value
+type MergeOperator func(key, value, operand []byte) ([]byte, error)
+
+// AddInt64 is a MergeOperator adding operands to the value as 8-byte
+// big-endian int64 counters; a missing value counts as 0
+func AddInt64(key, value, operand []byte) ([]byte, error) {
+	if len(operand) != 8 {
+		return nil, fmt.Errorf("invalid counter operand of %d bytes", len(operand))
+	}
+	n, err := decodeCounter(value)
+	if err != nil {
+		return nil, err
+	}
+	n += int64(binary.BigEndian.Uint64(operand))
+	return binary.BigEndian.AppendUint64(nil, uint64(n)), nil
+}
+
+// Merge combines key's value with operand using the store's merge
+// operator and returns the new value. Merges and other writes of the key
+// through the store apply one at a time, so callers need no
+// read-modify-write loop. A live TTL of the key is kept; an expired key
+// merges as missing.
+func (s *Store) Merge(key, operand []byte, opts ...WriteOption) ([]byte, error) {
+	if s.merge == nil {
+		return nil, fmt.Errorf("no merge operator configured")
+	}
+	return s.mergeWith(s.merge, key, operand, opts)
+}
+
+// Increment adds delta to the counter at key, which starts at 0, and
+// returns its new value. Like Merge, it keeps a live TTL of the key, so a
+// counter written with PutWithTTL resets when its window expires.
+func (s *Store) Increment(key []byte, delta int64, opts ...WriteOption) (int64, error) {
+	value, err := s.mergeWith(AddInt64, key, binary.BigEndian.AppendUint64(nil, uint64(delta)), opts)
+	if err != nil {
+		return 0, err
+	}
+	return decodeCounter(value)
+}
+
+// Counter returns the value of the counter at key, 0 if it is missing
+func (s *Store) Counter(key []byte) (int64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return decodeCounter(value)
+}
+
+// EncodeCounter returns the value of a counter holding n, for writing a
+// counter with Put or PutWithTTL
+func EncodeCounter(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// mergeWith merges operand into key's value with fn
+func (s *Store) mergeWith(fn MergeOperator, key, operand []byte, opts []WriteOption) (value []byte, err error) {
+	defer s.observe(OpMerge, time.Now(), &err)
+	if err := s.acquireWrite(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	d := s.durability(opts)
+	defer s.settle(d, &err)
+	defer s.keyLocks.lock(key)()
+
+	expired, err := s.expired(s.engine, key)
+	if err != nil {
+		return nil, err
+	}
+	var current []byte
+	if !expired {
+		if current, _, err = s.engine.Get(key); err != nil {
+			return nil, fmt.Errorf("failed to get key: %w", err)
+		}
+	}
+	if value, err = fn(key, current, operand); err != nil {
+		return nil, fmt.Errorf("failed to merge key: %w", err)
+	}
+
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	if err := s.index(batch, key, value, false); err != nil {
+		return nil, err
+	}
+	err = batch.Set(key, value)
+	if err == nil && expired {
+		err = batch.Delete(expiresKey(key))
+	}
+	if err == nil {
+		err = s.commit(batch, d)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge key: %w", err)
+	}
+	s.announce(ChangePut, key, value)
+	return value, nil
+}
+
+// decodeCounter decodes a counter value; nil is 0
+func decodeCounter(value []byte) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, ErrNotCounter
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
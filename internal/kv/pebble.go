@@ -0,0 +1,300 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ErrLocked is returned when opening a data directory another process
+// holds open
+var ErrLocked = errors.New("data directory is locked by another process")
+
+// pebbleEngine is an Engine storing keys on disk with Pebble
+type pebbleEngine struct {
+	db   *pebble.DB
+	lock *pebble.Lock
+	copy string // Copy of a locked directory opened read-only, removed on Close
+}
+
+// openPebble opens the Pebble database at cfg.Path
+func openPebble(cfg Config) (Engine, error) {
+	opts := &pebble.Options{}
+	if cfg.CacheSize > 0 {
+		cache := pebble.NewCache(cfg.CacheSize)
+		defer cache.Unref()
+		opts.Cache = cache
+	}
+	if cfg.TargetFileSize > 0 {
+		// Deeper levels default to doubling the size of the level above
+		opts.Levels = []pebble.LevelOptions{{TargetFileSize: cfg.TargetFileSize}}
+	}
+	opts.ReadOnly = cfg.ReadOnly
+
+	e := &pebbleEngine{}
+	dir := cfg.Path
+	lock, err := lockPebble(dir, cfg.ReadOnly)
+	if errors.Is(err, ErrLocked) && cfg.ReadOnly {
+		// The directory is in use, likely by a running node; read a copy
+		// of it as it is now
+		if e.copy, err = copyPebble(dir); err != nil {
+			return nil, err
+		}
+		dir = e.copy
+		lock, err = lockPebble(dir, true)
+	}
+	if err != nil {
+		e.removeCopy()
+		return nil, err
+	}
+	opts.Lock = lock
+	e.lock = lock
+
+	if e.db, err = pebble.Open(dir, opts); err != nil {
+		lock.Close()
+		e.removeCopy()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return e, nil
+}
+
+// lockPebble locks the Pebble directory dir, creating it unless readOnly.
+// A directory whose lock file exists but cannot be locked is ErrLocked.
+func lockPebble(dir string, readOnly bool) (*pebble.Lock, error) {
+	if !readOnly {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+	lock, err := pebble.LockDirectory(dir, vfs.Default)
+	if err == nil {
+		return lock, nil
+	}
+	if _, serr := os.Stat(filepath.Join(dir, "LOCK")); serr == nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrLocked, dir, err)
+	}
+	return nil, fmt.Errorf("failed to open database: %w", err)
+}
+
+// copyPebble copies the files of the Pebble directory dir to a temporary
+// directory and returns it. Files deleted while copying are skipped; if the
+// database changed underneath, opening the copy fails.
+func copyPebble(dir string) (tmp string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read database directory: %w", err)
+	}
+	tmp, err = os.MkdirTemp("", "kv-readonly-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create database copy: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == "LOCK" {
+			continue
+		}
+		err := copyFile(filepath.Join(dir, entry.Name()), filepath.Join(tmp, entry.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to copy database: %w", err)
+		}
+	}
+	return tmp, nil
+}
+
+// copyFile copies the file src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeCopy removes the copy of a locked directory, if one was made
+func (e *pebbleEngine) removeCopy() {
+	if e.copy != "" {
+		os.RemoveAll(e.copy)
+	}
+}
+
+func (e *pebbleEngine) Get(key []byte) ([]byte, bool, error) {
+	return pebbleGet(e.db, key)
+}
+
+func (e *pebbleEngine) NewIter(opts IterOptions) (EngineIterator, error) {
+	iter, err := e.db.NewIter(pebbleIterOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+func (e *pebbleEngine) NewBatch() EngineBatch {
+	return pebbleBatch{e.db.NewBatch()}
+}
+
+func (e *pebbleEngine) NewSnapshot() Snapshot {
+	return pebbleSnapshot{e.db.NewSnapshot()}
+}
+
+func (e *pebbleEngine) Sync() error {
+	// A synced commit syncs the whole log up to it
+	return e.db.LogData(nil, pebble.Sync)
+}
+
+func (e *pebbleEngine) Checkpoint(dir string) error {
+	return e.db.Checkpoint(dir, pebble.WithFlushedWAL())
+}
+
+func (e *pebbleEngine) Compact(start, end []byte) error {
+	if end == nil {
+		// Compact needs a bound; one past the last key covers every key
+		iter, err := e.db.NewIter(nil)
+		if err != nil {
+			return err
+		}
+		if iter.Last() {
+			end = append(append([]byte(nil), iter.Key()...), 0x00)
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+	if bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	return e.db.Compact(start, end, true)
+}
+
+func (e *pebbleEngine) DiskUsage(start, end []byte) (uint64, error) {
+	return e.db.EstimateDiskUsage(start, end)
+}
+
+func (e *pebbleEngine) Stats() EngineStats {
+	m := e.db.Metrics()
+	stats := EngineStats{
+		Compactions:       m.Compact.Count,
+		CompactionDebt:    m.Compact.EstimatedDebt,
+		CompactionsActive: m.Compact.NumInProgress,
+		Flushes:           m.Flush.Count,
+		ReadAmp:           m.ReadAmp(),
+		BlockCacheSize:    m.BlockCache.Size,
+		BlockCacheHits:    m.BlockCache.Hits,
+		BlockCacheMisses:  m.BlockCache.Misses,
+		MemTableSize:      m.MemTable.Size,
+		WALSize:           m.WAL.Size,
+		DiskUsage:         m.DiskSpaceUsage(),
+		Levels:            make([]LevelStats, len(m.Levels)),
+	}
+	for i, l := range m.Levels {
+		stats.Levels[i] = LevelStats{Files: l.NumFiles, Size: l.Size, Score: l.Score}
+	}
+	return stats
+}
+
+func (e *pebbleEngine) Close() error {
+	err := e.db.Close()
+	if lerr := e.lock.Close(); err == nil {
+		err = lerr
+	}
+	e.removeCopy()
+	return err
+}
+
+// pebbleSnapshot is a Snapshot of a pebbleEngine
+type pebbleSnapshot struct {
+	snap *pebble.Snapshot
+}
+
+func (s pebbleSnapshot) Get(key []byte) ([]byte, bool, error) {
+	return pebbleGet(s.snap, key)
+}
+
+func (s pebbleSnapshot) NewIter(opts IterOptions) (EngineIterator, error) {
+	iter, err := s.snap.NewIter(pebbleIterOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+func (s pebbleSnapshot) Close() error {
+	return s.snap.Close()
+}
+
+// pebbleBatch is an EngineBatch of a pebbleEngine
+type pebbleBatch struct {
+	b *pebble.Batch
+}
+
+func (b pebbleBatch) Set(key, value []byte) error {
+	return b.b.Set(key, value, nil)
+}
+
+func (b pebbleBatch) Delete(key []byte) error {
+	return b.b.Delete(key, nil)
+}
+
+func (b pebbleBatch) DeleteRange(start, end []byte) error {
+	return b.b.DeleteRange(start, end, nil)
+}
+
+func (b pebbleBatch) Empty() bool {
+	return b.b.Empty()
+}
+
+func (b pebbleBatch) Commit(sync bool) error {
+	if sync {
+		return b.b.Commit(pebble.Sync)
+	}
+	return b.b.Commit(pebble.NoSync)
+}
+
+func (b pebbleBatch) Close() error {
+	return b.b.Close()
+}
+
+// pebbleGet reads a copy of the value of key from r
+func pebbleGet(r pebble.Reader, key []byte) ([]byte, bool, error) {
+	value, closer, err := r.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer closer.Close()
+
+	// Copy value since it's only valid until closer.Close()
+	result := make([]byte, len(value))
+	copy(result, value)
+	return result, true, nil
+}
+
+// pebbleIterOptions converts iteration bounds to Pebble's
+func pebbleIterOptions(opts IterOptions) *pebble.IterOptions {
+	return &pebble.IterOptions{
+		LowerBound: opts.LowerBound,
+		UpperBound: opts.UpperBound,
+		SkipPoint:  opts.SkipPoint,
+	}
+}
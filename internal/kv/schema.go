@@ -0,0 +1,186 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// schemaPrefix holds each bucket's schema version, after the bucket's
+// prefix
+const schemaPrefix = reservedPrefix + "schema/"
+
+// Migration moves a bucket's layout up one schema version. Migrate reads
+// the bucket and writes the new layout through txn, which commits with
+// the bucket's new version.
+type Migration struct {
+	Version     uint64 // Version the migration upgrades to, from Version-1
+	Description string
+	Migrate     func(b *Bucket, txn *Txn) error
+}
+
+// MigrateOptions configure Store.Migrate
+type MigrateOptions struct {
+	// DryRun runs each pending migration without committing it. Each runs
+	// against the bucket as it is, without the writes of the migrations
+	// before it.
+	DryRun bool
+}
+
+// MigrationResult describes a migration Migrate ran
+type MigrationResult struct {
+	Bucket      string
+	Version     uint64
+	Description string
+	Writes      int // Keys the migration wrote or deleted
+}
+
+var (
+	migrations   = map[string][]Migration{}
+	migrationsMu sync.RWMutex
+)
+
+// RegisterMigration adds a migration of the bucket named bucket, replacing
+// any of the same version. Register migrations from init functions; a
+// bucket's versions must run from 1 without gaps.
+func RegisterMigration(bucket string, m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	registered := migrations[bucket]
+	for i, r := range registered {
+		if r.Version == m.Version {
+			registered[i] = m
+			return
+		}
+	}
+	migrations[bucket] = append(registered, m)
+}
+
+// Migrate brings every bucket with registered migrations up to its latest
+// schema version, one migration and transaction at a time, and returns the
+// migrations it ran. A bucket holding no keys and no version is new and
+// gets the latest version without migrating. Run it at startup before
+// anything writes the buckets.
+func (s *Store) Migrate(opts MigrateOptions) ([]MigrationResult, error) {
+	migrationsMu.RLock()
+	buckets := make([]string, 0, len(migrations))
+	for bucket := range migrations {
+		buckets = append(buckets, bucket)
+	}
+	migrationsMu.RUnlock()
+	sort.Strings(buckets)
+
+	var results []MigrationResult
+	for _, name := range buckets {
+		ran, err := s.migrateBucket(s.Bucket(name), opts)
+		results = append(results, ran...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// migrateBucket runs the pending migrations of b
+func (s *Store) migrateBucket(b *Bucket, opts MigrateOptions) ([]MigrationResult, error) {
+	pending, latest, err := b.pendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if pending[0].Version == 1 {
+		empty, err := b.empty()
+		if err != nil {
+			return nil, err
+		}
+		if empty {
+			if opts.DryRun {
+				return nil, nil
+			}
+			return nil, s.Update(func(txn *Txn) error {
+				return txn.Put(schemaKey(b.prefix), binary.BigEndian.AppendUint64(nil, latest))
+			})
+		}
+	}
+
+	var results []MigrationResult
+	for _, m := range pending {
+		txn := s.Begin()
+		if err := m.Migrate(b, txn); err != nil {
+			txn.Discard()
+			return results, fmt.Errorf("failed to migrate bucket %s to version %d: %w", b.name, m.Version, err)
+		}
+		writes := len(txn.writes)
+		if opts.DryRun {
+			txn.Discard()
+		} else {
+			err := txn.Put(schemaKey(b.prefix), binary.BigEndian.AppendUint64(nil, m.Version))
+			if err == nil {
+				err = txn.Commit()
+			}
+			if err != nil {
+				return results, fmt.Errorf("failed to migrate bucket %s to version %d: %w", b.name, m.Version, err)
+			}
+		}
+		results = append(results, MigrationResult{Bucket: b.name, Version: m.Version, Description: m.Description, Writes: writes})
+	}
+	return results, nil
+}
+
+// SchemaVersion returns the bucket's schema version, 0 if it has none
+func (b *Bucket) SchemaVersion() (uint64, error) {
+	value, err := b.store.Get(schemaKey(b.prefix))
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("malformed schema version of bucket %s", b.name)
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// pendingMigrations returns the bucket's migrations after its version, in
+// order, and its latest version
+func (b *Bucket) pendingMigrations() ([]Migration, uint64, error) {
+	migrationsMu.RLock()
+	registered := append([]Migration(nil), migrations[b.name]...)
+	migrationsMu.RUnlock()
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Version < registered[j].Version })
+	for i, m := range registered {
+		if m.Version != uint64(i+1) {
+			return nil, 0, fmt.Errorf("migrations of bucket %s skip version %d", b.name, i+1)
+		}
+	}
+
+	version, err := b.SchemaVersion()
+	if err != nil {
+		return nil, 0, err
+	}
+	latest := uint64(len(registered))
+	if version > latest {
+		return nil, 0, fmt.Errorf("bucket %s has schema version %d, newer than the latest known %d", b.name, version, latest)
+	}
+	return registered[version:], latest, nil
+}
+
+// empty reports whether the bucket holds no keys
+func (b *Bucket) empty() (bool, error) {
+	iter, err := b.Scan(nil)
+	if err != nil {
+		return false, err
+	}
+	defer iter.Close()
+	if iter.First() {
+		return false, nil
+	}
+	return true, iter.Err()
+}
+
+// schemaKey returns the key of the schema version of the bucket with
+// prefix
+func schemaKey(bucketPrefix []byte) []byte {
+	return append([]byte(schemaPrefix), bucketPrefix...)
+}
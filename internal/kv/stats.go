@@ -0,0 +1,98 @@
+package kv
+
+import "time"
+
+// Operations reported to a Recorder
+const (
+	OpGet    = "get"
+	OpPut    = "put"
+	OpPutTTL = "put_ttl"
+	OpDelete = "delete"
+	OpMerge  = "merge"
+	OpBatch  = "batch"
+	OpTxn    = "txn"
+	OpSweep  = "sweep"
+)
+
+// Recorder receives measurements of the store's operations
+type Recorder interface {
+	// RecordOp records an operation's latency and whether it failed
+	RecordOp(op string, latency time.Duration, failed bool)
+	// RecordBatch records the number of writes and bytes an operation
+	// committed atomically
+	RecordBatch(op string, writes, bytes int)
+}
+
+// EngineStats are an engine's internal measurements
+type EngineStats struct {
+	Compactions       int64
+	CompactionDebt    uint64 // Bytes to compact before the LSM is in shape
+	CompactionsActive int64
+	Flushes           int64
+	ReadAmp           int // Files a point read may consult
+	BlockCacheSize    int64
+	BlockCacheHits    int64
+	BlockCacheMisses  int64
+	MemTableSize      uint64
+	WALSize           uint64
+	DiskUsage         uint64
+	Levels            []LevelStats
+}
+
+// LevelStats describe one level of an LSM engine
+type LevelStats struct {
+	Files int64
+	Size  int64
+	Score float64 // Compaction priority; 1 or more needs compacting
+}
+
+// StatsReporter is an Engine that reports EngineStats
+type StatsReporter interface {
+	Stats() EngineStats
+}
+
+// recorderBox holds a Recorder in an atomic.Value
+type recorderBox struct {
+	r Recorder
+}
+
+// SetRecorder makes the store report its operations to r; nil stops
+// reporting
+func (s *Store) SetRecorder(r Recorder) {
+	s.recorder.Store(recorderBox{r})
+}
+
+// EngineStats returns the engine's internal measurements, if it reports
+// any
+func (s *Store) EngineStats() (EngineStats, bool) {
+	if err := s.acquire(); err != nil {
+		return EngineStats{}, false
+	}
+	defer s.release()
+	reporter, ok := s.engine.(StatsReporter)
+	if !ok {
+		return EngineStats{}, false
+	}
+	return reporter.Stats(), true
+}
+
+// observe reports an operation that began at start and failed if *errp is
+// set. Call it deferred.
+func (s *Store) observe(op string, start time.Time, errp *error) {
+	if r := s.loadRecorder(); r != nil {
+		r.RecordOp(op, time.Since(start), *errp != nil)
+	}
+}
+
+// recordBatch reports the size of a committed batch
+func (s *Store) recordBatch(op string, writes, bytes int) {
+	if r := s.loadRecorder(); r != nil {
+		r.RecordBatch(op, writes, bytes)
+	}
+}
+
+// loadRecorder returns the store's recorder, if any
+func (s *Store) loadRecorder() Recorder {
+	box, _ := s.recorder.Load().(recorderBox)
+	return box.r
+}
@@ -3,96 +3,273 @@ package kv
 import (
 	"fmt"
 	"sync"
-
-	"github.com/cockroachdb/pebble"
+	"sync/atomic"
+	"time"
 )
 
 // Store represents a key-value store
 type Store struct {
-	db      *pebble.DB
-	writeMu sync.RWMutex
+	engine   Engine
+	path     string
+	merge    MergeOperator
+	readOnly bool
+
+	defaultDurability Durability
+	group             groupCommitter
+
+	// Operations hold closeMu for reading so Close waits for them; writers
+	// of a key otherwise only contend through keyLocks
+	closeMu  sync.RWMutex
+	closed   bool
+	keyLocks keyLocks
+	txnMu    sync.Mutex // Serializes transaction commits
+
+	// hasTTL is set once any key has a TTL; done stops the sweeper, which
+	// closes swept when it exits
+	hasTTL atomic.Bool
+	done   chan struct{}
+	swept  chan struct{}
+
+	watchers watchers
+	indexes  indexes
+	recorder atomic.Value // recorderBox
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // Config represents store configuration
 type Config struct {
-	Path string
+	Engine        string // Storage engine; empty uses EnginePebble
+	Path          string
+	ReadOnly      bool          // Open without writing; writes fail with ErrReadOnly
+	SweepInterval time.Duration // How often expired keys are removed; 0 uses DefaultSweepInterval
+	Merge         MergeOperator // Operator of Merge; nil disables Merge
+
+	Durability         Durability    // Default durability of writes
+	GroupCommitLatency time.Duration // Longest a DurabilityGroup write waits; 0 uses DefaultGroupCommitLatency
+
+	CacheSize      int64 // Bytes of block cache; 0 uses the engine's default
+	TargetFileSize int64 // Size of the files of the first LSM level, doubling per level; 0 uses the engine's default
 }
 
 // New creates a new Store instance
 func New(cfg Config) (*Store, error) {
-	// Open Pebble database
-	db, err := pebble.Open(cfg.Path, &pebble.Options{})
+	engine, err := openEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hasTTL, err := hasExpiries(engine)
 	if err != nil {
+		engine.Close()
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	return &Store{
-		db: db,
-	}, nil
+	s := &Store{
+		engine:   engine,
+		path:     cfg.Path,
+		merge:    cfg.Merge,
+		readOnly: cfg.ReadOnly,
+		done:     make(chan struct{}),
+		swept:    make(chan struct{}),
+
+		defaultDurability: cfg.Durability,
+		group:             groupCommitter{sync: engine.Sync, latency: cfg.GroupCommitLatency},
+	}
+	if s.group.latency <= 0 {
+		s.group.latency = DefaultGroupCommitLatency
+	}
+	s.hasTTL.Store(hasTTL)
+	if cfg.ReadOnly {
+		// Expired keys read as missing without being swept
+		close(s.swept)
+		return s, nil
+	}
+	if cfg.SweepInterval <= 0 {
+		cfg.SweepInterval = DefaultSweepInterval
+	}
+	go s.sweeper(cfg.SweepInterval)
+	return s, nil
 }
 
-// Get retrieves a value by key
-func (s *Store) Get(key []byte) ([]byte, error) {
-	s.writeMu.RLock()
-	defer s.writeMu.RUnlock()
+// ReadOnly reports whether the store was opened read-only
+func (s *Store) ReadOnly() bool {
+	return s.readOnly
+}
 
-	value, closer, err := s.db.Get(key)
-	if err == pebble.ErrNotFound {
-		return nil, nil
+// Get retrieves a value by key. It returns nil for missing and expired
+// keys.
+func (s *Store) Get(key []byte) (value []byte, err error) {
+	defer s.observe(OpGet, time.Now(), &err)
+	if err := s.acquire(); err != nil {
+		return nil, err
 	}
+	defer s.release()
+	return s.get(key)
+}
+
+// get retrieves a value by key. closeMu must be held.
+func (s *Store) get(key []byte) ([]byte, error) {
+	if expired, err := s.expired(s.engine, key); err != nil || expired {
+		return nil, err
+	}
+	value, ok, err := s.engine.Get(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get key: %w", err)
 	}
-	defer closer.Close()
-
-	// Copy value since it's only valid until closer.Close()
-	result := make([]byte, len(value))
-	copy(result, value)
-	return result, nil
+	if !ok {
+		return nil, nil
+	}
+	return value, nil
 }
 
 // Put stores a key-value pair
-func (s *Store) Put(key, value []byte) error {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+func (s *Store) Put(key, value []byte, opts ...WriteOption) (err error) {
+	defer s.observe(OpPut, time.Now(), &err)
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+	d := s.durability(opts)
+	defer s.settle(d, &err)
+	defer s.keyLocks.lock(key)()
 
-	if err := s.db.Set(key, value, pebble.Sync); err != nil {
+	if err := s.write(key, value, false, d); err != nil {
 		return fmt.Errorf("failed to set key: %w", err)
 	}
+	s.announce(ChangePut, key, value)
 	return nil
 }
 
 // Delete removes a key-value pair
-func (s *Store) Delete(key []byte) error {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+func (s *Store) Delete(key []byte, opts ...WriteOption) (err error) {
+	defer s.observe(OpDelete, time.Now(), &err)
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+	d := s.durability(opts)
+	defer s.settle(d, &err)
+	defer s.keyLocks.lock(key)()
 
-	if err := s.db.Delete(key, pebble.Sync); err != nil {
+	if err := s.write(key, nil, true, d); err != nil {
 		return fmt.Errorf("failed to delete key: %w", err)
 	}
+	s.announce(ChangeDelete, key, nil)
 	return nil
 }
 
+// write sets or deletes a key, clearing its TTL in the same batch. closeMu
+// and the key's lock must be held.
+func (s *Store) write(key, value []byte, del bool, d Durability) error {
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	err := s.index(batch, key, value, del)
+	if err == nil && del {
+		err = batch.Delete(key)
+	} else if err == nil {
+		err = batch.Set(key, value)
+	}
+	if err == nil && s.hasTTL.Load() {
+		err = batch.Delete(expiresKey(key))
+	}
+	if err == nil {
+		err = s.commit(batch, d)
+	}
+	return err
+}
+
 // NewBatch creates a new write batch
-func (s *Store) NewBatch() *pebble.Batch {
-	return s.db.NewBatch()
+func (s *Store) NewBatch() *Batch {
+	return &Batch{store: s, b: s.engine.NewBatch()}
 }
 
-// Close shuts down the store
+// Close shuts down the store once operations in progress finish; later
+// operations fail with ErrClosed. Closing it again does nothing.
 func (s *Store) Close() error {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.swept
+		s.closeWatchers()
 
-	if err := s.db.Close(); err != nil {
-		return fmt.Errorf("failed to close database: %w", err)
-	}
-	return nil
+		s.closeMu.Lock()
+		defer s.closeMu.Unlock()
+		s.closed = true
+
+		if err := s.engine.Close(); err != nil {
+			s.closeErr = fmt.Errorf("failed to close database: %w", err)
+		}
+	})
+	return s.closeErr
 }
 
 // Snapshot creates a consistent point-in-time snapshot
-func (s *Store) Snapshot() (*pebble.Snapshot, error) {
-	s.writeMu.RLock()
-	defer s.writeMu.RUnlock()
+func (s *Store) Snapshot() (Snapshot, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	return s.engine.NewSnapshot(), nil
+}
+
+// Batch is a set of writes applied atomically on Commit. Writes through a
+// batch keep the TTLs of their keys, are not seen by watchers, and do not
+// update indexes.
+type Batch struct {
+	store  *Store
+	b      EngineBatch
+	writes int
+	bytes  int
+}
+
+// Set buffers a write of a key-value pair
+func (b *Batch) Set(key, value []byte) error {
+	b.count(len(key) + len(value))
+	return b.b.Set(key, value)
+}
+
+// Delete buffers the removal of a key
+func (b *Batch) Delete(key []byte) error {
+	b.count(len(key))
+	return b.b.Delete(key)
+}
+
+// DeleteRange buffers the removal of the keys from start up to but
+// excluding end
+func (b *Batch) DeleteRange(start, end []byte) error {
+	b.count(len(start) + len(end))
+	return b.b.DeleteRange(start, end)
+}
+
+// count adds a write of size bytes to the batch's measurements
+func (b *Batch) count(size int) {
+	b.writes++
+	b.bytes += size
+}
+
+// Empty reports whether the batch holds no writes
+func (b *Batch) Empty() bool {
+	return b.b.Empty()
+}
+
+// Commit applies the batch's writes, durably unless opts say otherwise
+func (b *Batch) Commit(opts ...WriteOption) (err error) {
+	defer b.store.observe(OpBatch, time.Now(), &err)
+	if err := b.store.acquireWrite(); err != nil {
+		return err
+	}
+	defer b.store.release()
+	d := b.store.durability(opts)
+	defer b.store.settle(d, &err)
+	if err := b.store.commit(b.b, d); err != nil {
+		return err
+	}
+	b.store.recordBatch(OpBatch, b.writes, b.bytes)
+	return nil
+}
 
-	return s.db.NewSnapshot(), nil
+// Close releases the batch
+func (b *Batch) Close() error {
+	return b.b.Close()
 }
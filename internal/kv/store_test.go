@@ -0,0 +1,1092 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func collect(t *testing.T, iter *Iterator) []string {
+	t.Helper()
+	defer iter.Close()
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	return keys
+}
+
+func TestStore_Scan(t *testing.T) {
+	s := newTestStore(t)
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1", "a"} {
+		if err := s.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		open func() (*Iterator, error)
+		want []string
+	}{
+		{"prefix", func() (*Iterator, error) { return s.Scan([]byte("a/")) }, []string{"a/1=a/1", "a/2=a/2", "a/3=a/3"}},
+		{"range", func() (*Iterator, error) { return s.Iterate([]byte("a/2"), []byte("b/1")) }, []string{"a/2=a/2", "a/3=a/3"}},
+		{"open end", func() (*Iterator, error) { return s.Iterate([]byte("a/3"), nil) }, []string{"a/3=a/3", "b/1=b/1"}},
+		{"empty prefix", func() (*Iterator, error) { return s.Scan(nil) }, []string{"a=a", "a/1=a/1", "a/2=a/2", "a/3=a/3", "b/1=b/1"}},
+		{"no match", func() (*Iterator, error) { return s.Scan([]byte("c/")) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iter, err := tt.open()
+			if err != nil {
+				t.Fatalf("open error = %v", err)
+			}
+			got := collect(t, iter)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	// Iterators see the store as it was when they were created
+	iter, err := s.Scan([]byte("a/"))
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	s.Put([]byte("a/4"), []byte("a/4"))
+	s.Delete([]byte("a/1"))
+	if got := collect(t, iter); len(got) != 3 || got[0] != "a/1=a/1" {
+		t.Errorf("snapshot iterator got %v, want the original a/1 to a/3", got)
+	}
+
+	iter, _ = s.Scan([]byte("a/"))
+	defer iter.Close()
+	if !iter.Last() || !bytes.Equal(iter.Key(), []byte("a/4")) {
+		t.Error("Last() is not a/4")
+	}
+	if !iter.SeekGE([]byte("a/25")) || !bytes.Equal(iter.Key(), []byte("a/3")) {
+		t.Error("SeekGE(a/25) is not a/3")
+	}
+	if !iter.Prev() || !bytes.Equal(iter.Key(), []byte("a/2")) {
+		t.Error("Prev() is not a/2")
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("a/"), []byte("a0")},
+		{[]byte{'a', 0xff}, []byte("b")},
+		{[]byte{0xff, 0xff}, nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := PrefixEnd(tt.prefix); !bytes.Equal(got, tt.want) {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestTxn(t *testing.T) {
+	s := newTestStore(t)
+	s.Put([]byte("balance"), []byte("10"))
+	s.Put([]byte("old"), []byte("x"))
+
+	t.Run("read your writes", func(t *testing.T) {
+		txn := s.Begin()
+		txn.Put([]byte("balance"), []byte("5"))
+		txn.Delete([]byte("old"))
+		if got, _ := txn.Get([]byte("balance")); string(got) != "5" {
+			t.Errorf("Get(balance) in txn = %q, want 5", got)
+		}
+		if got, _ := txn.Get([]byte("old")); got != nil {
+			t.Errorf("Get(old) in txn = %q, want deleted", got)
+		}
+		if got, _ := s.Get([]byte("balance")); string(got) != "10" {
+			t.Errorf("uncommitted write visible: balance = %q", got)
+		}
+		txn.Discard()
+		if err := txn.Put([]byte("balance"), nil); !errors.Is(err, ErrTxnDone) {
+			t.Errorf("Put() after Discard() error = %v, want ErrTxnDone", err)
+		}
+		if got, _ := s.Get([]byte("balance")); string(got) != "10" {
+			t.Errorf("discarded write applied: balance = %q", got)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		txn := s.Begin()
+		txn.Put([]byte("a"), []byte("1"))
+		txn.Put([]byte("b"), []byte("2"))
+		txn.Delete([]byte("old"))
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+		for key, want := range map[string]string{"a": "1", "b": "2", "old": ""} {
+			if got, _ := s.Get([]byte(key)); string(got) != want {
+				t.Errorf("%s = %q, want %q", key, got, want)
+			}
+		}
+		if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+			t.Errorf("second Commit() error = %v, want ErrTxnDone", err)
+		}
+	})
+
+	t.Run("snapshot isolation and conflict", func(t *testing.T) {
+		txn := s.Begin()
+		s.Put([]byte("balance"), []byte("20"))
+		if got, _ := txn.Get([]byte("balance")); string(got) != "10" {
+			t.Errorf("Get(balance) = %q, want the snapshot's 10", got)
+		}
+		txn.Put([]byte("balance"), []byte("11"))
+		if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+			t.Errorf("Commit() error = %v, want ErrConflict", err)
+		}
+
+		// Keys that were not read do not conflict
+		txn = s.Begin()
+		s.Put([]byte("a"), []byte("9"))
+		txn.Put([]byte("a"), []byte("3"))
+		if err := txn.Commit(); err != nil {
+			t.Errorf("blind write Commit() error = %v", err)
+		}
+	})
+
+	t.Run("update retries", func(t *testing.T) {
+		attempts := 0
+		err := s.Update(func(txn *Txn) error {
+			attempts++
+			v, _ := txn.Get([]byte("counter"))
+			if attempts == 1 {
+				s.Put([]byte("counter"), []byte("x"))
+			}
+			return txn.Put([]byte("counter"), append(v, 'y'))
+		})
+		if err != nil || attempts != 2 {
+			t.Errorf("Update() = %v after %d attempts, want success after 2", err, attempts)
+		}
+		if got, _ := s.Get([]byte("counter")); string(got) != "xy" {
+			t.Errorf("counter = %q, want xy", got)
+		}
+	})
+}
+
+func TestStore_TTL(t *testing.T) {
+	s := newTestStore(t)
+	s.PutWithTTL([]byte("session"), []byte("token"), time.Hour)
+	s.PutWithTTL([]byte("lock"), []byte("held"), time.Millisecond)
+	s.PutWithTTL([]byte("renewed"), []byte("v1"), time.Millisecond)
+	s.PutWithTTL([]byte("renewed"), []byte("v2"), time.Hour)
+	s.PutWithTTL([]byte("cleared"), []byte("v1"), time.Millisecond)
+	s.Put([]byte("cleared"), []byte("v2"))
+	time.Sleep(5 * time.Millisecond)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"session", "token"},
+		{"lock", ""},
+		{"renewed", "v2"},
+		{"cleared", "v2"},
+	}
+	check := func(stage string) {
+		for _, tt := range tests {
+			if got, err := s.Get([]byte(tt.key)); err != nil || string(got) != tt.want {
+				t.Errorf("%s: Get(%s) = %q, %v, want %q", stage, tt.key, got, err, tt.want)
+			}
+		}
+	}
+	check("before sweep")
+	if txn := s.Begin(); txn != nil {
+		if got, _ := txn.Get([]byte("lock")); got != nil {
+			t.Errorf("Txn.Get(lock) = %q, want expired", got)
+		}
+		txn.Discard()
+	}
+
+	if n, err := s.Sweep(time.Now()); err != nil || n != 1 {
+		t.Errorf("Sweep() = %d, %v, want 1 expired key", n, err)
+	}
+	check("after sweep")
+	if got := collect(t, mustScan(t, s, nil)); len(got) != 3 {
+		t.Errorf("Scan() = %v, want the 3 live keys without bookkeeping", got)
+	}
+	if n, _ := s.Sweep(time.Now().Add(2 * time.Hour)); n != 2 {
+		t.Errorf("Sweep() later = %d, want 2", n)
+	}
+	if err := s.PutWithTTL([]byte("k"), nil, 0); err == nil {
+		t.Error("PutWithTTL() with no TTL succeeded")
+	}
+}
+
+func mustScan(t *testing.T, s *Store, prefix []byte) *Iterator {
+	t.Helper()
+	iter, err := s.Scan(prefix)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	return iter
+}
+
+func TestStore_Watch(t *testing.T) {
+	s := newTestStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	deployments := s.Watch(ctx, []byte("deploy/"))
+	everything := s.Watch(context.Background(), nil)
+
+	s.Put([]byte("deploy/a"), []byte("1"))
+	s.Put([]byte("other"), []byte("x"))
+	s.PutWithTTL([]byte("deploy/b"), []byte("2"), time.Millisecond)
+	s.Delete([]byte("deploy/a"))
+	s.Update(func(txn *Txn) error {
+		return txn.Put([]byte("deploy/c"), []byte("3"))
+	})
+	s.Sweep(time.Now().Add(time.Second))
+
+	want := []string{"put deploy/a=1", "put deploy/b=2", "delete deploy/a=", "put deploy/c=3", "delete deploy/b="}
+	for _, w := range want {
+		select {
+		case c := <-deployments:
+			if got := c.Type + " " + string(c.Key) + "=" + string(c.Value); got != w {
+				t.Errorf("change = %q, want %q", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change %q", w)
+		}
+	}
+	select {
+	case c := <-deployments:
+		t.Errorf("unexpected change %+v", c)
+	default:
+	}
+	if n := len(everything); n != 6 {
+		t.Errorf("unfiltered watcher got %d changes, want 6", n)
+	}
+
+	cancel()
+	if _, ok := <-deployments; ok {
+		t.Error("watch channel still open after its context ended")
+	}
+	s.Close()
+	for range everything {
+	}
+}
+
+func TestBucket(t *testing.T) {
+	s := newTestStore(t)
+	souls := s.Bucket("souls")
+	// Names that are prefixes of each other, or hold the escape byte, must
+	// not collide
+	soulsX := s.Bucket("souls\x00x")
+	logs := s.Bucket("logs")
+
+	souls.Put([]byte("a"), []byte("1"))
+	souls.Put([]byte("b"), []byte("22"))
+	soulsX.Put([]byte("a"), []byte("x"))
+	logs.PutWithTTL([]byte("a"), []byte("log"), time.Hour)
+	s.Put([]byte("souls/a"), []byte("raw"))
+
+	if got, _ := souls.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("souls Get() = %q, want 1", got)
+	}
+	if got, _ := soulsX.Get([]byte("a")); string(got) != "x" {
+		t.Errorf("souls\\x00x Get() = %q, want x", got)
+	}
+
+	iter, err := souls.Scan(nil)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if got := collect(t, iter); len(got) != 2 || got[0] != "a=1" || got[1] != "b=22" {
+		t.Errorf("souls Scan() = %v", got)
+	}
+	iter, err = souls.Iterate([]byte("b"), nil)
+	if err != nil {
+		t.Fatalf("Iterate() error = %v", err)
+	}
+	if got := collect(t, iter); len(got) != 1 || got[0] != "b=22" {
+		t.Errorf("souls Iterate() = %v", got)
+	}
+
+	stats, err := souls.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Keys != 2 || stats.Bytes != 5 {
+		t.Errorf("Stats() = %+v, want 2 keys of 5 bytes", stats)
+	}
+
+	names, err := s.Buckets()
+	if err != nil {
+		t.Fatalf("Buckets() error = %v", err)
+	}
+	if len(names) != 3 || names[0] != "logs" || names[1] != "souls" || names[2] != "souls\x00x" {
+		t.Errorf("Buckets() = %q", names)
+	}
+
+	if err := logs.Wipe(); err != nil {
+		t.Fatalf("Wipe() error = %v", err)
+	}
+	if got, _ := logs.Get([]byte("a")); got != nil {
+		t.Errorf("Get() after Wipe() = %q, want nil", got)
+	}
+	// The wiped key's TTL goes with it, even for writes that keep TTLs
+	batch := s.NewBatch()
+	batch.Set(logs.Key([]byte("a")), []byte("again"))
+	batch.Commit()
+	batch.Close()
+	if n, _ := s.Sweep(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Errorf("Sweep() removed %d keys after Wipe(), want 0", n)
+	}
+	if got, _ := souls.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("Wipe() touched another bucket: Get() = %q", got)
+	}
+	if got, _ := s.Get([]byte("souls/a")); string(got) != "raw" {
+		t.Errorf("Wipe() touched unbucketed keys: Get() = %q", got)
+	}
+}
+
+func TestBucket_Index(t *testing.T) {
+	s := newTestStore(t)
+	deployments := s.Bucket("deployments")
+	// Values are comma-separated labels
+	labels := func(key, value []byte) [][]byte {
+		if len(value) == 0 {
+			return nil
+		}
+		return bytes.Split(value, []byte(","))
+	}
+	deployments.Put([]byte("d1"), []byte("prod,web"))
+	s.Bucket("other").Put([]byte("d9"), []byte("prod"))
+
+	// Keys written before the index is defined are indexed
+	byLabel, err := deployments.DefineIndex("label", labels)
+	if err != nil {
+		t.Fatalf("DefineIndex() error = %v", err)
+	}
+	deployments.Put([]byte("d2"), []byte("prod"))
+	deployments.PutWithTTL([]byte("d3"), []byte("web"), time.Hour)
+	deployments.Put([]byte("d1"), []byte("staging,web"))
+	s.Update(func(txn *Txn) error {
+		return txn.Put(deployments.Key([]byte("d4")), []byte("prod"))
+	})
+	deployments.Delete([]byte("d2"))
+	deployments.PutWithTTL([]byte("d5"), []byte("prod"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	lookup := func(term string) string {
+		t.Helper()
+		keys, err := byLabel.Lookup([]byte(term))
+		if err != nil {
+			t.Fatalf("Lookup(%s) error = %v", term, err)
+		}
+		return string(bytes.Join(keys, []byte(",")))
+	}
+	tests := map[string]string{"prod": "d4", "web": "d1,d3", "staging": "d1", "pro": ""}
+	for term, want := range tests {
+		if got := lookup(term); got != want {
+			t.Errorf("Lookup(%s) = %q, want %q", term, got, want)
+		}
+	}
+
+	// Swept keys leave the index
+	entry := byLabel.entryKey([]byte("prod"), []byte("d5"))
+	if _, ok, _ := s.engine.Get(entry); !ok {
+		t.Fatal("expired key missing from index before Sweep()")
+	}
+	s.Sweep(time.Now())
+	if _, ok, _ := s.engine.Get(entry); ok {
+		t.Error("Sweep() left the index entry of a swept key")
+	}
+	if err := byLabel.Rebuild(); err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	if got := lookup("prod"); got != "d4" {
+		t.Errorf("Lookup(prod) after sweep and rebuild = %q, want d4", got)
+	}
+
+	if err := deployments.Wipe(); err != nil {
+		t.Fatalf("Wipe() error = %v", err)
+	}
+	if got := lookup("web"); got != "" {
+		t.Errorf("Lookup(web) after Wipe() = %q, want none", got)
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	for _, name := range []string{"", "souls", "a\x00b", "\x00", "a\x00\x01"} {
+		got, ok := bucketName(bucketPrefix(name)[len(bucketsPrefix):])
+		if !ok || got != name {
+			t.Errorf("bucketName(bucketPrefix(%q)) = %q, %v", name, got, ok)
+		}
+	}
+}
+
+func TestStore_BackupRestore(t *testing.T) {
+	s := newTestStore(t)
+	s.Put([]byte("a"), []byte("1"))
+	s.Bucket("souls").Put([]byte("b"), []byte("2"))
+
+	var backup bytes.Buffer
+	if err := s.Backup(&backup); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	// Writes after the backup are not in it
+	s.Put([]byte("c"), []byte("3"))
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(bytes.NewReader(backup.Bytes()), dir); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("New() on restored dir error = %v", err)
+	}
+	defer restored.Close()
+	if got, _ := restored.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("Get(a) = %q, want 1", got)
+	}
+	if got, _ := restored.Bucket("souls").Get([]byte("b")); string(got) != "2" {
+		t.Errorf("Get(souls/b) = %q, want 2", got)
+	}
+	if got, _ := restored.Get([]byte("c")); got != nil {
+		t.Errorf("Get(c) = %q, want nil", got)
+	}
+
+	if err := Restore(bytes.NewReader(backup.Bytes()), dir); err == nil {
+		t.Error("Restore() into a non-empty dir succeeded")
+	}
+	bad := filepath.Join(t.TempDir(), "bad")
+	if err := Restore(strings.NewReader("not a tar"), bad); err == nil {
+		t.Error("Restore() of garbage succeeded")
+	}
+	if _, err := os.Stat(bad); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed Restore() left %s behind", bad)
+	}
+}
+
+func TestStore_ExportImportSnapshot(t *testing.T) {
+	src := newTestStore(t)
+	src.Put([]byte("soul/1/name"), []byte("neo"))
+	src.PutWithTTL([]byte("soul/1/lease"), []byte("held"), time.Hour)
+	src.PutWithTTL([]byte("soul/1/gone"), []byte("x"), time.Nanosecond)
+	src.Put([]byte("soul/2/name"), []byte("trinity"))
+	time.Sleep(time.Millisecond)
+
+	var stream bytes.Buffer
+	if n, err := src.ExportSnapshot(&stream, []byte("soul/1/")); err != nil || n != 2 {
+		t.Fatalf("ExportSnapshot() = %d, %v; want 2 keys", n, err)
+	}
+
+	dst, err := New(Config{Engine: EngineMemory})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer dst.Close()
+	dst.Put([]byte("soul/1/stale"), []byte("old"))
+	dst.Put([]byte("soul/3/name"), []byte("morpheus"))
+
+	// A corrupt or truncated stream changes nothing
+	data := stream.Bytes()
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	for name, bad := range map[string][]byte{"corrupt": corrupt, "truncated": data[:len(data)-1]} {
+		if _, err := dst.ImportSnapshot(bytes.NewReader(bad)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("ImportSnapshot(%s) error = %v, want ErrBadSnapshot", name, err)
+		}
+	}
+	if got, _ := dst.Get([]byte("soul/1/stale")); string(got) != "old" {
+		t.Errorf("failed ImportSnapshot() changed the store")
+	}
+
+	if n, err := dst.ImportSnapshot(bytes.NewReader(data)); err != nil || n != 2 {
+		t.Fatalf("ImportSnapshot() = %d, %v; want 2 keys", n, err)
+	}
+	want := map[string]string{"soul/1/name": "neo", "soul/1/lease": "held", "soul/1/stale": "", "soul/3/name": "morpheus"}
+	for key, value := range want {
+		if got, _ := dst.Get([]byte(key)); string(got) != value {
+			t.Errorf("Get(%s) = %q, want %q", key, got, value)
+		}
+	}
+	if ok, _ := dst.deadlineOf([]byte("soul/1/lease")); !ok {
+		t.Error("ImportSnapshot() dropped a TTL")
+	}
+
+	if _, err := src.ExportSnapshot(&stream, nil); err == nil {
+		t.Error("ExportSnapshot() of the whole store succeeded")
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	s, err := New(Config{Path: t.TempDir(), CacheSize: 1 << 20, TargetFileSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	big := s.Bucket("big")
+	value := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 512; i++ {
+		big.Put([]byte(fmt.Sprintf("k%03d", i)), value, WithDurability(DurabilityAsync))
+	}
+	s.Bucket("small").Put([]byte("k"), []byte("v"))
+
+	// Compacting moves the keys from memory into files
+	if err := s.Compact(nil, nil); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	usage, err := s.BucketDiskUsage()
+	if err != nil {
+		t.Fatalf("BucketDiskUsage() error = %v", err)
+	}
+	if usage["big"] == 0 || usage["small"] >= usage["big"] {
+		t.Errorf("BucketDiskUsage() = %v", usage)
+	}
+
+	for i := 0; i < 512; i++ {
+		big.Delete([]byte(fmt.Sprintf("k%03d", i)), WithDurability(DurabilityAsync))
+	}
+	if err := big.Compact(); err != nil {
+		t.Fatalf("Bucket.Compact() error = %v", err)
+	}
+	if after, _ := big.DiskUsage(); after >= usage["big"] {
+		t.Errorf("DiskUsage() after deleting and compacting = %d, was %d", after, usage["big"])
+	}
+
+	mem, err := New(Config{Engine: EngineMemory})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mem.Close()
+	if err := mem.Compact(nil, nil); err == nil {
+		t.Error("Compact() on the memory engine succeeded")
+	}
+}
+
+func TestStore_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(Config{Path: dir})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.Put([]byte("a"), []byte("1"))
+
+	if _, err := New(Config{Path: dir}); !errors.Is(err, ErrLocked) {
+		t.Errorf("New() of a locked dir error = %v, want ErrLocked", err)
+	}
+	// A locked dir is read through a copy
+	locked, err := New(Config{Path: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("New(ReadOnly) of a locked dir error = %v", err)
+	}
+	if got, _ := locked.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("Get() = %q, want 1", got)
+	}
+	locked.Close()
+	s.Close()
+
+	ro, err := New(Config{Path: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("New(ReadOnly) error = %v", err)
+	}
+	defer ro.Close()
+	if got, _ := ro.Get([]byte("a")); string(got) != "1" {
+		t.Errorf("Get() = %q, want 1", got)
+	}
+	if err := ro.Put([]byte("b"), []byte("2")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put() error = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Update(func(txn *Txn) error { return txn.Put([]byte("b"), []byte("2")) }); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Update() error = %v, want ErrReadOnly", err)
+	}
+	txn := ro.Begin()
+	txn.Get([]byte("a"))
+	if err := txn.Commit(); err != nil {
+		t.Errorf("Commit() of a read-only transaction error = %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	if _, err := New(Config{Path: missing, ReadOnly: true}); err == nil {
+		t.Error("New(ReadOnly) of a missing dir succeeded")
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Error("New(ReadOnly) created the missing dir")
+	}
+}
+
+func TestStore_WriteBatch(t *testing.T) {
+	s := newTestStore(t)
+	w := s.WriteBatch(WriteBatchOptions{MaxWrites: 3})
+	for i := 0; i < 7; i++ {
+		if err := w.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v")); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if n := w.Written(); n != 6 {
+		t.Errorf("Written() = %d, want 6 after two flushes", n)
+	}
+	if got, _ := s.Get([]byte("k6")); got != nil {
+		t.Errorf("Get(k6) = %q before Close()", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, _ := s.Get([]byte("k6")); string(got) != "v" {
+		t.Errorf("Get(k6) = %q after Close(), want v", got)
+	}
+
+	// Failed flushes are reported together on Close
+	w = s.WriteBatch(WriteBatchOptions{MaxBytes: 4})
+	w.Set([]byte("a"), []byte("1234"))
+	w.Delete([]byte("k0"))
+	s.Close()
+	if err := w.Set([]byte("b"), []byte("1234")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() on a closed store error = %v, want ErrClosed", err)
+	}
+	w.Delete([]byte("k1"))
+	if err := w.Close(); !errors.Is(err, ErrClosed) || strings.Count(err.Error(), ErrClosed.Error()) != 2 {
+		t.Errorf("Close() error = %v, want two ErrClosed", err)
+	}
+}
+
+func TestStore_Migrate(t *testing.T) {
+	s := newTestStore(t)
+	t.Cleanup(func() {
+		migrationsMu.Lock()
+		defer migrationsMu.Unlock()
+		for _, name := range []string{"migrate/people", "migrate/fresh", "migrate/gap"} {
+			delete(migrations, name)
+		}
+	})
+	RegisterMigration("migrate/people", Migration{
+		Version:     2,
+		Description: "move names under name/",
+		Migrate: func(b *Bucket, txn *Txn) error {
+			iter, err := b.Scan(nil)
+			if err != nil {
+				return err
+			}
+			defer iter.Close()
+			for valid := iter.First(); valid; valid = iter.Next() {
+				txn.Delete(b.Key(iter.Key()))
+				txn.Put(b.Key(append([]byte("name/"), iter.Key()...)), iter.Value())
+			}
+			return iter.Err()
+		},
+	})
+	RegisterMigration("migrate/people", Migration{
+		Version:     1,
+		Description: "upper-case names",
+		Migrate: func(b *Bucket, txn *Txn) error {
+			value, err := txn.Get(b.Key([]byte("1")))
+			if err != nil {
+				return err
+			}
+			return txn.Put(b.Key([]byte("1")), bytes.ToUpper(value))
+		},
+	})
+	RegisterMigration("migrate/fresh", Migration{Version: 1, Migrate: func(*Bucket, *Txn) error {
+		return errors.New("ran on a new bucket")
+	}})
+	people := s.Bucket("migrate/people")
+	people.Put([]byte("1"), []byte("neo"))
+
+	results, err := s.Migrate(MigrateOptions{DryRun: true})
+	if err != nil || len(results) != 2 || results[1].Writes != 2 {
+		t.Fatalf("Migrate(DryRun) = %+v, %v", results, err)
+	}
+	if v, _ := people.SchemaVersion(); v != 0 {
+		t.Errorf("SchemaVersion() after dry run = %d, want 0", v)
+	}
+
+	results, err = s.Migrate(MigrateOptions{})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Migrate() = %+v, %v", results, err)
+	}
+	if got, _ := people.Get([]byte("name/1")); string(got) != "NEO" {
+		t.Errorf("Get(name/1) = %q, want NEO", got)
+	}
+	// A new bucket starts at its latest version
+	for name, want := range map[string]uint64{"migrate/people": 2, "migrate/fresh": 1} {
+		if v, _ := s.Bucket(name).SchemaVersion(); v != want {
+			t.Errorf("SchemaVersion(%s) = %d, want %d", name, v, want)
+		}
+	}
+	if results, err := s.Migrate(MigrateOptions{}); err != nil || len(results) != 0 {
+		t.Errorf("Migrate() again = %+v, %v; want nothing to run", results, err)
+	}
+
+	RegisterMigration("migrate/gap", Migration{Version: 2})
+	if _, err := s.Migrate(MigrateOptions{}); err == nil {
+		t.Error("Migrate() with a missing version succeeded")
+	}
+}
+
+func TestEngines(t *testing.T) {
+	for _, engine := range []string{EnginePebble, EngineMemory} {
+		t.Run(engine, func(t *testing.T) {
+			s, err := New(Config{Engine: engine, Path: t.TempDir()})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer s.Close()
+
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if err := s.Put([]byte(key), []byte(key)); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+			snap, err := s.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot() error = %v", err)
+			}
+			defer snap.Close()
+
+			batch := s.NewBatch()
+			batch.DeleteRange([]byte("b"), []byte("d"))
+			batch.Set([]byte("e"), []byte("e"))
+			if err := batch.Commit(); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			batch.Close()
+			s.Delete([]byte("a"))
+			s.Put([]byte("d"), []byte("D"))
+
+			if got := collect(t, mustScan(t, s, nil)); strings.Join(got, ",") != "d=D,e=e" {
+				t.Errorf("Scan() = %v", got)
+			}
+			if value, ok, _ := snap.Get([]byte("b")); !ok || string(value) != "b" {
+				t.Errorf("snapshot Get(b) = %q, %v", value, ok)
+			}
+
+			iter, err := snap.NewIter(IterOptions{
+				LowerBound: []byte("a"),
+				UpperBound: []byte("d"),
+				SkipPoint:  func(key []byte) bool { return string(key) == "b" },
+			})
+			if err != nil {
+				t.Fatalf("NewIter() error = %v", err)
+			}
+			defer iter.Close()
+			var keys []string
+			for valid := iter.Last(); valid; valid = iter.Prev() {
+				keys = append(keys, string(iter.Key()))
+			}
+			if strings.Join(keys, ",") != "c,a" {
+				t.Errorf("snapshot keys in reverse = %v, want c,a", keys)
+			}
+			if !iter.SeekGE([]byte("b")) || string(iter.Key()) != "c" {
+				t.Errorf("SeekGE(b) at %q, want c", iter.Key())
+			}
+			if iter.Next() {
+				t.Errorf("Next() past the upper bound at %q", iter.Key())
+			}
+		})
+	}
+
+	if _, err := New(Config{Engine: "tape"}); err == nil {
+		t.Error("New() with an unknown engine succeeded")
+	}
+}
+
+func TestStore_Closed(t *testing.T) {
+	s := newTestStore(t)
+	s.Close()
+
+	if _, err := s.Get([]byte("a")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() error = %v, want ErrClosed", err)
+	}
+	if err := s.Put([]byte("a"), nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put() error = %v, want ErrClosed", err)
+	}
+	if _, err := s.Scan(nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Scan() error = %v, want ErrClosed", err)
+	}
+	if err := s.Begin().Put([]byte("a"), nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Txn.Put() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestStore_ConcurrentWrites(t *testing.T) {
+	s := newTestStore(t)
+	const writers, keys = 8, 50
+
+	// Writers clear TTLs that are due while the sweeper runs; no write may
+	// be lost to a sweep of the old TTL
+	for i := 0; i < keys; i++ {
+		key := []byte(fmt.Sprintf("k%d", i))
+		s.PutWithTTL(key, []byte("old"), time.Nanosecond)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += writers {
+				if err := s.Put([]byte(fmt.Sprintf("k%d", i)), []byte("new")); err != nil {
+					t.Errorf("Put() error = %v", err)
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Sweep(time.Now()); err != nil {
+			t.Fatalf("Sweep() error = %v", err)
+		}
+	}
+	wg.Wait()
+	s.Sweep(time.Now().Add(time.Hour))
+
+	for i := 0; i < keys; i++ {
+		if got, _ := s.Get([]byte(fmt.Sprintf("k%d", i))); string(got) != "new" {
+			t.Errorf("Get(k%d) = %q, want new", i, got)
+		}
+	}
+}
+
+func BenchmarkStore_Put(b *testing.B) {
+	s, err := New(Config{Path: b.TempDir()})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	value := make([]byte, 128)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Put([]byte(fmt.Sprintf("key/%d", i)), value); err != nil {
+			b.Fatalf("Put() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkStore_PutParallel measures concurrent writers, whose synced
+// commits pebble groups together when the store lets them overlap
+func BenchmarkStore_PutParallel(b *testing.B) {
+	s, err := New(Config{Path: b.TempDir()})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	value := make([]byte, 128)
+	var next atomic.Int64
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := s.Put([]byte(fmt.Sprintf("key/%d", next.Add(1))), value); err != nil {
+				b.Errorf("Put() error = %v", err)
+				return
+			}
+		}
+	})
+}
+
+// testRecorder collects what a store reports
+type testRecorder struct {
+	ops     []string
+	batches []string
+	mu      sync.Mutex
+}
+
+func (r *testRecorder) RecordOp(op string, latency time.Duration, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, fmt.Sprintf("%s failed=%v", op, failed))
+}
+
+func (r *testRecorder) RecordBatch(op string, writes, bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, fmt.Sprintf("%s %d/%d", op, writes, bytes))
+}
+
+func TestStore_Recorder(t *testing.T) {
+	s := newTestStore(t)
+	r := &testRecorder{}
+	s.SetRecorder(r)
+
+	s.Put([]byte("a"), []byte("1"))
+	s.Get([]byte("a"))
+	s.PutWithTTL([]byte("b"), []byte("2"), 0)
+	batch := s.NewBatch()
+	batch.Set([]byte("c"), []byte("33"))
+	batch.Delete([]byte("a"))
+	batch.Commit()
+	batch.Close()
+	s.Update(func(txn *Txn) error { return txn.Put([]byte("d"), []byte("4")) })
+
+	wantOps := []string{"put failed=false", "get failed=false", "put_ttl failed=true", "batch failed=false", "txn failed=false"}
+	if strings.Join(r.ops, ",") != strings.Join(wantOps, ",") {
+		t.Errorf("ops = %v, want %v", r.ops, wantOps)
+	}
+	wantBatches := []string{"batch 2/4", "txn 1/2"}
+	if strings.Join(r.batches, ",") != strings.Join(wantBatches, ",") {
+		t.Errorf("batches = %v, want %v", r.batches, wantBatches)
+	}
+
+	stats, ok := s.EngineStats()
+	if !ok || len(stats.Levels) == 0 {
+		t.Errorf("EngineStats() = %+v, %v; want pebble levels", stats, ok)
+	}
+}
+
+func TestStore_Increment(t *testing.T) {
+	s := newTestStore(t)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := s.Increment([]byte("hits"), 2); err != nil {
+					t.Errorf("Increment() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, err := s.Counter([]byte("hits")); err != nil || n != 400 {
+		t.Errorf("Counter() = %d, %v; want 400", n, err)
+	}
+	if n, _ := s.Increment([]byte("hits"), -401); n != -1 {
+		t.Errorf("Increment(-401) = %d, want -1", n)
+	}
+
+	// A counter's TTL survives increments and resets it once expired
+	s.PutWithTTL([]byte("window"), EncodeCounter(5), time.Hour)
+	if n, _ := s.Increment([]byte("window"), 1); n != 6 {
+		t.Errorf("Increment() in window = %d, want 6", n)
+	}
+	if ok, _ := s.deadlineOf([]byte("window")); !ok {
+		t.Error("Increment() cleared a live TTL")
+	}
+	s.PutWithTTL([]byte("expired"), EncodeCounter(5), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if n, _ := s.Increment([]byte("expired"), 1); n != 1 {
+		t.Errorf("Increment() of an expired counter = %d, want 1", n)
+	}
+	if n, _ := s.Sweep(time.Now()); n != 0 {
+		t.Errorf("Sweep() removed %d keys, want the incremented counter kept", n)
+	}
+
+	s.Put([]byte("name"), []byte("text"))
+	if _, err := s.Increment([]byte("name"), 1); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Increment() of text error = %v, want ErrNotCounter", err)
+	}
+	if _, err := s.Merge([]byte("name"), []byte("!")); err == nil {
+		t.Error("Merge() without an operator succeeded")
+	}
+}
+
+func TestStore_Merge(t *testing.T) {
+	s, err := New(Config{
+		Path: t.TempDir(),
+		Merge: func(key, value, operand []byte) ([]byte, error) {
+			return append(append([]byte{}, value...), operand...), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	for _, op := range []string{"a", "b", "c"} {
+		if _, err := s.Bucket("log").Merge([]byte("k"), []byte(op)); err != nil {
+			t.Fatalf("Merge() error = %v", err)
+		}
+	}
+	if got, _ := s.Bucket("log").Get([]byte("k")); string(got) != "abc" {
+		t.Errorf("Get() = %q, want abc", got)
+	}
+}
+
+func TestStore_Durability(t *testing.T) {
+	s, err := New(Config{Path: t.TempDir(), Durability: DurabilityGroup, GroupCommitLatency: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+	var syncs atomic.Int64
+	s.group.sync = func() error {
+		syncs.Add(1)
+		return s.engine.Sync()
+	}
+
+	// Writes waiting together share a sync
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Put([]byte(fmt.Sprintf("k%d", w)), []byte("v")); err != nil {
+				t.Errorf("Put() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := syncs.Load(); n < 1 || n >= 8 {
+		t.Errorf("group commit synced %d times for 8 writes", n)
+	}
+
+	// Writes made otherwise skip the group's syncs
+	syncs.Store(0)
+	s.Put([]byte("a"), []byte("1"), WithDurability(DurabilitySync))
+	s.Bucket("log").WithDurability(DurabilityAsync).Put([]byte("b"), []byte("2"))
+	batch := s.NewBatch()
+	batch.Set([]byte("c"), []byte("3"))
+	batch.Commit(WithDurability(DurabilityAsync))
+	batch.Close()
+	if n := syncs.Load(); n != 0 {
+		t.Errorf("sync and async writes waited on %d group syncs", n)
+	}
+	if got, _ := s.Bucket("log").Get([]byte("b")); string(got) != "2" {
+		t.Errorf("Get() = %q, want 2", got)
+	}
+
+	for _, d := range []Durability{DurabilitySync, DurabilityAsync, DurabilityGroup} {
+		if got, err := ParseDurability(d.String()); err != nil || got != d {
+			t.Errorf("ParseDurability(%q) = %v, %v", d, got, err)
+		}
+	}
+	if _, err := ParseDurability("fast"); err == nil {
+		t.Error("ParseDurability(fast) succeeded")
+	}
+}
+
+// deadlineOf reports whether key has a TTL
+func (s *Store) deadlineOf(key []byte) (bool, error) {
+	_, ok, err := deadline(s.engine, key)
+	return ok, err
+}
@@ -0,0 +1,222 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// DefaultSweepInterval is how often expired keys are removed
+const DefaultSweepInterval = time.Second
+
+// Keys starting with reservedPrefix hold the store's own bookkeeping. A
+// key with a TTL has its deadline under expiresPrefix and an entry under
+// expiryPrefix, ordered by deadline, that the sweeper walks.
+const (
+	reservedPrefix = "\x00kv/"
+	expiresPrefix  = reservedPrefix + "expires/" // + key -> big-endian deadline
+	expiryPrefix   = reservedPrefix + "expiry/"  // + big-endian deadline + key
+)
+
+// PutWithTTL stores a key-value pair that expires after ttl. Expired keys
+// read as missing and are deleted by the sweeper. A later Put or Delete of
+// the key clears its TTL.
+func (s *Store) PutWithTTL(key, value []byte, ttl time.Duration, opts ...WriteOption) (err error) {
+	defer s.observe(OpPutTTL, time.Now(), &err)
+	if ttl <= 0 {
+		return fmt.Errorf("invalid TTL %v", ttl)
+	}
+	deadline := uint64(time.Now().Add(ttl).UnixNano())
+
+	if err := s.acquireWrite(); err != nil {
+		return err
+	}
+	defer s.release()
+	d := s.durability(opts)
+	defer s.settle(d, &err)
+	defer s.keyLocks.lock(key)()
+
+	// Set before the commit so no reader misses the deadline
+	s.hasTTL.Store(true)
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	if err := s.index(batch, key, value, false); err != nil {
+		return err
+	}
+	if err := batch.Set(key, value); err != nil {
+		return fmt.Errorf("failed to set key: %w", err)
+	}
+	if err := batch.Set(expiresKey(key), binary.BigEndian.AppendUint64(nil, deadline)); err != nil {
+		return fmt.Errorf("failed to set key expiry: %w", err)
+	}
+	if err := batch.Set(expiryKey(deadline, key), nil); err != nil {
+		return fmt.Errorf("failed to set key expiry: %w", err)
+	}
+	if err := s.commit(batch, d); err != nil {
+		return fmt.Errorf("failed to set key: %w", err)
+	}
+	s.announce(ChangePut, key, value)
+	return nil
+}
+
+// Sweep deletes the keys that expired by now and returns how many it
+// deleted. The store sweeps every SweepInterval on its own.
+func (s *Store) Sweep(now time.Time) (swept int, err error) {
+	if !s.hasTTL.Load() {
+		return 0, nil
+	}
+	defer s.observe(OpSweep, time.Now(), &err)
+
+	if err := s.acquireWrite(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	entries, err := s.dueExpiries(now)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	keys := make([][]byte, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	// Writers of the keys wait so none is lost to a stale deadline
+	defer s.keyLocks.lock(keys...)()
+
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	var expired [][]byte
+	size := 0
+	for _, e := range entries {
+		if err := batch.Delete(expiryKey(e.at, e.key)); err != nil {
+			return 0, fmt.Errorf("failed to delete key expiry: %w", err)
+		}
+		// An entry of a TTL since replaced or cleared leaves the key alone
+		current, ok, err := deadline(s.engine, e.key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || current != e.at {
+			continue
+		}
+		if err := s.index(batch, e.key, nil, true); err != nil {
+			return 0, err
+		}
+		if err := batch.Delete(e.key); err != nil {
+			return 0, fmt.Errorf("failed to delete key: %w", err)
+		}
+		if err := batch.Delete(expiresKey(e.key)); err != nil {
+			return 0, fmt.Errorf("failed to delete key expiry: %w", err)
+		}
+		expired = append(expired, e.key)
+		size += len(e.key)
+	}
+	if batch.Empty() {
+		return 0, nil
+	}
+	// Expired keys are swept again if the deletion is lost in a crash
+	if err := s.commit(batch, DurabilityAsync); err != nil {
+		return 0, fmt.Errorf("failed to sweep expired keys: %w", err)
+	}
+	s.recordBatch(OpSweep, len(entries)+2*len(expired), size)
+	for _, key := range expired {
+		s.announce(ChangeDelete, key, nil)
+	}
+	return len(expired), nil
+}
+
+// expiry is a sweeper entry: a key and the deadline it was given
+type expiry struct {
+	key []byte
+	at  uint64
+}
+
+// dueExpiries returns the sweeper entries with deadlines by now
+func (s *Store) dueExpiries(now time.Time) ([]expiry, error) {
+	iter, err := s.engine.NewIter(IterOptions{
+		LowerBound: []byte(expiryPrefix),
+		UpperBound: expiryKey(uint64(now.UnixNano())+1, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var entries []expiry
+	for valid := iter.First(); valid; valid = iter.Next() {
+		entry := iter.Key()
+		entries = append(entries, expiry{
+			key: append([]byte(nil), entry[len(expiryPrefix)+8:]...),
+			at:  binary.BigEndian.Uint64(entry[len(expiryPrefix):]),
+		})
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to sweep expired keys: %w", err)
+	}
+	return entries, nil
+}
+
+// expired reports whether key has a TTL that has passed, as seen by r
+func (s *Store) expired(r Reader, key []byte) (bool, error) {
+	if !s.hasTTL.Load() {
+		return false, nil
+	}
+	at, ok, err := deadline(r, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return at <= uint64(time.Now().UnixNano()), nil
+}
+
+// deadline returns when key expires, as seen by r, if it has a TTL
+func deadline(r Reader, key []byte) (uint64, bool, error) {
+	value, ok, err := r.Get(expiresKey(key))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get key expiry: %w", err)
+	}
+	if !ok || len(value) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(value), true, nil
+}
+
+// sweeper sweeps expired keys every interval until the store closes
+func (s *Store) sweeper(interval time.Duration) {
+	defer close(s.swept)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if _, err := s.Sweep(now); err != nil {
+				fmt.Printf("Warning: failed to sweep expired keys: %v\n", err)
+			}
+		}
+	}
+}
+
+// hasExpiries reports whether the store holds any key with a TTL
+func hasExpiries(r Reader) (bool, error) {
+	iter, err := r.NewIter(IterOptions{
+		LowerBound: []byte(expiresPrefix),
+		UpperBound: PrefixEnd([]byte(expiresPrefix)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+	found := iter.First()
+	return found, iter.Error()
+}
+
+// expiresKey returns the key holding the deadline of key
+func expiresKey(key []byte) []byte {
+	return append([]byte(expiresPrefix), key...)
+}
+
+// expiryKey returns the sweeper's entry for key expiring at deadline
+func expiryKey(deadline uint64, key []byte) []byte {
+	return append(binary.BigEndian.AppendUint64([]byte(expiryPrefix), deadline), key...)
+}
@@ -0,0 +1,244 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultTxnRetries is how many times Update reruns a conflicting
+// transaction
+const DefaultTxnRetries = 3
+
+var (
+	// ErrConflict is returned by Commit when a key the transaction read
+	// changed before it committed
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxnDone is returned for operations on a committed or discarded
+	// transaction
+	ErrTxnDone = errors.New("transaction already committed or discarded")
+)
+
+// Txn is an optimistic transaction. It reads from a snapshot taken when it
+// began, overlaid with its own writes, and buffers writes until Commit
+// applies them atomically, clearing any TTL of the keys written. Commit
+// fails with ErrConflict when a key the transaction read was changed in the
+// meantime by another transaction, Put, PutWithTTL, or Delete.
+type Txn struct {
+	store  *Store
+	snap   Snapshot          // nil when the store was closed at Begin
+	reads  map[string][]byte // Values read from the snapshot; nil if absent
+	writes map[string]txnWrite
+	done   bool
+	mu     sync.Mutex
+}
+
+// txnWrite is a buffered write
+type txnWrite struct {
+	value  []byte
+	delete bool
+}
+
+// Begin starts a transaction. Commit or Discard it to release its
+// snapshot. A transaction begun on a closed store fails with ErrClosed.
+func (s *Store) Begin() *Txn {
+	if err := s.acquire(); err != nil {
+		return &Txn{store: s, done: true}
+	}
+	defer s.release()
+
+	return &Txn{
+		store:  s,
+		snap:   s.engine.NewSnapshot(),
+		reads:  make(map[string][]byte),
+		writes: make(map[string]txnWrite),
+	}
+}
+
+// Update runs fn in a transaction and commits it with opts, rerunning fn
+// on conflict up to DefaultTxnRetries times. The transaction is discarded
+// when fn returns an error.
+func (s *Store) Update(fn func(*Txn) error, opts ...WriteOption) error {
+	var err error
+	for attempt := 0; attempt <= DefaultTxnRetries; attempt++ {
+		txn := s.Begin()
+		if err = fn(txn); err != nil {
+			txn.Discard()
+			return err
+		}
+		if err = txn.Commit(opts...); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// Get retrieves a value by key, seeing the transaction's own writes. It
+// returns nil for missing keys.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+
+	if w, ok := t.writes[string(key)]; ok {
+		if w.delete {
+			return nil, nil
+		}
+		return append([]byte(nil), w.value...), nil
+	}
+	if value, ok := t.reads[string(key)]; ok {
+		return append([]byte(nil), value...), nil
+	}
+
+	expired, err := t.store.expired(t.snap, key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok, err := t.snap.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+	if !ok || expired {
+		t.reads[string(key)] = nil
+		return nil, nil
+	}
+	t.reads[string(key)] = value
+	return append([]byte(nil), value...), nil
+}
+
+// Put buffers a write of a key-value pair
+func (t *Txn) Put(key, value []byte) error {
+	return t.write(key, txnWrite{value: append([]byte{}, value...)})
+}
+
+// Delete buffers the removal of a key
+func (t *Txn) Delete(key []byte) error {
+	return t.write(key, txnWrite{delete: true})
+}
+
+// write buffers a write
+func (t *Txn) write(key []byte, w txnWrite) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.usable(); err != nil {
+		return err
+	}
+	t.writes[string(key)] = w
+	return nil
+}
+
+// Commit checks that the keys the transaction read are unchanged and
+// applies its writes atomically, durably unless opts say otherwise. The
+// transaction is finished either way.
+func (t *Txn) Commit(opts ...WriteOption) (err error) {
+	defer t.store.observe(OpTxn, time.Now(), &err)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.usable(); err != nil {
+		return err
+	}
+	t.finish()
+
+	s := t.store
+	if len(t.writes) > 0 && s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+	d := s.durability(opts)
+	defer s.settle(d, &err)
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+
+	// Writers of the keys read or written wait until the commit is done
+	keys := make([][]byte, 0, len(t.reads)+len(t.writes))
+	for key := range t.reads {
+		keys = append(keys, []byte(key))
+	}
+	for key := range t.writes {
+		keys = append(keys, []byte(key))
+	}
+	defer s.keyLocks.lock(keys...)()
+
+	for key, read := range t.reads {
+		current, err := s.get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if (current == nil) != (read == nil) || !bytes.Equal(current, read) {
+			return fmt.Errorf("%w on key %q", ErrConflict, key)
+		}
+	}
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	batch := s.engine.NewBatch()
+	defer batch.Close()
+	size := 0
+	for key, w := range t.writes {
+		size += len(key) + len(w.value)
+		if err := s.index(batch, []byte(key), w.value, w.delete); err != nil {
+			return err
+		}
+		var err error
+		if w.delete {
+			err = batch.Delete([]byte(key))
+		} else {
+			err = batch.Set([]byte(key), w.value)
+		}
+		if err == nil && s.hasTTL.Load() {
+			err = batch.Delete(expiresKey([]byte(key)))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write transaction: %w", err)
+		}
+	}
+	if err := s.commit(batch, d); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.recordBatch(OpTxn, len(t.writes), size)
+	for key, w := range t.writes {
+		if w.delete {
+			s.announce(ChangeDelete, []byte(key), nil)
+		} else {
+			s.announce(ChangePut, []byte(key), w.value)
+		}
+	}
+	return nil
+}
+
+// Discard abandons the transaction's writes. Discarding a finished
+// transaction does nothing.
+func (t *Txn) Discard() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.finish()
+	}
+}
+
+// usable returns why the transaction can't be used, if it can't. mu must
+// be held.
+func (t *Txn) usable() error {
+	switch {
+	case t.done && t.snap == nil:
+		return ErrClosed
+	case t.done:
+		return ErrTxnDone
+	}
+	return nil
+}
+
+// finish marks the transaction done and releases its snapshot. mu must be
+// held.
+func (t *Txn) finish() {
+	t.done = true
+	t.snap.Close()
+}
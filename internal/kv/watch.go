@@ -0,0 +1,119 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// Change types
+const (
+	ChangePut    = "put"
+	ChangeDelete = "delete"
+)
+
+// Change is a write seen by a watcher. Value is nil for deletes.
+type Change struct {
+	Type  string
+	Key   []byte
+	Value []byte
+}
+
+// watchers are the subscribers to the store's changes
+type watchers struct {
+	subs   map[*watcher]struct{}
+	closed bool
+	mu     sync.RWMutex
+}
+
+// watcher is a subscriber to changes of keys with a prefix
+type watcher struct {
+	prefix []byte
+	ch     chan Change
+}
+
+// Watch returns a channel of the changes to keys with prefix made by Put,
+// PutWithTTL, Delete, transactions, and expiry, until ctx ends or the
+// store closes. Writes through NewBatch are not seen. Changes are dropped
+// for a watcher that falls behind rather than blocking writers.
+func (s *Store) Watch(ctx context.Context, prefix []byte) <-chan Change {
+	w := &watcher{prefix: append([]byte(nil), prefix...), ch: make(chan Change, 100)}
+
+	s.watchers.mu.Lock()
+	if s.watchers.closed {
+		s.watchers.mu.Unlock()
+		close(w.ch)
+		return w.ch
+	}
+	if s.watchers.subs == nil {
+		s.watchers.subs = make(map[*watcher]struct{})
+	}
+	s.watchers.subs[w] = struct{}{}
+	s.watchers.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+		}
+		s.watchers.mu.Lock()
+		defer s.watchers.mu.Unlock()
+		if _, ok := s.watchers.subs[w]; ok {
+			delete(s.watchers.subs, w)
+			close(w.ch)
+		}
+	}()
+	return w.ch
+}
+
+// notify hands committed changes to the watchers of their keys
+func (s *Store) notify(changes ...Change) {
+	s.watchers.mu.RLock()
+	defer s.watchers.mu.RUnlock()
+	if len(s.watchers.subs) == 0 {
+		return
+	}
+	for _, c := range changes {
+		for w := range s.watchers.subs {
+			if !bytes.HasPrefix(c.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.ch <- c:
+			default:
+				// Watcher is behind, skip to avoid blocking the write
+			}
+		}
+	}
+}
+
+// announce hands a committed write to the watchers of its key
+func (s *Store) announce(typ string, key, value []byte) {
+	if !s.watching() {
+		return
+	}
+	c := Change{Type: typ, Key: append([]byte(nil), key...)}
+	if typ == ChangePut {
+		c.Value = append([]byte{}, value...)
+	}
+	s.notify(c)
+}
+
+// watching reports whether any watcher is subscribed, so writers can skip
+// copying changes nobody sees
+func (s *Store) watching() bool {
+	s.watchers.mu.RLock()
+	defer s.watchers.mu.RUnlock()
+	return len(s.watchers.subs) > 0
+}
+
+// closeWatchers ends every watch
+func (s *Store) closeWatchers() {
+	s.watchers.mu.Lock()
+	defer s.watchers.mu.Unlock()
+	s.watchers.closed = true
+	for w := range s.watchers.subs {
+		delete(s.watchers.subs, w)
+		close(w.ch)
+	}
+}
@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"errors"
+	"sync"
+)
+
+// Default thresholds at which a WriteBatch flushes
+const (
+	DefaultWriteBatchBytes  = 4 << 20
+	DefaultWriteBatchWrites = 10000
+)
+
+// WriteBatchOptions configure a WriteBatch
+type WriteBatchOptions struct {
+	MaxBytes  int           // Bytes buffered before flushing; 0 uses DefaultWriteBatchBytes
+	MaxWrites int           // Writes buffered before flushing; 0 uses DefaultWriteBatchWrites
+	Write     []WriteOption // Options of each flush's commit
+}
+
+// WriteBatch buffers bulk writes and commits them in batches, flushing
+// whenever a threshold is reached. Each flush is atomic, but the writes as
+// a whole are not. A failed flush drops its writes and the batch carries
+// on; Close reports every error. Like Batch, it keeps the TTLs of written
+// keys, is not seen by watchers, and does not update indexes.
+type WriteBatch struct {
+	store   *Store
+	opts    WriteBatchOptions
+	batch   *Batch
+	flushed int // Writes committed by successful flushes
+	errs    []error
+	mu      sync.Mutex
+}
+
+// WriteBatch returns a WriteBatch writing to the store
+func (s *Store) WriteBatch(opts WriteBatchOptions) *WriteBatch {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultWriteBatchBytes
+	}
+	if opts.MaxWrites <= 0 {
+		opts.MaxWrites = DefaultWriteBatchWrites
+	}
+	return &WriteBatch{store: s, opts: opts}
+}
+
+// Set buffers a write of a key-value pair. It returns the error of the
+// flush it triggers, if any.
+func (w *WriteBatch) Set(key, value []byte) error {
+	return w.write(func(b *Batch) error { return b.Set(key, value) })
+}
+
+// Delete buffers a deletion of a key. It returns the error of the flush it
+// triggers, if any.
+func (w *WriteBatch) Delete(key []byte) error {
+	return w.write(func(b *Batch) error { return b.Delete(key) })
+}
+
+// write applies fn to the current batch and flushes it if full
+func (w *WriteBatch) write(fn func(*Batch) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.batch == nil {
+		w.batch = w.store.NewBatch()
+	}
+	if err := fn(w.batch); err != nil {
+		w.errs = append(w.errs, err)
+		return err
+	}
+	if w.batch.bytes < w.opts.MaxBytes && w.batch.writes < w.opts.MaxWrites {
+		return nil
+	}
+	return w.flush()
+}
+
+// Flush commits the buffered writes
+func (w *WriteBatch) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// flush commits the current batch. w.mu must be held.
+func (w *WriteBatch) flush() error {
+	if w.batch == nil {
+		return nil
+	}
+	batch := w.batch
+	w.batch = nil
+	defer batch.Close()
+	if batch.Empty() {
+		return nil
+	}
+	if err := batch.Commit(w.opts.Write...); err != nil {
+		w.errs = append(w.errs, err)
+		return err
+	}
+	w.flushed += batch.writes
+	return nil
+}
+
+// Written returns how many writes successful flushes committed
+func (w *WriteBatch) Written() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushed
+}
+
+// Err returns every error the batch has met, joined
+func (w *WriteBatch) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return errors.Join(w.errs...)
+}
+
+// Close flushes the buffered writes and returns every error the batch has
+// met, joined
+func (w *WriteBatch) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+	return errors.Join(w.errs...)
+}
@@ -0,0 +1,191 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrAgentNotFound is returned for operations on unknown agents
+	ErrAgentNotFound = errors.New("matrix agent not found")
+	// ErrVersionConflict is returned by UpdateAgentState when the agent's
+	// state changed since the caller read it
+	ErrVersionConflict = errors.New("agent state version conflict")
+)
+
+// DefaultAgentPageSize is the page size of ListAgents when no limit is set
+const DefaultAgentPageSize = 100
+
+// AgentStatus filters agents by whether they take part in steps
+type AgentStatus string
+
+const (
+	// AgentStatusAny matches every agent
+	AgentStatusAny AgentStatus = ""
+	// AgentStatusEnabled matches agents rules apply to
+	AgentStatusEnabled AgentStatus = "enabled"
+	// AgentStatusDisabled matches agents excluded from steps
+	AgentStatusDisabled AgentStatus = "disabled"
+)
+
+// AgentFilters selects a page of agents for ListAgents
+type AgentFilters struct {
+	Type   string      // Exact agent type; empty matches all
+	Status AgentStatus // Enabled or disabled agents; empty matches all
+	Where  string      // Rule expression over the agent, such as "state.energy > 0"; agents it fails on are skipped
+	After  string      // Return agents with IDs after this cursor
+	Limit  int         // Page size; 0 uses DefaultAgentPageSize
+}
+
+// Version returns the number of state changes applied to the agent
+func (a *MatrixAgent) Version() uint64 {
+	a.stateMu.RLock()
+	defer a.stateMu.RUnlock()
+	return a.version
+}
+
+// Enabled reports whether rules apply to the agent
+func (a *MatrixAgent) Enabled() bool {
+	a.stateMu.RLock()
+	defer a.stateMu.RUnlock()
+	return !a.disabled
+}
+
+// setLocked assigns state keys and bumps the version. Callers must hold
+// stateMu.
+func (a *MatrixAgent) setLocked(changes map[string]interface{}) {
+	if a.State == nil {
+		a.State = make(map[string]interface{}, len(changes))
+	}
+	for k, v := range changes {
+		a.State[k] = v
+	}
+	a.version++
+}
+
+// RemoveAgent removes an agent from the matrix
+func (m *Matrix) RemoveAgent(id string) error {
+	m.agentMu.Lock()
+	defer m.agentMu.Unlock()
+
+	if _, exists := m.agents[id]; !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+	if err := m.journalEvent(Event{Type: EventAgentRemoved, Timestamp: time.Now(), AgentID: id}); err != nil {
+		return fmt.Errorf("failed to journal agent %s: %w", id, err)
+	}
+	delete(m.agents, id)
+	m.unlocate(id)
+	return nil
+}
+
+// SetAgentEnabled includes or excludes an agent from later steps. Events
+// rules emit for a disabled agent are dropped.
+func (m *Matrix) SetAgentEnabled(id string, enabled bool) error {
+	agent, exists := m.GetAgent(id)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+
+	agent.stateMu.Lock()
+	defer agent.stateMu.Unlock()
+	if agent.disabled == !enabled {
+		return nil
+	}
+
+	eventType := EventAgentEnabled
+	if !enabled {
+		eventType = EventAgentDisabled
+	}
+	if err := m.journalEvent(Event{Type: eventType, Timestamp: time.Now(), AgentID: id}); err != nil {
+		return fmt.Errorf("failed to journal agent %s: %w", id, err)
+	}
+	agent.disabled = !enabled
+	return nil
+}
+
+// UpdateAgentState sets state keys if the agent is still at version,
+// returning the new version. It fails with ErrVersionConflict otherwise.
+func (m *Matrix) UpdateAgentState(id string, version uint64, changes map[string]interface{}) (uint64, error) {
+	agent, exists := m.GetAgent(id)
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+
+	agent.stateMu.Lock()
+	defer agent.stateMu.Unlock()
+	if agent.version != version {
+		return agent.version, fmt.Errorf("%w: agent %s is at version %d, not %d", ErrVersionConflict, id, agent.version, version)
+	}
+
+	if err := m.checkChange(agent, changes); err != nil {
+		return version, err
+	}
+
+	event := Event{Type: EventStateChanged, Timestamp: time.Now(), AgentID: id, Data: changes}
+	if err := m.journalEvent(event); err != nil {
+		return version, fmt.Errorf("failed to journal agent %s: %w", id, err)
+	}
+	agent.setLocked(changes)
+	m.locate(agent)
+	return agent.version, nil
+}
+
+// ListAgents returns a page of agents ordered by ID, and the cursor for
+// the next page, which is empty after the last page
+func (m *Matrix) ListAgents(filters AgentFilters) ([]*MatrixAgent, string, error) {
+	var where *expr
+	if filters.Where != "" {
+		var err error
+		if where, err = compileExpr(filters.Where); err != nil {
+			return nil, "", err
+		}
+	}
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = DefaultAgentPageSize
+	}
+
+	m.agentMu.RLock()
+	candidates := make([]*MatrixAgent, 0, len(m.agents))
+	for id, agent := range m.agents {
+		if id > filters.After && (filters.Type == "" || agent.Type == filters.Type) {
+			candidates = append(candidates, agent)
+		}
+	}
+	m.agentMu.RUnlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	var page []*MatrixAgent
+	for _, agent := range candidates {
+		agent.stateMu.RLock()
+		disabled := agent.disabled
+		env := exprEnv{id: agent.ID, typ: agent.Type, state: copyState(agent.State), step: m.steps.Load(), m: m}
+		agent.stateMu.RUnlock()
+
+		switch {
+		case filters.Status == AgentStatusEnabled && disabled,
+			filters.Status == AgentStatusDisabled && !disabled:
+			continue
+		}
+		if ok, err := holds(where, env); err != nil || !ok {
+			continue
+		}
+
+		if len(page) == limit {
+			return page, page[len(page)-1].ID, nil
+		}
+		page = append(page, agent)
+	}
+	return page, "", nil
+}
+
+// agentDisabled reports whether an agent exists and is disabled
+func (m *Matrix) agentDisabled(id string) bool {
+	agent, exists := m.GetAgent(id)
+	return exists && !agent.Enabled()
+}
@@ -0,0 +1,136 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+)
+
+// BindingRulePrefix prefixes the IDs of rules created by BindAgent
+const BindingRulePrefix = "agent:"
+
+// AgentRuntime executes a matrix agent's behavior. *agent.Agent implements
+// it through its on_matrix_tick export.
+type AgentRuntime interface {
+	MatrixTick(ctx context.Context, step uint64, state []byte) ([]byte, error)
+}
+
+var _ AgentRuntime = (*agent.Agent)(nil)
+
+// TickInput is the JSON a bound agent receives each step. Seed is drawn
+// from the binding rule's random source for agents that need randomness.
+type TickInput struct {
+	Step  uint64                 `json:"step"`
+	Seed  int64                  `json:"seed"`
+	ID    string                 `json:"id"`
+	Type  string                 `json:"type"`
+	State map[string]interface{} `json:"state"`
+}
+
+// TickOutput is the JSON a bound agent may return: state keys to set on
+// itself and events to emit. Events default to the bound agent's ID.
+type TickOutput struct {
+	State  map[string]interface{} `json:"state,omitempty"`
+	Events []TickEvent            `json:"events,omitempty"`
+}
+
+// TickEvent is an event emitted by a bound agent
+type TickEvent struct {
+	Type    string                 `json:"type"`
+	AgentID string                 `json:"agent_id,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// BindAgent makes a runtime drive a matrix agent. Each step the runtime is
+// ticked with the agent's state through a rule with the given priority, so
+// its output is applied, journaled, and ordered like any rule's events.
+// Disabled agents are not ticked.
+func (m *Matrix) BindAgent(id string, runtime AgentRuntime, priority int) error {
+	if _, exists := m.GetAgent(id); !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+
+	ruleID := BindingRulePrefix + id
+	m.rulesMu.RLock()
+	for _, rule := range m.rules {
+		if rule.ID == ruleID {
+			m.rulesMu.RUnlock()
+			return fmt.Errorf("agent %s is already bound", id)
+		}
+	}
+	m.rulesMu.RUnlock()
+
+	m.AddRule(Rule{
+		ID:       ruleID,
+		Priority: priority,
+		Evaluate: func(ctx context.Context, m *Matrix) ([]Event, error) {
+			return m.tickBound(ctx, id, runtime)
+		},
+	})
+	return nil
+}
+
+// UnbindAgent stops ticking an agent's runtime
+func (m *Matrix) UnbindAgent(id string) {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+	for i, rule := range m.rules {
+		if rule.ID == BindingRulePrefix+id {
+			m.rules = append(m.rules[:i:i], m.rules[i+1:]...)
+			m.rulesVersion++
+			return
+		}
+	}
+}
+
+// tickBound ticks a bound agent and converts its output to events
+func (m *Matrix) tickBound(ctx context.Context, id string, runtime AgentRuntime) ([]Event, error) {
+	a, exists := m.GetAgent(id)
+	if !exists {
+		return nil, nil
+	}
+
+	a.stateMu.RLock()
+	if a.disabled {
+		a.stateMu.RUnlock()
+		return nil, nil
+	}
+	step := m.steps.Load()
+	input, err := json.Marshal(TickInput{Step: step, Seed: Rand(ctx).Int63(), ID: a.ID, Type: a.Type, State: a.State})
+	a.stateMu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state of agent %s: %w", id, err)
+	}
+
+	raw, err := runtime.MatrixTick(ctx, step, input)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s tick failed: %w", id, err)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var output TickOutput
+	if err := json.Unmarshal(raw, &output); err != nil {
+		return nil, fmt.Errorf("agent %s returned invalid output: %w", id, err)
+	}
+
+	now := time.Now()
+	events := make([]Event, 0, len(output.Events)+1)
+	for _, e := range output.Events {
+		if e.Type == "" {
+			return nil, fmt.Errorf("agent %s emitted an event without a type", id)
+		}
+		target := e.AgentID
+		if target == "" {
+			target = id
+		}
+		events = append(events, Event{Type: e.Type, Timestamp: now, AgentID: target, Data: e.Data})
+	}
+	if len(output.State) > 0 {
+		events = append(events, Event{Type: EventStateChanged, Timestamp: now, AgentID: id, Data: output.State})
+	}
+	return events, nil
+}
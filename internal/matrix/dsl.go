@@ -0,0 +1,259 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleFile is a YAML document of declarative rules:
+//
+//	rules:
+//	  - id: starve
+//	    priority: 10
+//	    match: kind == "prey"
+//	    when: state.energy < 1
+//	    emit:
+//	      - type: starved
+//	        data: {energy: state.energy}
+//	    set:
+//	      alive: "false"
+//
+// A rule is checked against every agent, in ID order. For each agent that
+// satisfies match and when, it emits the listed events and, if set is not
+// empty, a state_changed event assigning the evaluated values. Values are
+// expressions; see expr.go for the syntax.
+type RuleFile struct {
+	Rules []RuleSpec `yaml:"rules"`
+}
+
+// RuleSpec declares a single rule
+type RuleSpec struct {
+	ID        string            `yaml:"id"`
+	Priority  int               `yaml:"priority"`
+	Exclusive bool              `yaml:"exclusive"`
+	Match     string            `yaml:"match"` // Agents the rule applies to; empty matches all
+	When      string            `yaml:"when"`  // Condition over the agent; empty always holds
+	Emit      []EventSpec       `yaml:"emit"`
+	Set       map[string]string `yaml:"set"` // State key to value expression
+}
+
+// EventSpec declares an event emitted by a rule
+type EventSpec struct {
+	Type string            `yaml:"type"`
+	Data map[string]string `yaml:"data"` // Data key to value expression
+}
+
+// compiledEvent is an EventSpec with parsed expressions
+type compiledEvent struct {
+	typ  string
+	data map[string]*expr
+}
+
+// ParseRules decodes a rule file and compiles its rules
+func ParseRules(data []byte) ([]Rule, error) {
+	var file RuleFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	return compileRules(file.Rules)
+}
+
+// compileRules compiles rule declarations, rejecting duplicate IDs
+func compileRules(specs []RuleSpec) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if seen[spec.ID] {
+			return nil, fmt.Errorf("duplicate rule %s", spec.ID)
+		}
+		seen[spec.ID] = true
+
+		rule, err := CompileRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// LoadRules reads and compiles a rule file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	return ParseRules(data)
+}
+
+// CompileRule turns a declarative rule into a Rule
+func CompileRule(spec RuleSpec) (Rule, error) {
+	if spec.ID == "" {
+		return Rule{}, fmt.Errorf("rule id is required")
+	}
+	if len(spec.Emit) == 0 && len(spec.Set) == 0 {
+		return Rule{}, fmt.Errorf("rule %s has neither emit nor set", spec.ID)
+	}
+
+	compile := func(src string) (*expr, error) {
+		if src == "" {
+			return nil, nil
+		}
+		e, err := compileExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", spec.ID, err)
+		}
+		return e, nil
+	}
+	compileMap := func(srcs map[string]string) (map[string]*expr, error) {
+		exprs := make(map[string]*expr, len(srcs))
+		for k, src := range srcs {
+			if src == "" {
+				return nil, fmt.Errorf("rule %s: empty expression for %s", spec.ID, k)
+			}
+			e, err := compile(src)
+			if err != nil {
+				return nil, err
+			}
+			exprs[k] = e
+		}
+		return exprs, nil
+	}
+
+	match, err := compile(spec.Match)
+	if err != nil {
+		return Rule{}, err
+	}
+	when, err := compile(spec.When)
+	if err != nil {
+		return Rule{}, err
+	}
+	set, err := compileMap(spec.Set)
+	if err != nil {
+		return Rule{}, err
+	}
+	emit := make([]compiledEvent, len(spec.Emit))
+	for i, ev := range spec.Emit {
+		if ev.Type == "" {
+			return Rule{}, fmt.Errorf("rule %s: event type is required", spec.ID)
+		}
+		data, err := compileMap(ev.Data)
+		if err != nil {
+			return Rule{}, err
+		}
+		emit[i] = compiledEvent{typ: ev.Type, data: data}
+	}
+
+	evaluate := func(ctx context.Context, m *Matrix) ([]Event, error) {
+		var events []Event
+		rng := Rand(ctx)
+		for _, env := range m.exprEnvs() {
+			env.rng = rng
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			ok, err := holds(match, env)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ok, err = holds(when, env)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			now := time.Now()
+			for _, ev := range emit {
+				data, err := evalMap(ev.data, env)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, Event{Type: ev.typ, Timestamp: now, AgentID: env.id, Data: data})
+			}
+			if len(set) > 0 {
+				data, err := evalMap(set, env)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, Event{Type: EventStateChanged, Timestamp: now, AgentID: env.id, Data: data})
+			}
+		}
+		return events, nil
+	}
+
+	return Rule{
+		ID:        spec.ID,
+		Priority:  spec.Priority,
+		Exclusive: spec.Exclusive,
+		Evaluate:  evaluate,
+	}, nil
+}
+
+// holds evaluates an optional condition; a nil condition always holds
+func holds(cond *expr, env exprEnv) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	return cond.evalBool(env)
+}
+
+// evalMap evaluates each expression in exprs, in key order so random
+// draws are reproducible
+func evalMap(exprs map[string]*expr, env exprEnv) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(exprs))
+	for k := range exprs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]interface{}, len(exprs))
+	for _, k := range keys {
+		v, err := exprs[k].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// exprEnvs returns an expression environment per enabled agent, in ID
+// order. State is copied so rules see the values as of the start of their
+// evaluation.
+func (m *Matrix) exprEnvs() []exprEnv {
+	step := m.steps.Load()
+
+	m.agentMu.RLock()
+	envs := make([]exprEnv, 0, len(m.agents))
+	for _, agent := range m.agents {
+		agent.stateMu.RLock()
+		if agent.disabled {
+			agent.stateMu.RUnlock()
+			continue
+		}
+		envs = append(envs, exprEnv{
+			id:    agent.ID,
+			typ:   agent.Type,
+			state: copyState(agent.State),
+			step:  step,
+			m:     m,
+		})
+		agent.stateMu.RUnlock()
+	}
+	m.agentMu.RUnlock()
+
+	sort.Slice(envs, func(i, j int) bool {
+		return envs[i].id < envs[j].id
+	})
+	return envs
+}
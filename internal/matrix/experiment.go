@@ -0,0 +1,217 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"text/template"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// Experiment runs a scenario once per combination of parameter values.
+// The scenario is a text/template over the parameters, such as
+//
+//	populations:
+//	  - {type: prey, count: {{.prey}}}
+//	rules:
+//	  - {id: grow, when: rand() < {{.growth}}, set: {energy: state.energy + 1}}
+//
+// with Sweep {"prey": [10, 100], "growth": [0.1, 0.5]} giving four
+// parameter sets. Each set runs Repeats times; repeat r adds r to the
+// scenario's seed. The scenario must terminate on its own, through its
+// termination section or Run.
+type Experiment struct {
+	ID          string
+	Scenario    []byte
+	Sweep       map[string][]interface{}
+	Repeats     int       // Runs per parameter set; 0 runs each once
+	Parallelism int       // Matrices run at once; 0 runs one at a time
+	Run         RunConfig // Base run configuration; Mode defaults to RunAsFastAsPossible
+
+	// NewMetrics returns the collector for each run's matrix
+	NewMetrics func(matrixID string) MetricsCollector
+}
+
+// ExperimentRun is the outcome of one matrix in an experiment
+type ExperimentRun struct {
+	Index  int                    `json:"index"`
+	Params map[string]interface{} `json:"params"`
+	Repeat int                    `json:"repeat"`
+	Seed   int64                  `json:"seed"`
+	Result *RunResult             `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// RunExperiment runs every matrix of an experiment and saves each run to
+// the store as it finishes. Runs that fail record their error rather than
+// ending the experiment; RunExperiment fails only if the experiment is
+// invalid, results cannot be saved, or ctx ends.
+func RunExperiment(ctx context.Context, store *kv.Store, exp Experiment) ([]ExperimentRun, error) {
+	if exp.ID == "" {
+		return nil, fmt.Errorf("experiment id is required")
+	}
+	if exp.NewMetrics == nil {
+		return nil, fmt.Errorf("experiment %s has no metrics constructor", exp.ID)
+	}
+	tmpl, err := template.New(exp.ID).Option("missingkey=error").Parse(string(exp.Scenario))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scenario template: %w", err)
+	}
+	repeats := max(exp.Repeats, 1)
+	workers := max(exp.Parallelism, 1)
+	if exp.Run.Mode == "" {
+		exp.Run.Mode = RunAsFastAsPossible
+	}
+
+	var runs []ExperimentRun
+	for _, params := range sweep(exp.Sweep) {
+		for r := 0; r < repeats; r++ {
+			runs = append(runs, ExperimentRun{Index: len(runs), Params: params, Repeat: r})
+		}
+	}
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(runs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				run := &runs[i]
+				if err := exp.runOne(ctx, tmpl, run); err != nil {
+					run.Error = err.Error()
+				}
+				if err := saveExperimentRun(store, exp.ID, run); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var failed error
+feed:
+	for i := range runs {
+		select {
+		case indexes <- i:
+		case failed = <-errs:
+			break feed
+		case <-ctx.Done():
+			failed = ctx.Err()
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if failed == nil {
+		select {
+		case failed = <-errs:
+		default:
+			failed = ctx.Err()
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return runs, nil
+}
+
+// runOne builds and runs the matrix of one experiment run
+func (exp Experiment) runOne(ctx context.Context, tmpl *template.Template, run *ExperimentRun) error {
+	var doc bytes.Buffer
+	if err := tmpl.Execute(&doc, run.Params); err != nil {
+		return fmt.Errorf("failed to render scenario: %w", err)
+	}
+	scenario, err := ParseScenario(doc.Bytes())
+	if err != nil {
+		return err
+	}
+	scenario.ID = fmt.Sprintf("%s-%d", scenario.ID, run.Index)
+	scenario.Seed += int64(run.Repeat)
+	run.Seed = scenario.Seed
+
+	cfg := scenario.RunConfig(exp.Run)
+	if cfg.MaxSteps == 0 && cfg.MaxDuration == 0 && cfg.StopWhen == "" {
+		return fmt.Errorf("scenario %s never terminates", scenario.ID)
+	}
+	m, err := scenario.Build(exp.NewMetrics(scenario.ID))
+	if err != nil {
+		return err
+	}
+	err = m.Run(ctx, cfg)
+	run.Result, _ = m.LastRunResult()
+	return err
+}
+
+// sweep returns every combination of parameter values, varying the last
+// parameter by name fastest
+func sweep(params map[string][]interface{}) []map[string]interface{} {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sets := []map[string]interface{}{{}}
+	for _, name := range names {
+		var next []map[string]interface{}
+		for _, set := range sets {
+			for _, value := range params[name] {
+				combined := make(map[string]interface{}, len(set)+1)
+				for k, v := range set {
+					combined[k] = v
+				}
+				combined[name] = value
+				next = append(next, combined)
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+// LoadExperimentRuns reads an experiment's saved runs in index order
+func LoadExperimentRuns(store *kv.Store, experimentID string) ([]ExperimentRun, error) {
+	iter, err := store.Scan([]byte(experimentPrefix(experimentID)))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var runs []ExperimentRun
+	for valid := iter.First(); valid; valid = iter.Next() {
+		var run ExperimentRun
+		if err := json.Unmarshal(iter.Value(), &run); err != nil {
+			return nil, fmt.Errorf("failed to decode experiment run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load experiment runs: %w", err)
+	}
+	return runs, nil
+}
+
+// saveExperimentRun writes one run's outcome to the store
+func saveExperimentRun(store *kv.Store, experimentID string, run *ExperimentRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode experiment run: %w", err)
+	}
+	key := binary.BigEndian.AppendUint64([]byte(experimentPrefix(experimentID)), uint64(run.Index))
+	if err := store.Put(key, data); err != nil {
+		return fmt.Errorf("failed to save experiment run: %w", err)
+	}
+	return nil
+}
+
+// experimentPrefix returns the key prefix of an experiment's runs
+func experimentPrefix(experimentID string) string {
+	return "experiments/" + experimentID + "/runs/"
+}
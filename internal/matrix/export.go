@@ -0,0 +1,327 @@
+package matrix
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is a file format runs can be exported to
+type ExportFormat string
+
+const (
+	// ExportCSV writes the event stream, one row per journal entry
+	ExportCSV ExportFormat = "csv"
+	// ExportGEXF writes the interaction graph as GEXF 1.3, read by Gephi
+	ExportGEXF ExportFormat = "gexf"
+	// ExportGraphML writes the interaction graph as GraphML, read by
+	// networkx.read_graphml
+	ExportGraphML ExportFormat = "graphml"
+	// ExportParquet writes the event stream like ExportCSV as a Parquet
+	// file, read by pandas.read_parquet
+	ExportParquet ExportFormat = "parquet"
+)
+
+// DefaultInteractionKey is the event data key naming the agent an event is
+// aimed at
+const DefaultInteractionKey = "target"
+
+// ExportOptions configures an export
+type ExportOptions struct {
+	// InteractionKey is the event data key whose string value names the
+	// agent an event interacts with; defaults to DefaultInteractionKey
+	InteractionKey string
+}
+
+// InteractionGraph is the directed graph of agent interactions in a run.
+// An event from AgentID whose data names another agent under the
+// interaction key is an edge between them.
+type InteractionGraph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphNode is an agent in an interaction graph
+type GraphNode struct {
+	ID   string
+	Type string
+}
+
+// GraphEdge counts the interactions of one agent with another
+type GraphEdge struct {
+	Source string
+	Target string
+	Weight int
+	First  uint64 // Step of the first interaction
+	Last   uint64 // Step of the last interaction
+}
+
+// BuildInteractionGraph derives the interaction graph of journal entries.
+// Nodes and edges are sorted by ID.
+func BuildInteractionGraph(entries []JournalEntry, opts ExportOptions) *InteractionGraph {
+	key := opts.InteractionKey
+	if key == "" {
+		key = DefaultInteractionKey
+	}
+
+	types := make(map[string]string)
+	edges := make(map[[2]string]*GraphEdge)
+	for _, entry := range entries {
+		event := entry.Event
+		if event.AgentID == "" {
+			continue
+		}
+		if event.Type == EventAgentAdded {
+			types[event.AgentID], _ = event.Data["type"].(string)
+			continue
+		}
+		if _, seen := types[event.AgentID]; !seen {
+			types[event.AgentID] = ""
+		}
+
+		target, ok := event.Data[key].(string)
+		if !ok || target == "" || target == event.AgentID {
+			continue
+		}
+		if _, seen := types[target]; !seen {
+			types[target] = ""
+		}
+		pair := [2]string{event.AgentID, target}
+		edge, exists := edges[pair]
+		if !exists {
+			edge = &GraphEdge{Source: event.AgentID, Target: target, First: entry.Step}
+			edges[pair] = edge
+		}
+		edge.Weight++
+		edge.Last = entry.Step
+	}
+
+	graph := &InteractionGraph{}
+	for id, agentType := range types {
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: id, Type: agentType})
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	for _, edge := range edges {
+		graph.Edges = append(graph.Edges, *edge)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Target < b.Target
+	})
+	return graph
+}
+
+// Export writes journal entries in the given format: the event stream for
+// CSV and Parquet, the interaction graph for GEXF and GraphML
+func Export(w io.Writer, format ExportFormat, entries []JournalEntry, opts ExportOptions) error {
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, entries)
+	case ExportParquet:
+		return exportParquet(w, entries)
+	case ExportGEXF:
+		return exportGEXF(w, BuildInteractionGraph(entries, opts))
+	case ExportGraphML:
+		return exportGraphML(w, BuildInteractionGraph(entries, opts))
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// ExportJournal exports every entry of a journal
+func ExportJournal(w io.Writer, format ExportFormat, j *Journal, opts ExportOptions) error {
+	entries, err := j.Entries(0)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return Export(w, format, entries, opts)
+}
+
+// Journal returns the matrix's journal, if it has one
+func (m *Matrix) Journal() (*Journal, bool) {
+	j := m.journal.Load()
+	return j, j != nil
+}
+
+// exportCSV writes one row per entry with the event data as JSON
+func exportCSV(w io.Writer, entries []JournalEntry) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"seq", "step", "rule_id", "type", "agent_id", "timestamp", "data"}); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry.Event.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encode event data: %w", err)
+		}
+		row := []string{
+			strconv.FormatUint(entry.Seq, 10),
+			strconv.FormatUint(entry.Step, 10),
+			entry.RuleID,
+			entry.Event.Type,
+			entry.Event.AgentID,
+			entry.Event.Timestamp.UTC().Format(time.RFC3339Nano),
+			string(data),
+		}
+		if err := out.Write(row); err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return nil
+}
+
+// gexf is the root of a GEXF document
+type gexf struct {
+	XMLName xml.Name `xml:"http://gexf.net/1.3 gexf"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string `xml:"defaultedgetype,attr"`
+		Attributes      struct {
+			Class string          `xml:"class,attr"`
+			Attrs []gexfAttribute `xml:"attribute"`
+		} `xml:"attributes"`
+		Nodes []gexfNode `xml:"nodes>node"`
+		Edges []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+	Weight int    `xml:"weight,attr"`
+}
+
+// exportGEXF writes an interaction graph as GEXF
+func exportGEXF(w io.Writer, graph *InteractionGraph) error {
+	doc := gexf{Version: "1.3"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes.Class = "node"
+	doc.Graph.Attributes.Attrs = []gexfAttribute{{ID: "type", Title: "type", Type: "string"}}
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{
+			ID:     node.ID,
+			Label:  node.ID,
+			Values: []gexfValue{{For: "type", Value: node.Type}},
+		})
+	}
+	for i, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Weight: edge.Weight,
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// graphML is the root of a GraphML document
+type graphML struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// exportGraphML writes an interaction graph as GraphML
+func exportGraphML(w io.Writer, graph *InteractionGraph) error {
+	doc := graphML{Keys: []graphMLKey{
+		{ID: "type", For: "node", Name: "type", Type: "string"},
+		{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+		{ID: "first", For: "edge", Name: "first_step", Type: "long"},
+		{ID: "last", For: "edge", Name: "last_step", Type: "long"},
+	}}
+	doc.Graph.EdgeDefault = "directed"
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID:   node.ID,
+			Data: []graphMLData{{Key: "type", Value: node.Type}},
+		})
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.Source,
+			Target: edge.Target,
+			Data: []graphMLData{
+				{Key: "weight", Value: strconv.Itoa(edge.Weight)},
+				{Key: "first", Value: strconv.FormatUint(edge.First, 10)},
+				{Key: "last", Value: strconv.FormatUint(edge.Last, 10)},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// writeXML writes an indented XML document with its header
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode export: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package matrix
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// Expressions in rule files use Go expression syntax over an agent:
+//
+//	id, kind        the agent's ID and Type (type is a reserved word)
+//	state.key       a state value; state["key"] for keys that are not identifiers
+//	step            the index of the step being evaluated
+//	has("key")      whether the state holds key
+//	min, max, abs   numeric helpers
+//	rand()          a number in [0, 1) from the rule's seeded source
+//	neighbors(r)    the number of other agents within radius r in the space
+//	count(kind)     the number of enabled agents, of one type if kind is given
+//	total("key")    the sum of a numeric state key over enabled agents
+//	mean("key")     its mean over the enabled agents holding it
+//
+// Run stop conditions use the same syntax without an agent, so only step
+// and the aggregate functions apply. Numbers are float64, matching state
+// restored from journals and snapshots. Operators are + - * / %, comparisons, && || !, and + on strings.
+
+// expr is a compiled rule expression
+type expr struct {
+	src  string
+	node ast.Expr
+}
+
+// exprEnv is what an expression is evaluated against
+type exprEnv struct {
+	id    string
+	typ   string
+	state map[string]interface{}
+	step  uint64
+	rng   *rand.Rand // Set while a rule is evaluated
+	m     *Matrix
+}
+
+// compileExpr parses an expression
+func compileExpr(src string) (*expr, error) {
+	node, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, err)
+	}
+	return &expr{src: src, node: node}, nil
+}
+
+// eval evaluates the expression
+func (e *expr) eval(env exprEnv) (interface{}, error) {
+	v, err := env.eval(e.node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %q: %w", e.src, err)
+	}
+	return v, nil
+}
+
+// evalBool evaluates an expression that must be a boolean
+func (e *expr) evalBool(env exprEnv) (bool, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q is %T, not bool", e.src, v)
+	}
+	return b, nil
+}
+
+// eval evaluates a node of the expression tree
+func (env exprEnv) eval(node ast.Expr) (interface{}, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return env.eval(n.X)
+	case *ast.BasicLit:
+		return literal(n)
+	case *ast.Ident:
+		switch n.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "id":
+			return env.id, nil
+		case "kind":
+			return env.typ, nil
+		case "step":
+			return float64(env.step), nil
+		}
+		return nil, fmt.Errorf("unknown identifier %s", n.Name)
+	case *ast.SelectorExpr:
+		if x, ok := n.X.(*ast.Ident); !ok || x.Name != "state" {
+			return nil, fmt.Errorf("only state fields can be selected")
+		}
+		return env.lookup(n.Sel.Name)
+	case *ast.IndexExpr:
+		if x, ok := n.X.(*ast.Ident); !ok || x.Name != "state" {
+			return nil, fmt.Errorf("only state can be indexed")
+		}
+		key, err := env.eval(n.Index)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("state key must be a string, got %T", key)
+		}
+		return env.lookup(s)
+	case *ast.CallExpr:
+		return env.call(n)
+	case *ast.UnaryExpr:
+		x, err := env.eval(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return unary(n.Op, x)
+	case *ast.BinaryExpr:
+		return env.binary(n)
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", node)
+	}
+}
+
+// lookup reads a state value, failing on missing keys so typos surface
+func (env exprEnv) lookup(key string) (interface{}, error) {
+	v, ok := env.state[key]
+	if !ok {
+		return nil, fmt.Errorf("state has no key %q", key)
+	}
+	if f, ok := toFloat(v); ok {
+		return f, nil
+	}
+	return v, nil
+}
+
+// call evaluates a built-in function call
+func (env exprEnv) call(n *ast.CallExpr) (interface{}, error) {
+	fn, ok := n.Fun.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("unsupported call")
+	}
+	args := make([]interface{}, len(n.Args))
+	for i, arg := range n.Args {
+		v, err := env.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch fn.Name {
+	case "rand":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("rand takes no arguments")
+		}
+		if env.rng == nil {
+			return nil, fmt.Errorf("rand is only available in rules")
+		}
+		return env.rng.Float64(), nil
+	case "neighbors":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("neighbors takes one argument")
+		}
+		radius, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("neighbors takes a number")
+		}
+		if env.m == nil {
+			return 0.0, nil
+		}
+		// Agents without a position have no neighbors
+		neighbors, _ := env.m.Neighbors(env.id, radius)
+		return float64(len(neighbors)), nil
+	case "count", "total", "mean":
+		if env.m == nil {
+			return nil, fmt.Errorf("%s needs a matrix", fn.Name)
+		}
+		return env.aggregate(fn.Name, args)
+	case "has":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("has takes one argument")
+		}
+		key, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("has takes a string key")
+		}
+		_, exists := env.state[key]
+		return exists, nil
+	case "abs":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("abs takes one argument")
+		}
+		x, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("abs takes a number")
+		}
+		return math.Abs(x), nil
+	case "min", "max":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s takes at least one argument", fn.Name)
+		}
+		result, ok := args[0].(float64)
+		for _, arg := range args[1:] {
+			x, isNum := arg.(float64)
+			if !isNum {
+				ok = false
+				break
+			}
+			if fn.Name == "min" {
+				result = math.Min(result, x)
+			} else {
+				result = math.Max(result, x)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s takes numbers", fn.Name)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unknown function %s", fn.Name)
+	}
+}
+
+// aggregate evaluates an aggregate function over the matrix's enabled agents
+func (env exprEnv) aggregate(name string, args []interface{}) (interface{}, error) {
+	if name == "count" {
+		if len(args) > 1 {
+			return nil, fmt.Errorf("count takes at most one argument")
+		}
+		kind := ""
+		if len(args) == 1 {
+			var ok bool
+			if kind, ok = args[0].(string); !ok {
+				return nil, fmt.Errorf("count takes an agent type")
+			}
+		}
+		n := 0.0
+		for _, agent := range env.m.exprEnvs() {
+			if kind == "" || agent.typ == kind {
+				n++
+			}
+		}
+		return n, nil
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", name)
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s takes a string key", name)
+	}
+	sum, n := 0.0, 0.0
+	for _, agent := range env.m.exprEnvs() {
+		if v, ok := toFloat(agent.state[key]); ok {
+			sum += v
+			n++
+		}
+	}
+	if name == "mean" {
+		if n == 0 {
+			return 0.0, nil
+		}
+		return sum / n, nil
+	}
+	return sum, nil
+}
+
+// binary evaluates a binary operation
+func (env exprEnv) binary(n *ast.BinaryExpr) (interface{}, error) {
+	x, err := env.eval(n.X)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit logical operators
+	if n.Op == token.LAND || n.Op == token.LOR {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s needs bools, got %T", n.Op, x)
+		}
+		if b == (n.Op == token.LOR) {
+			return b, nil
+		}
+		y, err := env.eval(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok = y.(bool); !ok {
+			return nil, fmt.Errorf("operator %s needs bools, got %T", n.Op, y)
+		}
+		return b, nil
+	}
+
+	y, err := env.eval(n.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Op {
+	case token.EQL, token.NEQ:
+		if !scalar(x) || !scalar(y) {
+			return nil, fmt.Errorf("cannot compare %T and %T", x, y)
+		}
+		return (x == y) == (n.Op == token.EQL), nil
+	}
+
+	if xs, ok := x.(string); ok {
+		ys, ok := y.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply %s to string and %T", n.Op, y)
+		}
+		switch n.Op {
+		case token.ADD:
+			return xs + ys, nil
+		case token.LSS:
+			return xs < ys, nil
+		case token.LEQ:
+			return xs <= ys, nil
+		case token.GTR:
+			return xs > ys, nil
+		case token.GEQ:
+			return xs >= ys, nil
+		}
+		return nil, fmt.Errorf("operator %s is not defined on strings", n.Op)
+	}
+
+	xf, xok := x.(float64)
+	yf, yok := y.(float64)
+	if !xok || !yok {
+		return nil, fmt.Errorf("cannot apply %s to %T and %T", n.Op, x, y)
+	}
+	switch n.Op {
+	case token.ADD:
+		return xf + yf, nil
+	case token.SUB:
+		return xf - yf, nil
+	case token.MUL:
+		return xf * yf, nil
+	case token.QUO:
+		return xf / yf, nil
+	case token.REM:
+		return math.Mod(xf, yf), nil
+	case token.LSS:
+		return xf < yf, nil
+	case token.LEQ:
+		return xf <= yf, nil
+	case token.GTR:
+		return xf > yf, nil
+	case token.GEQ:
+		return xf >= yf, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", n.Op)
+}
+
+// unary applies ! or - to a value
+func unary(op token.Token, x interface{}) (interface{}, error) {
+	switch op {
+	case token.NOT:
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case token.SUB:
+		if f, ok := x.(float64); ok {
+			return -f, nil
+		}
+	case token.ADD:
+		if f, ok := x.(float64); ok {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot apply %s to %T", op, x)
+}
+
+// literal converts a number or string literal
+func literal(lit *ast.BasicLit) (interface{}, error) {
+	switch lit.Kind {
+	case token.INT, token.FLOAT:
+		return strconv.ParseFloat(lit.Value, 64)
+	case token.STRING:
+		return strconv.Unquote(lit.Value)
+	default:
+		return nil, fmt.Errorf("unsupported literal %s", lit.Value)
+	}
+}
+
+// scalar reports whether v is a bool, number, or string
+func scalar(v interface{}) bool {
+	switch v.(type) {
+	case bool, float64, string:
+		return true
+	default:
+		return false
+	}
+}
+
+// toFloat converts numeric state values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,207 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// ErrFederationTimeout is returned by Step when other shards did not
+// reach the step barrier in time
+var ErrFederationTimeout = errors.New("timed out waiting for federated shards")
+
+// Federation defaults
+const (
+	DefaultFederationTimeout = 30 * time.Second
+	DefaultFederationResend  = 500 * time.Millisecond
+)
+
+// FederationTransport carries federation messages between nodes.
+// *transport.Transport implements it over libp2p pubsub.
+type FederationTransport interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+	Subscribe(ctx context.Context, topic string) (<-chan transport.Message, error)
+}
+
+var _ FederationTransport = (*transport.Transport)(nil)
+
+// FederationConfig partitions a matrix across nodes. Every node runs a
+// matrix with the same ID and rules, holds the agents of its own shard,
+// and steps in lockstep with the others.
+type FederationConfig struct {
+	Shard     int // This node's shard, from 0 to Shards-1
+	Shards    int
+	Transport FederationTransport
+	Topic     string        // Empty uses "matrix/<id>/federation"
+	Timeout   time.Duration // Time to wait at the step barrier; 0 uses DefaultFederationTimeout
+	Resend    time.Duration // Interval to republish while waiting; 0 uses DefaultFederationResend
+}
+
+// federationMessage is a shard's contribution to a step: the events its
+// rules emitted for agents on other shards. It doubles as the barrier
+// token, so it is sent even when empty.
+type federationMessage struct {
+	MatrixID string         `json:"matrix_id"`
+	Step     uint64         `json:"step"`
+	Shard    int            `json:"shard"`
+	Events   []JournalEntry `json:"events"`
+}
+
+// federation is a matrix's membership in a federation
+type federation struct {
+	cfg    FederationConfig
+	inbox  map[uint64]map[int][]JournalEntry // Step to shard to events
+	notify chan struct{}                     // Closed when a message arrives
+	mu     sync.Mutex
+}
+
+// ShardOf returns the shard that owns an agent ID
+func ShardOf(id string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// Federate joins the matrix to a federation until ctx ends. From then on
+// each Step applies events for agents this shard owns, sends the rest to
+// their owners, and waits until every shard has finished the step. Rules
+// see only local agents, so aggregates such as count() are per shard.
+func (m *Matrix) Federate(ctx context.Context, cfg FederationConfig) error {
+	if cfg.Shards < 1 || cfg.Shard < 0 || cfg.Shard >= cfg.Shards {
+		return fmt.Errorf("invalid shard %d of %d", cfg.Shard, cfg.Shards)
+	}
+	if cfg.Transport == nil {
+		return fmt.Errorf("federation needs a transport")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "matrix/" + m.ID + "/federation"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultFederationTimeout
+	}
+	if cfg.Resend <= 0 {
+		cfg.Resend = DefaultFederationResend
+	}
+
+	m.agentMu.RLock()
+	for id := range m.agents {
+		if ShardOf(id, cfg.Shards) != cfg.Shard {
+			m.agentMu.RUnlock()
+			return fmt.Errorf("agent %s belongs to shard %d", id, ShardOf(id, cfg.Shards))
+		}
+	}
+	m.agentMu.RUnlock()
+
+	messages, err := cfg.Transport.Subscribe(ctx, cfg.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to join federation: %w", err)
+	}
+	f := &federation{
+		cfg:    cfg,
+		inbox:  make(map[uint64]map[int][]JournalEntry),
+		notify: make(chan struct{}),
+	}
+	if !m.federation.CompareAndSwap(nil, f) {
+		return fmt.Errorf("matrix %s is already federated", m.ID)
+	}
+
+	go func() {
+		defer m.federation.CompareAndSwap(f, nil)
+		for msg := range messages {
+			f.receive(m, msg.Payload)
+		}
+	}()
+	return nil
+}
+
+// owns reports whether this shard holds an agent
+func (f *federation) owns(id string) bool {
+	return ShardOf(id, f.cfg.Shards) == f.cfg.Shard
+}
+
+// receive buffers another shard's message for the step it belongs to
+func (f *federation) receive(m *Matrix, payload []byte) {
+	var msg federationMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return
+	}
+	if msg.MatrixID != m.ID || msg.Shard == f.cfg.Shard || msg.Step < m.steps.Load() {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.inbox[msg.Step] == nil {
+		f.inbox[msg.Step] = make(map[int][]JournalEntry)
+	}
+	f.inbox[msg.Step][msg.Shard] = msg.Events
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// exchange sends this shard's outbound events for step and waits for
+// every other shard's, returning those for local agents in shard order
+func (f *federation) exchange(ctx context.Context, m *Matrix, step uint64, outbox []JournalEntry) ([]JournalEntry, error) {
+	data, err := json.Marshal(federationMessage{MatrixID: m.ID, Step: step, Shard: f.cfg.Shard, Events: outbox})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode federation message: %w", err)
+	}
+	if err := f.cfg.Transport.Publish(ctx, f.cfg.Topic, data); err != nil {
+		return nil, fmt.Errorf("failed to publish federation message: %w", err)
+	}
+
+	timeout := time.NewTimer(f.cfg.Timeout)
+	defer timeout.Stop()
+	resend := time.NewTicker(f.cfg.Resend)
+	defer resend.Stop()
+	for {
+		f.mu.Lock()
+		received := f.inbox[step]
+		if len(received) == f.cfg.Shards-1 {
+			delete(f.inbox, step)
+			f.mu.Unlock()
+			return f.inbound(received), nil
+		}
+		notify := f.notify
+		f.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-resend.C:
+			// Peers that joined late or missed the message need it to pass the barrier
+			if err := f.cfg.Transport.Publish(ctx, f.cfg.Topic, data); err != nil {
+				return nil, fmt.Errorf("failed to publish federation message: %w", err)
+			}
+		case <-timeout.C:
+			return nil, fmt.Errorf("%w: step %d heard from %d of %d shards", ErrFederationTimeout, step, len(received)+1, f.cfg.Shards)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// inbound selects the received events for local agents, ordered by shard
+func (f *federation) inbound(received map[int][]JournalEntry) []JournalEntry {
+	shards := make([]int, 0, len(received))
+	for shard := range received {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+
+	var entries []JournalEntry
+	for _, shard := range shards {
+		for _, entry := range received[shard] {
+			if f.owns(entry.Event.AgentID) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,140 @@
+package matrix
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Lineage records where a forked matrix came from
+type Lineage struct {
+	ParentID string    `json:"parent_id"`
+	Step     uint64    `json:"step"` // Parent's completed steps at the fork
+	Forked   time.Time `json:"forked"`
+}
+
+// StateDiff is one difference between two matrices. An agent present in
+// only one matrix has an empty Key and a nil value on the other side.
+type StateDiff struct {
+	AgentID string
+	Key     string
+	Left    interface{}
+	Right   interface{}
+}
+
+// Fork returns an independent copy of the matrix under a new ID, taken
+// between steps so it may be called while the matrix runs. The fork has
+// the parent's agents, rules, step count, seed, and parallel and spatial
+// settings but no journal, observers, or run history; set a new seed or
+// change its rules to make it diverge. State maps are copied, values are
+// shared, so rules must replace rather than mutate nested values.
+func (m *Matrix) Fork(id string, metrics MetricsCollector) (*Matrix, error) {
+	if id == m.ID {
+		return nil, fmt.Errorf("fork of matrix %s needs a new ID", m.ID)
+	}
+
+	// Hold off steps so the fork sees a consistent state
+	m.stepMu.Lock()
+	defer m.stepMu.Unlock()
+
+	fork := New(id, metrics)
+	fork.lineage = &Lineage{ParentID: m.ID, Step: m.steps.Load(), Forked: time.Now()}
+	fork.steps.Store(m.steps.Load())
+	fork.seed.Store(m.seed.Load())
+
+	m.rulesMu.RLock()
+	fork.rules = append(fork.rules, m.rules...)
+	fork.parallel = m.parallel
+	m.rulesMu.RUnlock()
+
+	m.agentMu.RLock()
+	for _, agent := range m.agents {
+		agent.stateMu.RLock()
+		fork.agents[agent.ID] = &MatrixAgent{
+			ID:       agent.ID,
+			Type:     agent.Type,
+			State:    copyState(agent.State),
+			version:  agent.version,
+			disabled: agent.disabled,
+		}
+		agent.stateMu.RUnlock()
+	}
+	m.agentMu.RUnlock()
+
+	if s := m.space.Load(); s != nil {
+		if err := fork.SetSpace(s.cfg); err != nil {
+			return nil, err
+		}
+	}
+	return fork, nil
+}
+
+// Lineage returns where the matrix was forked from, if it is a fork
+func (m *Matrix) Lineage() (Lineage, bool) {
+	if m.lineage == nil {
+		return Lineage{}, false
+	}
+	return *m.lineage, true
+}
+
+// Diff compares the agent state of two matrices, such as a fork and its
+// parent, ordered by agent and key. The matrix is the left side.
+func (m *Matrix) Diff(other *Matrix) []StateDiff {
+	left, right := m.stateByAgent(), other.stateByAgent()
+
+	ids := make([]string, 0, len(left)+len(right))
+	for id := range left {
+		ids = append(ids, id)
+	}
+	for id := range right {
+		if _, seen := left[id]; !seen {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var diffs []StateDiff
+	for _, id := range ids {
+		l, inLeft := left[id]
+		r, inRight := right[id]
+		switch {
+		case !inLeft:
+			diffs = append(diffs, StateDiff{AgentID: id, Right: r})
+			continue
+		case !inRight:
+			diffs = append(diffs, StateDiff{AgentID: id, Left: l})
+			continue
+		}
+
+		keys := make([]string, 0, len(l)+len(r))
+		for key := range l {
+			keys = append(keys, key)
+		}
+		for key := range r {
+			if _, seen := l[key]; !seen {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !reflect.DeepEqual(l[key], r[key]) {
+				diffs = append(diffs, StateDiff{AgentID: id, Key: key, Left: l[key], Right: r[key]})
+			}
+		}
+	}
+	return diffs
+}
+
+// stateByAgent copies every agent's state
+func (m *Matrix) stateByAgent() map[string]map[string]interface{} {
+	m.agentMu.RLock()
+	defer m.agentMu.RUnlock()
+	states := make(map[string]map[string]interface{}, len(m.agents))
+	for id, agent := range m.agents {
+		agent.stateMu.RLock()
+		states[id] = copyState(agent.State)
+		agent.stateMu.RUnlock()
+	}
+	return states
+}
@@ -0,0 +1,138 @@
+package matrix
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ErrStateDiverged is returned by Replay when replayed state does not match
+// the hash journaled for a step
+var ErrStateDiverged = errors.New("matrix state diverged from journal")
+
+// StepHash is the state hash journaled for a completed step
+type StepHash struct {
+	Step uint64 `json:"step"`
+	Hash string `json:"hash"` // StateHash after the step
+	Seq  uint64 `json:"seq"`  // Sequence number of the first entry after the step
+}
+
+// AgentIDs returns the IDs of the matrix's agents in canonical order, the
+// order in which rules should visit agents so runs are reproducible
+func (m *Matrix) AgentIDs() []string {
+	m.agentMu.RLock()
+	ids := make([]string, 0, len(m.agents))
+	for id := range m.agents {
+		ids = append(ids, id)
+	}
+	m.agentMu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// StateHash returns a hex SHA-256 hash of the matrix's agents: their IDs,
+// types, enabled flags, and state, visited in canonical order. Numbers hash
+// by value regardless of their Go type, so state read back from the journal
+// as float64 hashes like the ints it was written from. A federated matrix
+// hashes only the agents of its shard.
+func (m *Matrix) StateHash() string {
+	h := sha256.New()
+	m.agentMu.RLock()
+	defer m.agentMu.RUnlock()
+
+	ids := make([]string, 0, len(m.agents))
+	for id := range m.agents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		agent := m.agents[id]
+		agent.stateMu.RLock()
+		hashValue(h, agent.ID)
+		hashValue(h, agent.Type)
+		hashValue(h, agent.disabled)
+		hashValue(h, agent.State)
+		agent.stateMu.RUnlock()
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashValue writes a canonical, type-tagged encoding of a state value
+func hashValue(h hash.Hash, v interface{}) {
+	if n, ok := toFloat(v); ok {
+		io.WriteString(h, "n"+strconv.FormatFloat(n, 'g', -1, 64)+";")
+		return
+	}
+	switch v := v.(type) {
+	case nil:
+		io.WriteString(h, "z;")
+	case bool:
+		io.WriteString(h, "b"+strconv.FormatBool(v)+";")
+	case string:
+		io.WriteString(h, "s"+strconv.Itoa(len(v))+":"+v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		io.WriteString(h, "m"+strconv.Itoa(len(keys))+":")
+		for _, k := range keys {
+			hashValue(h, k)
+			hashValue(h, v[k])
+		}
+	case []interface{}:
+		io.WriteString(h, "l"+strconv.Itoa(len(v))+":")
+		for _, e := range v {
+			hashValue(h, e)
+		}
+	default:
+		// Other values hash as their JSON, which is how they are journaled
+		data, err := json.Marshal(v)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%#v", v))
+		}
+		io.WriteString(h, "j"+strconv.Itoa(len(data))+":")
+		h.Write(data)
+	}
+}
+
+// StepHashes returns the hashes journaled for fromStep and later, in step
+// order
+func (j *Journal) StepHashes(fromStep uint64) ([]StepHash, error) {
+	iter, err := j.store.Iterate(j.hashKey(fromStep), prefixEnd(j.hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var hashes []StepHash
+	for valid := iter.First(); valid; valid = iter.Next() {
+		var h StepHash
+		if err := json.Unmarshal(iter.Value(), &h); err != nil {
+			return nil, fmt.Errorf("failed to decode step hash: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, iter.Err()
+}
+
+// hashKey returns the key of a step's hash
+func (j *Journal) hashKey(step uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), j.hashes...), step)
+}
+
+// verifyHash checks the matrix's state against a journaled step hash
+func (m *Matrix) verifyHash(h StepHash) error {
+	if got := m.StateHash(); got != h.Hash {
+		return fmt.Errorf("%w: step %d hashes to %s, journal has %s", ErrStateDiverged, h.Step, got, h.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package matrix
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// Built-in event types that change matrix state. They are applied as they
+// are emitted and re-applied by Replay, so rules that mutate agents through
+// them can be reproduced from the journal.
+const (
+	// EventAgentAdded is journaled by AddAgent. Data holds "type" and the
+	// initial "state".
+	EventAgentAdded = "agent_added"
+	// EventStateChanged sets every key in Data on the state of AgentID
+	EventStateChanged = "state_changed"
+	// EventAgentRemoved is journaled by RemoveAgent
+	EventAgentRemoved = "agent_removed"
+	// EventAgentEnabled and EventAgentDisabled are journaled by SetAgentEnabled
+	EventAgentEnabled  = "agent_enabled"
+	EventAgentDisabled = "agent_disabled"
+)
+
+// ErrNoJournal is returned by Replay on a matrix without a journal
+var ErrNoJournal = errors.New("matrix has no journal")
+
+// JournalEntry is one journaled event
+type JournalEntry struct {
+	Step   uint64 `json:"step"`              // Step the event was emitted in
+	RuleID string `json:"rule_id,omitempty"` // Empty for events outside rules, such as AddAgent
+	Seq    uint64 `json:"seq"`               // Journal-wide order
+	Event  Event  `json:"event"`
+}
+
+// Journal persists matrix events to the KV store. Event data is stored as
+// JSON, so numbers read back from the journal are float64.
+type Journal struct {
+	store  *kv.Store
+	prefix []byte // Entry keys are prefix + big-endian step + big-endian seq
+	head   []byte // Key holding the number of completed steps
+	hashes []byte // Prefix of step hash keys, followed by the big-endian step
+	seq    uint64
+	steps  uint64 // Cached value of head
+	mu     sync.Mutex
+}
+
+// NewJournal opens the journal of a matrix, continuing any entries already
+// in the store
+func NewJournal(store *kv.Store, matrixID string) (*Journal, error) {
+	j := &Journal{
+		store:  store,
+		prefix: []byte("matrix/" + matrixID + "/journal/"),
+		head:   []byte("matrix/" + matrixID + "/steps"),
+		hashes: []byte("matrix/" + matrixID + "/hashes/"),
+	}
+
+	err := j.iterate(0, func(iter *kv.Iterator) bool {
+		return iter.Last()
+	}, func(key, _ []byte) bool {
+		j.seq = binary.BigEndian.Uint64(key[len(j.prefix)+8:]) + 1
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	if j.steps, err = j.Steps(); err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return j, nil
+}
+
+// Append writes entries in order, assigning their sequence numbers. When
+// steps is non-zero it is stored as the number of completed steps in the
+// same batch.
+func (j *Journal) Append(entries []JournalEntry, steps uint64) error {
+	return j.write(entries, steps, nil)
+}
+
+// AppendStep writes the entries of a completed step together with the
+// state hash after it, and records the step as completed
+func (j *Journal) AppendStep(entries []JournalEntry, hash StepHash) error {
+	return j.write(entries, hash.Step+1, &hash)
+}
+
+// write appends entries, the number of completed steps when non-zero, and
+// a step hash when set, in one batch
+func (j *Journal) write(entries []JournalEntry, steps uint64, hash *StepHash) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	batch := j.store.NewBatch()
+	defer batch.Close()
+
+	seq := j.seq
+	for _, entry := range entries {
+		entry.Seq = seq
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal entry: %w", err)
+		}
+		if err := batch.Set(j.key(entry.Step, seq), value); err != nil {
+			return fmt.Errorf("failed to write journal entry: %w", err)
+		}
+		seq++
+	}
+	if steps > 0 {
+		if err := batch.Set(j.head, binary.BigEndian.AppendUint64(nil, steps)); err != nil {
+			return fmt.Errorf("failed to write journal head: %w", err)
+		}
+	}
+	if hash != nil {
+		hash.Seq = seq
+		value, err := json.Marshal(hash)
+		if err != nil {
+			return fmt.Errorf("failed to encode step hash: %w", err)
+		}
+		if err := batch.Set(j.hashKey(hash.Step), value); err != nil {
+			return fmt.Errorf("failed to write step hash: %w", err)
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to commit journal: %w", err)
+	}
+	j.seq = seq
+	if steps > 0 {
+		j.steps = steps
+	}
+	return nil
+}
+
+// Truncate discards the entries of step and later and records step as the
+// number of completed steps
+func (j *Journal) Truncate(step uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	batch := j.store.NewBatch()
+	defer batch.Close()
+	if err := batch.DeleteRange(j.key(step, 0)[:len(j.prefix)+8], prefixEnd(j.prefix)); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	if err := batch.DeleteRange(j.hashKey(step), prefixEnd(j.hashes)); err != nil {
+		return fmt.Errorf("failed to truncate step hashes: %w", err)
+	}
+	if err := batch.Set(j.head, binary.BigEndian.AppendUint64(nil, step)); err != nil {
+		return fmt.Errorf("failed to write journal head: %w", err)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to commit journal: %w", err)
+	}
+	j.steps = step
+	return nil
+}
+
+// completed returns the cached number of completed steps
+func (j *Journal) completed() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.steps
+}
+
+// Entries returns the entries of fromStep and later, in emission order
+func (j *Journal) Entries(fromStep uint64) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	var decodeErr error
+	err := j.iterate(fromStep, (*kv.Iterator).First, func(_, value []byte) bool {
+		var entry JournalEntry
+		if decodeErr = json.Unmarshal(value, &entry); decodeErr != nil {
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode journal entry: %w", decodeErr)
+	}
+	return entries, nil
+}
+
+// Steps returns the number of completed steps recorded in the journal
+func (j *Journal) Steps() (uint64, error) {
+	value, err := j.store.Get(j.head)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// key returns the entry key for a step and sequence number
+func (j *Journal) key(step, seq uint64) []byte {
+	key := make([]byte, 0, len(j.prefix)+16)
+	key = append(key, j.prefix...)
+	key = binary.BigEndian.AppendUint64(key, step)
+	return binary.BigEndian.AppendUint64(key, seq)
+}
+
+// iterate positions an iterator over entries of fromStep and later with
+// start, then calls fn until it returns false
+func (j *Journal) iterate(fromStep uint64, start func(*kv.Iterator) bool, fn func(key, value []byte) bool) error {
+	iter, err := j.store.Iterate(j.key(fromStep, 0)[:len(j.prefix)+8], prefixEnd(j.prefix))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for valid := start(iter); valid; valid = iter.Next() {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Err()
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// SetJournal makes the matrix journal every event it emits or applies
+func (m *Matrix) SetJournal(j *Journal) {
+	m.journal.Store(j)
+}
+
+// Replay reconstructs matrix state by re-applying journaled events from
+// fromStep on, then sets the step count to the journal's. Replaying from 0
+// into a fresh matrix reproduces the journaled run; rules are not evaluated
+// and replayed events are not journaled again. The state after each step
+// is checked against the step's journaled hash, failing with
+// ErrStateDiverged on a mismatch.
+func (m *Matrix) Replay(ctx context.Context, fromStep uint64) error {
+	j := m.journal.Load()
+	if j == nil {
+		return ErrNoJournal
+	}
+
+	m.runMu.Lock()
+	running := m.run.running
+	m.runMu.Unlock()
+	if running {
+		return ErrAlreadyRunning
+	}
+
+	entries, err := j.Entries(fromStep)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	hashes, err := j.StepHashes(fromStep)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for len(hashes) > 0 && hashes[0].Seq <= entry.Seq {
+			if err := m.verifyHash(hashes[0]); err != nil {
+				return err
+			}
+			hashes = hashes[1:]
+		}
+		if err := m.applyEvent(entry.Event); err != nil {
+			return fmt.Errorf("failed to replay step %d: %w", entry.Step, err)
+		}
+		m.metrics.RecordEvent(entry.Event)
+	}
+	for _, h := range hashes {
+		if err := m.verifyHash(h); err != nil {
+			return err
+		}
+	}
+
+	steps, err := j.Steps()
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	m.steps.Store(steps)
+	return nil
+}
+
+// applyEvent applies a built-in state event; other events are ignored
+func (m *Matrix) applyEvent(event Event) error {
+	switch event.Type {
+	case EventAgentAdded:
+		agentType, _ := event.Data["type"].(string)
+		state, _ := event.Data["state"].(map[string]interface{})
+		state, err := m.admitState(agentType, event.AgentID, state)
+		if err != nil {
+			return err
+		}
+		m.agentMu.Lock()
+		defer m.agentMu.Unlock()
+		agent := &MatrixAgent{
+			ID:    event.AgentID,
+			Type:  agentType,
+			State: copyState(state),
+		}
+		m.agents[event.AgentID] = agent
+		m.locate(agent)
+	case EventAgentRemoved:
+		m.agentMu.Lock()
+		defer m.agentMu.Unlock()
+		delete(m.agents, event.AgentID)
+		m.unlocate(event.AgentID)
+	case EventAgentEnabled, EventAgentDisabled, EventStateChanged, EventAgentMoved:
+		agent, exists := m.GetAgent(event.AgentID)
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrAgentNotFound, event.AgentID)
+		}
+		agent.stateMu.Lock()
+		defer agent.stateMu.Unlock()
+		switch event.Type {
+		case EventAgentEnabled:
+			agent.disabled = false
+		case EventAgentDisabled:
+			agent.disabled = true
+		case EventAgentMoved:
+			position, err := m.movement(event)
+			if err != nil {
+				return err
+			}
+			if err := m.checkChange(agent, position); err != nil {
+				return err
+			}
+			agent.setLocked(position)
+			m.locate(agent)
+		default:
+			if err := m.checkChange(agent, event.Data); err != nil {
+				return err
+			}
+			agent.setLocked(event.Data)
+			m.locate(agent)
+		}
+	}
+	return nil
+}
+
+// journalEvent journals an event applied outside of a step, if the matrix
+// has a journal, and publishes it to observers
+func (m *Matrix) journalEvent(event Event) error {
+	entries := []JournalEntry{{Step: m.steps.Load(), Event: event}}
+	if j := m.journal.Load(); j != nil {
+		if err := j.Append(entries, 0); err != nil {
+			return err
+		}
+	}
+	m.publish(entries)
+	return nil
+}
+
+// copyState returns a shallow copy of an agent state map
+func copyState(state map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(state))
+	for k, v := range state {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLimitExceeded is returned when a matrix exceeds one of its Limits
+var ErrLimitExceeded = errors.New("matrix limit exceeded")
+
+// errStepTimeout is the cause of a step context ended by MaxStepDuration
+var errStepTimeout = errors.New("step exceeded its maximum duration")
+
+// Limits bounds the resources a matrix may use. Zero values are unlimited.
+type Limits struct {
+	MaxAgents        int
+	MaxEventsPerStep int
+	MaxStepDuration  time.Duration // Rules see it as their context deadline
+
+	// PauseOnExceed pauses Run when a step exceeds a limit instead of
+	// ending it; Fault reports the breach until Resume
+	PauseOnExceed bool
+}
+
+// SetLimits sets the matrix's resource limits. They apply from the next
+// agent added or step taken.
+func (m *Matrix) SetLimits(limits Limits) error {
+	if limits.MaxAgents < 0 || limits.MaxEventsPerStep < 0 || limits.MaxStepDuration < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	m.limits.Store(&limits)
+	return nil
+}
+
+// Limits returns the matrix's resource limits
+func (m *Matrix) Limits() Limits {
+	if limits := m.limits.Load(); limits != nil {
+		return *limits
+	}
+	return Limits{}
+}
+
+// Fault returns the limit breach that paused the run loop, if it is paused
+// for one
+func (m *Matrix) Fault() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	return m.run.fault
+}
+
+// checkAgentLimit fails if adding an agent would exceed MaxAgents. Callers
+// must hold agentMu.
+func (m *Matrix) checkAgentLimit(id string) error {
+	max := m.Limits().MaxAgents
+	if _, exists := m.agents[id]; exists || max == 0 || len(m.agents) < max {
+		return nil
+	}
+	return fmt.Errorf("%w: matrix %s already has %d agents", ErrLimitExceeded, m.ID, max)
+}
+
+// checkEventLimit fails if applying more events would exceed
+// MaxEventsPerStep
+func (m *Matrix) checkEventLimit(limits Limits, applied, more int) error {
+	if limits.MaxEventsPerStep == 0 || applied+more <= limits.MaxEventsPerStep {
+		return nil
+	}
+	return fmt.Errorf("%w: step would apply %d events, more than %d", ErrLimitExceeded, applied+more, limits.MaxEventsPerStep)
+}
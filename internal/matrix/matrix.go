@@ -2,8 +2,10 @@ package matrix
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,16 +14,85 @@ type Matrix struct {
 	ID      string
 	rules   []Rule
 	rulesMu sync.RWMutex
-	agents  map[string]*MatrixAgent
-	agentMu sync.RWMutex
-	metrics MetricsCollector
+
+	// rulesVersion counts changes to rules; guarded by rulesMu
+	rulesVersion uint64
+	agents       map[string]*MatrixAgent
+	agentMu      sync.RWMutex
+	metrics      MetricsCollector
+
+	// steps counts completed steps
+	steps atomic.Uint64
+
+	// run is the state of the Run loop
+	run   runControl
+	runMu sync.Mutex
+
+	// journal persists emitted events when set
+	journal atomic.Pointer[Journal]
+
+	// parallel configures concurrent rule evaluation; guarded by rulesMu
+	parallel  ParallelConfig
+	conflicts atomic.Uint64
+
+	// observers receive events once they are applied
+	observers observers
+
+	// seed determines the random sources handed to rules
+	seed atomic.Int64
+
+	// space indexes agent positions when a spatial layer is set
+	space atomic.Pointer[space]
+
+	// counts tallies the events applied by steps, by type, and ruleStats
+	// the evaluations of each rule; both are guarded by statsMu
+	counts    map[string]uint64
+	ruleStats map[string]*RuleStats
+	statsMu   sync.Mutex
+
+	// lastResult is the outcome of the most recent run
+	lastResult atomic.Pointer[RunResult]
+
+	// stepMu serializes steps with operations that need a step boundary
+	stepMu sync.Mutex
+
+	// lineage is set on matrices created by Fork
+	lineage *Lineage
+
+	// federation is set while the matrix is partitioned across nodes
+	federation atomic.Pointer[federation]
+
+	// snapshots schedules periodic snapshots for RewindTo
+	snapshots atomic.Pointer[snapshotSchedule]
+
+	// limits bounds the matrix's resources when set
+	limits atomic.Pointer[Limits]
+
+	// schemas validates agent state by agent type
+	schemas  map[string]*StateSchema
+	schemaMu sync.RWMutex
+
+	// subs are nested matrices stepped with this one; owner is set on a
+	// nested matrix
+	subs  map[string]*subMatrix
+	subMu sync.RWMutex
+	owner atomic.Pointer[Matrix]
 }
 
-// Rule represents a simulation rule
+// Rule represents a simulation rule. Rules are evaluated from highest to
+// lowest Priority, in the order they were added when priorities tie.
 type Rule struct {
 	ID       string
 	Priority int
 	Evaluate func(context.Context, *Matrix) ([]Event, error)
+
+	// Exclusive rules preempt lower priority rules: when one emits events,
+	// rules with a lower Priority are skipped for the rest of the step
+	Exclusive bool
+
+	// Version is set by the matrix: 1 when the rule is added, incremented
+	// by each UpdateRule
+	Version uint64
 }
 
 // MatrixAgent represents an agent in the matrix (to avoid conflict with agent package)
@@ -30,6 +101,10 @@ type MatrixAgent struct {
 	Type    string
 	State   map[string]interface{}
 	stateMu sync.RWMutex
+
+	// version counts state changes, for optimistic concurrency
+	version  uint64
+	disabled bool
 }
 
 // Event represents a matrix event
@@ -56,11 +131,13 @@ func New(id string, metrics MetricsCollector) *Matrix {
 	}
 }
 
-// AddRule adds a new rule to the matrix
+// AddRule adds a new rule to the matrix while it is being set up. Use
+// UpdateRule to add rules to a running matrix so the change is journaled.
 func (m *Matrix) AddRule(rule Rule) {
 	m.rulesMu.Lock()
 	defer m.rulesMu.Unlock()
-	m.rules = append(m.rules, rule)
+	rule.Version = 1
+	m.insertRule(rule)
 }
 
 // AddAgent adds a new agent to the matrix
@@ -71,34 +148,259 @@ func (m *Matrix) AddAgent(agent *MatrixAgent) error {
 	if _, exists := m.agents[agent.ID]; exists {
 		return fmt.Errorf("agent with ID %s already exists", agent.ID)
 	}
+	if f := m.federation.Load(); f != nil && !f.owns(agent.ID) {
+		return fmt.Errorf("agent %s belongs to shard %d", agent.ID, ShardOf(agent.ID, f.cfg.Shards))
+	}
+	if err := m.checkAgentLimit(agent.ID); err != nil {
+		return err
+	}
+
+	agent.stateMu.Lock()
+	admitted, err := m.admitState(agent.Type, agent.ID, agent.State)
+	if err != nil {
+		agent.stateMu.Unlock()
+		return err
+	}
+	agent.State = admitted
+	state := copyState(agent.State)
+	m.locate(agent)
+	agent.stateMu.Unlock()
+
+	err = m.journalEvent(Event{
+		Type:      EventAgentAdded,
+		Timestamp: time.Now(),
+		AgentID:   agent.ID,
+		Data:      map[string]interface{}{"type": agent.Type, "state": state},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to journal agent %s: %w", agent.ID, err)
+	}
 
 	m.agents[agent.ID] = agent
 	return nil
 }
 
-// Step advances the matrix simulation by one step
-func (m *Matrix) Step(ctx context.Context) error {
+// Step advances the matrix simulation by one step. A step that fails is
+// rolled back: agents are left as they were, and nothing is journaled or
+// counted, so the step can be retried.
+func (m *Matrix) Step(ctx context.Context) (err error) {
+	m.stepMu.Lock()
+	defer m.stepMu.Unlock()
+
 	m.rulesMu.RLock()
 	rules := make([]Rule, len(m.rules))
 	copy(rules, m.rules)
+	parallel := m.parallel
 	m.rulesMu.RUnlock()
 
-	// Evaluate rules in priority order
-	for _, rule := range rules {
-		events, err := rule.Evaluate(ctx, m)
-		if err != nil {
-			return fmt.Errorf("rule %s evaluation failed: %w", rule.ID, err)
+	start := time.Now()
+	step := m.steps.Load()
+
+	limits := m.Limits()
+	if limits.MaxStepDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, limits.MaxStepDuration, errStepTimeout)
+		defer cancel()
+		defer func() {
+			// Report rules cut off by the deadline as a limit breach
+			if err != nil && !errors.Is(err, ErrLimitExceeded) && errors.Is(context.Cause(ctx), errStepTimeout) {
+				err = fmt.Errorf("%w: step %d ran longer than %v: %v", ErrLimitExceeded, step, limits.MaxStepDuration, err)
+			}
+		}()
+	}
+	if j := m.journal.Load(); j != nil && j.completed() > step {
+		return fmt.Errorf("%w: at step %d of %d", ErrRewound, step, j.completed())
+	}
+	timer := &stepTimer{m: m, step: step}
+	fed := m.federation.Load()
+	var journaled, outbox []JournalEntry
+
+	undo := &stepUndo{m: m, agents: make(map[string]*agentUndo)}
+	committed := false
+	defer func() {
+		if !committed {
+			undo.rollback()
 		}
+	}()
 
-		// Record events
+	// apply applies and records an event. Events aimed at disabled agents
+	// are dropped.
+	apply := func(ruleID string, event Event) error {
+		if m.agentDisabled(event.AgentID) {
+			return nil
+		}
+		if err := m.checkEventLimit(limits, len(journaled), 1); err != nil {
+			return fmt.Errorf("rule %s: %w", ruleID, err)
+		}
+		if event.Type == EventAgentAdded {
+			m.agentMu.RLock()
+			err := m.checkAgentLimit(event.AgentID)
+			m.agentMu.RUnlock()
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", ruleID, err)
+			}
+		}
+		undo.save(event.AgentID)
+		if err := m.applyEvent(event); err != nil {
+			return fmt.Errorf("rule %s emitted invalid %s event: %w", ruleID, event.Type, err)
+		}
+		journaled = append(journaled, JournalEntry{Step: step, RuleID: ruleID, Event: event})
+		return nil
+	}
+
+	// emit applies a rule's events, holding back those for agents on
+	// other federated shards. A rule whose events would exceed the step's
+	// event limit fails before any of them is applied.
+	emit := func(rule Rule, events []Event) error {
+		if err := m.checkEventLimit(limits, len(journaled)+len(outbox), len(events)); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
 		for _, event := range events {
-			m.metrics.RecordEvent(event)
+			if fed != nil && !fed.owns(event.AgentID) {
+				outbox = append(outbox, JournalEntry{Step: step, RuleID: rule.ID, Event: event})
+				continue
+			}
+			if err := apply(rule.ID, event); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
+	if err := m.stepSubMatrices(ctx, apply); err != nil {
+		return err
+	}
+
+	if parallel.Workers > 1 {
+		if err := m.stepParallel(ctx, rules, parallel, timer.evaluate, emit); err != nil {
+			return err
+		}
+	} else {
+		// Evaluate rules in priority order
+		preempted := false
+		var preemptedBelow int
+		for _, rule := range rules {
+			if preempted && rule.Priority < preemptedBelow {
+				break
+			}
+
+			events, err := timer.evaluate(ctx, rule)
+			if err != nil {
+				return fmt.Errorf("rule %s evaluation failed: %w", rule.ID, err)
+			}
+			if err := emit(rule, events); err != nil {
+				return err
+			}
+
+			if rule.Exclusive && len(events) > 0 && !preempted {
+				preempted, preemptedBelow = true, rule.Priority
+			}
+		}
+	}
+
+	if limits.MaxStepDuration > 0 && time.Since(start) > limits.MaxStepDuration {
+		return fmt.Errorf("%w: step %d took %v, longer than %v", ErrLimitExceeded, step, time.Since(start).Round(time.Millisecond), limits.MaxStepDuration)
+	}
+
+	if fed != nil {
+		inbound, err := fed.exchange(ctx, m, step, outbox)
+		if err != nil {
+			return err
+		}
+		for _, entry := range inbound {
+			if err := apply(entry.RuleID, entry.Event); err != nil {
+				return err
+			}
+		}
+	}
+
+	if j := m.journal.Load(); j != nil {
+		if err := j.AppendStep(journaled, StepHash{Step: step, Hash: m.StateHash()}); err != nil {
+			return fmt.Errorf("failed to journal step %d: %w", step, err)
+		}
+	}
+	committed = true
+	for _, entry := range journaled {
+		m.metrics.RecordEvent(entry.Event)
+	}
+	m.publish(journaled)
+	timer.record(time.Since(start), journaled)
+
+	m.steps.Add(1)
+	if err := m.takeScheduledSnapshot(); err != nil {
+		return fmt.Errorf("failed to snapshot step %d: %w", step, err)
+	}
 	return nil
 }
 
+// stepUndo holds the agents a step has touched as they were before it,
+// to roll back a step that fails
+type stepUndo struct {
+	m      *Matrix
+	agents map[string]*agentUndo
+}
+
+// agentUndo is an agent's state before the step first touched it. agent is
+// nil for agents the step added.
+type agentUndo struct {
+	agent    *MatrixAgent
+	state    map[string]interface{}
+	version  uint64
+	disabled bool
+}
+
+// save records an agent's state unless the step already touched it
+func (u *stepUndo) save(id string) {
+	if _, saved := u.agents[id]; saved {
+		return
+	}
+	agent, exists := u.m.GetAgent(id)
+	if !exists {
+		u.agents[id] = &agentUndo{}
+		return
+	}
+	agent.stateMu.RLock()
+	u.agents[id] = &agentUndo{agent: agent, state: copyState(agent.State), version: agent.version, disabled: agent.disabled}
+	agent.stateMu.RUnlock()
+}
+
+// rollback restores every agent the step touched, re-adding those it
+// removed and removing those it added
+func (u *stepUndo) rollback() {
+	m := u.m
+	m.agentMu.Lock()
+	defer m.agentMu.Unlock()
+	for id, saved := range u.agents {
+		if saved.agent == nil {
+			delete(m.agents, id)
+			m.unlocate(id)
+			continue
+		}
+		agent := saved.agent
+		agent.stateMu.Lock()
+		agent.State, agent.version, agent.disabled = saved.state, saved.version, saved.disabled
+		m.locate(agent)
+		agent.stateMu.Unlock()
+		m.agents[id] = agent
+	}
+}
+
+// EventCounts returns the number of events steps have applied, by type
+func (m *Matrix) EventCounts() map[string]uint64 {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	counts := make(map[string]uint64, len(m.counts))
+	for eventType, n := range m.counts {
+		counts[eventType] = n
+	}
+	return counts
+}
+
+// StepCount returns the number of steps completed
+func (m *Matrix) StepCount() uint64 {
+	return m.steps.Load()
+}
+
 // GetAgent returns an agent by ID
 func (m *Matrix) GetAgent(id string) (*MatrixAgent, bool) {
 	m.agentMu.RLock()
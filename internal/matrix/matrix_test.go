@@ -0,0 +1,1597 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// testMetrics records events for inspection
+type testMetrics struct {
+	events []Event
+}
+
+func (t *testMetrics) RecordEvent(e Event) {
+	t.events = append(t.events, e)
+}
+
+func (t *testMetrics) GetMetrics() map[string]float64 {
+	return map[string]float64{"events": float64(len(t.events))}
+}
+
+func TestMatrix_Run(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RunConfig
+		want uint64
+	}{
+		{"fast", RunConfig{Mode: RunAsFastAsPossible, MaxSteps: 50}, 50},
+		{"realtime", RunConfig{Mode: RunRealTime, TickInterval: time.Millisecond, MaxSteps: 3}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			if err := m.Run(context.Background(), tt.cfg); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := m.StepCount(); got != tt.want {
+				t.Errorf("StepCount() = %d, want %d", got, tt.want)
+			}
+			if m.State() != RunStateIdle {
+				t.Errorf("State() = %s after Run, want idle", m.State())
+			}
+		})
+	}
+}
+
+func TestMatrix_RunPauseStop(t *testing.T) {
+	m := New("m", &testMetrics{})
+	done := make(chan error, 1)
+	go func() { done <- m.Run(context.Background(), RunConfig{TickInterval: time.Millisecond}) }()
+
+	for m.State() != RunStateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	if err := m.Run(context.Background(), RunConfig{}); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("second Run() error = %v, want ErrAlreadyRunning", err)
+	}
+
+	m.Pause()
+	time.Sleep(5 * time.Millisecond)
+	paused := m.StepCount()
+	time.Sleep(10 * time.Millisecond)
+	if got := m.StepCount(); got != paused {
+		t.Errorf("StepCount() advanced from %d to %d while paused", paused, got)
+	}
+
+	m.Resume()
+	m.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v after Stop", err)
+	}
+}
+
+func TestMatrix_RulePriority(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  string
+	}{
+		{
+			name:  "highest first, ties in insertion order",
+			rules: []Rule{{ID: "low", Priority: 1}, {ID: "high", Priority: 10}, {ID: "mid-a", Priority: 5}, {ID: "mid-b", Priority: 5}},
+			want:  "high,mid-a,mid-b,low",
+		},
+		{
+			name:  "exclusive preempts lower priorities",
+			rules: []Rule{{ID: "low", Priority: 1}, {ID: "excl", Priority: 5, Exclusive: true}, {ID: "peer", Priority: 5}, {ID: "high", Priority: 10}},
+			want:  "high,excl,peer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			var order []string
+			for _, r := range tt.rules {
+				id := r.ID
+				r.Evaluate = func(context.Context, *Matrix) ([]Event, error) {
+					order = append(order, id)
+					return []Event{{Type: id}}, nil
+				}
+				m.AddRule(r)
+			}
+			if err := m.Step(context.Background()); err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+			if got := strings.Join(order, ","); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatrix_JournalReplay(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	newMatrix := func() *Matrix {
+		j, err := NewJournal(store, "m")
+		if err != nil {
+			t.Fatalf("NewJournal() error = %v", err)
+		}
+		m := New("m", &testMetrics{})
+		m.SetJournal(j)
+		return m
+	}
+
+	m := newMatrix()
+	if err := m.AddAgent(&MatrixAgent{ID: "a", Type: "counter", State: map[string]interface{}{"n": 0.0}}); err != nil {
+		t.Fatalf("AddAgent() error = %v", err)
+	}
+	m.AddRule(Rule{ID: "inc", Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+		a, _ := m.GetAgent("a")
+		n := a.State["n"].(float64)
+		return []Event{{Type: EventStateChanged, AgentID: "a", Data: map[string]interface{}{"n": n + 1}}}, nil
+	}})
+	for i := 0; i < 3; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+
+	replayed := newMatrix()
+	if err := replayed.Replay(context.Background(), 0); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	a, ok := replayed.GetAgent("a")
+	if !ok {
+		t.Fatal("agent a not replayed")
+	}
+	if a.Type != "counter" || a.State["n"] != 3.0 {
+		t.Errorf("replayed agent = %s %v, want counter n=3", a.Type, a.State)
+	}
+	if got := replayed.StepCount(); got != 3 {
+		t.Errorf("StepCount() = %d after replay, want 3", got)
+	}
+
+	entries, err := replayed.journal.Load().Entries(2)
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Step != 2 || entries[0].RuleID != "inc" {
+		t.Errorf("Entries(2) = %+v, want the inc event of step 2", entries)
+	}
+}
+
+func TestMatrix_SnapshotRestore(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	rule := Rule{ID: "noop", Priority: 2, Evaluate: func(context.Context, *Matrix) ([]Event, error) { return nil, nil }}
+	m := New("m", &testMetrics{})
+	m.AddRule(rule)
+	if err := m.AddAgent(&MatrixAgent{ID: "a", Type: "t", State: map[string]interface{}{"x": 1.5}}); err != nil {
+		t.Fatalf("AddAgent() error = %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+	if err := SaveSnapshot(store, m.Snapshot()); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	snap, err := LatestSnapshot(store, "m")
+	if err != nil {
+		t.Fatalf("LatestSnapshot() error = %v", err)
+	}
+	if err := New("m", &testMetrics{}).Restore(snap); err == nil {
+		t.Error("Restore() without the snapshot's rules succeeded")
+	}
+
+	restored := New("m", &testMetrics{})
+	restored.AddRule(rule)
+	if err := restored.Restore(snap); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := restored.StepCount(); got != 4 {
+		t.Errorf("StepCount() = %d after restore, want 4", got)
+	}
+	a, ok := restored.GetAgent("a")
+	if !ok || a.State["x"] != 1.5 {
+		t.Errorf("restored agent = %v, want x=1.5", a)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - id: drain
+    priority: 5
+    match: kind == "prey"
+    when: state.energy > 0 && !has("dead")
+    set:
+      energy: max(state.energy - 2, 0)
+  - id: starve
+    when: state["energy"] == 0
+    emit:
+      - type: starved
+        data: {at: step, who: "id + \"!\""}
+`))
+	if err != nil {
+		t.Fatalf("ParseRules() error = %v", err)
+	}
+
+	metrics := &testMetrics{}
+	m := New("m", metrics)
+	for _, r := range rules {
+		m.AddRule(r)
+	}
+	m.AddAgent(&MatrixAgent{ID: "p", Type: "prey", State: map[string]interface{}{"energy": 3}})
+	m.AddAgent(&MatrixAgent{ID: "w", Type: "wolf", State: map[string]interface{}{"energy": 5.0}})
+
+	for i := 0; i < 2; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+
+	p, _ := m.GetAgent("p")
+	if p.State["energy"] != 0.0 {
+		t.Errorf("prey energy = %v, want 0", p.State["energy"])
+	}
+	w, _ := m.GetAgent("w")
+	if w.State["energy"] != 5.0 {
+		t.Errorf("wolf energy = %v, want unchanged 5", w.State["energy"])
+	}
+	last := metrics.events[len(metrics.events)-1]
+	if last.Type != "starved" || last.AgentID != "p" || last.Data["at"] != 1.0 || last.Data["who"] != "p!" {
+		t.Errorf("last event = %+v, want starved p at step 1", last)
+	}
+
+	invalid := []string{
+		"rules: [{id: a, when: state.x >}]",
+		"rules: [{id: a}]",
+		"rules: [{id: a, set: {x: '1'}}, {id: a, set: {x: '2'}}]",
+	}
+	for _, doc := range invalid {
+		if _, err := ParseRules([]byte(doc)); err == nil {
+			t.Errorf("ParseRules(%q) succeeded, want error", doc)
+		}
+	}
+}
+
+func TestMatrix_ParallelConflicts(t *testing.T) {
+	setX := func(id string, v float64) Rule {
+		return Rule{ID: id, Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+			return []Event{{Type: EventStateChanged, AgentID: "a", Data: map[string]interface{}{"x": v}}}, nil
+		}}
+	}
+	tests := []struct {
+		strategy ConflictStrategy
+		want     interface{}
+		wantErr  error
+	}{
+		{ConflictPriority, 1.0, nil},
+		{ConflictLastWriter, 2.0, nil},
+		{ConflictError, 0.0, ErrStateConflict},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			m.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{"x": 0.0}})
+			m.AddRule(setX("first", 1))
+			m.AddRule(setX("second", 2))
+			if err := m.SetParallel(ParallelConfig{Workers: 4, Conflict: tt.strategy}); err != nil {
+				t.Fatalf("SetParallel() error = %v", err)
+			}
+
+			if err := m.Step(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Step() error = %v, want %v", err, tt.wantErr)
+			}
+			a, _ := m.GetAgent("a")
+			if a.State["x"] != tt.want {
+				t.Errorf("x = %v, want %v", a.State["x"], tt.want)
+			}
+			if m.ConflictCount() != 1 {
+				t.Errorf("ConflictCount() = %d, want 1", m.ConflictCount())
+			}
+		})
+	}
+}
+
+func TestMatrix_ParallelFailedEmit(t *testing.T) {
+	m := New("m", &testMetrics{})
+	m.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{"x": 0.0}})
+	m.AddRule(Rule{ID: "set", Priority: 1, Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: EventStateChanged, AgentID: "a", Data: map[string]interface{}{"x": 1.0}}}, nil
+	}})
+	m.AddRule(Rule{ID: "stray", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: EventStateChanged, AgentID: "nobody", Data: map[string]interface{}{"x": 1.0}}}, nil
+	}})
+	if err := m.SetParallel(ParallelConfig{Workers: 2}); err != nil {
+		t.Fatalf("SetParallel() error = %v", err)
+	}
+
+	// set's event is applied before stray's fails, and must be undone
+	if err := m.Step(context.Background()); !errors.Is(err, ErrAgentNotFound) {
+		t.Fatalf("Step() error = %v, want ErrAgentNotFound", err)
+	}
+	if a, _ := m.GetAgent("a"); a.State["x"] != 0.0 {
+		t.Errorf("x = %v after a failed step, want 0", a.State["x"])
+	}
+}
+
+// benchmarkStep steps a matrix of CPU-bound rules with the given workers
+func benchmarkStep(b *testing.B, workers int) {
+	m := New("bench", &testMetrics{})
+	for i := 0; i < 200; i++ {
+		m.AddAgent(&MatrixAgent{ID: fmt.Sprintf("a%d", i), State: map[string]interface{}{"v": float64(i)}})
+	}
+	for r := 0; r < 16; r++ {
+		key := fmt.Sprintf("r%d", r)
+		m.AddRule(Rule{ID: key, Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+			m.agentMu.RLock()
+			defer m.agentMu.RUnlock()
+			sum := 0.0
+			for _, a := range m.agents {
+				v := a.State["v"].(float64)
+				for k := 0; k < 200; k++ {
+					sum += math.Sqrt(v + float64(k))
+				}
+			}
+			return []Event{{Type: "sum", Data: map[string]interface{}{key: sum}}}, nil
+		}})
+	}
+	if err := m.SetParallel(ParallelConfig{Workers: workers}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMatrix_StepSequential(b *testing.B) { benchmarkStep(b, 1) }
+
+func BenchmarkMatrix_StepParallel(b *testing.B) { benchmarkStep(b, runtime.NumCPU()) }
+
+func TestMatrix_AgentLifecycle(t *testing.T) {
+	m := New("m", &testMetrics{})
+	for i := 0; i < 5; i++ {
+		typ := "prey"
+		if i%2 == 1 {
+			typ = "wolf"
+		}
+		m.AddAgent(&MatrixAgent{ID: fmt.Sprintf("a%d", i), Type: typ, State: map[string]interface{}{"n": float64(i)}})
+	}
+	m.AddRule(Rule{ID: "inc", Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+		return []Event{{Type: EventStateChanged, AgentID: "a0", Data: map[string]interface{}{"n": 10.0}}}, nil
+	}})
+
+	if err := m.SetAgentEnabled("a0", false); err != nil {
+		t.Fatalf("SetAgentEnabled() error = %v", err)
+	}
+	if err := m.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	a0, _ := m.GetAgent("a0")
+	if a0.State["n"] != 0.0 {
+		t.Errorf("disabled agent state changed to %v", a0.State["n"])
+	}
+
+	page, next, err := m.ListAgents(AgentFilters{Type: "prey", Status: AgentStatusEnabled, Limit: 1})
+	if err != nil || len(page) != 1 || page[0].ID != "a2" || next != "a2" {
+		t.Fatalf("ListAgents() = %v, %q, %v, want [a2] with cursor", page, next, err)
+	}
+	page, next, _ = m.ListAgents(AgentFilters{Type: "prey", Status: AgentStatusEnabled, After: next, Limit: 1})
+	if len(page) != 1 || page[0].ID != "a4" || next != "" {
+		t.Errorf("second page = %v, %q, want [a4] and no cursor", page, next)
+	}
+	if page, _, _ = m.ListAgents(AgentFilters{Where: "state.n >= 3"}); len(page) != 2 {
+		t.Errorf("ListAgents(Where) returned %d agents, want 2", len(page))
+	}
+
+	version, err := m.UpdateAgentState("a1", 0, map[string]interface{}{"n": 7.0})
+	if err != nil || version != 1 {
+		t.Fatalf("UpdateAgentState() = %d, %v, want 1", version, err)
+	}
+	if _, err := m.UpdateAgentState("a1", 0, map[string]interface{}{"n": 8.0}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("stale UpdateAgentState() error = %v, want ErrVersionConflict", err)
+	}
+
+	if err := m.RemoveAgent("a1"); err != nil {
+		t.Fatalf("RemoveAgent() error = %v", err)
+	}
+	if err := m.RemoveAgent("a1"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("second RemoveAgent() error = %v, want ErrAgentNotFound", err)
+	}
+}
+
+// matrixTickWasm is an agent whose on_matrix_tick returns a fixed output:
+//
+//	(module (memory (export "memory") 1)
+//	  (data (i32.const 16) "{\"state\":{\"ticked\":true}}")
+//	  (func (export "alloc") (param i32) (result i32) i32.const 1024)
+//	  (func (export "on_matrix_tick") (param i64 i32 i32) (result i64)
+//	    i64.const 0x1000000019)) ;; ptr 16, len 25
+var matrixTickWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x0d, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, // type section: (i32) -> i32,
+	0x60, 0x03, 0x7e, 0x7f, 0x7f, 0x01, 0x7e, // (i64, i32, i32) -> i64
+	0x03, 0x03, 0x02, 0x00, 0x01, // function section
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: min 1
+	0x07, 0x23, 0x03, // export section
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x0e, 'o', 'n', '_', 'm', 'a', 't', 'r', 'i', 'x', '_', 't', 'i', 'c', 'k', 0x00, 0x01,
+	0x0a, 0x11, 0x02, // code section
+	0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, // i32.const 1024; end
+	0x09, 0x00, 0x42, 0x99, 0x80, 0x80, 0x80, 0x80, 0x02, 0x0b, // i64.const; end
+	0x0b, 0x1f, 0x01, 0x00, 0x41, 0x10, 0x0b, 0x19, // data section at 16, 25 bytes
+	'{', '"', 's', 't', 'a', 't', 'e', '"', ':', '{', '"', 't', 'i', 'c', 'k', 'e', 'd', '"', ':', 't', 'r', 'u', 'e', '}', '}',
+}
+
+func TestMatrix_BindAgent(t *testing.T) {
+	ctx := context.Background()
+	a, err := agent.New(ctx, agent.Config{ID: "w", Code: matrixTickWasm, Verifier: agent.NewArtifactVerifier(true, nil)}, agent.ResourceLimits{MaxMemoryPages: 1})
+	if err != nil {
+		t.Fatalf("agent.New() error = %v", err)
+	}
+	defer a.Stop(ctx)
+	if err := a.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	m := New("m", &testMetrics{})
+	if err := m.BindAgent("w", a, 0); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("BindAgent() before AddAgent error = %v, want ErrAgentNotFound", err)
+	}
+	m.AddAgent(&MatrixAgent{ID: "w", Type: "wasm", State: map[string]interface{}{}})
+	if err := m.BindAgent("w", a, 0); err != nil {
+		t.Fatalf("BindAgent() error = %v", err)
+	}
+	if err := m.Step(ctx); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	w, _ := m.GetAgent("w")
+	if w.State["ticked"] != true {
+		t.Errorf("state = %v, want ticked", w.State)
+	}
+
+	m.UnbindAgent("w")
+	if err := m.BindAgent("w", a, 0); err != nil {
+		t.Errorf("BindAgent() after UnbindAgent error = %v", err)
+	}
+}
+
+func TestMatrix_Watch(t *testing.T) {
+	m := New("m", &testMetrics{})
+	bus := transport.NewEventBus()
+	defer bus.Close()
+	m.SetEventBus(bus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	busEvents := bus.Subscribe(ctx, transport.EventTypeMatrix)
+	watched := m.Watch(ctx, WatchFilters{Types: []string{"ping"}, RuleIDs: []string{"pinger"}})
+
+	m.AddAgent(&MatrixAgent{ID: "a"})
+	m.AddRule(Rule{ID: "pinger", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: "ping", AgentID: "a"}, {Type: "pong", AgentID: "a"}}, nil
+	}})
+	if err := m.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	entry := <-watched
+	if entry.Event.Type != "ping" || entry.RuleID != "pinger" || entry.Step != 0 {
+		t.Errorf("watched %+v, want ping from pinger at step 0", entry)
+	}
+	select {
+	case extra := <-watched:
+		t.Errorf("watched unfiltered event %+v", extra)
+	default:
+	}
+
+	// agent_added, ping, pong
+	for _, want := range []string{EventAgentAdded, "ping", "pong"} {
+		if e := <-busEvents; e.Data["event"] != want || e.Source != "m" {
+			t.Errorf("bus event = %v, want %s", e.Data, want)
+		}
+	}
+
+	cancel()
+	if _, ok := <-watched; ok {
+		t.Error("watch channel still open after cancel")
+	}
+}
+
+func TestScenario_Build(t *testing.T) {
+	doc := []byte(`
+id: herd
+seed: 7
+populations:
+  - type: prey
+    count: 3
+    state:
+      energy: {uniform: [5, 10]}
+      color: {choice: [brown, grey]}
+      alive: {value: true}
+agents:
+  - {id: wolf, type: wolf, state: {energy: 20}}
+rules:
+  - {id: drain, when: state.energy > 0, set: {energy: state.energy - 1}}
+termination:
+  max_steps: 4
+`)
+	s, err := ParseScenario(doc)
+	if err != nil {
+		t.Fatalf("ParseScenario() error = %v", err)
+	}
+
+	m1, err := s.Build(&testMetrics{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	m2, _ := s.Build(&testMetrics{})
+	if agents, _, _ := m1.ListAgents(AgentFilters{}); len(agents) != 4 {
+		t.Fatalf("built %d agents, want 4", len(agents))
+	}
+	for _, id := range []string{"prey-0", "prey-1", "prey-2"} {
+		a1, ok := m1.GetAgent(id)
+		if !ok {
+			t.Fatalf("agent %s missing", id)
+		}
+		a2, _ := m2.GetAgent(id)
+		if fmt.Sprint(a1.State) != fmt.Sprint(a2.State) {
+			t.Errorf("agent %s state %v differs from %v with the same seed", id, a1.State, a2.State)
+		}
+		if e := a1.State["energy"].(float64); e < 5 || e >= 10 || a1.State["alive"] != true {
+			t.Errorf("agent %s state = %v, want energy in [5, 10) and alive", id, a1.State)
+		}
+	}
+
+	cfg := s.RunConfig(RunConfig{Mode: RunAsFastAsPossible})
+	if cfg.MaxSteps != 4 {
+		t.Fatalf("RunConfig().MaxSteps = %d, want 4", cfg.MaxSteps)
+	}
+	if err := m1.Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if wolf, _ := m1.GetAgent("wolf"); wolf.State["energy"] != 16.0 {
+		t.Errorf("wolf energy = %v, want 16", wolf.State["energy"])
+	}
+
+	invalid := []string{
+		"populations: [{type: a}]",
+		"{id: s, populations: [{type: a, count: 1, state: {x: {uniform: [2, 1]}}}]}",
+		"{id: s, populations: [{type: a, count: 1, state: {x: {value: 1, choice: [2]}}}]}",
+		"{id: s, rules: [{id: r, when: state.x >}]}",
+	}
+	for _, doc := range invalid {
+		if _, err := ParseScenario([]byte(doc)); err == nil {
+			t.Errorf("ParseScenario(%q) succeeded, want error", doc)
+		}
+	}
+}
+
+func TestMatrix_SeededRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - {id: wander, when: rand() < 0.5, set: {x: state.x + rand()}}
+  - {id: jitter, set: {y: rand()}}
+`))
+	if err != nil {
+		t.Fatalf("ParseRules() error = %v", err)
+	}
+
+	run := func(seed int64, workers int) map[string]string {
+		m := New("m", &testMetrics{})
+		m.SetSeed(seed)
+		if err := m.SetParallel(ParallelConfig{Workers: workers, Conflict: ConflictLastWriter}); err != nil {
+			t.Fatalf("SetParallel() error = %v", err)
+		}
+		for _, r := range rules {
+			m.AddRule(r)
+		}
+		var drawn []int
+		m.AddRule(Rule{ID: "draw", Evaluate: func(ctx context.Context, m *Matrix) ([]Event, error) {
+			drawn = append(drawn, Rand(ctx).Intn(1000))
+			return nil, nil
+		}})
+		for i := 0; i < 5; i++ {
+			m.AddAgent(&MatrixAgent{ID: fmt.Sprint("a", i), State: map[string]interface{}{"x": 0.0}})
+		}
+		for i := 0; i < 5; i++ {
+			if err := m.Step(context.Background()); err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+		}
+		if snap := m.Snapshot(); snap.Seed != seed {
+			t.Errorf("Snapshot().Seed = %d, want %d", snap.Seed, seed)
+		}
+
+		states := map[string]string{"draw": fmt.Sprint(drawn)}
+		agents, _, _ := m.ListAgents(AgentFilters{})
+		for _, a := range agents {
+			states[a.ID] = fmt.Sprint(a.State)
+		}
+		return states
+	}
+
+	first := run(42, 1)
+	if again := fmt.Sprint(run(42, 1)); again != fmt.Sprint(first) {
+		t.Errorf("same seed diverged:\n%v\n%v", first, again)
+	}
+	if parallel := fmt.Sprint(run(42, 4)); parallel != fmt.Sprint(first) {
+		t.Errorf("parallel run diverged:\n%v\n%v", first, parallel)
+	}
+	if other := fmt.Sprint(run(7, 1)); other == fmt.Sprint(first) {
+		t.Error("different seeds produced identical runs")
+	}
+}
+
+func TestMatrix_Space(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    SpaceConfig
+		radius float64
+		want   string // Neighbors of "c" at (5, 5)
+	}{
+		{"grid moore", SpaceConfig{Kind: SpaceGrid, Width: 10, Height: 10}, 1, "[diag east]"},
+		{"continuous", SpaceConfig{Width: 10, Height: 10, CellSize: 2}, 1, "[east]"},
+		{"wrapped", SpaceConfig{Kind: SpaceGrid, Width: 10, Height: 10, Wrap: true}, 5, "[corner diag east far]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			if err := m.SetSpace(tt.cfg); err != nil {
+				t.Fatalf("SetSpace() error = %v", err)
+			}
+			positions := map[string][2]float64{"c": {5, 5}, "east": {6, 5}, "diag": {6, 6}, "far": {9, 9}, "corner": {0, 0}}
+			for id, p := range positions {
+				m.AddAgent(&MatrixAgent{ID: id, State: map[string]interface{}{PositionX: p[0], PositionY: p[1]}})
+			}
+			m.AddAgent(&MatrixAgent{ID: "nowhere"})
+
+			neighbors, err := m.Neighbors("c", tt.radius)
+			if err != nil {
+				t.Fatalf("Neighbors() error = %v", err)
+			}
+			var ids []string
+			for _, a := range neighbors {
+				ids = append(ids, a.ID)
+			}
+			if fmt.Sprint(ids) != tt.want {
+				t.Errorf("Neighbors() = %v, want %s", ids, tt.want)
+			}
+		})
+	}
+
+	// Moves are clamped, reindexed, and visible to rules
+	m := New("m", &testMetrics{})
+	m.SetSpace(SpaceConfig{Kind: SpaceGrid, Width: 4, Height: 4})
+	m.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{PositionX: 0, PositionY: 0}})
+	m.AddAgent(&MatrixAgent{ID: "b", State: map[string]interface{}{PositionX: 3, PositionY: 3}})
+	m.AddRule(Rule{ID: "move", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: EventAgentMoved, AgentID: "a", Data: map[string]interface{}{PositionX: 9.5, PositionY: 2.5}}}, nil
+	}})
+	crowd, err := CompileRule(RuleSpec{ID: "crowd", Priority: -1, When: "neighbors(1) > 0", Set: map[string]string{"crowded": "true"}})
+	if err != nil {
+		t.Fatalf("CompileRule() error = %v", err)
+	}
+	m.AddRule(crowd)
+	if err := m.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if x, y, _ := m.Position("a"); x != 3 || y != 2 {
+		t.Errorf("Position(a) = (%v, %v), want (3, 2)", x, y)
+	}
+	if b, _ := m.GetAgent("b"); b.State["crowded"] != true {
+		t.Errorf("b state = %v, want crowded after a moved next to it", b.State)
+	}
+	if got := m.AgentsWithin(0, 0, 1); len(got) != 0 {
+		t.Errorf("AgentsWithin(origin) = %d agents, want a to have left", len(got))
+	}
+}
+
+func TestMatrix_RunTermination(t *testing.T) {
+	drain, err := CompileRule(RuleSpec{ID: "drain", When: "state.energy > 0", Set: map[string]string{"energy": "state.energy - 1"}})
+	if err != nil {
+		t.Fatalf("CompileRule() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       RunConfig
+		stop      func(*Matrix)
+		want      StopReason
+		wantSteps uint64
+	}{
+		{"max steps", RunConfig{MaxSteps: 2}, nil, StopMaxSteps, 2},
+		{"condition", RunConfig{StopWhen: `total("energy") == 0 && count("prey") == 2`}, nil, StopCondition, 3},
+		{"max duration", RunConfig{MaxDuration: 20 * time.Millisecond, Mode: RunRealTime, TickInterval: time.Millisecond}, nil, StopMaxDuration, 0},
+		{"stopped", RunConfig{Mode: RunRealTime, TickInterval: time.Millisecond}, func(m *Matrix) { m.Stop() }, StopStopped, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			m.AddRule(drain)
+			m.AddAgent(&MatrixAgent{ID: "a", Type: "prey", State: map[string]interface{}{"energy": 3}})
+			m.AddAgent(&MatrixAgent{ID: "b", Type: "prey", State: map[string]interface{}{"energy": 1}})
+			if tt.cfg.Mode == "" {
+				tt.cfg.Mode = RunAsFastAsPossible
+			}
+			if tt.stop != nil {
+				go func() {
+					for m.StepCount() < 2 {
+						time.Sleep(time.Millisecond)
+					}
+					tt.stop(m)
+				}()
+			}
+
+			if err := m.Run(context.Background(), tt.cfg); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			result, ok := m.LastRunResult()
+			if !ok {
+				t.Fatal("LastRunResult() found no result")
+			}
+			if result.Reason != tt.want {
+				t.Errorf("Reason = %s, want %s", result.Reason, tt.want)
+			}
+			if tt.wantSteps > 0 && result.Steps != tt.wantSteps {
+				t.Errorf("Steps = %d, want %d", result.Steps, tt.wantSteps)
+			}
+			if want := uint64(min(result.Steps, 1) + min(result.Steps, 3)); result.EventCounts[EventStateChanged] != want {
+				t.Errorf("EventCounts = %v, want %d state changes", result.EventCounts, want)
+			}
+		})
+	}
+
+	m := New("m", &testMetrics{})
+	if err := m.Run(context.Background(), RunConfig{StopWhen: "count("}); err == nil {
+		t.Error("Run() with an invalid stop condition succeeded")
+	}
+}
+
+func TestRunExperiment(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	exp := Experiment{
+		ID: "exp",
+		Scenario: []byte(`
+id: herd
+seed: 10
+populations:
+  - {type: prey, count: {{.prey}}, state: {energy: {value: 0}}}
+rules:
+  - {id: grow, when: rand() < 0.5, set: {energy: state.energy + 1}}
+termination:
+  when: total("energy") >= {{.target}}
+`),
+		Sweep:       map[string][]interface{}{"prey": {1, 5}, "target": {3}},
+		Repeats:     2,
+		Parallelism: 2,
+		Run:         RunConfig{MaxSteps: 1000},
+		NewMetrics:  func(string) MetricsCollector { return &testMetrics{} },
+	}
+	runs, err := RunExperiment(context.Background(), store, exp)
+	if err != nil {
+		t.Fatalf("RunExperiment() error = %v", err)
+	}
+	if len(runs) != 4 {
+		t.Fatalf("got %d runs, want 4", len(runs))
+	}
+	for i, run := range runs {
+		if run.Error != "" || run.Result == nil || run.Result.Reason != StopCondition {
+			t.Errorf("run %d = %+v, want it to stop on its condition", i, run)
+		}
+		if want := int64(10 + i%2); run.Seed != want {
+			t.Errorf("run %d seed = %d, want %d", i, run.Seed, want)
+		}
+	}
+
+	saved, err := LoadExperimentRuns(store, "exp")
+	if err != nil {
+		t.Fatalf("LoadExperimentRuns() error = %v", err)
+	}
+	if len(saved) != 4 || saved[3].Params["prey"] != 5.0 || saved[3].Result.Steps != runs[3].Result.Steps {
+		t.Errorf("saved runs = %+v, want the 4 runs", saved)
+	}
+
+	endless := Experiment{
+		ID:         "endless",
+		Scenario:   []byte("id: idle"),
+		NewMetrics: exp.NewMetrics,
+	}
+	runs, err = RunExperiment(context.Background(), store, endless)
+	if err != nil {
+		t.Fatalf("RunExperiment() error = %v", err)
+	}
+	if len(runs) != 1 || runs[0].Error == "" {
+		t.Errorf("runs = %+v, want a run failing for lack of termination", runs)
+	}
+}
+
+func TestMatrix_Fork(t *testing.T) {
+	drain, _ := CompileRule(RuleSpec{ID: "drain", When: "state.energy > 0", Set: map[string]string{"energy": "state.energy - 1"}})
+	parent := New("parent", &testMetrics{})
+	parent.AddRule(drain)
+	parent.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{"energy": 5.0}})
+	if err := parent.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	fork, err := parent.Fork("fork", &testMetrics{})
+	if err != nil {
+		t.Fatalf("Fork() error = %v", err)
+	}
+	if lineage, ok := fork.Lineage(); !ok || lineage.ParentID != "parent" || lineage.Step != 1 {
+		t.Errorf("Lineage() = %+v, %v, want parent at step 1", lineage, ok)
+	}
+	if _, ok := parent.Lineage(); ok {
+		t.Error("parent has a lineage")
+	}
+	if diffs := parent.Diff(fork); len(diffs) != 0 {
+		t.Errorf("Diff() right after fork = %+v, want none", diffs)
+	}
+
+	// The fork diverges under an extra rule while the parent continues
+	fork.AddRule(Rule{ID: "spawn", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: EventAgentAdded, AgentID: "b", Data: map[string]interface{}{"state": map[string]interface{}{}}}}, nil
+	}})
+	for _, m := range []*Matrix{parent, fork} {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+	parent.Step(context.Background())
+
+	want := "[{a energy 2 3} {b  <nil> map[]}]"
+	if diffs := parent.Diff(fork); fmt.Sprint(diffs) != want {
+		t.Errorf("Diff() = %v, want %s", diffs, want)
+	}
+	if snap := fork.Snapshot(); snap.Parent == nil || snap.Parent.ParentID != "parent" {
+		t.Errorf("fork Snapshot().Parent = %+v, want parent lineage", snap.Parent)
+	}
+	if _, err := parent.Fork("parent", &testMetrics{}); err == nil {
+		t.Error("Fork() with the parent's ID succeeded")
+	}
+}
+
+// timingMetrics records step performance reports
+type timingMetrics struct {
+	testMetrics
+	rules []string
+	steps []int
+}
+
+func (t *timingMetrics) RecordRule(ruleID string, latency time.Duration, events int) {
+	t.rules = append(t.rules, fmt.Sprintf("%s:%d", ruleID, events))
+}
+
+func (t *timingMetrics) RecordStep(duration time.Duration, events int) {
+	t.steps = append(t.steps, events)
+}
+
+func TestMatrix_RuleStats(t *testing.T) {
+	metrics := &timingMetrics{}
+	m := New("m", metrics)
+	m.AddAgent(&MatrixAgent{ID: "a"})
+	m.AddRule(Rule{ID: "slow", Priority: 1, Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		time.Sleep(2 * time.Millisecond)
+		return []Event{{Type: "tick", AgentID: "a"}, {Type: "tock", AgentID: "a"}}, nil
+	}})
+	m.AddRule(Rule{ID: "fast", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return nil, nil
+	}})
+
+	for i := 0; i < 2; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+
+	stats := m.RuleStats()
+	slow, fast := stats["slow"], stats["fast"]
+	if slow.Evaluations != 2 || slow.Events != 4 || fast.Evaluations != 2 || fast.Events != 0 {
+		t.Errorf("RuleStats() = %+v, want 2 evaluations each and 4 slow events", stats)
+	}
+	if slow.Mean() < 2*time.Millisecond || slow.Max < slow.Mean() || fast.Mean() >= slow.Mean() {
+		t.Errorf("slow mean %v max %v, fast mean %v, want slow to stand out", slow.Mean(), slow.Max, fast.Mean())
+	}
+	if want := "[slow:2 fast:0 slow:2 fast:0]"; fmt.Sprint(metrics.rules) != want {
+		t.Errorf("recorded rules %v, want %s", metrics.rules, want)
+	}
+	if fmt.Sprint(metrics.steps) != "[2 2]" {
+		t.Errorf("recorded steps %v, want 2 events per step", metrics.steps)
+	}
+}
+
+// memoryHub is an in-process FederationTransport that, like pubsub,
+// delivers messages to every subscriber including the sender
+type memoryHub struct {
+	subs []chan transport.Message
+	mu   sync.Mutex
+}
+
+func (h *memoryHub) Publish(ctx context.Context, topic string, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.subs {
+		ch <- transport.Message{Topic: topic, Payload: data}
+	}
+	return nil
+}
+
+func (h *memoryHub) Subscribe(ctx context.Context, topic string) (<-chan transport.Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan transport.Message, 64)
+	h.subs = append(h.subs, ch)
+	return ch, nil
+}
+
+func TestMatrix_Federate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Pick one agent per shard
+	const shards = 3
+	ids := make([]string, shards)
+	for i := 0; ids[shards-1] == "" || ids[0] == "" || ids[1] == ""; i++ {
+		id := fmt.Sprint("agent-", i)
+		if s := ShardOf(id, shards); ids[s] == "" {
+			ids[s] = id
+		}
+	}
+
+	hub := &memoryHub{}
+	nodes := make([]*Matrix, shards)
+	for s := range nodes {
+		m := New("world", &testMetrics{})
+		cfg := FederationConfig{Shard: s, Shards: shards, Transport: hub, Timeout: 5 * time.Second}
+		if err := m.Federate(ctx, cfg); err != nil {
+			t.Fatalf("Federate() error = %v", err)
+		}
+		next := ids[(s+1)%shards]
+		if err := m.AddAgent(&MatrixAgent{ID: ids[s], State: map[string]interface{}{"next": next}}); err != nil {
+			t.Fatalf("AddAgent() error = %v", err)
+		}
+		if err := m.AddAgent(&MatrixAgent{ID: next}); err == nil {
+			t.Errorf("shard %d accepted agent %s of another shard", s, next)
+		}
+		// Each agent marks the next one in the ring, which lives on another shard
+		m.AddRule(Rule{ID: "mark", Evaluate: func(ctx context.Context, m *Matrix) ([]Event, error) {
+			a, _ := m.GetAgent(ids[s])
+			return []Event{{Type: EventStateChanged, AgentID: a.State["next"].(string), Data: map[string]interface{}{"marked_by": a.ID, "at": m.StepCount()}}}, nil
+		}})
+		nodes[s] = m
+	}
+
+	for step := 0; step < 2; step++ {
+		errs := make(chan error, shards)
+		for _, m := range nodes {
+			go func(m *Matrix) { errs <- m.Step(ctx) }(m)
+		}
+		for range nodes {
+			if err := <-errs; err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+		}
+	}
+
+	for s, m := range nodes {
+		a, _ := m.GetAgent(ids[s])
+		want := ids[(s+shards-1)%shards]
+		if a.State["marked_by"] != want || a.State["at"] != 1.0 {
+			t.Errorf("shard %d agent state = %v, want marked by %s at step 1", s, a.State, want)
+		}
+		if m.StepCount() != 2 {
+			t.Errorf("shard %d StepCount() = %d, want 2", s, m.StepCount())
+		}
+	}
+
+	// A shard left alone at the barrier times out
+	lonely := New("lonely", &testMetrics{})
+	lonely.Federate(ctx, FederationConfig{Shards: 2, Transport: &memoryHub{}, Timeout: 20 * time.Millisecond, Resend: 5 * time.Millisecond})
+	if err := lonely.Step(ctx); !errors.Is(err, ErrFederationTimeout) {
+		t.Errorf("Step() error = %v, want ErrFederationTimeout", err)
+	}
+}
+
+func TestMatrix_RewindTo(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	journal, err := NewJournal(store, "m")
+	if err != nil {
+		t.Fatalf("NewJournal() error = %v", err)
+	}
+	m := New("m", &testMetrics{})
+	m.SetJournal(journal)
+	m.SetSnapshotInterval(store, 2)
+	count, _ := CompileRule(RuleSpec{ID: "count", Set: map[string]string{"n": "step + 1"}})
+	m.AddRule(count)
+	m.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{"n": 0.0}})
+	for i := 0; i < 5; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+
+	counter := func() interface{} {
+		a, _ := m.GetAgent("a")
+		return a.State["n"]
+	}
+	for _, step := range []uint64{3, 5, 1, 0, 4} {
+		if err := m.RewindTo(context.Background(), step); err != nil {
+			t.Fatalf("RewindTo(%d) error = %v", step, err)
+		}
+		if got := counter(); got != float64(step) || m.StepCount() != step {
+			t.Errorf("after RewindTo(%d) n = %v at step %d", step, got, m.StepCount())
+		}
+	}
+	if err := m.RewindTo(context.Background(), 6); err == nil {
+		t.Error("RewindTo() past the journal succeeded")
+	}
+
+	m.RewindTo(context.Background(), 2)
+	if err := m.Step(context.Background()); !errors.Is(err, ErrRewound) {
+		t.Fatalf("Step() after rewind error = %v, want ErrRewound", err)
+	}
+	if err := m.DiscardFuture(); err != nil {
+		t.Fatalf("DiscardFuture() error = %v", err)
+	}
+	if err := m.Step(context.Background()); err != nil {
+		t.Fatalf("Step() after DiscardFuture error = %v", err)
+	}
+	if snap, err := NearestSnapshot(store, "m", 5); err != nil || snap.Step != 2 {
+		t.Errorf("NearestSnapshot(5) = %+v, %v, want step 2 after discarding later snapshots", snap, err)
+	}
+	if err := m.RewindTo(context.Background(), 3); err != nil || counter() != 3.0 {
+		t.Errorf("RewindTo(3) on the new timeline = %v, n = %v", err, counter())
+	}
+}
+
+func TestMatrix_Limits(t *testing.T) {
+	spam := Rule{ID: "spam", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: "a", AgentID: "x"}, {Type: "b", AgentID: "x"}, {Type: "c", AgentID: "x"}}, nil
+	}}
+	spawn := Rule{ID: "spawn", Evaluate: func(ctx context.Context, m *Matrix) ([]Event, error) {
+		id := fmt.Sprint("child-", m.StepCount())
+		return []Event{{Type: EventAgentAdded, AgentID: id, Data: map[string]interface{}{}}}, nil
+	}}
+	stall := Rule{ID: "stall", Evaluate: func(ctx context.Context, m *Matrix) ([]Event, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+
+	tests := []struct {
+		name   string
+		limits Limits
+		rule   Rule
+	}{
+		{"events", Limits{MaxEventsPerStep: 2}, spam},
+		{"agents", Limits{MaxAgents: 2}, spawn},
+		{"duration", Limits{MaxStepDuration: 10 * time.Millisecond}, stall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("m", &testMetrics{})
+			m.AddAgent(&MatrixAgent{ID: "x"})
+			if err := m.SetLimits(tt.limits); err != nil {
+				t.Fatalf("SetLimits() error = %v", err)
+			}
+			m.AddRule(tt.rule)
+
+			var err error
+			for i := 0; i < 3 && err == nil; i++ {
+				err = m.Step(context.Background())
+			}
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("Step() error = %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+
+	m := New("m", &testMetrics{})
+	m.SetLimits(Limits{MaxAgents: 1})
+	m.AddAgent(&MatrixAgent{ID: "a"})
+	if err := m.AddAgent(&MatrixAgent{ID: "b"}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("AddAgent() past MaxAgents error = %v, want ErrLimitExceeded", err)
+	}
+
+	// Run pauses on a breach and reports it until resumed
+	m = New("m", &testMetrics{})
+	m.AddAgent(&MatrixAgent{ID: "x"})
+	m.SetLimits(Limits{MaxEventsPerStep: 2, PauseOnExceed: true})
+	m.AddRule(spam)
+	done := make(chan error)
+	go func() { done <- m.Run(context.Background(), RunConfig{Mode: RunAsFastAsPossible}) }()
+	for m.Fault() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if m.State() != RunStatePaused || !errors.Is(m.Fault(), ErrLimitExceeded) {
+		t.Errorf("State() = %s, Fault() = %v, want paused on the breach", m.State(), m.Fault())
+	}
+	m.SetLimits(Limits{})
+	m.Resume()
+	for m.StepCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestMatrix_FailedStepRollsBack(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+
+	newMatrix := func() *Matrix {
+		j, err := NewJournal(store, "m")
+		if err != nil {
+			t.Fatalf("NewJournal() error = %v", err)
+		}
+		m := New("m", &testMetrics{})
+		m.SetJournal(j)
+		m.AddRule(Rule{ID: "grow", Priority: 1, Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+			a, _ := m.GetAgent("a")
+			n := a.State["n"].(float64)
+			return []Event{
+				{Type: EventStateChanged, AgentID: "a", Data: map[string]interface{}{"n": n + 1}},
+				{Type: EventAgentAdded, AgentID: fmt.Sprint("child-", m.StepCount()), Data: map[string]interface{}{}},
+				{Type: EventAgentRemoved, AgentID: "b"},
+			}, nil
+		}})
+		m.AddRule(Rule{ID: "spam", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+			return []Event{{Type: "a", AgentID: "a"}, {Type: "b", AgentID: "a"}}, nil
+		}})
+		return m
+	}
+
+	m := newMatrix()
+	m.AddAgent(&MatrixAgent{ID: "a", State: map[string]interface{}{"n": 0.0}})
+	m.AddAgent(&MatrixAgent{ID: "b"})
+	before := m.StateHash()
+
+	// grow's events are applied before spam breaches the limit, so the step
+	// must undo them
+	m.SetLimits(Limits{MaxEventsPerStep: 4})
+	if err := m.Step(context.Background()); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Step() error = %v, want ErrLimitExceeded", err)
+	}
+	if got := m.StateHash(); got != before {
+		t.Errorf("StateHash() changed after a failed step")
+	}
+	if _, ok := m.GetAgent("b"); !ok {
+		t.Error("agent b removed by a failed step")
+	}
+	if got := m.StepCount(); got != 0 {
+		t.Errorf("StepCount() = %d after a failed step, want 0", got)
+	}
+
+	// Retried, the step applies once and replays to the same hashes
+	m.SetLimits(Limits{})
+	if err := m.Step(context.Background()); err != nil {
+		t.Fatalf("retried Step() error = %v", err)
+	}
+	if a, _ := m.GetAgent("a"); a.State["n"] != 1.0 {
+		t.Errorf("n = %v after the retried step, want 1", a.State["n"])
+	}
+	if err := newMatrix().Replay(context.Background(), 0); err != nil {
+		t.Errorf("Replay() error = %v", err)
+	}
+}
+
+func TestMatrix_Schema(t *testing.T) {
+	zero, hundred := 0.0, 100.0
+	schema := &StateSchema{
+		Strict: true,
+		Fields: map[string]StateField{
+			"energy": {Type: "number", Required: true, Min: &zero, Max: &hundred},
+			"color":  {Type: "string", Default: "brown", Enum: []interface{}{"brown", "grey"}},
+		},
+	}
+	m := New("m", &testMetrics{})
+	if err := m.SetSchema("prey", schema); err != nil {
+		t.Fatalf("SetSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		state map[string]interface{}
+		ok    bool
+	}{
+		{"valid", map[string]interface{}{"energy": 5}, true},
+		{"missing required", map[string]interface{}{}, false},
+		{"wrong type", map[string]interface{}{"energy": "lots"}, false},
+		{"below min", map[string]interface{}{"energy": -1.0}, false},
+		{"not in enum", map[string]interface{}{"energy": 1, "color": "red"}, false},
+		{"unknown key", map[string]interface{}{"energy": 1, "speed": 2}, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.AddAgent(&MatrixAgent{ID: fmt.Sprint(i), Type: "prey", State: tt.state})
+			if (err == nil) != tt.ok {
+				t.Errorf("AddAgent() error = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidState) {
+				t.Errorf("AddAgent() error = %v, want ErrInvalidState", err)
+			}
+		})
+	}
+
+	a, _ := m.GetAgent("0")
+	if a.State["color"] != "brown" {
+		t.Errorf("state = %v, want default color", a.State)
+	}
+	if _, err := m.UpdateAgentState("0", a.Version(), map[string]interface{}{"energy": 500}); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("UpdateAgentState() error = %v, want ErrInvalidState", err)
+	}
+
+	m.AddRule(Rule{ID: "overfeed", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: EventStateChanged, AgentID: "0", Data: map[string]interface{}{"energy": 101.0}}}, nil
+	}})
+	if err := m.Step(context.Background()); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Step() error = %v, want ErrInvalidState from the rule", err)
+	}
+	if a.State["energy"] != 5 {
+		t.Errorf("energy = %v, want the invalid change rejected", a.State["energy"])
+	}
+
+	if err := m.SetSchema("prey", &StateSchema{Fields: map[string]StateField{"energy": {Type: "string"}}}); err == nil {
+		t.Error("SetSchema() that existing agents violate succeeded")
+	}
+}
+
+func TestMatrix_Sinks(t *testing.T) {
+	var mu sync.Mutex
+	var posted []SinkBatch
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch SinkBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decode batch: %v", err)
+		}
+		posted = append(posted, batch)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer file.Close()
+
+	m := New("m", &testMetrics{})
+	ctx, cancel := context.WithCancel(context.Background())
+	filters := WatchFilters{Types: []string{"ping"}}
+	webhook := m.AddSink(ctx, &WebhookSink{URL: server.URL}, SinkConfig{
+		Filters: filters, BatchSize: 2, FlushInterval: time.Hour, RetryBackoff: time.Millisecond,
+	})
+	files := m.AddSink(ctx, file, SinkConfig{Filters: filters, FlushInterval: time.Hour})
+
+	m.AddAgent(&MatrixAgent{ID: "a"})
+	m.AddRule(Rule{ID: "pinger", Evaluate: func(context.Context, *Matrix) ([]Event, error) {
+		return []Event{{Type: "ping", AgentID: "a"}}, nil
+	}})
+	for i := 0; i < 3; i++ {
+		if err := m.Step(context.Background()); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+	}
+
+	// Cancelling flushes the partial batches
+	cancel()
+	<-webhook.Done()
+	<-files.Done()
+
+	if stats := webhook.Stats(); stats.Sent != 3 || stats.Retries != 1 || stats.Dropped != 0 {
+		t.Errorf("webhook stats = %+v, want 3 sent after 1 retry", stats)
+	}
+	mu.Lock()
+	if len(posted) != 2 || len(posted[0].Entries) != 2 || posted[1].Entries[0].Step != 2 || posted[0].MatrixID != "m" {
+		t.Errorf("posted = %+v, want batches of 2 and 1", posted)
+	}
+	mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 || files.Stats().Sent != 3 {
+		t.Errorf("file sink wrote %d lines, want 3", lines)
+	}
+}
+
+func TestMatrix_SubMatrix(t *testing.T) {
+	herd := New("herd", &testMetrics{})
+	for _, id := range []string{"cow-1", "cow-2"} {
+		herd.AddAgent(&MatrixAgent{ID: id, Type: "cow", State: map[string]interface{}{"weight": 100.0}})
+	}
+	herd.AddRule(Rule{ID: "graze", Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+		var events []Event
+		for _, id := range []string{"cow-1", "cow-2"} {
+			a, _ := m.GetAgent(id)
+			events = append(events, Event{Type: EventStateChanged, AgentID: id, Data: map[string]interface{}{"weight": a.State["weight"].(float64) + 1}})
+		}
+		return events, nil
+	}})
+
+	farm := New("farm", &testMetrics{})
+	err := farm.AddSubMatrix(herd, SubMatrixConfig{
+		Steps:   2,
+		Outputs: map[string]string{"cows": `count("cow")`, "weight": `total("weight")`},
+	})
+	if err != nil {
+		t.Fatalf("AddSubMatrix() error = %v", err)
+	}
+	var seen interface{}
+	farm.AddRule(Rule{ID: "observe", Evaluate: func(_ context.Context, m *Matrix) ([]Event, error) {
+		a, _ := m.GetAgent("herd")
+		seen = a.State["weight"]
+		return nil, nil
+	}})
+
+	if err := farm.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if herd.StepCount() != 2 {
+		t.Errorf("herd steps = %d, want 2", herd.StepCount())
+	}
+	if seen != 204.0 {
+		t.Errorf("owner rule saw weight %v, want 204", seen)
+	}
+	proxy, _ := farm.GetAgent("herd")
+	if proxy.Type != SubMatrixType || proxy.State["cows"] != 2.0 {
+		t.Errorf("proxy = %s %v, want matrix with 2 cows", proxy.Type, proxy.State["cows"])
+	}
+
+	if err := herd.AddSubMatrix(farm, SubMatrixConfig{}); err == nil {
+		t.Error("AddSubMatrix() accepted a cycle")
+	}
+	if err := herd.Run(context.Background(), RunConfig{MaxSteps: 1}); err == nil {
+		t.Error("Run() of a sub-matrix succeeded")
+	}
+	if err := farm.RemoveSubMatrix("herd"); err != nil {
+		t.Fatalf("RemoveSubMatrix() error = %v", err)
+	}
+	if _, nested := herd.Owner(); nested || len(farm.SubMatrices()) != 0 {
+		t.Error("sub-matrix still attached after RemoveSubMatrix")
+	}
+}
+
+func TestExport(t *testing.T) {
+	entries := []JournalEntry{
+		{Seq: 0, Event: Event{Type: EventAgentAdded, AgentID: "wolf", Data: map[string]interface{}{"type": "predator"}}},
+		{Seq: 1, Event: Event{Type: EventAgentAdded, AgentID: "sheep", Data: map[string]interface{}{"type": "prey"}}},
+		{Seq: 2, Step: 1, RuleID: "hunt", Event: Event{Type: "attack", AgentID: "wolf", Data: map[string]interface{}{"target": "sheep"}}},
+		{Seq: 3, Step: 4, RuleID: "hunt", Event: Event{Type: "attack", AgentID: "wolf", Data: map[string]interface{}{"target": "sheep"}}},
+		{Seq: 4, Step: 4, RuleID: "flee", Event: Event{Type: "flee", AgentID: "sheep", Data: map[string]interface{}{"from": "wolf"}}},
+	}
+
+	graph := BuildInteractionGraph(entries, ExportOptions{})
+	want := []GraphEdge{{Source: "wolf", Target: "sheep", Weight: 2, First: 1, Last: 4}}
+	if fmt.Sprint(graph.Edges) != fmt.Sprint(want) || len(graph.Nodes) != 2 || graph.Nodes[1].Type != "predator" {
+		t.Errorf("graph = %+v, want one wolf->sheep edge of weight 2", graph)
+	}
+	if graph = BuildInteractionGraph(entries, ExportOptions{InteractionKey: "from"}); len(graph.Edges) != 1 || graph.Edges[0].Source != "sheep" {
+		t.Errorf("edges keyed by from = %+v", graph.Edges)
+	}
+
+	tests := []struct {
+		format ExportFormat
+		want   string
+	}{
+		{ExportCSV, `4,4,flee,flee,sheep,0001-01-01T00:00:00Z,"{""from"":""wolf""}"`},
+		{ExportGEXF, `<edge id="0" source="wolf" target="sheep" weight="2"></edge>`},
+		{ExportGraphML, `<data key="weight">2</data>`},
+		// PLAIN encoded values are prefixed with their length
+		{ExportParquet, "\x0f\x00\x00\x00" + `{"from":"wolf"}`},
+	}
+	for _, tt := range tests {
+		var buf strings.Builder
+		if err := Export(&buf, tt.format, entries, ExportOptions{}); err != nil {
+			t.Fatalf("Export(%s) error = %v", tt.format, err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("Export(%s) = %s, want it to contain %s", tt.format, buf.String(), tt.want)
+		}
+	}
+	for _, rows := range [][]JournalEntry{entries, nil} {
+		var buf bytes.Buffer
+		if err := Export(&buf, ExportParquet, rows, ExportOptions{}); err != nil {
+			t.Fatalf("Export(parquet) of %d rows error = %v", len(rows), err)
+		}
+		file := buf.Bytes()
+		footer := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+		if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) || footer > len(file)-12 {
+			t.Errorf("Export(parquet) of %d rows is not framed as a Parquet file", len(rows))
+		}
+	}
+	if err := Export(io.Discard, "xlsx", entries, ExportOptions{}); err == nil {
+		t.Error("Export() accepted an unknown format")
+	}
+}
+
+func TestMatrix_UpdateRule(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	j, err := NewJournal(store, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New("m", &testMetrics{})
+	m.SetJournal(j)
+	emits := func(kind string) func(context.Context, *Matrix) ([]Event, error) {
+		return func(context.Context, *Matrix) ([]Event, error) {
+			return []Event{{Type: kind}}, nil
+		}
+	}
+	m.AddRule(Rule{ID: "r", Evaluate: emits("v1")})
+	ctx := context.Background()
+	m.Step(ctx)
+
+	if v, err := m.UpdateRule(Rule{ID: "r", Evaluate: emits("v2")}); err != nil || v != 2 {
+		t.Fatalf("UpdateRule() = %d, %v, want version 2", v, err)
+	}
+	if v, _ := m.UpdateRule(Rule{ID: "extra", Priority: 1, Evaluate: emits("extra")}); v != 1 {
+		t.Errorf("UpdateRule() of a new rule = version %d, want 1", v)
+	}
+	m.Step(ctx)
+	if err := m.RemoveRule("extra"); err != nil {
+		t.Fatalf("RemoveRule() error = %v", err)
+	}
+	if err := m.RemoveRule("extra"); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("RemoveRule() of a removed rule error = %v, want ErrRuleNotFound", err)
+	}
+	m.Step(ctx)
+
+	entries, err := j.Entries(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%d:%s", entry.Step, entry.Event.Type))
+	}
+	want := "[0:v1 1:rule_updated 1:rule_added 1:extra 1:v2 2:rule_removed 2:v2]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("journal = %v, want %s", got, want)
+	}
+	if v, _ := m.RuleVersion("r"); v != 2 || m.RulesVersion() != 4 {
+		t.Errorf("versions = rule %d, set %d, want 2 and 4", v, m.RulesVersion())
+	}
+}
+
+func TestMatrix_StepHashes(t *testing.T) {
+	scenario, err := ParseScenario([]byte(`
+id: walk
+seed: 7
+populations:
+  - {type: walker, count: 5, state: {x: {uniform: [0, 10]}}}
+rules:
+  - {id: drift, set: {x: state.x + rand()}}
+`))
+	if err != nil {
+		t.Fatalf("ParseScenario() error = %v", err)
+	}
+
+	// run builds and steps the scenario on its own journal
+	run := func() (*Journal, *kv.Store) {
+		store, err := kv.New(kv.Config{Path: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := NewJournal(store, "walk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := scenario.Build(&testMetrics{})
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		m.SetJournal(j)
+		for i := 0; i < 3; i++ {
+			if err := m.Step(context.Background()); err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+		}
+		if ids := m.AgentIDs(); ids[0] != "walker-0" || len(ids) != 5 {
+			t.Errorf("AgentIDs() = %v", ids)
+		}
+		return j, store
+	}
+	left, store := run()
+	defer store.Close()
+	right, rightStore := run()
+	defer rightStore.Close()
+
+	leftHashes, err := left.StepHashes(0)
+	if err != nil {
+		t.Fatalf("StepHashes() error = %v", err)
+	}
+	rightHashes, _ := right.StepHashes(0)
+	if len(leftHashes) != 3 || fmt.Sprint(leftHashes) != fmt.Sprint(rightHashes) {
+		t.Errorf("step hashes differ between identical runs: %v and %v", leftHashes, rightHashes)
+	}
+	if leftHashes[0].Hash == leftHashes[1].Hash {
+		t.Error("consecutive steps have the same hash")
+	}
+
+	// Replay reproduces every hash, and detects a tampered one. The agents
+	// were added before the journal was set, so replay into a new build.
+	replayed, _ := scenario.Build(&testMetrics{})
+	replayed.SetJournal(left)
+	if err := replayed.Replay(context.Background(), 0); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	tampered := leftHashes[1]
+	tampered.Hash = strings.Repeat("0", 64)
+	value, _ := json.Marshal(tampered)
+	if err := store.Put(left.hashKey(1), value); err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ = scenario.Build(&testMetrics{})
+	replayed.SetJournal(left)
+	if err := replayed.Replay(context.Background(), 0); !errors.Is(err, ErrStateDiverged) {
+		t.Errorf("Replay() of a tampered journal error = %v, want ErrStateDiverged", err)
+	}
+}
@@ -0,0 +1,172 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrStateConflict is returned by Step under ConflictError when two rules
+// write the same agent state key
+var ErrStateConflict = errors.New("conflicting state writes")
+
+// EventStateConflict is recorded to metrics for every conflicting write.
+// Data holds the "key", the "winner" rule, and the "loser" rule.
+const EventStateConflict = "state_conflict"
+
+// ConflictStrategy decides which write wins when rules evaluated in
+// parallel set the same agent state key
+type ConflictStrategy string
+
+const (
+	// ConflictPriority keeps the write of the rule evaluated first: the
+	// highest priority, or the earliest added among equal priorities
+	ConflictPriority ConflictStrategy = "priority"
+	// ConflictLastWriter keeps the write of the rule evaluated last, as
+	// sequential evaluation would
+	ConflictLastWriter ConflictStrategy = "last_writer"
+	// ConflictError fails the step
+	ConflictError ConflictStrategy = "error"
+)
+
+// ParallelConfig controls concurrent rule evaluation. With more than one
+// worker every rule of a step is evaluated concurrently against the state
+// at the start of the step; events are then applied in priority order, so
+// rules no longer see writes made by higher priority rules in the same
+// step. Evaluate functions must only read the matrix.
+type ParallelConfig struct {
+	Workers  int              // Rules evaluated at once; 0 or 1 evaluates sequentially
+	Conflict ConflictStrategy // Empty uses ConflictPriority
+}
+
+// SetParallel configures concurrent rule evaluation for later steps
+func (m *Matrix) SetParallel(cfg ParallelConfig) error {
+	switch cfg.Conflict {
+	case "":
+		cfg.Conflict = ConflictPriority
+	case ConflictPriority, ConflictLastWriter, ConflictError:
+	default:
+		return fmt.Errorf("unknown conflict strategy %q", cfg.Conflict)
+	}
+
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+	m.parallel = cfg
+	return nil
+}
+
+// ConflictCount returns the number of conflicting state writes detected
+func (m *Matrix) ConflictCount() uint64 {
+	return m.conflicts.Load()
+}
+
+// ruleResult is the outcome of evaluating one rule
+type ruleResult struct {
+	events []Event
+	err    error
+}
+
+// stepParallel evaluates rules across a worker pool, then resolves state
+// conflicts and hands each rule's events to emit in priority order
+func (m *Matrix) stepParallel(ctx context.Context, rules []Rule, cfg ParallelConfig, evaluate func(context.Context, Rule) ([]Event, error), emit func(Rule, []Event) error) error {
+	results := make([]ruleResult, len(rules))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(cfg.Workers, len(rules)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				events, err := evaluate(ctx, rules[i])
+				results[i] = ruleResult{events: events, err: err}
+			}
+		}()
+	}
+	for i := range rules {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// Resolve in priority order, honoring exclusive preemption. Nothing is
+	// applied until every rule resolved; if emit then fails partway, Step
+	// rolls back the events already applied.
+	writers := make(map[[2]string]string) // Agent and key to the rule that wrote it
+	resolved := make([][]Event, 0, len(rules))
+	preempted := false
+	var preemptedBelow int
+	for i, rule := range rules {
+		if preempted && rule.Priority < preemptedBelow {
+			break
+		}
+		res := results[i]
+		if res.err != nil {
+			return fmt.Errorf("rule %s evaluation failed: %w", rule.ID, res.err)
+		}
+
+		events, err := m.resolveConflicts(rule, res.events, writers, cfg.Conflict)
+		if err != nil {
+			return err
+		}
+		resolved = append(resolved, events)
+
+		if rule.Exclusive && len(res.events) > 0 && !preempted {
+			preempted, preemptedBelow = true, rule.Priority
+		}
+	}
+
+	for i, events := range resolved {
+		if err := emit(rules[i], events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveConflicts checks a rule's state writes against those already
+// accepted this step and applies the strategy, returning the events to emit
+func (m *Matrix) resolveConflicts(rule Rule, events []Event, writers map[[2]string]string, strategy ConflictStrategy) ([]Event, error) {
+	resolved := make([]Event, 0, len(events))
+	for _, event := range events {
+		if event.Type != EventStateChanged {
+			resolved = append(resolved, event)
+			continue
+		}
+
+		data := make(map[string]interface{}, len(event.Data))
+		for key, value := range event.Data {
+			slot := [2]string{event.AgentID, key}
+			prev, written := writers[slot]
+			if !written || prev == rule.ID {
+				writers[slot] = rule.ID
+				data[key] = value
+				continue
+			}
+
+			m.conflicts.Add(1)
+			winner, loser := prev, rule.ID
+			switch strategy {
+			case ConflictError:
+				return nil, fmt.Errorf("%w: rules %s and %s both set %s on agent %s", ErrStateConflict, prev, rule.ID, key, event.AgentID)
+			case ConflictLastWriter:
+				winner, loser = rule.ID, prev
+				writers[slot] = rule.ID
+				data[key] = value
+			}
+			m.metrics.RecordEvent(Event{
+				Type:      EventStateConflict,
+				Timestamp: time.Now(),
+				AgentID:   event.AgentID,
+				Data:      map[string]interface{}{"key": key, "winner": winner, "loser": loser},
+			})
+		}
+		if len(data) > 0 {
+			event.Data = data
+			resolved = append(resolved, event)
+		}
+	}
+	return resolved, nil
+}
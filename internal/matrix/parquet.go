@@ -0,0 +1,260 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// parquetMagic opens and closes every Parquet file
+const parquetMagic = "PAR1"
+
+// Parquet physical types, converted types and enums used by the exporter
+const (
+	parquetInt64     int32 = 2
+	parquetByteArray int32 = 6
+
+	parquetUTF8            int32 = 0
+	parquetTimestampMicros int32 = 10
+	parquetUint64          int32 = 14
+	parquetJSON            int32 = 19
+
+	parquetRequired     int32 = 0
+	parquetPlain        int32 = 0
+	parquetRLE          int32 = 3
+	parquetUncompressed int32 = 0
+	parquetDataPage     int32 = 0
+)
+
+// parquetColumn is one column of the event stream export
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	// appendValue appends the entry's value, PLAIN encoded
+	appendValue func(values []byte, entry JournalEntry) ([]byte, error)
+}
+
+// parquetColumns mirrors the CSV export's columns
+var parquetColumns = []parquetColumn{
+	{"seq", parquetInt64, parquetUint64, func(b []byte, e JournalEntry) ([]byte, error) {
+		return binary.LittleEndian.AppendUint64(b, e.Seq), nil
+	}},
+	{"step", parquetInt64, parquetUint64, func(b []byte, e JournalEntry) ([]byte, error) {
+		return binary.LittleEndian.AppendUint64(b, e.Step), nil
+	}},
+	{"rule_id", parquetByteArray, parquetUTF8, func(b []byte, e JournalEntry) ([]byte, error) {
+		return appendParquetBytes(b, []byte(e.RuleID)), nil
+	}},
+	{"type", parquetByteArray, parquetUTF8, func(b []byte, e JournalEntry) ([]byte, error) {
+		return appendParquetBytes(b, []byte(e.Event.Type)), nil
+	}},
+	{"agent_id", parquetByteArray, parquetUTF8, func(b []byte, e JournalEntry) ([]byte, error) {
+		return appendParquetBytes(b, []byte(e.Event.AgentID)), nil
+	}},
+	{"timestamp", parquetInt64, parquetTimestampMicros, func(b []byte, e JournalEntry) ([]byte, error) {
+		return binary.LittleEndian.AppendUint64(b, uint64(e.Event.Timestamp.UnixMicro())), nil
+	}},
+	{"data", parquetByteArray, parquetJSON, func(b []byte, e JournalEntry) ([]byte, error) {
+		data, err := json.Marshal(e.Event.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event data: %w", err)
+		}
+		return appendParquetBytes(b, data), nil
+	}},
+}
+
+// appendParquetBytes appends a PLAIN encoded byte array: its length as a
+// little-endian uint32, then its bytes
+func appendParquetBytes(b, v []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+// exportParquet writes one row per entry as an uncompressed Parquet file
+// with a single row group, read by pandas.read_parquet
+func exportParquet(w io.Writer, entries []JournalEntry) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	var chunks []chunk
+	if len(entries) > 0 {
+		for _, col := range parquetColumns {
+			var values []byte
+			for _, entry := range entries {
+				var err error
+				if values, err = col.appendValue(values, entry); err != nil {
+					return err
+				}
+			}
+
+			header := newThriftWriter()
+			header.i32(1, parquetDataPage)
+			header.i32(2, int32(len(values)))
+			header.i32(3, int32(len(values)))
+			header.beginStruct(5)
+			header.i32(1, int32(len(entries)))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+			header.endStruct()
+			page := header.finish()
+
+			chunks = append(chunks, chunk{offset: int64(file.Len()), size: int64(len(page) + len(values))})
+			file.Write(page)
+			file.Write(values)
+		}
+	}
+
+	meta := newThriftWriter()
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(parquetColumns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(parquetColumns)))
+	meta.endStruct()
+	for _, col := range parquetColumns {
+		meta.beginElement()
+		meta.i32(1, col.typ)
+		meta.i32(3, parquetRequired)
+		meta.binary(4, col.name)
+		meta.i32(6, col.converted)
+		meta.endStruct()
+	}
+	meta.i64(3, int64(len(entries)))
+	meta.beginList(4, thriftStruct, min(len(chunks), 1))
+	if len(chunks) > 0 {
+		var total int64
+		for _, c := range chunks {
+			total += c.size
+		}
+		meta.beginElement()
+		meta.beginList(1, thriftStruct, len(chunks))
+		for i, col := range parquetColumns {
+			meta.beginElement()
+			meta.i64(2, chunks[i].offset)
+			meta.beginStruct(3)
+			meta.i32(1, col.typ)
+			meta.beginList(2, thriftI32, 1)
+			meta.listI32(parquetPlain)
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary(col.name)
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, int64(len(entries)))
+			meta.i64(6, chunks[i].size)
+			meta.i64(7, chunks[i].size)
+			meta.i64(9, chunks[i].offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(len(entries)))
+		meta.endStruct()
+	}
+	meta.binary(6, "matrix-core")
+	footer := meta.finish()
+
+	file.Write(footer)
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	file.WriteString(parquetMagic)
+	if _, err := file.WriteTo(w); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// Thrift compact protocol type IDs
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol structs Parquet uses for
+// page headers and file metadata
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // Last field ID written in each open struct
+}
+
+// newThriftWriter starts encoding a top-level struct
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+// finish closes the top-level struct and returns its encoding
+func (t *thriftWriter) finish() []byte {
+	t.endStruct()
+	return t.buf
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.listI32(v)
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+// beginElement opens a struct that is a list element
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// beginList writes a list header; the n elements follow
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+// listI32 writes an i32 value without a field header
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+// listBinary writes a binary value without a field header
+func (t *thriftWriter) listBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
@@ -0,0 +1,45 @@
+package matrix
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// randKey is the context key of a rule's random source
+type randKey struct{}
+
+// Rand returns the random source for the rule being evaluated. Each rule
+// gets its own source each step, derived from the matrix seed, the step,
+// and the rule ID, so a rule draws the same numbers on every run with the
+// same seed however rules are scheduled. Outside a step, Rand returns a
+// time-seeded source. The source must not be shared between goroutines.
+func Rand(ctx context.Context) *rand.Rand {
+	if rng, ok := ctx.Value(randKey{}).(*rand.Rand); ok {
+		return rng
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// SetSeed sets the seed rules draw random numbers from
+func (m *Matrix) SetSeed(seed int64) {
+	m.seed.Store(seed)
+}
+
+// Seed returns the seed rules draw random numbers from
+func (m *Matrix) Seed() int64 {
+	return m.seed.Load()
+}
+
+// ruleContext returns the context a rule is evaluated with at step
+func (m *Matrix) ruleContext(ctx context.Context, step uint64, ruleID string) context.Context {
+	h := fnv.New64a()
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(m.seed.Load()))
+	binary.BigEndian.PutUint64(buf[8:], step)
+	h.Write(buf[:])
+	h.Write([]byte(ruleID))
+	return context.WithValue(ctx, randKey{}, rand.New(rand.NewSource(int64(h.Sum64()))))
+}
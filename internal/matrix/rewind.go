@@ -0,0 +1,169 @@
+package matrix
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// ErrRewound is returned by Step on a matrix rewound behind its journal.
+// Rewind to the journal's head to continue, or call DiscardFuture to
+// continue from the past step.
+var ErrRewound = errors.New("matrix is rewound behind its journal")
+
+// snapshotSchedule saves a snapshot every few steps
+type snapshotSchedule struct {
+	store *kv.Store
+	every uint64
+}
+
+// SetSnapshotInterval saves a snapshot to store after every every steps,
+// giving RewindTo points to start from. Zero stops periodic snapshots.
+func (m *Matrix) SetSnapshotInterval(store *kv.Store, every uint64) {
+	if every == 0 {
+		m.snapshots.Store(nil)
+		return
+	}
+	m.snapshots.Store(&snapshotSchedule{store: store, every: every})
+}
+
+// RewindTo reconstructs the matrix's state as it was when step steps had
+// completed, including agents added or changed outside rules before the
+// next step, from the nearest periodic snapshot at or before step and the
+// journal after it, or from the whole journal without snapshots. The
+// journal is kept, so the matrix can be rewound to any step up to the
+// journal's head, but it cannot take steps until it is back at the head or
+// its future is discarded.
+func (m *Matrix) RewindTo(ctx context.Context, step uint64) error {
+	j := m.journal.Load()
+	if j == nil {
+		return ErrNoJournal
+	}
+	m.runMu.Lock()
+	running := m.run.running
+	m.runMu.Unlock()
+	if running {
+		return ErrAlreadyRunning
+	}
+	if head := j.completed(); step > head {
+		return fmt.Errorf("cannot rewind to step %d, the journal ends at step %d", step, head)
+	}
+
+	m.stepMu.Lock()
+	defer m.stepMu.Unlock()
+
+	base := uint64(0)
+	snap, err := m.nearestSnapshot(step)
+	if err != nil {
+		return err
+	}
+	if snap != nil {
+		if err := m.Restore(snap); err != nil {
+			return fmt.Errorf("failed to restore snapshot at step %d: %w", snap.Step, err)
+		}
+		base = snap.Step
+	} else {
+		m.agentMu.Lock()
+		m.agents = make(map[string]*MatrixAgent)
+		m.agentMu.Unlock()
+		if s := m.space.Load(); s != nil {
+			if err := m.SetSpace(s.cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries, err := j.Entries(base)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	for _, entry := range entries {
+		// Events outside rules at step happened before it was taken
+		if entry.Step > step || (entry.Step == step && entry.RuleID != "") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.applyEvent(entry.Event); err != nil {
+			return fmt.Errorf("failed to replay step %d: %w", entry.Step, err)
+		}
+	}
+	m.steps.Store(step)
+	return nil
+}
+
+// DiscardFuture drops the journal entries and periodic snapshots after the
+// matrix's current step, so a rewound matrix can take steps again
+func (m *Matrix) DiscardFuture() error {
+	step := m.steps.Load()
+	if j := m.journal.Load(); j != nil {
+		if err := j.Truncate(step); err != nil {
+			return err
+		}
+	}
+	if sched := m.snapshots.Load(); sched != nil {
+		if err := deleteSnapshotsAfter(sched.store, m.ID, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nearestSnapshot returns the latest periodic snapshot at or before step,
+// or nil if there is none
+func (m *Matrix) nearestSnapshot(step uint64) (*Snapshot, error) {
+	sched := m.snapshots.Load()
+	if sched == nil {
+		return nil, nil
+	}
+	snap, err := NearestSnapshot(sched.store, m.ID, step)
+	if errors.Is(err, ErrNoSnapshot) {
+		return nil, nil
+	}
+	return snap, err
+}
+
+// takeScheduledSnapshot saves a periodic snapshot if one is due
+func (m *Matrix) takeScheduledSnapshot() error {
+	sched := m.snapshots.Load()
+	if sched == nil || m.steps.Load()%sched.every != 0 {
+		return nil
+	}
+	return SaveSnapshot(sched.store, m.Snapshot())
+}
+
+// NearestSnapshot reads the most recent snapshot of a matrix taken at or
+// before step
+func NearestSnapshot(store *kv.Store, matrixID string, step uint64) (*Snapshot, error) {
+	iter, err := store.Iterate([]byte(snapshotPrefix(matrixID)), snapshotKey(matrixID, step+1))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	if !iter.Last() {
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		return nil, fmt.Errorf("%w: %s at or before step %d", ErrNoSnapshot, matrixID, step)
+	}
+	return decodeSnapshot(iter.Value())
+}
+
+// deleteSnapshotsAfter removes a matrix's snapshots taken after step
+func deleteSnapshotsAfter(store *kv.Store, matrixID string, step uint64) error {
+	batch := store.NewBatch()
+	defer batch.Close()
+	start := binary.BigEndian.AppendUint64([]byte(snapshotPrefix(matrixID)), step+1)
+	if err := batch.DeleteRange(start, prefixEnd([]byte(snapshotPrefix(matrixID)))); err != nil {
+		return fmt.Errorf("failed to delete snapshots: %w", err)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to delete snapshots: %w", err)
+	}
+	return nil
+}
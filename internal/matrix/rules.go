@@ -0,0 +1,120 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rule change events are journaled by UpdateRule and RemoveRule. Data holds
+// "rule_id", the rule's "version", "priority" and "exclusive", and the
+// matrix's "rules_version". They record the change only; replay leaves
+// the rule set alone, so rules must be registered again before Restore.
+const (
+	EventRuleAdded   = "rule_added"
+	EventRuleUpdated = "rule_updated"
+	EventRuleRemoved = "rule_removed"
+)
+
+// ErrRuleNotFound is returned for operations on rules the matrix does not have
+var ErrRuleNotFound = errors.New("rule not found")
+
+// UpdateRule replaces the rule with rule.ID, or adds rule if there is none,
+// and returns its new version. It may be called while the matrix runs; the
+// change takes effect from the next step.
+func (m *Matrix) UpdateRule(rule Rule) (uint64, error) {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	eventType := EventRuleAdded
+	i := m.ruleIndex(rule.ID)
+	rule.Version = 1
+	if i >= 0 {
+		eventType = EventRuleUpdated
+		rule.Version = m.rules[i].Version + 1
+	}
+	if err := m.journalRuleChange(eventType, rule); err != nil {
+		return 0, fmt.Errorf("failed to journal rule %s: %w", rule.ID, err)
+	}
+
+	if i >= 0 {
+		m.rules = append(m.rules[:i:i], m.rules[i+1:]...)
+	}
+	m.insertRule(rule)
+	return rule.Version, nil
+}
+
+// RemoveRule removes the rule with the given ID. It may be called while the
+// matrix runs; the rule is no longer evaluated from the next step.
+func (m *Matrix) RemoveRule(id string) error {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	i := m.ruleIndex(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	rule := m.rules[i]
+	rule.Version++
+	if err := m.journalRuleChange(EventRuleRemoved, rule); err != nil {
+		return fmt.Errorf("failed to journal rule %s: %w", id, err)
+	}
+	m.rules = append(m.rules[:i:i], m.rules[i+1:]...)
+	m.rulesVersion++
+	return nil
+}
+
+// RuleVersion returns the version of a rule: 1 when added, incremented by
+// each UpdateRule
+func (m *Matrix) RuleVersion(id string) (uint64, bool) {
+	m.rulesMu.RLock()
+	defer m.rulesMu.RUnlock()
+	if i := m.ruleIndex(id); i >= 0 {
+		return m.rules[i].Version, true
+	}
+	return 0, false
+}
+
+// RulesVersion counts the changes made to the matrix's rule set
+func (m *Matrix) RulesVersion() uint64 {
+	m.rulesMu.RLock()
+	defer m.rulesMu.RUnlock()
+	return m.rulesVersion
+}
+
+// insertRule adds a rule in evaluation order; the stable sort preserves
+// insertion order among equal priorities. rulesMu must be held.
+func (m *Matrix) insertRule(rule Rule) {
+	m.rules = append(m.rules, rule)
+	sort.SliceStable(m.rules, func(i, j int) bool {
+		return m.rules[i].Priority > m.rules[j].Priority
+	})
+	m.rulesVersion++
+}
+
+// ruleIndex returns the index of the rule with id, or -1. rulesMu must be
+// held.
+func (m *Matrix) ruleIndex(id string) int {
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// journalRuleChange journals a change to the rule set. rulesMu must be held.
+func (m *Matrix) journalRuleChange(eventType string, rule Rule) error {
+	return m.journalEvent(Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"rule_id":       rule.ID,
+			"version":       rule.Version,
+			"priority":      rule.Priority,
+			"exclusive":     rule.Exclusive,
+			"rules_version": m.rulesVersion + 1,
+		},
+	})
+}
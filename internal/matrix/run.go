@@ -0,0 +1,300 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAlreadyRunning is returned when Run is called on a running matrix
+var ErrAlreadyRunning = errors.New("matrix is already running")
+
+// ErrNotRunning is returned when controlling a matrix that is not running
+var ErrNotRunning = errors.New("matrix is not running")
+
+// RunMode selects how Run paces steps
+type RunMode string
+
+const (
+	// RunRealTime takes one step per tick interval
+	RunRealTime RunMode = "realtime"
+	// RunAsFastAsPossible takes steps back to back, ignoring the interval
+	RunAsFastAsPossible RunMode = "fast"
+)
+
+// RunState describes what a matrix's run loop is doing
+type RunState string
+
+const (
+	// RunStateIdle means Run is not active
+	RunStateIdle RunState = "idle"
+	// RunStateRunning means Run is taking steps
+	RunStateRunning RunState = "running"
+	// RunStatePaused means Run is waiting for Resume
+	RunStatePaused RunState = "paused"
+)
+
+// DefaultRunConfig steps ten times a second in real time without a step limit
+var DefaultRunConfig = RunConfig{
+	TickInterval: 100 * time.Millisecond,
+	Mode:         RunRealTime,
+}
+
+// RunConfig controls a matrix run loop
+type RunConfig struct {
+	TickInterval time.Duration // Time between steps in RunRealTime; 0 uses DefaultRunConfig's
+	MaxSteps     uint64        // Steps to take before returning; 0 runs until stopped
+	Mode         RunMode       // Empty uses RunRealTime
+	MaxDuration  time.Duration // Wall time before the run stops; 0 is unlimited
+	StopWhen     string        // Aggregate expression, such as count("prey") == 0, checked after each step
+}
+
+// StopReason says why a run ended
+type StopReason string
+
+const (
+	// StopMaxSteps means MaxSteps were taken
+	StopMaxSteps StopReason = "max_steps"
+	// StopCondition means StopWhen held
+	StopCondition StopReason = "condition"
+	// StopMaxDuration means MaxDuration elapsed
+	StopMaxDuration StopReason = "max_duration"
+	// StopStopped means Stop was called
+	StopStopped StopReason = "stopped"
+	// StopCanceled means the caller's context ended
+	StopCanceled StopReason = "canceled"
+	// StopError means a step failed
+	StopError StopReason = "error"
+)
+
+// RunResult summarizes a finished run
+type RunResult struct {
+	MatrixID    string             `json:"matrix_id"`
+	Reason      StopReason         `json:"reason"`
+	Error       string             `json:"error,omitempty"`
+	Steps       uint64             `json:"steps"`      // Steps taken by the run
+	FinalStep   uint64             `json:"final_step"` // Steps completed by the matrix
+	Started     time.Time          `json:"started"`
+	Elapsed     time.Duration      `json:"elapsed"`
+	Metrics     map[string]float64 `json:"metrics"`
+	EventCounts map[string]uint64  `json:"event_counts"` // Events applied during the run, by type
+}
+
+// errMaxDuration is the cause of a run context ended by MaxDuration
+var errMaxDuration = errors.New("run reached its maximum duration")
+
+// runControl holds the state shared between Run and its controllers
+type runControl struct {
+	running bool
+	paused  bool
+	fault   error // Limit breach that paused the loop
+	cancel  context.CancelFunc
+	wake    chan struct{} // Closed when the loop is resumed or stopped
+}
+
+// Run steps the matrix until ctx ends, Stop is called, a termination
+// condition is met, or a step fails. It returns nil when stopped or done,
+// the step error on failure, and ctx's error if ctx ends first. Every run
+// that starts leaves a RunResult for LastRunResult.
+func (m *Matrix) Run(ctx context.Context, cfg RunConfig) error {
+	if owner, nested := m.Owner(); nested {
+		return fmt.Errorf("sub-matrix %s is stepped by %s", m.ID, owner.ID)
+	}
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = DefaultRunConfig.TickInterval
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = RunRealTime
+	}
+	var stopWhen *expr
+	if cfg.StopWhen != "" {
+		var err error
+		if stopWhen, err = compileExpr(cfg.StopWhen); err != nil {
+			return fmt.Errorf("invalid stop condition: %w", err)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.runMu.Lock()
+	if m.run.running {
+		m.runMu.Unlock()
+		return ErrAlreadyRunning
+	}
+	m.run = runControl{running: true, cancel: cancel, wake: make(chan struct{})}
+	m.runMu.Unlock()
+
+	defer func() {
+		m.runMu.Lock()
+		m.run = runControl{}
+		m.runMu.Unlock()
+	}()
+
+	loopCtx := runCtx
+	if cfg.MaxDuration > 0 {
+		var cancelTimeout context.CancelFunc
+		loopCtx, cancelTimeout = context.WithTimeoutCause(runCtx, cfg.MaxDuration, errMaxDuration)
+		defer cancelTimeout()
+	}
+
+	result := &RunResult{MatrixID: m.ID, Started: time.Now()}
+	startStep := m.steps.Load()
+	startCounts := m.EventCounts()
+
+	reason, err := m.loop(loopCtx, cfg, stopWhen)
+	if ctx.Err() != nil {
+		reason, err = StopCanceled, ctx.Err()
+	}
+
+	result.Reason = reason
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.FinalStep = m.steps.Load()
+	result.Steps = result.FinalStep - startStep
+	result.Elapsed = time.Since(result.Started)
+	result.Metrics = m.GetMetrics()
+	result.EventCounts = m.EventCounts()
+	for eventType, n := range startCounts {
+		result.EventCounts[eventType] -= n
+		if result.EventCounts[eventType] == 0 {
+			delete(result.EventCounts, eventType)
+		}
+	}
+	m.lastResult.Store(result)
+	return err
+}
+
+// loop takes steps until a termination condition is met, returning why it
+// ended and the step error on failure
+func (m *Matrix) loop(ctx context.Context, cfg RunConfig, stopWhen *expr) (StopReason, error) {
+	var tick <-chan time.Time
+	if cfg.Mode == RunRealTime {
+		ticker := time.NewTicker(cfg.TickInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for taken := uint64(0); cfg.MaxSteps == 0 || taken < cfg.MaxSteps; {
+		m.waitWhilePaused(ctx)
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return interrupted(ctx), nil
+		}
+
+		if err := m.Step(ctx); err != nil {
+			if ctx.Err() != nil {
+				return interrupted(ctx), nil
+			}
+			if errors.Is(err, ErrLimitExceeded) && m.Limits().PauseOnExceed {
+				m.runMu.Lock()
+				m.run.paused, m.run.fault = true, err
+				m.runMu.Unlock()
+				continue
+			}
+			return StopError, err
+		}
+		taken++
+
+		if stopWhen != nil {
+			done, err := stopWhen.evalBool(exprEnv{step: m.steps.Load(), m: m})
+			if err != nil {
+				return StopError, fmt.Errorf("failed to evaluate stop condition: %w", err)
+			}
+			if done {
+				return StopCondition, nil
+			}
+		}
+	}
+	return StopMaxSteps, nil
+}
+
+// interrupted returns why a run context ended
+func interrupted(ctx context.Context) StopReason {
+	if errors.Is(context.Cause(ctx), errMaxDuration) {
+		return StopMaxDuration
+	}
+	return StopStopped
+}
+
+// LastRunResult returns the result of the most recent finished run
+func (m *Matrix) LastRunResult() (*RunResult, bool) {
+	result := m.lastResult.Load()
+	return result, result != nil
+}
+
+// waitWhilePaused blocks until the run loop is resumed or ctx ends
+func (m *Matrix) waitWhilePaused(ctx context.Context) {
+	for {
+		m.runMu.Lock()
+		paused, wake := m.run.paused, m.run.wake
+		m.runMu.Unlock()
+		if !paused {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop ends the run loop after the step in progress
+func (m *Matrix) Stop() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if !m.run.running {
+		return ErrNotRunning
+	}
+	m.run.cancel()
+	return nil
+}
+
+// Pause holds the run loop before its next step
+func (m *Matrix) Pause() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if !m.run.running {
+		return ErrNotRunning
+	}
+	m.run.paused = true
+	return nil
+}
+
+// Resume continues a paused run loop
+func (m *Matrix) Resume() error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if !m.run.running {
+		return ErrNotRunning
+	}
+	if m.run.paused {
+		m.run.paused = false
+		m.run.fault = nil
+		close(m.run.wake)
+		m.run.wake = make(chan struct{})
+	}
+	return nil
+}
+
+// State reports whether the run loop is idle, running, or paused
+func (m *Matrix) State() RunState {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	switch {
+	case !m.run.running:
+		return RunStateIdle
+	case m.run.paused:
+		return RunStatePaused
+	default:
+		return RunStateRunning
+	}
+}
@@ -0,0 +1,267 @@
+package matrix
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario declares how a matrix starts: its agents, their initial state,
+// its rules, and when a run ends. Scenarios are YAML or JSON documents:
+//
+//	id: predator-prey
+//	seed: 42
+//	populations:
+//	  - type: prey
+//	    count: 100
+//	    state:
+//	      energy: {uniform: [5, 10]}
+//	      speed: {normal: {mean: 1, stddev: 0.2}}
+//	      color: {choice: [brown, grey]}
+//	      alive: {value: true}
+//	agents:
+//	  - {id: wolf-1, type: wolf, state: {energy: 20}}
+//	schemas:
+//	  prey: {fields: {energy: {type: number, min: 0}}}
+//	space: {kind: grid, width: 50, height: 50, wrap: true}
+//	rules:
+//	  - {id: drain, when: state.energy > 0, set: {energy: state.energy - 1}}
+//	termination:
+//	  max_steps: 1000
+//	  max_duration: 5m
+//	  when: count("prey") == 0
+//
+// The seed drives both the sampled state and the matrix's rule random
+// sources, so building the same scenario twice yields identical matrices
+// that evolve identically.
+type Scenario struct {
+	ID          string                 `yaml:"id"`
+	Seed        int64                  `yaml:"seed"`
+	Populations []Population           `yaml:"populations"`
+	Agents      []AgentSpec            `yaml:"agents"`
+	Rules       []RuleSpec             `yaml:"rules"`
+	Space       *SpaceConfig           `yaml:"space"`
+	Schemas     map[string]StateSchema `yaml:"schemas"` // By agent type
+	Termination Termination            `yaml:"termination"`
+}
+
+// Population is a group of agents of one type with sampled initial state
+type Population struct {
+	Type     string                  `yaml:"type"`
+	Count    int                     `yaml:"count"`
+	IDPrefix string                  `yaml:"id_prefix"` // Defaults to the type; IDs are prefix-0, prefix-1, ...
+	State    map[string]Distribution `yaml:"state"`
+}
+
+// AgentSpec is an individually declared agent
+type AgentSpec struct {
+	ID    string                 `yaml:"id"`
+	Type  string                 `yaml:"type"`
+	State map[string]interface{} `yaml:"state"`
+}
+
+// Distribution produces initial state values. Exactly one field is set.
+type Distribution struct {
+	Value   interface{}   `yaml:"value"`
+	Uniform []float64     `yaml:"uniform"` // [min, max)
+	Normal  *Normal       `yaml:"normal"`
+	Choice  []interface{} `yaml:"choice"`
+}
+
+// Normal is a normal distribution
+type Normal struct {
+	Mean   float64 `yaml:"mean"`
+	StdDev float64 `yaml:"stddev"`
+}
+
+// Termination declares when a run of the scenario ends
+type Termination struct {
+	MaxSteps    uint64        `yaml:"max_steps"`
+	MaxDuration time.Duration `yaml:"max_duration"` // Such as 30s
+	When        string        `yaml:"when"`         // Aggregate stop condition, such as count("prey") == 0
+}
+
+// ParseScenario decodes and validates a YAML or JSON scenario
+func ParseScenario(data []byte) (*Scenario, error) {
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return &s, nil
+}
+
+// LoadScenario reads and parses a scenario file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	return ParseScenario(data)
+}
+
+// Validate checks that the scenario is complete and its rules compile
+func (s *Scenario) Validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	for i, p := range s.Populations {
+		if p.Type == "" {
+			return fmt.Errorf("population %d: type is required", i)
+		}
+		if p.Count < 0 {
+			return fmt.Errorf("population %s: count must not be negative", p.Type)
+		}
+		for key, d := range p.State {
+			if err := d.validate(); err != nil {
+				return fmt.Errorf("population %s: state %s: %w", p.Type, key, err)
+			}
+		}
+	}
+	for i, a := range s.Agents {
+		if a.ID == "" {
+			return fmt.Errorf("agent %d: id is required", i)
+		}
+	}
+	if s.Space != nil {
+		if _, err := s.Space.withDefaults(); err != nil {
+			return err
+		}
+	}
+	for agentType, schema := range s.Schemas {
+		for key, field := range schema.Fields {
+			if err := field.validate(); err != nil {
+				return fmt.Errorf("schema %s: field %s: %w", agentType, key, err)
+			}
+		}
+	}
+	if s.Termination.When != "" {
+		if _, err := compileExpr(s.Termination.When); err != nil {
+			return fmt.Errorf("termination: %w", err)
+		}
+	}
+	if _, err := compileRules(s.Rules); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Build creates a matrix populated as the scenario declares
+func (s *Scenario) Build(metrics MetricsCollector) (*Matrix, error) {
+	rules, err := compileRules(s.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	m := New(s.ID, metrics)
+	m.SetSeed(s.Seed)
+	if s.Space != nil {
+		if err := m.SetSpace(*s.Space); err != nil {
+			return nil, err
+		}
+	}
+	for agentType, schema := range s.Schemas {
+		if err := m.SetSchema(agentType, &schema); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range rules {
+		m.AddRule(rule)
+	}
+
+	rng := rand.New(rand.NewSource(s.Seed))
+	for _, p := range s.Populations {
+		prefix := p.IDPrefix
+		if prefix == "" {
+			prefix = p.Type
+		}
+
+		// Sample keys in a fixed order so the seed fully determines state
+		keys := make([]string, 0, len(p.State))
+		for key := range p.State {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for i := 0; i < p.Count; i++ {
+			state := make(map[string]interface{}, len(keys))
+			for _, key := range keys {
+				state[key] = p.State[key].sample(rng)
+			}
+			agent := &MatrixAgent{ID: fmt.Sprintf("%s-%d", prefix, i), Type: p.Type, State: state}
+			if err := m.AddAgent(agent); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, a := range s.Agents {
+		if err := m.AddAgent(&MatrixAgent{ID: a.ID, Type: a.Type, State: copyState(a.State)}); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RunConfig returns a run configuration honoring the scenario's termination
+func (s *Scenario) RunConfig(base RunConfig) RunConfig {
+	if s.Termination.MaxSteps > 0 {
+		base.MaxSteps = s.Termination.MaxSteps
+	}
+	if s.Termination.MaxDuration > 0 {
+		base.MaxDuration = s.Termination.MaxDuration
+	}
+	if s.Termination.When != "" {
+		base.StopWhen = s.Termination.When
+	}
+	return base
+}
+
+// validate checks that exactly one kind of distribution is set
+func (d Distribution) validate() error {
+	set := 0
+	if d.Value != nil {
+		set++
+	}
+	if d.Uniform != nil {
+		set++
+		if len(d.Uniform) != 2 || d.Uniform[0] > d.Uniform[1] {
+			return fmt.Errorf("uniform takes [min, max]")
+		}
+	}
+	if d.Normal != nil {
+		set++
+		if d.Normal.StdDev < 0 {
+			return fmt.Errorf("normal stddev must not be negative")
+		}
+	}
+	if d.Choice != nil {
+		set++
+		if len(d.Choice) == 0 {
+			return fmt.Errorf("choice needs at least one value")
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of value, uniform, normal, or choice is required")
+	}
+	return nil
+}
+
+// sample draws a value from the distribution
+func (d Distribution) sample(rng *rand.Rand) interface{} {
+	switch {
+	case d.Uniform != nil:
+		return d.Uniform[0] + rng.Float64()*(d.Uniform[1]-d.Uniform[0])
+	case d.Normal != nil:
+		return d.Normal.Mean + rng.NormFloat64()*d.Normal.StdDev
+	case d.Choice != nil:
+		return d.Choice[rng.Intn(len(d.Choice))]
+	default:
+		return d.Value
+	}
+}
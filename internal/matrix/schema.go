@@ -0,0 +1,207 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ErrInvalidState is returned when agent state does not match the schema
+// of its type
+var ErrInvalidState = errors.New("invalid agent state")
+
+// StateSchema declares the state of one agent type
+type StateSchema struct {
+	Fields map[string]StateField `yaml:"fields"`
+	Strict bool                  `yaml:"strict"` // Reject keys not in Fields
+}
+
+// StateField declares one state key
+type StateField struct {
+	Type     string        `yaml:"type"` // "string", "number", "bool", or "any"
+	Required bool          `yaml:"required"`
+	Default  interface{}   `yaml:"default"` // Applied by AddAgent when the key is missing
+	Min      *float64      `yaml:"min"`
+	Max      *float64      `yaml:"max"`
+	Enum     []interface{} `yaml:"enum"`
+}
+
+// SetSchema validates the state of agentType's agents against schema from
+// now on: AddAgent applies its defaults and rejects invalid state, and
+// state changes that would break it fail. Agents already in the matrix
+// must satisfy it. A nil schema removes the type's schema.
+func (m *Matrix) SetSchema(agentType string, schema *StateSchema) error {
+	m.agentMu.Lock()
+	defer m.agentMu.Unlock()
+
+	if schema != nil {
+		for key, field := range schema.Fields {
+			if err := field.validate(); err != nil {
+				return fmt.Errorf("schema %s: field %s: %w", agentType, key, err)
+			}
+		}
+		for _, agent := range m.agents {
+			if agent.Type != agentType {
+				continue
+			}
+			agent.stateMu.RLock()
+			err := schema.check(agent.State)
+			agent.stateMu.RUnlock()
+			if err != nil {
+				return fmt.Errorf("%w: agent %s: %v", ErrInvalidState, agent.ID, err)
+			}
+		}
+	}
+
+	m.schemaMu.Lock()
+	defer m.schemaMu.Unlock()
+	if schema == nil {
+		delete(m.schemas, agentType)
+		return nil
+	}
+	if m.schemas == nil {
+		m.schemas = make(map[string]*StateSchema)
+	}
+	m.schemas[agentType] = schema
+	return nil
+}
+
+// schema returns the schema of an agent type, or nil
+func (m *Matrix) schema(agentType string) *StateSchema {
+	m.schemaMu.RLock()
+	defer m.schemaMu.RUnlock()
+	return m.schemas[agentType]
+}
+
+// admitState applies defaults to a new agent's state and validates it
+func (m *Matrix) admitState(agentType, id string, state map[string]interface{}) (map[string]interface{}, error) {
+	schema := m.schema(agentType)
+	if schema == nil {
+		return state, nil
+	}
+	result := copyState(state)
+	for key, field := range schema.Fields {
+		if _, ok := result[key]; !ok && field.Default != nil {
+			result[key] = field.Default
+		}
+	}
+	if err := schema.check(result); err != nil {
+		return nil, fmt.Errorf("%w: agent %s: %v", ErrInvalidState, id, err)
+	}
+	return result, nil
+}
+
+// checkChange validates an agent's state with changes applied. Callers
+// must hold the agent's stateMu.
+func (m *Matrix) checkChange(agent *MatrixAgent, changes map[string]interface{}) error {
+	schema := m.schema(agent.Type)
+	if schema == nil {
+		return nil
+	}
+	merged := copyState(agent.State)
+	for k, v := range changes {
+		merged[k] = v
+	}
+	if err := schema.check(merged); err != nil {
+		return fmt.Errorf("%w: agent %s: %v", ErrInvalidState, agent.ID, err)
+	}
+	return nil
+}
+
+// check validates a state against the schema, reporting the first
+// problem by key order
+func (s *StateSchema) check(state map[string]interface{}) error {
+	keys := make([]string, 0, len(s.Fields))
+	for key := range s.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := state[key]
+		if !ok {
+			if s.Fields[key].Required {
+				return fmt.Errorf("missing required key %s", key)
+			}
+			continue
+		}
+		if err := s.Fields[key].check(value); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+
+	if s.Strict {
+		unknown := make([]string, 0)
+		for key := range state {
+			if _, ok := s.Fields[key]; !ok {
+				unknown = append(unknown, key)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return fmt.Errorf("unknown key %s", unknown[0])
+		}
+	}
+	return nil
+}
+
+// validate checks that a field declaration is usable
+func (f StateField) validate() error {
+	switch f.Type {
+	case "string", "number", "bool", "any":
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+	if (f.Min != nil || f.Max != nil) && f.Type != "number" {
+		return fmt.Errorf("min and max apply only to numbers")
+	}
+	if f.Default != nil {
+		if err := f.check(f.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	return nil
+}
+
+// check validates one value against the field
+func (f StateField) check(value interface{}) error {
+	switch f.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("must be a string, got %T", value)
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be a bool, got %T", value)
+		}
+	case "number":
+		n, ok := toFloat(value)
+		if !ok {
+			return fmt.Errorf("must be a number, got %T", value)
+		}
+		if f.Min != nil && n < *f.Min {
+			return fmt.Errorf("%v is below the minimum %v", n, *f.Min)
+		}
+		if f.Max != nil && n > *f.Max {
+			return fmt.Errorf("%v is above the maximum %v", n, *f.Max)
+		}
+	}
+
+	if len(f.Enum) > 0 {
+		for _, allowed := range f.Enum {
+			if reflect.DeepEqual(normalizeNumber(allowed), normalizeNumber(value)) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %v", value, f.Enum)
+	}
+	return nil
+}
+
+// normalizeNumber converts numbers to float64 so 1 and 1.0 compare equal
+func normalizeNumber(v interface{}) interface{} {
+	if n, ok := toFloat(v); ok {
+		return n
+	}
+	return v
+}
@@ -0,0 +1,256 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink defaults
+const (
+	DefaultSinkBatchSize     = 100
+	DefaultSinkFlushInterval = time.Second
+	DefaultSinkMaxRetries    = 3
+	DefaultSinkRetryBackoff  = 500 * time.Millisecond
+)
+
+// Sink delivers batches of applied matrix events to an external system
+type Sink interface {
+	Send(ctx context.Context, batch SinkBatch) error
+}
+
+// SinkBatch is the payload handed to a sink and, as JSON, sent by the
+// built-in sinks
+type SinkBatch struct {
+	MatrixID string         `json:"matrix_id"`
+	Entries  []JournalEntry `json:"entries"`
+}
+
+// SinkConfig controls how events are batched and retried for a sink
+type SinkConfig struct {
+	Filters       WatchFilters
+	BatchSize     int           // Events per batch; 0 uses DefaultSinkBatchSize
+	FlushInterval time.Duration // Longest a partial batch waits; 0 uses DefaultSinkFlushInterval
+	MaxRetries    int           // Retries of a failed batch before it is dropped; 0 uses DefaultSinkMaxRetries
+	RetryBackoff  time.Duration // First retry delay, doubled each retry; 0 uses DefaultSinkRetryBackoff
+}
+
+// SinkStats counts a sink's deliveries
+type SinkStats struct {
+	Sent    uint64 // Events delivered
+	Dropped uint64 // Events in batches that failed every retry
+	Retries uint64
+}
+
+// SinkHandle reports on a sink attached with AddSink
+type SinkHandle struct {
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	retries atomic.Uint64
+	lastErr atomic.Pointer[error]
+	done    chan struct{}
+}
+
+// Stats returns the sink's delivery counts
+func (h *SinkHandle) Stats() SinkStats {
+	return SinkStats{Sent: h.sent.Load(), Dropped: h.dropped.Load(), Retries: h.retries.Load()}
+}
+
+// Err returns the most recent delivery error
+func (h *SinkHandle) Err() error {
+	if err := h.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Done is closed once the sink has flushed its last batch after ctx ended
+func (h *SinkHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// AddSink streams the matrix's applied events to sink in batches until
+// ctx ends, then flushes what is pending. Failed batches are retried with
+// exponential backoff and dropped after MaxRetries, so a slow sink never
+// blocks steps; like Watch, events are skipped if the sink falls too far
+// behind.
+func (m *Matrix) AddSink(ctx context.Context, sink Sink, cfg SinkConfig) *SinkHandle {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultSinkBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultSinkFlushInterval
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultSinkMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultSinkRetryBackoff
+	}
+
+	h := &SinkHandle{done: make(chan struct{})}
+	events := m.Watch(ctx, cfg.Filters)
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(cfg.FlushInterval)
+		defer ticker.Stop()
+
+		var pending []JournalEntry
+		flush := func() {
+			if len(pending) > 0 {
+				h.deliver(ctx, sink, cfg, SinkBatch{MatrixID: m.ID, Entries: pending})
+				pending = nil
+			}
+		}
+		for {
+			select {
+			case entry, ok := <-events:
+				if !ok {
+					flush()
+					return
+				}
+				pending = append(pending, entry)
+				if len(pending) >= cfg.BatchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return h
+}
+
+// deliver sends a batch, retrying with backoff. Delivery outlives ctx so
+// batches pending at shutdown are still sent; MaxRetries bounds the wait.
+func (h *SinkHandle) deliver(ctx context.Context, sink Sink, cfg SinkConfig, batch SinkBatch) {
+	sendCtx := context.WithoutCancel(ctx)
+	backoff := cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := sink.Send(sendCtx, batch)
+		if err == nil {
+			h.sent.Add(uint64(len(batch.Entries)))
+			return
+		}
+		h.lastErr.Store(&err)
+		if attempt == cfg.MaxRetries {
+			h.dropped.Add(uint64(len(batch.Entries)))
+			return
+		}
+
+		h.retries.Add(1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// WebhookSink POSTs each batch as JSON to a URL
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client // nil uses a client with a 10 second timeout
+}
+
+// Send posts a batch, failing on non-2xx responses
+func (s *WebhookSink) Send(ctx context.Context, batch SinkBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post batch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Publisher publishes messages to a topic. *transport.Transport implements
+// it over libp2p pubsub; thin wrappers adapt Kafka or NATS clients.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+}
+
+// PublisherSink publishes each batch as JSON to a message broker topic
+type PublisherSink struct {
+	Publisher Publisher
+	Topic     string
+}
+
+// Send publishes a batch
+func (s *PublisherSink) Send(ctx context.Context, batch SinkBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	if err := s.Publisher.Publish(ctx, s.Topic, data); err != nil {
+		return fmt.Errorf("failed to publish batch: %w", err)
+	}
+	return nil
+}
+
+// FileSink appends events to a file as JSON lines, one entry per line
+type FileSink struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sink file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Send writes a batch's entries and syncs the file
+func (s *FileSink) Send(ctx context.Context, batch SinkBatch) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range batch.Entries {
+		line := struct {
+			MatrixID string `json:"matrix_id"`
+			JournalEntry
+		}{batch.MatrixID, entry}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("failed to encode entry: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write sink file: %w", err)
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
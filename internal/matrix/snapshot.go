@@ -0,0 +1,206 @@
+package matrix
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// SnapshotVersion is the snapshot format written by this package
+const SnapshotVersion = 1
+
+// ErrNoSnapshot is returned when a requested snapshot is not in the store
+var ErrNoSnapshot = errors.New("matrix snapshot not found")
+
+// Snapshot is a checkpoint of a matrix between steps. Rules are recorded
+// by metadata only; their Evaluate functions must be added again before
+// Restore.
+type Snapshot struct {
+	Version  int             `json:"version"`
+	MatrixID string          `json:"matrix_id"`
+	Step     uint64          `json:"step"` // Steps completed when taken
+	Seed     int64           `json:"seed"`
+	Parent   *Lineage        `json:"parent,omitempty"`
+	Taken    time.Time       `json:"taken"`
+	Agents   []AgentSnapshot `json:"agents"`
+	Rules    []RuleSnapshot  `json:"rules"`
+}
+
+// AgentSnapshot is an agent's state within a snapshot
+type AgentSnapshot struct {
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	State    map[string]interface{} `json:"state"`
+	Version  uint64                 `json:"version,omitempty"`
+	Disabled bool                   `json:"disabled,omitempty"`
+}
+
+// RuleSnapshot is a rule's metadata within a snapshot
+type RuleSnapshot struct {
+	ID        string `json:"id"`
+	Priority  int    `json:"priority"`
+	Exclusive bool   `json:"exclusive,omitempty"`
+	Version   uint64 `json:"version,omitempty"`
+}
+
+// Snapshot captures the matrix's agents, rules, step count, and seed. Take it
+// between steps, for example while the run loop is paused.
+func (m *Matrix) Snapshot() *Snapshot {
+	snap := &Snapshot{
+		Version:  SnapshotVersion,
+		MatrixID: m.ID,
+		Step:     m.steps.Load(),
+		Seed:     m.seed.Load(),
+		Parent:   m.lineage,
+		Taken:    time.Now(),
+	}
+
+	m.agentMu.RLock()
+	for _, agent := range m.agents {
+		agent.stateMu.RLock()
+		snap.Agents = append(snap.Agents, AgentSnapshot{
+			ID:       agent.ID,
+			Type:     agent.Type,
+			State:    copyState(agent.State),
+			Version:  agent.version,
+			Disabled: agent.disabled,
+		})
+		agent.stateMu.RUnlock()
+	}
+	m.agentMu.RUnlock()
+	sort.Slice(snap.Agents, func(i, j int) bool {
+		return snap.Agents[i].ID < snap.Agents[j].ID
+	})
+
+	m.rulesMu.RLock()
+	for _, rule := range m.rules {
+		snap.Rules = append(snap.Rules, RuleSnapshot{
+			ID:        rule.ID,
+			Priority:  rule.Priority,
+			Exclusive: rule.Exclusive,
+		})
+	}
+	m.rulesMu.RUnlock()
+	return snap
+}
+
+// Restore replaces the matrix's agents, step count, and seed with a
+// snapshot's.
+// Every rule in the snapshot must already be added. A journaled matrix can
+// then catch up with Replay(ctx, snap.Step).
+func (m *Matrix) Restore(snap *Snapshot) error {
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	m.runMu.Lock()
+	running := m.run.running
+	m.runMu.Unlock()
+	if running {
+		return ErrAlreadyRunning
+	}
+
+	m.rulesMu.RLock()
+	registered := make(map[string]bool, len(m.rules))
+	for _, rule := range m.rules {
+		registered[rule.ID] = true
+	}
+	m.rulesMu.RUnlock()
+	for _, rule := range snap.Rules {
+		if !registered[rule.ID] {
+			return fmt.Errorf("snapshot rule %s is not registered", rule.ID)
+		}
+	}
+
+	agents := make(map[string]*MatrixAgent, len(snap.Agents))
+	for _, a := range snap.Agents {
+		agents[a.ID] = &MatrixAgent{
+			ID:       a.ID,
+			Type:     a.Type,
+			State:    copyState(a.State),
+			version:  a.Version,
+			disabled: a.Disabled,
+		}
+	}
+
+	m.agentMu.Lock()
+	m.agents = agents
+	m.agentMu.Unlock()
+	if s := m.space.Load(); s != nil {
+		if err := m.SetSpace(s.cfg); err != nil {
+			return err
+		}
+	}
+	m.steps.Store(snap.Step)
+	m.seed.Store(snap.Seed)
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to the store, keyed by matrix and step.
+// State is stored as JSON, so numbers are restored as float64.
+func SaveSnapshot(store *kv.Store, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := store.Put(snapshotKey(snap.MatrixID, snap.Step), data); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads the snapshot of a matrix taken at step
+func LoadSnapshot(store *kv.Store, matrixID string, step uint64) (*Snapshot, error) {
+	data, err := store.Get(snapshotKey(matrixID, step))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s at step %d", ErrNoSnapshot, matrixID, step)
+	}
+	return decodeSnapshot(data)
+}
+
+// LatestSnapshot reads the most recent snapshot of a matrix
+func LatestSnapshot(store *kv.Store, matrixID string) (*Snapshot, error) {
+	iter, err := store.Scan([]byte(snapshotPrefix(matrixID)))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	if !iter.Last() {
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNoSnapshot, matrixID)
+	}
+	return decodeSnapshot(iter.Value())
+}
+
+// decodeSnapshot parses a stored snapshot, rejecting unknown versions
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+// snapshotPrefix returns the key prefix of a matrix's snapshots
+func snapshotPrefix(matrixID string) string {
+	return "matrix/" + matrixID + "/snapshots/"
+}
+
+// snapshotKey returns the key of a matrix's snapshot at step
+func snapshotKey(matrixID string, step uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(snapshotPrefix(matrixID)), step)
+}
@@ -0,0 +1,306 @@
+package matrix
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// EventAgentMoved moves an agent to Data's "x" and "y". Positions are
+// clamped to the space, or wrapped around it when it wraps, and snapped to
+// cells on a grid.
+const EventAgentMoved = "agent_moved"
+
+// Position state keys. Agents with numeric values for both are placed in
+// the matrix's space.
+const (
+	PositionX = "x"
+	PositionY = "y"
+)
+
+// SpaceKind selects how positions and distances are measured
+type SpaceKind string
+
+const (
+	// SpaceGrid places agents on integer cells; distance is the number of
+	// king moves between cells, so radius 1 is the Moore neighborhood
+	SpaceGrid SpaceKind = "grid"
+	// SpaceContinuous places agents anywhere; distance is Euclidean
+	SpaceContinuous SpaceKind = "continuous"
+)
+
+// SpaceConfig configures a matrix's spatial layer
+type SpaceConfig struct {
+	Kind     SpaceKind `yaml:"kind"`
+	Width    float64   `yaml:"width"`
+	Height   float64   `yaml:"height"`
+	CellSize float64   `yaml:"cell_size"` // Spatial hash bucket size; 0 uses 1 on grids and a tenth of the width otherwise
+	Wrap     bool      `yaml:"wrap"`      // Opposite edges meet, as on a torus
+}
+
+// space indexes agent positions in a spatial hash
+type space struct {
+	cfg    SpaceConfig
+	cols   int
+	rows   int
+	cells  map[[2]int]map[string]struct{}
+	points map[string][2]float64
+	mu     sync.RWMutex
+}
+
+// SetSpace gives the matrix a spatial layer and places every agent with a
+// position in it
+func (m *Matrix) SetSpace(cfg SpaceConfig) error {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	s := &space{
+		cfg:    cfg,
+		cols:   int(math.Ceil(cfg.Width / cfg.CellSize)),
+		rows:   int(math.Ceil(cfg.Height / cfg.CellSize)),
+		cells:  make(map[[2]int]map[string]struct{}),
+		points: make(map[string][2]float64),
+	}
+	m.agentMu.RLock()
+	for _, agent := range m.agents {
+		agent.stateMu.RLock()
+		s.place(agent.ID, agent.State)
+		agent.stateMu.RUnlock()
+	}
+	m.agentMu.RUnlock()
+
+	m.space.Store(s)
+	return nil
+}
+
+// withDefaults validates the config and fills in unset fields
+func (c SpaceConfig) withDefaults() (SpaceConfig, error) {
+	if c.Kind == "" {
+		c.Kind = SpaceContinuous
+	}
+	if c.Kind != SpaceGrid && c.Kind != SpaceContinuous {
+		return c, fmt.Errorf("unknown space kind %q", c.Kind)
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		return c, fmt.Errorf("space width and height must be positive")
+	}
+	if c.CellSize < 0 {
+		return c, fmt.Errorf("space cell size must not be negative")
+	}
+	if c.CellSize == 0 {
+		c.CellSize = 1
+		if c.Kind == SpaceContinuous {
+			c.CellSize = c.Width / 10
+		}
+	}
+	return c, nil
+}
+
+// Position returns an agent's position in the matrix's space
+func (m *Matrix) Position(id string) (x, y float64, ok bool) {
+	s := m.space.Load()
+	if s == nil {
+		return 0, 0, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.points[id]
+	return p[0], p[1], ok
+}
+
+// AgentsWithin returns the agents within radius of a point, ordered by ID
+func (m *Matrix) AgentsWithin(x, y, radius float64) []*MatrixAgent {
+	s := m.space.Load()
+	if s == nil {
+		return nil
+	}
+	ids := s.within(x, y, radius)
+
+	m.agentMu.RLock()
+	defer m.agentMu.RUnlock()
+	agents := make([]*MatrixAgent, 0, len(ids))
+	for _, id := range ids {
+		if agent, exists := m.agents[id]; exists {
+			agents = append(agents, agent)
+		}
+	}
+	return agents
+}
+
+// Neighbors returns the other agents within radius of an agent, ordered
+// by ID
+func (m *Matrix) Neighbors(id string, radius float64) ([]*MatrixAgent, error) {
+	x, y, ok := m.Position(id)
+	if !ok {
+		return nil, fmt.Errorf("agent %s has no position", id)
+	}
+	agents := m.AgentsWithin(x, y, radius)
+	for i, agent := range agents {
+		if agent.ID == id {
+			return append(agents[:i:i], agents[i+1:]...), nil
+		}
+	}
+	return agents, nil
+}
+
+// locate updates an agent's place in the space after its state changed.
+// Callers must hold the agent's stateMu.
+func (m *Matrix) locate(agent *MatrixAgent) {
+	if s := m.space.Load(); s != nil {
+		s.place(agent.ID, agent.State)
+	}
+}
+
+// unlocate removes an agent from the space
+func (m *Matrix) unlocate(id string) {
+	if s := m.space.Load(); s != nil {
+		s.remove(id)
+	}
+}
+
+// movement normalizes an agent_moved event's target to the space
+func (m *Matrix) movement(event Event) (map[string]interface{}, error) {
+	x, okX := toFloat(event.Data[PositionX])
+	y, okY := toFloat(event.Data[PositionY])
+	if !okX || !okY {
+		return nil, fmt.Errorf("move needs numeric x and y")
+	}
+	if s := m.space.Load(); s != nil {
+		x, y = s.normalize(x, y)
+	}
+	return map[string]interface{}{PositionX: x, PositionY: y}, nil
+}
+
+// normalize clamps or wraps a point into the space, snapping to cells on
+// a grid
+func (s *space) normalize(x, y float64) (float64, float64) {
+	fit := func(v, size float64) float64 {
+		if s.cfg.Kind == SpaceGrid {
+			v = math.Floor(v)
+		}
+		if s.cfg.Wrap {
+			v = math.Mod(v, size)
+			if v < 0 {
+				v += size
+			}
+			return v
+		}
+		upper := size
+		if s.cfg.Kind == SpaceGrid {
+			upper = size - 1
+		}
+		return math.Max(0, math.Min(v, upper))
+	}
+	return fit(x, s.cfg.Width), fit(y, s.cfg.Height)
+}
+
+// place indexes an agent at the position in its state, or removes it when
+// the state has none
+func (s *space) place(id string, state map[string]interface{}) {
+	x, okX := toFloat(state[PositionX])
+	y, okY := toFloat(state[PositionY])
+	if !okX || !okY {
+		s.remove(id)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, exists := s.points[id]; exists {
+		s.unindexLocked(id, old)
+	}
+	p := [2]float64{x, y}
+	s.points[id] = p
+	c := s.cell(x, y)
+	if s.cells[c] == nil {
+		s.cells[c] = make(map[string]struct{})
+	}
+	s.cells[c][id] = struct{}{}
+}
+
+// remove drops an agent from the index
+func (s *space) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, exists := s.points[id]; exists {
+		s.unindexLocked(id, p)
+		delete(s.points, id)
+	}
+}
+
+// unindexLocked removes an agent from its cell. Callers must hold mu.
+func (s *space) unindexLocked(id string, p [2]float64) {
+	c := s.cell(p[0], p[1])
+	delete(s.cells[c], id)
+	if len(s.cells[c]) == 0 {
+		delete(s.cells, c)
+	}
+}
+
+// cell returns the hash bucket of a point
+func (s *space) cell(x, y float64) [2]int {
+	col := int(math.Floor(x / s.cfg.CellSize))
+	row := int(math.Floor(y / s.cfg.CellSize))
+	if s.cfg.Wrap {
+		col = ((col % s.cols) + s.cols) % s.cols
+		row = ((row % s.rows) + s.rows) % s.rows
+	}
+	return [2]int{col, row}
+}
+
+// within returns the IDs of agents within radius of a point, sorted, by
+// checking only the buckets the radius overlaps
+func (s *space) within(x, y, radius float64) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	minCol, maxCol := int(math.Floor((x-radius)/s.cfg.CellSize)), int(math.Floor((x+radius)/s.cfg.CellSize))
+	minRow, maxRow := int(math.Floor((y-radius)/s.cfg.CellSize)), int(math.Floor((y+radius)/s.cfg.CellSize))
+	if s.cfg.Wrap {
+		// Past a full lap every bucket is covered
+		if maxCol-minCol >= s.cols {
+			minCol, maxCol = 0, s.cols-1
+		}
+		if maxRow-minRow >= s.rows {
+			minRow, maxRow = 0, s.rows-1
+		}
+	}
+
+	visited := make(map[[2]int]bool)
+	var ids []string
+	for col := minCol; col <= maxCol; col++ {
+		for row := minRow; row <= maxRow; row++ {
+			c := [2]int{col, row}
+			if s.cfg.Wrap {
+				c = [2]int{((col % s.cols) + s.cols) % s.cols, ((row % s.rows) + s.rows) % s.rows}
+			}
+			if visited[c] {
+				continue
+			}
+			visited[c] = true
+			for id := range s.cells[c] {
+				if s.distance(x, y, s.points[id]) <= radius {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// distance measures from a point to p in the space's metric
+func (s *space) distance(x, y float64, p [2]float64) float64 {
+	dx, dy := math.Abs(p[0]-x), math.Abs(p[1]-y)
+	if s.cfg.Wrap {
+		dx = math.Min(dx, s.cfg.Width-dx)
+		dy = math.Min(dy, s.cfg.Height-dy)
+	}
+	if s.cfg.Kind == SpaceGrid {
+		return math.Max(dx, dy)
+	}
+	return math.Hypot(dx, dy)
+}
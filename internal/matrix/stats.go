@@ -0,0 +1,104 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StepRecorder is implemented by MetricsCollectors that also record step
+// performance. Step reports each rule it evaluated, then the step itself.
+type StepRecorder interface {
+	RecordRule(ruleID string, latency time.Duration, events int)
+	RecordStep(duration time.Duration, events int)
+}
+
+// RuleStats summarizes a rule's evaluations
+type RuleStats struct {
+	Evaluations uint64
+	Events      uint64 // Events returned, before conflict resolution and filtering
+	Total       time.Duration
+	Max         time.Duration
+}
+
+// Mean returns the average evaluation latency
+func (s RuleStats) Mean() time.Duration {
+	if s.Evaluations == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Evaluations)
+}
+
+// RuleStats returns the evaluation statistics of every rule that has been
+// evaluated, by rule ID
+func (m *Matrix) RuleStats() map[string]RuleStats {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	stats := make(map[string]RuleStats, len(m.ruleStats))
+	for id, s := range m.ruleStats {
+		stats[id] = *s
+	}
+	return stats
+}
+
+// ruleTiming is one rule evaluation within a step
+type ruleTiming struct {
+	ruleID  string
+	latency time.Duration
+	events  int
+}
+
+// stepTimer evaluates and times the rules of one step
+type stepTimer struct {
+	m       *Matrix
+	step    uint64
+	timings []ruleTiming
+	mu      sync.Mutex
+}
+
+// evaluate runs a rule with its step context and records how long it took
+func (t *stepTimer) evaluate(ctx context.Context, rule Rule) ([]Event, error) {
+	start := time.Now()
+	events, err := rule.Evaluate(t.m.ruleContext(ctx, t.step, rule.ID), t.m)
+	latency := time.Since(start)
+
+	t.mu.Lock()
+	t.timings = append(t.timings, ruleTiming{ruleID: rule.ID, latency: latency, events: len(events)})
+	t.mu.Unlock()
+	return events, err
+}
+
+// record adds a step's timings to the matrix statistics and hands them to
+// the metrics collector
+func (t *stepTimer) record(duration time.Duration, journaled []JournalEntry) {
+	m := t.m
+	m.statsMu.Lock()
+	if m.counts == nil {
+		m.counts = make(map[string]uint64)
+	}
+	for _, entry := range journaled {
+		m.counts[entry.Event.Type]++
+	}
+	if m.ruleStats == nil {
+		m.ruleStats = make(map[string]*RuleStats)
+	}
+	for _, timing := range t.timings {
+		s := m.ruleStats[timing.ruleID]
+		if s == nil {
+			s = &RuleStats{}
+			m.ruleStats[timing.ruleID] = s
+		}
+		s.Evaluations++
+		s.Events += uint64(timing.events)
+		s.Total += timing.latency
+		s.Max = max(s.Max, timing.latency)
+	}
+	m.statsMu.Unlock()
+
+	if recorder, ok := m.metrics.(StepRecorder); ok {
+		for _, timing := range t.timings {
+			recorder.RecordRule(timing.ruleID, timing.latency, timing.events)
+		}
+		recorder.RecordStep(duration, len(journaled))
+	}
+}
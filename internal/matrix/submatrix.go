@@ -0,0 +1,175 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SubMatrixType is the default type of the agents standing in for
+// sub-matrices
+const SubMatrixType = "matrix"
+
+// SubMatrixConfig describes how a sub-matrix appears in the matrix owning it
+type SubMatrixConfig struct {
+	AgentType string // Type of the agent standing in for the sub-matrix; defaults to SubMatrixType
+	Steps     uint64 // Sub-matrix steps per owner step; defaults to 1
+
+	// Outputs maps state keys of the standing agent to aggregate
+	// expressions over the sub-matrix, such as count("prey") or
+	// mean("energy")
+	Outputs map[string]string
+}
+
+// subMatrix is a sub-matrix and its compiled outputs
+type subMatrix struct {
+	m       *Matrix
+	cfg     SubMatrixConfig
+	outputs map[string]*expr
+}
+
+// AddSubMatrix nests sub under the matrix. The sub-matrix is represented
+// by an agent with the sub-matrix's ID whose state holds its outputs. Each
+// step of the owner first steps every sub-matrix, in ID order, then
+// publishes their outputs as state_changed events before rules run, so
+// rules of the owner see the sub-matrices as of the current step.
+// Sub-matrices are stepped only by their owner and are not forked,
+// snapshotted, or rewound with it.
+func (m *Matrix) AddSubMatrix(sub *Matrix, cfg SubMatrixConfig) error {
+	if sub == m {
+		return fmt.Errorf("matrix %s cannot contain itself", m.ID)
+	}
+	if cfg.AgentType == "" {
+		cfg.AgentType = SubMatrixType
+	}
+	if cfg.Steps == 0 {
+		cfg.Steps = 1
+	}
+	for owner := m; owner != nil; owner = owner.owner.Load() {
+		if owner == sub {
+			return fmt.Errorf("matrix %s already contains %s", sub.ID, m.ID)
+		}
+	}
+	if sub.State() != RunStateIdle {
+		return fmt.Errorf("sub-matrix %s is running", sub.ID)
+	}
+
+	entry := &subMatrix{m: sub, cfg: cfg, outputs: make(map[string]*expr, len(cfg.Outputs))}
+	for key, src := range cfg.Outputs {
+		e, err := compileExpr(src)
+		if err != nil {
+			return fmt.Errorf("sub-matrix %s output %s: %w", sub.ID, key, err)
+		}
+		entry.outputs[key] = e
+	}
+	state, err := entry.evalOutputs()
+	if err != nil {
+		return err
+	}
+
+	if !sub.owner.CompareAndSwap(nil, m) {
+		return fmt.Errorf("sub-matrix %s already has an owner", sub.ID)
+	}
+	if err := m.AddAgent(&MatrixAgent{ID: sub.ID, Type: cfg.AgentType, State: state}); err != nil {
+		sub.owner.Store(nil)
+		return fmt.Errorf("failed to add sub-matrix %s: %w", sub.ID, err)
+	}
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	if m.subs == nil {
+		m.subs = make(map[string]*subMatrix)
+	}
+	m.subs[sub.ID] = entry
+	return nil
+}
+
+// RemoveSubMatrix detaches a sub-matrix and removes the agent standing in
+// for it
+func (m *Matrix) RemoveSubMatrix(id string) error {
+	m.subMu.Lock()
+	entry, exists := m.subs[id]
+	delete(m.subs, id)
+	m.subMu.Unlock()
+	if !exists {
+		return fmt.Errorf("sub-matrix %s not found", id)
+	}
+
+	entry.m.owner.Store(nil)
+	return m.RemoveAgent(id)
+}
+
+// SubMatrices returns the matrix's sub-matrices, sorted by ID
+func (m *Matrix) SubMatrices() []*Matrix {
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+	subs := make([]*Matrix, 0, len(m.subs))
+	for _, entry := range m.subs {
+		subs = append(subs, entry.m)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
+
+// Owner returns the matrix the matrix is nested in, if any
+func (m *Matrix) Owner() (*Matrix, bool) {
+	owner := m.owner.Load()
+	return owner, owner != nil
+}
+
+// SubMatrixRuleID returns the rule ID under which the outputs of a
+// sub-matrix are journaled in its owner
+func SubMatrixRuleID(id string) string {
+	return "submatrix:" + id
+}
+
+// stepSubMatrices advances each sub-matrix and applies its outputs
+func (m *Matrix) stepSubMatrices(ctx context.Context, apply func(ruleID string, event Event) error) error {
+	m.subMu.RLock()
+	subs := make([]*subMatrix, 0, len(m.subs))
+	for _, entry := range m.subs {
+		subs = append(subs, entry)
+	}
+	m.subMu.RUnlock()
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].m.ID < subs[j].m.ID
+	})
+
+	for _, entry := range subs {
+		for i := uint64(0); i < entry.cfg.Steps; i++ {
+			if err := entry.m.Step(ctx); err != nil {
+				return fmt.Errorf("sub-matrix %s step failed: %w", entry.m.ID, err)
+			}
+		}
+		if len(entry.outputs) == 0 {
+			continue
+		}
+
+		state, err := entry.evalOutputs()
+		if err != nil {
+			return err
+		}
+		event := Event{Type: EventStateChanged, Timestamp: time.Now(), AgentID: entry.m.ID, Data: state}
+		if err := apply(SubMatrixRuleID(entry.m.ID), event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evalOutputs evaluates the outputs against the sub-matrix's current state
+func (s *subMatrix) evalOutputs() (map[string]interface{}, error) {
+	env := exprEnv{step: s.m.steps.Load(), m: s.m}
+	state := make(map[string]interface{}, len(s.outputs))
+	for key, e := range s.outputs {
+		v, err := e.eval(env)
+		if err != nil {
+			return nil, fmt.Errorf("sub-matrix %s output %s: %w", s.m.ID, key, err)
+		}
+		state[key] = v
+	}
+	return state, nil
+}
@@ -0,0 +1,120 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// WatchBufferSize is the number of events a watcher may fall behind by
+// before further events are dropped for it
+const WatchBufferSize = 256
+
+// WatchFilters selects the events a watcher receives. Each non-empty list
+// must contain the event's value; empty lists match everything.
+type WatchFilters struct {
+	Types    []string
+	AgentIDs []string
+	RuleIDs  []string // Use "" to match events applied outside rules
+}
+
+// matches reports whether an entry passes the filters
+func (f WatchFilters) matches(entry JournalEntry) bool {
+	return matchAny(f.Types, entry.Event.Type) &&
+		matchAny(f.AgentIDs, entry.Event.AgentID) &&
+		matchAny(f.RuleIDs, entry.RuleID)
+}
+
+// matchAny reports whether values is empty or contains v
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// watcher is one Watch subscription
+type watcher struct {
+	filters WatchFilters
+	ch      chan JournalEntry
+}
+
+// observers fans applied events out to watchers and the event bus
+type observers struct {
+	watchers map[*watcher]struct{}
+	bus      *transport.EventBus
+	mu       sync.RWMutex
+}
+
+// Watch streams events as steps commit them, with the step and rule that
+// emitted each, until ctx ends. Events are dropped for a watcher that
+// falls more than WatchBufferSize behind.
+func (m *Matrix) Watch(ctx context.Context, filters WatchFilters) <-chan JournalEntry {
+	w := &watcher{filters: filters, ch: make(chan JournalEntry, WatchBufferSize)}
+
+	m.observers.mu.Lock()
+	if m.observers.watchers == nil {
+		m.observers.watchers = make(map[*watcher]struct{})
+	}
+	m.observers.watchers[w] = struct{}{}
+	m.observers.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.observers.mu.Lock()
+		delete(m.observers.watchers, w)
+		close(w.ch)
+		m.observers.mu.Unlock()
+	}()
+	return w.ch
+}
+
+// SetEventBus publishes every applied event to bus as an EventTypeMatrix
+// event. Data carries "matrix_id", "event", "agent_id", "rule_id", "step",
+// and the event's own "data".
+func (m *Matrix) SetEventBus(bus *transport.EventBus) {
+	m.observers.mu.Lock()
+	defer m.observers.mu.Unlock()
+	m.observers.bus = bus
+}
+
+// publish hands applied events to watchers and the event bus
+func (m *Matrix) publish(entries []JournalEntry) {
+	m.observers.mu.RLock()
+	defer m.observers.mu.RUnlock()
+
+	for _, entry := range entries {
+		for w := range m.observers.watchers {
+			if !w.filters.matches(entry) {
+				continue
+			}
+			select {
+			case w.ch <- entry:
+			default:
+				// Watcher is behind, skip to avoid blocking the step
+			}
+		}
+
+		if m.observers.bus != nil {
+			m.observers.bus.Publish(transport.Event{
+				Type:      transport.EventTypeMatrix,
+				Source:    m.ID,
+				Timestamp: entry.Event.Timestamp.UnixNano(),
+				Data: map[string]interface{}{
+					"matrix_id": m.ID,
+					"event":     entry.Event.Type,
+					"agent_id":  entry.Event.AgentID,
+					"rule_id":   entry.RuleID,
+					"step":      entry.Step,
+					"data":      entry.Event.Data,
+				},
+			})
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// KVMetricsAdapter adapts the metrics collector to the kv Recorder interface
+type KVMetricsAdapter struct {
+	collector *Collector
+}
+
+var _ kv.Recorder = (*KVMetricsAdapter)(nil)
+
+// NewKVMetricsAdapter creates a new adapter for the node's KV store
+func NewKVMetricsAdapter(collector *Collector) *KVMetricsAdapter {
+	return &KVMetricsAdapter{collector: collector}
+}
+
+// RecordOp records a store operation
+func (a *KVMetricsAdapter) RecordOp(op string, latency time.Duration, failed bool) {
+	a.collector.RecordKVOp(op, latency, failed)
+}
+
+// RecordBatch records the size of a committed batch
+func (a *KVMetricsAdapter) RecordBatch(op string, writes, bytes int) {
+	a.collector.RecordKVBatch(op, writes, bytes)
+}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/ecirlabs/matrix-core/internal/matrix"
 )
 
@@ -10,6 +12,8 @@ type MatrixMetricsAdapter struct {
 	matrixID  string
 }
 
+var _ matrix.StepRecorder = (*MatrixMetricsAdapter)(nil)
+
 // NewMatrixMetricsAdapter creates a new adapter for a specific matrix
 func NewMatrixMetricsAdapter(collector *Collector, matrixID string) *MatrixMetricsAdapter {
 	return &MatrixMetricsAdapter{
@@ -23,6 +27,16 @@ func (a *MatrixMetricsAdapter) RecordEvent(event matrix.Event) {
 	a.collector.RecordMatrixEvent(a.matrixID, event.Type)
 }
 
+// RecordRule records a rule evaluation
+func (a *MatrixMetricsAdapter) RecordRule(ruleID string, latency time.Duration, events int) {
+	a.collector.RecordMatrixRule(a.matrixID, ruleID, latency, events)
+}
+
+// RecordStep records a completed step
+func (a *MatrixMetricsAdapter) RecordStep(duration time.Duration, events int) {
+	a.collector.RecordMatrixStep(a.matrixID, duration, events)
+}
+
 // GetMetrics returns current metrics for the matrix
 func (a *MatrixMetricsAdapter) GetMetrics() map[string]float64 {
 	// Return empty map for now - can be extended to return actual metrics
@@ -1,6 +1,10 @@
 package metrics
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -23,6 +27,16 @@ var (
 		Help: "Size of soul memory in bytes",
 	}, []string{"soul_id"})
 
+	soulMemoryCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_soul_memory_count",
+		Help: "Number of memories a soul holds",
+	}, []string{"soul_id"})
+
+	soulMemoryEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_soul_memory_evictions",
+		Help: "Number of soul memories forgotten or compressed, by reason",
+	}, []string{"soul_id", "reason"})
+
 	// Matrix metrics
 	matrixCount = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "matrix_count",
@@ -34,6 +48,29 @@ var (
 		Help: "Number of matrix events by type",
 	}, []string{"matrix_id", "event_type"})
 
+	matrixRuleSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_rule_eval_seconds",
+		Help:    "Time spent evaluating a matrix rule in seconds",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"matrix_id", "rule_id"})
+
+	matrixRuleEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_rule_events",
+		Help: "Number of events emitted by a matrix rule",
+	}, []string{"matrix_id", "rule_id"})
+
+	matrixStepSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_step_duration_seconds",
+		Help:    "Duration of matrix steps in seconds",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"matrix_id"})
+
+	matrixStepEvents = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_step_events",
+		Help:    "Number of events applied by a matrix step",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"matrix_id"})
+
 	// Agent metrics
 	agentCount = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "matrix_agent_count",
@@ -45,6 +82,125 @@ var (
 		Help: "Memory usage by agent in bytes",
 	}, []string{"agent_id"})
 
+	agentFuelConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_agent_fuel_consumed",
+		Help: "Fuel consumed by agent invocations",
+	}, []string{"agent_id"})
+
+	agentInvocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_agent_invocations",
+		Help: "Number of agent invocations by result",
+	}, []string{"agent_id", "result"})
+
+	agentExecSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_agent_exec_seconds",
+		Help: "Time spent executing agent code in seconds",
+	}, []string{"agent_id"})
+
+	agentMailboxDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_agent_mailbox_depth",
+		Help: "Number of messages queued for an agent",
+	}, []string{"agent_id"})
+
+	agentMailboxDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_agent_mailbox_dropped",
+		Help: "Number of messages dropped by agent mailboxes",
+	}, []string{"agent_id", "reason"})
+
+	// Storage metrics
+	kvOpSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_kv_op_seconds",
+		Help:    "Latency of KV store operations in seconds",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"op"})
+
+	kvOpErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_kv_op_errors",
+		Help: "Number of failed KV store operations",
+	}, []string{"op"})
+
+	kvBatchWrites = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_kv_batch_writes",
+		Help:    "Number of writes committed atomically by a KV store operation",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"op"})
+
+	kvBatchBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "matrix_kv_batch_bytes",
+		Help:    "Bytes committed atomically by a KV store operation",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"op"})
+
+	kvCompactions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_compactions",
+		Help: "Number of compactions the storage engine has run",
+	})
+
+	kvCompactionDebt = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_compaction_debt_bytes",
+		Help: "Bytes the storage engine must compact to reach a stable shape",
+	})
+
+	kvCompactionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_compactions_active",
+		Help: "Number of compactions in progress",
+	})
+
+	kvFlushes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_flushes",
+		Help: "Number of memtable flushes the storage engine has run",
+	})
+
+	kvReadAmp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_read_amplification",
+		Help: "Number of files a point read may consult",
+	})
+
+	kvBlockCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_block_cache_size_bytes",
+		Help: "Bytes held by the storage engine's block cache",
+	})
+
+	kvBlockCacheHits = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_block_cache_hits",
+		Help: "Number of block cache hits",
+	})
+
+	kvBlockCacheMisses = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_block_cache_misses",
+		Help: "Number of block cache misses",
+	})
+
+	kvMemTableSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_memtable_size_bytes",
+		Help: "Bytes held by the storage engine's memtables",
+	})
+
+	kvWALSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_wal_size_bytes",
+		Help: "Bytes in the storage engine's write-ahead log",
+	})
+
+	kvDiskUsage = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_kv_disk_usage_bytes",
+		Help: "Bytes of disk used by the storage engine",
+	})
+
+	kvLevelFiles = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_kv_level_files",
+		Help: "Number of files in a level of the storage engine",
+	}, []string{"level"})
+
+	kvLevelSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_kv_level_size_bytes",
+		Help: "Bytes in a level of the storage engine",
+	}, []string{"level"})
+
+	kvLevelScore = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_kv_level_score",
+		Help: "Compaction score of a level of the storage engine; 1 or more needs compacting",
+	}, []string{"level"})
+
 	// Message metrics
 	messageCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "matrix_message_count",
@@ -75,6 +231,24 @@ func (c *Collector) RecordSoulMemory(soulID string, size int64) {
 	soulMemorySize.WithLabelValues(soulID).Set(float64(size))
 }
 
+// RecordSoulMemoryCount updates the soul memory count metric
+func (c *Collector) RecordSoulMemoryCount(soulID string, count int) {
+	soulMemoryCount.WithLabelValues(soulID).Set(float64(count))
+}
+
+// RemoveSoul drops a deleted soul's metric series
+func (c *Collector) RemoveSoul(soulID string) {
+	labels := prometheus.Labels{"soul_id": soulID}
+	soulMemorySize.DeletePartialMatch(labels)
+	soulMemoryCount.DeletePartialMatch(labels)
+	soulMemoryEvictions.DeletePartialMatch(labels)
+}
+
+// RecordSoulEviction counts soul memories forgotten or compressed
+func (c *Collector) RecordSoulEviction(soulID, reason string, count int) {
+	soulMemoryEvictions.WithLabelValues(soulID, reason).Add(float64(count))
+}
+
 // RecordMatrixCount updates the matrix count metric
 func (c *Collector) RecordMatrixCount(count int) {
 	matrixCount.Set(float64(count))
@@ -85,6 +259,19 @@ func (c *Collector) RecordMatrixEvent(matrixID, eventType string) {
 	matrixEventCount.WithLabelValues(matrixID, eventType).Inc()
 }
 
+// RecordMatrixRule observes a matrix rule evaluation and the events it
+// emitted
+func (c *Collector) RecordMatrixRule(matrixID, ruleID string, latency time.Duration, events int) {
+	matrixRuleSeconds.WithLabelValues(matrixID, ruleID).Observe(latency.Seconds())
+	matrixRuleEvents.WithLabelValues(matrixID, ruleID).Add(float64(events))
+}
+
+// RecordMatrixStep observes a matrix step's duration and applied events
+func (c *Collector) RecordMatrixStep(matrixID string, duration time.Duration, events int) {
+	matrixStepSeconds.WithLabelValues(matrixID).Observe(duration.Seconds())
+	matrixStepEvents.WithLabelValues(matrixID).Observe(float64(events))
+}
+
 // RecordAgentCount updates the agent count metric
 func (c *Collector) RecordAgentCount(count int) {
 	agentCount.Set(float64(count))
@@ -95,7 +282,68 @@ func (c *Collector) RecordAgentMemory(agentID string, usage int64) {
 	agentMemoryUsage.WithLabelValues(agentID).Set(float64(usage))
 }
 
+// RecordAgentFuel adds fuel consumed by an agent invocation
+func (c *Collector) RecordAgentFuel(agentID string, consumed uint64) {
+	agentFuelConsumed.WithLabelValues(agentID).Add(float64(consumed))
+}
+
+// RecordAgentInvocation counts an agent invocation and its execution time
+func (c *Collector) RecordAgentInvocation(agentID string, duration time.Duration, failed bool) {
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	agentInvocations.WithLabelValues(agentID, result).Inc()
+	agentExecSeconds.WithLabelValues(agentID).Add(duration.Seconds())
+}
+
+// RecordMailboxDepth updates the agent mailbox depth metric
+func (c *Collector) RecordMailboxDepth(agentID string, depth int) {
+	agentMailboxDepth.WithLabelValues(agentID).Set(float64(depth))
+}
+
+// RecordMailboxDropped increments the dropped message counter for an agent
+func (c *Collector) RecordMailboxDropped(agentID, reason string) {
+	agentMailboxDropped.WithLabelValues(agentID, reason).Inc()
+}
+
 // RecordMessage increments the message counter for a topic
 func (c *Collector) RecordMessage(topic string) {
 	messageCount.WithLabelValues(topic).Inc()
 }
+
+// RecordKVOp records a KV store operation's latency and failure
+func (c *Collector) RecordKVOp(op string, latency time.Duration, failed bool) {
+	kvOpSeconds.WithLabelValues(op).Observe(latency.Seconds())
+	if failed {
+		kvOpErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// RecordKVBatch records the writes and bytes a KV store operation
+// committed atomically
+func (c *Collector) RecordKVBatch(op string, writes, bytes int) {
+	kvBatchWrites.WithLabelValues(op).Observe(float64(writes))
+	kvBatchBytes.WithLabelValues(op).Observe(float64(bytes))
+}
+
+// RecordKVEngine records the storage engine's internal measurements
+func (c *Collector) RecordKVEngine(stats kv.EngineStats) {
+	kvCompactions.Set(float64(stats.Compactions))
+	kvCompactionDebt.Set(float64(stats.CompactionDebt))
+	kvCompactionsActive.Set(float64(stats.CompactionsActive))
+	kvFlushes.Set(float64(stats.Flushes))
+	kvReadAmp.Set(float64(stats.ReadAmp))
+	kvBlockCacheSize.Set(float64(stats.BlockCacheSize))
+	kvBlockCacheHits.Set(float64(stats.BlockCacheHits))
+	kvBlockCacheMisses.Set(float64(stats.BlockCacheMisses))
+	kvMemTableSize.Set(float64(stats.MemTableSize))
+	kvWALSize.Set(float64(stats.WALSize))
+	kvDiskUsage.Set(float64(stats.DiskUsage))
+	for i, l := range stats.Levels {
+		level := strconv.Itoa(i)
+		kvLevelFiles.WithLabelValues(level).Set(float64(l.Files))
+		kvLevelSize.WithLabelValues(level).Set(float64(l.Size))
+		kvLevelScore.WithLabelValues(level).Set(l.Score)
+	}
+}
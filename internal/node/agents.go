@@ -0,0 +1,79 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ecirlabs/matrix-core/internal/admin"
+	"github.com/ecirlabs/matrix-core/internal/agent"
+)
+
+// StartAgent creates and starts an agent under the node's supervisor. The
+// agent's code is always checked against the node's artifact verifier,
+// whatever cfg.Verifier says; the node's admin log, router, store, signing
+// keys, module cache, runtime pool, usage ledger and metrics fill any of
+// those cfg leaves unset.
+func (n *Node) StartAgent(ctx context.Context, cfg agent.Config, limits agent.ResourceLimits) (*agent.Agent, error) {
+	if n.supervisor == nil {
+		return nil, fmt.Errorf("node is not started")
+	}
+
+	cfg.Verifier = n.verifier
+	if cfg.Logger == nil {
+		cfg.Logger = n.adminServer.GetLogsService()
+	}
+	if cfg.Messenger == nil {
+		cfg.Messenger = n.router
+	}
+	if cfg.PubSub == nil {
+		cfg.PubSub = n.router
+	}
+	if cfg.Store == nil {
+		cfg.Store = n.kvStore
+	}
+	if cfg.Keys == nil {
+		cfg.Keys = n.agentKeys
+	}
+	if cfg.Cache == nil {
+		cfg.Cache = n.modCache
+	}
+	if cfg.Pool == nil {
+		cfg.Pool = n.rtPool
+	}
+	if cfg.Usage == nil {
+		cfg.Usage = n.usage
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = n.metrics
+	}
+
+	a, err := n.supervisor.Supervise(ctx, cfg, limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start agent %s: %w", cfg.ID, err)
+	}
+	return a, nil
+}
+
+// RunAgent starts the agent of an artifact deployment as its manifest and
+// config describe
+func (n *Node) RunAgent(ctx context.Context, deployment *admin.Deployment, code, signature []byte) error {
+	cfg := agent.Config{
+		ID:         deployment.ID,
+		Code:       code,
+		Signature:  signature,
+		InitConfig: deployment.Config,
+	}
+	if deployment.Manifest != nil {
+		deployment.Manifest.Apply(&cfg)
+	}
+	_, err := n.StartAgent(ctx, cfg, deployment.Limits)
+	return err
+}
+
+// StopAgent stops a supervised agent
+func (n *Node) StopAgent(ctx context.Context, id string) error {
+	if n.supervisor == nil {
+		return fmt.Errorf("node is not started")
+	}
+	return n.supervisor.Stop(ctx, id)
+}
@@ -0,0 +1,187 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/matrix"
+	"github.com/ecirlabs/matrix-core/internal/metrics"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// MatrixManager owns the node's live matrices. Each matrix is journaled to
+// the node's store, publishes to its event bus, and runs until deleted or
+// the manager is closed.
+type MatrixManager struct {
+	ctx      context.Context
+	store    *kv.Store
+	metrics  *metrics.Collector
+	bus      *transport.EventBus
+	matrices map[string]*managedMatrix
+	mu       sync.RWMutex
+}
+
+// managedMatrix is a live matrix and its run loop
+type managedMatrix struct {
+	m      *matrix.Matrix
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMatrixManager creates a matrix manager. Any of store, collector, and
+// bus may be nil.
+func NewMatrixManager(ctx context.Context, store *kv.Store, collector *metrics.Collector, bus *transport.EventBus) *MatrixManager {
+	return &MatrixManager{
+		ctx:      ctx,
+		store:    store,
+		metrics:  collector,
+		bus:      bus,
+		matrices: make(map[string]*managedMatrix),
+	}
+}
+
+// Create builds a matrix from a scenario, or an empty one when scenario is
+// nil, and starts running it. A journal left by an earlier matrix with the
+// same ID is discarded.
+func (mm *MatrixManager) Create(id string, scenario *matrix.Scenario) (*matrix.Matrix, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if _, exists := mm.matrices[id]; exists {
+		return nil, fmt.Errorf("matrix %s already exists", id)
+	}
+
+	var collector matrix.MetricsCollector = noopMetrics{}
+	if mm.metrics != nil {
+		collector = metrics.NewMatrixMetricsAdapter(mm.metrics, id)
+	}
+
+	cfg := matrix.DefaultRunConfig
+	var m *matrix.Matrix
+	if scenario != nil {
+		built := *scenario
+		built.ID = id
+		var err error
+		if m, err = built.Build(collector); err != nil {
+			return nil, fmt.Errorf("failed to build matrix %s: %w", id, err)
+		}
+		cfg = scenario.RunConfig(cfg)
+	} else {
+		m = matrix.New(id, collector)
+	}
+
+	if mm.store != nil {
+		journal, err := matrix.NewJournal(mm.store, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := journal.Truncate(0); err != nil {
+			return nil, fmt.Errorf("failed to reset journal of matrix %s: %w", id, err)
+		}
+		m.SetJournal(journal)
+	}
+	if mm.bus != nil {
+		m.SetEventBus(mm.bus)
+	}
+
+	ctx, cancel := context.WithCancel(mm.ctx)
+	managed := &managedMatrix{m: m, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(managed.done)
+		if err := m.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Printf("Warning: matrix %s stopped: %v\n", id, err)
+		}
+	}()
+
+	mm.matrices[id] = managed
+	mm.recordCount()
+	return m, nil
+}
+
+// Get returns a live matrix by ID
+func (mm *MatrixManager) Get(id string) (*matrix.Matrix, bool) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	managed, exists := mm.matrices[id]
+	if !exists {
+		return nil, false
+	}
+	return managed.m, true
+}
+
+// List returns the live matrices, sorted by ID
+func (mm *MatrixManager) List() []*matrix.Matrix {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	result := make([]*matrix.Matrix, 0, len(mm.matrices))
+	for _, managed := range mm.matrices {
+		result = append(result, managed.m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Stop ends a matrix's run but keeps it, so its state and journal can still
+// be inspected
+func (mm *MatrixManager) Stop(id string) error {
+	m, exists := mm.Get(id)
+	if !exists {
+		return fmt.Errorf("matrix %s not found", id)
+	}
+	if err := m.Stop(); err != nil && !errors.Is(err, matrix.ErrNotRunning) {
+		return err
+	}
+	return nil
+}
+
+// Delete stops a matrix and waits for its run to end before forgetting it
+func (mm *MatrixManager) Delete(id string) error {
+	mm.mu.Lock()
+	managed, exists := mm.matrices[id]
+	delete(mm.matrices, id)
+	mm.recordCount()
+	mm.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("matrix %s not found", id)
+	}
+
+	managed.cancel()
+	<-managed.done
+	return nil
+}
+
+// Close stops every matrix
+func (mm *MatrixManager) Close() {
+	mm.mu.Lock()
+	matrices := mm.matrices
+	mm.matrices = make(map[string]*managedMatrix)
+	mm.recordCount()
+	mm.mu.Unlock()
+
+	for _, managed := range matrices {
+		managed.cancel()
+	}
+	for _, managed := range matrices {
+		<-managed.done
+	}
+}
+
+// recordCount updates the matrix count gauge. mu must be held.
+func (mm *MatrixManager) recordCount() {
+	if mm.metrics != nil {
+		mm.metrics.RecordMatrixCount(len(mm.matrices))
+	}
+}
+
+// noopMetrics discards matrix metrics when the node has no collector
+type noopMetrics struct{}
+
+func (noopMetrics) RecordEvent(matrix.Event) {}
+
+func (noopMetrics) GetMetrics() map[string]float64 { return nil }
@@ -0,0 +1,193 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/admin"
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/matrix"
+)
+
+const herdScenario = `
+id: herd
+seed: 7
+populations:
+  - {type: prey, count: 3, state: {energy: {value: 1}}}
+`
+
+// waitState waits for a matrix's run loop to reach state
+func waitState(t *testing.T, m *matrix.Matrix, state matrix.RunState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("matrix %s state = %v, want %v", m.ID, m.State(), state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMatrixManager(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+	scenario, err := matrix.ParseScenario([]byte(herdScenario))
+	if err != nil {
+		t.Fatalf("ParseScenario() error = %v", err)
+	}
+
+	mm := NewMatrixManager(context.Background(), store, nil, nil)
+	herd, err := mm.Create("b-herd", scenario)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if herd.ID != "b-herd" {
+		t.Errorf("ID = %s, want the deployment ID over the scenario's", herd.ID)
+	}
+	if agents, _, _ := herd.ListAgents(matrix.AgentFilters{}); len(agents) != 3 {
+		t.Errorf("built %d agents, want 3", len(agents))
+	}
+	if _, err := mm.Create("a-empty", nil); err != nil {
+		t.Fatalf("Create(nil scenario) error = %v", err)
+	}
+	if _, err := mm.Create("a-empty", nil); err == nil {
+		t.Errorf("Create() with a duplicate ID succeeded")
+	}
+
+	list := mm.List()
+	if len(list) != 2 || list[0].ID != "a-empty" || list[1].ID != "b-herd" {
+		t.Fatalf("List() = %v, want a-empty and b-herd in order", list)
+	}
+	waitState(t, herd, matrix.RunStateRunning)
+
+	// Stopped matrices stay inspectable until deleted
+	if err := mm.Stop("b-herd"); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	waitState(t, herd, matrix.RunStateIdle)
+	if err := mm.Stop("b-herd"); err != nil {
+		t.Errorf("Stop() of a stopped matrix error = %v", err)
+	}
+	if _, ok := mm.Get("b-herd"); !ok {
+		t.Errorf("Get() after Stop() found nothing")
+	}
+	if err := mm.Stop("missing"); err == nil {
+		t.Errorf("Stop() of an unknown matrix succeeded")
+	}
+
+	if err := mm.Delete("b-herd"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := mm.Get("b-herd"); ok {
+		t.Errorf("Get() after Delete() found the matrix")
+	}
+	if err := mm.Delete("b-herd"); err == nil {
+		t.Errorf("Delete() of a deleted matrix succeeded")
+	}
+
+	mm.Close()
+	if n := len(mm.List()); n != 0 {
+		t.Errorf("List() after Close() = %d matrices, want 0", n)
+	}
+}
+
+func TestMatrixManager_Deployments(t *testing.T) {
+	store, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer store.Close()
+	mm := NewMatrixManager(context.Background(), store, nil, nil)
+	defer mm.Close()
+
+	ctx := context.Background()
+	deploy := admin.NewDeployService(nil)
+	deploy.SetMatrixDeployer(mm)
+
+	if err := deploy.DeployMatrix(ctx, "herd", map[string]interface{}{admin.ScenarioConfigKey: herdScenario}); err != nil {
+		t.Fatalf("DeployMatrix() error = %v", err)
+	}
+	m, ok := mm.Get("herd")
+	if !ok {
+		t.Fatalf("deployed matrix is not live")
+	}
+	if agents, _, _ := m.ListAgents(matrix.AgentFilters{}); len(agents) != 3 {
+		t.Errorf("deployed matrix has %d agents, want the scenario's 3", len(agents))
+	}
+	if err := deploy.DeployMatrix(ctx, "herd", nil); err == nil {
+		t.Errorf("DeployMatrix() with a duplicate ID succeeded")
+	}
+
+	if err := deploy.StopDeployment(ctx, "herd"); err != nil {
+		t.Fatalf("StopDeployment() error = %v", err)
+	}
+	waitState(t, m, matrix.RunStateIdle)
+
+	if err := deploy.RemoveDeployment(ctx, "herd"); err != nil {
+		t.Fatalf("RemoveDeployment() error = %v", err)
+	}
+	if _, ok := mm.Get("herd"); ok {
+		t.Errorf("removed matrix is still live")
+	}
+}
+
+func TestNode_Deployments(t *testing.T) {
+	n := startNode(t, nil)
+	deploy := n.adminServer.GetDeployService()
+
+	t.Run("matrix", func(t *testing.T) {
+		if err := deploy.DeployMatrix(adminContext, "herd", map[string]interface{}{admin.ScenarioConfigKey: herdScenario}); err != nil {
+			t.Fatalf("DeployMatrix() error = %v", err)
+		}
+		m, ok := n.Matrix("herd")
+		if !ok {
+			t.Fatalf("deployed matrix is not live")
+		}
+		if got := n.GetMatrixManager().List(); len(got) != 1 || got[0] != m {
+			t.Errorf("List() = %v, want the deployed matrix", got)
+		}
+
+		if err := deploy.StopDeployment(adminContext, "herd"); err != nil {
+			t.Fatalf("StopDeployment() error = %v", err)
+		}
+		waitState(t, m, matrix.RunStateIdle)
+
+		if err := deploy.RemoveDeployment(adminContext, "herd"); err != nil {
+			t.Fatalf("RemoveDeployment() error = %v", err)
+		}
+		if _, ok := n.Matrix("herd"); ok {
+			t.Errorf("removed matrix is still live")
+		}
+	})
+
+	t.Run("agent", func(t *testing.T) {
+		artifact := admin.AgentArtifact{
+			Code:     logStartWasm,
+			Manifest: []byte("name: greeter\nversion: 1.0.0\ncapabilities: [log]\nresources: {memory_pages: 1}\n"),
+		}
+		if _, err := deploy.DeployAgentArtifact(adminContext, "greeter", artifact); err != nil {
+			t.Fatalf("DeployAgentArtifact() error = %v", err)
+		}
+		a, ok := n.GetAgentSupervisor().Agent("greeter")
+		if !ok {
+			t.Fatalf("deployed agent is not running")
+		}
+		if !a.HasCapability("log") {
+			t.Errorf("deployed agent lacks the capability its manifest requests")
+		}
+
+		if err := deploy.StopDeployment(adminContext, "greeter"); err != nil {
+			t.Fatalf("StopDeployment() error = %v", err)
+		}
+		if _, ok := n.GetAgentSupervisor().Agent("greeter"); ok {
+			t.Errorf("stopped agent is still running")
+		}
+		if err := deploy.RemoveDeployment(adminContext, "greeter"); err != nil {
+			t.Errorf("RemoveDeployment() error = %v", err)
+		}
+	})
+}
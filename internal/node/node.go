@@ -2,10 +2,12 @@ package node
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/ecirlabs/matrix-core/internal/admin"
 	"github.com/ecirlabs/matrix-core/internal/agent"
@@ -14,6 +16,7 @@ import (
 	"github.com/ecirlabs/matrix-core/internal/metrics"
 	"github.com/ecirlabs/matrix-core/internal/p2p"
 	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/ecirlabs/matrix-core/internal/trainer"
 	"github.com/ecirlabs/matrix-core/internal/transport"
 	"gopkg.in/yaml.v3"
 )
@@ -21,20 +24,36 @@ import (
 // Config represents the node configuration
 type Config struct {
 	Network struct {
-		ListenAddr     string   `yaml:"listen_addr"`
-		BootstrapPeers []string `yaml:"bootstrap_peers"`
+		ListenAddr        string        `yaml:"listen_addr"`
+		BootstrapPeers    []string      `yaml:"bootstrap_peers"`
+		MinPeers          int           `yaml:"min_peers"`          // Peer count below which bootstrap peers are redialed; 0 uses the default
+		BootstrapInterval time.Duration `yaml:"bootstrap_interval"` // How often the peer count is checked; 0 uses the default
+		DHTMode           string        `yaml:"dht_mode"`           // client, server, or auto; empty is auto
 	} `yaml:"network"`
 	Storage struct {
-		Engine string `yaml:"engine"`
-		Path   string `yaml:"path"`
+		Engine         string `yaml:"engine"`
+		Path           string `yaml:"path"`
+		Durability     string `yaml:"durability"`       // sync, async, or group; empty is sync
+		CacheSize      int64  `yaml:"cache_size"`       // Bytes of block cache; 0 uses the engine default
+		TargetFileSize int64  `yaml:"target_file_size"` // Bytes per file of the first LSM level; 0 uses the engine default
 	} `yaml:"storage"`
 	Security struct {
-		EnableACLs          bool `yaml:"enable_acls"`
-		AllowUnsignedAgents bool `yaml:"allow_unsigned_agents"`
+		EnableACLs          bool     `yaml:"enable_acls"`
+		AllowUnsignedAgents bool     `yaml:"allow_unsigned_agents"`
+		TrustedSigners      []string `yaml:"trusted_signers"` // Hex-encoded ed25519 public keys
+		MasterKey           string   `yaml:"master_key"`      // Hex-encoded 32-byte key encrypting souls and agent keys at rest
 	} `yaml:"security"`
 	Admin struct {
 		Addr string `yaml:"addr"`
 	} `yaml:"admin"`
+	Souls struct {
+		MaxMemories    int    `yaml:"max_memories"`     // Per soul; 0 means unlimited
+		MaxMemoryBytes int    `yaml:"max_memory_bytes"` // Per soul; 0 means unlimited
+		Forgetting     string `yaml:"forgetting"`       // lru or importance
+		RejectWhenFull bool   `yaml:"reject_when_full"` // Fail new memories instead of forgetting
+		Templates      string `yaml:"templates"`        // Path of a persona templates file
+	} `yaml:"souls"`
+	Trainer trainer.Config `yaml:"trainer"` // Training is off without objectives
 }
 
 // Node represents a Matrix node instance
@@ -45,15 +64,21 @@ type Node struct {
 	p2pHost    *p2p.Host
 	transport  *transport.Transport
 	eventBus   *transport.EventBus
+	router     *agent.Router
+	supervisor *agent.AgentSupervisor
+	usage      *agent.UsageLedger
+	verifier   *agent.ArtifactVerifier
+	agentKeys  *agent.KeyStore
+	modCache   *agent.ModuleCache
+	rtPool     *agent.RuntimePool
 	kvStore    *kv.Store
 	metrics    *metrics.Collector
 	adminServer *admin.Server
 	agents     map[string]*agent.Agent
 	agentsMu   sync.RWMutex
-	souls      map[string]*soul.Soul
-	soulsMu    sync.RWMutex
-	matrices   map[string]*matrix.Matrix
-	matricesMu sync.RWMutex
+	souls      *SoulManager
+	matrices   *MatrixManager
+	trainer    *trainer.Trainer
 }
 
 // Initialize creates a new node configuration
@@ -61,7 +86,7 @@ func Initialize(configPath string) error {
 	// Create default configuration
 	config := &Config{}
 	config.Network.ListenAddr = "0.0.0.0:9000"
-	config.Storage.Engine = "pebble"
+	config.Storage.Engine = kv.EnginePebble
 	config.Storage.Path = "./data"
 	config.Security.EnableACLs = true
 	config.Security.AllowUnsignedAgents = false
@@ -117,11 +142,16 @@ func New(ctx context.Context, configPath string) (*Node, error) {
 		cancel:   cancel,
 		config:   config,
 		agents:   make(map[string]*agent.Agent),
-		souls:    make(map[string]*soul.Soul),
-		matrices: make(map[string]*matrix.Matrix),
 	}, nil
 }
 
+// UseMemoryStorage keeps the node's store in memory, so its state is lost
+// when it stops. Compiled modules are still cached under the storage path.
+// It must be called before Start.
+func (n *Node) UseMemoryStorage() {
+	n.config.Storage.Engine = kv.EngineMemory
+}
+
 // Start initializes and starts all node components
 func (n *Node) Start() error {
 	// Initialize metrics collector
@@ -131,15 +161,92 @@ func (n *Node) Start() error {
 	n.eventBus = transport.NewEventBus()
 
 	// Initialize KV store
-	kvStore, err := kv.New(kv.Config{Path: n.config.Storage.Path})
+	durability, err := kv.ParseDurability(n.config.Storage.Durability)
+	if err != nil {
+		return fmt.Errorf("invalid storage config: %w", err)
+	}
+	kvStore, err := kv.New(kv.Config{
+		Engine:         n.config.Storage.Engine,
+		Path:           n.config.Storage.Path,
+		Durability:     durability,
+		CacheSize:      n.config.Storage.CacheSize,
+		TargetFileSize: n.config.Storage.TargetFileSize,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to initialize KV store: %w", err)
 	}
 	n.kvStore = kvStore
 
+	// Bring on-disk layouts up to date before anything reads them
+	migrated, err := kvStore.Migrate(kv.MigrateOptions{})
+	for _, m := range migrated {
+		fmt.Printf("Migrated bucket %s to schema version %d: %s\n", m.Bucket, m.Version, m.Description)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate KV store: %w", err)
+	}
+	kvStore.SetRecorder(metrics.NewKVMetricsAdapter(n.metrics))
+	go n.runStorageMetrics(DefaultStorageMetricsInterval)
+
+	// Load souls persisted in the KV store
+	soulCfg, err := n.soulStoreConfig()
+	if err != nil {
+		return err
+	}
+	souls, err := NewSoulManager(soul.NewStore(kvStore, soulCfg), n.metrics, n.eventBus)
+	if err != nil {
+		return fmt.Errorf("failed to initialize souls: %w", err)
+	}
+	n.souls = souls
+	go souls.RunMetrics(n.ctx, DefaultSoulMetricsInterval)
+	if path := n.config.Souls.Templates; path != "" {
+		templates, err := soul.LoadPersonaTemplates(path)
+		if err != nil {
+			return err
+		}
+		souls.SetTemplates(templates)
+	}
+
+	// Initialize agent signing keys backed by the KV store and sealed with
+	// the master key
+	master, err := n.masterKey()
+	if err != nil {
+		return err
+	}
+	agentKeys, err := agent.NewKeyStore(kvStore, master)
+	if err != nil {
+		return fmt.Errorf("failed to initialize agent keys: %w", err)
+	}
+	n.agentKeys = agentKeys
+
+	// Agent code must be signed by a trusted signer unless explicitly allowed
+	signers, err := agent.ParseSigners(n.config.Security.TrustedSigners)
+	if err != nil {
+		return fmt.Errorf("invalid trusted signers: %w", err)
+	}
+	n.verifier = agent.NewArtifactVerifier(n.config.Security.AllowUnsignedAgents, signers)
+
+	// Initialize compiled module cache shared by all agents and persisted
+	// across restarts
+	modCache, err := agent.OpenModuleCache(n.config.Storage.Path)
+	if err != nil {
+		return fmt.Errorf("failed to initialize module cache: %w", err)
+	}
+	n.modCache = modCache
+
+	// Trusted agents share runtimes; untrusted agents stay isolated
+	n.rtPool = agent.NewRuntimePool(agent.PoolConfig{
+		SharedLevels: []agent.TrustLevel{agent.TrustTrusted, agent.TrustSystem},
+		Cache:        modCache,
+	})
+
 	// Initialize P2P host
 	p2pHost, err := p2p.New(n.ctx, &p2p.Config{
-		ListenAddr: n.config.Network.ListenAddr,
+		ListenAddr:        n.config.Network.ListenAddr,
+		BootstrapPeers:    n.config.Network.BootstrapPeers,
+		MinPeers:          n.config.Network.MinPeers,
+		BootstrapInterval: n.config.Network.BootstrapInterval,
+		DHTMode:           n.config.Network.DHTMode,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to initialize P2P host: %w", err)
@@ -155,13 +262,9 @@ func (n *Node) Start() error {
 	}
 	n.transport = trans
 
-	// Connect to bootstrap peers
-	for _, peerAddr := range n.config.Network.BootstrapPeers {
-		if err := n.p2pHost.Connect(n.ctx, peerAddr); err != nil {
-			// Log but don't fail on bootstrap peer connection errors
-			fmt.Printf("Warning: failed to connect to bootstrap peer %s: %v\n", peerAddr, err)
-		}
-	}
+	// Initialize agent message router
+	n.router = agent.NewRouter(trans, n.eventBus)
+	n.usage = agent.NewUsageLedger(agent.DefaultUsageWindow, agent.DefaultUsageRetention)
 
 	// Initialize admin server with authentication if enabled
 	var apiKeys []*admin.APIKey
@@ -192,6 +295,33 @@ func (n *Node) Start() error {
 	}
 	n.adminServer = adminServer
 
+	// Agent logs, traps and crashes are served by the admin logs service
+	n.supervisor = agent.NewAgentSupervisor(agent.DefaultRestartPolicy, n.router, n.eventBus, adminServer.GetLogsService())
+	n.adminServer.GetAgentsService().SetSource(n)
+	n.adminServer.GetMatricesService().SetSource(n)
+	n.adminServer.GetStorageService().SetStore(kvStore)
+
+	// Track live matrices; deployed matrices are created and removed here
+	n.matrices = NewMatrixManager(n.ctx, kvStore, n.metrics, n.eventBus)
+	n.adminServer.GetDeployService().SetMatrixDeployer(n.matrices)
+
+	// Deployed agents run under the supervisor, held to the node's verifier
+	n.adminServer.GetDeployService().SetArtifactVerifier(n.verifier)
+	n.adminServer.GetDeployService().SetAgentRunner(n)
+
+	// Train bound souls on the matrix events of their agents
+	if len(n.config.Trainer.Objectives) > 0 {
+		t, err := trainer.New(n.config.Trainer, n.souls.BoundSoul, n.eventBus)
+		if err != nil {
+			return fmt.Errorf("failed to initialize trainer: %w", err)
+		}
+		n.trainer = t
+		go t.Run(n.ctx)
+	}
+
+	// Surface agent traps in deployment status
+	go n.watchAgentFailures(n.eventBus.Subscribe(n.ctx, transport.EventTypeAgent))
+
 	// Start admin server
 	if err := n.adminServer.Start(n.ctx); err != nil {
 		return fmt.Errorf("failed to start admin server: %w", err)
@@ -216,6 +346,30 @@ func (n *Node) Stop() error {
 	}
 	n.agentsMu.Unlock()
 
+	// Stop matrices before the store they journal to closes
+	if n.matrices != nil {
+		n.matrices.Close()
+	}
+
+	// Stop supervised agents
+	if n.supervisor != nil {
+		if err := n.supervisor.Close(n.ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop supervised agents: %w", err))
+		}
+	}
+
+	// End agent topic subscriptions
+	if n.router != nil {
+		n.router.Close()
+	}
+
+	// Release compiled agent code
+	if n.modCache != nil {
+		if err := n.modCache.Close(n.ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	// Stop admin server
 	if n.adminServer != nil {
 		if err := n.adminServer.Stop(n.ctx); err != nil {
@@ -242,6 +396,13 @@ func (n *Node) Stop() error {
 		}
 	}
 
+	// Write pending soul changes
+	if n.souls != nil {
+		if err := n.souls.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close souls: %w", err))
+		}
+	}
+
 	// Close KV store
 	if n.kvStore != nil {
 		if err := n.kvStore.Close(); err != nil {
@@ -276,6 +437,196 @@ func (n *Node) GetEventBus() *transport.EventBus {
 	return n.eventBus
 }
 
+// GetRouter returns the agent message router
+func (n *Node) GetRouter() *agent.Router {
+	return n.router
+}
+
+// GetAgentSupervisor returns the supervisor that restarts crashed agents
+func (n *Node) GetAgentSupervisor() *agent.AgentSupervisor {
+	return n.supervisor
+}
+
+// GetArtifactVerifier returns the verifier applied to agent code
+func (n *Node) GetArtifactVerifier() *agent.ArtifactVerifier {
+	return n.verifier
+}
+
+// GetAgentKeys returns the agent signing key store
+func (n *Node) GetAgentKeys() *agent.KeyStore {
+	return n.agentKeys
+}
+
+// GetModuleCache returns the compiled agent module cache
+func (n *Node) GetModuleCache() *agent.ModuleCache {
+	return n.modCache
+}
+
+// GetRuntimePool returns the shared agent runtime pool
+func (n *Node) GetRuntimePool() *agent.RuntimePool {
+	return n.rtPool
+}
+
+// watchAgentFailures records supervisor crash events on the matching
+// deployment
+func (n *Node) watchAgentFailures(events <-chan transport.Event) {
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			trap, isTrap := ev.Data["trap"].(*agent.Trap)
+			if ev.Data["event"] != "crashed" || !isTrap {
+				continue
+			}
+			// Agents started outside the deploy service have no deployment
+			_ = n.adminServer.GetDeployService().ReportFailure(ev.Source, trap)
+		}
+	}
+}
+
+// AgentStats returns resource usage for every agent running on the node
+func (n *Node) AgentStats() []agent.Stats {
+	n.agentsMu.RLock()
+	stats := make([]agent.Stats, 0, len(n.agents))
+	for _, a := range n.agents {
+		stats = append(stats, a.Stats())
+	}
+	n.agentsMu.RUnlock()
+
+	if n.supervisor != nil {
+		for _, a := range n.supervisor.Agents() {
+			stats = append(stats, a.Stats())
+		}
+	}
+	return stats
+}
+
+// AgentOutput returns the buffered stdout and stderr of a running agent
+func (n *Node) AgentOutput(id string) (agent.Output, bool) {
+	n.agentsMu.RLock()
+	a, exists := n.agents[id]
+	n.agentsMu.RUnlock()
+
+	if !exists && n.supervisor != nil {
+		a, exists = n.supervisor.Agent(id)
+	}
+	if !exists {
+		return agent.Output{}, false
+	}
+	return a.Output(), true
+}
+
+// GetUsageLedger returns the ledger agents report consumption to through
+// their Config.Usage
+func (n *Node) GetUsageLedger() *agent.UsageLedger {
+	return n.usage
+}
+
+// AgentUsage returns the usage report for a period
+func (n *Node) AgentUsage(from, to time.Time, id string) agent.UsageReport {
+	if n.usage == nil {
+		return agent.UsageReport{From: from, To: to}
+	}
+	return n.usage.Report(from, to, id)
+}
+
+// BindSoul binds a running agent to one of the node's souls under an access
+// policy
+func (n *Node) BindSoul(agentID, soulID string, access agent.SoulAccess) error {
+	n.agentsMu.RLock()
+	a, exists := n.agents[agentID]
+	n.agentsMu.RUnlock()
+
+	if !exists && n.supervisor != nil {
+		a, exists = n.supervisor.Agent(agentID)
+	}
+	if !exists {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+	if n.souls == nil {
+		return fmt.Errorf("node is not started")
+	}
+	return n.souls.Bind(a, soulID, access)
+}
+
+// AgentDebugger returns the debugger of a running agent, enabling debug
+// mode on first use
+func (n *Node) AgentDebugger(id string) (*agent.Debugger, bool) {
+	n.agentsMu.RLock()
+	a, exists := n.agents[id]
+	n.agentsMu.RUnlock()
+
+	if !exists && n.supervisor != nil {
+		a, exists = n.supervisor.Agent(id)
+	}
+	if !exists {
+		return nil, false
+	}
+	return a.Debug(), true
+}
+
+// Matrix returns a matrix by ID
+func (n *Node) Matrix(id string) (*matrix.Matrix, bool) {
+	if n.matrices == nil {
+		return nil, false
+	}
+	return n.matrices.Get(id)
+}
+
+// soulStoreConfig applies the configured master key and memory quotas to
+// every soul
+func (n *Node) soulStoreConfig() (soul.StoreConfig, error) {
+	var storeCfg soul.StoreConfig
+	master, err := n.masterKey()
+	if err != nil {
+		return storeCfg, err
+	}
+	storeCfg.MasterKey = master
+
+	cfg := n.config.Souls
+	if cfg.MaxMemories > 0 || cfg.MaxMemoryBytes > 0 {
+		policy := &soul.ForgettingPolicy{
+			Policy:      cfg.Forgetting,
+			MaxMemories: cfg.MaxMemories,
+			MaxBytes:    cfg.MaxMemoryBytes,
+			Reject:      cfg.RejectWhenFull,
+		}
+		if n.metrics != nil {
+			policy.Recorder = n.metrics
+		}
+		storeCfg.Forgetting = policy
+	}
+	return storeCfg, nil
+}
+
+// masterKey decodes the configured master key, which is nil when none is
+// set
+func (n *Node) masterKey() ([]byte, error) {
+	key := n.config.Security.MasterKey
+	if key == "" {
+		return nil, nil
+	}
+	master, err := hex.DecodeString(key)
+	if err != nil || len(master) != 32 {
+		return nil, fmt.Errorf("master key must be 32 hex-encoded bytes")
+	}
+	return master, nil
+}
+
+// GetSoulManager returns the soul manager
+func (n *Node) GetSoulManager() *SoulManager {
+	return n.souls
+}
+
+// GetMatrixManager returns the matrix manager
+func (n *Node) GetMatrixManager() *MatrixManager {
+	return n.matrices
+}
+
 // GetKVStore returns the KV store
 func (n *Node) GetKVStore() *kv.Store {
 	return n.kvStore
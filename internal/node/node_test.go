@@ -0,0 +1,167 @@
+package node
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ecirlabs/matrix-core/internal/admin"
+	"github.com/ecirlabs/matrix-core/internal/agent"
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// testAdminKey is the admin API key of nodes started by startNode
+const testAdminKey = "test-admin-key"
+
+// adminContext authenticates admin service calls as testAdminKey
+var adminContext = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", testAdminKey))
+
+// noopStartWasm is a module whose exported _start returns immediately:
+//
+//	(module (func (export "_start")))
+var noopStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00, // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00, // export "_start"
+	0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b, // code: end
+}
+
+// logStartWasm is a module whose _start logs "hello":
+//
+//	(module (import "env" "log" (func $log (param i32 i32))) (memory 1)
+//	  (data (i32.const 0) "hello")
+//	  (func (export "_start") (call $log (i32.const 0) (i32.const 5))))
+var logStartWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic, version
+	0x01, 0x09, 0x02, 0x60, 0x02, 0x7f, 0x7f, 0x00, 0x60, 0x00, 0x00, // type section: (i32, i32) -> (), () -> ()
+	0x02, 0x0b, 0x01, 0x03, 'e', 'n', 'v', 0x03, 'l', 'o', 'g', 0x00, 0x00, // import env.log
+	0x03, 0x02, 0x01, 0x01, // function section
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: min 1
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x01, // export "_start"
+	0x0a, 0x0a, 0x01, 0x08, 0x00, 0x41, 0x00, 0x41, 0x05, 0x10, 0x00, 0x0b, // code: i32.const 0; i32.const 5; call 0; end
+	0x0b, 0x0b, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x05, 'h', 'e', 'l', 'l', 'o', // data: "hello" at 0
+}
+
+// startNode starts a node on loopback with in-memory storage and stops it
+// when the test ends. Admin calls are authenticated by adminContext.
+func startNode(t *testing.T, configure func(*Config)) *Node {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("starts a node")
+	}
+	dir := t.TempDir()
+	cfg := &Config{}
+	cfg.Network.ListenAddr = "/ip4/127.0.0.1/tcp/0"
+	cfg.Network.DHTMode = "client"
+	cfg.Storage.Path = dir
+	cfg.Admin.Addr = "127.0.0.1:0"
+	cfg.Security.EnableACLs = true
+	cfg.Security.AllowUnsignedAgents = true
+	t.Setenv("MATRIX_ADMIN_API_KEY", testAdminKey)
+	if configure != nil {
+		configure(cfg)
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	n, err := New(context.Background(), path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n.UseMemoryStorage()
+	if err := n.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { n.Stop() })
+	return n
+}
+
+func TestNode_StartAgent_Verifies(t *testing.T) {
+	signerPub, signer, _ := ed25519.GenerateKey(nil)
+	n := startNode(t, func(cfg *Config) {
+		cfg.Security.AllowUnsignedAgents = false
+		cfg.Security.TrustedSigners = []string{hex.EncodeToString(signerPub)}
+	})
+	ctx := context.Background()
+	limits := agent.ResourceLimits{MaxMemoryPages: 1}
+
+	// The node's verifier applies even when the caller supplies a laxer one
+	lax := agent.NewArtifactVerifier(true, nil)
+	_, err := n.StartAgent(ctx, agent.Config{ID: "unsigned", Code: noopStartWasm, Verifier: lax}, limits)
+	if !errors.Is(err, agent.ErrUnsignedArtifact) {
+		t.Fatalf("StartAgent(unsigned) error = %v, want ErrUnsignedArtifact", err)
+	}
+
+	cfg := agent.Config{ID: "signed", Code: noopStartWasm, Signature: agent.SignArtifact(signer, noopStartWasm)}
+	if _, err := n.StartAgent(ctx, cfg, limits); err != nil {
+		t.Fatalf("StartAgent(signed) error = %v", err)
+	}
+	if _, ok := n.GetAgentSupervisor().Agent("signed"); !ok {
+		t.Errorf("signed agent is not supervised")
+	}
+	if err := n.StopAgent(ctx, "signed"); err != nil {
+		t.Errorf("StopAgent() error = %v", err)
+	}
+}
+
+func TestNode_AgentLogs(t *testing.T) {
+	n := startNode(t, nil)
+	ctx := context.Background()
+
+	cfg := agent.Config{ID: "logger", Code: logStartWasm, Capabilities: []agent.Capability{agent.CapabilityLog}}
+	if _, err := n.StartAgent(ctx, cfg, agent.ResourceLimits{MaxMemoryPages: 1}); err != nil {
+		t.Fatalf("StartAgent() error = %v", err)
+	}
+
+	logs, err := n.adminServer.GetLogsService().GetLogs(adminContext, admin.LogFilters{Component: "agent"})
+	if err != nil {
+		t.Fatalf("GetLogs() error = %v", err)
+	}
+	for _, entry := range logs {
+		if entry.Message == "hello" && entry.Fields["agent_id"] == "logger" {
+			return
+		}
+	}
+	t.Errorf("guest log line not found in %+v", logs)
+}
+
+func TestSoulManager_ValidatesIDs(t *testing.T) {
+	// Without a store the manager must still hold souls to the store's rule,
+	// so a store added later can persist them
+	sm, err := NewSoulManager(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewSoulManager() error = %v", err)
+	}
+	sm.SetTemplates(map[string]soul.PersonaTemplate{"calm": {Traits: map[string]float64{"calm": 1}}})
+
+	if _, err := sm.Create("a/b"); !errors.Is(err, soul.ErrInvalidID) {
+		t.Errorf("Create(a/b) error = %v, want ErrInvalidID", err)
+	}
+	if _, err := sm.CreateFromTemplate("a/b", "calm"); !errors.Is(err, soul.ErrInvalidID) {
+		t.Errorf("CreateFromTemplate(a/b) error = %v, want ErrInvalidID", err)
+	}
+	_, key, _ := ed25519.GenerateKey(nil)
+	bundle, err := soul.New("a/b").Export(key)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if _, err := sm.Import(bundle, nil); !errors.Is(err, soul.ErrInvalidID) {
+		t.Errorf("Import() of soul a/b error = %v, want ErrInvalidID", err)
+	}
+	if n := sm.Count(); n != 0 {
+		t.Errorf("Count() = %d, want 0", n)
+	}
+}
@@ -0,0 +1,377 @@
+package node
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/agent"
+	"github.com/ecirlabs/matrix-core/internal/metrics"
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// DefaultSoulMetricsInterval is how often RunMetrics records soul memory
+const DefaultSoulMetricsInterval = 15 * time.Second
+
+// Soul lifecycle events published on the event bus under EventTypeSoul,
+// in the event's "event" data
+const (
+	SoulCreated  = "created"
+	SoulImported = "imported"
+	SoulDeleted  = "deleted"
+)
+
+// SoulManager owns the node's souls. Souls created in its store are known
+// from startup and loaded when first used.
+type SoulManager struct {
+	store   *soul.Store
+	metrics *metrics.Collector
+	bus     *transport.EventBus
+	souls   map[string]*soul.Soul
+	mu      sync.RWMutex
+
+	// bindings are the agents bound to souls, by agent ID, and templates
+	// the personas souls can start from; both are guarded by mu
+	bindings  map[string]soulBinding
+	templates map[string]soul.PersonaTemplate
+}
+
+// soulBinding is an agent bound to a soul
+type soulBinding struct {
+	agent  *agent.Agent
+	soulID string
+}
+
+// NewSoulManager creates a soul manager, registering the souls already in
+// store. Without a store, souls live in memory only. collector and bus may
+// be nil.
+func NewSoulManager(store *soul.Store, collector *metrics.Collector, bus *transport.EventBus) (*SoulManager, error) {
+	sm := &SoulManager{
+		store:    store,
+		metrics:  collector,
+		bus:      bus,
+		souls:    make(map[string]*soul.Soul),
+		bindings: make(map[string]soulBinding),
+	}
+	if store != nil {
+		ids, err := store.List()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load souls: %w", err)
+		}
+		for _, id := range ids {
+			s := store.Open(id)
+			s.SetEventBus(bus)
+			sm.souls[id] = s
+		}
+	}
+	sm.recordCount()
+	return sm, nil
+}
+
+// Create creates a soul. The ID must pass soul.ValidateID whether or not
+// the manager has a store.
+func (sm *SoulManager) Create(id string) (*soul.Soul, error) {
+	if err := soul.ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.souls[id]; exists {
+		return nil, fmt.Errorf("soul %s already exists", id)
+	}
+	s := soul.New(id)
+	if sm.store != nil {
+		var err error
+		if s, err = sm.store.Create(id); err != nil {
+			return nil, err
+		}
+	}
+
+	s.SetEventBus(sm.bus)
+	sm.souls[id] = s
+	sm.recordCount()
+	sm.publish(id, SoulCreated)
+	return s, nil
+}
+
+// Import creates a soul from a signed bundle made by Soul.Export. When
+// trusted is not empty, the bundle must be signed by one of its keys.
+func (sm *SoulManager) Import(data []byte, trusted []ed25519.PublicKey) (*soul.Soul, error) {
+	bundle, err := soul.VerifyBundle(data, trusted)
+	if err != nil {
+		return nil, err
+	}
+	if err := soul.ValidateID(bundle.SoulID); err != nil {
+		return nil, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	id := bundle.SoulID
+	if _, exists := sm.souls[id]; exists {
+		return nil, fmt.Errorf("soul %s already exists", id)
+	}
+	s := soul.New(id)
+	if sm.store != nil {
+		if s, err = sm.store.Create(id); err != nil {
+			return nil, err
+		}
+	}
+	s.Restore(bundle)
+
+	s.SetEventBus(sm.bus)
+	sm.souls[id] = s
+	sm.recordCount()
+	sm.publish(id, SoulImported)
+	return s, nil
+}
+
+// SetTemplates sets the persona templates souls can be created from
+func (sm *SoulManager) SetTemplates(templates map[string]soul.PersonaTemplate) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.templates = templates
+}
+
+// Templates returns the names of the persona templates, sorted
+func (sm *SoulManager) Templates() []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	names := make([]string, 0, len(sm.templates))
+	for name := range sm.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CreateFromTemplate creates a soul with a persona template's persona and
+// schema
+func (sm *SoulManager) CreateFromTemplate(id, template string) (*soul.Soul, error) {
+	if err := soul.ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	sm.mu.RLock()
+	t, exists := sm.templates[template]
+	sm.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("persona template %s not found", template)
+	}
+
+	s, err := sm.Create(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ApplyTemplate("", t); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns a soul by ID
+func (sm *SoulManager) Get(id string) (*soul.Soul, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	s, exists := sm.souls[id]
+	return s, exists
+}
+
+// List returns the souls, sorted by ID
+func (sm *SoulManager) List() []*soul.Soul {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	result := make([]*soul.Soul, 0, len(sm.souls))
+	for _, s := range sm.souls {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Count returns the number of souls
+func (sm *SoulManager) Count() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.souls)
+}
+
+// Delete deletes a soul and its persisted data
+func (sm *SoulManager) Delete(id string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.souls[id]; !exists {
+		return fmt.Errorf("soul %s not found", id)
+	}
+	if sm.store != nil {
+		if err := sm.store.Delete(id); err != nil {
+			return err
+		}
+	}
+
+	for agentID, binding := range sm.bindings {
+		if binding.soulID == id {
+			binding.agent.UnbindSoul()
+			delete(sm.bindings, agentID)
+		}
+	}
+	sm.souls[id].SetEventBus(nil)
+	delete(sm.souls, id)
+	sm.recordCount()
+	if sm.metrics != nil {
+		sm.metrics.RemoveSoul(id)
+	}
+	sm.publish(id, SoulDeleted)
+	return nil
+}
+
+// Bind binds an agent to a soul under an access policy, replacing the
+// agent's earlier binding. Many agents may share a soul.
+func (sm *SoulManager) Bind(a *agent.Agent, soulID string, access agent.SoulAccess) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s, exists := sm.souls[soulID]
+	if !exists {
+		return fmt.Errorf("soul %s not found", soulID)
+	}
+	a.BindSoul(s, access)
+	sm.bindings[a.ID] = soulBinding{agent: a, soulID: soulID}
+	return nil
+}
+
+// Unbind removes an agent's soul binding
+func (sm *SoulManager) Unbind(agentID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	binding, exists := sm.bindings[agentID]
+	if !exists {
+		return fmt.Errorf("agent %s is not bound to a soul", agentID)
+	}
+	binding.agent.UnbindSoul()
+	delete(sm.bindings, agentID)
+	return nil
+}
+
+// Bindings returns the IDs of the agents bound to a soul, sorted
+func (sm *SoulManager) Bindings(soulID string) []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	var agents []string
+	for agentID, binding := range sm.bindings {
+		if binding.soulID == soulID {
+			agents = append(agents, agentID)
+		}
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// BoundSoul returns the soul an agent is bound to
+func (sm *SoulManager) BoundSoul(agentID string) (*soul.Soul, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	binding, exists := sm.bindings[agentID]
+	if !exists {
+		return nil, false
+	}
+	s, exists := sm.souls[binding.soulID]
+	return s, exists
+}
+
+// RunConsolidation consolidates the memories of every soul each interval
+// until ctx ends
+func (sm *SoulManager) RunConsolidation(ctx context.Context, cfg soul.ConsolidationConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, s := range sm.List() {
+				if _, err := s.Consolidate(ctx, cfg, now); err != nil && ctx.Err() == nil {
+					fmt.Printf("Warning: failed to consolidate soul %s: %v\n", s.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// RunMetrics records the soul count and the memory size and count of each
+// loaded soul every interval until ctx ends. Souls not yet loaded are
+// skipped rather than loaded for their metrics.
+func (sm *SoulManager) RunMetrics(ctx context.Context, interval time.Duration) {
+	if sm.metrics == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sm.RecordMetrics()
+		}
+	}
+}
+
+// RecordMetrics records the soul count and the memory size and count of
+// each loaded soul
+func (sm *SoulManager) RecordMetrics() {
+	if sm.metrics == nil {
+		return
+	}
+	// Hold mu so a soul deleted meanwhile does not get its series back
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	sm.recordCount()
+	for _, s := range sm.souls {
+		if !s.Loaded() {
+			continue
+		}
+		usage := s.Usage()
+		sm.metrics.RecordSoulMemory(s.ID, int64(usage.Bytes))
+		sm.metrics.RecordSoulMemoryCount(s.ID, usage.Memories)
+	}
+}
+
+// Close writes pending soul changes to the store
+func (sm *SoulManager) Close() error {
+	if sm.store == nil {
+		return nil
+	}
+	return sm.store.Close()
+}
+
+// recordCount updates the soul count gauge. mu must be held.
+func (sm *SoulManager) recordCount() {
+	if sm.metrics != nil {
+		sm.metrics.RecordSoulCount(len(sm.souls))
+	}
+}
+
+// publish announces a soul lifecycle event
+func (sm *SoulManager) publish(id, event string) {
+	if sm.bus == nil {
+		return
+	}
+	sm.bus.Publish(transport.Event{
+		Type:      transport.EventTypeSoul,
+		Source:    id,
+		Timestamp: time.Now().UnixNano(),
+		Data:      map[string]interface{}{"event": event, "soul_id": id},
+	})
+}
@@ -0,0 +1,26 @@
+package node
+
+import (
+	"time"
+)
+
+// DefaultStorageMetricsInterval is how often the storage engine's
+// internal measurements are recorded
+const DefaultStorageMetricsInterval = 15 * time.Second
+
+// runStorageMetrics records the storage engine's measurements every
+// interval until the node stops
+func (n *Node) runStorageMetrics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			if stats, ok := n.kvStore.EngineStats(); ok {
+				n.metrics.RecordKVEngine(stats)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Bootstrap defaults
+const (
+	DefaultMinPeers          = 4
+	DefaultBootstrapInterval = 30 * time.Second
+	DefaultDialTimeout       = 10 * time.Second
+
+	// A bootstrap peer that fails to dial is retried after bootstrapBackoff,
+	// doubling per failure up to maxBootstrapBackoff
+	bootstrapBackoff    = time.Second
+	maxBootstrapBackoff = 5 * time.Minute
+)
+
+// bootstrapPeer is a bootstrap peer and when it may next be dialed
+type bootstrapPeer struct {
+	addr     string
+	info     peer.AddrInfo
+	failures int
+	next     time.Time
+}
+
+// parseBootstrapPeers parses bootstrap peer multiaddrs, which must include
+// the peer ID
+func parseBootstrapPeers(addrs []string) ([]*bootstrapPeer, error) {
+	peers := make([]*bootstrapPeer, 0, len(addrs))
+	for _, addr := range addrs {
+		ma, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %s: %w", addr, err)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(ma)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %s: %w", addr, err)
+		}
+		peers = append(peers, &bootstrapPeer{addr: addr, info: *info})
+	}
+	return peers, nil
+}
+
+// runBootstrap dials the bootstrap peers at startup and again whenever the
+// host has fewer than minPeers peers, topping up from peers found through
+// the DHT, until ctx ends
+func (h *Host) runBootstrap(ctx context.Context, peers []*bootstrapPeer, minPeers int, interval time.Duration) {
+	defer close(h.bootstrapped)
+	for {
+		if len(h.host.Network().Peers()) < minPeers {
+			h.dialBootstrapPeers(ctx, peers)
+		}
+		if n := len(h.host.Network().Peers()); n < minPeers {
+			h.dialDiscoveredPeers(ctx, minPeers-n)
+		}
+
+		// Wake for the next check or, while still short of peers, the
+		// earliest pending retry if sooner
+		wait := interval
+		if len(h.host.Network().Peers()) < minPeers {
+			now := time.Now()
+			for _, p := range peers {
+				if p.next.After(now) && p.next.Sub(now) < wait {
+					wait = p.next.Sub(now)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// dialBootstrapPeers dials, in parallel, the bootstrap peers that are not
+// connected and not backing off
+func (h *Host) dialBootstrapPeers(ctx context.Context, peers []*bootstrapPeer) {
+	var wg sync.WaitGroup
+	now := time.Now()
+	for _, p := range peers {
+		if now.Before(p.next) || h.host.Network().Connectedness(p.info.ID) == network.Connected {
+			continue
+		}
+		wg.Add(1)
+		go func(p *bootstrapPeer) {
+			defer wg.Done()
+			dialCtx, cancel := context.WithTimeout(ctx, DefaultDialTimeout)
+			defer cancel()
+			if err := h.host.Connect(dialCtx, p.info); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				backoff := retryBackoff(p.failures)
+				p.failures++
+				p.next = time.Now().Add(backoff)
+				fmt.Printf("Warning: failed to connect to bootstrap peer %s, retrying in %v: %v\n", p.addr, backoff, err)
+				return
+			}
+			p.failures = 0
+			p.next = time.Time{}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// retryBackoff is how long to wait before redialing a bootstrap peer that
+// has failed failures times before
+func retryBackoff(failures int) time.Duration {
+	return min(bootstrapBackoff<<min(failures, 16), maxBootstrapBackoff)
+}
+
+// dialDiscoveredPeers dials, in parallel, up to n unconnected peers found
+// through the DHT
+func (h *Host) dialDiscoveredPeers(ctx context.Context, n int) {
+	findCtx, cancel := context.WithTimeout(ctx, DefaultDialTimeout)
+	found, err := h.FindPeers(findCtx, 0)
+	cancel()
+	if err != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, info := range found {
+		if n == 0 {
+			break
+		}
+		if h.host.Network().Connectedness(info.ID) == network.Connected {
+			continue
+		}
+		n--
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			dialCtx, cancel := context.WithTimeout(ctx, DefaultDialTimeout)
+			defer cancel()
+			if err := h.host.Connect(dialCtx, info); err != nil && ctx.Err() == nil {
+				fmt.Printf("Warning: failed to connect to discovered peer %s: %v\n", info.ID, err)
+			}
+		}(info)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,142 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func TestParseBootstrapPeers(t *testing.T) {
+	const id = "12D3KooWGRUVh6GwDQ6ePyLcT2RtPAGB2Jc3AvUBFQpWAbkFRTYb"
+	tests := []struct {
+		name    string
+		addrs   []string
+		want    int
+		wantErr bool
+	}{
+		{"none", nil, 0, false},
+		{"tcp", []string{"/ip4/127.0.0.1/tcp/9000/p2p/" + id}, 1, false},
+		{"dns", []string{"/dns4/boot.example.com/tcp/9000/p2p/" + id, "/ip4/10.0.0.1/tcp/9000/p2p/" + id}, 2, false},
+		{"no peer ID", []string{"/ip4/127.0.0.1/tcp/9000"}, 0, true},
+		{"not a multiaddr", []string{"127.0.0.1:9000"}, 0, true},
+		{"bad peer ID", []string{"/ip4/127.0.0.1/tcp/9000/p2p/not-a-peer"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers, err := parseBootstrapPeers(tt.addrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBootstrapPeers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(peers) != tt.want {
+				t.Fatalf("parseBootstrapPeers() = %d peers, want %d", len(peers), tt.want)
+			}
+			for i, p := range peers {
+				if p.addr != tt.addrs[i] || p.info.ID.String() != id || len(p.info.Addrs) != 1 {
+					t.Errorf("peer %d = %+v", i, p)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{8, 256 * time.Second},
+		{9, maxBootstrapBackoff},
+		{64, maxBootstrapBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.failures); got != tt.want {
+			t.Errorf("retryBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// newTestHost starts a DHT server host on loopback, bootstrapped from the
+// given hosts, and closes it when the test ends
+func newTestHost(t *testing.T, bootstrap ...*Host) *Host {
+	t.Helper()
+	var peers []string
+	for _, b := range bootstrap {
+		peers = append(peers, fmt.Sprintf("%s/p2p/%s", b.GetAddrs()[0], b.GetPeerID()))
+	}
+	h, err := New(context.Background(), &Config{
+		ListenAddr:        "/ip4/127.0.0.1/tcp/0",
+		BootstrapPeers:    peers,
+		MinPeers:          1,
+		BootstrapInterval: 100 * time.Millisecond,
+		DHTMode:           "server",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestDialBootstrapPeers_Backoff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts libp2p hosts")
+	}
+	h := newTestHost(t)
+	gone := newTestHost(t)
+	peers, err := parseBootstrapPeers([]string{gone.GetAddrs()[0].String() + "/p2p/" + gone.GetPeerID().String()})
+	if err != nil {
+		t.Fatalf("parseBootstrapPeers() error = %v", err)
+	}
+	gone.Close()
+
+	ctx := context.Background()
+	p := peers[0]
+	for i := 1; i <= 3; i++ {
+		p.next = time.Time{}
+		h.dialBootstrapPeers(ctx, peers)
+		if p.failures != i {
+			t.Fatalf("failures = %d, want %d", p.failures, i)
+		}
+		if want, wait := retryBackoff(i-1), time.Until(p.next); wait > want || wait < want-time.Second {
+			t.Errorf("failure %d: retry in %v, want %v", i, wait, want)
+		}
+	}
+
+	// A peer backing off is not dialed again until its retry is due
+	h.dialBootstrapPeers(ctx, peers)
+	if p.failures != 3 {
+		t.Errorf("failures = %d after dial during backoff, want 3", p.failures)
+	}
+}
+
+func TestHost_Rebootstrap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts libp2p hosts")
+	}
+	seed := newTestHost(t)
+	h := newTestHost(t, seed)
+
+	waitConnected := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for h.GetHost().Network().Connectedness(seed.GetPeerID()) != network.Connected {
+			if time.Now().After(deadline) {
+				t.Fatalf("not connected to bootstrap peer")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitConnected()
+
+	// Dropping below MinPeers redials the bootstrap peer
+	if err := h.GetHost().Network().ClosePeer(seed.GetPeerID()); err != nil {
+		t.Fatalf("ClosePeer() error = %v", err)
+	}
+	waitConnected()
+}
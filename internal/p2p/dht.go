@@ -0,0 +1,164 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/multiformats/go-multihash"
+)
+
+// DHT settings
+const (
+	// DHTProtocolPrefix keeps matrix-core nodes in a DHT of their own,
+	// apart from the public IPFS one
+	DHTProtocolPrefix = "/matrix-core"
+	// DiscoveryNamespace is the namespace nodes advertise themselves under
+	DiscoveryNamespace = "matrix-core/nodes"
+	// DefaultDHTMode is the DHT mode used when none is configured
+	DefaultDHTMode = "auto"
+	// ContentNamespace prefixes content keys so they cannot collide with
+	// the keys other DHT users provide
+	ContentNamespace = "matrix-core/content/"
+)
+
+// ParseDHTMode parses a DHT mode: client, which queries the DHT but does not
+// serve it; server, which does both; or auto, which serves it only while
+// the node is publicly reachable. The empty string is DefaultDHTMode.
+func ParseDHTMode(mode string) (dht.ModeOpt, error) {
+	if mode == "" {
+		mode = DefaultDHTMode
+	}
+	switch mode {
+	case "client":
+		return dht.ModeClient, nil
+	case "server":
+		return dht.ModeServer, nil
+	case "auto":
+		return dht.ModeAuto, nil
+	default:
+		return 0, fmt.Errorf("unknown DHT mode %q", mode)
+	}
+}
+
+// startDHT starts the host's DHT, seeded with the bootstrap peers, and
+// advertises the node under DiscoveryNamespace until ctx ends
+func (h *Host) startDHT(ctx context.Context, mode dht.ModeOpt, peers []*bootstrapPeer) error {
+	seeds := make([]peer.AddrInfo, 0, len(peers))
+	for _, p := range peers {
+		seeds = append(seeds, p.info)
+	}
+	kdht, err := dht.New(ctx, h.host,
+		dht.Mode(mode),
+		dht.ProtocolPrefix(DHTProtocolPrefix),
+		dht.BootstrapPeers(seeds...),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create DHT: %w", err)
+	}
+	if err := kdht.Bootstrap(ctx); err != nil {
+		kdht.Close()
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+	h.dht = kdht
+	h.discovery = drouting.NewRoutingDiscovery(kdht)
+	go h.advertise(ctx)
+	return nil
+}
+
+// advertise advertises the node under DiscoveryNamespace until ctx ends,
+// starting as soon as the DHT has peers to advertise to and renewing the
+// advertisement before it expires
+func (h *Host) advertise(ctx context.Context) {
+	for {
+		wait := bootstrapBackoff
+		if h.dht.RoutingTable().Size() > 0 {
+			ttl, err := h.discovery.Advertise(ctx, DiscoveryNamespace)
+			if err == nil {
+				wait = 7 * ttl / 8
+			} else if ctx.Err() == nil {
+				fmt.Printf("Warning: failed to advertise node: %v\n", err)
+				wait = DefaultBootstrapInterval
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// FindPeers looks up, through the DHT, up to limit other nodes advertising
+// under DiscoveryNamespace. A limit of 0 means no limit.
+func (h *Host) FindPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
+	found, err := dutil.FindPeers(ctx, h.discovery, DiscoveryNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find peers: %w", err)
+	}
+	peers := make([]peer.AddrInfo, 0, len(found))
+	for _, p := range found {
+		if p.ID == h.host.ID() || len(p.Addrs) == 0 {
+			continue
+		}
+		peers = append(peers, p)
+		if limit > 0 && len(peers) == limit {
+			break
+		}
+	}
+	return peers, nil
+}
+
+// ContentID returns the DHT key content is provided under: a CID of the
+// SHA-256 of key within ContentNamespace
+func ContentID(key string) (cid.Cid, error) {
+	mh, err := multihash.Sum([]byte(ContentNamespace+key), multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to hash content key: %w", err)
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// Provide announces, through the DHT, that this node holds the content
+// under key. Provider records expire, so the announcement must be repeated
+// for as long as the node holds the content.
+func (h *Host) Provide(ctx context.Context, key string) error {
+	c, err := ContentID(key)
+	if err != nil {
+		return err
+	}
+	if err := h.dht.Provide(ctx, c, true); err != nil {
+		return fmt.Errorf("failed to provide %s: %w", key, err)
+	}
+	return nil
+}
+
+// FindProviders looks up, through the DHT, up to limit other nodes holding
+// the content under key. A limit of 0 means no limit.
+func (h *Host) FindProviders(ctx context.Context, key string, limit int) ([]peer.AddrInfo, error) {
+	c, err := ContentID(key)
+	if err != nil {
+		return nil, err
+	}
+	// Stop the lookup once enough providers are found
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var providers []peer.AddrInfo
+	seen := make(map[peer.ID]bool)
+	for p := range h.dht.FindProvidersAsync(ctx, c, 0) {
+		if p.ID == h.host.ID() || seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		providers = append(providers, p)
+		if limit > 0 && len(providers) == limit {
+			break
+		}
+	}
+	return providers, nil
+}
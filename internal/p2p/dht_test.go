@@ -0,0 +1,119 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestParseDHTMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    dht.ModeOpt
+		wantErr bool
+	}{
+		{"", dht.ModeAuto, false},
+		{"auto", dht.ModeAuto, false},
+		{"client", dht.ModeClient, false},
+		{"server", dht.ModeServer, false},
+		{"Server", 0, true},
+		{"full", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDHTMode(tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDHTMode(%q) error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseDHTMode(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestContentID(t *testing.T) {
+	a, err := ContentID("artifact-1")
+	if err != nil {
+		t.Fatalf("ContentID() error = %v", err)
+	}
+	again, _ := ContentID("artifact-1")
+	other, _ := ContentID("artifact-2")
+	if !a.Equals(again) {
+		t.Errorf("ContentID() not stable: %v != %v", a, again)
+	}
+	if a.Equals(other) {
+		t.Errorf("ContentID() collides for different keys: %v", a)
+	}
+}
+
+// eventually retries lookup until it finds want or the deadline passes
+func eventually(t *testing.T, want peer.ID, lookup func(ctx context.Context) ([]peer.AddrInfo, error)) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		found, err := lookup(ctx)
+		cancel()
+		if err == nil {
+			for _, p := range found {
+				if p.ID == want {
+					return
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("peer %s not found", want)
+}
+
+func TestHost_Discovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts libp2p hosts")
+	}
+	seed := newTestHost(t)
+	a := newTestHost(t, seed)
+	b := newTestHost(t, seed)
+
+	t.Run("FindPeers", func(t *testing.T) {
+		eventually(t, a.GetPeerID(), func(ctx context.Context) ([]peer.AddrInfo, error) {
+			return b.FindPeers(ctx, 0)
+		})
+	})
+
+	t.Run("FindProviders", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := a.Provide(ctx, "artifact-1"); err != nil {
+			t.Fatalf("Provide() error = %v", err)
+		}
+		eventually(t, a.GetPeerID(), func(ctx context.Context) ([]peer.AddrInfo, error) {
+			return b.FindProviders(ctx, "artifact-1", 0)
+		})
+
+		found, err := b.FindProviders(ctx, "artifact-2", 0)
+		if err != nil {
+			t.Fatalf("FindProviders() error = %v", err)
+		}
+		if len(found) != 0 {
+			t.Errorf("FindProviders() for unprovided key = %v, want none", found)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		found, err := b.FindPeers(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("FindPeers() error = %v", err)
+		}
+		if len(found) > 1 {
+			t.Errorf("FindPeers(limit 1) = %d peers", len(found))
+		}
+		for _, p := range found {
+			if p.ID == b.GetPeerID() {
+				t.Errorf("FindPeers() returned the host itself")
+			}
+		}
+	})
+}
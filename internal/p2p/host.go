@@ -3,22 +3,40 @@ package p2p
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/multiformats/go-multiaddr"
 )
 
 // Host represents a p2p network host
 type Host struct {
-	host host.Host
+	host         host.Host
+	dht          *dht.IpfsDHT
+	discovery    *drouting.RoutingDiscovery
+	cancel       context.CancelFunc
+	bootstrapped chan struct{} // Closed when the bootstrap loop exits
 }
 
 // Config represents p2p host configuration
 type Config struct {
 	ListenAddr string
-	// Add more config options as needed
+
+	// BootstrapPeers are multiaddrs, with peer IDs, dialed at startup and
+	// whenever the host has fewer than MinPeers peers
+	BootstrapPeers []string
+	// MinPeers is the peer count below which the bootstrap peers are
+	// redialed; 0 uses DefaultMinPeers
+	MinPeers int
+	// BootstrapInterval is how often the peer count is checked; 0 uses
+	// DefaultBootstrapInterval
+	BootstrapInterval time.Duration
+	// DHTMode is client, server, or auto; empty uses DefaultDHTMode
+	DHTMode string
 }
 
 // New creates a new p2p host
@@ -44,20 +62,62 @@ func New(ctx context.Context, cfg *Config) (*Host, error) {
 		return nil, fmt.Errorf("invalid listen address: %w", err)
 	}
 
+	bootstrapPeers, err := parseBootstrapPeers(cfg.BootstrapPeers)
+	if err != nil {
+		return nil, err
+	}
+	minPeers := cfg.MinPeers
+	if minPeers <= 0 {
+		minPeers = DefaultMinPeers
+	}
+	interval := cfg.BootstrapInterval
+	if interval <= 0 {
+		interval = DefaultBootstrapInterval
+	}
+	mode, err := ParseDHTMode(cfg.DHTMode)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create libp2p host
+	p := &Host{bootstrapped: make(chan struct{})}
 	h, err := libp2p.New(
 		libp2p.ListenAddrs(listenAddr),
 		libp2p.EnableRelay(),
-		libp2p.EnableAutoRelayWithPeerSource(nil),
+		libp2p.EnableAutoRelayWithPeerSource(p.relayCandidates),
 		libp2p.NATPortMap(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
 
-	return &Host{
-		host: h,
-	}, nil
+	bootstrapCtx, cancel := context.WithCancel(ctx)
+	p.host = h
+	p.cancel = cancel
+	if err := p.startDHT(bootstrapCtx, mode, bootstrapPeers); err != nil {
+		cancel()
+		h.Close()
+		return nil, err
+	}
+	go p.runBootstrap(bootstrapCtx, bootstrapPeers, minPeers, interval)
+	return p, nil
+}
+
+// relayCandidates offers up to num connected peers to autorelay as
+// candidate relays
+func (h *Host) relayCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, num)
+	defer close(out)
+	if h.host == nil {
+		return out
+	}
+	for _, id := range h.host.Network().Peers() {
+		if len(out) == num {
+			break
+		}
+		out <- h.host.Peerstore().PeerInfo(id)
+	}
+	return out
 }
 
 // Connect attempts to connect to a peer
@@ -99,5 +159,11 @@ func (h *Host) GetAddrs() []multiaddr.Multiaddr {
 
 // Close shuts down the p2p host
 func (h *Host) Close() error {
+	h.cancel()
+	<-h.bootstrapped
+	if err := h.dht.Close(); err != nil {
+		h.host.Close()
+		return fmt.Errorf("failed to close DHT: %w", err)
+	}
 	return h.host.Close()
 }
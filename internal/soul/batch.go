@@ -0,0 +1,82 @@
+package soul
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidMemory is returned by AddMemories for an entry it cannot add
+var ErrInvalidMemory = errors.New("invalid memory")
+
+// AddMemories adds entries in one step, as when importing a transcript or
+// replaying an event log. Every entry is validated before any is added, and
+// entries without a timestamp are stamped now. The entries are indexed
+// together and persisted in the same store batch. When they would pass the
+// limits of a rejecting forgetting policy, none is added and
+// ErrQuotaExceeded is returned.
+func (s *Soul) AddMemories(entries []MemoryEntry) error {
+	for i, entry := range entries {
+		if err := validateMemory(entry); err != nil {
+			return fmt.Errorf("%w %d: %v", ErrInvalidMemory, i, err)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.load()
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	if err := s.admitLocked(entries...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r := s.replication.Load()
+	added := make([]MemoryEntry, 0, len(entries))
+	writes := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if entry.Timestamp == 0 {
+			entry.Timestamp = now.UnixNano()
+		}
+		if s.scoring != nil {
+			entry.Importance = s.scoring.score(entry, s.memory, now)
+		}
+		entry.ID = s.nextMemory
+		s.nextMemory++
+		if r != nil {
+			r.localMemory(s, &entry)
+		}
+		s.memory = append(s.memory, entry)
+		s.tags.add(entry)
+		if s.persisted() {
+			key := memoryKey(s.ID, entry.ID)
+			if value, ok := s.encodeJSON(key, entry); ok {
+				writes[string(key)] = value
+			}
+		}
+		added = append(added, entry)
+	}
+	if len(writes) > 0 {
+		s.store.stageAll(writes)
+	}
+	for _, entry := range added {
+		s.publishMemory(entry)
+	}
+	s.enforceLocked(now, false)
+	return nil
+}
+
+// validateMemory checks an entry before it is added
+func validateMemory(entry MemoryEntry) error {
+	switch {
+	case entry.Content == "":
+		return errors.New("content is empty")
+	case !finite(entry.Importance) || entry.Importance < 0:
+		return fmt.Errorf("importance %v is not a finite non-negative number", entry.Importance)
+	case entry.Timestamp < 0:
+		return fmt.Errorf("timestamp %d is negative", entry.Timestamp)
+	}
+	return nil
+}
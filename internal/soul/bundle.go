@@ -0,0 +1,203 @@
+package soul
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BundleVersion is the soul bundle format written by Export
+const BundleVersion = 1
+
+var (
+	// ErrInvalidBundle is returned for bundles that are malformed or fail
+	// schema validation
+	ErrInvalidBundle = errors.New("invalid soul bundle")
+	// ErrBadSignature is returned when a bundle's signature does not verify
+	ErrBadSignature = errors.New("soul bundle signature does not verify")
+	// ErrUntrustedSigner is returned when a bundle is signed by a key that
+	// is not trusted
+	ErrUntrustedSigner = errors.New("soul bundle is not from a trusted signer")
+)
+
+// Bundle is the portable form of a soul
+type Bundle struct {
+	Version  int                `json:"version"`
+	SoulID   string             `json:"soul_id"`
+	Exported time.Time          `json:"exported"`
+	Memories []MemoryEntry      `json:"memories"`
+	Values   map[string]float64 `json:"values"`
+	Persona  Persona            `json:"persona"`
+}
+
+// signedBundle is a bundle with a detached ed25519 signature over its
+// encoded form
+type signedBundle struct {
+	Bundle    json.RawMessage `json:"bundle"`
+	Signer    string          `json:"signer"`    // Hex-encoded public key
+	Signature string          `json:"signature"` // Hex-encoded
+}
+
+// Snapshot returns an unsigned bundle of the soul's current memories,
+// values, and persona
+func (s *Soul) Snapshot() *Bundle {
+	s.load()
+	bundle := &Bundle{
+		Version:  BundleVersion,
+		SoulID:   s.ID,
+		Exported: time.Now().UTC(),
+		Memories: s.GetMemories(nil),
+		Persona:  clonePersona(s.GetPersona()),
+	}
+	s.valuesMu.RLock()
+	bundle.Values = make(map[string]float64, len(s.values))
+	for k, v := range s.values {
+		bundle.Values[k] = v
+	}
+	s.valuesMu.RUnlock()
+	return bundle
+}
+
+// Export produces a signed bundle of the soul's memories, values, and
+// persona
+func (s *Soul) Export(key ed25519.PrivateKey) ([]byte, error) {
+	payload, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode soul bundle: %w", err)
+	}
+	return json.Marshal(signedBundle{
+		Bundle:    payload,
+		Signer:    hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, payload)),
+	})
+}
+
+// VerifyBundle checks a bundle's signature and contents. When trusted is
+// not empty, the bundle must be signed by one of its keys.
+func VerifyBundle(data []byte, trusted []ed25519.PublicKey) (*Bundle, error) {
+	var signed signedBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	signer, err := hex.DecodeString(signed.Signer)
+	if err != nil || len(signer) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: malformed signer", ErrInvalidBundle)
+	}
+	signature, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidBundle)
+	}
+	if !ed25519.Verify(signer, signed.Bundle, signature) {
+		return nil, ErrBadSignature
+	}
+	if len(trusted) > 0 {
+		ok := false
+		for _, key := range trusted {
+			if bytes.Equal(key, signer) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, ErrUntrustedSigner
+		}
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(signed.Bundle, &bundle); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if err := bundle.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return &bundle, nil
+}
+
+// Import verifies a bundle and returns its soul, held in memory
+func Import(data []byte, trusted []ed25519.PublicKey) (*Soul, error) {
+	bundle, err := VerifyBundle(data, trusted)
+	if err != nil {
+		return nil, err
+	}
+	s := New(bundle.SoulID)
+	s.Restore(bundle)
+	return s, nil
+}
+
+// validate checks the bundle's schema
+func (b *Bundle) validate() error {
+	if b.Version < 1 || b.Version > BundleVersion {
+		return fmt.Errorf("unsupported version %d", b.Version)
+	}
+	if b.SoulID == "" {
+		return fmt.Errorf("soul_id is required")
+	}
+	seen := make(map[uint64]bool, len(b.Memories))
+	for _, entry := range b.Memories {
+		if entry.ID == 0 || seen[entry.ID] {
+			return fmt.Errorf("memory IDs must be unique and non-zero")
+		}
+		seen[entry.ID] = true
+		if !finite(entry.Importance) {
+			return fmt.Errorf("memory %d has a non-finite importance", entry.ID)
+		}
+	}
+	for key, v := range b.Values {
+		if !finite(v) {
+			return fmt.Errorf("value %s is not finite", key)
+		}
+	}
+	for trait, v := range b.Persona.Traits {
+		if !finite(v) {
+			return fmt.Errorf("trait %s is not finite", trait)
+		}
+	}
+	return nil
+}
+
+// Restore replaces the soul's memories and persona with a verified
+// bundle's and sets its values. Memory IDs are kept.
+func (s *Soul) Restore(b *Bundle) {
+	s.load()
+
+	s.memoryMu.Lock()
+	persisted := s.persisted()
+	for _, entry := range s.memory {
+		if persisted {
+			s.store.stage(memoryKey(s.ID, entry.ID), nil)
+		}
+	}
+	s.memory = append([]MemoryEntry(nil), b.Memories...)
+	sortMemories(s.memory)
+	s.tags = buildTagIndex(s.memory)
+	s.nextMemory = 1
+	for _, entry := range s.memory {
+		s.nextMemory = max(s.nextMemory, entry.ID+1)
+		if persisted {
+			s.stageJSON(memoryKey(s.ID, entry.ID), entry)
+		}
+	}
+	s.memoryMu.Unlock()
+
+	for key, value := range b.Values {
+		s.SetValue(key, value)
+	}
+	persona := b.Persona
+	if persona.Traits == nil {
+		persona.Traits = make(map[string]float64)
+	}
+	// The bundle is authoritative, so it is not held to the persona schema
+	s.personaMu.Lock()
+	s.setPersonaLocked("", persona)
+	s.personaMu.Unlock()
+}
+
+// finite reports whether v is neither NaN nor infinite
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
@@ -0,0 +1,224 @@
+package soul
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SummaryType is the Type of memories created by consolidation
+const SummaryType = "summary"
+
+// Consolidation defaults
+const (
+	DefaultConsolidationMinCluster = 3
+	DefaultConsolidationSimilarity = 0.8
+)
+
+// Summarizer condenses related memories into the content of one summary
+// memory, for example by asking an agent or an external language model
+type Summarizer interface {
+	Summarize(ctx context.Context, memories []MemoryEntry) (string, error)
+}
+
+// SummarizerFunc adapts a function to a Summarizer
+type SummarizerFunc func(ctx context.Context, memories []MemoryEntry) (string, error)
+
+// Summarize calls f
+func (f SummarizerFunc) Summarize(ctx context.Context, memories []MemoryEntry) (string, error) {
+	return f(ctx, memories)
+}
+
+// ConsolidationConfig controls which memories are merged
+type ConsolidationConfig struct {
+	Summarizer Summarizer
+	MinAge     time.Duration // Only memories at least this old are merged
+	MinCluster int           // Smallest group worth merging; 0 uses DefaultConsolidationMinCluster
+
+	// Similarity is the cosine similarity memories need with a cluster's
+	// first memory to join it when the soul has an embedder; 0 uses
+	// DefaultConsolidationSimilarity. Without an embedder, memories of the
+	// same type sharing their first tag are clustered.
+	Similarity float64
+}
+
+// ConsolidationStats counts what a consolidation merged
+type ConsolidationStats struct {
+	Clusters int // Summaries created
+	Merged   int // Memories replaced by summaries
+}
+
+// Consolidate merges clusters of related old memories into summary
+// memories. A summary has the type SummaryType, the union of its sources'
+// tags, their highest importance, and the latest timestamp, and lists the
+// IDs it replaced in Sources. Summaries are not merged again.
+func (s *Soul) Consolidate(ctx context.Context, cfg ConsolidationConfig, now time.Time) (ConsolidationStats, error) {
+	var stats ConsolidationStats
+	if cfg.Summarizer == nil {
+		return stats, fmt.Errorf("consolidation needs a summarizer")
+	}
+	if cfg.MinCluster <= 1 {
+		cfg.MinCluster = DefaultConsolidationMinCluster
+	}
+	if cfg.Similarity <= 0 {
+		cfg.Similarity = DefaultConsolidationSimilarity
+	}
+
+	s.embedMu.RLock()
+	embedder := s.embedder
+	s.embedMu.RUnlock()
+	if embedder != nil {
+		if err := s.embedMissing(ctx, embedder, 0); err != nil {
+			return stats, err
+		}
+	}
+
+	s.load()
+	s.memoryMu.RLock()
+	var candidates []MemoryEntry
+	cutoff := now.Add(-cfg.MinAge).UnixNano()
+	for _, entry := range s.memory {
+		if entry.Type != SummaryType && entry.Timestamp <= cutoff {
+			candidates = append(candidates, entry)
+		}
+	}
+	s.memoryMu.RUnlock()
+
+	for _, cluster := range clusterMemories(candidates, embedder != nil, cfg) {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		content, err := cfg.Summarizer.Summarize(ctx, cluster)
+		if err != nil {
+			return stats, fmt.Errorf("failed to summarize memories: %w", err)
+		}
+		if s.replaceWithSummary(cluster, content) {
+			stats.Clusters++
+			stats.Merged += len(cluster)
+		}
+	}
+	return stats, nil
+}
+
+// RunConsolidation consolidates every interval until ctx ends
+func (s *Soul) RunConsolidation(ctx context.Context, cfg ConsolidationConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.Consolidate(ctx, cfg, now); err != nil && ctx.Err() == nil {
+				fmt.Printf("Warning: failed to consolidate soul %s: %v\n", s.ID, err)
+			}
+		}
+	}
+}
+
+// clusterMemories groups memories, in ID order, into clusters of at least
+// cfg.MinCluster
+func clusterMemories(memories []MemoryEntry, byEmbedding bool, cfg ConsolidationConfig) [][]MemoryEntry {
+	var clusters [][]MemoryEntry
+	if byEmbedding {
+		used := make([]bool, len(memories))
+		for i, seed := range memories {
+			if used[i] || len(seed.Embedding) == 0 {
+				continue
+			}
+			members := []int{i}
+			for j := i + 1; j < len(memories); j++ {
+				other := memories[j]
+				if !used[j] && len(other.Embedding) == len(seed.Embedding) && cosine(seed.Embedding, other.Embedding) >= cfg.Similarity {
+					members = append(members, j)
+				}
+			}
+			if len(members) < cfg.MinCluster {
+				continue
+			}
+			cluster := make([]MemoryEntry, len(members))
+			for k, j := range members {
+				used[j] = true
+				cluster[k] = memories[j]
+			}
+			clusters = append(clusters, cluster)
+		}
+		return clusters
+	}
+
+	groups := make(map[[2]string][]MemoryEntry)
+	var keys [][2]string
+	for _, entry := range memories {
+		if len(entry.Tags) == 0 {
+			continue
+		}
+		key := [2]string{entry.Type, entry.Tags[0]}
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	for _, key := range keys {
+		if len(groups[key]) >= cfg.MinCluster {
+			clusters = append(clusters, groups[key])
+		}
+	}
+	return clusters
+}
+
+// replaceWithSummary swaps a cluster's memories for a summary, unless any
+// of them has been forgotten meanwhile
+func (s *Soul) replaceWithSummary(cluster []MemoryEntry, content string) bool {
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+
+	sources := make(map[uint64]bool, len(cluster))
+	for _, entry := range cluster {
+		sources[entry.ID] = true
+	}
+	found := 0
+	for _, entry := range s.memory {
+		if sources[entry.ID] {
+			found++
+		}
+	}
+	if found != len(cluster) {
+		return false
+	}
+
+	summary := MemoryEntry{ID: s.nextMemory, Type: SummaryType, Content: content}
+	s.nextMemory++
+	tags := make(map[string]bool)
+	for _, entry := range cluster {
+		summary.Sources = append(summary.Sources, entry.ID)
+		summary.Timestamp = max(summary.Timestamp, entry.Timestamp)
+		summary.Importance = max(summary.Importance, entry.Importance)
+		for _, tag := range entry.Tags {
+			if !tags[tag] {
+				tags[tag] = true
+				summary.Tags = append(summary.Tags, tag)
+			}
+		}
+	}
+	sort.Strings(summary.Tags)
+
+	kept := s.memory[:0]
+	for _, entry := range s.memory {
+		if sources[entry.ID] {
+			if s.persisted() {
+				s.store.stage(memoryKey(s.ID, entry.ID), nil)
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	clear(s.memory[len(kept):])
+	s.memory = append(kept, summary)
+	s.tags = buildTagIndex(s.memory)
+	if s.persisted() {
+		s.stageJSON(memoryKey(s.ID, summary.ID), summary)
+	}
+	s.publishMemory(summary)
+	return true
+}
@@ -0,0 +1,113 @@
+package soul
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNoMasterKey is returned when a soul's data is encrypted but its store
+// has no master key
+var ErrNoMasterKey = errors.New("soul data is encrypted but no master key is set")
+
+// sealedPrefix marks sealed values. JSON never starts with it, so values
+// written before encryption was enabled remain readable.
+const sealedPrefix byte = 0x01
+
+// dataKeySize is the size of a soul's AES-256 data key
+const dataKeySize = 32
+
+// newAEAD returns AES-GCM under key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext bound to ad, which is the value's KV key for
+// soul data and the soul ID for its wrapped data key
+func seal(aead cipher.AEAD, ad, plaintext []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	out := append([]byte{sealedPrefix}, nonce...)
+	return aead.Seal(out, nonce, plaintext, ad)
+}
+
+// unseal decrypts a sealed value, passing plaintext values through
+func unseal(aead cipher.AEAD, ad, value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != sealedPrefix {
+		return value, nil
+	}
+	if aead == nil {
+		return nil, ErrNoMasterKey
+	}
+	n := aead.NonceSize()
+	if len(value) < 1+n {
+		return nil, fmt.Errorf("sealed value is truncated")
+	}
+	return aead.Open(nil, value[1:1+n], value[1+n:], ad)
+}
+
+// soulCipher returns a soul's data key, unwrapping it with the master key
+// or, for a soul without one, creating it. It returns nil when the store
+// does not encrypt. data holds the soul's persisted keys.
+func (s *Store) soulCipher(id string, data map[string][]byte) (cipher.AEAD, error) {
+	if s.masterErr != nil {
+		return nil, s.masterErr
+	}
+	wrapped, exists := data[string(dataKeyKey(id))]
+	if s.master == nil {
+		if exists {
+			return nil, fmt.Errorf("soul %s: %w", id, ErrNoMasterKey)
+		}
+		return nil, nil
+	}
+
+	if exists {
+		key, err := unseal(s.master, []byte(id), wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key of soul %s: %w", id, err)
+		}
+		return newAEAD(key)
+	}
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key of soul %s: %w", id, err)
+	}
+	s.stage(dataKeyKey(id), seal(s.master, []byte(id), key))
+	return newAEAD(key)
+}
+
+// stageJSON buffers a JSON-encoded write, sealed with the soul's data key
+// when its store encrypts
+func (s *Soul) stageJSON(key []byte, v interface{}) {
+	if value, ok := s.encodeJSON(key, v); ok {
+		s.store.stage(key, value)
+	}
+}
+
+// encodeJSON encodes v for key as stageJSON writes it, warning and
+// returning false when it cannot be encoded
+func (s *Soul) encodeJSON(key []byte, v interface{}) ([]byte, bool) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode soul data: %v\n", err)
+		return nil, false
+	}
+	if s.aead != nil {
+		value = seal(s.aead, key, value)
+	}
+	return value, true
+}
+
+// dataKeyKey returns the key of a soul's wrapped data key
+func dataKeyKey(id string) []byte {
+	return []byte("soul/" + id + "/key")
+}
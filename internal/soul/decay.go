@@ -0,0 +1,293 @@
+package soul
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Forgetting policies, which choose the memories to let go of first
+const (
+	// ForgetLRU forgets the memories least recently added or recalled
+	ForgetLRU = "lru"
+	// ForgetImportance forgets the memories of lowest salience: importance
+	// decayed by the time since the memory was last added or recalled
+	ForgetImportance = "importance"
+)
+
+// Eviction reasons reported to a MemoryRecorder
+const (
+	EvictDecayed  = "decayed"    // Salience fell below MinSalience
+	EvictCount    = "count"      // Over MaxMemories
+	EvictSize     = "size"       // Over MaxBytes
+	EvictCompress = "compressed" // Compressed to fit MaxBytes
+)
+
+// DefaultHalfLife is how long a memory takes to lose half its salience
+const DefaultHalfLife = 24 * time.Hour
+
+// ErrQuotaExceeded is returned by AddMemory when a memory would pass the
+// limits of a policy that rejects rather than forgets
+var ErrQuotaExceeded = errors.New("soul memory quota exceeded")
+
+// ForgettingPolicy bounds a soul's memory. Limits are enforced as memories
+// are added; decay below MinSalience is applied by Forget.
+type ForgettingPolicy struct {
+	Policy      string        // ForgetLRU or ForgetImportance; defaults to ForgetImportance
+	MaxMemories int           // 0 means unlimited
+	MaxBytes    int           // Limit on total content size; 0 means unlimited
+	HalfLife    time.Duration // Salience half-life; 0 uses DefaultHalfLife
+	MinSalience float64       // Forget evicts memories below this salience
+
+	// CompressTo, when set, makes memories over MaxBytes shrink to this
+	// many bytes of content, dropping their embeddings, before any is
+	// evicted
+	CompressTo int
+
+	// Reject makes AddMemory fail with ErrQuotaExceeded, rather than
+	// forget, when a memory would pass MaxMemories or MaxBytes
+	Reject bool
+
+	Recorder MemoryRecorder // Optional
+}
+
+// MemoryRecorder records forgetting metrics. *metrics.Collector implements
+// it.
+type MemoryRecorder interface {
+	RecordSoulEviction(soulID, reason string, count int)
+	RecordSoulMemory(soulID string, size int64)
+}
+
+// ForgetStats counts what forgetting did
+type ForgetStats struct {
+	Evicted    int
+	Compressed int
+}
+
+// MemoryUsage is the size of a soul's memory
+type MemoryUsage struct {
+	Memories int
+	Bytes    int // Total content size
+}
+
+// SetForgetting sets the soul's forgetting policy and enforces its limits
+func (s *Soul) SetForgetting(policy ForgettingPolicy) ForgetStats {
+	policy = policy.withDefaults()
+	s.load()
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	s.forgetting = &policy
+	return s.enforceLocked(time.Now(), false)
+}
+
+// Usage returns the size of the soul's memory
+func (s *Soul) Usage() MemoryUsage {
+	s.load()
+	s.memoryMu.RLock()
+	defer s.memoryMu.RUnlock()
+	return s.usageLocked()
+}
+
+// Forget evicts memories whose salience has decayed below the policy's
+// MinSalience and enforces its limits. Call it periodically.
+func (s *Soul) Forget(now time.Time) ForgetStats {
+	s.load()
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	return s.enforceLocked(now, true)
+}
+
+// Salience returns a memory's importance decayed exponentially with the
+// policy's half-life since the memory was last added or recalled. Memories
+// without an importance count as 1.
+func (p ForgettingPolicy) Salience(entry MemoryEntry, now time.Time) float64 {
+	importance := importanceOf(entry)
+	halfLife := p.HalfLife
+	if halfLife <= 0 {
+		halfLife = DefaultHalfLife
+	}
+	age := now.Sub(time.Unix(0, lastUsed(entry)))
+	if age < 0 {
+		age = 0
+	}
+	return importance * math.Exp2(-float64(age)/float64(halfLife))
+}
+
+// withDefaults fills in the policy's unset fields
+func (p ForgettingPolicy) withDefaults() ForgettingPolicy {
+	if p.Policy == "" {
+		p.Policy = ForgetImportance
+	}
+	if p.HalfLife <= 0 {
+		p.HalfLife = DefaultHalfLife
+	}
+	return p
+}
+
+// usageLocked sums the soul's memory. memoryMu must be held.
+func (s *Soul) usageLocked() MemoryUsage {
+	usage := MemoryUsage{Memories: len(s.memory)}
+	for _, entry := range s.memory {
+		usage.Bytes += len(entry.Content)
+	}
+	return usage
+}
+
+// admitLocked checks that new memories fit the limits of a rejecting
+// policy. memoryMu must be held.
+func (s *Soul) admitLocked(entries ...MemoryEntry) error {
+	p := s.forgetting
+	if p == nil || !p.Reject {
+		return nil
+	}
+	usage := s.usageLocked()
+	size := 0
+	for _, entry := range entries {
+		size += len(entry.Content)
+	}
+	if (p.MaxMemories > 0 && usage.Memories+len(entries) > p.MaxMemories) ||
+		(p.MaxBytes > 0 && usage.Bytes+size > p.MaxBytes) {
+		if p.Recorder != nil {
+			p.Recorder.RecordSoulMemory(s.ID, int64(usage.Bytes))
+		}
+		return fmt.Errorf("%w: soul %s holds %d memories of %d bytes", ErrQuotaExceeded, s.ID, usage.Memories, usage.Bytes)
+	}
+	return nil
+}
+
+// touchLocked marks memories as recalled now. memoryMu must be held.
+func (s *Soul) touchLocked(ids map[uint64]bool) {
+	now := time.Now().UnixNano()
+	for i := range s.memory {
+		if ids[s.memory[i].ID] {
+			s.memory[i].accessed = now
+		}
+	}
+}
+
+// enforceLocked applies the forgetting policy. memoryMu must be held.
+func (s *Soul) enforceLocked(now time.Time, decay bool) ForgetStats {
+	var stats ForgetStats
+	p := s.forgetting
+	if p == nil {
+		return stats
+	}
+
+	// Rank memories from first to last to forget
+	order := make([]int, len(s.memory))
+	for i := range order {
+		order[i] = i
+	}
+	salience := make([]float64, len(s.memory))
+	for i, entry := range s.memory {
+		salience[i] = p.Salience(entry, now)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if p.Policy == ForgetLRU {
+			return lastUsed(s.memory[i]) < lastUsed(s.memory[j])
+		}
+		return salience[i] < salience[j]
+	})
+
+	evicted := make(map[int]string)
+	count, size := len(s.memory), 0
+	for _, entry := range s.memory {
+		size += len(entry.Content)
+	}
+	evict := func(i int, reason string) {
+		evicted[i] = reason
+		count--
+		size -= len(s.memory[i].Content)
+	}
+
+	if decay && p.MinSalience > 0 {
+		for _, i := range order {
+			if salience[i] < p.MinSalience {
+				evict(i, EvictDecayed)
+			}
+		}
+	}
+	if p.MaxBytes > 0 && p.CompressTo > 0 {
+		for _, i := range order {
+			if size <= p.MaxBytes {
+				break
+			}
+			entry := &s.memory[i]
+			if _, gone := evicted[i]; gone || entry.Compressed || len(entry.Content) <= p.CompressTo {
+				continue
+			}
+			size -= len(entry.Content) - p.CompressTo
+			entry.Content = truncate(entry.Content, p.CompressTo)
+			entry.Embedding = nil
+			entry.Compressed = true
+			stats.Compressed++
+			if s.persisted() {
+				s.stageJSON(memoryKey(s.ID, entry.ID), *entry)
+			}
+		}
+	}
+	for _, i := range order {
+		if _, gone := evicted[i]; gone {
+			continue
+		}
+		switch {
+		case p.MaxBytes > 0 && size > p.MaxBytes:
+			evict(i, EvictSize)
+		case p.MaxMemories > 0 && count > p.MaxMemories:
+			evict(i, EvictCount)
+		}
+	}
+
+	if len(evicted) > 0 {
+		kept := s.memory[:0]
+		reasons := make(map[string]int)
+		for i, entry := range s.memory {
+			reason, gone := evicted[i]
+			if !gone {
+				kept = append(kept, entry)
+				continue
+			}
+			reasons[reason]++
+			if s.persisted() {
+				s.store.stage(memoryKey(s.ID, entry.ID), nil)
+			}
+		}
+		clear(s.memory[len(kept):])
+		s.memory = kept
+		s.tags = buildTagIndex(s.memory)
+		stats.Evicted = len(evicted)
+		if p.Recorder != nil {
+			for reason, n := range reasons {
+				p.Recorder.RecordSoulEviction(s.ID, reason, n)
+			}
+		}
+	}
+	if p.Recorder != nil {
+		if stats.Compressed > 0 {
+			p.Recorder.RecordSoulEviction(s.ID, EvictCompress, stats.Compressed)
+		}
+		p.Recorder.RecordSoulMemory(s.ID, int64(size))
+	}
+	return stats
+}
+
+// lastUsed returns when a memory was last added or recalled, in Unix
+// nanoseconds
+func lastUsed(entry MemoryEntry) int64 {
+	return max(entry.Timestamp, entry.accessed)
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8
+// sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,112 @@
+package soul
+
+import (
+	"reflect"
+	"sort"
+)
+
+// SoulDiff is what changed between two snapshots of a soul. Memories are
+// matched by ID; a memory whose content, type, tags, or importance changed
+// is listed in ChangedMemories as it is in the later snapshot.
+type SoulDiff struct {
+	AddedMemories   []MemoryEntry `json:"added_memories,omitempty"`
+	RemovedMemories []MemoryEntry `json:"removed_memories,omitempty"`
+	ChangedMemories []MemoryEntry `json:"changed_memories,omitempty"`
+	Values          []Delta       `json:"values,omitempty"` // By key
+	Traits          []Delta       `json:"traits,omitempty"` // By trait
+	AddedGoals      []string      `json:"added_goals,omitempty"`
+	RemovedGoals    []string      `json:"removed_goals,omitempty"`
+}
+
+// Delta is a changed number. Old is nil when it was added and New is nil
+// when it was removed.
+type Delta struct {
+	Key string   `json:"key"`
+	Old *float64 `json:"old,omitempty"`
+	New *float64 `json:"new,omitempty"`
+}
+
+// Empty reports whether the snapshots were the same
+func (d SoulDiff) Empty() bool {
+	return len(d.AddedMemories) == 0 && len(d.RemovedMemories) == 0 && len(d.ChangedMemories) == 0 &&
+		len(d.Values) == 0 && len(d.Traits) == 0 && len(d.AddedGoals) == 0 && len(d.RemovedGoals) == 0
+}
+
+// Diff compares snapshot a with the later snapshot b, such as a soul's
+// Snapshot before and after a training run
+func Diff(a, b *Bundle) SoulDiff {
+	var diff SoulDiff
+
+	before := make(map[uint64]MemoryEntry, len(a.Memories))
+	for _, entry := range a.Memories {
+		before[entry.ID] = entry
+	}
+	for _, entry := range b.Memories {
+		old, ok := before[entry.ID]
+		switch {
+		case !ok:
+			diff.AddedMemories = append(diff.AddedMemories, entry)
+		case memoryChanged(old, entry):
+			diff.ChangedMemories = append(diff.ChangedMemories, entry)
+		}
+		delete(before, entry.ID)
+	}
+	for _, entry := range before {
+		diff.RemovedMemories = append(diff.RemovedMemories, entry)
+	}
+	sortMemories(diff.AddedMemories)
+	sortMemories(diff.RemovedMemories)
+	sortMemories(diff.ChangedMemories)
+
+	diff.Values = deltas(a.Values, b.Values)
+	diff.Traits = deltas(a.Persona.Traits, b.Persona.Traits)
+	diff.AddedGoals = missing(b.Persona.Goals, a.Persona.Goals)
+	diff.RemovedGoals = missing(a.Persona.Goals, b.Persona.Goals)
+	return diff
+}
+
+// memoryChanged reports whether a memory's recorded content differs; recall
+// times and embeddings are not compared
+func memoryChanged(a, b MemoryEntry) bool {
+	return a.Content != b.Content || a.Type != b.Type || a.Importance != b.Importance ||
+		a.Compressed != b.Compressed || !reflect.DeepEqual(a.Tags, b.Tags)
+}
+
+// deltas returns the keys whose numbers differ between a and b, sorted
+func deltas(a, b map[string]float64) []Delta {
+	var result []Delta
+	for key, old := range a {
+		d := Delta{Key: key, Old: &old}
+		if v, ok := b[key]; ok {
+			if v == old {
+				continue
+			}
+			d.New = &v
+		}
+		result = append(result, d)
+	}
+	for key, v := range b {
+		if _, ok := a[key]; !ok {
+			result = append(result, Delta{Key: key, New: &v})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// missing returns the strings in a that are not in b, in a's order
+func missing(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var result []string
+	for _, s := range a {
+		if !in[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
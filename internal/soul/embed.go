@@ -0,0 +1,259 @@
+package soul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrNoEmbedder is returned by Recall on a soul without an embedder
+var ErrNoEmbedder = errors.New("soul has no embedder")
+
+// Embedder maps texts to embedding vectors of a fixed dimension
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// SetEmbedder sets the embedder Recall uses. Memories are embedded when
+// first recalled, so they can be added without waiting on the embedder.
+func (s *Soul) SetEmbedder(e Embedder) {
+	s.embedMu.Lock()
+	defer s.embedMu.Unlock()
+	s.embedder = e
+}
+
+// Recall returns the k memories most similar in meaning to query, most
+// similar first. Memories without an embedding of the embedder's dimension
+// are embedded, and their embeddings kept, first. Importance scoring with a
+// RecallWeight ranks important memories higher.
+func (s *Soul) Recall(ctx context.Context, query string, k int) ([]MemoryEntry, error) {
+	s.embedMu.RLock()
+	embedder := s.embedder
+	s.embedMu.RUnlock()
+	if embedder == nil {
+		return nil, ErrNoEmbedder
+	}
+
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for 1 text", len(vectors))
+	}
+	q := vectors[0]
+	if err := s.embedMissing(ctx, embedder, len(q)); err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		entry MemoryEntry
+		score float64
+	}
+	s.load()
+	s.memoryMu.RLock()
+	weight := 0.0
+	if s.scoring != nil {
+		weight = s.scoring.RecallWeight
+	}
+	candidates := make([]scored, 0, len(s.memory))
+	for _, entry := range s.memory {
+		if len(entry.Embedding) == len(q) {
+			score := cosine(q, entry.Embedding)
+			if weight != 0 {
+				score += weight * importanceOf(entry)
+			}
+			candidates = append(candidates, scored{entry, score})
+		}
+	}
+	s.memoryMu.RUnlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if k > 0 && len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	result := make([]MemoryEntry, len(candidates))
+	recalled := make(map[uint64]bool, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.entry
+		recalled[c.entry.ID] = true
+	}
+
+	// Recalling a memory refreshes it against forgetting
+	s.memoryMu.Lock()
+	s.touchLocked(recalled)
+	s.memoryMu.Unlock()
+	return result, nil
+}
+
+// embedMissing embeds the memories lacking an embedding of dimension dim,
+// or lacking any embedding when dim is 0
+func (s *Soul) embedMissing(ctx context.Context, embedder Embedder, dim int) error {
+	s.load()
+	s.memoryMu.RLock()
+	var ids []uint64
+	var texts []string
+	for _, entry := range s.memory {
+		if len(entry.Embedding) == 0 || (dim > 0 && len(entry.Embedding) != dim) {
+			ids = append(ids, entry.ID)
+			texts = append(texts, entry.Content)
+		}
+	}
+	s.memoryMu.RUnlock()
+	if len(texts) == 0 {
+		return nil
+	}
+
+	vectors, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed memories: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+	embeddings := make(map[uint64][]float32, len(ids))
+	for i, id := range ids {
+		embeddings[id] = vectors[i]
+	}
+
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	for i := range s.memory {
+		if v, ok := embeddings[s.memory[i].ID]; ok {
+			s.memory[i].Embedding = v
+			if s.persisted() {
+				s.stageJSON(memoryKey(s.ID, s.memory[i].ID), s.memory[i])
+			}
+		}
+	}
+	return nil
+}
+
+// cosine returns the cosine similarity of two vectors of equal length
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// HashingEmbedder embeds texts locally by hashing their words into a fixed
+// number of dimensions. It needs no model, so it suits tests and nodes
+// without access to an embedding service, but it only captures shared
+// words, not meaning.
+type HashingEmbedder struct {
+	Dimensions int // Defaults to 256
+}
+
+// Embed embeds texts
+func (e HashingEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	dim := e.Dimensions
+	if dim <= 0 {
+		dim = 256
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, dim)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			h := fnv.New64a()
+			h.Write([]byte(word))
+			sum := h.Sum64()
+			sign := float32(1)
+			if sum>>63 == 1 {
+				sign = -1
+			}
+			v[sum%uint64(dim)] += sign
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// HTTPEmbedder embeds texts with a remote embedding API speaking the
+// common {"model", "input"} request and {"data": [{"embedding"}]} response
+// format, such as a hosted API or a local ONNX model server
+type HTTPEmbedder struct {
+	URL     string
+	Model   string
+	APIKey  string       // Sent as a bearer token when set
+	Client  *http.Client // nil uses a client with a 30 second timeout
+	Headers map[string]string
+}
+
+// Embed embeds texts in one request
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("embedding API returned %s", resp.Status)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding API returned %d embeddings for %d texts", len(result.Data), len(texts))
+	}
+	// Each text must get exactly one embedding, or a memory would be stored
+	// with none
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) || vectors[d.Index] != nil {
+			return nil, fmt.Errorf("embedding API returned invalid or duplicate index %d", d.Index)
+		}
+		if len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("embedding API returned an empty embedding at index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
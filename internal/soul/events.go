@@ -0,0 +1,75 @@
+package soul
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// Soul activity events published on the event bus under EventTypeSoul, in
+// the event's "event" data
+const (
+	EventMemoryAdded    = "memory_added"
+	EventValueChanged   = "value_changed"
+	EventPersonaUpdated = "persona_updated"
+)
+
+// SetEventBus sets the bus the soul publishes its activity on; nil stops
+// publishing. Events carry the soul's ID and a summary of the change, not
+// memory content.
+func (s *Soul) SetEventBus(bus *transport.EventBus) {
+	s.bus.Store(bus)
+}
+
+// publishMemory announces an added memory
+func (s *Soul) publishMemory(entry MemoryEntry) {
+	s.publish(EventMemoryAdded, map[string]interface{}{
+		"memory_id":  entry.ID,
+		"type":       entry.Type,
+		"tags":       append([]string(nil), entry.Tags...),
+		"importance": entry.Importance,
+	})
+}
+
+// publishValue announces a changed value; a nil value was removed
+func (s *Soul) publishValue(actor, key string, old, value *float64) {
+	data := map[string]interface{}{"key": key, "actor": actor}
+	if old != nil {
+		data["old"] = *old
+	}
+	if value != nil {
+		data["value"] = *value
+	}
+	s.publish(EventValueChanged, data)
+}
+
+// publishPersona announces an updated persona
+func (s *Soul) publishPersona(actor string, persona Persona) {
+	traits := make([]string, 0, len(persona.Traits))
+	for trait := range persona.Traits {
+		traits = append(traits, trait)
+	}
+	sort.Strings(traits)
+	s.publish(EventPersonaUpdated, map[string]interface{}{
+		"actor":  actor,
+		"traits": traits,
+		"goals":  len(persona.Goals),
+	})
+}
+
+// publish sends a soul event when the soul has an event bus
+func (s *Soul) publish(event string, data map[string]interface{}) {
+	bus := s.bus.Load()
+	if bus == nil {
+		return
+	}
+	data["event"] = event
+	data["soul_id"] = s.ID
+	bus.Publish(transport.Event{
+		Type:      transport.EventTypeSoul,
+		Source:    s.ID,
+		Timestamp: time.Now().UnixNano(),
+		Data:      data,
+	})
+}
@@ -0,0 +1,201 @@
+package soul
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Kinds of change recorded in a soul's history
+const (
+	ChangeValue   = "value"
+	ChangePersona = "persona"
+)
+
+// DefaultHistoryLimit is the number of changes a soul's history keeps
+const DefaultHistoryLimit = 1000
+
+// ErrVersionNotRetained is returned by RollbackTo when changes made since
+// the version have been dropped from the history
+var ErrVersionNotRetained = errors.New("soul version is no longer in the history")
+
+// Change is a recorded change to one of a soul's values or its persona
+type Change struct {
+	Version uint64 `json:"version"`
+	Time    int64  `json:"time"` // Unix nanoseconds
+	Actor   string `json:"actor,omitempty"`
+	Kind    string `json:"kind"`
+
+	// Key is the value changed, and Old and New are its value before and
+	// after; nil means unset
+	Key string   `json:"key,omitempty"`
+	Old *float64 `json:"old,omitempty"`
+	New *float64 `json:"new,omitempty"`
+
+	// OldPersona and NewPersona are set for persona changes
+	OldPersona *Persona `json:"old_persona,omitempty"`
+	NewPersona *Persona `json:"new_persona,omitempty"`
+}
+
+// SetHistoryLimit sets the number of changes the history keeps. Values
+// below 1 use DefaultHistoryLimit.
+func (s *Soul) SetHistoryLimit(limit int) {
+	s.load()
+	if limit < 1 {
+		limit = DefaultHistoryLimit
+	}
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	s.historyLimit = limit
+	s.trimHistoryLocked()
+}
+
+// GetHistory returns the recorded changes, oldest first
+func (s *Soul) GetHistory() []Change {
+	s.load()
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	result := make([]Change, len(s.history))
+	copy(result, s.history)
+	return result
+}
+
+// Version returns the version of the soul's values and persona: that of
+// the last change, or 0 before any
+func (s *Soul) Version() uint64 {
+	s.load()
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	return s.version
+}
+
+// RollbackTo restores the values and persona the soul had at version by
+// undoing later changes. The rollback is itself recorded as changes by
+// actor, so it can be rolled back in turn.
+func (s *Soul) RollbackTo(version uint64, actor string) error {
+	s.load()
+	s.valuesMu.Lock()
+	defer s.valuesMu.Unlock()
+	s.personaMu.Lock()
+	defer s.personaMu.Unlock()
+
+	s.historyMu.Lock()
+	if version > s.version {
+		s.historyMu.Unlock()
+		return fmt.Errorf("soul %s has no version %d, its latest is %d", s.ID, version, s.version)
+	}
+	if version < s.version && (len(s.history) == 0 || s.history[0].Version > version+1) {
+		s.historyMu.Unlock()
+		return fmt.Errorf("%w: version %d of soul %s", ErrVersionNotRetained, version, s.ID)
+	}
+	// Walking back from the latest change leaves each key at its value
+	// before the earliest change undone
+	values := make(map[string]*float64)
+	var persona *Persona
+	for i := len(s.history) - 1; i >= 0 && s.history[i].Version > version; i-- {
+		change := s.history[i]
+		switch change.Kind {
+		case ChangeValue:
+			values[change.Key] = change.Old
+		case ChangePersona:
+			persona = change.OldPersona
+		}
+	}
+	s.historyMu.Unlock()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	r := s.replication.Load()
+	for _, key := range keys {
+		s.setValueLocked(actor, key, values[key])
+		if r != nil && values[key] != nil {
+			r.localValue(s, key, *values[key])
+		}
+	}
+	if persona != nil {
+		s.setPersonaLocked(actor, clonePersona(*persona))
+	}
+	return nil
+}
+
+// setValueLocked sets or, when value is nil, removes a value and records
+// the change. valuesMu must be held.
+func (s *Soul) setValueLocked(actor, key string, value *float64) {
+	change := Change{Actor: actor, Kind: ChangeValue, Key: key}
+	if old, ok := s.values[key]; ok {
+		change.Old = &old
+	}
+	if value == nil {
+		delete(s.values, key)
+		if s.persisted() {
+			s.store.stage(valueKey(s.ID, key), nil)
+		}
+	} else {
+		v := *value
+		change.New = &v
+		s.values[key] = v
+		if s.persisted() {
+			s.stageJSON(valueKey(s.ID, key), v)
+		}
+		s.recordPointLocked(key, ValuePoint{Time: time.Now().UnixNano(), Value: v})
+	}
+	s.record(change)
+	s.publishValue(actor, key, change.Old, change.New)
+}
+
+// setPersonaLocked replaces the persona and records the change. personaMu
+// must be held.
+func (s *Soul) setPersonaLocked(actor string, persona Persona) {
+	old, updated := clonePersona(s.persona), clonePersona(persona)
+	s.persona = persona
+	if s.persisted() {
+		s.stageJSON(personaKeyOf(s.ID), persona)
+	}
+	s.record(Change{Actor: actor, Kind: ChangePersona, OldPersona: &old, NewPersona: &updated})
+	s.publishPersona(actor, updated)
+}
+
+// record appends a change to the history at the next version
+func (s *Soul) record(change Change) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	s.version++
+	change.Version = s.version
+	change.Time = time.Now().UnixNano()
+	s.history = append(s.history, change)
+	if s.persisted() {
+		s.stageJSON(historyKey(s.ID, change.Version), change)
+	}
+	s.trimHistoryLocked()
+}
+
+// trimHistoryLocked drops the oldest changes beyond the limit. historyMu
+// must be held.
+func (s *Soul) trimHistoryLocked() {
+	excess := len(s.history) - s.historyLimit
+	if excess <= 0 {
+		return
+	}
+	if s.persisted() {
+		for _, change := range s.history[:excess] {
+			s.store.stage(historyKey(s.ID, change.Version), nil)
+		}
+	}
+	s.history = s.history[excess:]
+}
+
+// clonePersona returns a copy of a persona that shares no maps or slices
+func clonePersona(p Persona) Persona {
+	clone := Persona{Goals: append([]string(nil), p.Goals...)}
+	if p.Traits != nil {
+		clone.Traits = make(map[string]float64, len(p.Traits))
+		for trait, v := range p.Traits {
+			clone.Traits[trait] = v
+		}
+	}
+	return clone
+}
@@ -0,0 +1,90 @@
+package soul
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// DefaultNoveltyWindow is how many recent memories a new memory is compared
+// with to judge its novelty
+const DefaultNoveltyWindow = 32
+
+// minImportance keeps scored importance above 0, which counts as 1
+const minImportance = 1e-6
+
+// ImportanceScoring scores memories as they are added. A memory's
+// importance becomes its explicit weight, the Importance it was added with
+// or 1, times its recency and novelty, so forgetting by importance keeps
+// critical memories over routine ones and Recall can favor them.
+type ImportanceScoring struct {
+	// RecencyHalfLife halves the importance of a memory for each half-life
+	// its timestamp lies in the past, as when replaying old transcripts; 0
+	// ignores recency
+	RecencyHalfLife time.Duration
+
+	// NoveltyWindow is how many recent memories the new one is compared
+	// with; a memory repeating one of them scores down to half. 0 uses
+	// DefaultNoveltyWindow and a negative window ignores novelty.
+	NoveltyWindow int
+
+	// RecallWeight is added to Recall's similarity score per unit of
+	// importance; 0 ranks by similarity alone
+	RecallWeight float64
+}
+
+// SetImportanceScoring sets how the soul scores the importance of new
+// memories. Memories already held keep their importance.
+func (s *Soul) SetImportanceScoring(scoring ImportanceScoring) {
+	scoring = scoring.withDefaults()
+	s.load()
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	s.scoring = &scoring
+}
+
+// withDefaults fills in the scoring's unset fields
+func (sc ImportanceScoring) withDefaults() ImportanceScoring {
+	if sc.NoveltyWindow == 0 {
+		sc.NoveltyWindow = DefaultNoveltyWindow
+	}
+	return sc
+}
+
+// score returns the importance of a memory about to be added after recent
+func (sc *ImportanceScoring) score(entry MemoryEntry, recent []MemoryEntry, now time.Time) float64 {
+	importance := importanceOf(entry)
+	if sc.RecencyHalfLife > 0 && entry.Timestamp != 0 {
+		if age := now.Sub(time.Unix(0, entry.Timestamp)); age > 0 {
+			importance *= math.Exp2(-float64(age) / float64(sc.RecencyHalfLife))
+		}
+	}
+	if sc.NoveltyWindow > 0 && len(recent) > 0 {
+		importance *= 0.5 + 0.5*novelty(entry.Content, recent[max(0, len(recent)-sc.NoveltyWindow):])
+	}
+	return max(importance, minImportance)
+}
+
+// importanceOf returns a memory's importance, counting 0 as 1
+func importanceOf(entry MemoryEntry) float64 {
+	if entry.Importance == 0 {
+		return 1
+	}
+	return entry.Importance
+}
+
+// novelty returns 1 minus the greatest word similarity of content to the
+// recent memories, from 0 for a repeat to 1 for nothing in common
+func novelty(content string, recent []MemoryEntry) float64 {
+	texts := make([]string, 0, len(recent)+1)
+	texts = append(texts, content)
+	for _, entry := range recent {
+		texts = append(texts, entry.Content)
+	}
+	vectors, _ := HashingEmbedder{}.Embed(context.Background(), texts)
+	similarity := 0.0
+	for _, v := range vectors[1:] {
+		similarity = max(similarity, cosine(vectors[0], v))
+	}
+	return 1 - min(similarity, 1)
+}
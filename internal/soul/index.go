@@ -0,0 +1,139 @@
+package soul
+
+import "sort"
+
+// tagIndex maps each tag to the IDs of the memories carrying it, in
+// ascending order. Memories are kept sorted by ID, so an ID locates its
+// memory by binary search.
+type tagIndex map[string][]uint64
+
+// buildTagIndex indexes memories sorted by ID
+func buildTagIndex(memories []MemoryEntry) tagIndex {
+	idx := make(tagIndex)
+	for _, entry := range memories {
+		idx.add(entry)
+	}
+	return idx
+}
+
+// add indexes a memory with a higher ID than any already indexed
+func (idx tagIndex) add(entry MemoryEntry) {
+	for _, tag := range entry.Tags {
+		ids := idx[tag]
+		if n := len(ids); n > 0 && ids[n-1] == entry.ID {
+			continue // Repeated tag
+		}
+		idx[tag] = append(ids, entry.ID)
+	}
+}
+
+// any returns the IDs of memories carrying at least one of tags
+func (idx tagIndex) any(tags []string) []uint64 {
+	var result []uint64
+	for _, tag := range tags {
+		result = union(result, idx[tag])
+	}
+	return result
+}
+
+// all returns the IDs of memories carrying every one of tags
+func (idx tagIndex) all(tags []string) []uint64 {
+	if len(tags) == 0 {
+		return nil
+	}
+	// Intersect from the rarest tag so the working set only shrinks
+	lists := make([][]uint64, len(tags))
+	for i, tag := range tags {
+		lists[i] = idx[tag]
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	})
+	result := lists[0]
+	for _, ids := range lists[1:] {
+		if len(result) == 0 {
+			break
+		}
+		result = intersect(result, ids)
+	}
+	return result
+}
+
+// union merges two ascending ID lists
+func union(a, b []uint64) []uint64 {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	result := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// intersect returns the IDs in both ascending lists
+func intersect(a, b []uint64) []uint64 {
+	var result []uint64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// subtract returns the IDs in a that are not in b
+func subtract(a, b []uint64) []uint64 {
+	var result []uint64
+	j := 0
+	for _, id := range a {
+		for j < len(b) && b[j] < id {
+			j++
+		}
+		if j < len(b) && b[j] == id {
+			continue
+		}
+		result = append(result, id)
+	}
+	return result
+}
+
+// lookupLocked returns the memories with the given ascending IDs.
+// memoryMu must be held.
+func (s *Soul) lookupLocked(ids []uint64) []MemoryEntry {
+	result := make([]MemoryEntry, 0, len(ids))
+	lo := 0
+	for _, id := range ids {
+		lo += sort.Search(len(s.memory)-lo, func(i int) bool {
+			return s.memory[lo+i].ID >= id
+		})
+		if lo < len(s.memory) && s.memory[lo].ID == id {
+			result = append(result, s.memory[lo])
+		}
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+package soul
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// MergeStrategy resolves a value or trait both souls hold
+type MergeStrategy string
+
+// Merge strategies
+const (
+	MergeAverage     MergeStrategy = "average"
+	MergeMax         MergeStrategy = "max"
+	MergePreferNewer MergeStrategy = "newer" // The one set most recently
+)
+
+// MergeStats counts what a merge changed
+type MergeStats struct {
+	Memories   int // Memories added
+	Duplicates int // Memories skipped because their content was already held
+	Values     int // Values set
+}
+
+// Merge folds other into the soul. Memories are added unless one with the
+// same content is already held; values and persona traits both souls hold
+// are combined by strategy, and the rest are copied; goals are joined.
+// Changes are recorded in the history as made by "merge:<other ID>".
+func (s *Soul) Merge(other *Soul, strategy MergeStrategy) (MergeStats, error) {
+	var stats MergeStats
+	switch strategy {
+	case MergeAverage, MergeMax, MergePreferNewer:
+	default:
+		return stats, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	if other == s {
+		return stats, fmt.Errorf("cannot merge soul %s into itself", s.ID)
+	}
+	actor := "merge:" + other.ID
+
+	held := make(map[[32]byte]bool)
+	for _, entry := range s.GetMemories(nil) {
+		held[sha256.Sum256([]byte(entry.Content))] = true
+	}
+	for _, entry := range other.GetMemories(nil) {
+		hash := sha256.Sum256([]byte(entry.Content))
+		if held[hash] {
+			stats.Duplicates++
+			continue
+		}
+		held[hash] = true
+		entry.Sources, entry.Origin, entry.OriginID = nil, "", 0
+		if err := s.AddMemory(entry); err != nil {
+			return stats, err
+		}
+		stats.Memories++
+	}
+
+	ours, oursSet := s.valueSnapshot()
+	theirs, theirsSet := other.valueSnapshot()
+	for key, v := range theirs {
+		merged := v
+		if current, ok := ours[key]; ok {
+			merged = combine(strategy, current, v, oursSet[key], theirsSet[key])
+			if merged == current {
+				continue
+			}
+		}
+		s.SetValueAs(actor, key, merged)
+		stats.Values++
+	}
+
+	persona, personaSet := s.personaSnapshot()
+	otherPersona, otherSet := other.personaSnapshot()
+	changed := false
+	for trait, v := range otherPersona.Traits {
+		current, ok := persona.Traits[trait]
+		if ok {
+			v = combine(strategy, current, v, personaSet, otherSet)
+		}
+		if ok && v == current {
+			continue
+		}
+		if persona.Traits == nil {
+			persona.Traits = make(map[string]float64)
+		}
+		persona.Traits[trait] = v
+		changed = true
+	}
+	goals := make(map[string]bool, len(persona.Goals))
+	for _, goal := range persona.Goals {
+		goals[goal] = true
+	}
+	for _, goal := range otherPersona.Goals {
+		if !goals[goal] {
+			goals[goal] = true
+			persona.Goals = append(persona.Goals, goal)
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.UpdatePersonaAs(actor, persona); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// combine resolves a value held by both souls. ourTime and theirTime are
+// when each was last set, in Unix nanoseconds.
+func combine(strategy MergeStrategy, ours, theirs float64, ourTime, theirTime int64) float64 {
+	switch strategy {
+	case MergeAverage:
+		return (ours + theirs) / 2
+	case MergeMax:
+		return max(ours, theirs)
+	default:
+		if theirTime > ourTime {
+			return theirs
+		}
+		return ours
+	}
+}
+
+// valueSnapshot returns the soul's values and when each was last set
+func (s *Soul) valueSnapshot() (map[string]float64, map[string]int64) {
+	s.load()
+	s.valuesMu.RLock()
+	defer s.valuesMu.RUnlock()
+	values := make(map[string]float64, len(s.values))
+	set := make(map[string]int64, len(s.values))
+	for key, v := range s.values {
+		values[key] = v
+		if series := s.series[key]; series != nil && len(series.points) > 0 {
+			set[key] = series.points[(series.next-1)%ValueSeriesLength].Time
+		}
+	}
+	return values, set
+}
+
+// personaSnapshot returns a copy of the persona and when it was last
+// updated, or 0 if the update is no longer in the history
+func (s *Soul) personaSnapshot() (Persona, int64) {
+	persona := clonePersona(s.GetPersona())
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Kind == ChangePersona {
+			return persona, s.history[i].Time
+		}
+	}
+	return persona, 0
+}
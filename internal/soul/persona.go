@@ -0,0 +1,209 @@
+package soul
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPersona is returned when a persona does not match the soul's
+// persona schema
+var ErrInvalidPersona = errors.New("invalid persona")
+
+// PersonaSchema constrains a soul's persona
+type PersonaSchema struct {
+	Traits        map[string]TraitSchema `yaml:"traits" json:"traits,omitempty"`
+	Strict        bool                   `yaml:"strict" json:"strict,omitempty"` // Reject traits not in Traits
+	RequiredGoals []string               `yaml:"required_goals" json:"required_goals,omitempty"`
+}
+
+// TraitSchema declares one persona trait
+type TraitSchema struct {
+	Required bool     `yaml:"required" json:"required,omitempty"`
+	Min      *float64 `yaml:"min" json:"min,omitempty"`
+	Max      *float64 `yaml:"max" json:"max,omitempty"`
+}
+
+// PersonaTemplate is a named starting persona and the schema that keeps
+// it comparable across souls:
+//
+//	templates:
+//	  explorer:
+//	    schema:
+//	      traits:
+//	        curiosity: {required: true, min: 0, max: 1}
+//	      strict: true
+//	      required_goals: [explore]
+//	    traits: {curiosity: 0.8}
+//	    goals: [explore]
+type PersonaTemplate struct {
+	Schema *PersonaSchema     `yaml:"schema"`
+	Traits map[string]float64 `yaml:"traits"`
+	Goals  []string           `yaml:"goals"`
+}
+
+// ParsePersonaTemplates decodes and validates YAML or JSON persona
+// templates, keyed by name under "templates"
+func ParsePersonaTemplates(data []byte) (map[string]PersonaTemplate, error) {
+	var doc struct {
+		Templates map[string]PersonaTemplate `yaml:"templates"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse persona templates: %w", err)
+	}
+	for name, t := range doc.Templates {
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("persona template %s: %w", name, err)
+		}
+	}
+	return doc.Templates, nil
+}
+
+// LoadPersonaTemplates reads and parses a persona templates file
+func LoadPersonaTemplates(path string) (map[string]PersonaTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persona templates: %w", err)
+	}
+	return ParsePersonaTemplates(data)
+}
+
+// Persona returns a copy of the template's persona
+func (t PersonaTemplate) Persona() Persona {
+	return clonePersona(Persona{Traits: t.Traits, Goals: t.Goals})
+}
+
+// Validate checks the template's schema and that its persona satisfies it
+func (t PersonaTemplate) Validate() error {
+	if t.Schema == nil {
+		return nil
+	}
+	if err := t.Schema.validate(); err != nil {
+		return err
+	}
+	return t.Schema.Check(t.Persona())
+}
+
+// Check reports how a persona breaks the schema, or nil if it does not
+func (ps *PersonaSchema) Check(p Persona) error {
+	names := make([]string, 0, len(ps.Traits))
+	for name := range ps.Traits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		trait := ps.Traits[name]
+		v, ok := p.Traits[name]
+		if !ok {
+			if trait.Required {
+				return fmt.Errorf("%w: trait %s is required", ErrInvalidPersona, name)
+			}
+			continue
+		}
+		if math.IsNaN(v) || (trait.Min != nil && v < *trait.Min) || (trait.Max != nil && v > *trait.Max) {
+			return fmt.Errorf("%w: trait %s is %v, outside %s", ErrInvalidPersona, name, v, trait.bounds())
+		}
+	}
+	if ps.Strict {
+		for name := range p.Traits {
+			if _, ok := ps.Traits[name]; !ok {
+				return fmt.Errorf("%w: trait %s is not in the schema", ErrInvalidPersona, name)
+			}
+		}
+	}
+	for _, goal := range ps.RequiredGoals {
+		found := false
+		for _, g := range p.Goals {
+			if g == goal {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: goal %q is required", ErrInvalidPersona, goal)
+		}
+	}
+	return nil
+}
+
+// SetPersonaSchema validates the persona against schema from now on;
+// UpdatePersona rejects personas that break it. The current persona must
+// satisfy it. A nil schema removes the soul's schema.
+func (s *Soul) SetPersonaSchema(schema *PersonaSchema) error {
+	if schema != nil {
+		if err := schema.validate(); err != nil {
+			return fmt.Errorf("invalid persona schema: %w", err)
+		}
+	}
+	s.load()
+	s.personaMu.Lock()
+	defer s.personaMu.Unlock()
+	if schema != nil {
+		if err := schema.Check(s.persona); err != nil {
+			return err
+		}
+	}
+	s.setSchemaLocked(schema)
+	return nil
+}
+
+// PersonaSchema returns the soul's persona schema, or nil
+func (s *Soul) PersonaSchema() *PersonaSchema {
+	s.load()
+	s.personaMu.RLock()
+	defer s.personaMu.RUnlock()
+	return s.schema
+}
+
+// ApplyTemplate gives the soul a template's persona and schema, recording
+// actor as the persona's author in the history
+func (s *Soul) ApplyTemplate(actor string, t PersonaTemplate) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid persona template: %w", err)
+	}
+	s.load()
+	s.personaMu.Lock()
+	defer s.personaMu.Unlock()
+	s.setSchemaLocked(t.Schema)
+	s.setPersonaLocked(actor, t.Persona())
+	return nil
+}
+
+// setSchemaLocked replaces the persona schema. personaMu must be held.
+func (s *Soul) setSchemaLocked(schema *PersonaSchema) {
+	s.schema = schema
+	if !s.persisted() {
+		return
+	}
+	if schema == nil {
+		s.store.stage(personaSchemaKey(s.ID), nil)
+	} else {
+		s.stageJSON(personaSchemaKey(s.ID), schema)
+	}
+}
+
+// validate checks that the schema's trait ranges are well formed
+func (ps *PersonaSchema) validate() error {
+	for name, trait := range ps.Traits {
+		if trait.Min != nil && trait.Max != nil && *trait.Min > *trait.Max {
+			return fmt.Errorf("trait %s: min is greater than max", name)
+		}
+	}
+	return nil
+}
+
+// bounds describes a trait's range
+func (t TraitSchema) bounds() string {
+	lo, hi := math.Inf(-1), math.Inf(1)
+	if t.Min != nil {
+		lo = *t.Min
+	}
+	if t.Max != nil {
+		hi = *t.Max
+	}
+	return fmt.Sprintf("[%v, %v]", lo, hi)
+}
@@ -0,0 +1,241 @@
+package soul
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Memory query orders
+const (
+	OrderAdded  = ""       // The order memories were added
+	OrderOldest = "oldest" // By timestamp, oldest first
+	OrderNewest = "newest" // By timestamp, newest first
+
+	// OrderImportance orders by importance, most important first
+	OrderImportance = "importance"
+)
+
+// TagExpr is a boolean expression over memory tags. Exactly one field is
+// set:
+//
+//	{"and": [{"tag": "dream"}, {"not": {"or": [{"tag": "sad"}, {"tag": "old"}]}}]}
+type TagExpr struct {
+	Tag string    `json:"tag,omitempty"`
+	And []TagExpr `json:"and,omitempty"`
+	Or  []TagExpr `json:"or,omitempty"`
+	Not *TagExpr  `json:"not,omitempty"`
+}
+
+// Tag matches memories carrying tag
+func Tag(tag string) TagExpr {
+	return TagExpr{Tag: tag}
+}
+
+// And matches memories matching every expression
+func And(exprs ...TagExpr) TagExpr {
+	return TagExpr{And: exprs}
+}
+
+// Or matches memories matching any expression
+func Or(exprs ...TagExpr) TagExpr {
+	return TagExpr{Or: exprs}
+}
+
+// Not matches memories not matching expr
+func Not(expr TagExpr) TagExpr {
+	return TagExpr{Not: &expr}
+}
+
+// AnyTag matches memories carrying any of tags
+func AnyTag(tags ...string) TagExpr {
+	exprs := make([]TagExpr, len(tags))
+	for i, tag := range tags {
+		exprs[i] = Tag(tag)
+	}
+	return Or(exprs...)
+}
+
+// MemoryQuery selects memories. The zero value selects every memory in
+// the order they were added.
+type MemoryQuery struct {
+	Tags   *TagExpr `json:"tags,omitempty"`
+	Types  []string `json:"types,omitempty"`  // Matches any of the types
+	From   int64    `json:"from,omitempty"`   // Earliest timestamp, inclusive; 0 is unbounded
+	To     int64    `json:"to,omitempty"`     // Latest timestamp, exclusive; 0 is unbounded
+	Order  string   `json:"order,omitempty"`  // OrderAdded, OrderOldest, OrderNewest, or OrderImportance
+	Offset int      `json:"offset,omitempty"` // Matches to skip
+	Limit  int      `json:"limit,omitempty"`  // Matches to return; 0 returns all
+}
+
+// Validate checks that the query is well formed
+func (q MemoryQuery) Validate() error {
+	if q.Tags != nil {
+		if err := q.Tags.validate(); err != nil {
+			return err
+		}
+	}
+	switch q.Order {
+	case OrderAdded, OrderOldest, OrderNewest, OrderImportance:
+	default:
+		return fmt.Errorf("unknown order %q", q.Order)
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return fmt.Errorf("offset and limit must not be negative")
+	}
+	if q.To != 0 && q.To < q.From {
+		return fmt.Errorf("time range ends before it starts")
+	}
+	return nil
+}
+
+// Query returns the memories selected by q. Tag expressions are evaluated
+// on the tag index.
+func (s *Soul) Query(q MemoryQuery) ([]MemoryEntry, error) {
+	if err := q.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid memory query: %w", err)
+	}
+	s.load()
+	s.memoryMu.RLock()
+	var candidates []MemoryEntry
+	if q.Tags != nil {
+		candidates = s.lookupLocked(s.tags.eval(*q.Tags, s.memoryIDsLocked))
+	} else {
+		candidates = make([]MemoryEntry, len(s.memory))
+		copy(candidates, s.memory)
+	}
+	s.memoryMu.RUnlock()
+
+	matches := candidates[:0]
+	for _, entry := range candidates {
+		if q.matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+
+	switch q.Order {
+	case OrderOldest:
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].Timestamp < matches[j].Timestamp
+		})
+	case OrderNewest:
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].Timestamp > matches[j].Timestamp
+		})
+	case OrderImportance:
+		sort.SliceStable(matches, func(i, j int) bool {
+			return importanceOf(matches[i]) > importanceOf(matches[j])
+		})
+	}
+
+	if q.Offset >= len(matches) {
+		return []MemoryEntry{}, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, nil
+}
+
+// matches applies the query's type and time filters
+func (q MemoryQuery) matches(entry MemoryEntry) bool {
+	if q.From != 0 && entry.Timestamp < q.From {
+		return false
+	}
+	if q.To != 0 && entry.Timestamp >= q.To {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if entry.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that exactly one field of each expression is set
+func (e TagExpr) validate() error {
+	set := 0
+	if e.Tag != "" {
+		set++
+	}
+	if e.And != nil {
+		set++
+	}
+	if e.Or != nil {
+		set++
+	}
+	if e.Not != nil {
+		set++
+		if err := e.Not.validate(); err != nil {
+			return err
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("tag expression needs exactly one of tag, and, or, or not")
+	}
+	for _, sub := range append(append([]TagExpr(nil), e.And...), e.Or...) {
+		if err := sub.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eval returns the ascending IDs of memories matching e. all lists every
+// memory ID and is only called for negations that cannot be resolved
+// against a sibling.
+func (idx tagIndex) eval(e TagExpr, all func() []uint64) []uint64 {
+	switch {
+	case e.Tag != "":
+		return idx[e.Tag]
+	case e.Not != nil:
+		return subtract(all(), idx.eval(*e.Not, all))
+	case e.Or != nil:
+		var result []uint64
+		for _, sub := range e.Or {
+			result = union(result, idx.eval(sub, all))
+		}
+		return result
+	default:
+		// Intersect the positive terms, then remove the negated ones
+		var result []uint64
+		var negated []TagExpr
+		first := true
+		for _, sub := range e.And {
+			if sub.Not != nil {
+				negated = append(negated, *sub.Not)
+				continue
+			}
+			ids := idx.eval(sub, all)
+			if first {
+				result, first = ids, false
+			} else {
+				result = intersect(result, ids)
+			}
+		}
+		if first {
+			result = all()
+		}
+		for _, sub := range negated {
+			if len(result) == 0 {
+				break
+			}
+			result = subtract(result, idx.eval(sub, all))
+		}
+		return result
+	}
+}
+
+// memoryIDsLocked returns every memory ID in ascending order. memoryMu
+// must be held.
+func (s *Soul) memoryIDsLocked() []uint64 {
+	ids := make([]uint64, len(s.memory))
+	for i, entry := range s.memory {
+		ids[i] = entry.ID
+	}
+	return ids
+}
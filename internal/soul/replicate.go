@@ -0,0 +1,270 @@
+package soul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// DefaultSyncInterval is how often a replica republishes its full state
+const DefaultSyncInterval = 30 * time.Second
+
+// ReplicationTransport carries replication messages between nodes.
+// *transport.Transport implements it over libp2p pubsub.
+type ReplicationTransport interface {
+	Publish(ctx context.Context, topic string, data []byte) error
+	Subscribe(ctx context.Context, topic string) (<-chan transport.Message, error)
+}
+
+var _ ReplicationTransport = (*transport.Transport)(nil)
+
+// ReplicationConfig replicates a soul across nodes
+type ReplicationConfig struct {
+	Replica      string // This node's replica ID, unique among the soul's replicas
+	Transport    ReplicationTransport
+	Topic        string        // Empty uses "soul/<id>/replication"
+	SyncInterval time.Duration // Interval to republish the full state; 0 uses DefaultSyncInterval
+}
+
+// replicaMessage carries memories and values from one replica. Changes are
+// sent as they happen and the full state periodically, so replicas that
+// missed a message or joined late converge.
+type replicaMessage struct {
+	SoulID   string         `json:"soul_id"`
+	Replica  string         `json:"replica"`
+	Memories []MemoryEntry  `json:"memories,omitempty"`
+	Values   []valueVersion `json:"values,omitempty"`
+}
+
+// valueVersion is a value stamped for last-writer-wins merging
+type valueVersion struct {
+	Key     string  `json:"key"`
+	Value   float64 `json:"value"`
+	Time    int64   `json:"time"` // Unix nanoseconds
+	Replica string  `json:"replica"`
+}
+
+// newer reports whether v wins over other: the later write, or on a tie
+// the higher replica ID
+func (v valueVersion) newer(other valueVersion) bool {
+	if v.Time != other.Time {
+		return v.Time > other.Time
+	}
+	return v.Replica > other.Replica
+}
+
+// replication is a soul's membership in a replica set. Memories form a
+// grow-only log identified by their origin replica and ID there; values
+// form a last-writer-wins map.
+type replication struct {
+	cfg    ReplicationConfig
+	seen   map[string]bool         // Origin/origin ID of memories in the log
+	clocks map[string]valueVersion // By value key
+	outbox chan replicaMessage
+	mu     sync.Mutex
+}
+
+// Replicate joins the soul to its replicas on other nodes until ctx ends.
+// Memories added with AddMemory and values set with SetValue on any
+// replica reach every other, and concurrent writes to a value converge on
+// the latest. Memories removed by forgetting or consolidation are not
+// replicated again, and neither are the removals.
+func (s *Soul) Replicate(ctx context.Context, cfg ReplicationConfig) error {
+	if cfg.Replica == "" {
+		return fmt.Errorf("replication needs a replica ID")
+	}
+	if cfg.Transport == nil {
+		return fmt.Errorf("replication needs a transport")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "soul/" + s.ID + "/replication"
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+
+	messages, err := cfg.Transport.Subscribe(ctx, cfg.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to join soul replication: %w", err)
+	}
+	r := &replication{
+		cfg:    cfg,
+		seen:   make(map[string]bool),
+		clocks: make(map[string]valueVersion),
+		outbox: make(chan replicaMessage, 256),
+	}
+
+	// Memories written before replication originate here
+	s.load()
+	s.memoryMu.Lock()
+	for i := range s.memory {
+		entry := &s.memory[i]
+		if entry.Origin == "" {
+			entry.Origin, entry.OriginID = cfg.Replica, entry.ID
+			if s.persisted() {
+				s.stageJSON(memoryKey(s.ID, entry.ID), *entry)
+			}
+		}
+		r.seen[originKey(*entry)] = true
+	}
+	if !s.replication.CompareAndSwap(nil, r) {
+		s.memoryMu.Unlock()
+		return fmt.Errorf("soul %s is already replicating", s.ID)
+	}
+	s.memoryMu.Unlock()
+
+	go func() {
+		defer s.replication.CompareAndSwap(r, nil)
+		for msg := range messages {
+			r.receive(s, msg.Payload)
+		}
+	}()
+	go r.send(ctx, s)
+	return nil
+}
+
+// send publishes changes as they are queued and the full state each sync
+// interval until ctx ends
+func (r *replication) send(ctx context.Context, s *Soul) {
+	ticker := time.NewTicker(r.cfg.SyncInterval)
+	defer ticker.Stop()
+	r.publish(ctx, r.state(s))
+	for {
+		select {
+		case msg := <-r.outbox:
+			r.publish(ctx, msg)
+		case <-ticker.C:
+			r.publish(ctx, r.state(s))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish sends a message to the other replicas
+func (r *replication) publish(ctx context.Context, msg replicaMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode soul replication message: %v\n", err)
+		return
+	}
+	if err := r.cfg.Transport.Publish(ctx, r.cfg.Topic, data); err != nil && ctx.Err() == nil {
+		fmt.Printf("Warning: failed to publish soul %s replication message: %v\n", msg.SoulID, err)
+	}
+}
+
+// queue schedules a change for sending. A change dropped because the
+// outbox is full is sent with the next full state.
+func (r *replication) queue(msg replicaMessage) {
+	select {
+	case r.outbox <- msg:
+	default:
+	}
+}
+
+// state returns the soul's full replicated state
+func (r *replication) state(s *Soul) replicaMessage {
+	msg := replicaMessage{SoulID: s.ID, Replica: r.cfg.Replica, Memories: s.GetMemories(nil)}
+
+	s.valuesMu.RLock()
+	r.mu.Lock()
+	for key, value := range s.values {
+		version := r.clocks[key]
+		if version.Replica == "" {
+			// Values set before replication carry no time and lose to any write
+			version.Replica = r.cfg.Replica
+		}
+		version.Key, version.Value = key, value
+		msg.Values = append(msg.Values, version)
+	}
+	r.mu.Unlock()
+	s.valuesMu.RUnlock()
+	return msg
+}
+
+// localMemory stamps a memory added on this replica. memoryMu must be held.
+func (r *replication) localMemory(s *Soul, entry *MemoryEntry) {
+	entry.Origin, entry.OriginID = r.cfg.Replica, entry.ID
+	r.mu.Lock()
+	r.seen[originKey(*entry)] = true
+	r.mu.Unlock()
+	r.queue(replicaMessage{SoulID: s.ID, Replica: r.cfg.Replica, Memories: []MemoryEntry{*entry}})
+}
+
+// localValue stamps a value set on this replica. valuesMu must be held.
+func (r *replication) localValue(s *Soul, key string, value float64) {
+	r.mu.Lock()
+	version := valueVersion{Key: key, Value: value, Time: time.Now().UnixNano(), Replica: r.cfg.Replica}
+	if current, ok := r.clocks[key]; ok && version.Time <= current.Time {
+		// Keep the clock moving forward so this write wins over the last one
+		version.Time = current.Time + 1
+	}
+	r.clocks[key] = version
+	r.mu.Unlock()
+	r.queue(replicaMessage{SoulID: s.ID, Replica: r.cfg.Replica, Values: []valueVersion{version}})
+}
+
+// receive merges another replica's message into the soul
+func (r *replication) receive(s *Soul, payload []byte) {
+	var msg replicaMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return
+	}
+	if msg.SoulID != s.ID || msg.Replica == r.cfg.Replica {
+		return
+	}
+
+	if len(msg.Memories) > 0 {
+		s.memoryMu.Lock()
+		added := false
+		for _, entry := range msg.Memories {
+			if entry.Origin == "" {
+				continue
+			}
+			r.mu.Lock()
+			seen := r.seen[originKey(entry)]
+			r.seen[originKey(entry)] = true
+			r.mu.Unlock()
+			if seen {
+				continue
+			}
+			entry.ID = s.nextMemory
+			s.nextMemory++
+			entry.accessed = 0
+			s.memory = append(s.memory, entry)
+			s.tags.add(entry)
+			if s.persisted() {
+				s.stageJSON(memoryKey(s.ID, entry.ID), entry)
+			}
+			s.publishMemory(entry)
+			added = true
+		}
+		if added {
+			s.enforceLocked(time.Now(), false)
+		}
+		s.memoryMu.Unlock()
+	}
+
+	if len(msg.Values) > 0 {
+		s.valuesMu.Lock()
+		r.mu.Lock()
+		for _, version := range msg.Values {
+			if current, ok := r.clocks[version.Key]; ok && !version.newer(current) {
+				continue
+			}
+			r.clocks[version.Key] = version
+			s.setValueLocked("replica:"+msg.Replica, version.Key, &version.Value)
+		}
+		r.mu.Unlock()
+		s.valuesMu.Unlock()
+	}
+}
+
+// originKey identifies a memory across replicas
+func originKey(entry MemoryEntry) string {
+	return fmt.Sprintf("%s/%d", entry.Origin, entry.OriginID)
+}
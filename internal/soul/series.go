@@ -0,0 +1,113 @@
+package soul
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// ValueSeriesLength is the number of points kept for each value
+const ValueSeriesLength = 1000
+
+// ValuePoint is a value at the time it was set
+type ValuePoint struct {
+	Time  int64   `json:"time"` // Unix nanoseconds
+	Value float64 `json:"value"`
+}
+
+// valueSeries is a ring buffer of a value's latest points. next counts
+// every point ever recorded and keys the points in the store.
+type valueSeries struct {
+	points []ValuePoint
+	next   uint64
+}
+
+// GetValueHistory returns the points a value was set to, oldest first,
+// between from (inclusive) and to (exclusive) in Unix nanoseconds; 0
+// leaves a bound open. The latest ValueSeriesLength points are kept.
+func (s *Soul) GetValueHistory(key string, from, to int64) []ValuePoint {
+	s.load()
+	s.valuesMu.RLock()
+	defer s.valuesMu.RUnlock()
+
+	series := s.series[key]
+	if series == nil {
+		return nil
+	}
+	var result []ValuePoint
+	for _, p := range series.ordered() {
+		if (from == 0 || p.Time >= from) && (to == 0 || p.Time < to) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// recordPointLocked appends a point to a value's series. valuesMu must be
+// held.
+func (s *Soul) recordPointLocked(key string, p ValuePoint) {
+	series := s.series[key]
+	if series == nil {
+		series = &valueSeries{}
+		s.series[key] = series
+	}
+	seq := series.next
+	series.next++
+	if len(series.points) < ValueSeriesLength {
+		series.points = append(series.points, p)
+	} else {
+		series.points[seq%ValueSeriesLength] = p
+	}
+	if s.persisted() {
+		s.stageJSON(seriesKey(s.ID, key, seq), p)
+		if seq >= ValueSeriesLength {
+			s.store.stage(seriesKey(s.ID, key, seq-ValueSeriesLength), nil)
+		}
+	}
+}
+
+// ordered returns the series' points, oldest first
+func (vs *valueSeries) ordered() []ValuePoint {
+	if len(vs.points) < ValueSeriesLength {
+		return vs.points
+	}
+	start := int(vs.next % ValueSeriesLength)
+	return append(append([]ValuePoint(nil), vs.points[start:]...), vs.points[:start]...)
+}
+
+// restoreSeries rebuilds series from stored points by value key and
+// sequence number
+func restoreSeries(stored map[string]map[uint64]ValuePoint) map[string]*valueSeries {
+	result := make(map[string]*valueSeries, len(stored))
+	for key, points := range stored {
+		seqs := make([]uint64, 0, len(points))
+		for seq := range points {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		if len(seqs) > ValueSeriesLength {
+			seqs = seqs[len(seqs)-ValueSeriesLength:]
+		}
+
+		series := &valueSeries{next: seqs[len(seqs)-1] + 1}
+		series.points = make([]ValuePoint, len(seqs))
+		for _, seq := range seqs {
+			if len(seqs) < ValueSeriesLength {
+				series.points[seq-seqs[0]] = points[seq]
+			} else {
+				series.points[seq%ValueSeriesLength] = points[seq]
+			}
+		}
+		result[key] = series
+	}
+	return result
+}
+
+// seriesPrefix returns the key prefix of a soul's value series
+func seriesPrefix(id string) string {
+	return "soul/" + id + "/series/"
+}
+
+// seriesKey returns the key of a point in a value's series
+func seriesKey(id, key string, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(seriesPrefix(id)+key+"/"), seq)
+}
@@ -1,40 +1,101 @@
 package soul
 
 import (
+	"crypto/cipher"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/transport"
 )
 
 // Soul represents an individual soul instance
 type Soul struct {
 	ID        string
 	memory    []MemoryEntry
+	tags      tagIndex // Indexes memory; guarded by memoryMu
 	memoryMu  sync.RWMutex
 	values    map[string]float64
+	series    map[string]*valueSeries // Guarded by valuesMu
 	valuesMu  sync.RWMutex
 	persona   Persona
+	schema    *PersonaSchema // Guarded by personaMu
 	personaMu sync.RWMutex
+
+	// nextMemory is the ID given to the next memory, forgetting bounds the
+	// memories, and scoring scores new ones; all are guarded by memoryMu
+	nextMemory uint64
+	forgetting *ForgettingPolicy
+	scoring    *ImportanceScoring
+
+	// embedder embeds memories for Recall
+	embedder Embedder
+	embedMu  sync.RWMutex
+
+	// store persists the soul when set; its data is loaded on first access
+	store    *Store
+	loadOnce sync.Once
+	loadErr  error
+	loaded   atomic.Bool
+	aead     cipher.AEAD // Seals persisted data when the store encrypts
+
+	// replication shares the soul with replicas on other nodes when set
+	replication atomic.Pointer[replication]
+
+	// bus receives the soul's activity events when set
+	bus atomic.Pointer[transport.EventBus]
+
+	// history records value and persona changes, the last at version;
+	// changes beyond historyLimit are dropped, oldest first
+	history      []Change
+	version      uint64
+	historyLimit int
+	historyMu    sync.Mutex
 }
 
 // MemoryEntry represents a piece of soul memory
 type MemoryEntry struct {
-	Timestamp int64
-	Content   string
-	Type      string
-	Tags      []string
+	ID        uint64   `json:"id"` // Assigned by AddMemory
+	Timestamp int64    `json:"timestamp"`
+	Content   string   `json:"content"`
+	Type      string   `json:"type,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+
+	// Embedding is set when the memory is first recalled by meaning
+	Embedding []float32 `json:"embedding,omitempty"`
+
+	// Importance weighs the memory's salience; 0 counts as 1
+	Importance float64 `json:"importance,omitempty"`
+	// Compressed is set once forgetting has shortened the content
+	Compressed bool `json:"compressed,omitempty"`
+	// Sources lists the memories a consolidated summary replaced
+	Sources []uint64 `json:"sources,omitempty"`
+	// Origin is the replica the memory was added on and OriginID its ID
+	// there; both are set while the soul replicates
+	Origin   string `json:"origin,omitempty"`
+	OriginID uint64 `json:"origin_id,omitempty"`
+
+	// accessed is when the memory was last recalled, in Unix nanoseconds
+	accessed int64
 }
 
 // Persona represents a soul's personality traits
 type Persona struct {
-	Traits map[string]float64
-	Goals  []string
+	Traits map[string]float64 `json:"traits"`
+	Goals  []string           `json:"goals"`
 }
 
 // New creates a new Soul instance
 func New(id string) *Soul {
 	return &Soul{
-		ID:     id,
-		memory: make([]MemoryEntry, 0),
-		values: make(map[string]float64),
+		ID:           id,
+		memory:       make([]MemoryEntry, 0),
+		tags:         make(tagIndex),
+		values:       make(map[string]float64),
+		series:       make(map[string]*valueSeries),
+		nextMemory:   1,
+		historyLimit: DefaultHistoryLimit,
 		persona: Persona{
 			Traits: make(map[string]float64),
 			Goals:  make([]string, 0),
@@ -42,15 +103,39 @@ func New(id string) *Soul {
 	}
 }
 
-// AddMemory adds a new memory entry
-func (s *Soul) AddMemory(entry MemoryEntry) {
+// AddMemory adds a new memory entry, scoring its importance when the soul
+// has importance scoring. When the memory would pass the limits of the
+// forgetting policy, older memories are forgotten to make room or, if the
+// policy rejects, ErrQuotaExceeded is returned.
+func (s *Soul) AddMemory(entry MemoryEntry) error {
+	s.load()
 	s.memoryMu.Lock()
 	defer s.memoryMu.Unlock()
+	if err := s.admitLocked(entry); err != nil {
+		return err
+	}
+	if s.scoring != nil {
+		entry.Importance = s.scoring.score(entry, s.memory, time.Now())
+	}
+	entry.ID = s.nextMemory
+	s.nextMemory++
+	if r := s.replication.Load(); r != nil {
+		r.localMemory(s, &entry)
+	}
 	s.memory = append(s.memory, entry)
+	s.tags.add(entry)
+	if s.persisted() {
+		s.stageJSON(memoryKey(s.ID, entry.ID), entry)
+	}
+	s.publishMemory(entry)
+	s.enforceLocked(time.Now(), false)
+	return nil
 }
 
-// GetMemories returns all memories matching given tags
+// GetMemories returns all memories carrying any of the given tags, or
+// every memory when tags is empty. Query selects memories by more criteria.
 func (s *Soul) GetMemories(tags []string) []MemoryEntry {
+	s.load()
 	s.memoryMu.RLock()
 	defer s.memoryMu.RUnlock()
 
@@ -59,53 +144,144 @@ func (s *Soul) GetMemories(tags []string) []MemoryEntry {
 		copy(result, s.memory)
 		return result
 	}
+	return s.lookupLocked(s.tags.any(tags))
+}
 
-	var matches []MemoryEntry
-	for _, entry := range s.memory {
-		if hasMatchingTags(entry.Tags, tags) {
-			matches = append(matches, entry)
-		}
-	}
-	return matches
+// GetMemoriesWithAllTags returns the memories carrying every one of the
+// given tags
+func (s *Soul) GetMemoriesWithAllTags(tags []string) []MemoryEntry {
+	s.load()
+	s.memoryMu.RLock()
+	defer s.memoryMu.RUnlock()
+	return s.lookupLocked(s.tags.all(tags))
 }
 
 // SetValue updates a soul value
 func (s *Soul) SetValue(key string, value float64) {
+	s.SetValueAs("", key, value)
+}
+
+// SetValueAs updates a soul value, recording actor as its author in the
+// history
+func (s *Soul) SetValueAs(actor, key string, value float64) {
+	s.load()
 	s.valuesMu.Lock()
 	defer s.valuesMu.Unlock()
-	s.values[key] = value
+	s.setValueLocked(actor, key, &value)
+	if r := s.replication.Load(); r != nil {
+		r.localValue(s, key, value)
+	}
 }
 
 // GetValue retrieves a soul value
 func (s *Soul) GetValue(key string) (float64, bool) {
+	s.load()
 	s.valuesMu.RLock()
 	defer s.valuesMu.RUnlock()
 	val, ok := s.values[key]
 	return val, ok
 }
 
-// UpdatePersona updates the soul's persona
-func (s *Soul) UpdatePersona(persona Persona) {
+// UpdatePersona updates the soul's persona. It fails with
+// ErrInvalidPersona when the persona breaks the soul's persona schema.
+func (s *Soul) UpdatePersona(persona Persona) error {
+	return s.UpdatePersonaAs("", persona)
+}
+
+// UpdatePersonaAs updates the soul's persona, recording actor as its
+// author in the history
+func (s *Soul) UpdatePersonaAs(actor string, persona Persona) error {
+	s.load()
 	s.personaMu.Lock()
 	defer s.personaMu.Unlock()
-	s.persona = persona
+	if s.schema != nil {
+		if err := s.schema.Check(persona); err != nil {
+			return err
+		}
+	}
+	s.setPersonaLocked(actor, persona)
+	return nil
 }
 
 // GetPersona returns the soul's current persona
 func (s *Soul) GetPersona() Persona {
+	s.load()
 	s.personaMu.RLock()
 	defer s.personaMu.RUnlock()
 	return s.persona
 }
 
-// hasMatchingTags checks if two tag slices share any elements
-func hasMatchingTags(a, b []string) bool {
-	for _, tag := range a {
-		for _, target := range b {
-			if tag == target {
-				return true
+// Err returns the error that kept a stored soul from loading. Such a soul
+// works from memory only and does not write to the store, so it cannot
+// overwrite the data it failed to read.
+func (s *Soul) Err() error {
+	s.load()
+	return s.loadErr
+}
+
+// Loaded reports whether the soul's data is in memory. A stored soul loads
+// when first accessed.
+func (s *Soul) Loaded() bool {
+	return s.store == nil || s.loaded.Load()
+}
+
+// load reads a stored soul's data the first time it is needed
+func (s *Soul) load() {
+	if s.store == nil {
+		return
+	}
+	s.loadOnce.Do(func() {
+		defer s.loaded.Store(true)
+		stored, err := s.store.load(s.ID)
+		if err != nil {
+			s.loadErr = err
+			return
+		}
+		s.aead = stored.aead
+
+		s.memoryMu.Lock()
+		s.memory = append(stored.memories, s.memory...)
+		s.tags = buildTagIndex(s.memory)
+		for _, entry := range stored.memories {
+			if entry.ID >= s.nextMemory {
+				s.nextMemory = entry.ID + 1
 			}
 		}
-	}
-	return false
+		s.memoryMu.Unlock()
+
+		s.valuesMu.Lock()
+		for key, value := range stored.values {
+			s.values[key] = value
+		}
+		for key, series := range restoreSeries(stored.series) {
+			s.series[key] = series
+		}
+		s.valuesMu.Unlock()
+
+		s.personaMu.Lock()
+		if stored.persona != nil {
+			s.persona = *stored.persona
+		}
+		s.schema = stored.schema
+		s.personaMu.Unlock()
+
+		s.historyMu.Lock()
+		s.history = append(stored.history, s.history...)
+		if n := len(stored.history); n > 0 {
+			s.version = max(s.version, stored.history[n-1].Version)
+		}
+		s.historyMu.Unlock()
+	})
+}
+
+// persisted reports whether changes are written to a store
+func (s *Soul) persisted() bool {
+	return s.store != nil && s.loadErr == nil
+}
+
+// sortMemories orders memories by ID, which is the order they were added
+func sortMemories(memories []MemoryEntry) {
+	sort.Slice(memories, func(i, j int) bool {
+		return memories[i].ID < memories[j].ID
+	})
 }
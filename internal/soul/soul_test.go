@@ -0,0 +1,1154 @@
+package soul
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+func TestStore_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	db, err := kv.New(kv.Config{Path: dir})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	store := NewStore(db, StoreConfig{})
+
+	s, err := store.Create("soul-1")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	s.AddMemory(MemoryEntry{Content: "met bob", Tags: []string{"social"}})
+	s.AddMemory(MemoryEntry{Content: "saw rain"})
+	s.SetValue("curiosity", 0.5)
+	s.SetValue("curiosity", 0.75)
+	s.UpdatePersona(Persona{Traits: map[string]float64{"calm": 1}, Goals: []string{"explore"}})
+	doomed, _ := store.Create("soul-2")
+	doomed.SetValue("fear", 1)
+	if err := store.Delete("soul-2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	db.Close()
+
+	db, err = kv.New(kv.Config{Path: dir})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store = NewStore(db, StoreConfig{})
+	defer store.Close()
+
+	if ids, err := store.List(); err != nil || len(ids) != 1 || ids[0] != "soul-1" {
+		t.Errorf("List() = %v, %v, want [soul-1]", ids, err)
+	}
+	s = store.Open("soul-1")
+	if s.Loaded() {
+		t.Error("soul loaded before first access")
+	}
+	memories := s.GetMemories(nil)
+	if !s.Loaded() {
+		t.Error("soul not loaded after first access")
+	}
+	if len(memories) != 2 || memories[0].Content != "met bob" || memories[1].ID != 2 {
+		t.Errorf("memories = %+v, want met bob then saw rain", memories)
+	}
+	if v, _ := s.GetValue("curiosity"); v != 0.75 {
+		t.Errorf("curiosity = %v, want 0.75", v)
+	}
+	if p := s.GetPersona(); p.Traits["calm"] != 1 || len(p.Goals) != 1 {
+		t.Errorf("persona = %+v", p)
+	}
+	s.AddMemory(MemoryEntry{Content: "third"})
+	if got := s.GetMemories(nil); got[2].ID != 3 || s.Err() != nil {
+		t.Errorf("new memory ID = %d, want 3", got[2].ID)
+	}
+	if _, ok := store.Open("soul-2").GetValue("fear"); ok {
+		t.Error("deleted soul still has its value")
+	}
+}
+
+func TestStore_NestedIDs(t *testing.T) {
+	db, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store := NewStore(db, StoreConfig{})
+	defer store.Close()
+
+	parent, err := store.Create("a")
+	if err != nil {
+		t.Fatalf("Create(a) error = %v", err)
+	}
+	parent.SetValue("mood", 1)
+
+	// "a/b" would share a's prefix, so deleting or loading one would reach
+	// the other's data
+	if _, err := store.Create("a/b"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Create(a/b) error = %v, want ErrInvalidID", err)
+	}
+	if err := store.Delete("a/b"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Delete(a/b) error = %v, want ErrInvalidID", err)
+	}
+	nested := store.Open("a/b")
+	if _, ok := nested.GetValue("mood"); ok || !errors.Is(nested.Err(), ErrInvalidID) {
+		t.Errorf("Open(a/b) loaded a's value or Err() = %v, want ErrInvalidID", nested.Err())
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if ids, err := store.List(); err != nil || len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("List() = %v, %v, want [a]", ids, err)
+	}
+	if v, ok := store.Open("a").GetValue("mood"); !ok || v != 1 {
+		t.Errorf("a's mood = %v, %v, want 1", v, ok)
+	}
+}
+
+// countingEmbedder counts the texts it embeds
+type countingEmbedder struct {
+	HashingEmbedder
+	texts int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts += len(texts)
+	return e.HashingEmbedder.Embed(ctx, texts)
+}
+
+func TestSoul_Recall(t *testing.T) {
+	s := New("soul-1")
+	ctx := context.Background()
+	if _, err := s.Recall(ctx, "cat", 1); !errors.Is(err, ErrNoEmbedder) {
+		t.Errorf("Recall() without embedder error = %v, want ErrNoEmbedder", err)
+	}
+
+	embedder := &countingEmbedder{}
+	s.SetEmbedder(embedder)
+	for _, content := range []string{"the cat sat on the mat", "stock prices fell sharply", "a cat chased a mouse"} {
+		s.AddMemory(MemoryEntry{Content: content})
+	}
+
+	got, err := s.Recall(ctx, "cat", 2)
+	if err != nil {
+		t.Fatalf("Recall() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID+got[1].ID != 4 {
+		t.Errorf("Recall() = %+v, want the two cat memories", got)
+	}
+
+	// Embeddings are kept, so a second recall embeds only the query
+	embedder.texts = 0
+	if _, err := s.Recall(ctx, "prices", 1); err != nil || embedder.texts != 1 {
+		t.Errorf("second Recall() embedded %d texts, want 1 (err %v)", embedder.texts, err)
+	}
+}
+
+func TestHTTPEmbedder_Indexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"in order", `[{"index":0,"embedding":[1]},{"index":1,"embedding":[2]}]`, false},
+		{"reordered", `[{"index":1,"embedding":[2]},{"index":0,"embedding":[1]}]`, false},
+		{"duplicate", `[{"index":0,"embedding":[1]},{"index":0,"embedding":[2]}]`, true},
+		{"out of range", `[{"index":0,"embedding":[1]},{"index":2,"embedding":[2]}]`, true},
+		{"empty embedding", `[{"index":0,"embedding":[1]},{"index":1,"embedding":[]}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"data":%s}`, tt.data)
+			}))
+			defer srv.Close()
+
+			e := &HTTPEmbedder{URL: srv.URL}
+			got, err := e.Embed(context.Background(), []string{"a", "b"})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Embed() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || len(got) != 2 || got[0][0] != 1 || got[1][0] != 2 {
+				t.Errorf("Embed() = %v, %v, want [[1] [2]]", got, err)
+			}
+		})
+	}
+}
+
+// evictionRecorder records eviction counts by reason
+type evictionRecorder struct {
+	evictions map[string]int
+	size      int64
+}
+
+func (r *evictionRecorder) RecordSoulEviction(_, reason string, count int) {
+	r.evictions[reason] += count
+}
+
+func (r *evictionRecorder) RecordSoulMemory(_ string, size int64) {
+	r.size = size
+}
+
+func TestSoul_Forgetting(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(h int) int64 { return now.Add(-time.Duration(h) * time.Hour).UnixNano() }
+
+	tests := []struct {
+		name   string
+		policy ForgettingPolicy
+		want   []string
+	}{
+		{"lru keeps newest", ForgettingPolicy{Policy: ForgetLRU, MaxMemories: 2}, []string{"important", "recent"}},
+		{"importance keeps salient", ForgettingPolicy{MaxMemories: 2, HalfLife: time.Hour}, []string{"old", "important"}},
+		{"compress before evicting", ForgettingPolicy{MaxBytes: 10, CompressTo: 3}, []string{"old", "imp", "rec"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("soul-1")
+			s.AddMemory(MemoryEntry{Content: "old", Timestamp: hoursAgo(3), Importance: 100})
+			s.AddMemory(MemoryEntry{Content: "important", Timestamp: hoursAgo(2), Importance: 10})
+			s.AddMemory(MemoryEntry{Content: "recent", Timestamp: hoursAgo(1)})
+			recorder := &evictionRecorder{evictions: make(map[string]int)}
+			tt.policy.Recorder = recorder
+			s.SetForgetting(tt.policy)
+
+			var got []string
+			for _, m := range s.GetMemories(nil) {
+				got = append(got, m.Content)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("memories = %v, want %v", got, tt.want)
+			}
+			if recorder.size == 0 {
+				t.Error("memory size not recorded")
+			}
+		})
+	}
+
+	// Forget evicts memories decayed below the minimum salience
+	s := New("soul-1")
+	s.AddMemory(MemoryEntry{Content: "stale", Timestamp: hoursAgo(48)})
+	s.AddMemory(MemoryEntry{Content: "fresh", Timestamp: hoursAgo(0)})
+	s.SetForgetting(ForgettingPolicy{MinSalience: 0.5})
+	if stats := s.Forget(now); stats.Evicted != 1 || s.GetMemories(nil)[0].Content != "fresh" {
+		t.Errorf("Forget() = %+v, want the stale memory evicted", stats)
+	}
+}
+
+func TestSoul_Consolidate(t *testing.T) {
+	now := time.Now()
+	s := New("soul-1")
+	for i, tag := range []string{"food", "food", "weather", "food", "food"} {
+		s.AddMemory(MemoryEntry{Content: fmt.Sprintf("%s %d", tag, i), Tags: []string{tag}, Timestamp: now.Add(-time.Hour).UnixNano(), Importance: float64(i)})
+	}
+	s.AddMemory(MemoryEntry{Content: "food today", Tags: []string{"food"}, Timestamp: now.UnixNano()})
+
+	var summarized []string
+	cfg := ConsolidationConfig{
+		MinAge: time.Minute,
+		Summarizer: SummarizerFunc(func(_ context.Context, memories []MemoryEntry) (string, error) {
+			for _, m := range memories {
+				summarized = append(summarized, m.Content)
+			}
+			return "ate a lot", nil
+		}),
+	}
+	stats, err := s.Consolidate(context.Background(), cfg, now)
+	if err != nil {
+		t.Fatalf("Consolidate() error = %v", err)
+	}
+	if stats.Clusters != 1 || stats.Merged != 4 {
+		t.Errorf("Consolidate() = %+v, want 4 memories merged into 1", stats)
+	}
+	if fmt.Sprint(summarized) != "[food 0 food 1 food 3 food 4]" {
+		t.Errorf("summarized %v, want the old food memories", summarized)
+	}
+
+	memories := s.GetMemories(nil)
+	summary := memories[len(memories)-1]
+	if len(memories) != 3 || summary.Type != SummaryType || fmt.Sprint(summary.Sources) != "[1 2 4 5]" || summary.Importance != 4 {
+		t.Errorf("memories after consolidation = %+v", memories)
+	}
+	if stats, _ := s.Consolidate(context.Background(), cfg, now); stats.Clusters != 0 {
+		t.Errorf("second Consolidate() merged %+v, want nothing", stats)
+	}
+}
+
+func TestSoul_ExportImport(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New("alice")
+	s.AddMemory(MemoryEntry{Content: "first", Tags: []string{"a"}})
+	s.AddMemory(MemoryEntry{Content: "second", Importance: 2})
+	s.SetValue("curiosity", 0.7)
+	s.UpdatePersona(Persona{Traits: map[string]float64{"calm": 0.4}, Goals: []string{"explore"}})
+
+	data, err := s.Export(key)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	imported, err := Import(data, []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported.ID != "alice" {
+		t.Errorf("ID = %s, want alice", imported.ID)
+	}
+	memories := imported.GetMemories(nil)
+	if len(memories) != 2 || memories[0].Content != "first" || memories[1].ID != 2 {
+		t.Errorf("memories = %+v, want both memories with their IDs", memories)
+	}
+	if v, _ := imported.GetValue("curiosity"); v != 0.7 {
+		t.Errorf("curiosity = %v, want 0.7", v)
+	}
+	if p := imported.GetPersona(); p.Traits["calm"] != 0.4 || len(p.Goals) != 1 {
+		t.Errorf("persona = %+v", p)
+	}
+	imported.AddMemory(MemoryEntry{Content: "third"})
+	if got := imported.GetMemories(nil)[2].ID; got != 3 {
+		t.Errorf("next memory ID = %d, want 3", got)
+	}
+
+	if _, err := Import(data, []ed25519.PublicKey{other}); !errors.Is(err, ErrUntrustedSigner) {
+		t.Errorf("Import() from untrusted signer error = %v, want ErrUntrustedSigner", err)
+	}
+
+	var signed signedBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		t.Fatal(err)
+	}
+	tampered := signed
+	tampered.Bundle = json.RawMessage(strings.Replace(string(signed.Bundle), "first", "forged", 1))
+	forged, _ := json.Marshal(tampered)
+	if _, err := Import(forged, nil); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Import() of tampered bundle error = %v, want ErrBadSignature", err)
+	}
+
+	// A validly signed bundle must still pass schema validation
+	sign := func(b Bundle) []byte {
+		payload, _ := json.Marshal(b)
+		out, _ := json.Marshal(signedBundle{
+			Bundle:    payload,
+			Signer:    signed.Signer,
+			Signature: hex.EncodeToString(ed25519.Sign(key, payload)),
+		})
+		return out
+	}
+	tests := []struct {
+		name   string
+		bundle Bundle
+	}{
+		{"future version", Bundle{Version: BundleVersion + 1, SoulID: "x"}},
+		{"missing id", Bundle{Version: BundleVersion}},
+		{"duplicate memory", Bundle{Version: BundleVersion, SoulID: "x", Memories: []MemoryEntry{{ID: 1}, {ID: 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Import(sign(tt.bundle), nil); !errors.Is(err, ErrInvalidBundle) {
+				t.Errorf("Import() error = %v, want ErrInvalidBundle", err)
+			}
+		})
+	}
+}
+
+// memoryHub is an in-process ReplicationTransport that, like pubsub,
+// delivers messages to every subscriber including the sender
+type memoryHub struct {
+	subs []chan transport.Message
+	mu   sync.Mutex
+}
+
+func (h *memoryHub) Publish(ctx context.Context, topic string, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.subs {
+		ch <- transport.Message{Topic: topic, Payload: data}
+	}
+	return nil
+}
+
+func (h *memoryHub) Subscribe(ctx context.Context, topic string) (<-chan transport.Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan transport.Message, 1024)
+	h.subs = append(h.subs, ch)
+	return ch, nil
+}
+
+func TestSoul_Replicate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := &memoryHub{}
+	a, b := New("alice"), New("alice")
+	a.AddMemory(MemoryEntry{Content: "before"})
+	a.SetValue("mood", 1)
+	for _, r := range []struct {
+		s  *Soul
+		id string
+	}{{a, "node-a"}, {b, "node-b"}} {
+		cfg := ReplicationConfig{Replica: r.id, Transport: hub, SyncInterval: 10 * time.Millisecond}
+		if err := r.s.Replicate(ctx, cfg); err != nil {
+			t.Fatalf("Replicate() error = %v", err)
+		}
+	}
+	if err := a.Replicate(ctx, ReplicationConfig{Replica: "node-a", Transport: hub}); err == nil {
+		t.Error("Replicate() twice succeeded")
+	}
+
+	a.AddMemory(MemoryEntry{Content: "from a"})
+	b.AddMemory(MemoryEntry{Content: "from b"})
+	b.SetValue("mood", 2) // Later write wins
+	a.SetValue("energy", 5)
+
+	contents := func(s *Soul) string {
+		var result []string
+		for _, entry := range s.GetMemories(nil) {
+			result = append(result, entry.Content)
+		}
+		sort.Strings(result)
+		return strings.Join(result, ",")
+	}
+	converged := func() bool {
+		am, _ := a.GetValue("mood")
+		bm, _ := b.GetValue("mood")
+		ae, _ := a.GetValue("energy")
+		be, _ := b.GetValue("energy")
+		return contents(a) == "before,from a,from b" && contents(b) == contents(a) &&
+			am == 2 && bm == 2 && ae == 5 && be == 5
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !converged() {
+		if time.Now().After(deadline) {
+			t.Fatalf("replicas did not converge: a = %s %v, b = %s %v", contents(a), a.values, contents(b), b.values)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Periodic syncs do not duplicate memories
+	time.Sleep(30 * time.Millisecond)
+	if n := len(b.GetMemories(nil)); n != 3 {
+		t.Errorf("replica b has %d memories, want 3", n)
+	}
+}
+
+func TestSoul_History(t *testing.T) {
+	db, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store := NewStore(db, StoreConfig{})
+
+	s, err := store.Create("alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	s.SetValueAs("agent-1", "mood", 1)
+	s.UpdatePersonaAs("agent-1", Persona{Traits: map[string]float64{"calm": 0.9}})
+	good := s.Version()
+	s.SetValueAs("agent-2", "mood", -5)
+	s.SetValueAs("agent-2", "anger", 10)
+	s.UpdatePersonaAs("agent-2", Persona{Traits: map[string]float64{"calm": 0}})
+
+	history := s.GetHistory()
+	if len(history) != 5 || good != 2 {
+		t.Fatalf("history has %d changes, good version %d; want 5 and 2", len(history), good)
+	}
+	if c := history[2]; c.Actor != "agent-2" || c.Key != "mood" || *c.Old != 1 || *c.New != -5 {
+		t.Errorf("change 3 = %+v, want agent-2 setting mood from 1 to -5", c)
+	}
+
+	if err := s.RollbackTo(good, "admin"); err != nil {
+		t.Fatalf("RollbackTo() error = %v", err)
+	}
+	if v, _ := s.GetValue("mood"); v != 1 {
+		t.Errorf("mood = %v, want 1", v)
+	}
+	if _, ok := s.GetValue("anger"); ok {
+		t.Error("anger is still set after rollback")
+	}
+	if calm := s.GetPersona().Traits["calm"]; calm != 0.9 {
+		t.Errorf("calm = %v, want 0.9", calm)
+	}
+	if last := s.GetHistory()[len(s.GetHistory())-1]; last.Actor != "admin" || s.Version() != 8 {
+		t.Errorf("last change = %+v at version %d, want admin's rollback at 8", last, s.Version())
+	}
+	if err := s.RollbackTo(100, "admin"); err == nil {
+		t.Error("RollbackTo() a future version succeeded")
+	}
+
+	// History survives a restart
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	store = NewStore(db, StoreConfig{})
+	defer store.Close()
+	reopened := store.Open("alice")
+	if n := len(reopened.GetHistory()); n != 8 || reopened.Version() != 8 {
+		t.Errorf("reopened history has %d changes at version %d, want 8", n, reopened.Version())
+	}
+	if err := reopened.RollbackTo(0, "admin"); err != nil {
+		t.Fatalf("RollbackTo(0) error = %v", err)
+	}
+	if _, ok := reopened.GetValue("mood"); ok {
+		t.Error("mood is still set after rolling back to version 0")
+	}
+
+	reopened.SetHistoryLimit(2)
+	if err := reopened.RollbackTo(good, "admin"); !errors.Is(err, ErrVersionNotRetained) {
+		t.Errorf("RollbackTo() past the limit error = %v, want ErrVersionNotRetained", err)
+	}
+}
+
+func TestSoul_TagIndex(t *testing.T) {
+	s := New("indexed")
+	for i := 0; i < 100; i++ {
+		tags := []string{fmt.Sprint("n", i%10)}
+		if i%2 == 0 {
+			tags = append(tags, "even", "even") // Repeats are indexed once
+		}
+		s.AddMemory(MemoryEntry{Content: fmt.Sprint(i), Tags: tags})
+	}
+	s.SetForgetting(ForgettingPolicy{Policy: ForgetLRU, MaxMemories: 50})
+
+	tests := []struct {
+		name  string
+		query func() []MemoryEntry
+		want  int
+	}{
+		{"any", func() []MemoryEntry { return s.GetMemories([]string{"n1", "n2"}) }, 10},
+		{"any with overlap", func() []MemoryEntry { return s.GetMemories([]string{"n2", "even"}) }, 25},
+		{"all", func() []MemoryEntry { return s.GetMemoriesWithAllTags([]string{"even", "n4"}) }, 5},
+		{"all disjoint", func() []MemoryEntry { return s.GetMemoriesWithAllTags([]string{"even", "n3"}) }, 0},
+		{"unknown tag", func() []MemoryEntry { return s.GetMemories([]string{"missing"}) }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.query()
+			if len(got) != tt.want {
+				t.Fatalf("got %d memories, want %d", len(got), tt.want)
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].ID <= got[i-1].ID {
+					t.Fatalf("memories out of order: %d after %d", got[i].ID, got[i-1].ID)
+				}
+			}
+			for _, entry := range got {
+				if entry.ID <= 50 {
+					t.Errorf("forgotten memory %d still indexed", entry.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSoul_Query(t *testing.T) {
+	s := New("queried")
+	memories := []MemoryEntry{
+		{Content: "a", Timestamp: 10, Type: "dream", Tags: []string{"night", "happy"}},
+		{Content: "b", Timestamp: 40, Type: "event", Tags: []string{"day", "happy"}},
+		{Content: "c", Timestamp: 20, Type: "dream", Tags: []string{"night", "sad"}},
+		{Content: "d", Timestamp: 30, Type: "event", Tags: []string{"day"}},
+		{Content: "e", Timestamp: 50, Type: "event"},
+	}
+	for _, m := range memories {
+		s.AddMemory(m)
+	}
+	expr := func(e TagExpr) *TagExpr { return &e }
+
+	tests := []struct {
+		name  string
+		query MemoryQuery
+		want  string
+	}{
+		{"all", MemoryQuery{}, "abcde"},
+		{"and", MemoryQuery{Tags: expr(And(Tag("night"), Tag("happy")))}, "a"},
+		{"or", MemoryQuery{Tags: expr(Or(Tag("sad"), Tag("day")))}, "bcd"},
+		{"and not", MemoryQuery{Tags: expr(And(Tag("day"), Not(Tag("happy"))))}, "d"},
+		{"not", MemoryQuery{Tags: expr(Not(AnyTag("night", "day")))}, "e"},
+		{"or with not", MemoryQuery{Tags: expr(Or(Tag("sad"), Not(Tag("happy"))))}, "cde"},
+		{"type", MemoryQuery{Types: []string{"dream"}}, "ac"},
+		{"time range", MemoryQuery{From: 20, To: 40}, "cd"},
+		{"newest", MemoryQuery{Order: OrderNewest}, "ebdca"},
+		{"oldest page", MemoryQuery{Order: OrderOldest, Offset: 1, Limit: 2}, "cd"},
+		{"offset past end", MemoryQuery{Offset: 10}, ""},
+		{"combined", MemoryQuery{Tags: expr(Tag("happy")), Types: []string{"event"}, Order: OrderNewest}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			var contents string
+			for _, entry := range got {
+				contents += entry.Content
+			}
+			if contents != tt.want {
+				t.Errorf("Query() = %q, want %q", contents, tt.want)
+			}
+		})
+	}
+
+	invalid := []MemoryQuery{
+		{Tags: &TagExpr{Tag: "a", Or: []TagExpr{Tag("b")}}},
+		{Tags: expr(And(TagExpr{}))},
+		{Order: "sideways"},
+		{Limit: -1},
+		{From: 10, To: 5},
+	}
+	for _, q := range invalid {
+		if _, err := s.Query(q); err == nil {
+			t.Errorf("Query(%+v) succeeded, want an error", q)
+		}
+	}
+}
+
+func TestSoul_Quota(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ForgettingPolicy
+		add    []string
+		want   MemoryUsage
+		err    bool
+	}{
+		{"count rejects", ForgettingPolicy{MaxMemories: 2, Reject: true}, []string{"a", "b", "c"}, MemoryUsage{2, 2}, true},
+		{"bytes rejects", ForgettingPolicy{MaxBytes: 5, Reject: true}, []string{"abc", "de", "f"}, MemoryUsage{2, 5}, true},
+		{"count evicts", ForgettingPolicy{MaxMemories: 2}, []string{"a", "b", "c"}, MemoryUsage{2, 2}, false},
+		{"under quota", ForgettingPolicy{MaxMemories: 5, MaxBytes: 10, Reject: true}, []string{"a", "b"}, MemoryUsage{2, 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("bounded")
+			s.SetForgetting(tt.policy)
+			var err error
+			for _, content := range tt.add {
+				if e := s.AddMemory(MemoryEntry{Content: content}); e != nil {
+					err = e
+				}
+			}
+			if (err != nil) != tt.err || (err != nil && !errors.Is(err, ErrQuotaExceeded)) {
+				t.Errorf("AddMemory() error = %v, want quota error %v", err, tt.err)
+			}
+			if got := s.Usage(); got != tt.want {
+				t.Errorf("Usage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A store applies its policy to the souls it opens
+	db, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store := NewStore(db, StoreConfig{Forgetting: &ForgettingPolicy{MaxMemories: 1, Reject: true}})
+	defer store.Close()
+	s := store.Open("stored")
+	s.AddMemory(MemoryEntry{Content: "kept"})
+	if err := s.AddMemory(MemoryEntry{Content: "refused"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("AddMemory() past the store quota error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestSoul_PersonaSchema(t *testing.T) {
+	templates, err := ParsePersonaTemplates([]byte(`
+templates:
+  explorer:
+    schema:
+      traits:
+        curiosity: {required: true, min: 0, max: 1}
+        calm: {min: 0}
+      strict: true
+      required_goals: [explore]
+    traits: {curiosity: 0.8}
+    goals: [explore]
+`))
+	if err != nil {
+		t.Fatalf("ParsePersonaTemplates() error = %v", err)
+	}
+	s := New("templated")
+	if err := s.ApplyTemplate("admin", templates["explorer"]); err != nil {
+		t.Fatalf("ApplyTemplate() error = %v", err)
+	}
+	if got := s.GetPersona().Traits["curiosity"]; got != 0.8 {
+		t.Errorf("curiosity = %v, want 0.8", got)
+	}
+
+	tests := []struct {
+		name    string
+		persona Persona
+		valid   bool
+	}{
+		{"valid", Persona{Traits: map[string]float64{"curiosity": 1, "calm": 3}, Goals: []string{"explore", "rest"}}, true},
+		{"missing required trait", Persona{Traits: map[string]float64{"calm": 1}, Goals: []string{"explore"}}, false},
+		{"out of range", Persona{Traits: map[string]float64{"curiosity": 1.5}, Goals: []string{"explore"}}, false},
+		{"below min", Persona{Traits: map[string]float64{"curiosity": 0, "calm": -1}, Goals: []string{"explore"}}, false},
+		{"unknown trait", Persona{Traits: map[string]float64{"curiosity": 0.5, "anger": 1}, Goals: []string{"explore"}}, false},
+		{"missing goal", Persona{Traits: map[string]float64{"curiosity": 0.5}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.UpdatePersona(tt.persona)
+			if tt.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidPersona)) {
+				t.Errorf("UpdatePersona() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+
+	// A schema the current persona breaks is refused
+	floor := 5.0
+	if err := s.SetPersonaSchema(&PersonaSchema{Traits: map[string]TraitSchema{"curiosity": {Min: &floor}}}); err == nil {
+		t.Error("SetPersonaSchema() accepted a schema the persona breaks")
+	}
+	if _, err := ParsePersonaTemplates([]byte("templates: {bad: {schema: {required_goals: [x]}}}")); err == nil {
+		t.Error("ParsePersonaTemplates() accepted a template breaking its own schema")
+	}
+}
+
+func TestSoul_ValueHistory(t *testing.T) {
+	db, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store := NewStore(db, StoreConfig{})
+
+	s := store.Open("drifting")
+	s.SetValue("mood", 1)
+	mid := time.Now().UnixNano()
+	s.SetValue("mood", 2)
+	s.SetValue("other", 9)
+
+	points := s.GetValueHistory("mood", 0, 0)
+	if len(points) != 2 || points[0].Value != 1 || points[1].Value != 2 || points[0].Time > points[1].Time {
+		t.Fatalf("GetValueHistory() = %+v, want 1 then 2", points)
+	}
+	if got := s.GetValueHistory("mood", mid, 0); len(got) != 1 || got[0].Value != 2 {
+		t.Errorf("GetValueHistory(from) = %+v, want the second point", got)
+	}
+	if got := s.GetValueHistory("missing", 0, 0); got != nil {
+		t.Errorf("GetValueHistory(missing) = %+v, want nil", got)
+	}
+
+	// The series keeps the latest points, across a restart
+	for i := 0; i < ValueSeriesLength+5; i++ {
+		s.SetValue("busy", float64(i))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	store = NewStore(db, StoreConfig{})
+	defer store.Close()
+	reopened := store.Open("drifting")
+	for _, s := range []*Soul{s, reopened} {
+		busy := s.GetValueHistory("busy", 0, 0)
+		if len(busy) != ValueSeriesLength || busy[0].Value != 5 || busy[len(busy)-1].Value != ValueSeriesLength+4 {
+			t.Fatalf("busy series has %d points from %v to %v", len(busy), busy[0].Value, busy[len(busy)-1].Value)
+		}
+	}
+	reopened.SetValue("busy", -1)
+	busy := reopened.GetValueHistory("busy", 0, 0)
+	if busy[0].Value != 6 || busy[len(busy)-1].Value != -1 {
+		t.Errorf("after another point series runs from %v to %v, want 6 to -1", busy[0].Value, busy[len(busy)-1].Value)
+	}
+}
+
+func TestStore_Encryption(t *testing.T) {
+	db, err := kv.New(kv.Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	master := bytes.Repeat([]byte{7}, 32)
+
+	store := NewStore(db, StoreConfig{MasterKey: master})
+	s, err := store.Create("secret")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	s.AddMemory(MemoryEntry{Content: "the password is swordfish"})
+	s.UpdatePersona(Persona{Goals: []string{"hide the treasure"}})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	raw, err := db.Get(memoryKey("secret", 1))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if bytes.Contains(raw, []byte("swordfish")) {
+		t.Error("memory is stored in plaintext")
+	}
+	raw, _ = db.Get(personaKeyOf("secret"))
+	if bytes.Contains(raw, []byte("treasure")) {
+		t.Error("persona is stored in plaintext")
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		ok   bool
+	}{
+		{"same master key", master, true},
+		{"wrong master key", bytes.Repeat([]byte{8}, 32), false},
+		{"no master key", nil, false},
+		{"invalid master key", []byte("short"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(db, StoreConfig{MasterKey: tt.key})
+			defer store.Close()
+			s := store.Open("secret")
+			if (s.Err() == nil) != tt.ok {
+				t.Fatalf("Err() = %v, want ok %v", s.Err(), tt.ok)
+			}
+			if tt.ok && s.GetMemories(nil)[0].Content != "the password is swordfish" {
+				t.Errorf("memories = %+v", s.GetMemories(nil))
+			}
+		})
+	}
+}
+
+func TestSoul_Merge(t *testing.T) {
+	newPair := func() (*Soul, *Soul) {
+		a, b := New("a"), New("b")
+		a.AddMemory(MemoryEntry{Content: "shared"})
+		a.AddMemory(MemoryEntry{Content: "only a"})
+		b.AddMemory(MemoryEntry{Content: "shared"})
+		b.AddMemory(MemoryEntry{Content: "only b", Tags: []string{"b"}})
+		a.SetValue("mood", 2)
+		a.SetValue("a", 1)
+		b.SetValue("mood", 4) // Set after a's
+		b.SetValue("b", 1)
+		a.UpdatePersona(Persona{Traits: map[string]float64{"calm": 1}, Goals: []string{"rest"}})
+		b.UpdatePersona(Persona{Traits: map[string]float64{"calm": 0.5, "bold": 1}, Goals: []string{"explore", "rest"}})
+		return a, b
+	}
+
+	tests := []struct {
+		strategy MergeStrategy
+		mood     float64
+		calm     float64
+	}{
+		{MergeAverage, 3, 0.75},
+		{MergeMax, 4, 1},
+		{MergePreferNewer, 4, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			a, b := newPair()
+			stats, err := a.Merge(b, tt.strategy)
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if stats.Memories != 1 || stats.Duplicates != 1 {
+				t.Errorf("stats = %+v, want 1 memory added and 1 duplicate", stats)
+			}
+			if got := len(a.GetMemories(nil)); got != 3 {
+				t.Errorf("merged soul has %d memories, want 3", got)
+			}
+			if got := a.GetMemories([]string{"b"}); len(got) != 1 {
+				t.Errorf("merged memory lost its tags: %+v", got)
+			}
+			if mood, _ := a.GetValue("mood"); mood != tt.mood {
+				t.Errorf("mood = %v, want %v", mood, tt.mood)
+			}
+			if v, ok := a.GetValue("b"); !ok || v != 1 {
+				t.Error("value only b held was not copied")
+			}
+			persona := a.GetPersona()
+			if persona.Traits["calm"] != tt.calm || persona.Traits["bold"] != 1 {
+				t.Errorf("traits = %v, want calm %v and bold 1", persona.Traits, tt.calm)
+			}
+			if len(persona.Goals) != 2 {
+				t.Errorf("goals = %v, want rest and explore", persona.Goals)
+			}
+			if last := a.GetHistory()[len(a.GetHistory())-1]; last.Actor != "merge:b" {
+				t.Errorf("last change by %q, want merge:b", last.Actor)
+			}
+		})
+	}
+
+	a, _ := newPair()
+	if _, err := a.Merge(a, MergeMax); err == nil {
+		t.Error("Merge() of a soul into itself succeeded")
+	}
+	if _, err := a.Merge(New("c"), "median"); err == nil {
+		t.Error("Merge() with an unknown strategy succeeded")
+	}
+}
+
+func TestSoul_Events(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := transport.NewEventBus()
+	events := bus.Subscribe(ctx, transport.EventTypeSoul)
+
+	s := New("evented")
+	s.SetEventBus(bus)
+	s.AddMemory(MemoryEntry{Content: "met the guide", Type: "episode", Tags: []string{"guide"}})
+	s.SetValueAs("agent-1", "trust", 0.5)
+	s.SetValueAs("agent-1", "trust", 0.75)
+	s.UpdatePersonaAs("agent-1", Persona{Traits: map[string]float64{"calm": 1}, Goals: []string{"rest"}})
+	s.SetEventBus(nil)
+	s.SetValue("trust", 1)
+
+	tests := []struct {
+		event string
+		check func(data map[string]interface{}) bool
+	}{
+		{EventMemoryAdded, func(d map[string]interface{}) bool {
+			return d["memory_id"] == uint64(1) && d["type"] == "episode" && len(d["tags"].([]string)) == 1
+		}},
+		{EventValueChanged, func(d map[string]interface{}) bool {
+			_, hasOld := d["old"]
+			return d["key"] == "trust" && d["value"] == 0.5 && !hasOld && d["actor"] == "agent-1"
+		}},
+		{EventValueChanged, func(d map[string]interface{}) bool {
+			return d["old"] == 0.5 && d["value"] == 0.75
+		}},
+		{EventPersonaUpdated, func(d map[string]interface{}) bool {
+			traits := d["traits"].([]string)
+			return len(traits) == 1 && traits[0] == "calm" && d["goals"] == 1
+		}},
+	}
+	for _, tt := range tests {
+		select {
+		case e := <-events:
+			if e.Source != "evented" || e.Data["soul_id"] != "evented" || e.Data["event"] != tt.event {
+				t.Fatalf("event = %+v, want %s from evented", e, tt.event)
+			}
+			if !tt.check(e.Data) {
+				t.Errorf("%s event data = %v", tt.event, e.Data)
+			}
+		default:
+			t.Fatalf("no %s event published", tt.event)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("event published without a bus: %+v", e)
+	default:
+	}
+}
+
+func TestSoul_ImportanceScoring(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		scoring ImportanceScoring
+		entry   MemoryEntry
+		want    float64 // Importance of entry added after "the river is cold"
+	}{
+		{"novel", ImportanceScoring{}, MemoryEntry{Content: "a wolf howled"}, 1},
+		{"repeat", ImportanceScoring{}, MemoryEntry{Content: "The river is cold"}, 0.5},
+		{"explicit weight", ImportanceScoring{}, MemoryEntry{Content: "a bridge fell", Importance: 4}, 4},
+		{"old", ImportanceScoring{RecencyHalfLife: time.Hour}, MemoryEntry{Content: "a wolf howled", Timestamp: now.Add(-2 * time.Hour).UnixNano()}, 0.25},
+		{"novelty ignored", ImportanceScoring{NoveltyWindow: -1}, MemoryEntry{Content: "the river is cold"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("scored")
+			s.AddMemory(MemoryEntry{Content: "the river is cold", Timestamp: now.UnixNano()})
+			s.SetImportanceScoring(tt.scoring)
+			s.AddMemory(tt.entry)
+			memories := s.GetMemories(nil)
+			if got := memories[1].Importance; math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("Importance = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Important memories are ranked first, recalled first with a weight,
+	// and kept when forgetting
+	s := New("ranked")
+	s.SetImportanceScoring(ImportanceScoring{NoveltyWindow: -1, RecallWeight: 1})
+	s.SetEmbedder(HashingEmbedder{})
+	s.AddMemory(MemoryEntry{Content: "the river is cold", Timestamp: now.UnixNano()})
+	s.AddMemory(MemoryEntry{Content: "the river is deep", Importance: 3, Timestamp: now.UnixNano()})
+	ranked, err := s.Query(MemoryQuery{Order: OrderImportance})
+	if err != nil || ranked[0].Content != "the river is deep" {
+		t.Errorf("Query(OrderImportance) = %v, %v", ranked, err)
+	}
+	recalled, err := s.Recall(context.Background(), "the river is cold", 1)
+	if err != nil || recalled[0].Content != "the river is deep" {
+		t.Errorf("Recall() = %v, %v; want the important memory first", recalled, err)
+	}
+	s.SetForgetting(ForgettingPolicy{MaxMemories: 1})
+	if kept := s.GetMemories(nil); len(kept) != 1 || kept[0].Content != "the river is deep" {
+		t.Errorf("forgetting kept %v, want the important memory", kept)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	s := New("diffed")
+	s.AddMemory(MemoryEntry{Content: "forgotten"})
+	s.AddMemory(MemoryEntry{Content: "kept"})
+	s.AddMemory(MemoryEntry{Content: "retagged"})
+	s.SetValue("same", 1)
+	s.SetValue("changed", 1)
+	s.SetValue("removed", 1)
+	s.UpdatePersona(Persona{Traits: map[string]float64{"calm": 0.5}, Goals: []string{"rest", "eat"}})
+	before := s.Snapshot()
+
+	if diff := Diff(before, s.Snapshot()); !diff.Empty() {
+		t.Errorf("Diff() of unchanged soul = %+v", diff)
+	}
+
+	s.AddMemory(MemoryEntry{Content: "new"})
+	s.SetForgetting(ForgettingPolicy{Policy: ForgetLRU, MaxMemories: 3})
+	s.memoryMu.Lock()
+	s.memory[1].Tags = []string{"x"}
+	s.memoryMu.Unlock()
+	s.SetValue("changed", 2)
+	s.SetValue("added", 3)
+	s.valuesMu.Lock()
+	s.setValueLocked("", "removed", nil)
+	s.valuesMu.Unlock()
+	s.UpdatePersona(Persona{Traits: map[string]float64{"calm": 0.5, "bold": 1}, Goals: []string{"eat", "explore"}})
+
+	diff := Diff(before, s.Snapshot())
+	contents := func(entries []MemoryEntry) string {
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Content)
+		}
+		return strings.Join(result, ",")
+	}
+	deltas := func(ds []Delta) string {
+		var result []string
+		for _, d := range ds {
+			old, updated := "-", "-"
+			if d.Old != nil {
+				old = fmt.Sprint(*d.Old)
+			}
+			if d.New != nil {
+				updated = fmt.Sprint(*d.New)
+			}
+			result = append(result, d.Key+":"+old+">"+updated)
+		}
+		return strings.Join(result, ",")
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"added memories", contents(diff.AddedMemories), "new"},
+		{"removed memories", contents(diff.RemovedMemories), "forgotten"},
+		{"changed memories", contents(diff.ChangedMemories), "retagged"},
+		{"values", deltas(diff.Values), "added:->3,changed:1>2,removed:1>-"},
+		{"traits", deltas(diff.Traits), "bold:->1"},
+		{"added goals", strings.Join(diff.AddedGoals, ","), "explore"},
+		{"removed goals", strings.Join(diff.RemovedGoals, ","), "rest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+
+	encoded, err := json.Marshal(diff)
+	if err != nil || !strings.Contains(string(encoded), `"added_goals":["explore"]`) {
+		t.Errorf("json.Marshal(diff) = %s, %v", encoded, err)
+	}
+}
+
+func TestSoul_AddMemories(t *testing.T) {
+	dir := t.TempDir()
+	db, err := kv.New(kv.Config{Path: dir})
+	if err != nil {
+		t.Fatalf("kv.New() error = %v", err)
+	}
+	defer db.Close()
+	store := NewStore(db, StoreConfig{FlushInterval: time.Hour, FlushBatch: 1 << 20})
+	s, err := store.Create("transcript")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	s.AddMemory(MemoryEntry{Content: "before"})
+
+	tests := []struct {
+		name    string
+		entries []MemoryEntry
+		policy  *ForgettingPolicy
+		wantErr error
+		want    int // Memories held after the call
+	}{
+		{"empty content", []MemoryEntry{{Content: "ok"}, {}}, nil, ErrInvalidMemory, 1},
+		{"bad importance", []MemoryEntry{{Content: "ok", Importance: math.Inf(1)}}, nil, ErrInvalidMemory, 1},
+		{"over quota", []MemoryEntry{{Content: "a"}, {Content: "b"}}, &ForgettingPolicy{MaxMemories: 2, Reject: true}, ErrQuotaExceeded, 1},
+		{"batch", []MemoryEntry{{Content: "a", Tags: []string{"t"}}, {Content: "b", Timestamp: 5}, {Content: "c", Tags: []string{"t"}}}, nil, nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy != nil {
+				s.SetForgetting(*tt.policy)
+				defer s.SetForgetting(ForgettingPolicy{})
+			}
+			if err := s.AddMemories(tt.entries); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddMemories() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := len(s.GetMemories(nil)); got != tt.want {
+				t.Errorf("soul holds %d memories, want %d", got, tt.want)
+			}
+		})
+	}
+
+	memories := s.GetMemories(nil)
+	if memories[1].ID != 2 || memories[3].ID != 4 || memories[1].Timestamp == 0 || memories[2].Timestamp != 5 {
+		t.Errorf("batch memories = %+v, want IDs 2 to 4 with timestamps", memories[1:])
+	}
+	if tagged := s.GetMemories([]string{"t"}); len(tagged) != 2 {
+		t.Errorf("tag index holds %d batch memories, want 2", len(tagged))
+	}
+	store.mu.Lock()
+	pending := len(store.pending)
+	store.mu.Unlock()
+	if pending != 4 {
+		t.Errorf("%d writes pending, want all 4 memories in one batch", pending)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got := len(store.Open("transcript").GetMemories(nil)); got != 4 {
+		t.Errorf("reopened soul holds %d memories, want 4", got)
+	}
+	store.Close()
+}
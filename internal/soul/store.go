@@ -0,0 +1,440 @@
+package soul
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/kv"
+)
+
+// Store defaults
+const (
+	DefaultFlushInterval = 100 * time.Millisecond
+	DefaultFlushBatch    = 256
+)
+
+// ErrInvalidID is returned for soul IDs that cannot key a soul's data
+var ErrInvalidID = errors.New("invalid soul id")
+
+// ValidateID checks that id can key a soul in a store. A soul's data lives
+// under "soul/<id>/", so an ID may not be empty or contain a slash;
+// otherwise one soul's prefix would cover another's data.
+func ValidateID(id string) error {
+	if id == "" || strings.Contains(id, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+// StoreConfig configures write-behind batching
+type StoreConfig struct {
+	FlushInterval time.Duration // Longest a write waits before it is flushed; 0 uses DefaultFlushInterval
+	FlushBatch    int           // Pending writes that trigger an early flush; 0 uses DefaultFlushBatch
+
+	// Forgetting, when set, is the policy of every soul the store opens
+	Forgetting *ForgettingPolicy
+
+	// Importance, when set, scores the new memories of every soul the
+	// store opens
+	Importance *ImportanceScoring
+
+	// MasterKey, when set, is a 32-byte key that wraps a data key per
+	// soul, which encrypts the soul's data at rest
+	MasterKey []byte
+}
+
+// Store persists souls to the KV store under per-soul key prefixes.
+// Writes are buffered and flushed in batches in the background, so a crash
+// loses at most the last FlushInterval of changes; Flush and Close write
+// everything pending.
+type Store struct {
+	kv  *kv.Store
+	cfg StoreConfig
+
+	// master wraps soul data keys; masterErr is set for an invalid key
+	master    cipher.AEAD
+	masterErr error
+
+	// pending maps keys to their latest value; nil deletes the key
+	pending map[string][]byte
+	mu      sync.Mutex
+	flushMu sync.Mutex
+
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewStore creates a soul store and starts its flusher
+func NewStore(store *kv.Store, cfg StoreConfig) *Store {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	if cfg.FlushBatch <= 0 {
+		cfg.FlushBatch = DefaultFlushBatch
+	}
+	s := &Store{
+		kv:      store,
+		cfg:     cfg,
+		pending: make(map[string][]byte),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if len(cfg.MasterKey) > 0 {
+		if len(cfg.MasterKey) != dataKeySize {
+			s.masterErr = fmt.Errorf("soul master key must be %d bytes", dataKeySize)
+		} else {
+			s.master, s.masterErr = newAEAD(cfg.MasterKey)
+		}
+	}
+	go s.flusher()
+	return s
+}
+
+// Open returns the soul with the given ID. Its memories, values, and
+// persona are loaded from the store when first accessed, and changes to
+// them are persisted. Loading a soul whose ID fails ValidateID sets its
+// Err.
+func (s *Store) Open(id string) *Soul {
+	soul := New(id)
+	soul.store = s
+	if s.cfg.Forgetting != nil {
+		policy := s.cfg.Forgetting.withDefaults()
+		soul.forgetting = &policy
+	}
+	if s.cfg.Importance != nil {
+		scoring := s.cfg.Importance.withDefaults()
+		soul.scoring = &scoring
+	}
+	return soul
+}
+
+// Create records a new soul in the store's index and returns it
+func (s *Store) Create(id string) (*Soul, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	if err := s.kv.Put(indexKey(id), []byte{1}); err != nil {
+		return nil, fmt.Errorf("failed to create soul %s: %w", id, err)
+	}
+	return s.Open(id), nil
+}
+
+// List returns the IDs of the souls created in the store, in order
+func (s *Store) List() ([]string, error) {
+	prefix := []byte(indexPrefix)
+	iter, err := s.kv.Scan(prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var ids []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		ids = append(ids, string(iter.Key()[len(prefix):]))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list souls: %w", err)
+	}
+	return ids, nil
+}
+
+// Delete removes a soul's persisted data and its index entry
+func (s *Store) Delete(id string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+
+	// Hold off flushes so none rewrites the soul after it is deleted
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	prefix := soulPrefix(id)
+	s.mu.Lock()
+	for key := range s.pending {
+		if strings.HasPrefix(key, string(prefix)) {
+			delete(s.pending, key)
+		}
+	}
+	s.mu.Unlock()
+
+	batch := s.kv.NewBatch()
+	defer batch.Close()
+	if err := batch.DeleteRange(prefix, prefixEnd(prefix)); err != nil {
+		return fmt.Errorf("failed to delete soul %s: %w", id, err)
+	}
+	if err := batch.Delete(indexKey(id)); err != nil {
+		return fmt.Errorf("failed to delete soul %s: %w", id, err)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to delete soul %s: %w", id, err)
+	}
+	return nil
+}
+
+// Flush writes all pending changes
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string][]byte)
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	batch := s.kv.NewBatch()
+	defer batch.Close()
+	for key, value := range pending {
+		var err error
+		if value == nil {
+			err = batch.Delete([]byte(key))
+		} else {
+			err = batch.Set([]byte(key), value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write soul data: %w", err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		// Keep the writes so the next flush retries them, unless newer
+		// values replaced them meanwhile
+		s.mu.Lock()
+		for key, value := range pending {
+			if _, newer := s.pending[key]; !newer {
+				s.pending[key] = value
+			}
+		}
+		s.mu.Unlock()
+		return fmt.Errorf("failed to commit soul data: %w", err)
+	}
+	return nil
+}
+
+// Close stops the flusher and writes all pending changes
+func (s *Store) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	<-s.stopped
+	return s.Flush()
+}
+
+// flusher flushes pending writes periodically or when enough accumulate
+func (s *Store) flusher() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		if err := s.Flush(); err != nil {
+			fmt.Printf("Warning: failed to flush souls: %v\n", err)
+		}
+	}
+}
+
+// stage buffers a write; a nil value deletes the key
+func (s *Store) stage(key []byte, value []byte) {
+	s.stageAll(map[string][]byte{string(key): value})
+}
+
+// stageAll buffers writes together, so they are flushed in the same batch
+func (s *Store) stageAll(writes map[string][]byte) {
+	s.mu.Lock()
+	for key, value := range writes {
+		s.pending[key] = value
+	}
+	full := len(s.pending) >= s.cfg.FlushBatch
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// storedSoul is a soul's persisted data
+type storedSoul struct {
+	aead     cipher.AEAD // The soul's data key, when the store encrypts
+	memories []MemoryEntry
+	values   map[string]float64
+	persona  *Persona
+	schema   *PersonaSchema
+	history  []Change
+	series   map[string]map[uint64]ValuePoint // By value key and sequence
+}
+
+// load reads a soul's persisted data, including writes not yet flushed
+func (s *Store) load(id string) (*storedSoul, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	prefix := soulPrefix(id)
+	data := make(map[string][]byte)
+
+	iter, err := s.kv.Scan(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for valid := iter.First(); valid; valid = iter.Next() {
+		data[string(iter.Key())] = append([]byte(nil), iter.Value()...)
+	}
+	if err := iter.Err(); err != nil {
+		iter.Close()
+		return nil, fmt.Errorf("failed to load soul %s: %w", id, err)
+	}
+	iter.Close()
+
+	s.mu.Lock()
+	for key, value := range s.pending {
+		if strings.HasPrefix(key, string(prefix)) {
+			if value == nil {
+				delete(data, key)
+			} else {
+				data[key] = value
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	aead, err := s.soulCipher(id, data)
+	if err != nil {
+		return nil, err
+	}
+
+	memPrefix, valPrefix, personaKey := memoryPrefix(id), string(valueKey(id, "")), string(personaKeyOf(id))
+	histPrefix, schemaKey := historyPrefix(id), string(personaSchemaKey(id))
+	serPrefix := seriesPrefix(id)
+	stored := &storedSoul{aead: aead, values: make(map[string]float64), series: make(map[string]map[uint64]ValuePoint)}
+	for key, value := range data {
+		if key == string(dataKeyKey(id)) {
+			continue
+		}
+		if value, err = unseal(aead, []byte(key), value); err != nil {
+			return nil, fmt.Errorf("failed to decrypt soul %s: %w", id, err)
+		}
+		switch {
+		case strings.HasPrefix(key, memPrefix):
+			var entry MemoryEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return nil, fmt.Errorf("failed to decode memory of soul %s: %w", id, err)
+			}
+			stored.memories = append(stored.memories, entry)
+		case strings.HasPrefix(key, valPrefix):
+			var v float64
+			if err := json.Unmarshal(value, &v); err != nil {
+				return nil, fmt.Errorf("failed to decode value of soul %s: %w", id, err)
+			}
+			stored.values[key[len(valPrefix):]] = v
+		case key == personaKey:
+			stored.persona = &Persona{}
+			if err := json.Unmarshal(value, stored.persona); err != nil {
+				return nil, fmt.Errorf("failed to decode persona of soul %s: %w", id, err)
+			}
+		case key == schemaKey:
+			stored.schema = &PersonaSchema{}
+			if err := json.Unmarshal(value, stored.schema); err != nil {
+				return nil, fmt.Errorf("failed to decode persona schema of soul %s: %w", id, err)
+			}
+		case strings.HasPrefix(key, serPrefix) && len(key) >= len(serPrefix)+9:
+			var p ValuePoint
+			if err := json.Unmarshal(value, &p); err != nil {
+				return nil, fmt.Errorf("failed to decode value series of soul %s: %w", id, err)
+			}
+			name := key[len(serPrefix) : len(key)-9]
+			if stored.series[name] == nil {
+				stored.series[name] = make(map[uint64]ValuePoint)
+			}
+			stored.series[name][binary.BigEndian.Uint64([]byte(key[len(key)-8:]))] = p
+		case strings.HasPrefix(key, histPrefix):
+			var change Change
+			if err := json.Unmarshal(value, &change); err != nil {
+				return nil, fmt.Errorf("failed to decode history of soul %s: %w", id, err)
+			}
+			stored.history = append(stored.history, change)
+		}
+	}
+	sortMemories(stored.memories)
+	sort.Slice(stored.history, func(i, j int) bool {
+		return stored.history[i].Version < stored.history[j].Version
+	})
+	return stored, nil
+}
+
+// indexPrefix prefixes the keys listing created souls
+const indexPrefix = "souls/"
+
+// indexKey returns the index key of a soul
+func indexKey(id string) []byte {
+	return []byte(indexPrefix + id)
+}
+
+// soulPrefix returns the key prefix of a soul's data
+func soulPrefix(id string) []byte {
+	return []byte("soul/" + id + "/")
+}
+
+// memoryPrefix returns the key prefix of a soul's memories
+func memoryPrefix(id string) string {
+	return "soul/" + id + "/memory/"
+}
+
+// memoryKey returns the key of a soul's memory
+func memoryKey(id string, memoryID uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(memoryPrefix(id)), memoryID)
+}
+
+// valueKey returns the key of a soul's value
+func valueKey(id, key string) []byte {
+	return []byte("soul/" + id + "/values/" + key)
+}
+
+// historyPrefix returns the key prefix of a soul's history
+func historyPrefix(id string) string {
+	return "soul/" + id + "/history/"
+}
+
+// historyKey returns the key of a change in a soul's history
+func historyKey(id string, version uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(historyPrefix(id)), version)
+}
+
+// personaKeyOf returns the key of a soul's persona
+func personaKeyOf(id string) []byte {
+	return []byte("soul/" + id + "/persona")
+}
+
+// personaSchemaKey returns the key of a soul's persona schema
+func personaSchemaKey(id string) []byte {
+	return []byte("soul/" + id + "/persona_schema")
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
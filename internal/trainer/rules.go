@@ -0,0 +1,112 @@
+package trainer
+
+import (
+	"fmt"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+)
+
+// Update rule kinds of a RuleConfig
+const (
+	RuleValue = "value"
+	RuleTrait = "trait"
+)
+
+// UpdateRule adjusts a soul from a reward
+type UpdateRule interface {
+	Update(s *soul.Soul, r Reward) error
+}
+
+// UpdateFunc adapts a function to an UpdateRule
+type UpdateFunc func(s *soul.Soul, r Reward) error
+
+// Update calls f
+func (f UpdateFunc) Update(s *soul.Soul, r Reward) error {
+	return f(s, r)
+}
+
+// RuleConfig configures a built-in update rule
+type RuleConfig struct {
+	Kind      string   `yaml:"kind"`      // value or trait
+	Objective string   `yaml:"objective"` // Empty applies the rule to every objective
+	Key       string   `yaml:"key"`       // Value key or trait name
+	Rate      float64  `yaml:"rate"`
+	Min       *float64 `yaml:"min"`
+	Max       *float64 `yaml:"max"`
+}
+
+// Rule returns the update rule the config describes
+func (c RuleConfig) Rule() (UpdateRule, error) {
+	if c.Rate == 0 {
+		return nil, fmt.Errorf("rule needs a non-zero rate")
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return nil, fmt.Errorf("rule min %v is above max %v", *c.Min, *c.Max)
+	}
+	switch c.Kind {
+	case RuleValue:
+		return ValueRule{Key: c.Key, Rate: c.Rate, Min: c.Min, Max: c.Max}, nil
+	case RuleTrait:
+		if c.Key == "" {
+			return nil, fmt.Errorf("trait rule needs a key")
+		}
+		return TraitRule{Trait: c.Key, Rate: c.Rate, Min: c.Min, Max: c.Max}, nil
+	default:
+		return nil, fmt.Errorf("unknown rule kind %q", c.Kind)
+	}
+}
+
+// ValueRule adds Rate times the reward to a soul value, starting from 0.
+// An empty Key uses the reward's objective name.
+type ValueRule struct {
+	Key      string
+	Rate     float64
+	Min, Max *float64 // Optional bounds the value is clamped to
+}
+
+// Update applies the rule
+func (r ValueRule) Update(s *soul.Soul, reward Reward) error {
+	key := r.Key
+	if key == "" {
+		key = reward.Objective
+	}
+	current, _ := s.GetValue(key)
+	s.SetValueAs(actor(reward), key, clamp(current+r.Rate*reward.Value, r.Min, r.Max))
+	return nil
+}
+
+// TraitRule adds Rate times the reward to a persona trait, starting from 0
+type TraitRule struct {
+	Trait    string
+	Rate     float64
+	Min, Max *float64 // Optional bounds the trait is clamped to
+}
+
+// Update applies the rule. It fails when the changed persona breaks the
+// soul's persona schema.
+func (r TraitRule) Update(s *soul.Soul, reward Reward) error {
+	persona := s.GetPersona()
+	traits := make(map[string]float64, len(persona.Traits)+1)
+	for trait, v := range persona.Traits {
+		traits[trait] = v
+	}
+	traits[r.Trait] = clamp(traits[r.Trait]+r.Rate*reward.Value, r.Min, r.Max)
+	persona.Traits = traits
+	return s.UpdatePersonaAs(actor(reward), persona)
+}
+
+// actor is the history author of a reward's updates
+func actor(r Reward) string {
+	return "trainer:" + r.Objective
+}
+
+// clamp bounds v by the optional lo and hi
+func clamp(v float64, lo, hi *float64) float64 {
+	if lo != nil && v < *lo {
+		return *lo
+	}
+	if hi != nil && v > *hi {
+		return *hi
+	}
+	return v
+}
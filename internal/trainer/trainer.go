@@ -0,0 +1,234 @@
+package trainer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+// Config configures training. Objectives score matrix events and rules turn
+// the scores into soul updates.
+type Config struct {
+	Objectives []Objective  `yaml:"objectives"`
+	Rules      []RuleConfig `yaml:"rules"`
+}
+
+// Objective rewards the agent behind matrix events of a type. The reward is
+// Weight, times the numeric Field of the event's data when Field is set.
+type Objective struct {
+	Name   string  `yaml:"name"`
+	Event  string  `yaml:"event"` // Matrix event type; empty matches every event
+	Field  string  `yaml:"field"`
+	Weight float64 `yaml:"weight"`
+}
+
+// Reward is an objective's score of one matrix event for an agent
+type Reward struct {
+	Objective string
+	AgentID   string
+	MatrixID  string
+	Step      uint64
+	Value     float64
+}
+
+// SoulLookup returns the soul bound to an agent
+type SoulLookup func(agentID string) (*soul.Soul, bool)
+
+// Trainer updates the souls bound to agents from the rewards their matrix
+// events earn
+type Trainer struct {
+	objectives []Objective
+	rules      []scopedRule
+	lookup     SoulLookup
+	bus        *transport.EventBus
+	mu         sync.RWMutex
+}
+
+// scopedRule is an update rule and the objective it applies to; an empty
+// objective applies it to every reward
+type scopedRule struct {
+	objective string
+	rule      UpdateRule
+}
+
+// New creates a trainer. bus carries the matrix events it trains on and the
+// EventTypeTrainer events it publishes for applied rewards.
+func New(cfg Config, lookup SoulLookup, bus *transport.EventBus) (*Trainer, error) {
+	names := make(map[string]bool, len(cfg.Objectives))
+	for _, o := range cfg.Objectives {
+		if o.Name == "" {
+			return nil, fmt.Errorf("trainer objective needs a name")
+		}
+		if names[o.Name] {
+			return nil, fmt.Errorf("duplicate trainer objective %s", o.Name)
+		}
+		names[o.Name] = true
+	}
+
+	t := &Trainer{
+		objectives: append([]Objective(nil), cfg.Objectives...),
+		lookup:     lookup,
+		bus:        bus,
+	}
+	for i, rc := range cfg.Rules {
+		if rc.Objective != "" && !names[rc.Objective] {
+			return nil, fmt.Errorf("trainer rule %d: unknown objective %s", i, rc.Objective)
+		}
+		rule, err := rc.Rule()
+		if err != nil {
+			return nil, fmt.Errorf("trainer rule %d: %w", i, err)
+		}
+		t.rules = append(t.rules, scopedRule{objective: rc.Objective, rule: rule})
+	}
+	return t, nil
+}
+
+// AddRule adds an update rule for an objective's rewards, or for every
+// reward when objective is empty
+func (t *Trainer) AddRule(objective string, rule UpdateRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = append(t.rules, scopedRule{objective: objective, rule: rule})
+}
+
+// Run trains on matrix events until ctx ends
+func (t *Trainer) Run(ctx context.Context) {
+	events := t.bus.Subscribe(ctx, transport.EventTypeMatrix)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			t.Handle(e)
+		}
+	}
+}
+
+// Handle scores a matrix event and applies the rewards to the soul bound
+// to the event's agent. It returns the rewards applied.
+func (t *Trainer) Handle(e transport.Event) []Reward {
+	rewards := t.Score(e)
+	if len(rewards) == 0 {
+		return nil
+	}
+	s, ok := t.lookup(rewards[0].AgentID)
+	if !ok {
+		return nil
+	}
+
+	t.mu.RLock()
+	rules := t.rules
+	t.mu.RUnlock()
+
+	applied := rewards[:0]
+	for _, r := range rewards {
+		var errs []error
+		for _, sr := range rules {
+			if sr.objective != "" && sr.objective != r.Objective {
+				continue
+			}
+			if err := sr.rule.Update(s, r); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			fmt.Printf("Warning: failed to train soul %s on %s: %v\n", s.ID, r.Objective, errs)
+			continue
+		}
+		applied = append(applied, r)
+		t.publish(s.ID, r)
+	}
+	return applied
+}
+
+// Score returns the rewards the objectives give a matrix event. Events
+// without an agent earn none.
+func (t *Trainer) Score(e transport.Event) []Reward {
+	if e.Type != transport.EventTypeMatrix {
+		return nil
+	}
+	agentID, _ := e.Data["agent_id"].(string)
+	if agentID == "" {
+		return nil
+	}
+	eventType, _ := e.Data["event"].(string)
+	data, _ := e.Data["data"].(map[string]interface{})
+	step, _ := e.Data["step"].(uint64)
+
+	var rewards []Reward
+	for _, o := range t.objectives {
+		if o.Event != "" && o.Event != eventType {
+			continue
+		}
+		value := o.Weight
+		if o.Field != "" {
+			v, ok := toFloat(data[o.Field])
+			if !ok {
+				continue
+			}
+			value *= v
+		}
+		if value == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		rewards = append(rewards, Reward{
+			Objective: o.Name,
+			AgentID:   agentID,
+			MatrixID:  e.Source,
+			Step:      step,
+			Value:     value,
+		})
+	}
+	return rewards
+}
+
+// publish announces an applied reward
+func (t *Trainer) publish(soulID string, r Reward) {
+	if t.bus == nil {
+		return
+	}
+	t.bus.Publish(transport.Event{
+		Type:      transport.EventTypeTrainer,
+		Source:    soulID,
+		Timestamp: time.Now().UnixNano(),
+		Data: map[string]interface{}{
+			"event":     "reward",
+			"soul_id":   soulID,
+			"agent_id":  r.AgentID,
+			"matrix_id": r.MatrixID,
+			"step":      r.Step,
+			"objective": r.Objective,
+			"reward":    r.Value,
+		},
+	})
+}
+
+// toFloat converts numeric event data to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
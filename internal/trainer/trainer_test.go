@@ -0,0 +1,133 @@
+package trainer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecirlabs/matrix-core/internal/soul"
+	"github.com/ecirlabs/matrix-core/internal/transport"
+)
+
+func matrixEvent(agentID, eventType string, data map[string]interface{}) transport.Event {
+	return transport.Event{
+		Type:   transport.EventTypeMatrix,
+		Source: "m1",
+		Data: map[string]interface{}{
+			"matrix_id": "m1",
+			"event":     eventType,
+			"agent_id":  agentID,
+			"step":      uint64(3),
+			"data":      data,
+		},
+	}
+}
+
+func TestTrainer(t *testing.T) {
+	one := 1.0
+	cfg := Config{
+		Objectives: []Objective{
+			{Name: "forage", Event: "eat", Field: "amount", Weight: 2},
+			{Name: "survive", Event: "hurt", Weight: -1},
+		},
+		Rules: []RuleConfig{
+			{Kind: RuleValue, Rate: 1},
+			{Kind: RuleTrait, Objective: "forage", Key: "bold", Rate: 0.25, Max: &one},
+		},
+	}
+	s := soul.New("s1")
+	lookup := func(agentID string) (*soul.Soul, bool) {
+		return s, agentID == "a1"
+	}
+	bus := transport.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trained := bus.Subscribe(ctx, transport.EventTypeTrainer)
+
+	tr, err := New(cfg, lookup, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		event   transport.Event
+		rewards int
+		values  map[string]float64
+		bold    float64
+	}{
+		{"scored by field", matrixEvent("a1", "eat", map[string]interface{}{"amount": 1.5}), 1, map[string]float64{"forage": 3}, 0.75},
+		{"int field", matrixEvent("a1", "eat", map[string]interface{}{"amount": 1}), 1, map[string]float64{"forage": 5}, 1},
+		{"missing field", matrixEvent("a1", "eat", nil), 0, map[string]float64{"forage": 5}, 1},
+		{"negative reward", matrixEvent("a1", "hurt", nil), 1, map[string]float64{"forage": 5, "survive": -1}, 1},
+		{"unbound agent", matrixEvent("a2", "eat", map[string]interface{}{"amount": 1}), 0, map[string]float64{"forage": 5}, 1},
+		{"other event", matrixEvent("a1", "move", nil), 0, map[string]float64{"forage": 5}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tr.Handle(tt.event); len(got) != tt.rewards {
+				t.Errorf("Handle() applied %d rewards, want %d", len(got), tt.rewards)
+			}
+			for key, want := range tt.values {
+				if got, _ := s.GetValue(key); got != want {
+					t.Errorf("value %s = %v, want %v", key, got, want)
+				}
+			}
+			if got := s.GetPersona().Traits["bold"]; got != tt.bold {
+				t.Errorf("trait bold = %v, want %v", got, tt.bold)
+			}
+		})
+	}
+
+	history := s.GetHistory()
+	if last := history[len(history)-1]; last.Actor != "trainer:survive" {
+		t.Errorf("last change by %q, want trainer:survive", last.Actor)
+	}
+	select {
+	case e := <-trained:
+		if e.Data["soul_id"] != "s1" || e.Data["objective"] != "forage" || e.Data["reward"] != 3.0 {
+			t.Errorf("trainer event = %v", e.Data)
+		}
+	default:
+		t.Error("no trainer event published")
+	}
+
+	// Rules can be plugged in and run through Run
+	calls := make(chan Reward, 1)
+	tr.AddRule("survive", UpdateFunc(func(s *soul.Soul, r Reward) error {
+		calls <- r
+		return nil
+	}))
+	go tr.Run(ctx)
+	time.Sleep(10 * time.Millisecond) // Let Run subscribe
+	bus.Publish(matrixEvent("a1", "hurt", nil))
+	select {
+	case r := <-calls:
+		if r.AgentID != "a1" || r.MatrixID != "m1" || r.Step != 3 || r.Value != -1 {
+			t.Errorf("custom rule got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("custom rule not called")
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unnamed objective", Config{Objectives: []Objective{{Weight: 1}}}},
+		{"duplicate objective", Config{Objectives: []Objective{{Name: "a"}, {Name: "a"}}}},
+		{"unknown objective", Config{Rules: []RuleConfig{{Kind: RuleValue, Objective: "a", Rate: 1}}}},
+		{"unknown kind", Config{Rules: []RuleConfig{{Kind: "gradient", Rate: 1}}}},
+		{"zero rate", Config{Rules: []RuleConfig{{Kind: RuleValue}}}},
+		{"trait without key", Config{Rules: []RuleConfig{{Kind: RuleTrait, Rate: 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg, nil, nil); err == nil {
+				t.Error("New() succeeded")
+			}
+		})
+	}
+}
@@ -53,20 +53,14 @@ func (t *Transport) Subscribe(ctx context.Context, topic string) (<-chan Message
 	defer t.topicMu.Unlock()
 
 	// Join topic if not already joined
-	tp, exists := t.topics[topic]
-	if !exists {
-		var err error
-		tp, err = t.pubsub.Join(topic)
-		if err != nil {
-			return nil, fmt.Errorf("failed to join topic %s: %w", topic, err)
-		}
-		t.topics[topic] = tp
+	tp, err := t.joinLocked(topic)
+	if err != nil {
+		return nil, err
 	}
 
 	// Subscribe if not already subscribed
 	sub, exists := t.subs[topic]
 	if !exists {
-		var err error
 		sub, err = tp.Subscribe()
 		if err != nil {
 			return nil, fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
@@ -104,19 +98,40 @@ func (t *Transport) Subscribe(ctx context.Context, topic string) (<-chan Message
 	return ch, nil
 }
 
-// Publish sends a message to a topic
+// Publish sends a message to a topic, joining it first if necessary
 func (t *Transport) Publish(ctx context.Context, topic string, data []byte) error {
 	t.topicMu.RLock()
 	tp, exists := t.topics[topic]
 	t.topicMu.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("not subscribed to topic %s", topic)
+		t.topicMu.Lock()
+		var err error
+		tp, err = t.joinLocked(topic)
+		t.topicMu.Unlock()
+		if err != nil {
+			return err
+		}
 	}
 
 	return tp.Publish(ctx, data)
 }
 
+// joinLocked returns the joined topic handle, joining it if needed.
+// Callers must hold topicMu for writing.
+func (t *Transport) joinLocked(topic string) (*pubsub.Topic, error) {
+	if tp, exists := t.topics[topic]; exists {
+		return tp, nil
+	}
+
+	tp, err := t.pubsub.Join(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join topic %s: %w", topic, err)
+	}
+	t.topics[topic] = tp
+	return tp, nil
+}
+
 // Close shuts down the transport
 func (t *Transport) Close() error {
 	t.topicMu.Lock()